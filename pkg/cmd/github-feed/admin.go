@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	adminAddr = flag.String("admin", "",
+		"Address of the admin endpoint exposing /debug/pprof/, /debug/snapshots, /debug/logs, /debug/responses and /metrics, secured like -serve.")
+	profileDir = flag.String("profile-dir", "",
+		"Directory where CPU profiles are written whenever the CPU load exceeds -profile-cpu-threshold.")
+	profileThreshold = flag.Float64("profile-cpu-threshold", 0.8,
+		"Fraction of the available CPUs above which a CPU profile is recorded.")
+)
+
+// startAdmin returns the admin mux so that other subsystems can register
+// their handlers, or nil when the admin endpoint is disabled.
+func startAdmin(ctx context.Context) *http.ServeMux {
+	if *adminAddr == "" && *profileDir == "" {
+		return nil
+	}
+
+	profiler := lib.NewProfiler(*profileDir, *profileThreshold)
+	go profiler.Serve(ctx)
+
+	if *adminAddr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	profiler.RegisterHandlers(mux)
+
+	conf := serveConfig()
+	conf.Addr = *adminAddr
+	go func() { fail(exitFeed, conf.ListenAndServe(ctx, mux)) }()
+
+	return mux
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var annotationsState = flag.String("annotations", "",
+	"State file of the event annotations managed on /annotations of the -admin endpoint, e.g. by reviewers.")
+
+// startAnnotations exposes the annotations on the admin endpoint, if both
+// are enabled.
+func startAnnotations(admin *http.ServeMux) error {
+	if admin == nil || *annotationsState == "" {
+		return nil
+	}
+
+	store, err := lib.NewAnnotationStore(*annotationsState)
+	if err != nil {
+		return err
+	}
+
+	admin.Handle("/annotations", store)
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+var archiveStores = stringList(flag.CommandLine, "archive", "",
+	"Comma separated list of object stores (file:///dir, s3://bucket/prefix?region=...) where "+
+		"batches are archived, each object is mirrored to every store.")
+
+var archiveSpool = flag.String("archive-spool", "archive-spool",
+	"Directory where objects which failed to upload to a mirror are spooled until reconciled.")
+
+var archiveDictionary = flag.Bool("archive-dictionary", false,
+	"Dictionary-encode the actors and repositories repeated within each archived batch.")
+
+var (
+	sourceArchive = flag.String("source-archive", "",
+		"Object store (file:///dir, s3://bucket/prefix?region=...) whose archived events are replayed "+
+			"through the pipeline instead of polling GitHub.")
+	sourceFrom  = flag.String("source-from", "", "Start (RFC 3339) of the range replayed from -source-archive.")
+	sourceTo    = flag.String("source-to", "", "End (RFC 3339, excluded) of the range replayed from -source-archive, defaults to now.")
+	sourceSpeed = flag.Float64("source-speed", 0,
+		"Replay -source-archive at this multiple of the archived pace, e.g. 60 replays an hour in a minute, 0 is as fast as possible.")
+)
+
+const archiveReconcileInterval = time.Minute
+
+// The name of the archive in the dead letter queue.
+const archiveSinkName = "archive"
+
+// newArchiveSink returns nil when archiving is disabled.
+func newArchiveSink(ctx context.Context) (lib.Sink, error) {
+	if *archiveStores == "" {
+		return nil, nil
+	}
+
+	var stores []lib.ObjectStore
+	for _, u := range strings.Split(*archiveStores, ",") {
+		store, err := lib.OpenObjectStore(u)
+		if err != nil {
+			return nil, err
+		}
+		allowEgress(store)
+		stores = append(stores, store)
+	}
+
+	records, err := recordSerializer()
+	if err != nil {
+		return nil, err
+	}
+	if *archiveDictionary {
+		records = lib.DictionaryEncoded(records)
+	}
+
+	if len(stores) == 1 {
+		sink := lib.NewArchiveSink(stores[0])
+		sink.Serializer = records
+		return sink, nil
+	}
+
+	mirror, err := lib.NewMirrorStore(*archiveSpool, stores...)
+	if err != nil {
+		return nil, err
+	}
+
+	go mirror.ServeReconcile(ctx, archiveReconcileInterval)
+
+	sink := lib.NewArchiveSink(mirror)
+	sink.Serializer = records
+	return sink, nil
+}
+
+// startArchiveSource replays the events archived in -source-archive, the
+// channel is closed at the end of the range.
+func startArchiveSource(ctx context.Context) (<-chan []*github.Event, error) {
+	store, err := openReadableStore(*sourceArchive)
+	if err != nil {
+		return nil, err
+	}
+
+	if *sourceFrom == "" {
+		return nil, fmt.Errorf("-source-archive requires -source-from")
+	}
+	from, to, err := parseRange(*sourceFrom, *sourceTo)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, events, err := lib.NewArchiveRangeFeed(store, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	feed.Speed = *sourceSpeed
+
+	sources.Add(1)
+	go func() {
+		defer sources.Done()
+		if err := feed.Serve(ctx); err != nil {
+			fail(exitFeed, fmt.Errorf("failed replaying the archive: %w", err))
+			return
+		}
+		log.Print("Archive replay complete")
+	}()
+
+	return events, nil
+}
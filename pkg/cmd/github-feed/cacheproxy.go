@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var cacheProxyAddr = flag.String("cache-proxy", "",
+	"Address on which the events endpoints of the GitHub API are proxied through the feed's cache, e.g. 127.0.0.1:8081, "+
+		"for sibling instances polling with -api-url http://127.0.0.1:8081/,https://api.github.com/.")
+
+// startCacheProxy serves the feed's client to the sibling instances, without
+// authentication: bind it to a local address. The proxy refuses the endpoints
+// listing the private events of the token's user.
+func startCacheProxy(ctx context.Context, feed *lib.EventFeed) {
+	if *cacheProxyAddr == "" {
+		return
+	}
+
+	conf := &lib.ServeConfig{Addr: *cacheProxyAddr}
+	go func() { fail(exitFeed, conf.ListenAndServe(ctx, lib.NewCacheProxy(feed))) }()
+}
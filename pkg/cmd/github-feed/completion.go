@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/loadgen"
+)
+
+// commands lists the subcommands with their flags, in the order they are
+// completed.
+var commands = []struct {
+	name  string
+	flags *flag.FlagSet
+}{
+	{"feed", flag.CommandLine},
+	{"serve", flag.CommandLine},
+	{"loadgen", loadgen.Flags},
+	{"replay", replayFlags},
+	{"redrive", flag.CommandLine},
+	{"verify", verifyFlags},
+	{"diff", diffFlags},
+	{"doctor", flag.CommandLine},
+	{"query", queryFlags},
+	{"test-filter", testFilterFlags},
+	{"fsck", fsckFlags},
+	{"migrate", migrateFlags},
+	{"completion", flag.NewFlagSet("completion", flag.ExitOnError)},
+	{"install-service", serviceFlags},
+	{"uninstall-service", serviceFlags},
+	{"version", versionFlags},
+	{"config-schema", configSchemaFlags},
+	{"support-bundle", flag.CommandLine},
+}
+
+var completionShells = map[string]func(io.Writer){
+	"bash": bashCompletion,
+	"zsh":  zshCompletion,
+	"fish": fishCompletion,
+}
+
+// completion prints the completion script of a shell, e.g.
+//
+//	source <(github-feed completion bash)
+func completion(args []string) int {
+	if len(args) != 1 || completionShells[args[0]] == nil {
+		fmt.Fprintf(os.Stderr, "usage: github-feed completion bash|zsh|fish\n")
+		return 2
+	}
+
+	completionShells[args[0]](os.Stdout)
+	return 0
+}
+
+func commandNames() string {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, " ")
+}
+
+type completionFlag struct {
+	name    string
+	usage   string
+	boolean bool
+}
+
+func completionFlags(fs *flag.FlagSet) []completionFlag {
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{
+			name:    f.Name,
+			usage:   firstSentence(f.Usage),
+			boolean: ok && b.IsBoolFlag(),
+		})
+	})
+
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+func firstSentence(s string) string {
+	for i := 0; i < len(s)-1; i++ {
+		if s[i] == '.' && s[i+1] == ' ' && !strings.HasSuffix(s[:i], "e.g") {
+			return s[:i]
+		}
+	}
+	return strings.TrimSuffix(s, ".")
+}
+
+func bashCompletion(w io.Writer) {
+	fmt.Fprintf(w, `_github_feed() {
+	local cur=${COMP_WORDS[COMP_CWORD]} cmd=feed
+	if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+	fi
+	[[ ${COMP_WORDS[1]} != -* ]] && cmd=${COMP_WORDS[1]}
+	[[ $cur == -* ]] || return
+	case $cmd in
+`, commandNames())
+
+	for _, c := range commands {
+		var names []string
+		for _, f := range completionFlags(c.flags) {
+			names = append(names, "-"+f.name)
+		}
+		fmt.Fprintf(w, "\t%s) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", c.name, strings.Join(names, " "))
+	}
+
+	fmt.Fprintf(w, "\tesac\n}\ncomplete -o default -F _github_feed github-feed\n")
+}
+
+var zshEscaper = strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+
+func zshCompletion(w io.Writer) {
+	fmt.Fprintf(w, `#compdef github-feed
+
+_github_feed() {
+	local cmd=feed
+	if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then
+		compadd %s
+		return
+	fi
+	[[ $words[2] != -* ]] && cmd=$words[2]
+	case $cmd in
+`, commandNames())
+
+	for _, c := range commands {
+		fmt.Fprintf(w, "\t%s)\n\t\t_arguments \\\n", c.name)
+		for _, f := range completionFlags(c.flags) {
+			spec := fmt.Sprintf("-%s[%s]", f.name, zshEscaper.Replace(f.usage))
+			if !f.boolean {
+				spec += ":" + f.name + ":"
+			}
+			fmt.Fprintf(w, "\t\t\t'%s' \\\n", spec)
+		}
+		fmt.Fprintf(w, "\t\t\t'*:file:_files'\n\t\t;;\n")
+	}
+
+	fmt.Fprintf(w, "\tesac\n}\n\ncompdef _github_feed github-feed\n")
+}
+
+func fishCompletion(w io.Writer) {
+	names := commandNames()
+	fmt.Fprintf(w, "complete -c github-feed -n '__fish_use_subcommand' -f -a '%s'\n", names)
+
+	for _, c := range commands {
+		cond := fmt.Sprintf("__fish_seen_subcommand_from %s", c.name)
+		if c.name == "feed" {
+			// Flags of the default command are also completed without it.
+			cond = fmt.Sprintf("not __fish_seen_subcommand_from %s; or __fish_seen_subcommand_from feed",
+				strings.TrimPrefix(names, "feed "))
+		}
+
+		for _, f := range completionFlags(c.flags) {
+			opts := ""
+			if !f.boolean {
+				opts = " -r"
+			}
+			fmt.Fprintf(w, "complete -c github-feed -n '%s' -o %s%s -d '%s'\n",
+				cond, f.name, opts, strings.Replace(f.usage, "'", "\\'", -1))
+		}
+	}
+}
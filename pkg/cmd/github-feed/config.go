@@ -0,0 +1,384 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// configFlags registers the flags configuring the GitHub event feed on fs,
+// shared by the subcommands consuming the feed, and returns the function
+// building the configuration once fs is parsed along with -print-config.
+func configFlags(fs *flag.FlagSet) (func() *lib.Config, *bool) {
+	printConfig := fs.Bool("print-config", false,
+		"Print the effective configuration as JSON and exit, secrets are redacted.")
+	fs.String("config", "",
+		"YAML file setting the flags left unset by the command line and the "+flagEnvPrefix+"* environment, keyed by flag name.")
+	apiURL := fs.String("api-url", "",
+		"Base URL of the GitHub API, e.g. of a GitHub Enterprise instance, or comma separated base URLs failed over in order.")
+	apiUploadURL := fs.String("api-upload-url", "",
+		"Upload URL of a GitHub Enterprise Server instance, e.g. https://ghe.example.com/, selecting its API paths "+
+			"(api/v3/ is appended to -api-url).")
+	apiCACerts := &caCerts{}
+	fs.Var(apiCACerts, "api-ca-cert",
+		"PEM file of a CA trusted for the GitHub API on top of the system's, e.g. the internal CA of GitHub Enterprise (repeatable).")
+	apiProxy := fs.String("api-proxy", "",
+		"Proxy of the GitHub API requests (http, https or socks5 URL), default HTTPS_PROXY.")
+	apiVersion := fs.String("api-version", "", "GitHub API version requested with X-GitHub-Api-Version.")
+	apiPreviews := stringList(fs, "api-previews", "", "Comma separated list of GitHub API previews to opt into.")
+	userAgent := fs.String("user-agent", "",
+		"User-Agent of the requests to GitHub, the object stores and GH Archive (default the command's name).")
+	requestTag := fs.String("request-tag", "",
+		"Tag sent in the X-Feed-Tag header of the same requests, e.g. the deployment name for egress attribution.")
+	sources := &eventSources{}
+	fs.Var(sources, "sources",
+		"Comma separated events endpoints polled concurrently: public, repo:owner/name, org:name or user:name (default public).")
+	onlyTypes := stringList(fs, "only-types", "", "Comma separated event types published by the feed, e.g. PushEvent,PullRequestEvent.")
+	onlyActors := stringList(fs, "only-actors", "", "Comma separated glob patterns (* and ?) of the actors whose events are published.")
+	excludeActors := stringList(fs, "exclude-actors", "", "Comma separated glob patterns of the actors whose events are dropped, e.g. '*[bot]'.")
+	onlyRepos := stringList(fs, "only-repos", "", "Comma separated glob patterns of the repositories whose events are published, e.g. 'golang/*'.")
+	excludeRepos := stringList(fs, "exclude-repos", "", "Comma separated glob patterns of the repositories whose events are dropped.")
+	onlyOrgs := stringList(fs, "only-orgs", "", "Comma separated glob patterns of the organizations whose events are published.")
+	excludeOrgs := stringList(fs, "exclude-orgs", "", "Comma separated glob patterns of the organizations whose events are dropped.")
+	onlyMatching := fs.String("only-matching", "",
+		"Filter expression (see -filter) the events published by the feed match, e.g. 'action=opened label=bug'.")
+	queueSize := fs.Int("feed-queue", 16, "Number of polled batches buffered before the -feed-backpressure policy applies.")
+	backpressure := fs.String("feed-backpressure", lib.BackpressureBlock,
+		"What polling does once -feed-queue batches wait for processing: block, drop-oldest, drop-newest or spill to -feed-spill-dir.")
+	spillDir := fs.String("feed-spill-dir", "",
+		"Directory of the batches spilled by -feed-backpressure spill, published first on restart.")
+	stateDir := fs.String("state-dir", "",
+		"Directory of the feed's checkpoint, the newest event published and the ETag of each source, from which a restarted feed resumes.")
+	catchUpAfter := fs.Duration("catch-up-after", 0,
+		"Poll every -catch-up-interval after resuming from a -state-dir checkpoint older than d, until the feed caught up (default disabled).")
+	catchUpInterval := fs.Duration("catch-up-interval", 5*time.Second, "Interval between the polls catching up, see -catch-up-after.")
+	catchUpFromArchive := fs.Bool("catch-up-from-archive", false,
+		"Replay from -gharchive-url the events created since the checkpoint and out of reach of the events API, see -catch-up-after.")
+	maxBatchEvents := fs.Int("feed-max-batch", 0,
+		"Split the polled batches in batches of at most n events, bounding the events queued (default unbounded).")
+	maxEventAge := fs.Duration("feed-max-age", 0,
+		"Drop the events created longer than d ago instead of publishing them, e.g. the backlog after a downtime (default unlimited).")
+	lagThreshold := fs.Int("feed-lag-threshold", 0,
+		"Slow the polls down once more than n batches wait for processing, doubling the interval for every further n (default disabled).")
+	lagMaxInterval := fs.Duration("feed-lag-max-interval", 5*time.Minute, "Maximum interval between the polls slowed down by -feed-lag-threshold.")
+	lagShedTypes := stringList(fs, "feed-lag-shed", "",
+		"Comma separated event types dropped while -feed-lag-threshold slows the polls down, e.g. WatchEvent,ForkEvent.")
+	errorsQueueSize := fs.Int("errors-queue", 64, "Number of errors buffered before they are dropped.")
+	requestTimeout := fs.Duration("request-timeout", 10*time.Second, "Timeout of the GitHub API requests.")
+	requestReports := fs.Duration("request-report-interval", 0,
+		"Interval at which the GitHub API requests sent per token and subsystem (poller, enrichers, discovery) are logged (default disabled).")
+	pollMinInterval := fs.Duration("poll-min-interval", 0,
+		"Minimum interval between the polls of a source, raising the interval requested by GitHub (X-Poll-Interval).")
+	pollMaxPages := fs.Int("poll-max-pages", 10, "Pages of events requested per poll, at most 10.")
+	pollMaxFailures := fs.Int("poll-max-failures", 5,
+		"Consecutive failed polls of a source (connection errors, 5xx) before the feed gives up, negative retries forever.")
+	pollRetryBackoff := fs.Duration("poll-retry-backoff", time.Second,
+		"Initial backoff of the retries of failed polls, doubled on every consecutive failure, with jitter.")
+	pollMaxBackoff := fs.Duration("poll-max-backoff", 5*time.Minute, "Maximum backoff of the retries of failed polls.")
+	chaosPollInterval := fs.Float64("chaos-poll-interval", 0,
+		"Fraction of the events API responses whose X-Poll-Interval is replaced by a random one, reporting the earlier polls (testing only, -experimental chaos).")
+	chaosNotModified := fs.Float64("chaos-not-modified", 0,
+		"Fraction of the revalidations of the events API responses answered 304 Not Modified (testing only, -experimental chaos).")
+	httpCacheBytes := byteSize(32 << 20)
+	fs.Var(&httpCacheBytes, "http-cache", "Size of the cache of GitHub API responses, e.g. 64M.")
+	httpCacheBackend := &httpCacheURL{}
+	fs.Var(httpCacheBackend, "http-cache-url",
+		"Backend of the cache of GitHub API responses surviving restarts: a directory, file:///dir?max-size=1G or "+
+			"redis://:password@host:6379/0?ttl=24h (default memory).")
+	var bandwidthLimit byteSize
+	fs.Var(&bandwidthLimit, "bandwidth-limit",
+		"Bandwidth cap of the GitHub API traffic in bytes per second on the wire, e.g. 256K (default unlimited).")
+	webhookAddr := fs.String("webhook", "",
+		"Address on which GitHub webhook deliveries are received instead of polling the events API, e.g. :8080, "+
+			"signed with the secret in "+webhookSecretEnv+".")
+	var ghArchiveFrom, ghArchiveTo rfc3339Time
+	fs.Var(&ghArchiveFrom, "gharchive-from",
+		"Start (RFC 3339) of the range of GH Archive replayed instead of polling the events API, e.g. to load test with a real day.")
+	fs.Var(&ghArchiveTo, "gharchive-to", "End (RFC 3339, excluded) of the range of GH Archive replayed, defaults to now.")
+	ghArchiveSpeed := fs.Float64("gharchive-speed", 0,
+		"Replay GH Archive at this multiple of the pace the events were created at, e.g. 1 in real time, 0 is as fast as possible.")
+	ghArchiveURL := fs.String("gharchive-url", lib.GHArchiveURL, "Base URL of the GH Archive hourly dumps.")
+	metricsAddr := fs.String("metrics-addr", "",
+		"Address on which the feed's metrics (/metrics) and Kubernetes probes (/healthz, /readyz) are served, e.g. :9090.")
+	logLevel := fs.String("log-level", lib.LogInfo, "Verbosity of the logs: debug (every page polled), info, warn or error.")
+	logFormat := fs.String("log-format", lib.LogText, "Format of the logs: text, or json for a JSON object per line.")
+	fs.Var(&redactPatterns{}, "redact",
+		"Regular expression whose matches are redacted from logs and errors, on top of the known secrets (repeatable).")
+
+	return func() *lib.Config {
+		conf := &lib.Config{
+			AuthToken:  os.Getenv("GITHUB_AUTH_TOKEN"),
+			AuthTokens: splitList(os.Getenv(authTokensEnv)),
+			UploadURL:  *apiUploadURL,
+			TLSConfig:  apiCACerts.conf,
+			ProxyURL:   *apiProxy,
+			APIVersion: *apiVersion,
+			UserAgent:  *userAgent,
+			RequestTag: *requestTag,
+
+			WebhookAddr:   *webhookAddr,
+			WebhookSecret: os.Getenv(webhookSecretEnv),
+			MetricsAddr:   *metricsAddr,
+
+			GHArchiveFrom:  ghArchiveFrom.t,
+			GHArchiveTo:    ghArchiveTo.t,
+			GHArchiveURL:   *ghArchiveURL,
+			GHArchiveSpeed: *ghArchiveSpeed,
+
+			QueueSize:       *queueSize,
+			Backpressure:    *backpressure,
+			SpillDir:        *spillDir,
+			StateDir:        *stateDir,
+			MaxBatchEvents:  *maxBatchEvents,
+			MaxEventAge:     *maxEventAge,
+			LagThreshold:    *lagThreshold,
+			ErrorsQueueSize: *errorsQueueSize,
+			RequestTimeout:  *requestTimeout,
+			MinPollInterval: *pollMinInterval,
+			MaxPages:        *pollMaxPages,
+			HTTPCacheBytes:  int(httpCacheBytes),
+			Cache:           httpCacheBackend.backend,
+			MaxBandwidth:    int64(bandwidthLimit),
+
+			MaxLagPollInterval:    *lagMaxInterval,
+			LagShedFilter:         lib.EventFilter{Types: splitList(*lagShedTypes)},
+			RequestReportInterval: *requestReports,
+
+			CatchUpAfter:       *catchUpAfter,
+			CatchUpInterval:    *catchUpInterval,
+			CatchUpFromArchive: *catchUpFromArchive,
+
+			Chaos: lib.ChaosConfig{
+				PollIntervalRate: *chaosPollInterval,
+				NotModifiedRate:  *chaosNotModified,
+			},
+
+			MaxConsecutiveFailures: *pollMaxFailures,
+			RetryBackoff:           *pollRetryBackoff,
+			MaxRetryBackoff:        *pollMaxBackoff,
+
+			Sources: sources.sources,
+
+			LogLevel:  *logLevel,
+			LogFormat: *logFormat,
+
+			Filter: lib.EventFilter{
+				Types:         splitList(*onlyTypes),
+				Actors:        splitList(*onlyActors),
+				ExcludeActors: splitList(*excludeActors),
+				Repos:         splitList(*onlyRepos),
+				ExcludeRepos:  splitList(*excludeRepos),
+				Orgs:          splitList(*onlyOrgs),
+				ExcludeOrgs:   splitList(*excludeOrgs),
+				Expression:    *onlyMatching,
+			},
+		}
+
+		if *apiURL != "" {
+			urls := strings.Split(*apiURL, ",")
+			conf.BaseURL, conf.FallbackURLs = urls[0], urls[1:]
+		}
+
+		if *apiPreviews != "" {
+			conf.Previews = strings.Split(*apiPreviews, ",")
+		}
+
+		return conf
+	}, printConfig
+}
+
+// checkpointPath returns the checkpoint file of -state-dir, none without.
+func checkpointPath() string {
+	if dir := feedConfig().StateDir; dir != "" {
+		return filepath.Join(dir, lib.CheckpointFile)
+	}
+	return ""
+}
+
+// splitList splits a comma separated flag, nil if empty.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// redactPatterns registers the -redact patterns as they are parsed.
+type redactPatterns struct {
+	patterns []string
+}
+
+func (r *redactPatterns) String() string {
+	return strings.Join(r.patterns, ",")
+}
+
+func (r *redactPatterns) Set(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	lib.RegisterRedactPattern(re)
+	r.patterns = append(r.patterns, pattern)
+	return nil
+}
+
+func (r *redactPatterns) Repeatable() bool { return true }
+
+// httpCacheURL opens the -http-cache-url backend as it is parsed.
+type httpCacheURL struct {
+	url     string
+	backend lib.HTTPCacheBackend
+}
+
+func (c *httpCacheURL) String() string {
+	return lib.Redact(c.url)
+}
+
+func (c *httpCacheURL) Set(rawurl string) error {
+	backend, err := lib.OpenHTTPCache(rawurl)
+	if err != nil {
+		return err
+	}
+	allowEgress(backend)
+	c.url, c.backend = rawurl, backend
+	return nil
+}
+
+// rfc3339Time parses a time flag, zero if unset.
+type rfc3339Time struct {
+	t time.Time
+}
+
+func (r *rfc3339Time) String() string {
+	if r.t.IsZero() {
+		return ""
+	}
+	return r.t.Format(time.RFC3339)
+}
+
+func (r *rfc3339Time) Set(v string) error {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return err
+	}
+	r.t = t
+	return nil
+}
+
+// caCerts loads the -api-ca-cert files as they are parsed.
+type caCerts struct {
+	paths []string
+	conf  *tls.Config
+}
+
+func (c *caCerts) String() string {
+	return strings.Join(c.paths, ",")
+}
+
+func (c *caCerts) Set(path string) error {
+	conf, err := lib.LoadCACerts(append(c.paths, path)...)
+	if err != nil {
+		return err
+	}
+	c.paths, c.conf = append(c.paths, path), conf
+	return nil
+}
+
+func (c *caCerts) Repeatable() bool { return true }
+
+// eventSources parses the -sources list.
+type eventSources struct {
+	sources []lib.Source
+}
+
+func (e *eventSources) String() string {
+	names := make([]string, 0, len(e.sources))
+	for _, source := range e.sources {
+		names = append(names, source.String())
+	}
+	return strings.Join(names, ",")
+}
+
+func (e *eventSources) Set(v string) error {
+	var sources []lib.Source
+	for _, s := range strings.Split(v, ",") {
+		source, err := lib.ParseSource(s)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source)
+	}
+
+	e.sources = sources
+	return nil
+}
+
+func (e *eventSources) List() bool { return true }
+
+// The environment variables holding secrets, only whether they are set is
+// printed.
+var secretEnv = []string{"GITHUB_AUTH_TOKEN", authTokensEnv, serveTokensEnv, webhookSecretEnv}
+
+const (
+	webhookSecretEnv = "GITHUB_WEBHOOK_SECRET"
+	// Comma separated tokens pooled with GITHUB_AUTH_TOKEN.
+	authTokensEnv = "GITHUB_AUTH_TOKENS"
+)
+
+type configValue struct {
+	Value string `json:"value"`
+	// Either "flag", "env", "file" or "default".
+	Source string `json:"source"`
+}
+
+type effectiveConfig struct {
+	Command string                 `json:"command"`
+	Flags   map[string]configValue `json:"flags"`
+	Env     map[string]bool        `json:"env"`
+}
+
+// printConfig writes the effective configuration of the command, i.e. every
+// flag with its resolved value and where it comes from, as JSON on stdout.
+func printConfig(cmd string, fs *flag.FlagSet) int {
+	if err := writeConfig(os.Stdout, cmd, fs); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// writeConfig writes the effective configuration of the command to w.
+func writeConfig(w io.Writer, cmd string, fs *flag.FlagSet) error {
+	conf := effectiveConfig{
+		Command: cmd,
+		Flags:   make(map[string]configValue),
+		Env:     make(map[string]bool),
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		conf.Flags[f.Name] = configValue{Value: f.Value.String(), Source: "default"}
+	})
+	fs.Visit(func(f *flag.Flag) {
+		source := "flag"
+		if s, ok := flagSources[fs][f.Name]; ok {
+			source = s
+		}
+		conf.Flags[f.Name] = configValue{Value: f.Value.String(), Source: source}
+	})
+
+	for _, name := range secretEnv {
+		conf.Env[name] = os.Getenv(name) != ""
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(conf)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Prefix of the environment variables overriding the flags, e.g.
+// GITHUB_FEED_FEED_QUEUE sets -feed-queue.
+const flagEnvPrefix = "GITHUB_FEED_"
+
+// flagSources records the flags set by the -config file or the environment,
+// printed by -print-config.
+var flagSources = make(map[*flag.FlagSet]map[string]string)
+
+// flagEnv returns the environment variable overriding a flag.
+func flagEnv(name string) string {
+	return flagEnvPrefix + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// parseFlags parses the command line, then sets the flags it left unset from
+// the environment (see flagEnv) and the -config file, in this order of
+// precedence, e.g.
+//
+//	feed-queue: 64
+//	sources: [public, org:golang]
+//	api-ca-cert: [/etc/ssl/ghe.pem]
+//
+// The keys of the file are the names of the flags. Lists are joined with
+// commas, or set one item at a time for the repeatable flags.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	sources := make(map[string]string)
+	flagSources[fs] = sources
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(flagEnv(f.Name))
+		if set[f.Name] || !ok {
+			return
+		}
+		if err := fs.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", flagEnv(f.Name), err))
+			return
+		}
+		set[f.Name], sources[f.Name] = true, "env"
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid environment: %s", strings.Join(errs, "; "))
+	}
+
+	path := ""
+	if f := fs.Lookup("config"); f != nil {
+		path = f.Value.String()
+	}
+	if path == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var values yaml.MapSlice
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	for _, kv := range values {
+		name := fmt.Sprint(kv.Key)
+		f := fs.Lookup(name)
+		if f == nil {
+			errs = append(errs, fmt.Sprintf("unknown flag '%s'", name))
+			continue
+		}
+		if name == "config" {
+			errs = append(errs, "config: nested configuration files are not supported")
+			continue
+		}
+		if set[name] {
+			continue
+		}
+
+		if err := setFlagValue(fs, f, kv.Value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		sources[name] = "file"
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %s", path, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// setFlagValue sets a flag from a value of the -config file.
+func setFlagValue(fs *flag.FlagSet, f *flag.Flag, value interface{}) error {
+	items, ok := value.([]interface{})
+	if !ok {
+		if _, ok := value.(map[interface{}]interface{}); ok {
+			return fmt.Errorf("expected a value or a list, got a mapping")
+		}
+		if value == nil {
+			return fs.Set(f.Name, "")
+		}
+		return fs.Set(f.Name, fmt.Sprint(value))
+	}
+
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = fmt.Sprint(item)
+	}
+	if !isRepeatable(f) {
+		return fs.Set(f.Name, strings.Join(values, ","))
+	}
+	for _, v := range values {
+		if err := fs.Set(f.Name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// repeatableFlag is a flag set once per item, e.g. -api-ca-cert a.pem
+// -api-ca-cert b.pem.
+type repeatableFlag interface {
+	Repeatable() bool
+}
+
+// listFlag is a flag set to a comma separated list of items, see stringList.
+type listFlag interface {
+	List() bool
+}
+
+func isRepeatable(f *flag.Flag) bool {
+	r, ok := f.Value.(repeatableFlag)
+	return ok && r.Repeatable()
+}
+
+func isList(f *flag.Flag) bool {
+	l, ok := f.Value.(listFlag)
+	return ok && l.List()
+}
+
+// commaList is a string flag holding a comma separated list.
+type commaList string
+
+func (l *commaList) String() string { return string(*l) }
+
+func (l *commaList) Set(v string) error {
+	*l = commaList(v)
+	return nil
+}
+
+func (l *commaList) List() bool { return true }
+
+// stringList defines a string flag holding a comma separated list, the
+// -config files also give it as an array, e.g. only-types: [PushEvent].
+func stringList(fs *flag.FlagSet, name, value, usage string) *string {
+	p := &value
+	fs.Var((*commaList)(p), name, usage)
+	return p
+}
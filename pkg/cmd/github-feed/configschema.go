@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/fsaintjacques/github-feed/pkg/loadgen"
+)
+
+var (
+	configSchemaFlags   = flag.NewFlagSet("config-schema", flag.ExitOnError)
+	configSchemaCommand = configSchemaFlags.String("command", "feed",
+		"Command whose -config file is described: feed (also serve, redrive and doctor) or loadgen.")
+)
+
+// Durations as parsed by time.ParseDuration, e.g. 1h30m.
+const durationPattern = `^(0|[-+]?([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`
+
+// configSchema prints the JSON Schema (draft-07) of the -config file of a
+// command, generated from its flags, so that editors validate the files and
+// tools template them, e.g.
+//
+//	github-feed config-schema > github-feed.schema.json
+//
+// Every flag is a property typed after its value, with its usage as
+// description, its default, the environment variable overriding it and the
+// lib.Config field it sets if any. Unknown keys are errors, as they are for
+// parseFlags.
+func configSchema(args []string) int {
+	fs := configSchemaFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed config-schema [-command feed|loadgen]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var flags *flag.FlagSet
+	switch *configSchemaCommand {
+	case "feed", "serve", "redrive", "doctor":
+		flags = flag.CommandLine
+	case "loadgen":
+		flags = loadgen.Flags
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(flagsSchema(*configSchemaCommand, flags)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFeed
+	}
+	return exitOK
+}
+
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 interface{}            `json:"type,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Default              interface{}            `json:"default,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties *bool                  `json:"additionalProperties,omitempty"`
+	// The environment variable and lib.Config field set by the flag.
+	Env         string   `json:"x-env,omitempty"`
+	ConfigField []string `json:"x-config-field,omitempty"`
+}
+
+func flagsSchema(cmd string, fs *flag.FlagSet) *jsonSchema {
+	fields := make(map[string][]string)
+	for field, name := range configFlagNames {
+		fields[name] = append(fields[name], field)
+	}
+
+	closed := false
+	schema := &jsonSchema{
+		Schema:               "http://json-schema.org/draft-07/schema#",
+		Title:                "github-feed " + cmd + " configuration",
+		Description:          "The -config file of github-feed " + cmd + " " + version + ", keyed by flag name.",
+		Type:                 "object",
+		Properties:           make(map[string]*jsonSchema),
+		AdditionalProperties: &closed,
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			// Nested configuration files are not supported.
+			return
+		}
+		p := flagSchema(f)
+		p.Env = flagEnv(f.Name)
+		p.ConfigField = fields["-"+f.Name]
+		sort.Strings(p.ConfigField)
+		schema.Properties[f.Name] = p
+	})
+	return schema
+}
+
+// flagSchema types a flag after its value: booleans, integers, numbers,
+// durations, and strings otherwise. The list flags also take arrays, see
+// setFlagValue.
+func flagSchema(f *flag.Flag) *jsonSchema {
+	s := &jsonSchema{Description: f.Usage}
+
+	switch reflect.TypeOf(f.Value).String() {
+	case "*flag.intValue", "*flag.int64Value", "*flag.uintValue", "*flag.uint64Value":
+		s.Type = "integer"
+		s.Default, _ = strconv.ParseInt(f.DefValue, 10, 64)
+		return s
+	case "*flag.float64Value":
+		s.Type = "number"
+		s.Default, _ = strconv.ParseFloat(f.DefValue, 64)
+		return s
+	case "*flag.durationValue":
+		s.Type, s.Pattern = "string", durationPattern
+	default:
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			s.Type = "boolean"
+			s.Default, _ = strconv.ParseBool(f.DefValue)
+			return s
+		}
+		s.Type = "string"
+	}
+	if f.DefValue != "" {
+		s.Default = f.DefValue
+	}
+
+	if isRepeatable(f) || isList(f) {
+		item := &jsonSchema{Type: s.Type, Pattern: s.Pattern}
+		s.Type, s.Pattern = []string{"string", "array"}, ""
+		s.Items = item
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	coverageDelay = flag.Duration("coverage-delay", 2*time.Hour,
+		"Time after the end of an hour before the coverage job compares it with GH Archive, which publishes hours late.")
+)
+
+// newCoverageEstimator compares the first -archive store with GH Archive, it
+// returns nil when archiving is disabled.
+func newCoverageEstimator() (*lib.CoverageEstimator, error) {
+	if *archiveStores == "" {
+		return nil, nil
+	}
+
+	store, err := openReadableStore(strings.Split(*archiveStores, ",")[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return lib.NewCoverageEstimator(store, feedConfig().GHArchiveURL), nil
+}
+
+// estimateCoverage is the coverage housekeeping job, estimating the latest
+// hour published by GH Archive.
+func estimateCoverage(coverage *lib.CoverageEstimator) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if coverage == nil {
+			return errors.New("coverage requires -archive")
+		}
+
+		hour := time.Now().Add(-*coverageDelay).Truncate(time.Hour).Add(-time.Hour)
+		if last := coverage.Last(); last != nil && !last.Hour.Before(hour) {
+			return nil
+		}
+
+		report, err := coverage.Estimate(ctx, hour)
+		if err != nil {
+			return err
+		}
+		log.Printf("Captured %d of the %d events of GH Archive at %s (%.2f%%)",
+			report.Captured, report.Expected, report.Hour.Format(time.RFC3339), 100*report.Ratio())
+
+		return nil
+	}
+}
+
+// metricsHandler serves the event statistics, the feed's metrics, the cache
+// statistics, the space reclaimed by the retention job, the bandwidth of the
+// GitHub API traffic, the sink deliveries, the failed polls and, when
+// enabled, the coverage, the enrichment budget and the commit signatures.
+func metricsHandler(feed *lib.EventFeed, stats *lib.EventStats, coverage *lib.CoverageEstimator, enrichment *lib.EnrichmentScheduler,
+	transforms *lib.TransformChain, signatures *lib.SignatureChecker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeBuildInfo(w)
+		stats.WritePrometheus(w)
+		feed.WritePrometheus(w)
+		lib.WriteCacheMetrics(w)
+		lib.WriteRetentionMetrics(w)
+		lib.WriteBandwidthMetrics(w)
+		lib.WriteSinkMetrics(w)
+		transforms.WritePrometheus(w)
+		feed.Failures().WritePrometheus(w)
+		if coverage != nil {
+			coverage.WritePrometheus(w)
+		}
+		if enrichment != nil {
+			enrichment.WritePrometheus(w)
+		}
+		if signatures != nil {
+			signatures.WritePrometheus(w)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	deadLetters = flag.String("dead-letters", "",
+		"File where the events sinks failed to deliver after -sink-retries are queued, replayed with 'redrive'.")
+	sinkRetries = flag.Int("sink-retries", 3, "Number of retries, with exponential backoff, of a failed sink write.")
+)
+
+const redriveBatchSize = 100
+
+// deadLetterQueue is the queue opened by openDeadLetters, expired by the
+// retention housekeeping job.
+var deadLetterQueue *lib.DeadLetterQueue
+
+// openDeadLetters opens the -dead-letters queue once, shared by the sinks and
+// the transforms. It is nil without -dead-letters.
+func openDeadLetters() (*lib.DeadLetterQueue, error) {
+	if *deadLetters != "" && deadLetterQueue == nil {
+		queue, err := lib.OpenDeadLetterQueue(*deadLetters)
+		if err != nil {
+			return nil, err
+		}
+		deadLetterQueue = queue
+	}
+	return deadLetterQueue, nil
+}
+
+// withDeadLetters wraps a sink so that its failed writes are retried and
+// then queued as dead letters with -dead-letters, failed without.
+func withDeadLetters(name string, sink lib.Sink) (lib.Sink, error) {
+	if sink == nil {
+		return sink, nil
+	}
+
+	queue, err := openDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+
+	s := lib.NewDeadLetterSink(name, sink, queue)
+	s.Retries = *sinkRetries
+	return s, nil
+}
+
+// redrive replays the dead letters to the sinks configured with the same
+// flags as the feed, e.g.
+//
+//	github-feed redrive -dead-letters dlq.ndjson -archive s3://bucket/events
+//
+// The letters of the archive and of each -sink output are replayed to it. The
+// queue is refused while a feed has it open, see lib.DeadLetterQueue.
+func redrive(args []string) int {
+	if err := parseFlags(flag.CommandLine, args); err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	if *deadLetters == "" {
+		fmt.Fprintf(os.Stderr, "redrive requires -dead-letters\n")
+		return 2
+	}
+
+	if err := configureDialer(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	startEgressGuard()
+	conf := feedConfig()
+	lib.SetRequestIdentity(conf.UserAgent, conf.RequestTag)
+
+	ctx := context.Background()
+
+	queue, err := lib.OpenDeadLetterQueue(*deadLetters)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer queue.Close()
+
+	archive, err := newArchiveSink(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	records, err := recordSerializer()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	sinks := make(map[string]lib.Sink)
+	if archive != nil {
+		sinks[archiveSinkName] = archive
+	}
+	for _, u := range outputURLs() {
+		sink, err := lib.OpenSink(u, records)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer sink.Close()
+		allowEgress(sink)
+		sinks[lib.Redact(u)] = sink
+	}
+	if len(sinks) == 0 {
+		fmt.Fprintf(os.Stderr, "no sink configured\n")
+		return 2
+	}
+
+	names := make([]string, 0, len(sinks))
+	for name := range sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	code := 0
+	for _, name := range names {
+		n, err := queue.Redrive(ctx, name, sinks[name], redriveBatchSize)
+		fmt.Printf("redrove %d events to %s\n", n, name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+		}
+	}
+
+	return code
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	dedupCapacity = flag.Int("dedup", 10000, "Number of recent event IDs remembered to drop duplicated events.")
+	dedupState    = flag.String("dedup-state", "",
+		"State file persisting the remembered event IDs, so that a restarted process doesn't deliver events twice.")
+)
+
+func newDeduplicator(clock lib.Clock) (*lib.Deduplicator, error) {
+	return lib.NewDeduplicator(*dedupState, *dedupCapacity, clock)
+}
+
+// newPollDeduplicator returns the deduplicator of the feed, dropping the
+// events overlapping between its polls, in memory: a restarted feed resumes
+// from its -state-dir checkpoint instead.
+func newPollDeduplicator(clock lib.Clock) (*lib.Deduplicator, error) {
+	return lib.NewDeduplicator("", *dedupCapacity, clock)
+}
+
+// saveDeduplicator persists the IDs once their events were delivered.
+func saveDeduplicator(d *lib.Deduplicator) {
+	if err := d.Save(); err != nil {
+		log.Printf("Failed saving deduplication state: %v", err)
+	}
+}
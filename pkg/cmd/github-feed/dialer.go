@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/fsaintjacques/github-feed/pkg/loadgen"
+)
+
+var (
+	configureDialer        = dialerFlags(flag.CommandLine)
+	configureLoadgenDialer = dialerFlags(loadgen.Flags)
+)
+
+// dialerFlags registers the flags configuring how connections are
+// established on fs, and returns the function configuring the default
+// transport accordingly once fs is parsed. It must run before the egress
+// guard.
+func dialerFlags(fs *flag.FlagSet) func() error {
+	resolvers := stringList(fs, "dns-resolvers", "",
+		"Comma separated DNS servers (address or address:port) queried instead of the system's resolvers.")
+	family := fs.String("ip-family", "",
+		"Addresses dialed: ipv4 or ipv6 only, or prefer-ipv6 (default as resolved).")
+	hosts := &hostOverrides{}
+	fs.Var(hosts, "resolve",
+		"Static address of a host bypassing DNS, host=address, e.g. api.github.com=2001:db8::1 (repeatable).")
+
+	return func() error {
+		conf := lib.DialerConfig{Family: *family, Hosts: hosts.ips}
+		if *resolvers != "" {
+			conf.Resolvers = strings.Split(*resolvers, ",")
+		}
+		return lib.ConfigureDialer(http.DefaultTransport.(*http.Transport), conf)
+	}
+}
+
+// hostOverrides collects the repeated -resolve overrides.
+type hostOverrides struct {
+	overrides []string
+	ips       map[string][]net.IP
+}
+
+func (h *hostOverrides) String() string {
+	return strings.Join(h.overrides, ",")
+}
+
+func (h *hostOverrides) Set(v string) error {
+	host, ip, err := lib.ParseHostOverride(v)
+	if err != nil {
+		return err
+	}
+
+	if h.ips == nil {
+		h.ips = make(map[string][]net.IP)
+	}
+	h.ips[host] = append(h.ips[host], ip)
+	h.overrides = append(h.overrides, v)
+	return nil
+}
+
+func (h *hostOverrides) Repeatable() bool { return true }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	diffFlags = flag.NewFlagSet("diff", flag.ExitOnError)
+	diffFrom  = diffFlags.String("from", "", "Start (RFC 3339) of the compared range.")
+	diffTo    = diffFlags.String("to", "", "End (RFC 3339, excluded) of the compared range, defaults to now.")
+	diffBFrom = diffFlags.String("b-from", "", "Start of the range of the second archive, defaults to -from.")
+	diffBTo   = diffFlags.String("b-to", "", "End of the range of the second archive, defaults to -to.")
+	diffIDs   = diffFlags.Bool("ids", false, "List the missing and duplicated event IDs.")
+)
+
+// diff compares the event IDs archived in two ranges, of the same archive or
+// of archives written by different pollers, to measure the completeness of
+// the collection, e.g.
+//
+//	github-feed diff -from 2020-08-20T14:00:00Z -to 2020-08-20T15:00:00Z \
+//		file:///var/lib/github-feed/archive s3://bucket/archive?region=us-east-1
+//
+// It exits with status 1 when the archives differ.
+func diff(args []string) int {
+	fs := diffFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed diff -from time [-to time] [-b-from time] [-b-to time] [-ids] store-a store-b\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 || *diffFrom == "" {
+		fs.Usage()
+		return 2
+	}
+
+	from, to, err := parseRange(*diffFrom, *diffTo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	bFrom, bTo := from, to
+	if *diffBFrom != "" || *diffBTo != "" {
+		if *diffBFrom == "" {
+			*diffBFrom = *diffFrom
+		}
+		if *diffBTo == "" {
+			*diffBTo = *diffTo
+		}
+		if bFrom, bTo, err = parseRange(*diffBFrom, *diffBTo); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	a, err := archivedIDs(fs.Arg(0), from, to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	b, err := archivedIDs(fs.Arg(1), bFrom, bTo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(1), err)
+		return 1
+	}
+
+	missingA, missingB := missingIDs(b, a), missingIDs(a, b)
+	dupA, dupB := duplicatedIDs(a), duplicatedIDs(b)
+
+	for _, side := range []struct {
+		name          string
+		ids           map[string]int
+		missing, dups []string
+	}{
+		{"a", a, missingA, dupA},
+		{"b", b, missingB, dupB},
+	} {
+		total := 0
+		for _, n := range side.ids {
+			total += n
+		}
+		fmt.Printf("%s: %d events, %d unique, %d missing, %d duplicated\n",
+			side.name, total, len(side.ids), len(side.missing), len(side.dups))
+
+		if *diffIDs {
+			for _, id := range side.missing {
+				fmt.Printf("%s missing %s\n", side.name, id)
+			}
+			for _, id := range side.dups {
+				fmt.Printf("%s duplicated %s (%d times)\n", side.name, id, side.ids[id])
+			}
+		}
+	}
+
+	if union := len(a) + len(missingA); union > 0 {
+		fmt.Printf("completeness: a %.2f%%, b %.2f%%\n",
+			100*float64(len(a))/float64(union), 100*float64(len(b))/float64(union))
+	}
+
+	if len(missingA)+len(missingB)+len(dupA)+len(dupB) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func parseRange(from, to string) (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339, from)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	end := time.Now()
+	if to != "" {
+		if end, err = time.Parse(time.RFC3339, to); err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+	}
+
+	return start, end, nil
+}
+
+// openReadableStore opens a store URL or a local archive directory.
+func openReadableStore(location string) (lib.ReadableStore, error) {
+	if !strings.Contains(location, "://") {
+		// Unlike the file:// store, don't create a mistyped directory.
+		if _, err := os.Stat(location); err != nil {
+			return nil, err
+		}
+		return lib.NewDirStore(location)
+	}
+
+	store, err := lib.OpenObjectStore(location)
+	if err != nil {
+		return nil, err
+	}
+	allowEgress(store)
+	readable, ok := store.(lib.ReadableStore)
+	if !ok {
+		return nil, fmt.Errorf("store '%s' can't be read back", location)
+	}
+	return readable, nil
+}
+
+// archivedIDs counts the occurrences of the event IDs archived in the range.
+func archivedIDs(location string, from, to time.Time) (map[string]int, error) {
+	store, err := openReadableStore(location)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, events, err := lib.NewArchiveRangeFeed(store, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- feed.Serve(context.Background()) }()
+
+	ids := make(map[string]int)
+	for batch := range events {
+		for _, ev := range batch {
+			ids[ev.GetID()]++
+		}
+	}
+
+	return ids, <-errc
+}
+
+// missingIDs returns the sorted IDs of want absent from got.
+func missingIDs(want, got map[string]int) []string {
+	var missing []string
+	for id := range want {
+		if _, ok := got[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func duplicatedIDs(ids map[string]int) []string {
+	var dups []string
+	for id, n := range ids {
+		if n > 1 {
+			dups = append(dups, id)
+		}
+	}
+	sort.Strings(dups)
+	return dups
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+var (
+	discoverQueries  searchQueries
+	discoverInterval = flag.Duration("discover-interval", 0,
+		"Interval between the runs of the -discover queries and the -discover-org and -discover-topics listings (default 10m).")
+	discoverBudget = flag.Int("discover-budget", 0,
+		"Search API requests per hour spent by -discover (default 60).")
+	discoverOrg = flag.String("discover-org", "",
+		"Organization whose repositories are polled as repo sources, listed every -discover-interval to pick up the new ones.")
+	discoverInclude = stringList(flag.CommandLine, "discover-org-include", "",
+		"Comma separated glob patterns of the -discover-org repository names polled, every repository if empty.")
+	discoverExclude = stringList(flag.CommandLine, "discover-org-exclude", "",
+		"Comma separated glob patterns of the -discover-org repository names not polled, e.g. '*-archive,sandbox-*'.")
+	discoverTopics = stringList(flag.CommandLine, "discover-topics", "",
+		"Comma separated topics whose repositories are polled as repo sources, searched every -discover-interval, "+
+			"e.g. 'kubernetes-operator'.")
+)
+
+func init() {
+	flag.Var(&discoverQueries, "discover",
+		"Search API query whose new hits are emitted as DiscoveryEvents, kind:query with kind repos or code, "+
+			"e.g. 'repos:topic:kubernetes-operator' (repeatable).")
+}
+
+// searchQueries collects the repeated -discover queries.
+type searchQueries []lib.SearchQuery
+
+func (s *searchQueries) String() string {
+	var queries []string
+	for _, q := range *s {
+		queries = append(queries, q.String())
+	}
+	return strings.Join(queries, ",")
+}
+
+func (s *searchQueries) Set(v string) error {
+	q, err := lib.ParseSearchQuery(v)
+	if err != nil {
+		return err
+	}
+
+	*s = append(*s, q)
+	return nil
+}
+
+func (s *searchQueries) Repeatable() bool { return true }
+
+// startDiscovery runs the -discover queries with the feed's client, the
+// returned channel is nil without queries.
+func startDiscovery(ctx context.Context, feed *lib.EventFeed) <-chan []*github.Event {
+	if len(discoverQueries) == 0 {
+		return nil
+	}
+
+	seeder := lib.NewSearchSeeder(feed.Client(), discoverQueries)
+	seeder.Interval = *discoverInterval
+	seeder.Budget = *discoverBudget
+	seeder.ReportError = feed.ReportError
+
+	events := make(chan []*github.Event, 1)
+	go seeder.Serve(ctx, events)
+	return events
+}
+
+// repoDiscovery tells whether repositories are discovered, in which case
+// the source manager is set.
+func repoDiscovery() bool {
+	return *discoverOrg != "" || *discoverTopics != ""
+}
+
+// startRepoDiscovery keeps the feed polling the repositories of
+// -discover-org and -discover-topics through the source manager.
+func startRepoDiscovery(ctx context.Context, feed *lib.EventFeed, manager *lib.SourceManager) error {
+	var discoveries []*lib.RepoDiscovery
+	if *discoverOrg != "" {
+		discovery, err := lib.NewRepoDiscovery(feed.Client(), manager, *discoverOrg,
+			splitList(*discoverInclude), splitList(*discoverExclude))
+		if err != nil {
+			return err
+		}
+		discoveries = append(discoveries, discovery)
+	}
+	for _, topic := range splitList(*discoverTopics) {
+		discovery, err := lib.NewTopicDiscovery(feed.Client(), manager, strings.TrimSpace(topic))
+		if err != nil {
+			return err
+		}
+		discoveries = append(discoveries, discovery)
+	}
+
+	for _, discovery := range discoveries {
+		discovery.Interval = *discoverInterval
+		discovery.ReportError = feed.ReportError
+		go discovery.Serve(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	doctorTimeout = 20 * time.Second
+	// Beyond this, S3 rejects signed requests and timestamps are off.
+	doctorMaxClockSkew = time.Minute
+	// Overwritten at each run, so that probes don't accumulate.
+	doctorProbeKey = ".doctor/probe"
+)
+
+// diagnosis is the outcome of a check, with a hint at the fix when it failed.
+type diagnosis struct {
+	ok     bool
+	check  string
+	detail string
+	hint   string
+}
+
+// doctor validates the environment of the feed configured by the same flags,
+// e.g.
+//
+//	github-feed doctor -archive s3://bucket/events -dedup-state /var/lib/github-feed/dedup
+//
+// It exits with status 1 when a check fails.
+func doctor(args []string) int {
+	if err := parseFlags(flag.CommandLine, args); err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	if err := configureDialer(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	conf := feedConfig()
+	lib.SetRequestIdentity(conf.UserAgent, conf.RequestTag)
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	var diagnoses []diagnosis
+	diagnoses = append(diagnoses, checkGitHub(ctx)...)
+	diagnoses = append(diagnoses, checkStores(ctx)...)
+	diagnoses = append(diagnoses, checkStateDirs()...)
+
+	status := 0
+	for _, d := range diagnoses {
+		if d.ok {
+			fmt.Printf("ok    %s: %s\n", d.check, d.detail)
+			continue
+		}
+
+		status = 1
+		fmt.Printf("FAIL  %s: %s\n", d.check, d.detail)
+		if d.hint != "" {
+			fmt.Printf("      %s\n", d.hint)
+		}
+	}
+
+	return status
+}
+
+// checkGitHub checks the token, the reachability of the events API and the
+// clock against GitHub's.
+func checkGitHub(ctx context.Context) []diagnosis {
+	conf := feedConfig()
+
+	var diagnoses []diagnosis
+	if conf.AuthToken == "" && len(conf.AuthTokens) == 0 {
+		diagnoses = append(diagnoses, diagnosis{check: "token", detail: "GITHUB_AUTH_TOKEN is not set",
+			hint: "Unauthenticated clients are limited to 60 requests per hour, set GITHUB_AUTH_TOKEN to a personal access token."})
+	}
+
+	// The checkpoint is checked with the state files, not loaded.
+	conf.StateDir = ""
+	feed, _, err := lib.NewEventFeed(ctx, conf)
+	if err != nil {
+		return append(diagnoses, diagnosis{check: "api", detail: err.Error(), hint: "Check -api-url."})
+	}
+
+	_, rep, err := feed.Client().Activity.ListEvents(ctx, &github.ListOptions{PerPage: 1})
+	if err != nil {
+		hint := "Check the network access to " + feed.Client().BaseURL.Host + ", and -api-url."
+		if rep != nil && rep.StatusCode == http.StatusUnauthorized {
+			hint = "The token was rejected, it may be revoked or expired."
+		}
+		return append(diagnoses, diagnosis{check: "api", detail: err.Error(), hint: hint})
+	}
+
+	diagnoses = append(diagnoses, diagnosis{ok: true, check: "api",
+		detail: fmt.Sprintf("events API reachable at %s, %d/%d requests left", feed.Client().BaseURL.Host, rep.Rate.Remaining, rep.Rate.Limit)})
+
+	if conf.AuthToken != "" || len(conf.AuthTokens) > 0 {
+		// Fine-grained tokens and GitHub Apps don't report scopes.
+		scopes := rep.Header.Get("X-OAuth-Scopes")
+		if scopes == "" {
+			scopes = "none"
+		}
+		d := diagnosis{ok: true, check: "token", detail: "scopes: " + scopes}
+		if rep.Rate.Limit <= 60 {
+			d = diagnosis{check: "token", detail: fmt.Sprintf("rate limit of %d requests per hour", rep.Rate.Limit),
+				hint: "The token isn't applied, check that GITHUB_AUTH_TOKEN holds a valid token."}
+		}
+		diagnoses = append(diagnoses, d)
+	}
+
+	if date, err := http.ParseTime(rep.Header.Get("Date")); err == nil {
+		skew := time.Since(date)
+		if skew < 0 {
+			skew = -skew
+		}
+		d := diagnosis{ok: true, check: "clock", detail: fmt.Sprintf("%v off GitHub's clock", skew.Round(time.Second))}
+		if skew > doctorMaxClockSkew {
+			d.ok = false
+			d.hint = "Synchronize the clock (e.g. with NTP), S3 rejects requests signed with a skewed clock."
+		}
+		diagnoses = append(diagnoses, d)
+	}
+
+	return diagnoses
+}
+
+// checkStores writes a probe object to each archive store.
+func checkStores(ctx context.Context) []diagnosis {
+	if *archiveStores == "" {
+		return nil
+	}
+
+	var diagnoses []diagnosis
+	for _, u := range strings.Split(*archiveStores, ",") {
+		check := "archive " + u
+		store, err := lib.OpenObjectStore(u)
+		if err != nil {
+			diagnoses = append(diagnoses, diagnosis{check: check, detail: err.Error(),
+				hint: "Check the store URL, and AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY for s3:// stores."})
+			continue
+		}
+
+		probe := []byte(fmt.Sprintf("github-feed doctor probe, %s\n", time.Now().UTC().Format(time.RFC3339)))
+		if err := store.Put(ctx, doctorProbeKey, probe); err != nil {
+			diagnoses = append(diagnoses, diagnosis{check: check, detail: err.Error(),
+				hint: "Check the network access to the store and that the credentials may write to it."})
+			continue
+		}
+		diagnoses = append(diagnoses, diagnosis{ok: true, check: check, detail: "writable (probe written to " + doctorProbeKey + ")"})
+	}
+
+	return diagnoses
+}
+
+// checkStateDirs checks that the directories of the state files, spools
+// and local archives exist (or can be created) and are writable.
+func checkStateDirs() []diagnosis {
+	dirs := map[string]string{}
+	states := map[string]string{
+		"state-dir":              checkpointPath(),
+		"dedup-state":            *dedupState,
+		"dead-letters":           *deadLetters,
+		"annotations":            *annotationsState,
+		"new-contributors-state": *newContributorsState,
+		"repo-snapshots":         *repoSnapshots,
+	}
+	for flagName, path := range states {
+		if path != "" {
+			dirs[filepath.Dir(path)] = "-" + flagName
+		}
+	}
+	if *profileDir != "" {
+		dirs[*profileDir] = "-profile-dir"
+	}
+	if strings.Contains(*archiveStores, ",") {
+		dirs[*archiveSpool] = "-archive-spool"
+	}
+	for _, dir := range archiveDirs() {
+		dirs[dir] = "-archive"
+	}
+
+	paths := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		paths = append(paths, dir)
+	}
+	sort.Strings(paths)
+
+	var diagnoses []diagnosis
+	for _, dir := range paths {
+		flagName := dirs[dir]
+		check := fmt.Sprintf("directory %s (%s)", dir, flagName)
+		if err := checkWritable(dir); err != nil {
+			diagnoses = append(diagnoses, diagnosis{check: check, detail: err.Error(),
+				hint: fmt.Sprintf("Create the directory and give the user running the feed (uid %d) write access.", os.Getuid())})
+			continue
+		}
+		diagnoses = append(diagnoses, diagnosis{ok: true, check: check, detail: "writable"})
+	}
+
+	return diagnoses
+}
+
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".doctor-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	return os.Remove(f.Name())
+}
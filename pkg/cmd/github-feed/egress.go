@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	egressGuard = flag.Bool("egress-guard", false,
+		"Refuse outbound connections to hosts other than the GitHub API, GH Archive, those of the sinks, archive stores and HTTP cache, and -egress-allow.")
+	egressAllow = stringList(flag.CommandLine, "egress-allow", "",
+		"Comma separated hosts (or *.domain suffixes) allowed on top of the configured endpoints by -egress-guard.")
+)
+
+// egressAllowlist lists the hosts allowed by -egress-guard. The sinks,
+// stores and caches add theirs as they are opened, see allowEgress.
+var egressAllowlist = lib.NewEgressAllowlist()
+
+// allowEgress allows the hosts dialed by a sink, store or cache, see
+// lib.EgressEndpoint.
+func allowEgress(endpoint interface{}) {
+	egressAllowlist.Allow(lib.EgressHosts(endpoint)...)
+}
+
+// egressHosts returns the hosts of the GitHub API and GH Archive.
+func egressHosts() []string {
+	hosts := []string{"api.github.com"}
+	conf := feedConfig()
+	for _, base := range append([]string{conf.BaseURL, conf.UploadURL, conf.ProxyURL}, conf.FallbackURLs...) {
+		if u, err := url.Parse(base); err == nil && base != "" {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+
+	if u, err := url.Parse(conf.GHArchiveURL); err == nil {
+		hosts = append(hosts, u.Hostname())
+	}
+
+	return hosts
+}
+
+// startEgressGuard guards the default transport, used by the GitHub client,
+// the sinks, the object stores and the coverage job.
+func startEgressGuard() {
+	if !*egressGuard {
+		return
+	}
+
+	egressAllowlist.Allow(egressHosts()...)
+	if *egressAllow != "" {
+		egressAllowlist.Allow(strings.Split(*egressAllow, ",")...)
+	}
+
+	lib.GuardTransport(http.DefaultTransport.(*http.Transport), egressAllowlist)
+}
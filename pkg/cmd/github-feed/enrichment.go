@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var enrichBudget = flag.Int("enrich-budget", 0,
+	"API requests per hour shared by the enrichers (-repo-snapshots, -commit-signatures), instead of their own budgets.")
+
+// startEnrichment starts the scheduler of the enrichers' requests, nil
+// without -enrich-budget.
+func startEnrichment(ctx context.Context) *lib.EnrichmentScheduler {
+	if *enrichBudget <= 0 {
+		return nil
+	}
+
+	scheduler := lib.NewEnrichmentScheduler(lib.EnrichmentConfig{Budget: *enrichBudget})
+	go scheduler.Serve(ctx)
+	return scheduler
+}
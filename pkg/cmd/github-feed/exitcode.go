@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Exit statuses of the daemon, distinct per class of failure so that
+// supervisors decide whether restarting may help. The classes follow
+// sysexits(3) where one applies.
+const (
+	// Drained cleanly, on SIGINT/SIGTERM or at the end of a finite source.
+	exitOK = 0
+	// The feed failed, e.g. the API or a listener became unavailable.
+	exitFeed  = 1
+	exitUsage = 2
+	// A sink failed to open or to drain, events may be lost.
+	exitSink = 74
+	// The token was rejected.
+	exitAuth = 77
+	// Invalid flags or configuration.
+	exitConfig = 78
+)
+
+// exitClasses names the classes of failure for -restart-on.
+var exitClasses = map[string]int{
+	"feed":   exitFeed,
+	"sink":   exitSink,
+	"auth":   exitAuth,
+	"config": exitConfig,
+}
+
+// parseRestartOn parses a comma separated list of failure classes, "none"
+// or "all", and returns the exit statuses of the classes left out.
+func parseRestartOn(s string) ([]int, error) {
+	restart := map[int]bool{}
+	switch s {
+	case "none", "":
+	case "all":
+		for _, code := range exitClasses {
+			restart[code] = true
+		}
+	default:
+		for _, class := range strings.Split(s, ",") {
+			code, ok := exitClasses[strings.TrimSpace(class)]
+			if !ok {
+				return nil, fmt.Errorf("unknown failure class '%s', expected feed, sink, auth or config", class)
+			}
+			restart[code] = true
+		}
+	}
+
+	var prevent []int
+	for _, code := range exitClasses {
+		if !restart[code] {
+			prevent = append(prevent, code)
+		}
+	}
+	sort.Ints(prevent)
+	return prevent, nil
+}
+
+func exitStatusList(codes []int) string {
+	s := make([]string, len(codes))
+	for i, code := range codes {
+		s[i] = strconv.Itoa(code)
+	}
+	return strings.Join(s, " ")
+}
+
+// feedExitCode classifies the error which stopped the poller.
+func feedExitCode(err error) int {
+	var rep *github.ErrorResponse
+	if errors.As(err, &rep) && rep.Response != nil {
+		// Rate limits are other error types, a 403 here is a missing scope.
+		switch rep.Response.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return exitAuth
+		}
+	}
+	return exitFeed
+}
+
+var (
+	failure struct {
+		sync.Mutex
+		code int
+	}
+	// sources tracks the goroutines feeding the main loop, which may fail
+	// as they close its channel.
+	sources sync.WaitGroup
+)
+
+// fail records the first fatal error raised outside of the main loop (e.g. by
+// the poller or a listener) and shuts the command down, its exit status is
+// then code once the sinks are drained.
+func fail(code int, err error) {
+	log.Printf("Fatal: %v", err)
+
+	failure.Lock()
+	if failure.code == exitOK {
+		failure.code = code
+	}
+	failure.Unlock()
+
+	requestShutdown()
+}
+
+// failureCode returns the status recorded by fail, exitOK if none.
+func failureCode() int {
+	failure.Lock()
+	defer failure.Unlock()
+	return failure.code
+}
+
+// exitWith logs a fatal error raised by the main loop and returns code.
+func exitWith(code int, err error) int {
+	log.Printf("Fatal: %v", err)
+	return code
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// experiment is a feature still under development, refused unless enabled
+// by -experimental: it may change or go away between releases.
+type experiment struct {
+	name        string
+	description string
+	// The flags of the feature set by the command line, none if unused.
+	used func() []string
+}
+
+// experiments lists the features gated by -experimental. A feature leaving
+// the list moves to graduatedExperiments, so that the configurations still
+// enabling it keep working.
+var experiments = []experiment{
+	{
+		name:        "chaos",
+		description: "perturb the events API responses to test the polling",
+		used: func() []string {
+			var flags []string
+			chaos := feedConfig().Chaos
+			if chaos.PollIntervalRate > 0 {
+				flags = append(flags, "-chaos-poll-interval")
+			}
+			if chaos.NotModifiedRate > 0 {
+				flags = append(flags, "-chaos-not-modified")
+			}
+			return flags
+		},
+	},
+}
+
+// graduatedExperiments are the former experiments, enabled for everyone.
+var graduatedExperiments = map[string]bool{}
+
+// experimentList is the set of experiments enabled by -experimental, e.g.
+// experimental: [chaos] in the -config file.
+type experimentList struct {
+	names []string
+}
+
+var enabledExperiments = &experimentList{}
+
+func init() {
+	flag.Var(enabledExperiments, "experimental",
+		"Comma separated experimental features enabled, unstable and refused otherwise: "+experimentNames()+".")
+}
+
+func (l *experimentList) String() string {
+	return strings.Join(l.names, ",")
+}
+
+func (l *experimentList) Set(s string) error {
+	var names []string
+	for _, name := range splitList(s) {
+		name = strings.TrimSpace(name)
+		if lookupExperiment(name) == nil && !graduatedExperiments[name] {
+			return fmt.Errorf("unknown experimental feature '%s', expected %s", name, experimentNames())
+		}
+		names = append(names, name)
+	}
+	l.names = names
+	return nil
+}
+
+func (l *experimentList) List() bool { return true }
+
+func (l *experimentList) enabled(name string) bool {
+	for _, n := range l.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func lookupExperiment(name string) *experiment {
+	for i := range experiments {
+		if experiments[i].name == name {
+			return &experiments[i]
+		}
+	}
+	return nil
+}
+
+func experimentNames() string {
+	names := make([]string, len(experiments))
+	for i, e := range experiments {
+		names[i] = e.name
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// validateExperiments reports the experimental features used without being
+// enabled, by the flags set.
+func validateExperiments(errs *lib.ConfigErrors) {
+	for _, e := range experiments {
+		if enabledExperiments.enabled(e.name) {
+			continue
+		}
+		for _, flag := range e.used() {
+			errs.Addf(flag, "experimental, requires -experimental %s", e.name)
+		}
+	}
+}
+
+// logExperiments logs the experimental features enabled, once the feed
+// starts.
+func logExperiments() {
+	for _, name := range enabledExperiments.names {
+		if graduatedExperiments[name] {
+			log.Printf("The feature %s is no longer experimental, remove it from -experimental", name)
+			continue
+		}
+		e := lookupExperiment(name)
+		state := "unused"
+		if len(e.used()) > 0 {
+			state = "in use"
+		}
+		log.Printf("Experimental feature %s enabled (%s, %s): it may change or go away in a future release", name, e.description, state)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestValidateExperiments(t *testing.T) {
+	defer func() {
+		flag.Set("chaos-poll-interval", "0")
+		flag.Set("chaos-not-modified", "0")
+		enabledExperiments.names = nil
+	}()
+
+	for _, c := range []struct {
+		flags        map[string]string
+		experimental string
+		want         []string
+	}{
+		{nil, "", nil},
+		{map[string]string{"chaos-not-modified": "0.5"}, "", []string{"-chaos-not-modified"}},
+		{map[string]string{"chaos-poll-interval": "0.1"}, "", []string{"-chaos-poll-interval"}},
+		{map[string]string{"chaos-poll-interval": "0.1", "chaos-not-modified": "0.5"}, "",
+			[]string{"-chaos-poll-interval", "-chaos-not-modified"}},
+		{map[string]string{"chaos-not-modified": "0.5"}, "chaos", nil},
+	} {
+		flag.Set("chaos-poll-interval", "0")
+		flag.Set("chaos-not-modified", "0")
+		for name, value := range c.flags {
+			if err := flag.Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		enabledExperiments.names = nil
+		if c.experimental != "" {
+			if err := flag.Set("experimental", c.experimental); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var errs lib.ConfigErrors
+		validateExperiments(&errs)
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Field)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: got errors of %v, want %v", c.flags, got, c.want)
+		}
+	}
+}
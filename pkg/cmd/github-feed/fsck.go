@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	fsckFlags  = flag.NewFlagSet("fsck", flag.ExitOnError)
+	fsckRepair = fsckFlags.Bool("repair", false, "Restore corrupted files from their backup and remove stale temporary files.")
+)
+
+// fsck validates (and optionally repairs) state files, e.g.
+//
+//	github-feed fsck -repair /var/lib/github-feed/checkpoint
+//
+// It exits with 1 unless every file is healthy or was repaired, e.g. when a
+// corrupted file has no valid backup.
+func fsck(args []string) int {
+	fs := fsckFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed fsck [-repair] state-file...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		report := lib.CheckStateFile(path, *fsckRepair)
+
+		if report.Err != nil {
+			fmt.Printf("%s: %v\n", path, report.Err)
+		} else {
+			fmt.Printf("%s: ok (version %d, %d bytes)\n", path, report.Version, report.Size)
+		}
+
+		if report.BackupErr != nil && !os.IsNotExist(report.BackupErr) {
+			fmt.Printf("%s: backup: %v\n", path, report.BackupErr)
+		}
+
+		for _, tmp := range report.StaleTemps {
+			fmt.Printf("%s: stale temporary file %s\n", path, tmp)
+		}
+
+		for _, note := range report.RepairNotes {
+			fmt.Printf("%s: %s\n", path, note)
+		}
+
+		if report.Unrecoverable || !report.Healthy() && !report.Repaired {
+			status = 1
+		}
+	}
+
+	return status
+}
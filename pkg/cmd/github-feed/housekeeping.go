@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	housekeeping = stringList(flag.CommandLine, "housekeeping", "prune-cache=@daily,expire-dedup=@hourly,retention=@hourly",
+		"Comma separated maintenance jobs with their schedule (a duration, @hourly, @daily or @weekly), "+
+			"among prune-cache, compact-archive, expire-dedup, retention and coverage.")
+	housekeepingRetention = flag.Duration("housekeeping-retention", 7*24*time.Hour,
+		"Age after which prune-cache removes CPU profiles, stale temporary files and corrupted state copies.")
+	dedupMaxAge = flag.Duration("dedup-max-age", 24*time.Hour,
+		"Age after which expire-dedup forgets a delivered event ID.")
+	archiveRetention = flag.Duration("archive-retention", 0,
+		"Age after which the retention job removes the objects of file:// archives, 0 is unlimited.")
+	archiveMaxSize      = byteSize(0)
+	deadLetterRetention = flag.Duration("dead-letters-retention", 0,
+		"Age after which the retention job drops dead letters, 0 is unlimited.")
+	deadLetterMaxSize = byteSize(0)
+)
+
+func init() {
+	flag.Var(&archiveMaxSize, "archive-max-size",
+		"Size of each file:// archive above which the retention job removes the oldest objects, e.g. 50G, 0 is unlimited.")
+	flag.Var(&deadLetterMaxSize, "dead-letters-max-size",
+		"Size of the dead letters queue above which the retention job drops the oldest letters, e.g. 1G, 0 is unlimited.")
+}
+
+// startHousekeeping schedules the maintenance of the daemon's files.
+func startHousekeeping(ctx context.Context, dedup *lib.Deduplicator, coverage *lib.CoverageEstimator) error {
+	schedules, err := lib.ParseSchedules(*housekeeping)
+	if err != nil {
+		return err
+	}
+
+	jobs := map[string]func(ctx context.Context) error{
+		"prune-cache":     pruneCache,
+		"compact-archive": compactArchives,
+		"expire-dedup": func(ctx context.Context) error {
+			// Persisted with the next delivered batch.
+			if n := dedup.Expire(time.Now().Add(-*dedupMaxAge)); n > 0 {
+				log.Printf("Expired %d deduplication IDs", n)
+			}
+			return nil
+		},
+		"retention": enforceRetention,
+		"coverage":  estimateCoverage(coverage),
+	}
+
+	h := lib.NewHousekeeper()
+	for name, schedule := range schedules {
+		job, ok := jobs[name]
+		if !ok {
+			return fmt.Errorf("unknown housekeeping job '%s'", name)
+		}
+		h.Add(name, schedule, job)
+	}
+
+	go h.Serve(ctx)
+
+	return nil
+}
+
+// archiveDirs returns the directories of the file:// archive stores.
+func archiveDirs() []string {
+	var dirs []string
+	for _, u := range strings.Split(*archiveStores, ",") {
+		if parsed, err := url.Parse(u); err == nil && parsed.Scheme == "file" {
+			dirs = append(dirs, parsed.Path)
+		}
+	}
+	return dirs
+}
+
+func pruneCache(ctx context.Context) error {
+	var patterns []string
+	if *profileDir != "" {
+		patterns = append(patterns, filepath.Join(*profileDir, "cpu-*.pprof"))
+	}
+
+	// Temporary files are left behind by a crash during an atomic write.
+	if *archiveStores != "" {
+		patterns = append(patterns, filepath.Join(*archiveSpool, "*", "*", "*", "*", "*", "*.tmp-*"))
+	}
+	for _, dir := range archiveDirs() {
+		patterns = append(patterns, filepath.Join(dir, "*", "*", "*", "*", "*.tmp-*"))
+	}
+	for _, path := range []string{checkpointPath(), *dedupState, *deadLetters, *newContributorsState, *repoSnapshots} {
+		if path != "" {
+			patterns = append(patterns, path+".tmp-*", path+".corrupt")
+		}
+	}
+
+	n, err := lib.PruneFiles(patterns, *housekeepingRetention)
+	if n > 0 {
+		log.Printf("Pruned %d files", n)
+	}
+
+	return err
+}
+
+func compactArchives(ctx context.Context) error {
+	for _, dir := range archiveDirs() {
+		n, err := lib.CompactArchive(dir, time.Now())
+		if n > 0 {
+			log.Printf("Compacted %d archived objects in %s", n, dir)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enforceRetention bounds the file:// archives and the dead letters queue by
+// -archive-retention, -archive-max-size, -dead-letters-retention and
+// -dead-letters-max-size.
+func enforceRetention(ctx context.Context) error {
+	now := time.Now()
+
+	policy := lib.RetentionPolicy{MaxAge: *archiveRetention, MaxBytes: int64(archiveMaxSize)}
+	for _, dir := range archiveDirs() {
+		r, err := lib.EnforceArchiveRetention(dir, policy, now)
+		if r.Removed > 0 {
+			log.Printf("Removed %d archived objects (%d bytes) from %s", r.Removed, r.Bytes, dir)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if deadLetterQueue != nil {
+		policy := lib.RetentionPolicy{MaxAge: *deadLetterRetention, MaxBytes: int64(deadLetterMaxSize)}
+		r, err := deadLetterQueue.Expire(policy, now)
+		if r.Removed > 0 {
+			log.Printf("Dropped %d dead letters (%d bytes)", r.Removed, r.Bytes)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
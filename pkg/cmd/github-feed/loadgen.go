@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/fsaintjacques/github-feed/pkg/loadgen"
+)
+
+var loadgenConfig, loadgenPrintConfig = configFlags(loadgen.Flags)
+
+const loadgenUserAgent = "github-loadgen"
+
+// runLoadgen replays the live feed against a target, e.g.
+//
+//	github-feed loadgen -duration 10m -warmup 1m -ab-target https://canary
+func runLoadgen(args []string) int {
+	fs := loadgen.Flags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed loadgen [flags]\n")
+		fs.PrintDefaults()
+	}
+	if err := parseFlags(fs, args); err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	if *loadgenPrintConfig {
+		return printConfig("loadgen", fs)
+	}
+
+	if err := configureLoadgenDialer(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	conf := loadgenConfig()
+	if conf.UserAgent == "" {
+		conf.UserAgent = loadgenUserAgent
+	}
+	lib.SetRequestIdentity(conf.UserAgent, conf.RequestTag)
+
+	runner, err := loadgen.NewRunner()
+	if err != nil {
+		return exitWith(exitConfig, err)
+	}
+	if err := runner.Run(conf); err != nil {
+		return exitWith(exitFeed, err)
+	}
+	return exitOK
+}
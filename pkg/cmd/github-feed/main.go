@@ -3,37 +3,494 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
 )
 
+var sponsorships = stringList(flag.CommandLine, "sponsorships", "",
+	"Comma separated list of accounts for which sponsorship records are emitted "+
+		"instead of raw events, use '*' to track every account.")
+
+var feedConfig, feedPrintConfig = configFlags(flag.CommandLine)
+
+var filterExpr = flag.String("filter", "",
+	"Filter expression selecting the emitted events, e.g. 'type=PullRequestEvent label=bug'.")
+
+var transformsPath = flag.String("transforms", "",
+	"YAML file declaring the ordered transforms (filter, sample, anonymize, truncate) applied to the emitted events.")
+
+var canonical = flag.Bool("canonical", false,
+	"Emit and archive canonical JSON (sorted keys, fixed number format, no HTML escaping), "+
+		"same as -format canonical-json.")
+
+var recordFormat = flag.String("format", lib.DefaultSerializer,
+	"Format of the emitted, archived and served records: "+strings.Join(lib.SerializerNames(), ", ")+".")
+
+var prettyTerminal = flag.Bool("pretty-terminal", false,
+	"Write the emitted events on stdout as aligned, colored lines with an icon per event type and a summary "+
+		"in the locale of LANG, for people rather than programs.")
+
+// recordSerializer returns the serializer selected by -format or -canonical.
+func recordSerializer() (lib.Serializer, error) {
+	if *canonical {
+		if *recordFormat != lib.DefaultSerializer {
+			return nil, fmt.Errorf("-canonical conflicts with -format %s", *recordFormat)
+		}
+		return lib.LookupSerializer("canonical-json")
+	}
+	return lib.LookupSerializer(*recordFormat)
+}
+
+// newTerminalRenderer renders the events on stdout in the locale of LANG,
+// English if it has no catalog, colored unless stdout isn't a terminal or
+// NO_COLOR is set.
+func newTerminalRenderer() *lib.TerminalRenderer {
+	summarizer, err := lib.NewSummarizer(os.Getenv("LANG"))
+	if err != nil {
+		summarizer, _ = lib.NewSummarizer(lib.DefaultSummaryLocale)
+	}
+
+	color := false
+	if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		_, noColor := os.LookupEnv("NO_COLOR")
+		color = !noColor
+	}
+	return lib.NewTerminalRenderer(os.Stdout, summarizer, color)
+}
+
+func writeRecord(s lib.Serializer, ev *github.Event) {
+	s.Encode(os.Stdout, lib.NewRecord(ev))
+}
+
+func writeJSON(v interface{}) {
+	marshal := json.Marshal
+	if *canonical {
+		marshal = lib.MarshalCanonical
+	}
+
+	b, _ := marshal(v)
+	os.Stdout.Write(b)
+	os.Stdout.WriteString("\n")
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: github-feed [feed] [flags]\n")
+	fmt.Fprintf(out, "       github-feed serve -serve addr [flags]\n")
+	fmt.Fprintf(out, "       github-feed loadgen [flags]\n")
+	fmt.Fprintf(out, "       github-feed replay [-filter expr] [-summary locale] file...\n")
+	fmt.Fprintf(out, "       github-feed redrive -dead-letters file [flags]\n")
+	fmt.Fprintf(out, "       github-feed verify [-strict] file-or-dir...\n")
+	fmt.Fprintf(out, "       github-feed diff -from time [-to time] [-ids] store-a store-b\n")
+	fmt.Fprintf(out, "       github-feed query -archive store 'expression [since=time] [until=time]'\n")
+	fmt.Fprintf(out, "       github-feed test-filter -filter expr -fixtures file [-expect n]\n")
+	fmt.Fprintf(out, "       github-feed doctor [flags]\n")
+	fmt.Fprintf(out, "       github-feed fsck [-repair] state-file...\n")
+	fmt.Fprintf(out, "       github-feed migrate [-to version] [-in-place] file...\n")
+	fmt.Fprintf(out, "       github-feed completion bash|zsh|fish\n")
+	fmt.Fprintf(out, "       github-feed install-service [-name name] -- [flags]\n")
+	fmt.Fprintf(out, "       github-feed uninstall-service [-name name]\n")
+	fmt.Fprintf(out, "       github-feed version [-check-update]\n")
+	fmt.Fprintf(out, "       github-feed config-schema [-command feed|loadgen]\n")
+	fmt.Fprintf(out, "       github-feed support-bundle [-bundle-output file] [-bundle-admin-url url] [flags]\n")
+	flag.PrintDefaults()
+}
+
 func main() {
-	var err error
+	flag.Usage = usage
+	// Secrets are scrubbed from every log line, see lib.Redact. The last
+	// lines are kept for support-bundle.
+	log.SetOutput(lib.RedactingWriter(io.MultiWriter(os.Stderr, recentLogs)))
+
+	if runService() {
+		return
+	}
+
+	os.Exit(run(os.Args[1:]))
+}
+
+// run executes the subcommand, the feed (written on stdout) without one, and
+// returns the exit status.
+func run(args []string) int {
+	cmd := "feed"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "feed", "serve":
+		if err := parseFlags(flag.CommandLine, args); err != nil {
+			return exitWith(exitConfig, err)
+		}
+		if *feedPrintConfig {
+			return printConfig(cmd, flag.CommandLine)
+		}
+		if cmd == "serve" && *serveAddr == "" {
+			log.Print("serve requires -serve")
+			return exitUsage
+		}
+		if err := validateConfig(); err != nil {
+			return exitWith(exitConfig, err)
+		}
+		conf := feedConfig()
+		if err := lib.ConfigureLogging(conf.LogLevel, conf.LogFormat); err != nil {
+			return exitWith(exitConfig, err)
+		}
+		return runFeed(cmd == "feed")
+	case "loadgen":
+		return runLoadgen(args)
+	case "replay":
+		return replay(args)
+	case "redrive":
+		return redrive(args)
+	case "verify":
+		return verify(args)
+	case "diff":
+		return diff(args)
+	case "query":
+		return query(args)
+	case "test-filter":
+		return testFilter(args)
+	case "doctor":
+		return doctor(args)
+	case "fsck":
+		return fsck(args)
+	case "completion":
+		return completion(args)
+	case "migrate":
+		return migrate(args)
+	case "install-service":
+		return installService(args)
+	case "uninstall-service":
+		return uninstallService(args)
+	case "version":
+		return printVersion(args)
+	case "config-schema":
+		return configSchema(args)
+	case "support-bundle":
+		return supportBundle(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n", cmd)
+		usage()
+		return exitUsage
+	}
+}
+
+var (
+	shutdown     = make(chan struct{})
+	shutdownOnce sync.Once
+)
+
+// requestShutdown asks the running command to stop, on SIGINT/SIGTERM or
+// when the service is stopped.
+func requestShutdown() {
+	shutdownOnce.Do(func() { close(shutdown) })
+}
+
+// runFeed polls the feed, publishing the events to the configured sinks and
+// stream, and on stdout unless stdout is false or -sink outputs replace it.
+// It returns the exit status, see exitcode.go.
+func runFeed(stdout bool) (code int) {
+	if err := configureDialer(); err != nil {
+		return exitWith(exitConfig, err)
+	}
+	startEgressGuard()
+	conf := feedConfig()
+	lib.SetRequestIdentity(conf.UserAgent, conf.RequestTag)
+
+	filter, err := lib.ParseFilter(*filterExpr)
+	if err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	if conf.Deduplicator, err = newPollDeduplicator(conf.Clock); err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	records, err := recordSerializer()
+	if err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	tracker, err := newRecordTracker()
+	if err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	transforms := &lib.TransformChain{}
+	if *transformsPath != "" {
+		queue, err := openDeadLetters()
+		if err != nil {
+			return exitWith(exitConfig, err)
+		}
+		if transforms, err = lib.LoadTransformChain(*transformsPath, queue); err != nil {
+			return exitWith(exitConfig, err)
+		}
+	}
+
+	var pretty *lib.TerminalRenderer
+	if *prettyTerminal {
+		pretty = newTerminalRenderer()
+	}
 
 	ctx := context.Background()
+	log.Printf("Starting %s", versionString())
+	logExperiments()
+	startUpdateCheck(ctx)
 
-	conf := &lib.Config{
-		AuthToken: os.Getenv("GITHUB_AUTH_TOKEN"),
+	sourceManager, err := openSourceManager(conf)
+	if err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	feed, events_chan, err := lib.NewFeed(ctx, conf)
+	if err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	sinkErrors := func(err error) { feed.ReportError(lib.OpSink, "", err) }
+	transforms.OnError = func(name string, ev *github.Event, err error) {
+		feed.ReportError(lib.OpTransform, ev.GetID(), fmt.Errorf("%s: %w", name, err))
+	}
+	archive, err := newSink(ctx, sinkErrors)
+	if err != nil {
+		return exitWith(exitSink, err)
+	}
+	outputs, err := newOutputs(records, sinkErrors)
+	if err != nil {
+		return exitWith(exitSink, err)
+	}
+
+	broadcaster := startServer(ctx, records)
+
+	coverage, err := newCoverageEstimator()
+	if err != nil {
+		return exitWith(exitConfig, err)
 	}
 
-	feed, events_chan, err := lib.NewEventFeed(ctx, conf)
+	stats := lib.NewEventStats(nil)
+	enrichment := startEnrichment(ctx)
+	signatures := startCommitSignatures(ctx, feed, enrichment)
+	admin := startAdmin(ctx)
+	if admin != nil {
+		admin.Handle("/metrics", metricsHandler(feed, stats, coverage, enrichment, transforms, signatures))
+		admin.Handle("/pause", pauseHandler(feed))
+		admin.Handle("/debug/logs", recentLogs)
+		admin.Handle("/debug/responses", feed.RecentResponsesHandler())
+	}
+	if err := startAnnotations(admin); err != nil {
+		return exitWith(exitConfig, err)
+	}
+	startSourceManager(admin, feed, sourceManager)
+	snapshots, err := startRepoSnapshots(ctx, feed, admin, enrichment)
 	if err != nil {
-		log.Panic(err)
+		return exitWith(exitConfig, err)
+	}
+	trackerSnapshots = snapshots
+	router, routeSinks, err := startPathRouter(ctx, feed, enrichment, records, sinkErrors)
+	if err != nil {
+		return exitWith(exitSink, err)
+	}
+	startCacheProxy(ctx, feed)
+
+	// Synthetic events seeded next to the live feed, see -discover.
+	var discovered <-chan []*github.Event
+	if *soakDuration > 0 {
+		if events_chan, err = startSoak(); err != nil {
+			return exitWith(exitConfig, err)
+		}
+	} else if *sourceArchive != "" {
+		if events_chan, err = startArchiveSource(ctx); err != nil {
+			return exitWith(exitConfig, err)
+		}
+	} else {
+		sources.Add(1)
+		go func() {
+			defer sources.Done()
+			err := feed.Serve()
+			if err == nil {
+				// The end of the -gharchive-from range.
+				return
+			}
+			fail(feedExitCode(err), err)
+		}()
+		discovered = startDiscovery(ctx, feed)
+		if err := startRepoDiscovery(ctx, feed, sourceManager); err != nil {
+			return exitWith(exitConfig, err)
+		}
+	}
+
+	go func() {
+		for err := range feed.Errors() {
+			status.error(err)
+			log.Print(err)
+		}
+	}()
+
+	dedup, err := newDeduplicator(conf.Clock)
+	if err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	if err := startHousekeeping(ctx, dedup, coverage); err != nil {
+		return exitWith(exitConfig, err)
 	}
 
-	go func() { log.Panic(feed.Serve()) }()
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+		requestShutdown()
+	}()
 
-	for events := range events_chan {
-		for _, ev := range events {
-			if *ev.Actor.Login == "dependabot[bot]" {
-				continue
+	// On shutdown the batch being processed completes, then the sinks are
+	// drained and the deduplication state checkpointed. The exit status is
+	// that of the failure which stopped the loop, if any, and the run is
+	// reported.
+	totals := newRunTotals()
+	defer func() {
+		code = failureCode()
+		if archive != nil {
+			if err := archive.Close(); err != nil {
+				log.Printf("Failed closing the archive: %v", err)
+				if code == exitOK {
+					code = exitSink
+				}
 			}
+		}
+		for _, out := range append(outputs, routeSinks...) {
+			if err := out.Close(); err != nil {
+				log.Printf("Failed closing a sink: %v", err)
+				if code == exitOK {
+					code = exitSink
+				}
+			}
+		}
+		saveDeduplicator(dedup)
+		if snapshots != nil {
+			if err := snapshots.Save(); err != nil {
+				log.Printf("Failed saving repository snapshots: %v", err)
+			}
+		}
+		writeRunReport(feed, totals, code)
+	}()
+
+	next := func() ([]*github.Event, bool) {
+		select {
+		case events, ok := <-events_chan:
+			if !ok {
+				// The source is failing or done, see fail.
+				sources.Wait()
+			}
+			return events, ok
+		case events := <-discovered:
+			return events, true
+		case <-shutdown:
+			log.Print("Shutting down")
+			return nil, false
+		}
+	}
 
-			b, _ := json.Marshal(ev)
-			os.Stdout.Write(b)
-			os.Stdout.WriteString("\n")
+	if stdout && tracker != nil {
+		defer tracker.save()
+		for events, ok := next(); ok; events, ok = next() {
+			totals.receive(events)
+			unseen := dedup.Filter(events)
+			totals.drop("duplicate", events, unseen)
+			events = unseen
+			if snapshots != nil {
+				snapshots.Observe(events)
+			}
+			records := tracker.track(events)
+			for _, record := range records {
+				writeJSON(record)
+			}
+			totals.emitted += uint64(len(records))
+			saveDeduplicator(dedup)
+			tracker.checkpoint()
 		}
+		return exitOK
 	}
+
+	for events, ok := next(); ok; events, ok = next() {
+		totals.receive(events)
+		unseen := dedup.Filter(events)
+		totals.drop("duplicate", events, unseen)
+		events = unseen
+		status.batch(len(events))
+		stats.Observe(events)
+		if snapshots != nil {
+			snapshots.Observe(events)
+		}
+		if signatures != nil {
+			signatures.Observe(events)
+		}
+
+		if archive != nil {
+			if err := archive.Write(ctx, events); err != nil {
+				feed.ReportError(lib.OpSink, "", err)
+			}
+		}
+
+		if broadcaster != nil {
+			broadcaster.Publish(events)
+		}
+
+		if stdout || len(outputs) > 0 || len(routeSinks) > 0 {
+			emitted := make([]*github.Event, 0, len(events))
+			for _, ev := range events {
+				if ev.GetActor().GetLogin() == "dependabot[bot]" || !filter.Match(ev) {
+					continue
+				}
+				emitted = append(emitted, ev)
+			}
+			totals.drop("filtered", events, emitted)
+			transformed, err := transforms.Apply(emitted)
+			if err != nil {
+				fail(exitFeed, err)
+				break
+			}
+			totals.drop("transform", emitted, transformed)
+			emitted = transformed
+			totals.emitted += uint64(len(emitted))
+			if router != nil {
+				router.Resolve(ctx, emitted)
+			}
+
+			// The outputs replace stdout, which is one of them if listed.
+			for _, out := range outputs {
+				if err := out.Write(ctx, emitted); err != nil {
+					feed.ReportError(lib.OpSink, "", err)
+				}
+			}
+			for _, out := range routeSinks {
+				if err := out.Write(ctx, emitted); err != nil {
+					feed.ReportError(lib.OpSink, "", err)
+				}
+			}
+			if stdout && len(outputs) == 0 {
+				for _, ev := range emitted {
+					if pretty != nil {
+						pretty.Render(ev)
+						continue
+					}
+					writeRecord(records, ev)
+				}
+			}
+		}
+
+		saveDeduplicator(dedup)
+	}
+
+	return exitOK
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	migrateFlags   = flag.NewFlagSet("migrate", flag.ExitOnError)
+	migrateTo      = migrateFlags.Int("to", lib.SchemaVersion, "Target schema version.")
+	migrateInPlace = migrateFlags.Bool("in-place", false, "Rewrite the files atomically instead of writing to stdout.")
+)
+
+// migrate upgrades archived NDJSON files (optionally gzipped) to a schema
+// version, e.g.
+//
+//	github-feed migrate -in-place archive/2020/08/20/*/*.ndjson.gz
+func migrate(args []string) int {
+	fs := migrateFlags
+	to, inPlace := migrateTo, migrateInPlace
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed migrate [-to version] [-in-place] file...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		n, err := migrateFile(path, *to, *inPlace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		if *inPlace {
+			fmt.Fprintf(os.Stderr, "%s: migrated %d records to schema version %d\n", path, n, *to)
+		}
+	}
+
+	return status
+}
+
+func migrateFile(path string, to int, inPlace bool) (int, error) {
+	if strings.HasSuffix(path, ".parquet") {
+		return 0, fmt.Errorf("parquet archives are not supported")
+	}
+
+	gzipped := strings.HasSuffix(path, ".gz")
+
+	r, err := openArchive(path)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	if !inPlace {
+		return lib.MigrateNDJSON(r, os.Stdout, to)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	// TempFile creates the file 0600, the archive keeps its mode.
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+
+	var w io.WriteCloser = tmp
+	if gzipped {
+		w = gzip.NewWriter(tmp)
+	}
+
+	n, err := lib.MigrateNDJSON(r, w, to)
+	if err == nil && gzipped {
+		err = w.Close()
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return n, err
+	}
+
+	return n, os.Rename(tmp.Name(), path)
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
+
+// openArchive opens an NDJSON archive, decompressing it if gzipped.
+func openArchive(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return gzipFile{gz, f}, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+var (
+	pathRoutes      = &pathRouteFlag{}
+	pathRouteSinks  = &routeSinkFlag{}
+	pathRouteBudget = flag.Int("path-route-budget", 0, "Compare API requests per hour spent by -path-route (default 1000).")
+)
+
+func init() {
+	flag.Var(pathRoutes, "path-route",
+		"Route of the pushes to a monorepo changing matching paths, repo:pattern=route, "+
+			"e.g. acme/mono:services/payments/**=payments (repeatable).")
+	flag.Var(pathRouteSinks, "path-route-sink",
+		"Output of the pushes of a -path-route, route=url with the urls of -sink, e.g. "+
+			"payments=kafka://rest-proxy:8082/payments (repeatable).")
+}
+
+// pathRouteFlag collects the repeated -path-route.
+type pathRouteFlag struct {
+	specs  []string
+	routes []lib.PathRoute
+}
+
+func (f *pathRouteFlag) String() string {
+	return strings.Join(f.specs, ",")
+}
+
+func (f *pathRouteFlag) Set(v string) error {
+	route, err := lib.ParsePathRoute(v)
+	if err != nil {
+		return err
+	}
+	f.specs = append(f.specs, v)
+	f.routes = append(f.routes, route)
+	return nil
+}
+
+func (f *pathRouteFlag) Repeatable() bool { return true }
+
+// routeSinkFlag collects the repeated -path-route-sink.
+type routeSinkFlag struct {
+	specs []string
+}
+
+func (f *routeSinkFlag) String() string {
+	return strings.Join(f.specs, ",")
+}
+
+func (f *routeSinkFlag) Set(v string) error {
+	if i := strings.Index(v, "="); i <= 0 || i == len(v)-1 {
+		return fmt.Errorf("invalid route sink '%s', expected route=url", v)
+	}
+	f.specs = append(f.specs, v)
+	return nil
+}
+
+func (f *routeSinkFlag) Repeatable() bool { return true }
+
+// validatePathRoutes checks that the route sinks are of declared routes.
+func validatePathRoutes(errs *lib.ConfigErrors) {
+	declared := make(map[string]bool)
+	for _, route := range pathRoutes.routes {
+		declared[route.Route] = true
+	}
+	for i, spec := range pathRouteSinks.specs {
+		kv := strings.SplitN(spec, "=", 2)
+		name := fmt.Sprintf("-path-route-sink[%d]", i)
+		if !declared[kv[0]] {
+			errs.Addf(name, "route '%s' isn't declared by -path-route", kv[0])
+		}
+		errs.Add(name, lib.ValidateSinkURL(kv[1]))
+	}
+}
+
+// routedSink writes the pushes of a route.
+type routedSink struct {
+	lib.Sink
+	route  string
+	router *lib.PathRouter
+}
+
+func (s *routedSink) Write(ctx context.Context, events []*github.Event) error {
+	var routed []*github.Event
+	for _, ev := range events {
+		for _, route := range s.router.Routes(ev) {
+			if route == s.route {
+				routed = append(routed, ev)
+				break
+			}
+		}
+	}
+	if len(routed) == 0 {
+		return nil
+	}
+	return s.Sink.Write(ctx, routed)
+}
+
+// startPathRouter routes the pushes of -path-route with the feed's client,
+// sets their routes in the records and opens the -path-route-sink outputs,
+// wrapped like those of -sink. The router is nil without -path-route, its
+// requests are scheduled by enrichment if not nil.
+func startPathRouter(ctx context.Context, feed *lib.EventFeed, enrichment *lib.EnrichmentScheduler,
+	records lib.Serializer, errs func(error)) (*lib.PathRouter, []lib.Sink, error) {
+	if len(pathRoutes.routes) == 0 {
+		return nil, nil, nil
+	}
+
+	router := lib.NewPathRouter(feed.Client(), pathRoutes.routes, *pathRouteBudget)
+	router.ReportError = feed.ReportError
+	router.Scheduler = enrichment
+	router.AttachToRecords()
+
+	var sinks []lib.Sink
+	for _, spec := range pathRouteSinks.specs {
+		kv := strings.SplitN(spec, "=", 2)
+		sink, err := lib.OpenSink(kv[1], records)
+		if err == nil {
+			allowEgress(sink)
+			sink, err = wrapSink(kv[0]+"="+lib.Redact(kv[1]), sink, errs)
+		}
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, nil, err
+		}
+		sinks = append(sinks, &routedSink{Sink: sink, route: kv[0], router: router})
+	}
+
+	go router.Serve(ctx)
+	return router, sinks, nil
+}
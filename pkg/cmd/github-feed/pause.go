@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// pauseHandler serves the pause switch of the feed on the admin endpoint:
+// GET reports whether it is paused, POST pauses it and DELETE resumes it,
+// e.g. around a maintenance window.
+func pauseHandler(feed *lib.EventFeed) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			feed.Pause()
+		case http.MethodDelete:
+			feed.Resume()
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Paused bool `json:"paused"`
+		}{feed.Paused()})
+	}
+}
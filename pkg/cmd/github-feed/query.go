@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+const (
+	defaultQuerySince = 24 * time.Hour
+	// Events are archived shortly after their creation, objects archived
+	// this long after the end of the range are searched too.
+	queryArchiveGrace = time.Hour
+)
+
+var (
+	queryFlags   = flag.NewFlagSet("query", flag.ExitOnError)
+	queryArchive = queryFlags.String("archive", "", "Archive searched, a store URL (see -archive of the feed) or an archive directory.")
+	queryLimit   = queryFlags.Int("limit", 0, "Stop after n matching events, 0 is unlimited.")
+	queryCount   = queryFlags.Bool("count", false, "Only print the number of matching events.")
+)
+
+// query searches the archive for the events matching a filter expression,
+// restricted to the events created in [since, until), e.g.
+//
+//	github-feed query -archive /var/lib/github-feed/archive 'type=PushEvent repo~=^golang/ since=-2h'
+//
+// The archive is partitioned by hour, only the objects of the hours of the
+// range are read. It exits with status 1 when no event matches.
+func query(args []string) int {
+	fs := queryFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed query -archive store [-limit n] [-count] 'expression [since=time] [until=time]'\n")
+		fmt.Fprintf(fs.Output(), "times are RFC 3339 or relative to now, e.g. since=-2h (default since=-%v)\n", defaultQuerySince)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *queryArchive == "" || fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	filter, since, until, err := parseQuery(fs.Arg(0), time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	store, err := openReadableStore(*queryArchive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *queryArchive, err)
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	end := until.Add(queryArchiveGrace)
+	if now := time.Now(); end.After(now) {
+		end = now
+	}
+	feed, events, err := lib.NewArchiveRangeFeed(store, since, end)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *queryArchive, err)
+		return 1
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- feed.Serve(ctx) }()
+
+	matches := 0
+scan:
+	for batch := range events {
+		for _, ev := range batch {
+			created := ev.GetCreatedAt()
+			if created.Before(since) || !created.Before(until) || !filter.Match(ev) {
+				continue
+			}
+
+			matches++
+			if !*queryCount {
+				writeJSON(lib.NewRecord(ev))
+			}
+			if matches == *queryLimit {
+				break scan
+			}
+		}
+	}
+
+	cancel()
+	if err := <-errc; err != nil && matches != *queryLimit {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *queryArchive, err)
+		return 1
+	}
+
+	if *queryCount {
+		fmt.Println(matches)
+	}
+	if matches == 0 {
+		return 1
+	}
+	return 0
+}
+
+// parseQuery splits the since= and until= terms from the filter expression.
+func parseQuery(expr string, now time.Time) (filter *lib.Filter, since, until time.Time, err error) {
+	since, until = now.Add(-defaultQuerySince), now
+
+	var terms []string
+	for _, term := range strings.Fields(expr) {
+		switch {
+		case strings.HasPrefix(term, "since="):
+			since, err = parseQueryTime(strings.TrimPrefix(term, "since="), now)
+		case strings.HasPrefix(term, "until="):
+			until, err = parseQueryTime(strings.TrimPrefix(term, "until="), now)
+		default:
+			terms = append(terms, term)
+		}
+		if err != nil {
+			return nil, since, until, fmt.Errorf("query term '%s': %v", term, err)
+		}
+	}
+
+	if !since.Before(until) {
+		return nil, since, until, fmt.Errorf("query range is empty: since %v, until %v", since, until)
+	}
+
+	filter, err = lib.ParseFilter(strings.Join(terms, " "))
+	return filter, since, until, err
+}
+
+// parseQueryTime parses an RFC 3339 time, or a duration relative to now.
+func parseQueryTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	replayFlags   = flag.NewFlagSet("replay", flag.ExitOnError)
+	replayFilter  = replayFlags.String("filter", "", "Filter expression selecting the replayed events.")
+	replaySummary = replayFlags.String("summary", "",
+		"Write a one-line description of each event in this locale (e.g. en, fr) instead of the records.")
+	replayCatalog = replayFlags.String("summary-catalog", "",
+		"YAML file of summary messages by locale, adding locales or rephrasing the built-in messages.")
+)
+
+// replay writes the records of archived NDJSON files (optionally gzipped) on
+// stdout, upgraded to the current schema, e.g.
+//
+//	github-feed replay -filter type=PushEvent archive/2020/08/20/*/*.ndjson.gz
+func replay(args []string) int {
+	fs := replayFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed replay [-filter expr] [-summary locale] file...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	filter, err := lib.ParseFilter(*replayFilter)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *replayCatalog != "" {
+		if err := lib.LoadSummaryCatalog(*replayCatalog); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	var summarizer *lib.Summarizer
+	if *replaySummary != "" {
+		if summarizer, err = lib.NewSummarizer(*replaySummary); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	status := 0
+	for _, path := range fs.Args() {
+		if err := replayFile(w, path, filter, summarizer); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			status = 1
+		}
+	}
+
+	return status
+}
+
+// replayFile writes the records of a file matching filter, or their
+// summaries with a summarizer.
+func replayFile(w *bufio.Writer, path string, filter *lib.Filter, summarizer *lib.Summarizer) error {
+	r, err := openArchive(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var expander lib.RecordExpander
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		line, err := expander.Expand(line)
+		if err != nil {
+			return fmt.Errorf("record %d: %v", n, err)
+		} else if line == nil {
+			continue
+		}
+
+		migrated, err := lib.MigrateRecord(line, lib.SchemaVersion)
+		if err != nil {
+			return fmt.Errorf("record %d: %v", n, err)
+		}
+
+		var record lib.Record
+		if err := json.Unmarshal(migrated, &record); err != nil {
+			return fmt.Errorf("record %d: %v", n, err)
+		}
+
+		if record.Event == nil || !filter.Match(record.Event) {
+			continue
+		}
+
+		if summarizer != nil {
+			fmt.Fprintf(w, "%s %s\n", record.GetCreatedAt().Format(time.RFC3339), summarizer.Summarize(record.Event))
+			continue
+		}
+
+		w.Write(migrated)
+		w.WriteByte('\n')
+	}
+
+	return scanner.Err()
+}
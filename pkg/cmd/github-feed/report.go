@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+var runReportPath = flag.String("report", "",
+	"JSON file written on exit with the report of the run (events received, emitted and dropped by reason, "+
+		"deliveries per sink, final checkpoint, uptime), which is logged either way.")
+
+// runTotals count the events of the main loop, once received from the feed.
+type runTotals struct {
+	received uint64
+	emitted  uint64
+	// Events not emitted, by reason.
+	dropped map[string]uint64
+	// Newest event received.
+	last *github.Event
+}
+
+func newRunTotals() *runTotals {
+	return &runTotals{dropped: map[string]uint64{"duplicate": 0, "filtered": 0, "transform": 0}}
+}
+
+// receive counts a batch received from the feed, before deduplication.
+func (t *runTotals) receive(events []*github.Event) {
+	t.received += uint64(len(events))
+	for _, ev := range events {
+		if t.last == nil || ev.GetCreatedAt().After(t.last.GetCreatedAt()) {
+			t.last = ev
+		}
+	}
+}
+
+// drop counts the events of a stage dropping them for reason, from before to
+// after it.
+func (t *runTotals) drop(reason string, before, after []*github.Event) {
+	t.dropped[reason] += uint64(len(before) - len(after))
+}
+
+// runReport is the report of a run, see -report.
+type runReport struct {
+	Version  string    `json:"version"`
+	Started  time.Time `json:"started"`
+	Stopped  time.Time `json:"stopped"`
+	Uptime   string    `json:"uptime"`
+	ExitCode int       `json:"exit_code"`
+	// Events received from the feed, emitted to stdout or the sinks, and
+	// dropped along the way by reason, those of the feed included.
+	Received uint64            `json:"received"`
+	Emitted  uint64            `json:"emitted"`
+	Dropped  map[string]uint64 `json:"dropped"`
+	// Newest event received.
+	LastEventID   string     `json:"last_event_id,omitempty"`
+	LastEventTime *time.Time `json:"last_event_time,omitempty"`
+	// Deduplication state, the checkpoint of the next run.
+	DedupState string                   `json:"dedup_state,omitempty"`
+	Feed       lib.FeedReport           `json:"feed"`
+	Sinks      map[string]lib.SinkStats `json:"sinks,omitempty"`
+}
+
+// writeRunReport logs the report of the run and writes it to -report.
+func writeRunReport(feed *lib.EventFeed, totals *runTotals, code int) {
+	now := time.Now()
+	report := runReport{
+		Version:    versionString(),
+		Started:    status.started,
+		Stopped:    now,
+		Uptime:     now.Sub(status.started).Round(time.Second).String(),
+		ExitCode:   code,
+		Received:   totals.received,
+		Emitted:    totals.emitted,
+		Dropped:    make(map[string]uint64),
+		DedupState: *dedupState,
+		Feed:       feed.Report(),
+		Sinks:      lib.AllSinkStats(),
+	}
+	for reason, n := range report.Feed.Dropped {
+		report.Dropped[reason] = n
+	}
+	for reason, n := range totals.dropped {
+		report.Dropped[reason] = n
+	}
+	if totals.last != nil {
+		report.LastEventID = totals.last.GetID()
+		created := totals.last.GetCreatedAt()
+		report.LastEventTime = &created
+	}
+
+	b, err := json.Marshal(report)
+	if err != nil {
+		log.Printf("Failed encoding the report of the run: %v", err)
+		return
+	}
+	log.Printf("Run report: %s", b)
+
+	if *runReportPath == "" {
+		return
+	}
+	if err := ioutil.WriteFile(*runReportPath, append(b, '\n'), 0644); err != nil {
+		log.Printf("Failed writing the report of the run: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	repoSnapshots = flag.String("repo-snapshots", "",
+		"State file of the metadata snapshots (license, default branch, topics, created_at) captured for the repositories "+
+			"first seen in the stream, referenced by the repo_snapshot field of the following records.")
+	repoSnapshotBudget = flag.Int("repo-snapshot-budget", 0,
+		"Repositories API requests per hour spent by -repo-snapshots (default 1000).")
+	repoSnapshotSize = flag.Int("repo-snapshot-size", 0,
+		"Maximum number of repository snapshots kept by -repo-snapshots (default 100000).")
+	repoSnapshotTTL = flag.Duration("repo-snapshot-ttl", 0,
+		"Age after which a repository snapshot is captured again (default 720h).")
+)
+
+// startRepoSnapshots captures the snapshots of the repositories with the
+// feed's client, references them in the records and serves them on the admin
+// endpoint if enabled. The returned snapshotter is nil without
+// -repo-snapshots. The captures are scheduled by enrichment if not nil.
+func startRepoSnapshots(ctx context.Context, feed *lib.EventFeed, admin *http.ServeMux, enrichment *lib.EnrichmentScheduler) (*lib.RepoSnapshotter, error) {
+	if *repoSnapshots == "" {
+		return nil, nil
+	}
+
+	snapshots, err := lib.NewRepoSnapshotter(feed.Client(), *repoSnapshots, *repoSnapshotBudget,
+		lib.CacheConfig{MaxEntries: *repoSnapshotSize, TTL: *repoSnapshotTTL})
+	if err != nil {
+		return nil, err
+	}
+	snapshots.ReportError = feed.ReportError
+	snapshots.Scheduler = enrichment
+	snapshots.AttachToRecords()
+
+	go snapshots.Serve(ctx)
+	go snapshots.ServePersist(ctx, time.Minute)
+
+	if admin != nil {
+		admin.Handle("/repo-snapshots", snapshots)
+	}
+	return snapshots, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	serveAddr = flag.String("serve", "",
+		"Address on which events are streamed as server-sent events (GET /events), e.g. ':8080'. "+
+			"A web UI is served on / and the pipeline status on /status.")
+	serveTLSCert = flag.String("serve-tls-cert", "", "TLS certificate of the served endpoints.")
+	serveTLSKey  = flag.String("serve-tls-key", "", "TLS private key of the served endpoints.")
+	serveCA      = flag.String("serve-client-ca", "",
+		"CA certificate authenticating clients of the served endpoints (mTLS).")
+	serveRate  = flag.Float64("serve-client-rate", 0, "Requests per second allowed per client, 0 is unlimited.")
+	serveBurst = flag.Int("serve-client-burst", 10, "Request burst allowed per client.")
+	replayAge  = flag.Duration("serve-replay", 0,
+		"How long events are kept for subscribers resuming with Last-Event-ID (default 5m).")
+	replayMax       = flag.Int("serve-replay-max", 0, "Maximum number of events kept for replay (default 65536).")
+	subscriberQueue = flag.Int("serve-subscriber-queue", 1024,
+		"Number of events buffered per subscriber before a slow subscriber is disconnected.")
+)
+
+// Bearer tokens are read from the environment to keep them out of `ps`.
+const serveTokensEnv = "GITHUB_FEED_SERVE_TOKENS"
+
+func serveConfig() *lib.ServeConfig {
+	conf := &lib.ServeConfig{
+		Addr:         *serveAddr,
+		TLSCertFile:  *serveTLSCert,
+		TLSKeyFile:   *serveTLSKey,
+		ClientCAFile: *serveCA,
+		ClientRate:   *serveRate,
+		ClientBurst:  *serveBurst,
+	}
+
+	if tokens := os.Getenv(serveTokensEnv); tokens != "" {
+		conf.BearerTokens = strings.Split(tokens, ",")
+	}
+
+	return conf
+}
+
+// startServer returns nil when serving is disabled.
+func startServer(ctx context.Context, records lib.Serializer) *lib.Broadcaster {
+	if *serveAddr == "" {
+		return nil
+	}
+
+	broadcaster := lib.NewBroadcaster(*replayAge, *replayMax)
+	broadcaster.SubscriberQueue = *subscriberQueue
+	broadcaster.Serializer = records
+
+	mux := http.NewServeMux()
+	mux.Handle("/events", broadcaster)
+	mux.Handle("/status", statusHandler(broadcaster))
+	mux.HandleFunc("/", serveUI)
+
+	conf := serveConfig()
+	conf.PublicPaths = []string{"/"}
+	go func() { fail(exitFeed, conf.ListenAndServe(ctx, mux)) }()
+
+	return broadcaster
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	serviceFlags       = flag.NewFlagSet("service", flag.ExitOnError)
+	serviceName        = serviceFlags.String("name", "github-feed", "Name of the service.")
+	serviceUser        = serviceFlags.String("user", "", "User running the service (systemd only).")
+	serviceEnvFile     = serviceFlags.String("env-file", "", "Environment file of the service, e.g. holding GITHUB_AUTH_TOKEN (systemd only).")
+	serviceStopTimeout = serviceFlags.Duration("stop-timeout", 30*time.Second, "Time given on stop to drain the sinks and checkpoint (systemd only).")
+	serviceRestartOn   = stringList(serviceFlags, "restart-on", "feed,sink",
+		"Comma separated classes of failure (feed, sink, auth, config) on which the service is restarted, 'all' or 'none'.")
+)
+
+// installService registers the daemon as a service started at boot, running
+// the executable with the arguments following the flags, e.g.
+//
+//	github-feed install-service -- serve -serve :8080 -archive /var/lib/github-feed
+//
+// Stopping the service drains the sinks and checkpoints the deduplication
+// state, as on SIGTERM. The service is restarted on the failures of the
+// classes of -restart-on, the others (e.g. a revoked token) would only fail
+// again.
+func installService(args []string) int {
+	fs := serviceFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed install-service [flags] -- [command] [flags]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed locating the executable: %v\n", err)
+		return 1
+	}
+
+	prevent, err := parseRestartOn(*serviceRestartOn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -restart-on: %v\n", err)
+		return exitUsage
+	}
+
+	if err := installServiceUnit(*serviceName, exe, fs.Args(), prevent); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed installing service '%s': %v\n", *serviceName, err)
+		return 1
+	}
+	return 0
+}
+
+// uninstallService stops and removes a service registered by installService.
+func uninstallService(args []string) int {
+	fs := serviceFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed uninstall-service [-name name]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if err := removeServiceUnit(*serviceName); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed uninstalling service '%s': %v\n", *serviceName, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const systemdUnitDir = "/etc/systemd/system"
+
+// runService reports whether the process ran as a service; systemd services
+// are regular processes stopped with SIGTERM.
+func runService() bool { return false }
+
+// installServiceUnit writes and enables the unit, prevent lists the exit
+// statuses on which it isn't restarted.
+func installServiceUnit(name, exe string, args []string, prevent []int) error {
+	var unit bytes.Buffer
+	fmt.Fprintf(&unit, "[Unit]\n")
+	fmt.Fprintf(&unit, "Description=GitHub events feed (%s)\n", name)
+	fmt.Fprintf(&unit, "Wants=network-online.target\n")
+	fmt.Fprintf(&unit, "After=network-online.target\n\n")
+	fmt.Fprintf(&unit, "[Service]\n")
+	fmt.Fprintf(&unit, "ExecStart=%s\n", systemdCommand(append([]string{exe}, args...)))
+	if len(prevent) < len(exitClasses) {
+		fmt.Fprintf(&unit, "Restart=on-failure\n")
+		if len(prevent) > 0 {
+			fmt.Fprintf(&unit, "RestartPreventExitStatus=%s\n", exitStatusList(prevent))
+		}
+	}
+	fmt.Fprintf(&unit, "KillSignal=SIGTERM\n")
+	fmt.Fprintf(&unit, "TimeoutStopSec=%d\n", int(serviceStopTimeout.Seconds()))
+	if *serviceUser != "" {
+		fmt.Fprintf(&unit, "User=%s\n", *serviceUser)
+	}
+	if *serviceEnvFile != "" {
+		path, err := filepath.Abs(*serviceEnvFile)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&unit, "EnvironmentFile=%s\n", path)
+	}
+	fmt.Fprintf(&unit, "\n[Install]\nWantedBy=multi-user.target\n")
+
+	path := filepath.Join(systemdUnitDir, name+".service")
+	if err := ioutil.WriteFile(path, unit.Bytes(), 0644); err != nil {
+		return err
+	}
+	log.Printf("Wrote %s", path)
+
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl("enable", name+".service")
+}
+
+func removeServiceUnit(name string) error {
+	path := filepath.Join(systemdUnitDir, name+".service")
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	if err := systemctl("disable", "--now", name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	log.Printf("Removed %s", path)
+
+	return systemctl("daemon-reload")
+}
+
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("systemctl %s: %v", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// systemdCommand quotes a command line for ExecStart, escaping specifiers.
+func systemdCommand(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		arg = strings.Replace(arg, "%", "%%", -1)
+		arg = strings.Replace(arg, "$", "$$", -1)
+		if arg == "" || strings.ContainsAny(arg, " \t\"';\\") {
+			arg = strings.Replace(arg, `\`, `\\`, -1)
+			arg = `"` + strings.Replace(arg, `"`, `\"`, -1) + `"`
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
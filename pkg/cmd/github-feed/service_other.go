@@ -0,0 +1,19 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func runService() bool { return false }
+
+func installServiceUnit(name, exe string, args []string, prevent []int) error {
+	return fmt.Errorf("services are not supported on %s", runtime.GOOS)
+}
+
+func removeServiceUnit(name string) error {
+	return fmt.Errorf("services are not supported on %s", runtime.GOOS)
+}
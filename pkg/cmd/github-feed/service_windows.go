@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// runService runs the command line as a Windows service when started by the
+// service manager and reports whether it did.
+func runService() bool {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil || interactive {
+		return false
+	}
+
+	if err := svc.Run(*serviceName, &service{args: os.Args[1:]}); err != nil {
+		log.Fatal(err)
+	}
+	return true
+}
+
+// service adapts run to the service manager, a stop request drains the sinks
+// and checkpoints like SIGTERM.
+type service struct {
+	args []string
+}
+
+func (s *service) Execute(_ []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.StartPending}
+
+	done := make(chan int, 1)
+	go func() { done <- run(s.args) }()
+
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case code := <-done:
+			return code != exitOK, uint32(code)
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				changes <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				requestShutdown()
+				code := <-done
+				return code != exitOK, uint32(code)
+			}
+		}
+	}
+}
+
+// installServiceUnit creates the service, which the service manager restarts
+// on any failure unless prevent lists every class: it doesn't filter on exit
+// statuses.
+func installServiceUnit(name, exe string, args []string, prevent []int) error {
+	if *serviceUser != "" || *serviceEnvFile != "" {
+		return errors.New("-user and -env-file are not supported on windows")
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service already exists")
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: name,
+		Description: "GitHub events feed",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// Mirrors Restart=on-failure of the systemd unit, the exit status of run
+	// is reported as a service-specific error.
+	if len(prevent) < len(exitClasses) {
+		restart := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}
+		if err := s.SetRecoveryActions(restart, 24*60*60); err != nil {
+			return err
+		}
+		if err := setRecoveryOnNonCrashFailures(s); err != nil {
+			return err
+		}
+	}
+	log.Printf("Installed service '%s'", name)
+
+	return nil
+}
+
+// setRecoveryOnNonCrashFailures applies the recovery actions when the
+// service stops with an error, not only when it crashes.
+func setRecoveryOnNonCrashFailures(s *mgr.Service) error {
+	// SERVICE_FAILURE_ACTIONS_FLAG, fFailureActionsOnNonCrashFailures.
+	flag := uint32(1)
+	return windows.ChangeServiceConfig2(s.Handle, windows.SERVICE_CONFIG_FAILURE_ACTIONS_FLAG, (*byte)(unsafe.Pointer(&flag)))
+}
+
+func removeServiceUnit(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if _, err := s.Control(svc.Stop); err != nil {
+		log.Printf("Failed stopping service '%s': %v", name, err)
+	}
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	log.Printf("Removed service '%s'", name)
+
+	return nil
+}
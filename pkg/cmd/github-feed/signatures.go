@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	commitSignatures = stringList(flag.CommandLine, "commit-signatures", "",
+		"Comma separated list of repositories whose pushed commits are checked for a verified signature, reported "+
+			"on -admin /metrics, use '*' to check every repository.")
+	commitSignatureBudget = flag.Int("commit-signature-budget", 0,
+		"Git Database API requests per hour spent by -commit-signatures (default 1000).")
+)
+
+// startCommitSignatures checks the signatures of the pushed commits with the
+// feed's client, nil without -commit-signatures. The checks are scheduled by
+// enrichment if not nil.
+func startCommitSignatures(ctx context.Context, feed *lib.EventFeed, enrichment *lib.EnrichmentScheduler) *lib.SignatureChecker {
+	if *commitSignatures == "" {
+		return nil
+	}
+
+	checker := lib.NewSignatureChecker(feed.Client(), trackedList(*commitSignatures), *commitSignatureBudget, nil)
+	checker.ReportError = feed.ReportError
+	checker.Scheduler = enrichment
+
+	go checker.Serve(ctx)
+	return checker
+}
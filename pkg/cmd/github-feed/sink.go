@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+var (
+	batchRecords = flag.Int("sink-batch-records", 0, "Maximum number of events per sink write, 0 is unbounded.")
+	batchBytes   = flag.Int("sink-batch-bytes", 0, "Maximum estimated size of a sink write in bytes, 0 is unbounded.")
+	batchLatency = flag.Duration("sink-batch-latency", 0,
+		"Maximum time events are buffered before being written, enables batching across feed batches.")
+	sinkWorkers  = flag.Int("sink-workers", 1, "Number of concurrent sink writers.")
+	sinkQueue    = flag.Int("sink-queue", 64, "Number of batches buffered per sink writer when -sink-workers > 1.")
+	sinkOrderKey = flag.String("sink-order-key", "repo.name",
+		"Key (see lib.KeySpec) whose events are written in order when -sink-workers > 1 or with -sink-priority, e.g. 'actor.login'.")
+	sinkPriority = flag.String("sink-priority", "",
+		"Filter expression of the events written ahead of those of the other -sink-order-key during a backlog, e.g. 'type=ReleaseEvent'.")
+	outputSinks = stringList(flag.CommandLine, "sink", "",
+		"Comma separated outputs of the emitted events, replacing stdout: stdout, "+
+			"file:///path/prefix?rotate=1h&max-size=100M&gzip=true, http(s)://host/path[?gzip=true&max-payload=256K&cloudevents=binary|structured|batch&secret-env=NAME], "+
+			"webhook+http(s)://host/path[?secret-env=NAME], "+
+			"nats://[user:pass@]host:4222/subject, kafka://[user:pass@]rest-proxy:8082/topic[?tls=true&max-payload=1M], "+
+			"eventbridge://bus?region=us-east-1[&source=name] "+
+			"or eventhubs://[key-name:key@]namespace/hub[?partition-key=repo.name&client-id=id].")
+	sinkSpillDir = flag.String("sink-spill-dir", "",
+		"Directory of the files the sinks' writes are spilled to past -sink-spill-memory, none if empty.")
+	sinkSpillMemory = byteSize(64 << 20)
+)
+
+func init() {
+	flag.Var(&sinkSpillMemory, "sink-spill-memory",
+		"Estimated size of the writes queued in memory per sink before spilling to -sink-spill-dir, e.g. 64M.")
+}
+
+// Characters replaced in the sinks' names to name their spill files.
+var spillFileName = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// newSink returns the archive sink, retrying, parallelizing and batching its
+// writes as configured, or nil when archiving is disabled.
+func newSink(ctx context.Context, errs func(error)) (lib.Sink, error) {
+	sink, err := newArchiveSink(ctx)
+	if sink == nil || err != nil {
+		return sink, err
+	}
+
+	return wrapSink(archiveSinkName, sink, errs)
+}
+
+// outputURLs returns the outputs of -sink.
+func outputURLs() []string {
+	if *outputSinks == "" {
+		return nil
+	}
+	return strings.Split(*outputSinks, ",")
+}
+
+// newOutputs returns the sinks of -sink, wrapped like the archive's. They are
+// named by their redacted URL in the metrics and the dead letter queue.
+func newOutputs(records lib.Serializer, errs func(error)) ([]lib.Sink, error) {
+	var outputs []lib.Sink
+	for _, u := range outputURLs() {
+		sink, err := lib.OpenSink(u, records)
+		if err == nil {
+			allowEgress(sink)
+			sink, err = wrapSink(lib.Redact(u), sink, errs)
+		}
+		if err != nil {
+			for _, s := range outputs {
+				s.Close()
+			}
+			return nil, err
+		}
+		outputs = append(outputs, sink)
+	}
+	return outputs, nil
+}
+
+// wrapSink instruments the sink, retries its failed writes and parallelizes,
+// spills and batches them as configured.
+func wrapSink(name string, sink lib.Sink, errs func(error)) (lib.Sink, error) {
+	sink, err := withDeadLetters(name, lib.NewInstrumentedSink(name, sink))
+	if err != nil {
+		return nil, err
+	}
+
+	var priority func(*github.Event) bool
+	var key *lib.KeySpec
+	if *sinkPriority != "" {
+		filter, err := lib.ParseFilter(*sinkPriority)
+		if err != nil {
+			return nil, err
+		}
+		priority = filter.Match
+	}
+
+	// Priority events overtake the others of other keys in the workers'
+	// queues, which are needed even with a single worker.
+	if *sinkWorkers > 1 || priority != nil {
+		if key, err = lib.ParseKeySpec(*sinkOrderKey); err != nil {
+			return nil, err
+		}
+
+		sharded := lib.NewShardedSink(sink, key, *sinkWorkers, *sinkQueue)
+		sharded.OnError = errs
+		sharded.Priority = priority
+		sink = sharded
+	}
+
+	// Spilling last, so that a slow sink queues the batched writes.
+	if *sinkSpillDir != "" {
+		path := filepath.Join(*sinkSpillDir, spillFileName.ReplaceAllString(name, "_")+".spill")
+		spilling, err := lib.NewSpillingSink(name, sink, path, int(sinkSpillMemory))
+		if err != nil {
+			return nil, err
+		}
+		spilling.OnError = errs
+		sink = spilling
+	}
+
+	policy := lib.BatchPolicy{MaxRecords: *batchRecords, MaxBytes: *batchBytes, MaxLatency: *batchLatency}
+	if policy == (lib.BatchPolicy{}) {
+		return sink, nil
+	}
+
+	batching := lib.NewBatchingSink(sink, policy)
+	batching.OnError = errs
+	batching.Priority = priority
+	batching.Key = key
+	return batching, nil
+}
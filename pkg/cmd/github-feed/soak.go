@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+var (
+	soakDuration = flag.Duration("soak", 0,
+		"Run the pipeline for this long against the recorded traffic of -soak-input instead of GitHub, "+
+			"failing if a resource ceiling is exceeded.")
+	soakInput = stringList(flag.CommandLine, "soak-input", "",
+		"Comma separated archive files or directories replayed in a loop by -soak.")
+	soakInterval  = flag.Duration("soak-interval", time.Second, "Interval between the replayed batches.")
+	maxRSS        = byteSize(0)
+	maxGoroutines = flag.Int("max-goroutines", 0, "Goroutine ceiling of -soak, 0 is unlimited.")
+	soakDumpDir   = flag.String("soak-dump", ".", "Directory where the diagnostic dump is written when a ceiling is exceeded.")
+)
+
+func init() {
+	flag.Var(&maxRSS, "max-rss", "Resident memory ceiling of -soak, e.g. 1G, 0 is unlimited.")
+}
+
+const (
+	soakBatchSize      = 300
+	soakSampleInterval = 10 * time.Second
+	soakSamplesKept    = 360
+)
+
+// byteSize is a flag.Value accepting sizes with a K, M, G or T suffix
+// (powers of 1024).
+type byteSize uint64
+
+func (b *byteSize) String() string {
+	return strconv.FormatUint(uint64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	mult := uint64(1)
+	if i := strings.IndexAny(s, "KMGTkmgt"); i >= 0 && i == len(s)-1 {
+		mult = 1 << (10 * uint(strings.IndexByte("KMGT", strings.ToUpper(s[i:])[0])+1))
+		s = s[:i]
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*b = byteSize(n * mult)
+	return nil
+}
+
+// soakBatches reads the recorded events of the inputs, in batches.
+func soakBatches(paths []string) ([][]*github.Event, error) {
+	var files []string
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() && (path == root || isArchive(path)) {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var batches [][]*github.Event
+	var batch []*github.Event
+	for _, path := range files {
+		r, err := openArchive(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var expander lib.RecordExpander
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			line, err := expander.Expand(scanner.Bytes())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+
+			var record lib.Record
+			if err := json.Unmarshal(line, &record); err != nil || record.Event == nil {
+				continue
+			}
+
+			batch = append(batch, record.Event)
+			if len(batch) == soakBatchSize {
+				batches = append(batches, batch)
+				batch = nil
+			}
+		}
+		err = scanner.Err()
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	if len(batches) == 0 {
+		return nil, fmt.Errorf("no recorded events in %s", strings.Join(paths, ", "))
+	}
+
+	return batches, nil
+}
+
+// soakMonitor samples the process and enforces the ceilings.
+type soakMonitor struct {
+	mu      sync.Mutex
+	samples []lib.RuntimeSnapshot
+	peak    lib.RuntimeSnapshot
+}
+
+func snapshotRSS(s lib.RuntimeSnapshot) uint64 {
+	// Fall back on the memory obtained by the runtime where RSS is unknown.
+	if s.RSS == 0 {
+		return s.Sys
+	}
+	return s.RSS
+}
+
+func (m *soakMonitor) sample() (lib.RuntimeSnapshot, string) {
+	snap := lib.TakeSnapshot()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.samples = append(m.samples, snap)
+	if len(m.samples) > soakSamplesKept {
+		m.samples = m.samples[1:]
+	}
+	if snapshotRSS(snap) > snapshotRSS(m.peak) {
+		m.peak.RSS, m.peak.Sys = snap.RSS, snap.Sys
+	}
+	if snap.Goroutines > m.peak.Goroutines {
+		m.peak.Goroutines = snap.Goroutines
+	}
+
+	switch {
+	case maxRSS > 0 && snapshotRSS(snap) > uint64(maxRSS):
+		return snap, fmt.Sprintf("RSS %d above ceiling %d", snapshotRSS(snap), maxRSS)
+	case *maxGoroutines > 0 && snap.Goroutines > *maxGoroutines:
+		return snap, fmt.Sprintf("%d goroutines above ceiling %d", snap.Goroutines, *maxGoroutines)
+	}
+
+	return snap, ""
+}
+
+// dump writes the goroutines, a heap profile and the samples.
+func (m *soakMonitor) dump(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	for name, debug := range map[string]int{"goroutine": 2, "heap": 0} {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("soak-%s-%s.pprof", stamp, name)))
+		if err != nil {
+			return err
+		}
+		err = pprof.Lookup(name).WriteTo(f, debug)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	b, err := json.MarshalIndent(m.samples, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("soak-%s-snapshots.json", stamp)), b, 0644)
+}
+
+func (m *soakMonitor) watch(ctx context.Context) {
+	ticker := time.NewTicker(soakSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			snap, exceeded := m.sample()
+			if exceeded == "" {
+				continue
+			}
+
+			log.Printf("Soak failed: %s (heap %d, %d goroutines)", exceeded, snap.HeapAlloc, snap.Goroutines)
+			if err := m.dump(*soakDumpDir); err != nil {
+				log.Printf("Failed writing the diagnostic dump: %v", err)
+			} else {
+				log.Printf("Diagnostic dump written in %s", *soakDumpDir)
+			}
+			os.Exit(1)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// startSoak replays the recorded traffic in a loop for the soak duration,
+// the events of each loop get distinct IDs so that they aren't deduplicated.
+func startSoak() (<-chan []*github.Event, error) {
+	if *soakInput == "" {
+		return nil, fmt.Errorf("-soak requires -soak-input")
+	}
+
+	batches, err := soakBatches(strings.Split(*soakInput, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *soakDuration)
+
+	monitor := &soakMonitor{}
+	go monitor.watch(ctx)
+
+	events := make(chan []*github.Event)
+	go func() {
+		defer cancel()
+		defer close(events)
+
+		ticker := time.NewTicker(*soakInterval)
+		defer ticker.Stop()
+
+		for loop := 0; ; loop++ {
+			for _, recorded := range batches {
+				batch := make([]*github.Event, 0, len(recorded))
+				for _, ev := range recorded {
+					replayed := *ev
+					replayed.ID = github.String(fmt.Sprintf("%s-%d", ev.GetID(), loop))
+					batch = append(batch, &replayed)
+				}
+
+				select {
+				case events <- batch:
+				case <-ctx.Done():
+					monitor.sample()
+					monitor.mu.Lock()
+					log.Printf("Soak of %v passed after %d loops: peak RSS %d, peak goroutines %d",
+						*soakDuration, loop, snapshotRSS(monitor.peak), monitor.peak.Goroutines)
+					monitor.mu.Unlock()
+					return
+				}
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
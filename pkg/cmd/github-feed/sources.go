@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var sourcesState = flag.String("sources-state", "",
+	"State file of the sources added and removed on /sources of the -admin endpoint, polled instead of -sources once saved.")
+
+// openSourceManager loads the sources persisted in -sources-state, which
+// replace the configured ones. The manager is kept in memory for
+// -discover-org and -discover-topics without state file.
+func openSourceManager(conf *lib.Config) (*lib.SourceManager, error) {
+	if *sourcesState == "" && !repoDiscovery() {
+		return nil, nil
+	}
+
+	manager, err := lib.NewSourceManager(*sourcesState)
+	if err != nil {
+		return nil, err
+	}
+	if sources := manager.Sources(); sources != nil {
+		log.Printf("Polling the %d sources of %s", len(sources), *sourcesState)
+		conf.Sources = sources
+	}
+	return manager, nil
+}
+
+// startSourceManager lets the manager change the sources of the feed, and
+// exposes them on the admin endpoint if enabled.
+func startSourceManager(admin *http.ServeMux, feed *lib.EventFeed, manager *lib.SourceManager) {
+	if manager == nil {
+		return
+	}
+
+	manager.Manage(feed)
+	if admin != nil {
+		admin.Handle("/sources", manager)
+	}
+}
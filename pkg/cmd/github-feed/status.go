@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// pipelineStatus tracks the health of the feed for the /status endpoint.
+type pipelineStatus struct {
+	mu        sync.Mutex
+	started   time.Time
+	lastBatch time.Time
+	batches   uint64
+	events    uint64
+	errors    uint64
+	lastError string
+}
+
+var status = &pipelineStatus{started: time.Now()}
+
+func (s *pipelineStatus) batch(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastBatch = time.Now()
+	s.batches++
+	s.events += uint64(n)
+}
+
+func (s *pipelineStatus) error(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.errors++
+	s.lastError = lib.Redact(err.Error())
+}
+
+type statusReport struct {
+	Uptime    string             `json:"uptime"`
+	LastBatch time.Time          `json:"last_batch"`
+	Batches   uint64             `json:"batches"`
+	Events    uint64             `json:"events"`
+	Errors    uint64             `json:"errors"`
+	LastError string             `json:"last_error,omitempty"`
+	Stream    lib.BroadcastStats `json:"stream"`
+}
+
+func statusHandler(broadcaster *lib.Broadcaster) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status.mu.Lock()
+		report := statusReport{
+			Uptime:    time.Since(status.started).Round(time.Second).String(),
+			LastBatch: status.lastBatch,
+			Batches:   status.batches,
+			Events:    status.events,
+			Errors:    status.errors,
+			LastError: status.lastError,
+		}
+		status.mu.Unlock()
+
+		report.Stream = broadcaster.Stats()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+const (
+	// Log lines kept for the support bundles, see -admin.
+	recentLogLines = 1000
+	bundleTimeout  = 20 * time.Second
+)
+
+var (
+	bundleOutput = flag.String("bundle-output", "",
+		"Archive written by support-bundle, - for stdout (default github-feed-support-<time>.tar.gz).")
+	bundleAdminURL = flag.String("bundle-admin-url", "",
+		"URL of the -admin endpoint of the running feed whose logs, metrics and API responses support-bundle collects (default from -admin).")
+)
+
+// recentLogs are the last lines logged by the process, served on
+// /debug/logs of the admin endpoint.
+var recentLogs = lib.NewRecentLogs(recentLogLines)
+
+// supportBundle writes an archive describing the feed configured by the same
+// flags, to attach to the reports of problems, e.g.
+//
+//	github-feed support-bundle -admin :6060 -dedup-state /var/lib/github-feed/dedup
+//
+// It holds the version, the effective configuration, the stats of the state
+// files, and from the admin endpoint of the running feed its recent logs,
+// metrics and GitHub API responses. Every file is redacted (see lib.Redact),
+// what couldn't be collected is listed in errors.txt.
+func supportBundle(args []string) int {
+	if err := parseFlags(flag.CommandLine, args); err != nil {
+		return exitWith(exitConfig, err)
+	}
+
+	// Redacted from the files, on top of the credentials of the URLs.
+	for _, name := range secretEnv {
+		for _, secret := range splitList(os.Getenv(name)) {
+			lib.RegisterSecret(secret)
+		}
+	}
+
+	var bundle bytes.Buffer
+	gz := gzip.NewWriter(&bundle)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	add := func(name string, data []byte) error {
+		data = []byte(lib.Redact(string(data)))
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	var problems []string
+	var config bytes.Buffer
+	if err := writeConfig(&config, "feed", flag.CommandLine); err != nil {
+		return exitWith(exitFeed, err)
+	}
+	state, err := json.MarshalIndent(bundleStates(), "", "  ")
+	if err != nil {
+		return exitWith(exitFeed, err)
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"version.txt", bundleVersion()},
+		{"config.json", config.Bytes()},
+		{"state.json", append(state, '\n')},
+	}
+
+	admin := adminURL()
+	for _, f := range []struct{ name, path string }{
+		{"logs.txt", "/debug/logs"},
+		{"metrics.txt", "/metrics"},
+		{"responses.json", "/debug/responses"},
+	} {
+		if admin == "" {
+			problems = append(problems, fmt.Sprintf("%s: no -admin endpoint to collect it from", f.name))
+			continue
+		}
+		data, err := fetchAdmin(admin + f.path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", f.name, err))
+			continue
+		}
+		files = append(files, struct {
+			name string
+			data []byte
+		}{f.name, data})
+	}
+	if len(problems) > 0 {
+		files = append(files, struct {
+			name string
+			data []byte
+		}{"errors.txt", []byte(strings.Join(problems, "\n") + "\n")})
+	}
+
+	dir := "github-feed-support-" + now.UTC().Format("20060102T150405Z")
+	for _, f := range files {
+		if err := add(dir+"/"+f.name, f.data); err != nil {
+			return exitWith(exitFeed, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return exitWith(exitFeed, err)
+	}
+	if err := gz.Close(); err != nil {
+		return exitWith(exitFeed, err)
+	}
+
+	output := *bundleOutput
+	switch output {
+	case "-":
+		_, err = os.Stdout.Write(bundle.Bytes())
+	case "":
+		output = dir + ".tar.gz"
+		fallthrough
+	default:
+		err = ioutil.WriteFile(output, bundle.Bytes(), 0600)
+	}
+	if err != nil {
+		return exitWith(exitFeed, err)
+	}
+
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "Not collected: %s\n", lib.Redact(p))
+	}
+	if output != "-" {
+		fmt.Fprintf(os.Stderr, "Wrote %s, check it before sharing it.\n", output)
+	}
+	return exitOK
+}
+
+func bundleVersion() []byte {
+	var b bytes.Buffer
+	fmt.Fprintln(&b, versionString())
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&b, "\n%s", info)
+	}
+	return b.Bytes()
+}
+
+// bundleState describes a state file or directory of the feed.
+type bundleState struct {
+	Flag     string     `json:"flag"`
+	Path     string     `json:"path"`
+	Exists   bool       `json:"exists"`
+	Size     int64      `json:"size"`
+	Files    int        `json:"files,omitempty"`
+	Modified *time.Time `json:"modified,omitempty"`
+	// Checks of the state files, see fsck.
+	Version     uint16   `json:"version,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	BackupError string   `json:"backup_error,omitempty"`
+	StaleTemps  []string `json:"stale_temps,omitempty"`
+}
+
+// bundleStates returns the state of the feed: its state files, checked
+// like fsck does, the dead letters and the spill directories.
+func bundleStates() []bundleState {
+	var states []bundleState
+	for _, s := range []struct {
+		flag, path string
+		stateFile  bool
+	}{
+		{"state-dir", checkpointPath(), true},
+		{"dedup-state", *dedupState, true},
+		{"annotations", *annotationsState, true},
+		{"new-contributors-state", *newContributorsState, true},
+		{"repo-snapshots", *repoSnapshots, true},
+		{"dead-letters", *deadLetters, false},
+		{"feed-spill-dir", feedConfig().SpillDir, false},
+		{"sink-spill-dir", *sinkSpillDir, false},
+	} {
+		if s.path == "" {
+			continue
+		}
+
+		state := bundleState{Flag: "-" + s.flag, Path: s.path}
+		info, err := os.Stat(s.path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				state.Error = err.Error()
+			}
+			states = append(states, state)
+			continue
+		}
+		modified := info.ModTime()
+		state.Exists, state.Size, state.Modified = true, info.Size(), &modified
+
+		switch {
+		case info.IsDir():
+			state.Size = 0
+			filepath.Walk(s.path, func(path string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					state.Files++
+					state.Size += info.Size()
+				}
+				return nil
+			})
+		case s.stateFile:
+			report := lib.CheckStateFile(s.path, false)
+			state.Version, state.StaleTemps = report.Version, report.StaleTemps
+			if report.Err != nil {
+				state.Error = report.Err.Error()
+			}
+			if report.BackupErr != nil {
+				state.BackupError = report.BackupErr.Error()
+			}
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// adminURL returns the URL of the admin endpoint, of -bundle-admin-url or
+// -admin, empty without.
+func adminURL() string {
+	if *bundleAdminURL != "" {
+		return strings.TrimSuffix(*bundleAdminURL, "/")
+	}
+	if *adminAddr == "" {
+		return ""
+	}
+
+	scheme, addr := "http", *adminAddr
+	if *serveTLSCert != "" {
+		scheme = "https"
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "localhost" + addr
+	}
+	return scheme + "://" + addr
+}
+
+// fetchAdmin gets a page of the admin endpoint, authenticated with the
+// first token of GITHUB_FEED_SERVE_TOKENS if set.
+func fetchAdmin(url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bundleTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if tokens := os.Getenv(serveTokensEnv); tokens != "" {
+		req.Header.Set("Authorization", "Bearer "+strings.Split(tokens, ",")[0])
+	}
+
+	rep, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+
+	body, err := ioutil.ReadAll(rep.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rep.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, rep.Status)
+	}
+	return body, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	testFilterFlags    = flag.NewFlagSet("test-filter", flag.ExitOnError)
+	testFilterExpr     = testFilterFlags.String("filter", "", "Filter expression tested, see -filter of the feed.")
+	testFilterFixtures = testFilterFlags.String("fixtures", "",
+		"NDJSON file (optionally gzipped) of the events the filter is evaluated against, GitHub events or emitted records.")
+	testFilterExpect    = testFilterFlags.Int("expect", -1, "Number of events expected to match, unchecked if negative.")
+	testFilterExpectIDs = stringList(testFilterFlags, "expect-ids", "",
+		"Comma separated IDs of the events expected to match, the others are expected not to.")
+	testFilterVerbose = testFilterFlags.Bool("v", false, "List the matching events.")
+)
+
+// testFilter evaluates a filter expression against fixture events and
+// checks the matches against the expectations, so that a rule change can be
+// validated before it is deployed, e.g.
+//
+//	github-feed test-filter -filter 'type=PullRequestEvent action=opened' -fixtures events.ndjson -expect 12
+//
+// It exits with status 1 when the matches differ from the expectations.
+func testFilter(args []string) int {
+	fs := testFilterFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed test-filter -filter expr -fixtures file [-expect n] [-expect-ids id,...] [-v]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *testFilterFixtures == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	filter, err := lib.ParseFilter(*testFilterExpr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-filter: %v\n", err)
+		return exitUsage
+	}
+
+	events, err := readFixtures(*testFilterFixtures)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *testFilterFixtures, err)
+		return exitUsage
+	}
+
+	expected := make(map[string]bool)
+	for _, id := range splitList(*testFilterExpectIDs) {
+		expected[id] = true
+	}
+
+	var matches int
+	var unexpected, missed []string
+	for _, record := range events {
+		id := record.GetID()
+		match := filter.Match(record.Event)
+		if match {
+			matches++
+			if *testFilterVerbose {
+				fmt.Printf("match %s %s %s %s\n", id, record.GetType(), record.GetActor().GetLogin(), record.GetRepo().GetName())
+			}
+		}
+
+		if *testFilterExpectIDs == "" {
+			continue
+		}
+		if match && !expected[id] {
+			unexpected = append(unexpected, id)
+		} else if !match && expected[id] {
+			missed = append(missed, id)
+		}
+		delete(expected, id)
+	}
+
+	fmt.Printf("%d of %d events match\n", matches, len(events))
+
+	status := 0
+	if *testFilterExpect >= 0 && matches != *testFilterExpect {
+		fmt.Printf("FAIL: expected %d matching events\n", *testFilterExpect)
+		status = 1
+	}
+	for _, m := range []struct {
+		what string
+		ids  []string
+	}{
+		{"matched, expected not to", unexpected},
+		{"didn't match, expected to", missed},
+		{"expected to match, missing from the fixtures", sortedKeys(expected)},
+	} {
+		if len(m.ids) > 0 {
+			fmt.Printf("FAIL: %d events %s: %v\n", len(m.ids), m.what, m.ids)
+			status = 1
+		}
+	}
+	if status == 0 && (*testFilterExpect >= 0 || *testFilterExpectIDs != "") {
+		fmt.Println("ok")
+	}
+
+	return status
+}
+
+// readFixtures reads the events of an NDJSON file, either GitHub events or
+// records, which embed them.
+func readFixtures(path string) ([]*lib.Record, error) {
+	r, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var events []*lib.Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var record lib.Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if record.Event == nil {
+			return nil, fmt.Errorf("line %d: not an event", n)
+		}
+		events = append(events, &record)
+	}
+
+	return events, scanner.Err()
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// Trackers state is saved at most this often, it can be large.
+const trackerSaveInterval = time.Minute
+
+var (
+	starVelocity = stringList(flag.CommandLine, "star-velocity", "",
+		"Comma separated list of repositories for which star velocity records (stars per hour, acceleration) "+
+			"are emitted instead of raw events, use '*' to track every repository.")
+	ciOutcomes = stringList(flag.CommandLine, "ci-outcomes", "",
+		"Comma separated list of repositories for which the CI outcomes of pushed commits are emitted "+
+			"instead of raw events, use '*' to track every repository.")
+	dora = stringList(flag.CommandLine, "dora", "",
+		"Comma separated list of repositories for which DORA metrics records are emitted every -dora-period "+
+			"instead of raw events, use '*' to track every repository.")
+	doraPeriod      = flag.Duration("dora-period", 24*time.Hour, "Period over which the -dora metrics are computed.")
+	newContributors = stringList(flag.CommandLine, "new-contributors", "",
+		"Comma separated list of repositories for which the first contributions (pull request or push) of actors "+
+			"are emitted instead of raw events, use '*' to track every repository.")
+	newContributorsState = flag.String("new-contributors-state", "",
+		"State file where -new-contributors remembers the known contributors across restarts.")
+	newContributorsWarmup = flag.Duration("new-contributors-warmup", 24*time.Hour,
+		"Time during which -new-contributors learns the contributors without emitting records, when starting without state.")
+	newContributorsCapacity = flag.Int("new-contributors-capacity", 1000000,
+		"Number of contributors remembered by -new-contributors, the least recently active are forgotten.")
+	emailDomains = stringList(flag.CommandLine, "email-domains", "",
+		"Comma separated list of repositories for which the commit email domains of pushes are aggregated in records "+
+			"emitted every -email-domains-period instead of raw events, use '*' to track every repository.")
+	emailDomainsPeriod     = flag.Duration("email-domains-period", 24*time.Hour, "Period over which -email-domains aggregates the commits.")
+	emailDomainsMinAuthors = flag.Int("email-domains-min-authors", 3,
+		"Minimum number of distinct authors of a domain within -email-domains-period for its record to be emitted.")
+	directPushes = stringList(flag.CommandLine, "direct-pushes", "",
+		"Comma separated list of organizations or repositories for which the pushes to the default branch not merging "+
+			"a pull request are alerted on instead of emitting raw events, use '*' to watch everything.")
+	abuse = stringList(flag.CommandLine, "abuse", "",
+		"Comma separated list of organizations or repositories for which abuse alerts (mass issues, identical comments, "+
+			"fork and pull request waves) are emitted instead of raw events, use '*' to watch everything.")
+	abuseWindow     = flag.Duration("abuse-window", time.Hour, "Sliding window over which -abuse counts the occurrences of a pattern.")
+	abuseThresholds = stringList(flag.CommandLine, "abuse-thresholds", "",
+		"Comma separated occurrences within -abuse-window raising an alert, among issues (default 10), comments (5) "+
+			"and fork-prs (10), e.g. 'issues=20,fork-prs=5'.")
+)
+
+// trackedList splits the comma separated accounts or repositories of a
+// tracker flag, '*' (all) is empty.
+func trackedList(s string) []string {
+	if s == "*" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
+
+// recordTracker emits derived records instead of raw events.
+type recordTracker struct {
+	track func(events []*github.Event) []interface{}
+	// Persists the state of the tracker, nil if it has none.
+	persist  func() error
+	lastSave time.Time
+}
+
+func (t *recordTracker) save() {
+	if t.persist == nil {
+		return
+	}
+	if err := t.persist(); err != nil {
+		log.Printf("Failed saving tracker state: %v", err)
+	}
+	t.lastSave = time.Now()
+}
+
+// checkpoint saves the state of the tracker if it wasn't recently.
+func (t *recordTracker) checkpoint() {
+	if time.Since(t.lastSave) >= trackerSaveInterval {
+		t.save()
+	}
+}
+
+// Snapshots of the repositories giving their default branch to
+// -direct-pushes, nil without -repo-snapshots.
+var trackerSnapshots *lib.RepoSnapshotter
+
+// newRecordTracker returns the tracker selected by -sponsorships,
+// -star-velocity, -ci-outcomes, -dora, -new-contributors, -email-domains,
+// -direct-pushes or -abuse, nil if none is.
+func newRecordTracker() (*recordTracker, error) {
+	var selected []string
+	var tracker *recordTracker
+
+	if *sponsorships != "" {
+		t := lib.NewSponsorshipTracker(trackedList(*sponsorships))
+		selected = append(selected, "-sponsorships")
+		tracker = &recordTracker{track: func(events []*github.Event) (records []interface{}) {
+			for _, r := range t.Track(events) {
+				records = append(records, r)
+			}
+			return records
+		}}
+	}
+	if *starVelocity != "" {
+		t := lib.NewStarVelocityTracker(trackedList(*starVelocity))
+		selected = append(selected, "-star-velocity")
+		tracker = &recordTracker{track: func(events []*github.Event) (records []interface{}) {
+			for _, r := range t.Track(events) {
+				records = append(records, r)
+			}
+			return records
+		}}
+	}
+	if *ciOutcomes != "" {
+		t := lib.NewCITracker(trackedList(*ciOutcomes))
+		selected = append(selected, "-ci-outcomes")
+		tracker = &recordTracker{track: func(events []*github.Event) (records []interface{}) {
+			for _, r := range t.Track(events) {
+				records = append(records, r)
+			}
+			return records
+		}}
+	}
+	if *dora != "" {
+		t := lib.NewDORATracker(trackedList(*dora))
+		t.Period = *doraPeriod
+		selected = append(selected, "-dora")
+		tracker = &recordTracker{track: func(events []*github.Event) (records []interface{}) {
+			for _, r := range t.Track(events) {
+				records = append(records, r)
+			}
+			return records
+		}}
+	}
+	if *newContributors != "" {
+		t, err := lib.NewContributorTracker(trackedList(*newContributors), *newContributorsState, *newContributorsCapacity)
+		if err != nil {
+			return nil, err
+		}
+		t.Warmup = *newContributorsWarmup
+		selected = append(selected, "-new-contributors")
+		tracker = &recordTracker{track: func(events []*github.Event) (records []interface{}) {
+			for _, r := range t.Track(events) {
+				records = append(records, r)
+			}
+			return records
+		}, persist: t.Save, lastSave: time.Now()}
+	}
+	if *emailDomains != "" {
+		t := lib.NewEmailDomainTracker(trackedList(*emailDomains))
+		t.Period, t.MinAuthors = *emailDomainsPeriod, *emailDomainsMinAuthors
+		selected = append(selected, "-email-domains")
+		tracker = &recordTracker{track: func(events []*github.Event) (records []interface{}) {
+			for _, r := range t.Track(events) {
+				records = append(records, r)
+			}
+			return records
+		}}
+	}
+	if *directPushes != "" {
+		d := lib.NewDirectPushDetector(trackedList(*directPushes))
+		d.DefaultBranch = func(repo string) string {
+			if trackerSnapshots == nil {
+				return ""
+			}
+			snapshot, _ := trackerSnapshots.Snapshot(repo)
+			return snapshot.DefaultBranch
+		}
+		selected = append(selected, "-direct-pushes")
+		tracker = &recordTracker{track: func(events []*github.Event) (records []interface{}) {
+			for _, r := range d.Track(events) {
+				records = append(records, r)
+			}
+			return records
+		}}
+	}
+
+	if *abuse != "" {
+		thresholds, err := lib.ParseAbuseThresholds(*abuseThresholds)
+		if err != nil {
+			return nil, err
+		}
+		d := lib.NewAbuseDetector(trackedList(*abuse))
+		d.Window, d.Thresholds = *abuseWindow, thresholds
+		selected = append(selected, "-abuse")
+		tracker = &recordTracker{track: func(events []*github.Event) (records []interface{}) {
+			for _, r := range d.Track(events) {
+				records = append(records, r)
+			}
+			return records
+		}}
+	}
+
+	if len(selected) > 1 {
+		return nil, fmt.Errorf("%s are exclusive", strings.Join(selected, ", "))
+	}
+	return tracker, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+)
+
+// serveUI serves the event viewer, a single page consuming /events and
+// /status. The page itself holds no data and is served without
+// authentication, the bearer token entered in the page is sent with its
+// requests.
+func serveUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write([]byte(uiPage))
+}
+
+const uiPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>github-feed</title>
+<style>
+body { font: 13px/1.4 sans-serif; margin: 0; display: grid; grid-template-columns: 1fr 320px; height: 100vh; }
+header { grid-column: 1 / 3; padding: 8px; background: #24292e; color: #fff; display: flex; gap: 8px; align-items: center; }
+header input { font: inherit; padding: 2px 4px; }
+#filter { flex: 1; }
+main { overflow-y: auto; }
+aside { overflow-y: auto; border-left: 1px solid #ddd; padding: 8px; }
+table { border-collapse: collapse; width: 100%; }
+td { padding: 2px 6px; border-bottom: 1px solid #eee; white-space: nowrap; overflow: hidden; text-overflow: ellipsis; max-width: 320px; }
+.bar { background: #0366d6; height: 10px; }
+.rate td { border: 0; }
+.bad { color: #cb2431; }
+</style>
+</head>
+<body>
+<header>
+	<b>github-feed</b>
+	<input id="filter" placeholder="filter, e.g. type=PullRequestEvent label=bug">
+	<input id="token" type="password" placeholder="bearer token">
+	<button id="connect">Connect</button>
+	<span id="state">disconnected</span>
+</header>
+<main><table id="events"></table></main>
+<aside>
+	<h3>Events per minute</h3>
+	<table id="rates" class="rate"></table>
+	<h3>Pipeline</h3>
+	<table id="status"></table>
+</aside>
+<script>
+const maxRows = 500, rateWindow = 60000;
+const $ = (id) => document.getElementById(id);
+let abort = null, lastId = "", seen = [];
+
+$("token").value = localStorage.getItem("token") || "";
+$("filter").value = new URLSearchParams(location.search).get("filter") || "";
+
+function headers() {
+	const token = $("token").value;
+	return token ? {"Authorization": "Bearer " + token} : {};
+}
+
+function cell(row, text) {
+	const td = row.insertCell();
+	td.textContent = text;
+	td.title = text;
+}
+
+function show(type, record) {
+	const row = $("events").insertRow(0);
+	cell(row, (record.created_at || "").replace("T", " ").replace("Z", ""));
+	cell(row, type);
+	cell(row, record.actor ? record.actor.login : "");
+	cell(row, record.repo ? record.repo.name : "");
+	cell(row, record.payload && record.payload.action || "");
+	while ($("events").rows.length > maxRows) $("events").deleteRow(-1);
+
+	seen.push({time: Date.now(), type: type});
+}
+
+function drawRates() {
+	const now = Date.now();
+	seen = seen.filter((e) => now - e.time < rateWindow);
+
+	const counts = {};
+	seen.forEach((e) => counts[e.type] = (counts[e.type] || 0) + 1);
+	const types = Object.keys(counts).sort((a, b) => counts[b] - counts[a]);
+	const max = Math.max(1, ...types.map((t) => counts[t]));
+
+	const table = $("rates");
+	table.innerHTML = "";
+	types.forEach((t) => {
+		const row = table.insertRow();
+		cell(row, t);
+		cell(row, counts[t]);
+		const bar = document.createElement("div");
+		bar.className = "bar";
+		bar.style.width = (100 * counts[t] / max) + "px";
+		row.insertCell().appendChild(bar);
+	});
+}
+
+async function drawStatus() {
+	const table = $("status");
+	try {
+		const rep = await fetch("status", {headers: headers()});
+		if (!rep.ok) throw new Error(rep.status + " " + rep.statusText);
+		const s = await rep.json();
+		const stale = s.last_batch && Date.now() - Date.parse(s.last_batch) > 5 * 60000;
+		const rows = [
+			["uptime", s.uptime],
+			["last batch", s.last_batch, stale],
+			["batches", s.batches],
+			["events", s.events],
+			["errors", s.errors, s.errors > 0],
+			["last error", s.last_error || "", !!s.last_error],
+			["subscribers", s.stream.subscribers],
+			["replay buffer", s.stream.buffered],
+		];
+		table.innerHTML = "";
+		rows.forEach(([name, value, bad]) => {
+			const row = table.insertRow();
+			if (bad) row.className = "bad";
+			cell(row, name);
+			cell(row, value);
+		});
+	} catch (err) {
+		table.innerHTML = "";
+		const row = table.insertRow();
+		row.className = "bad";
+		cell(row, "unavailable: " + err.message);
+	}
+}
+
+// EventSource can't send an Authorization header, the stream is read with
+// fetch and parsed here.
+async function connect() {
+	if (abort) abort.abort();
+	abort = new AbortController();
+	localStorage.setItem("token", $("token").value);
+	$("events").innerHTML = "";
+
+	const params = new URLSearchParams({filter: $("filter").value});
+	history.replaceState(null, "", "?" + params);
+
+	while (!abort.signal.aborted) {
+		try {
+			const h = headers();
+			if (lastId) h["Last-Event-ID"] = lastId;
+			const rep = await fetch("events?" + params, {headers: h, signal: abort.signal});
+			if (!rep.ok) throw new Error(rep.status + " " + (await rep.text()));
+			$("state").textContent = "connected";
+
+			const reader = rep.body.getReader(), decoder = new TextDecoder();
+			let buf = "";
+			for (;;) {
+				const {value, done} = await reader.read();
+				if (done) break;
+				buf += decoder.decode(value, {stream: true});
+
+				let end;
+				while ((end = buf.indexOf("\n\n")) >= 0) {
+					const message = {};
+					buf.slice(0, end).split("\n").forEach((line) => {
+						const i = line.indexOf(": ");
+						if (i > 0) message[line.slice(0, i)] = line.slice(i + 2);
+					});
+					buf = buf.slice(end + 2);
+
+					if (message.id) lastId = message.id;
+					if (message.event && message.event !== "gap" && message.data) {
+						show(message.event, JSON.parse(message.data));
+					}
+				}
+			}
+		} catch (err) {
+			if (abort.signal.aborted) return;
+			$("state").textContent = "disconnected: " + err.message;
+		}
+		await new Promise((resolve) => setTimeout(resolve, 3000));
+	}
+}
+
+$("connect").onclick = () => { lastId = ""; connect(); };
+$("filter").onkeydown = (e) => { if (e.key === "Enter") $("connect").onclick(); };
+setInterval(drawRates, 1000);
+setInterval(drawStatus, 5000);
+drawStatus();
+connect();
+</script>
+</body>
+</html>
+`
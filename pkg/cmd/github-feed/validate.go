@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// configFlagNames are the flags (or environment variables) setting the
+// fields of lib.Config, which name the problems reported by validateConfig.
+var configFlagNames = map[string]string{
+	"BaseURL":              "-api-url",
+	"FallbackURLs":         "-api-url",
+	"UploadURL":            "-api-upload-url",
+	"ProxyURL":             "-api-proxy",
+	"AuthTokens":           authTokensEnv,
+	"Previews":             "-api-previews",
+	"QueueSize":            "-feed-queue",
+	"Backpressure":         "-feed-backpressure",
+	"SpillDir":             "-feed-spill-dir",
+	"MaxBatchEvents":       "-feed-max-batch",
+	"MaxEventAge":          "-feed-max-age",
+	"LagThreshold":         "-feed-lag-threshold",
+	"MaxLagPollInterval":   "-feed-lag-max-interval",
+	"LagShedFilter":        "-feed-lag-shed",
+	"ErrorsQueueSize":      "-errors-queue",
+	"RequestTimeout":       "-request-timeout",
+	"MinPollInterval":      "-poll-min-interval",
+	"MaxPages":             "-poll-max-pages",
+	"LogLevel":             "-log-level",
+	"LogFormat":            "-log-format",
+	"HTTPCacheBytes":       "-http-cache",
+	"MaxBandwidth":         "-bandwidth-limit",
+	"RetryBackoff":         "-poll-retry-backoff",
+	"MaxRetryBackoff":      "-poll-max-backoff",
+	"Sources":              "-sources",
+	"Filter.Actors":        "-only-actors",
+	"Filter.ExcludeActors": "-exclude-actors",
+	"Filter.Repos":         "-only-repos",
+	"Filter.ExcludeRepos":  "-exclude-repos",
+	"Filter.Orgs":          "-only-orgs",
+	"Filter.ExcludeOrgs":   "-exclude-orgs",
+	"Filter.Expression":    "-only-matching",
+	"WebhookSecret":        webhookSecretEnv,
+	"MetricsAddr":          "-metrics-addr",
+	"GHArchiveFrom":        "-gharchive-from",
+	"GHArchiveTo":          "-gharchive-to",
+	"GHArchiveURL":         "-gharchive-url",
+	"GHArchiveSpeed":       "-gharchive-speed",
+
+	"RequestReportInterval":  "-request-report-interval",
+	"Chaos.PollIntervalRate": "-chaos-poll-interval",
+	"Chaos.NotModifiedRate":  "-chaos-not-modified",
+
+	"StateDir":           "-state-dir",
+	"CatchUpAfter":       "-catch-up-after",
+	"CatchUpInterval":    "-catch-up-interval",
+	"CatchUpFromArchive": "-catch-up-from-archive",
+}
+
+// flagField names a field of lib.Config by its flag, keeping the index of
+// list items, e.g. Filter.Repos[1] is -only-repos[1].
+func flagField(field string) string {
+	name, index := field, ""
+	if i := strings.IndexByte(field, '['); i >= 0 {
+		name, index = field[:i], field[i:]
+	}
+	if f, ok := configFlagNames[name]; ok {
+		return f + index
+	}
+	return field
+}
+
+// validateConfig checks every flag of the feed before anything is started,
+// and reports all the problems at once, named by their flag, instead of
+// failing at the first use of each.
+func validateConfig() error {
+	var errs lib.ConfigErrors
+
+	if err := feedConfig().Validate(); err != nil {
+		for _, e := range err.(lib.ConfigErrors) {
+			if e.Field == "GHArchiveFrom" {
+				// Conflicting with -webhook, reported with the other modes.
+				continue
+			}
+			errs.Add(flagField(e.Field), e.Err)
+		}
+	}
+
+	if _, err := recordSerializer(); err != nil {
+		errs.Add("-format", err)
+	}
+	for _, f := range []struct{ flag, expr string }{
+		{"-filter", *filterExpr},
+		{"-sink-priority", *sinkPriority},
+	} {
+		_, err := lib.ParseFilter(f.expr)
+		errs.Add(f.flag, err)
+	}
+
+	if *transformsPath != "" {
+		// The dead-letter transforms require -dead-letters.
+		queue, err := openDeadLetters()
+		errs.Add("-dead-letters", err)
+		_, err = lib.LoadTransformChain(*transformsPath, queue)
+		errs.Add("-transforms", err)
+	}
+
+	for i, u := range outputURLs() {
+		errs.Add(fmt.Sprintf("-sink[%d]", i), lib.ValidateSinkURL(u))
+	}
+	validatePathRoutes(&errs)
+	if *prettyTerminal && len(outputURLs()) > 0 {
+		errs.Addf("-pretty-terminal", "conflicts with -sink, whose outputs replace stdout")
+	}
+	if *archiveStores != "" {
+		for i, u := range strings.Split(*archiveStores, ",") {
+			errs.Add(fmt.Sprintf("-archive[%d]", i), lib.ValidateObjectStoreURL(u))
+		}
+	}
+	if *sinkWorkers < 1 {
+		errs.Addf("-sink-workers", "must be at least 1")
+	}
+	if *sinkWorkers > 1 || *sinkPriority != "" {
+		_, err := lib.ParseKeySpec(*sinkOrderKey)
+		errs.Add("-sink-order-key", err)
+	}
+	for _, n := range []struct {
+		flag  string
+		value int64
+	}{
+		{"-sink-queue", int64(*sinkQueue)},
+		{"-sink-batch-records", int64(*batchRecords)},
+		{"-sink-batch-bytes", int64(*batchBytes)},
+		{"-sink-batch-latency", int64(*batchLatency)},
+		{"-enrich-budget", int64(*enrichBudget)},
+		{"-commit-signature-budget", int64(*commitSignatureBudget)},
+		{"-path-route-budget", int64(*pathRouteBudget)},
+	} {
+		if n.value < 0 {
+			errs.Addf(n.flag, "must not be negative")
+		}
+	}
+
+	// The sources of events replacing the polls exclude each other.
+	var modes []string
+	if *soakDuration > 0 {
+		modes = append(modes, "-soak")
+	}
+	if *sourceArchive != "" {
+		modes = append(modes, "-source-archive")
+	}
+	if feedConfig().WebhookAddr != "" {
+		modes = append(modes, "-webhook")
+	}
+	if !feedConfig().GHArchiveFrom.IsZero() {
+		modes = append(modes, "-gharchive-from")
+	}
+	if len(modes) > 1 {
+		errs.Addf(modes[1], "conflicts with %s", strings.Join(append(modes[:1:1], modes[2:]...), " and "))
+	}
+
+	if *sourceArchive != "" {
+		if strings.Contains(*sourceArchive, "://") {
+			errs.Add("-source-archive", lib.ValidateObjectStoreURL(*sourceArchive))
+		} else if _, err := os.Stat(*sourceArchive); err != nil {
+			errs.Add("-source-archive", err)
+		}
+		if *sourceFrom == "" {
+			errs.Addf("-source-from", "required by -source-archive")
+		}
+	} else if *sourceFrom != "" || *sourceTo != "" {
+		errs.Addf("-source-from", "requires -source-archive")
+	}
+	for _, f := range []struct{ flag, value string }{
+		{"-source-from", *sourceFrom},
+		{"-source-to", *sourceTo},
+	} {
+		if f.value != "" {
+			if _, err := time.Parse(time.RFC3339, f.value); err != nil {
+				errs.Addf(f.flag, "invalid time '%s', expected RFC 3339", f.value)
+			}
+		}
+	}
+	if *soakDuration > 0 && *soakInput == "" {
+		errs.Addf("-soak-input", "required by -soak")
+	}
+	validateExperiments(&errs)
+
+	return errs.Err()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	verifyFlags  = flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyStrict = verifyFlags.Bool("strict", false, "Fail on records without a digest (schema version < 2).")
+)
+
+// verify checks the digest of every record of archived NDJSON files
+// (optionally gzipped), directories are walked, e.g.
+//
+//	github-feed verify archive/
+func verify(args []string) int {
+	fs := verifyFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed verify [-strict] file-or-dir...\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var paths []string
+	for _, root := range fs.Args() {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() && (path == root || isArchive(path)) {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+	}
+
+	status := 0
+	var total, unsigned int
+	for _, path := range paths {
+		n, u, err := verifyFile(path)
+		total += n
+		unsigned += u
+		if err != nil {
+			fmt.Printf("%s: %v\n", path, err)
+			status = 1
+		} else if *verifyStrict && u > 0 {
+			fmt.Printf("%s: %d records without digest\n", path, u)
+			status = 1
+		}
+	}
+
+	fmt.Printf("verified %d records in %d files, %d without digest\n", total-unsigned, len(paths), unsigned)
+	return status
+}
+
+func isArchive(path string) bool {
+	return strings.HasSuffix(path, ".ndjson") || strings.HasSuffix(path, ".ndjson.gz")
+}
+
+// verifyFile returns the number of records and of records without digest,
+// stopping at the first mismatch.
+func verifyFile(path string) (n, unsigned int, err error) {
+	r, err := openArchive(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+
+	var expander lib.RecordExpander
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	for scanner.Scan() {
+		line, err := expander.Expand(scanner.Bytes())
+		if err != nil {
+			return n, unsigned, fmt.Errorf("record %d: %v", n+1, err)
+		} else if len(line) == 0 {
+			continue
+		}
+		n++
+
+		if err := lib.VerifyRecord(line); errors.Is(err, lib.ErrNoDigest) {
+			unsigned++
+		} else if err != nil {
+			return n, unsigned, fmt.Errorf("record %d: %v", n, err)
+		}
+	}
+
+	return n, unsigned, scanner.Err()
+}
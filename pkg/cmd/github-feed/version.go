@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Build information, set by the release builds, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// The commit and its date default to those recorded by the go command.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// Repository whose releases -check-update compares the build with.
+const (
+	releaseOwner = "fsaintjacques"
+	releaseRepo  = "github-feed"
+)
+
+var (
+	versionFlags = flag.NewFlagSet("version", flag.ExitOnError)
+	versionCheck = versionFlags.Bool("check-update", false, "Also tell whether a newer release was published on GitHub.")
+
+	checkUpdate = flag.Bool("check-update", false,
+		"Log at startup whether a newer release than the running build was published on GitHub (opt-in, one API request).")
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	if version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		// Installed with go install module@version.
+		version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && commit == "":
+			commit = s.Value
+		case s.Key == "vcs.time" && buildDate == "":
+			buildDate = s.Value
+		}
+	}
+}
+
+// versionString describes the build on one line.
+func versionString() string {
+	s := fmt.Sprintf("github-feed %s", version)
+	if commit != "" {
+		short := commit
+		if len(short) > 12 {
+			short = short[:12]
+		}
+		s += " (" + short
+		if buildDate != "" {
+			s += ", " + buildDate
+		}
+		s += ")"
+	}
+	return fmt.Sprintf("%s %s %s/%s", s, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// printVersion prints the build information, e.g.
+//
+//	github-feed version -check-update
+func printVersion(args []string) int {
+	fs := versionFlags
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: github-feed version [-check-update]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fmt.Println(versionString())
+	if !*versionCheck {
+		return exitOK
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	latest, newer, err := latestRelease(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed checking for updates: %v\n", err)
+		return exitFeed
+	}
+	printUpdate(os.Stdout, latest, newer)
+	return exitOK
+}
+
+// startUpdateCheck logs whether a newer release was published, with
+// -check-update.
+func startUpdateCheck(ctx context.Context) {
+	if !*checkUpdate {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		latest, newer, err := latestRelease(ctx)
+		if err != nil {
+			log.Printf("Failed checking for updates: %v", err)
+			return
+		}
+		if newer {
+			log.Printf("A newer release of github-feed is available: %s (running %s), see %s", latest.GetTagName(), version, latest.GetHTMLURL())
+		}
+	}()
+}
+
+func printUpdate(w io.Writer, latest *github.RepositoryRelease, newer bool) {
+	switch {
+	case newer:
+		fmt.Fprintf(w, "A newer release is available: %s, see %s\n", latest.GetTagName(), latest.GetHTMLURL())
+	case version == "dev":
+		fmt.Fprintf(w, "Development build, the latest release is %s\n", latest.GetTagName())
+	default:
+		fmt.Fprintf(w, "Up to date, the latest release is %s\n", latest.GetTagName())
+	}
+}
+
+// latestRelease returns the latest release published on GitHub, and whether
+// it is newer than the running build. Development builds are never older.
+func latestRelease(ctx context.Context) (*github.RepositoryRelease, bool, error) {
+	client := github.NewClient(nil)
+	client.UserAgent = "github-feed/" + version
+	release, _, err := client.Repositories.GetLatestRelease(ctx, releaseOwner, releaseRepo)
+	if err != nil {
+		return nil, false, err
+	}
+	return release, version != "dev" && compareVersions(release.GetTagName(), version) > 0, nil
+}
+
+// compareVersions compares two semantic versions (v1.2.3, the v optional),
+// ignoring pre-release and build suffixes: negative if a is older than b,
+// positive if newer.
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			return pa[i] - pb[i]
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) [3]int {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var parts [3]int
+	for i, s := range strings.SplitN(v, ".", 3) {
+		parts[i], _ = strconv.Atoi(s)
+	}
+	return parts
+}
+
+// writeBuildInfo writes the build information as the labels of the
+// github_feed_build_info gauge, always 1.
+func writeBuildInfo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP github_feed_build_info Build of the running process.\n")
+	fmt.Fprintf(w, "# TYPE github_feed_build_info gauge\n")
+	fmt.Fprintf(w, "github_feed_build_info{version=%q,commit=%q,build_date=%q,go_version=%q} 1\n", version, commit, buildDate, runtime.Version())
+}
@@ -0,0 +1,338 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Patterns of AbuseAlertRecord.
+const (
+	AbuseMassIssues       = "mass-issues"
+	AbuseIdenticalComment = "identical-comments"
+	AbuseForkPRWave       = "fork-pr-wave"
+)
+
+const (
+	defaultAbuseWindow   = time.Hour
+	defaultAbuseIssues   = 10
+	defaultAbuseComments = 5
+	defaultAbuseForkPRs  = 10
+	// Event IDs given as evidence in an alert.
+	abuseEvidence = 10
+	// Comments shorter than this (e.g. "+1", "LGTM") are legitimately
+	// repeated.
+	abuseMinCommentLength = 20
+)
+
+// AbuseThresholds are the numbers of occurrences within the window raising an
+// alert, a zero threshold selects the default.
+type AbuseThresholds struct {
+	// Repositories in which an actor opened issues, 10 by default.
+	Issues int
+	// Issues or pull requests which received the same comment, 5 by default.
+	Comments int
+	// Actors who forked a repository and opened a pull request to it, 10 by
+	// default.
+	ForkPRs int
+}
+
+// ParseAbuseThresholds parses comma separated name=n thresholds, among
+// issues, comments and fork-prs, e.g. "issues=20,fork-prs=5".
+func ParseAbuseThresholds(s string) (AbuseThresholds, error) {
+	var t AbuseThresholds
+	if s == "" {
+		return t, nil
+	}
+
+	for _, term := range strings.Split(s, ",") {
+		kv := strings.SplitN(term, "=", 2)
+		if len(kv) != 2 {
+			return t, fmt.Errorf("invalid abuse threshold '%s', expected name=n", term)
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n <= 0 {
+			return t, fmt.Errorf("invalid abuse threshold '%s', expected a positive number", term)
+		}
+
+		switch kv[0] {
+		case "issues":
+			t.Issues = n
+		case "comments":
+			t.Comments = n
+		case "fork-prs":
+			t.ForkPRs = n
+		default:
+			return t, fmt.Errorf("unknown abuse threshold '%s', expected issues, comments or fork-prs", kv[0])
+		}
+	}
+
+	return t, nil
+}
+
+// AbuseAlertRecord reports a spammy pattern seen within the window.
+type AbuseAlertRecord struct {
+	SchemaVersion int    `json:"schema_version"`
+	Pattern       string `json:"pattern"`
+	// The spamming actor (mass-issues), the targeted repository
+	// (fork-pr-wave) or the digest of the repeated comment.
+	Key string `json:"key"`
+	// Distinct repositories, issues or actors involved.
+	Count    int      `json:"count"`
+	Actors   []string `json:"actors"`
+	Repos    []string `json:"repos"`
+	EventIDs []string `json:"event_ids"`
+	// The comment, for identical-comments.
+	Body          string    `json:"body,omitempty"`
+	FirstSeen     time.Time `json:"first_seen"`
+	LastSeen      time.Time `json:"last_seen"`
+	WindowSeconds float64   `json:"window_seconds"`
+}
+
+type abuseObservation struct {
+	at      time.Time
+	target  string
+	actor   string
+	repo    string
+	eventID string
+}
+
+type abuseSeries struct {
+	observations []abuseObservation
+	body         string
+	// Until when the series is not alerted on again.
+	quiet time.Time
+}
+
+// distinct returns the number of distinct targets observed.
+func (s *abuseSeries) distinct() int {
+	targets := make(map[string]bool)
+	for _, o := range s.observations {
+		targets[o.target] = true
+	}
+	return len(targets)
+}
+
+// AbuseDetector raises alerts on spammy patterns in the events of a set of
+// organizations or repositories (all if empty), over a sliding window (an
+// hour if zero) of the events' creation time:
+//
+//   - mass-issues: an actor opening issues in many repositories;
+//   - identical-comments: the same comment posted on many issues or pull
+//     requests;
+//   - fork-pr-wave: many actors forking a repository and opening a pull
+//     request to it.
+//
+// A pattern is alerted on once per window.
+type AbuseDetector struct {
+	Window     time.Duration
+	Thresholds AbuseThresholds
+
+	scopes map[string]bool
+	series map[string]*abuseSeries
+	// Forks by repository and actor, for the fork-pr-wave pattern.
+	forks map[string]time.Time
+}
+
+// NewAbuseDetector watches the given organizations (owner) or repositories
+// (owner/name).
+func NewAbuseDetector(scopes []string) *AbuseDetector {
+	d := &AbuseDetector{scopes: make(map[string]bool, len(scopes)), series: make(map[string]*abuseSeries), forks: make(map[string]time.Time)}
+	for _, scope := range scopes {
+		d.scopes[strings.ToLower(scope)] = true
+	}
+
+	return d
+}
+
+func (d *AbuseDetector) window() time.Duration {
+	if d.Window <= 0 {
+		return defaultAbuseWindow
+	}
+	return d.Window
+}
+
+func (d *AbuseDetector) watches(repo string) bool {
+	if len(d.scopes) == 0 {
+		return true
+	}
+	repo = strings.ToLower(repo)
+	return d.scopes[repo] || d.scopes[strings.SplitN(repo, "/", 2)[0]]
+}
+
+type abusePayload struct {
+	Action *string `json:"action"`
+	Issue  *struct {
+		Number *int `json:"number"`
+	} `json:"issue"`
+	Comment *struct {
+		Body *string `json:"body"`
+	} `json:"comment"`
+}
+
+// Track returns the alerts raised by the batch.
+func (d *AbuseDetector) Track(events []*github.Event) []*AbuseAlertRecord {
+	var records []*AbuseAlertRecord
+	var latest time.Time
+
+	for _, ev := range events {
+		repo, actor := ev.GetRepo().GetName(), ev.GetActor().GetLogin()
+		if repo == "" || actor == "" || !d.watches(repo) {
+			continue
+		}
+
+		created := ev.GetCreatedAt()
+		if created.After(latest) {
+			latest = created
+		}
+		o := abuseObservation{at: created, actor: actor, repo: repo, eventID: ev.GetID()}
+
+		var payload abusePayload
+		switch ev.GetType() {
+		case "IssuesEvent", "IssueCommentEvent", "PullRequestEvent":
+			if ev.RawPayload == nil || json.Unmarshal(*ev.RawPayload, &payload) != nil {
+				continue
+			}
+		}
+
+		switch ev.GetType() {
+		case "IssuesEvent":
+			if stringOrEmpty(payload.Action) != "opened" {
+				continue
+			}
+			o.target = strings.ToLower(repo)
+			records = d.observe(records, AbuseMassIssues, actor, o, "")
+		case "IssueCommentEvent":
+			if payload.Comment == nil || payload.Issue == nil || stringOrEmpty(payload.Action) != "created" {
+				continue
+			}
+			body := strings.Join(strings.Fields(stringOrEmpty(payload.Comment.Body)), " ")
+			if len(body) < abuseMinCommentLength {
+				continue
+			}
+			sum := sha256.Sum256([]byte(strings.ToLower(body)))
+			o.target = strings.ToLower(repo) + "#" + strconv.Itoa(intOrZero(payload.Issue.Number))
+			records = d.observe(records, AbuseIdenticalComment, hex.EncodeToString(sum[:8]), o, body)
+		case "ForkEvent":
+			d.forks[strings.ToLower(repo)+"\x00"+strings.ToLower(actor)] = created
+		case "PullRequestEvent":
+			if stringOrEmpty(payload.Action) != "opened" {
+				continue
+			}
+			if _, forked := d.forks[strings.ToLower(repo)+"\x00"+strings.ToLower(actor)]; !forked {
+				continue
+			}
+			o.target = strings.ToLower(actor)
+			records = d.observe(records, AbuseForkPRWave, repo, o, "")
+		}
+	}
+
+	d.forget(latest.Add(-d.window()))
+	return records
+}
+
+// observe adds the observation to the series of the pattern and key, and
+// appends an alert to records if it crossed the threshold.
+func (d *AbuseDetector) observe(records []*AbuseAlertRecord, pattern, key string, o abuseObservation, body string) []*AbuseAlertRecord {
+	id := pattern + "\x00" + strings.ToLower(key)
+	s, ok := d.series[id]
+	if !ok {
+		s = &abuseSeries{body: body}
+		d.series[id] = s
+	}
+
+	// Out of the window of this observation.
+	window := d.window()
+	kept := s.observations[:0]
+	for _, prev := range s.observations {
+		if o.at.Sub(prev.at) < window {
+			kept = append(kept, prev)
+		}
+	}
+	s.observations = append(kept, o)
+
+	threshold := 0
+	switch pattern {
+	case AbuseMassIssues:
+		threshold = orDefault(d.Thresholds.Issues, defaultAbuseIssues)
+	case AbuseIdenticalComment:
+		threshold = orDefault(d.Thresholds.Comments, defaultAbuseComments)
+	case AbuseForkPRWave:
+		threshold = orDefault(d.Thresholds.ForkPRs, defaultAbuseForkPRs)
+	}
+
+	count := s.distinct()
+	if count < threshold || o.at.Before(s.quiet) {
+		return records
+	}
+	s.quiet = o.at.Add(window)
+
+	return append(records, s.alert(pattern, key, count, window))
+}
+
+func (s *abuseSeries) alert(pattern, key string, count int, window time.Duration) *AbuseAlertRecord {
+	record := &AbuseAlertRecord{
+		SchemaVersion: SchemaVersion,
+		Pattern:       pattern,
+		Key:           key,
+		Count:         count,
+		Body:          s.body,
+		WindowSeconds: window.Seconds(),
+	}
+
+	actors, repos := make(map[string]bool), make(map[string]bool)
+	for _, o := range s.observations {
+		if record.FirstSeen.IsZero() || o.at.Before(record.FirstSeen) {
+			record.FirstSeen = o.at.UTC()
+		}
+		if o.at.After(record.LastSeen) {
+			record.LastSeen = o.at.UTC()
+		}
+		if !actors[o.actor] {
+			actors[o.actor] = true
+			record.Actors = append(record.Actors, o.actor)
+		}
+		if !repos[o.repo] {
+			repos[o.repo] = true
+			record.Repos = append(record.Repos, o.repo)
+		}
+		if len(record.EventIDs) < abuseEvidence {
+			record.EventIDs = append(record.EventIDs, o.eventID)
+		}
+	}
+	sort.Strings(record.Actors)
+	sort.Strings(record.Repos)
+
+	return record
+}
+
+// forget drops the observations and forks older than the given time, and the
+// series left empty once they are no longer quiet.
+func (d *AbuseDetector) forget(before time.Time) {
+	for id, s := range d.series {
+		kept := s.observations[:0]
+		for _, o := range s.observations {
+			if !o.at.Before(before) {
+				kept = append(kept, o)
+			}
+		}
+		s.observations = kept
+
+		if len(kept) == 0 && s.quiet.Before(before) {
+			delete(d.series, id)
+		}
+	}
+
+	for key, at := range d.forks {
+		if at.Before(before) {
+			delete(d.forks, key)
+		}
+	}
+}
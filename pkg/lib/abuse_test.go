@@ -0,0 +1,63 @@
+package lib_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestAbuseDetectorMassIssues(t *testing.T) {
+	start := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	d := lib.NewAbuseDetector(nil)
+	d.Thresholds = lib.AbuseThresholds{Issues: 3}
+
+	var batch []*github.Event
+	for i := 0; i < 5; i++ {
+		batch = append(batch, testEvent("", "IssuesEvent", "spammer", fmt.Sprintf("org/repo-%d", i), start.Add(time.Duration(i)*time.Minute), `{"action":"opened"}`))
+	}
+	// Too far apart to be a pattern.
+	batch = append(batch, testEvent("", "IssuesEvent", "user", "org/a", start, `{"action":"opened"}`))
+	batch = append(batch, testEvent("", "IssuesEvent", "user", "org/b", start.Add(2*time.Hour), `{"action":"opened"}`))
+	batch = append(batch, testEvent("", "IssuesEvent", "user", "org/c", start.Add(4*time.Hour), `{"action":"opened"}`))
+
+	records := d.Track(batch)
+	if len(records) != 1 {
+		t.Fatalf("got %d alerts, want 1: %+v", len(records), records)
+	}
+	if r := records[0]; r.Pattern != lib.AbuseMassIssues || r.Key != "spammer" || r.Count != 3 || len(r.Repos) != 3 {
+		t.Errorf("unexpected alert %+v", r)
+	}
+}
+
+func TestAbuseDetectorIdenticalComments(t *testing.T) {
+	start := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	d := lib.NewAbuseDetector([]string{"org"})
+	d.Thresholds = lib.AbuseThresholds{Comments: 3}
+
+	var batch []*github.Event
+	for i := 0; i < 3; i++ {
+		payload := fmt.Sprintf(`{"action":"created","issue":{"number":%d},"comment":{"body":"Check out  my   FREE crypto giveaway!"}}`, i)
+		batch = append(batch, testEvent("", "IssueCommentEvent", fmt.Sprintf("bot-%d", i), "org/repo", start, payload))
+	}
+	batch = append(batch, testEvent("", "IssueCommentEvent", "bot", "other/repo", start, `{"action":"created","issue":{"number":9},"comment":{"body":"Check out my FREE crypto giveaway!"}}`))
+
+	records := d.Track(batch)
+	if len(records) != 1 || records[0].Pattern != lib.AbuseIdenticalComment || records[0].Count != 3 || len(records[0].Actors) != 3 {
+		t.Fatalf("unexpected alerts %+v", records)
+	}
+}
+
+func TestParseAbuseThresholds(t *testing.T) {
+	thresholds, err := lib.ParseAbuseThresholds("issues=20,fork-prs=5")
+	if err != nil || thresholds.Issues != 20 || thresholds.ForkPRs != 5 || thresholds.Comments != 0 {
+		t.Errorf("parsed %+v: %v", thresholds, err)
+	}
+	for _, invalid := range []string{"issues", "issues=0", "stars=3"} {
+		if _, err := lib.ParseAbuseThresholds(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
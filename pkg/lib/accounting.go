@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Subsystems of the API requests, see WithSubsystem.
+const (
+	// The pages of the events polled.
+	SubsystemPoller = "poller"
+	// The searches of the SearchSeeder.
+	SubsystemDiscovery = "discovery"
+	// The lookups of the enrichers.
+	SubsystemActorType    = "actor-type"
+	SubsystemPathRoute    = "path-route"
+	SubsystemRepoSnapshot = "repo-snapshot"
+	SubsystemSignatures   = "signatures"
+	// The requests of the clients of the CacheProxy.
+	SubsystemProxy = "proxy"
+	// The requests of an untagged context.
+	SubsystemOther = "other"
+)
+
+type subsystemKey struct{}
+
+// WithSubsystem tags the API requests made with the context as the
+// subsystem's in the request accounting of the feed, see
+// EventFeed.RequestCounts. The components sharing the feed's client (see
+// EventFeed.Client) tag their contexts, the untagged requests are
+// accounted as SubsystemOther.
+func WithSubsystem(ctx context.Context, subsystem string) context.Context {
+	return context.WithValue(ctx, subsystemKey{}, subsystem)
+}
+
+func requestSubsystem(ctx context.Context) string {
+	if s, ok := ctx.Value(subsystemKey{}).(string); ok && s != "" {
+		return s
+	}
+	return SubsystemOther
+}
+
+// RequestCount are the API requests a subsystem sent with a token: those
+// served from the cache aren't sent, and those answered 304 Not Modified
+// are free of the rate limit.
+type RequestCount struct {
+	// Position of the token in the pool, see TokenStats, 1 without a pool.
+	Token       int    `json:"token"`
+	Subsystem   string `json:"subsystem"`
+	Requests    uint64 `json:"requests"`
+	NotModified uint64 `json:"not_modified"`
+}
+
+type requestKey struct {
+	token     int
+	subsystem string
+}
+
+// requestAccounting counts the API requests sent per token and subsystem,
+// since the start and since the last report.
+type requestAccounting struct {
+	mu       sync.Mutex
+	total    map[requestKey]*RequestCount
+	reported map[requestKey]RequestCount
+}
+
+func newRequestAccounting() *requestAccounting {
+	return &requestAccounting{
+		total:    make(map[requestKey]*RequestCount),
+		reported: make(map[requestKey]RequestCount),
+	}
+}
+
+func (a *requestAccounting) record(token int, req *http.Request, resp *http.Response) {
+	k := requestKey{token, requestSubsystem(req.Context())}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c := a.total[k]
+	if c == nil {
+		c = &RequestCount{Token: k.token, Subsystem: k.subsystem}
+		a.total[k] = c
+	}
+	c.Requests++
+	if resp != nil && resp.StatusCode == http.StatusNotModified {
+		c.NotModified++
+	}
+}
+
+// counts returns the requests since the start, by token then subsystem.
+func (a *requestAccounting) counts() []RequestCount {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	counts := make([]RequestCount, 0, len(a.total))
+	for _, c := range a.total {
+		counts = append(counts, *c)
+	}
+	sortRequestCounts(counts)
+	return counts
+}
+
+// since returns the requests since the last call, and resets them.
+func (a *requestAccounting) since() []RequestCount {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var counts []RequestCount
+	for k, c := range a.total {
+		last := a.reported[k]
+		if c.Requests == last.Requests {
+			continue
+		}
+		counts = append(counts, RequestCount{
+			Token:       c.Token,
+			Subsystem:   c.Subsystem,
+			Requests:    c.Requests - last.Requests,
+			NotModified: c.NotModified - last.NotModified,
+		})
+		a.reported[k] = *c
+	}
+	sortRequestCounts(counts)
+	return counts
+}
+
+func sortRequestCounts(counts []RequestCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Token != counts[j].Token {
+			return counts[i].Token < counts[j].Token
+		}
+		return counts[i].Subsystem < counts[j].Subsystem
+	})
+}
+
+// FormatRequestCounts formats the counts on a line, e.g. "token 1: poller
+// 120 (80 not modified), discovery 6; token 2: poller 118".
+func FormatRequestCounts(counts []RequestCount) string {
+	var b strings.Builder
+	for i, c := range counts {
+		switch {
+		case i == 0:
+			fmt.Fprintf(&b, "token %d: ", c.Token)
+		case c.Token != counts[i-1].Token:
+			fmt.Fprintf(&b, "; token %d: ", c.Token)
+		default:
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s %d", c.Subsystem, c.Requests)
+		if c.NotModified > 0 {
+			fmt.Fprintf(&b, " (%d not modified)", c.NotModified)
+		}
+	}
+	return b.String()
+}
+
+// accountedTransport accounts the requests of a single token.
+type accountedTransport struct {
+	base       http.RoundTripper
+	accounting *requestAccounting
+}
+
+func (t *accountedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	t.accounting.record(1, req, resp)
+	return resp, err
+}
+
+// RequestCounts returns the API requests sent since the feed started, by
+// token then subsystem.
+func (f *EventFeed) RequestCounts() []RequestCount {
+	return f.accounting.counts()
+}
+
+// reportRequests logs the API requests sent during each interval, until
+// ctx is done.
+func (f *EventFeed) reportRequests(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-f.clock.After(interval):
+		case <-ctx.Done():
+			return
+		}
+		if counts := f.accounting.since(); len(counts) > 0 {
+			log.Printf("API requests in the last %v, %s", interval, FormatRequestCounts(counts))
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	ActorTypeUser         = "User"
+	ActorTypeBot          = "Bot"
+	ActorTypeOrganization = "Organization"
+
+	actorCacheVersion       = 1
+	defaultActorCacheTTL    = 30 * 24 * time.Hour
+	defaultActorCacheSize   = 100000
+	defaultActorLookupTime  = 5 * time.Second
+	defaultActorLookupLimit = 1000
+	defaultActorFailureTTL  = 10 * time.Minute
+)
+
+type actorTypeEntry struct {
+	Type     string    `json:"type"`
+	Resolved time.Time `json:"resolved"`
+	// Set for a failed lookup, cached for FailureTTL and not persisted.
+	failed bool
+}
+
+// ActorTypeEnricher resolves the type of actors (User, Bot, Organization)
+// with the Users API. Resolved types are cached and persisted in a state file
+// since they practically never change, and lookups are capped by an hourly
+// budget to preserve the rate limit for polling. When a type can't be
+// resolved, the Fallback detector is used. The cache is reported as
+// actor_type in the cache metrics (see WriteCacheMetrics).
+type ActorTypeEnricher struct {
+	Fallback BotDetector
+	// Shared budget of the lookups, at normal priority, instead of the
+	// enricher's own if not nil.
+	Scheduler *EnrichmentScheduler
+	// How long a failed lookup (e.g. 404 for a deleted account) is cached
+	// before the actor is looked up again, 10 minutes if zero.
+	FailureTTL time.Duration
+
+	client *github.Client
+	clock  Clock
+	path   string
+	budget int
+
+	cache *Cache
+
+	mu      sync.Mutex
+	window  time.Time
+	lookups int
+	dirty   bool
+}
+
+// NewActorTypeEnricher loads the cache persisted at path (if not empty) and
+// allows at most budget lookups per hour. The cache holds at most 100000
+// actors for 30 days unless bounded otherwise.
+func NewActorTypeEnricher(client *github.Client, path string, budget int, cache CacheConfig) (*ActorTypeEnricher, error) {
+	if budget <= 0 {
+		budget = defaultActorLookupLimit
+	}
+	if cache.MaxEntries <= 0 && cache.MaxBytes <= 0 {
+		cache.MaxEntries = defaultActorCacheSize
+	}
+	if cache.TTL <= 0 {
+		cache.TTL = defaultActorCacheTTL
+	}
+
+	e := &ActorTypeEnricher{
+		Fallback: LoginBotDetector{},
+		client:   client,
+		clock:    clockOrSystem(cache.Clock),
+		path:     path,
+		budget:   budget,
+		cache:    NewCache("actor_type", cache),
+	}
+
+	if path == "" {
+		return e, nil
+	}
+
+	payload, err := ReadMigratedStateFile(path, actorCacheVersion, nil)
+	if os.IsNotExist(err) {
+		return e, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries map[string]actorTypeEntry
+	if err := json.Unmarshal(payload, &entries); err != nil {
+		return nil, err
+	}
+	for login, entry := range entries {
+		e.cache.SetAt(login, entry, actorEntrySize(login), entry.Resolved)
+	}
+
+	return e, nil
+}
+
+func actorEntrySize(login string) int {
+	// The login twice (map and LRU), the type and the timestamp.
+	return 2*len(login) + 64
+}
+
+// cached returns the cached entry of the actor, if any, dropping the
+// failures older than FailureTTL.
+func (e *ActorTypeEnricher) cached(login string, now time.Time) (actorTypeEntry, bool) {
+	v, ok := e.cache.Get(login)
+	if !ok {
+		return actorTypeEntry{}, false
+	}
+
+	entry := v.(actorTypeEntry)
+	ttl := e.FailureTTL
+	if ttl <= 0 {
+		ttl = defaultActorFailureTTL
+	}
+	if entry.failed && now.Sub(entry.Resolved) >= ttl {
+		e.cache.Delete(login)
+		return actorTypeEntry{}, false
+	}
+	return entry, true
+}
+
+func (e *ActorTypeEnricher) spend(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if now.Sub(e.window) >= time.Hour {
+		e.window, e.lookups = now, 0
+	}
+
+	if e.lookups >= e.budget {
+		return false
+	}
+
+	e.lookups++
+	return true
+}
+
+// ActorType returns the type of the actor, and false if it couldn't be
+// resolved (budget exhausted, API error). A failed lookup isn't retried
+// before FailureTTL.
+func (e *ActorTypeEnricher) ActorType(ctx context.Context, login string) (string, bool) {
+	now := e.clock.Now()
+
+	if entry, ok := e.cached(login, now); ok {
+		return entry.Type, !entry.failed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultActorLookupTime)
+	defer cancel()
+
+	var user *github.User
+	var lookupErr error
+	lookup := func(ctx context.Context) error {
+		user, _, lookupErr = e.client.Users.Get(WithSubsystem(ctx, SubsystemActorType), login)
+		return lookupErr
+	}
+
+	var err error
+	if e.Scheduler != nil {
+		err = e.Scheduler.Do(ctx, EnrichNormal, lookup)
+	} else if e.spend(now) {
+		err = lookup(ctx)
+	} else {
+		return "", false
+	}
+	if lookupErr != nil {
+		// The actor isn't looked up again on each of its events.
+		e.cache.SetAt(login, actorTypeEntry{Resolved: now, failed: true}, actorEntrySize(login), now)
+	}
+	if err != nil {
+		return "", false
+	}
+
+	e.cache.SetAt(login, actorTypeEntry{Type: user.GetType(), Resolved: now}, actorEntrySize(login), now)
+	e.mu.Lock()
+	e.dirty = true
+	e.mu.Unlock()
+
+	return user.GetType(), true
+}
+
+func (e *ActorTypeEnricher) IsBot(ev *github.Event) bool {
+	t, ok := e.ActorType(context.Background(), ev.GetActor().GetLogin())
+	if !ok {
+		return e.Fallback.IsBot(ev)
+	}
+	return t == ActorTypeBot
+}
+
+// Save persists the cache if it changed since the last save.
+func (e *ActorTypeEnricher) Save() error {
+	if e.path == "" {
+		return nil
+	}
+
+	e.mu.Lock()
+	if !e.dirty {
+		e.mu.Unlock()
+		return nil
+	}
+	e.dirty = false
+	e.mu.Unlock()
+
+	// Only the live entries are persisted, expired ones are dropped.
+	entries := make(map[string]actorTypeEntry)
+	e.cache.Range(func(login string, v interface{}) bool {
+		if entry := v.(actorTypeEntry); !entry.failed {
+			entries[login] = entry
+		}
+		return true
+	})
+
+	payload, err := json.Marshal(entries)
+
+	if err != nil {
+		return err
+	}
+
+	return WriteStateFile(e.path, actorCacheVersion, payload)
+}
+
+// ServePersist saves the cache periodically until the context is cancelled.
+func (e *ActorTypeEnricher) ServePersist(ctx context.Context, interval time.Duration) error {
+	for {
+		select {
+		case <-time.After(interval):
+			if err := e.Save(); err != nil {
+				warnf("Failed saving actor type cache: %v", err)
+			}
+		case <-ctx.Done():
+			e.Save()
+			return ctx.Err()
+		}
+	}
+}
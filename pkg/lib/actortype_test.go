@@ -0,0 +1,90 @@
+package lib_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestActorTypeFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actortype")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	lookups := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		lookups[r.URL.Path]++
+		mu.Unlock()
+
+		if r.URL.Path != "/users/dependabot" {
+			http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"login": "dependabot", "type": "Bot"}`))
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	path := filepath.Join(dir, "actors")
+	clock := lib.NewSimulatedClock(time.Now())
+	open := func() *lib.ActorTypeEnricher {
+		e, err := lib.NewActorTypeEnricher(client, path, 100, lib.CacheConfig{Clock: clock})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.FailureTTL = time.Minute
+		return e
+	}
+	e := open()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if typ, ok := e.ActorType(ctx, "ghost"); ok {
+			t.Errorf("resolved a missing actor as %s", typ)
+		}
+		if typ, ok := e.ActorType(ctx, "dependabot"); !ok || typ != lib.ActorTypeBot {
+			t.Errorf("resolved dependabot as %s, %v", typ, ok)
+		}
+	}
+	if lookups["/users/ghost"] != 1 || lookups["/users/dependabot"] != 1 {
+		t.Errorf("looked up %v, want each actor once", lookups)
+	}
+
+	// The failure expires, the resolved type doesn't.
+	clock.Advance(time.Minute)
+	e.ActorType(ctx, "ghost")
+	e.ActorType(ctx, "dependabot")
+	if lookups["/users/ghost"] != 2 || lookups["/users/dependabot"] != 1 {
+		t.Errorf("looked up %v after the failure TTL, want ghost again", lookups)
+	}
+
+	// Failures aren't persisted.
+	if err := e.Save(); err != nil {
+		t.Fatal(err)
+	}
+	e = open()
+	e.ActorType(ctx, "ghost")
+	if typ, ok := e.ActorType(ctx, "dependabot"); !ok || typ != lib.ActorTypeBot {
+		t.Errorf("reloaded dependabot as %s, %v", typ, ok)
+	}
+	if lookups["/users/ghost"] != 3 || lookups["/users/dependabot"] != 1 {
+		t.Errorf("looked up %v after reloading, want ghost again", lookups)
+	}
+}
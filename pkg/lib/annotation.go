@@ -0,0 +1,246 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrInvalidAnnotation = errors.New("annotations require an event_id and a label")
+
+const (
+	annotationStateVersion = 1
+	defaultAnnotationLimit = 100
+	maxAnnotationBody      = 64 * 1024
+)
+
+// Annotation is a label attached to an event by a downstream system or a
+// reviewer, e.g. "processed" or "flagged".
+type Annotation struct {
+	EventID string    `json:"event_id"`
+	Label   string    `json:"label"`
+	Note    string    `json:"note,omitempty"`
+	Author  string    `json:"author,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// AnnotationStore keeps the annotations of events in a state file, so that
+// review workflows (e.g. flag, then mark as processed) can be built on top of
+// the feed without another database. An event has at most one annotation per
+// label, annotating it again replaces it.
+type AnnotationStore struct {
+	path string
+
+	mu      sync.Mutex
+	byEvent map[string]map[string]Annotation
+}
+
+// NewAnnotationStore loads the annotations persisted at path, if not empty.
+func NewAnnotationStore(path string) (*AnnotationStore, error) {
+	s := &AnnotationStore{path: path, byEvent: make(map[string]map[string]Annotation)}
+	if path == "" {
+		return s, nil
+	}
+
+	payload, err := ReadMigratedStateFile(path, annotationStateVersion, nil)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var annotations []Annotation
+	if err := json.Unmarshal(payload, &annotations); err != nil {
+		return nil, err
+	}
+	for _, a := range annotations {
+		s.put(a)
+	}
+
+	return s, nil
+}
+
+func (s *AnnotationStore) put(a Annotation) {
+	labels, ok := s.byEvent[a.EventID]
+	if !ok {
+		labels = make(map[string]Annotation)
+		s.byEvent[a.EventID] = labels
+	}
+	labels[a.Label] = a
+}
+
+// all returns the annotations sorted by time.
+func (s *AnnotationStore) all() []Annotation {
+	var annotations []Annotation
+	for _, labels := range s.byEvent {
+		for _, a := range labels {
+			annotations = append(annotations, a)
+		}
+	}
+	sort.Slice(annotations, func(i, j int) bool {
+		if !annotations[i].Time.Equal(annotations[j].Time) {
+			return annotations[i].Time.Before(annotations[j].Time)
+		}
+		return annotations[i].EventID+annotations[i].Label < annotations[j].EventID+annotations[j].Label
+	})
+	return annotations
+}
+
+// save persists the annotations, annotating is rare enough (humans and
+// downstream acknowledgements) to write them on every change.
+func (s *AnnotationStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	payload, err := json.Marshal(s.all())
+	if err != nil {
+		return err
+	}
+	return WriteStateFile(s.path, annotationStateVersion, payload)
+}
+
+// Annotate attaches the annotation to its event, stamped with the current
+// time if it has none.
+func (s *AnnotationStore) Annotate(a Annotation) (Annotation, error) {
+	if a.EventID == "" || a.Label == "" {
+		return a, ErrInvalidAnnotation
+	}
+	if a.Time.IsZero() {
+		a.Time = time.Now().UTC()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.put(a)
+	return a, s.save()
+}
+
+// Remove removes the label of an event, or all its annotations if label is
+// empty, and reports whether there was any.
+func (s *AnnotationStore) Remove(eventID, label string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	labels, ok := s.byEvent[eventID]
+	if !ok {
+		return false, nil
+	}
+
+	if label == "" {
+		delete(s.byEvent, eventID)
+	} else if _, ok := labels[label]; !ok {
+		return false, nil
+	} else if delete(labels, label); len(labels) == 0 {
+		delete(s.byEvent, eventID)
+	}
+
+	return true, s.save()
+}
+
+// AnnotationQuery selects annotations, a zero field matches all.
+type AnnotationQuery struct {
+	EventID string
+	Label   string
+	Since   time.Time
+	Limit   int
+}
+
+// Query returns the annotations matching q, oldest first, up to q.Limit (a
+// default if not positive).
+func (s *AnnotationStore) Query(q AnnotationQuery) []Annotation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit := orDefault(q.Limit, defaultAnnotationLimit)
+	matches := []Annotation{}
+	for _, a := range s.all() {
+		if (q.EventID != "" && a.EventID != q.EventID) || (q.Label != "" && a.Label != q.Label) || a.Time.Before(q.Since) {
+			continue
+		}
+		if matches = append(matches, a); len(matches) == limit {
+			break
+		}
+	}
+	return matches
+}
+
+// ServeHTTP exposes the store, e.g. on the admin endpoint:
+//
+//	GET    /annotations?event=ID&label=flagged&since=RFC3339&limit=n
+//	POST   /annotations {"event_id": "ID", "label": "flagged", "note": "..."}
+//	DELETE /annotations?event=ID[&label=flagged]
+//
+// The author of posted annotations is the authenticated client, see
+// ClientIdentity.
+func (s *AnnotationStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		q := AnnotationQuery{EventID: query.Get("event"), Label: query.Get("label")}
+		if since := query.Get("since"); since != "" {
+			t, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			q.Since = t
+		}
+		if limit := query.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil {
+				http.Error(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			q.Limit = n
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Query(q))
+	case http.MethodPost:
+		var a Annotation
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationBody)).Decode(&a); err != nil {
+			http.Error(w, "invalid annotation: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.Author, a.Time = ClientIdentity(r), time.Time{}
+
+		a, err := s.Annotate(a)
+		if errors.Is(err, ErrInvalidAnnotation) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	case http.MethodDelete:
+		event := query.Get("event")
+		if event == "" {
+			http.Error(w, "missing event", http.StatusBadRequest)
+			return
+		}
+
+		removed, err := s.Remove(event, query.Get("label"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else if !removed {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
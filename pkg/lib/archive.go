@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const archiveKeyLayout = "2006/01/02/15"
+
+// ArchiveSink uploads each batch as a gzipped stream of records to a store,
+// NDJSON by default. The objects are keyed by the hour they were archived in,
+// e.g. 2020/08/20/14/20200820T143000.123456789Z-000042.ndjson.gz.
+type ArchiveSink struct {
+	// Format of the records, JSON if nil. The archive readers (replay,
+	// verify, the archive feed) only read JSON, possibly dictionary-encoded
+	// (see DictionaryEncoded).
+	Serializer Serializer
+
+	store ObjectStore
+	seq   uint64
+}
+
+func NewArchiveSink(store ObjectStore) *ArchiveSink {
+	return &ArchiveSink{store: store}
+}
+
+func archiveKey(t time.Time, seq uint64, ext string) string {
+	t = t.UTC()
+	return fmt.Sprintf("%s/%s-%06d.%s.gz", t.Format(archiveKeyLayout), t.Format(archiveObjectTimeLayout), seq, ext)
+}
+
+func encodeArchiveObject(events []*github.Event, s Serializer) ([]byte, error) {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	if err := encodeRecords(gz, events, s); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// encodeRecords writes the records of the events as a stream, in a single
+// batch if the serializer supports it.
+func encodeRecords(w io.Writer, events []*github.Event, s Serializer) error {
+	if batch, ok := s.(BatchSerializer); ok {
+		records := make([]*Record, len(events))
+		for i, ev := range events {
+			records[i] = NewRecord(ev)
+		}
+		return batch.EncodeBatch(w, records)
+	}
+
+	for _, ev := range events {
+		if err := s.Encode(w, NewRecord(ev)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ArchiveSink) Write(ctx context.Context, events []*github.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	serializer := serializerOrDefault(s.Serializer)
+	body, err := encodeArchiveObject(events, serializer)
+	if err != nil {
+		return err
+	}
+
+	return s.store.Put(ctx, archiveKey(time.Now(), atomic.AddUint64(&s.seq, 1), serializer.Extension()), body)
+}
+
+func (s *ArchiveSink) Close() error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const archiveObjectTimeLayout = "20060102T150405.000000000Z"
+
+// ArchiveRangeFeed streams back the events archived by an ArchiveSink in a
+// store between two times, in the order they were archived, one batch per
+// archived object. It publishes on a channel like EventFeed, so that the
+// archives can be processed by the same pipeline as the live feed.
+type ArchiveRangeFeed struct {
+	// Speed paces the replay at Speed times the rate the objects were
+	// archived at, as measured by Clock (the system clock if nil). The
+	// replay runs as fast as possible when zero.
+	Speed float64
+	Clock Clock
+
+	store    ReadableStore
+	from, to time.Time
+	events   chan<- []*github.Event
+}
+
+// NewArchiveRangeFeed returns a feed of the objects archived in [from, to),
+// the channel is closed once they were all published.
+func NewArchiveRangeFeed(store ReadableStore, from, to time.Time) (*ArchiveRangeFeed, <-chan []*github.Event, error) {
+	if !from.Before(to) {
+		return nil, nil, fmt.Errorf("empty archive range [%s, %s)", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	events := make(chan []*github.Event, defaultFeedCapacity)
+	return &ArchiveRangeFeed{store: store, from: from.UTC(), to: to.UTC(), events: events}, events, nil
+}
+
+// archiveObjectTime returns the time an object was archived at from its key,
+// see archiveKey.
+func archiveObjectTime(key string) (time.Time, bool) {
+	name := path.Base(key)
+	if i := strings.IndexByte(name, '-'); i > 0 {
+		name = name[:i]
+	}
+	t, err := time.Parse(archiveObjectTimeLayout, name)
+	return t, err == nil
+}
+
+// Serve publishes the archived events until the end of the range or the
+// context is done, and closes the channel. Objects which can't be read or
+// decoded are fatal, the replay would otherwise silently miss events.
+func (f *ArchiveRangeFeed) Serve(ctx context.Context) error {
+	defer close(f.events)
+
+	clock := clockOrSystem(f.Clock)
+	var first, start time.Time
+
+	// Objects are listed by hour, the granularity of their key prefix.
+	for hour := f.from.Truncate(time.Hour); hour.Before(f.to); hour = hour.Add(time.Hour) {
+		keys, err := f.store.List(ctx, hour.Format(archiveKeyLayout)+"/")
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			t, ok := archiveObjectTime(key)
+			if !ok || t.Before(f.from) || !t.Before(f.to) {
+				continue
+			}
+
+			events, err := f.read(ctx, key)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %v", f.store.Name(), key, err)
+			}
+			if len(events) == 0 {
+				continue
+			}
+
+			if f.Speed > 0 {
+				if first.IsZero() {
+					first, start = t, clock.Now()
+				}
+				due := start.Add(time.Duration(float64(t.Sub(first)) / f.Speed))
+				select {
+				case <-clock.After(due.Sub(clock.Now())):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			select {
+			case f.events <- events:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f *ArchiveRangeFeed) read(ctx context.Context, key string) ([]*github.Event, error) {
+	body, err := f.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compacted objects are multiple gzip members, read as one stream.
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var events []*github.Event
+	var expander RecordExpander
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		data, err := expander.Expand(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		} else if data == nil {
+			continue
+		}
+
+		data, err = MigrateRecord(data, SchemaVersion)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if record.Event != nil {
+			events = append(events, record.Event)
+		}
+	}
+
+	return events, scanner.Err()
+}
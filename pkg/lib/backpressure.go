@@ -0,0 +1,263 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Backpressure policies, see Config.Backpressure.
+const (
+	// Publishing blocks until the consumer makes room, holding the polls
+	// back, the default.
+	BackpressureBlock = "block"
+	// The oldest batch queued is dropped to make room for the new one.
+	BackpressureDropOldest = "drop-oldest"
+	// The new batch is dropped.
+	BackpressureDropNewest = "drop-newest"
+	// The new batch is spilled to a file of Config.SpillDir, and queued
+	// once the consumer caught up with the batches spilled before it.
+	BackpressureSpill = "spill"
+)
+
+// Name of the spill file in Config.SpillDir.
+const spillFileName = "events.spill"
+
+func validBackpressure(policy string) error {
+	switch policy {
+	case "", BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest, BackpressureSpill:
+		return nil
+	}
+	return fmt.Errorf("unknown policy '%s', expected %s, %s, %s or %s", policy,
+		BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest, BackpressureSpill)
+}
+
+// splitBatch splits a batch in batches of at most max events, see
+// Config.MaxBatchEvents.
+func splitBatch(events []*github.Event, max int) [][]*github.Event {
+	if max <= 0 || len(events) <= max {
+		return [][]*github.Event{events}
+	}
+
+	batches := make([][]*github.Event, 0, (len(events)+max-1)/max)
+	for len(events) > max {
+		batches = append(batches, events[:max:max])
+		events = events[max:]
+	}
+	return append(batches, events)
+}
+
+// enqueue queues a batch on the events channel, applying the backpressure
+// policy once it is full.
+func (f *EventFeed) enqueue(ctx context.Context, events []*github.Event) error {
+	switch f.backpressure {
+	case BackpressureDropNewest:
+		select {
+		case f.events <- events:
+			f.metrics.observeBatch(events)
+		default:
+			f.drop(events)
+		}
+		return nil
+
+	case BackpressureDropOldest:
+		for {
+			select {
+			case f.events <- events:
+				f.metrics.observeBatch(events)
+				return nil
+			default:
+			}
+			// The consumer may have made room meanwhile.
+			select {
+			case old := <-f.events:
+				f.drop(old)
+			default:
+			}
+		}
+
+	case BackpressureSpill:
+		if err := f.spill.push(events); err != nil {
+			return err
+		}
+		f.metrics.observeBatch(events)
+		return nil
+	}
+
+	select {
+	case f.events <- events:
+		f.metrics.observeBatch(events)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.ctx.Done():
+		return f.ctx.Err()
+	}
+}
+
+// drop accounts a batch dropped by the backpressure policy.
+func (f *EventFeed) drop(events []*github.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	atomic.AddUint64(&f.metrics.dropped, uint64(len(events)))
+	warnf("Events queue full, dropped a batch of %d events", len(events))
+	if f.hooks.onDrop != nil {
+		f.hooks.onDrop(events)
+	}
+}
+
+// closeEvents closes the events channel once the spilled batches were
+// queued, or the feed's context is done.
+func (f *EventFeed) closeEvents() {
+	if f.spill != nil {
+		f.spill.close()
+	}
+	close(f.events)
+}
+
+// spillQueue holds the batches published while the events channel is full
+// in a spillFile, and queues them in order once the consumer makes room.
+// Batches left in the file when the feed stops are queued by the next feed
+// spilling to the same directory.
+type spillQueue struct {
+	queue chan<- []*github.Event
+
+	mu   sync.Mutex
+	file *spillFile
+
+	ready   chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+	started bool
+}
+
+func openSpillQueue(dir string, queue chan<- []*github.Event) (*spillQueue, error) {
+	file, err := openSpillFile(filepath.Join(dir, spillFileName))
+	if err != nil {
+		return nil, err
+	}
+	if file.pending > 0 {
+		log.Printf("Queuing %d batches spilled to %s", file.pending, file.path)
+	}
+
+	return &spillQueue{
+		queue:   queue,
+		file:    file,
+		ready:   make(chan struct{}, 1),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// push queues a batch, directly if the channel has room and nothing is
+// spilled ahead of it.
+func (q *spillQueue) push(events []*github.Event) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file.pending == 0 {
+		select {
+		case q.queue <- events:
+			return nil
+		default:
+		}
+	}
+
+	if err := q.file.append(events); err != nil {
+		return err
+	}
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// depth returns the number of batches spilled.
+func (q *spillQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.file.pending
+}
+
+// run queues the spilled batches until close, once they were all queued, or
+// ctx is done. Errors reading the file are reported to report, the batches
+// left in it are then dropped.
+func (q *spillQueue) run(ctx context.Context, report func(error)) {
+	defer close(q.done)
+
+	for {
+		q.mu.Lock()
+		if q.file.pending == 0 {
+			q.mu.Unlock()
+			select {
+			case <-q.ready:
+				continue
+			case <-q.closing:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+		events, err := q.file.read()
+		if err != nil {
+			q.file.drop()
+		}
+		q.mu.Unlock()
+
+		if err != nil {
+			report(fmt.Errorf("reading the spilled batches: %v", err))
+			continue
+		}
+
+		select {
+		case q.queue <- events:
+		case <-ctx.Done():
+			return
+		}
+
+		q.mu.Lock()
+		q.file.done()
+		q.mu.Unlock()
+	}
+}
+
+// start runs the queue in the background, once.
+func (q *spillQueue) start(ctx context.Context, report func(error)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.started {
+		q.started = true
+		go q.run(ctx, report)
+	}
+}
+
+func (q *spillQueue) close() {
+	q.mu.Lock()
+	started := q.started
+	q.mu.Unlock()
+
+	if started {
+		close(q.closing)
+		<-q.done
+	}
+	pending := q.file.pending
+	if err := q.file.close(); err != nil {
+		warnf("Failed saving the %d spilled batches left: %v", pending, err)
+	}
+}
+
+func (f *EventFeed) spillDepth() int {
+	if f.spill == nil {
+		return 0
+	}
+	return f.spill.depth()
+}
@@ -0,0 +1,173 @@
+package lib_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// backpressureServer serves 5 events on the first poll, none afterwards.
+func backpressureServer() *httptest.Server {
+	var polls int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&polls, 1) > 1 {
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`[{"id":"5","type":"PushEvent","actor":{"login":"a"}},{"id":"4","type":"PushEvent","actor":{"login":"a"}},` +
+			`{"id":"3","type":"PushEvent","actor":{"login":"a"}},{"id":"2","type":"PushEvent","actor":{"login":"a"}},` +
+			`{"id":"1","type":"PushEvent","actor":{"login":"a"}}]`))
+	}))
+}
+
+func batchIDs(batch []*github.Event) []string {
+	ids := make([]string, 0, len(batch))
+	for _, ev := range batch {
+		ids = append(ids, ev.GetID())
+	}
+	return ids
+}
+
+func TestBackpressureDrop(t *testing.T) {
+	for policy, want := range map[string][]string{
+		lib.BackpressureDropNewest: {"5", "4"},
+		lib.BackpressureDropOldest: {"1"},
+	} {
+		server := backpressureServer()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		clock := lib.NewSimulatedClock(time.Now())
+		feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+			BaseURL:        server.URL,
+			Clock:          clock,
+			QueueSize:      1,
+			MaxBatchEvents: 2,
+			Backpressure:   policy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var mu sync.Mutex
+		var dropped int
+		feed.OnDrop(func(events []*github.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			dropped += len(events)
+		})
+		go feed.Serve()
+
+		// The poll doesn't block on the full queue.
+		waitForTimer(t, clock)
+		if got := batchIDs(<-events); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got the batch %v, want %v", policy, got, want)
+		}
+		mu.Lock()
+		if dropped != 5-len(want) {
+			t.Errorf("%s: dropped %d events, want %d", policy, dropped, 5-len(want))
+		}
+		mu.Unlock()
+
+		cancel()
+		server.Close()
+	}
+}
+
+func TestBackpressureSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	serve := func(ctx context.Context, server *httptest.Server) (<-chan []*github.Event, *lib.SimulatedClock, chan error) {
+		clock := lib.NewSimulatedClock(time.Now())
+		feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+			BaseURL:        server.URL,
+			Clock:          clock,
+			QueueSize:      1,
+			MaxBatchEvents: 2,
+			Backpressure:   lib.BackpressureSpill,
+			SpillDir:       dir,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- feed.Serve() }()
+		return events, clock, done
+	}
+
+	// The feed stops with batches spilled, published by the next one.
+	server := backpressureServer()
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	events, clock, done := serve(ctx, server)
+	waitForTimer(t, clock)
+	cancel()
+	<-done
+	var queued [][]string
+	for batch := range events {
+		queued = append(queued, batchIDs(batch))
+	}
+	if want := [][]string{{"5", "4"}}; !reflect.DeepEqual(queued, want) {
+		t.Errorf("got the batches %v, want %v", queued, want)
+	}
+
+	spilled, err := ioutil.ReadFile(filepath.Join(dir, "events.spill"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spilled) == 0 {
+		t.Fatal("nothing left spilled")
+	}
+
+	server = backpressureServer()
+	defer server.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	events, _, _ = serve(ctx, server)
+
+	var got [][]string
+	for len(got) < 4 {
+		select {
+		case batch := <-events:
+			if len(batch) > 0 {
+				got = append(got, batchIDs(batch))
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out, got %v", got)
+		}
+	}
+	// The batches spilled by the first feed precede those of the second,
+	// in order.
+	if want := [][]string{{"3", "2"}, {"1"}, {"5", "4"}, {"3", "2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got batches %v, want %v", got, want)
+	}
+}
+
+func TestBackpressureValidate(t *testing.T) {
+	for conf, valid := range map[*lib.Config]bool{
+		{Backpressure: lib.BackpressureDropOldest}:               true,
+		{Backpressure: "drop"}:                                   false,
+		{Backpressure: lib.BackpressureSpill}:                    false,
+		{Backpressure: lib.BackpressureSpill, SpillDir: "spill"}: true,
+		{MaxBatchEvents: -1}:                                     false,
+	} {
+		if err := conf.Validate(); (err == nil) != valid {
+			t.Errorf("%+v: got %v", conf, err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BandwidthStats are the totals of a MeteredTransport.
+type BandwidthStats struct {
+	Requests uint64
+	// Bytes of the request and response bodies as transferred.
+	WireBytes uint64
+	// Bytes of the response bodies once decompressed.
+	DecodedBytes uint64
+	// Time requests waited for the bandwidth cap.
+	Throttled time.Duration
+}
+
+// MeteredTransport measures the bandwidth of the requests going through it,
+// and caps it when metered links call for it. Unless the caller negotiates
+// the encoding itself, it requests gzip-compressed responses and decodes
+// them, so that the bytes on the wire are told apart from the decoded ones
+// (http.Transport decompresses transparently, hiding them).
+//
+// The cap is enforced on the wire bytes, as the bodies are transferred: the
+// reads of response bodies block, which slows the transfer down through TCP
+// flow control. Transports register themselves by name, see
+// WriteBandwidthMetrics.
+type MeteredTransport struct {
+	name string
+	base http.RoundTripper
+
+	requests     uint64
+	wireBytes    uint64
+	decodedBytes uint64
+	throttled    int64
+
+	mu     sync.Mutex
+	bucket *tokenBucket
+}
+
+var meteredTransports = struct {
+	sync.Mutex
+	byName map[string]*MeteredTransport
+}{byName: make(map[string]*MeteredTransport)}
+
+// NewMeteredTransport meters base (http.DefaultTransport if nil) under name,
+// replacing a previous transport of the same name in the metrics, and caps
+// its bandwidth to limit bytes per second, unlimited if not positive.
+func NewMeteredTransport(name string, base http.RoundTripper, limit int64) *MeteredTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	t := &MeteredTransport{name: name, base: base}
+	if limit > 0 {
+		// A second worth of bytes may be transferred at once.
+		t.bucket = newTokenBucket(float64(limit), int(limit), time.Now())
+	}
+
+	meteredTransports.Lock()
+	meteredTransports.byName[name] = t
+	meteredTransports.Unlock()
+
+	return t
+}
+
+func (t *MeteredTransport) Stats() BandwidthStats {
+	return BandwidthStats{
+		Requests:     atomic.LoadUint64(&t.requests),
+		WireBytes:    atomic.LoadUint64(&t.wireBytes),
+		DecodedBytes: atomic.LoadUint64(&t.decodedBytes),
+		Throttled:    time.Duration(atomic.LoadInt64(&t.throttled)),
+	}
+}
+
+// transfer accounts n bytes on the wire, and waits for the cap to allow them.
+func (t *MeteredTransport) transfer(ctx context.Context, n int) error {
+	atomic.AddUint64(&t.wireBytes, uint64(n))
+	if t.bucket == nil || n <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	wait := t.bucket.reserveN(time.Now(), n)
+	t.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	atomic.AddInt64(&t.throttled, int64(wait))
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *MeteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	decode := req.Header.Get("Accept-Encoding") == ""
+	if decode {
+		// RoundTrippers must not modify the caller's request.
+		req = req.Clone(ctx)
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	atomic.AddUint64(&t.requests, 1)
+	if req.ContentLength > 0 {
+		if err := t.transfer(ctx, int(req.ContentLength)); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	wire := &meteredBody{transport: t, ctx: ctx, body: resp.Body}
+	if decode && strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		resp.Body = &decodedBody{transport: t, wire: wire}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	} else {
+		wire.decoded = true
+		resp.Body = wire
+	}
+
+	return resp, nil
+}
+
+// meteredBody accounts the bytes of a response body as read from the wire,
+// also as decoded bytes when it isn't compressed.
+type meteredBody struct {
+	transport *MeteredTransport
+	ctx       context.Context
+	body      io.ReadCloser
+	decoded   bool
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if b.decoded {
+		atomic.AddUint64(&b.transport.decodedBytes, uint64(n))
+	}
+	if werr := b.transport.transfer(b.ctx, n); werr != nil && err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+func (b *meteredBody) Close() error {
+	return b.body.Close()
+}
+
+// decodedBody decompresses a gzip-encoded body, lazily so that empty bodies
+// (e.g. of HEAD requests) don't fail.
+type decodedBody struct {
+	transport *MeteredTransport
+	wire      *meteredBody
+	gz        *gzip.Reader
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.gz == nil {
+		gz, err := gzip.NewReader(b.wire)
+		if err != nil {
+			return 0, err
+		}
+		b.gz = gz
+	}
+
+	n, err := b.gz.Read(p)
+	atomic.AddUint64(&b.transport.decodedBytes, uint64(n))
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	return b.wire.Close()
+}
+
+// WriteBandwidthMetrics writes the statistics of the metered transports in
+// the Prometheus text format, labeled by transport name:
+//
+//	github_feed_http_requests_total{transport}          requests sent
+//	github_feed_http_wire_bytes_total{transport}        body bytes transferred
+//	github_feed_http_decoded_bytes_total{transport}     response body bytes decoded
+//	github_feed_http_throttled_seconds_total{transport} time waited for the cap
+func WriteBandwidthMetrics(w io.Writer) {
+	meteredTransports.Lock()
+	names := make([]string, 0, len(meteredTransports.byName))
+	stats := make(map[string]BandwidthStats, len(meteredTransports.byName))
+	for name, t := range meteredTransports.byName {
+		names = append(names, name)
+		stats[name] = t.Stats()
+	}
+	meteredTransports.Unlock()
+
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	series := []struct {
+		name, help string
+		value      func(BandwidthStats) interface{}
+	}{
+		{"http_requests_total", "Requests sent by the transport.", func(s BandwidthStats) interface{} { return s.Requests }},
+		{"http_wire_bytes_total", "Bytes of the request and response bodies transferred by the transport.", func(s BandwidthStats) interface{} { return s.WireBytes }},
+		{"http_decoded_bytes_total", "Bytes of the response bodies of the transport once decompressed.", func(s BandwidthStats) interface{} { return s.DecodedBytes }},
+		{"http_throttled_seconds_total", "Time the requests of the transport waited for the bandwidth cap.", func(s BandwidthStats) interface{} { return s.Throttled.Seconds() }},
+	}
+
+	for _, s := range series {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n", statsNamespace, s.name, s.help)
+		fmt.Fprintf(w, "# TYPE %s_%s counter\n", statsNamespace, s.name)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s_%s{transport=\"%s\"} %v\n", statsNamespace, s.name, labelEscaper.Replace(name), s.value(stats[name]))
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package lib_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestMeteredTransportDecodes(t *testing.T) {
+	body := strings.Repeat(`{"type":"PushEvent"}`, 500)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(body))
+	gz.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	transport := lib.NewMeteredTransport("test-decode", nil, 0)
+	resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(got) != body || resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("unexpected response of %d bytes, %v: %v", len(got), resp.Header, err)
+	}
+
+	stats := transport.Stats()
+	if stats.Requests != 1 || stats.WireBytes != uint64(compressed.Len()) || stats.DecodedBytes != uint64(len(body)) {
+		t.Errorf("unexpected stats %+v, want %d bytes on the wire for %d decoded", stats, compressed.Len(), len(body))
+	}
+
+	var metrics bytes.Buffer
+	lib.WriteBandwidthMetrics(&metrics)
+	if !strings.Contains(metrics.String(), `github_feed_http_decoded_bytes_total{transport="test-decode"} 10000`) {
+		t.Errorf("unexpected metrics:\n%s", metrics.String())
+	}
+}
+
+func TestMeteredTransportCaps(t *testing.T) {
+	body := bytes.Repeat([]byte{'x'}, 20000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer server.Close()
+
+	// The first second worth of bytes is transferred at once.
+	transport := lib.NewMeteredTransport("test-cap", nil, 10000)
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept-Encoding", "identity")
+
+	began := time.Now()
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(got) != len(body) {
+		t.Fatalf("read %d bytes: %v", len(got), err)
+	}
+
+	if elapsed := time.Since(began); elapsed < 900*time.Millisecond {
+		t.Errorf("transferred 20000 bytes at 10000/s in %v, want at least 1s", elapsed)
+	}
+	if stats := transport.Stats(); stats.WireBytes != 20000 || stats.DecodedBytes != 20000 || stats.Throttled <= 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,213 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Estimated size of an event's fields besides its payload.
+const eventEnvelopeSize = 512
+
+var ErrSinkClosed = errors.New("sink closed")
+
+// BatchPolicy bounds the batches written by a BatchingSink, a zero field
+// is unbounded.
+type BatchPolicy struct {
+	MaxRecords int
+	// Estimated from the payload sizes.
+	MaxBytes int
+	// Maximum time an event is buffered before its batch is flushed.
+	MaxLatency time.Duration
+}
+
+// BatchingSink accumulates events and writes them to a sink in batches
+// bounded by a policy, so that sinks favoring large writes don't each
+// implement their own flushing. Close flushes the buffered events.
+type BatchingSink struct {
+	sink   Sink
+	policy BatchPolicy
+	// Called with the errors of the flushes triggered by MaxLatency, which
+	// have no caller to return them to.
+	OnError func(error)
+	// The events matching Priority, if set, aren't buffered: they are
+	// written right away, ahead of the buffered events. Those sharing their
+	// Key with an event written before them are buffered all the same, the
+	// events of a key keep their order. A nil Key is shared by all events.
+	Priority func(*github.Event) bool
+	Key      *KeySpec
+
+	mu   sync.Mutex
+	buf  []*github.Event
+	size int
+	// Keys of the buffered events, tracked with Priority.
+	keys   map[string]bool
+	timer  *time.Timer
+	closed bool
+	// Serializes the writes to the sink, taken before mu is released by the
+	// late flushes so that Write keeps buffering while they are written.
+	wmu sync.Mutex
+}
+
+func NewBatchingSink(sink Sink, policy BatchPolicy) *BatchingSink {
+	return &BatchingSink{sink: sink, policy: policy, OnError: func(error) {}}
+}
+
+func eventSize(ev *github.Event) int {
+	if ev.RawPayload == nil {
+		return eventEnvelopeSize
+	}
+	return eventEnvelopeSize + len(*ev.RawPayload)
+}
+
+// full reports whether a batch of the given records and estimated size is
+// complete.
+func (p BatchPolicy) full(records, size int) bool {
+	return (p.MaxRecords > 0 && records >= p.MaxRecords) || (p.MaxBytes > 0 && size >= p.MaxBytes)
+}
+
+func (s *BatchingSink) full() bool {
+	return s.policy.full(len(s.buf), s.size)
+}
+
+// Write buffers the events, flushing synchronously whenever the batch is
+// full.
+func (s *BatchingSink) Write(ctx context.Context, events []*github.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSinkClosed
+	}
+
+	if s.Priority != nil {
+		if s.keys == nil {
+			s.keys = make(map[string]bool)
+		}
+		var urgent, bulk []*github.Event
+		// The keys of the bulk events of the batch.
+		later := make(map[string]bool)
+		for _, ev := range events {
+			key := s.key(ev)
+			if s.Priority(ev) && !s.keys[key] && !later[key] {
+				urgent = append(urgent, ev)
+			} else {
+				bulk = append(bulk, ev)
+				later[key] = true
+			}
+		}
+
+		if len(urgent) > 0 {
+			if err := s.write(ctx, urgent); err != nil {
+				return err
+			}
+		}
+		events = bulk
+	}
+
+	for _, ev := range events {
+		s.buf = append(s.buf, ev)
+		s.size += eventSize(ev)
+		if s.keys != nil {
+			s.keys[s.key(ev)] = true
+		}
+
+		if s.full() {
+			if err := s.flushLocked(ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(s.buf) > 0 && s.timer == nil && s.policy.MaxLatency > 0 {
+		s.timer = time.AfterFunc(s.policy.MaxLatency, s.flushLate)
+	}
+
+	return nil
+}
+
+func (s *BatchingSink) key(ev *github.Event) string {
+	if s.Key == nil {
+		return ""
+	}
+	key, _ := s.Key.Key(ev)
+	return key
+}
+
+func (s *BatchingSink) write(ctx context.Context, events []*github.Event) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	return s.sink.Write(ctx, events)
+}
+
+// take empties the buffer, returning the batch to write.
+func (s *BatchingSink) take() []*github.Event {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	batch := s.buf
+	s.buf, s.size = nil, 0
+	for key := range s.keys {
+		delete(s.keys, key)
+	}
+	return batch
+}
+
+func (s *BatchingSink) flushLocked(ctx context.Context) error {
+	batch := s.take()
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.write(ctx, batch)
+}
+
+// flushLate writes the batch buffered for MaxLatency without holding mu,
+// before any later batch.
+func (s *BatchingSink) flushLate() {
+	s.mu.Lock()
+	batch := s.take()
+	if len(batch) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	s.wmu.Lock()
+	s.mu.Unlock()
+
+	err := s.sink.Write(context.Background(), batch)
+	s.wmu.Unlock()
+	if err != nil {
+		s.OnError(err)
+	}
+}
+
+// Flush writes the buffered events.
+func (s *BatchingSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked(ctx)
+}
+
+// Close flushes the buffered events and closes the sink.
+func (s *BatchingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.flushLocked(context.Background())
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if cerr := s.sink.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
@@ -0,0 +1,70 @@
+package lib_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestBatchingSink(t *testing.T) {
+	recorded := &retainingSink{}
+	sink := lib.NewBatchingSink(recorded, lib.BatchPolicy{MaxRecords: 3})
+	sink.Priority = func(ev *github.Event) bool { return ev.GetRepo().GetName() != "o/r" || ev.GetID() == "6" }
+	sink.Key, _ = lib.ParseKeySpec("repo.name")
+
+	// 6 is held behind the buffered events of its repository.
+	for _, events := range [][]*github.Event{
+		{testEvent("1", "PushEvent", "", "o/r", time.Time{}, ""), testEvent("2", "PushEvent", "", "o/r", time.Time{}, "")},
+		{testEvent("3", "PushEvent", "", "o/r", time.Time{}, ""), testEvent("4", "PushEvent", "", "o/urgent", time.Time{}, ""), testEvent("5", "PushEvent", "", "o/r", time.Time{}, "")},
+		{testEvent("6", "PushEvent", "", "o/r", time.Time{}, ""), testEvent("7", "PushEvent", "", "o/r", time.Time{}, "")},
+	} {
+		if err := sink.Write(context.Background(), events); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := recorded.ids(), "4 1,2,3 5,6,7"; got != want {
+		t.Errorf("got batches %s, want %s", got, want)
+	}
+	if err := sink.Write(context.Background(), []*github.Event{testEvent("8", "PushEvent", "", "o/r", time.Time{}, "")}); err != lib.ErrSinkClosed {
+		t.Errorf("got %v writing to a closed sink, want %v", err, lib.ErrSinkClosed)
+	}
+}
+
+func TestBatchingSinkLateFlush(t *testing.T) {
+	stalled := &gatedSink{gate: make(chan struct{})}
+	sink := lib.NewBatchingSink(stalled, lib.BatchPolicy{MaxRecords: 100, MaxLatency: 10 * time.Millisecond})
+
+	if err := sink.Write(context.Background(), []*github.Event{testEvent("1", "PushEvent", "", "o/r", time.Time{}, "")}); err != nil {
+		t.Fatal(err)
+	}
+	// The late flush of 1 is stalled, the next events are buffered meanwhile.
+	time.Sleep(50 * time.Millisecond)
+	written := make(chan error)
+	go func() {
+		written <- sink.Write(context.Background(), []*github.Event{testEvent("2", "PushEvent", "", "o/r", time.Time{}, "")})
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked by the late flush")
+	}
+
+	close(stalled.gate)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(stalled.ids, ","); got != "1,2" {
+		t.Errorf("wrote %s, want 1,2", got)
+	}
+}
@@ -0,0 +1,23 @@
+package lib
+
+import (
+	"regexp"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// BotDetector decides whether the actor of an event is a bot.
+type BotDetector interface {
+	IsBot(ev *github.Event) bool
+}
+
+// Github bots ends with `[?bot]?`. This will induce false positives, but we
+// can tolerate it.
+var botLoginMatcher = regexp.MustCompile(`(?i)\[?bot\]?$`)
+
+// LoginBotDetector guesses bots from their login.
+type LoginBotDetector struct{}
+
+func (LoginBotDetector) IsBot(ev *github.Event) bool {
+	return botLoginMatcher.MatchString(ev.GetActor().GetLogin())
+}
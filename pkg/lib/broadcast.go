@@ -0,0 +1,316 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	defaultReplayRetention = 5 * time.Minute
+	defaultReplayCapacity  = 64 * 1024
+	defaultSubscriberQueue = 1024
+)
+
+// SequencedEvent is an event tagged with its position in the broadcast
+// stream. Subscribers use the sequence number as their cursor.
+type SequencedEvent struct {
+	Seq      uint64
+	Event    *github.Event
+	received time.Time
+}
+
+// Cursor is a position in the broadcast stream. Sequence numbers restart
+// with the process, the epoch tells the sequences of two processes apart.
+type Cursor struct {
+	Epoch string
+	Seq   uint64
+}
+
+// String formats the cursor as the `<epoch>-<seq>` event id.
+func (c Cursor) String() string {
+	return c.Epoch + "-" + strconv.FormatUint(c.Seq, 10)
+}
+
+// ParseCursor parses an `<epoch>-<seq>` event id. A bare sequence number,
+// as handed out before the ids had an epoch, parses with an empty epoch.
+func ParseCursor(s string) (Cursor, error) {
+	var c Cursor
+	seq := s
+	if i := strings.LastIndexByte(s, '-'); i >= 0 {
+		c.Epoch, seq = s[:i], s[i+1:]
+	}
+	var err error
+	c.Seq, err = strconv.ParseUint(seq, 10, 64)
+	return c, err
+}
+
+// Broadcaster fans out events to subscribers and keeps a bounded replay
+// buffer (by age and count), so that a subscriber reconnecting with its last
+// seen cursor receives the events it missed.
+type Broadcaster struct {
+	// Number of events buffered per subscriber before it is disconnected
+	// for being too slow.
+	SubscriberQueue int
+	// Format of the streamed records, JSON if nil. Subscribers may pick
+	// another registered format with the format query parameter.
+	Serializer Serializer
+
+	epoch     string
+	mu        sync.Mutex
+	seq       uint64
+	buffer    []SequencedEvent
+	retention time.Duration
+	capacity  int
+	subs      map[*Subscription]struct{}
+}
+
+// Subscription is a subscriber's view of the broadcast. The channel is
+// closed when the subscriber falls too far behind, it should reconnect with
+// its cursor to resume from the replay buffer.
+type Subscription struct {
+	C <-chan SequencedEvent
+	// Gap is set when events between the requested cursor and the oldest
+	// buffered event were already evicted.
+	Gap bool
+
+	ch          chan SequencedEvent
+	broadcaster *Broadcaster
+}
+
+func NewBroadcaster(retention time.Duration, capacity int) *Broadcaster {
+	if retention <= 0 {
+		retention = defaultReplayRetention
+	}
+	if capacity <= 0 {
+		capacity = defaultReplayCapacity
+	}
+
+	return &Broadcaster{
+		SubscriberQueue: defaultSubscriberQueue,
+		epoch:           newEpoch(),
+		retention:       retention,
+		capacity:        capacity,
+		subs:            make(map[*Subscription]struct{}),
+	}
+}
+
+// newEpoch draws the random epoch of a broadcaster, falling back to its
+// start time.
+func newEpoch() string {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// BroadcastStats is a snapshot of the broadcaster's state.
+type BroadcastStats struct {
+	Epoch       string    `json:"epoch"`
+	Seq         uint64    `json:"seq"`
+	Buffered    int       `json:"buffered"`
+	Subscribers int       `json:"subscribers"`
+	LastPublish time.Time `json:"last_publish"`
+}
+
+func (b *Broadcaster) Stats() BroadcastStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := BroadcastStats{Epoch: b.epoch, Seq: b.seq, Buffered: len(b.buffer), Subscribers: len(b.subs)}
+	if len(b.buffer) > 0 {
+		stats.LastPublish = b.buffer[len(b.buffer)-1].received
+	}
+
+	return stats
+}
+
+func (b *Broadcaster) trim(now time.Time) {
+	i := 0
+	for i < len(b.buffer) && (len(b.buffer)-i > b.capacity || now.Sub(b.buffer[i].received) > b.retention) {
+		i++
+	}
+
+	if i > 0 {
+		b.buffer = append(b.buffer[:0:0], b.buffer[i:]...)
+	}
+}
+
+// Publish assigns sequence numbers to the events and delivers them to every
+// subscriber.
+func (b *Broadcaster) Publish(events []*github.Event) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ev := range events {
+		b.seq++
+		sev := SequencedEvent{Seq: b.seq, Event: ev, received: now}
+		b.buffer = append(b.buffer, sev)
+
+		for sub := range b.subs {
+			select {
+			case sub.ch <- sev:
+			default:
+				// Don't let a slow subscriber hold the others, it will
+				// resume from the replay buffer.
+				b.drop(sub)
+			}
+		}
+	}
+
+	b.trim(now)
+}
+
+func (b *Broadcaster) drop(sub *Subscription) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Subscribe registers a subscriber which will first receive the buffered
+// events following the cursor, use the zero Cursor to only receive new
+// events. A cursor of another epoch, i.e. handed out before a restart,
+// replays them all with a Gap.
+func (b *Broadcaster) Subscribe(cursor Cursor) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trim(time.Now())
+
+	// The events of a previous process are lost and its sequence numbers
+	// mean nothing here: everything buffered is new to the subscriber.
+	restarted := cursor != Cursor{} && (cursor.Epoch != b.epoch || cursor.Seq > b.seq)
+
+	var replay []SequencedEvent
+	if restarted {
+		replay = b.buffer
+	} else if cursor.Seq > 0 {
+		for i, sev := range b.buffer {
+			if sev.Seq > cursor.Seq {
+				replay = b.buffer[i:]
+				break
+			}
+		}
+	}
+
+	ch := make(chan SequencedEvent, len(replay)+orDefault(b.SubscriberQueue, defaultSubscriberQueue))
+	for _, sev := range replay {
+		ch <- sev
+	}
+
+	sub := &Subscription{C: ch, ch: ch, broadcaster: b}
+	if restarted {
+		sub.Gap = true
+	} else if cursor.Seq > 0 && cursor.Seq < b.seq {
+		oldest := b.seq + 1
+		if len(b.buffer) > 0 {
+			oldest = b.buffer[0].Seq
+		}
+		sub.Gap = cursor.Seq+1 < oldest
+	}
+
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+func (s *Subscription) Close() {
+	s.broadcaster.mu.Lock()
+	defer s.broadcaster.mu.Unlock()
+	s.broadcaster.drop(s)
+}
+
+// cursorFromRequest reads the cursor from the standard SSE `Last-Event-ID`
+// header or from the `since` query parameter.
+func cursorFromRequest(r *http.Request) (Cursor, error) {
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.URL.Query().Get("since")
+	}
+	if cursor == "" {
+		return Cursor{}, nil
+	}
+	return ParseCursor(cursor)
+}
+
+// ServeHTTP streams events as server-sent events, the event id being the
+// cursor. A `filter` query parameter restricts the stream with a
+// filter expression (see Filter).
+func (b *Broadcaster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	cursor, err := cursorFromRequest(r)
+	if err != nil {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := ParseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	serializer := serializerOrDefault(b.Serializer)
+	if format := r.URL.Query().Get("format"); format != "" {
+		if serializer, err = LookupSerializer(format); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub := b.Subscribe(cursor)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if sub.Gap {
+		fmt.Fprintf(w, "event: gap\ndata: events following %s are missing from the replay buffer\n\n", cursor)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case sev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			if !filter.Match(sev.Event) {
+				continue
+			}
+
+			data, err := serializer.Marshal(NewRecord(sev.Event))
+			if err != nil {
+				continue
+			}
+			if serializer.Binary() {
+				data = []byte(base64.StdEncoding.EncodeToString(data))
+			}
+
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", Cursor{b.epoch, sev.Seq}, sev.Event.GetType(), data)
+			if len(sub.C) == 0 {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func publish(b *lib.Broadcaster, ids ...string) {
+	for _, id := range ids {
+		b.Publish([]*github.Event{testEvent(id, "PushEvent", "a", "o/r", time.Time{}, "")})
+	}
+}
+
+func TestBroadcasterSubscribeGap(t *testing.T) {
+	b := lib.NewBroadcaster(time.Hour, 3)
+	publish(b, "1", "2", "3", "4", "5")
+	epoch := b.Stats().Epoch
+
+	for _, c := range []struct {
+		name   string
+		cursor lib.Cursor
+		gap    bool
+		replay []uint64
+	}{
+		{"new events only", lib.Cursor{}, false, nil},
+		{"up to date", lib.Cursor{epoch, 5}, false, nil},
+		{"buffered", lib.Cursor{epoch, 3}, false, []uint64{4, 5}},
+		{"evicted", lib.Cursor{epoch, 1}, true, []uint64{3, 4, 5}},
+		{"ahead", lib.Cursor{epoch, 42}, true, []uint64{3, 4, 5}},
+		{"without epoch", lib.Cursor{Seq: 4}, true, []uint64{3, 4, 5}},
+	} {
+		sub := b.Subscribe(c.cursor)
+		var replay []uint64
+		for len(sub.C) > 0 {
+			replay = append(replay, (<-sub.C).Seq)
+		}
+		sub.Close()
+
+		if sub.Gap != c.gap {
+			t.Errorf("%s: got gap %v, want %v", c.name, sub.Gap, c.gap)
+		}
+		if len(replay) != len(c.replay) {
+			t.Errorf("%s: got replay %v, want %v", c.name, replay, c.replay)
+			continue
+		}
+		for i := range replay {
+			if replay[i] != c.replay[i] {
+				t.Errorf("%s: got replay %v, want %v", c.name, replay, c.replay)
+				break
+			}
+		}
+	}
+}
+
+func TestBroadcasterRestart(t *testing.T) {
+	before := lib.NewBroadcaster(time.Hour, 10)
+	publish(before, "1", "2")
+	cursor, err := lib.ParseCursor(lib.Cursor{before.Stats().Epoch, 2}.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new process is further along than the cursor, its events 1 and 2
+	// aren't those the subscriber saw.
+	after := lib.NewBroadcaster(time.Hour, 10)
+	publish(after, "3", "4", "5")
+	sub := after.Subscribe(cursor)
+	defer sub.Close()
+
+	if !sub.Gap {
+		t.Error("no gap after a restart")
+	}
+	if n := len(sub.C); n != 3 {
+		t.Errorf("replayed %d events, want 3", n)
+	}
+}
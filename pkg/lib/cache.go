@@ -0,0 +1,238 @@
+package lib
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CacheConfig bounds a Cache, a zero field is unbounded.
+type CacheConfig struct {
+	MaxEntries int
+	// Of the sizes given to Set.
+	MaxBytes int
+	// Entries expire TTL after they were set.
+	TTL time.Duration
+	// Clock timing the expirations, the system clock if nil.
+	Clock Clock
+}
+
+// Cache is a memory-bounded LRU cache with expiration, shared by the
+// enrichers (and available to plugins) so that every cache is bounded the
+// same way and exposes the same metrics. Caches register themselves by name,
+// see WriteCacheMetrics.
+type Cache struct {
+	name string
+	conf CacheConfig
+
+	mu          sync.Mutex
+	lru         *list.List
+	items       map[string]*list.Element
+	bytes       int
+	hits        uint64
+	misses      uint64
+	evictions   uint64
+	expirations uint64
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	size    int
+	expires time.Time
+}
+
+var caches = struct {
+	sync.Mutex
+	byName map[string]*Cache
+}{byName: make(map[string]*Cache)}
+
+// NewCache returns a cache reported under name in the metrics, replacing a
+// previous cache of the same name.
+func NewCache(name string, conf CacheConfig) *Cache {
+	c := &Cache{name: name, conf: conf, lru: list.New(), items: make(map[string]*list.Element)}
+
+	caches.Lock()
+	caches.byName[name] = c
+	caches.Unlock()
+
+	return c
+}
+
+func (c *Cache) now() time.Time {
+	return clockOrSystem(c.conf.Clock).Now()
+}
+
+// Get returns the value of key, and false if it is missing or expired.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if ok {
+		if e := el.Value.(*cacheEntry); e.expires.IsZero() || c.now().Before(e.expires) {
+			c.hits++
+			c.lru.MoveToFront(el)
+			return e.value, true
+		}
+
+		c.expirations++
+		c.remove(el)
+	}
+
+	c.misses++
+	return nil, false
+}
+
+// Set caches the value of key, size is its estimated size in bytes.
+func (c *Cache) Set(key string, value interface{}, size int) {
+	c.SetAt(key, value, size, c.now())
+}
+
+// SetAt caches a value obtained at the given time, e.g. when loading
+// persisted entries, so that it expires TTL after that time.
+func (c *Cache) SetAt(key string, value interface{}, size int, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &cacheEntry{key: key, value: value, size: size}
+	if c.conf.TTL > 0 {
+		e.expires = at.Add(c.conf.TTL)
+		if !c.now().Before(e.expires) {
+			return
+		}
+	}
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	c.items[key] = c.lru.PushFront(e)
+	c.bytes += size
+
+	for c.lru.Len() > 0 && ((c.conf.MaxEntries > 0 && c.lru.Len() > c.conf.MaxEntries) ||
+		(c.conf.MaxBytes > 0 && c.bytes > c.conf.MaxBytes)) {
+		c.evictions++
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.items, e.key)
+	c.bytes -= e.size
+}
+
+// Range calls fn with the live entries, most recently used first, until it
+// returns false. fn must not use the cache.
+func (c *Cache) Range(fn func(key string, value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*cacheEntry)
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			continue
+		}
+		if !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// CacheStats are the counters of a Cache.
+type CacheStats struct {
+	Entries     int    `json:"entries"`
+	Bytes       int    `json:"bytes"`
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+}
+
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Entries:     c.lru.Len(),
+		Bytes:       c.bytes,
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+	}
+}
+
+// WriteCacheMetrics writes the statistics of the caches in the Prometheus
+// text format, labeled by cache name:
+//
+//	github_feed_cache_entries{cache}            live entries
+//	github_feed_cache_bytes{cache}              estimated size of the entries
+//	github_feed_cache_hits_total{cache}         lookups served
+//	github_feed_cache_misses_total{cache}       lookups missed (absent or expired)
+//	github_feed_cache_evictions_total{cache}    entries evicted by the bounds
+func WriteCacheMetrics(w io.Writer) {
+	caches.Lock()
+	names := make([]string, 0, len(caches.byName))
+	stats := make(map[string]CacheStats, len(caches.byName))
+	for name, c := range caches.byName {
+		names = append(names, name)
+		stats[name] = c.Stats()
+	}
+	caches.Unlock()
+
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	series := []struct {
+		name, kind, help string
+		value            func(CacheStats) interface{}
+	}{
+		{"cache_entries", "gauge", "Live entries of the cache.", func(s CacheStats) interface{} { return s.Entries }},
+		{"cache_bytes", "gauge", "Estimated size of the entries of the cache.", func(s CacheStats) interface{} { return s.Bytes }},
+		{"cache_hits_total", "counter", "Lookups served by the cache.", func(s CacheStats) interface{} { return s.Hits }},
+		{"cache_misses_total", "counter", "Lookups missed by the cache, absent or expired.", func(s CacheStats) interface{} { return s.Misses }},
+		{"cache_evictions_total", "counter", "Entries evicted to bound the cache.", func(s CacheStats) interface{} { return s.Evictions }},
+	}
+
+	for _, s := range series {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n", statsNamespace, s.name, s.help)
+		fmt.Fprintf(w, "# TYPE %s_%s %s\n", statsNamespace, s.name, s.kind)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s_%s{cache=\"%s\"} %d\n", statsNamespace, s.name, labelEscaper.Replace(name), s.value(stats[name]))
+		}
+	}
+}
+
+// httpCache adapts a Cache to httpcache.Cache, bounding the cached API
+// responses.
+type httpCache struct {
+	*Cache
+}
+
+func (c httpCache) Get(key string) ([]byte, bool) {
+	v, ok := c.Cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.([]byte), true
+}
+
+func (c httpCache) Set(key string, response []byte) {
+	c.Cache.Set(key, response, len(key)+len(response))
+}
@@ -0,0 +1,181 @@
+package lib
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gregjones/httpcache"
+)
+
+// CacheProxy serves the events endpoints of the GitHub API (events,
+// received_events and their public variants, of the network, users,
+// organizations and repositories) through the client of a feed, so that
+// sibling instances on the same host poll through it with -api-url: they
+// share its cache of API responses, and concurrent identical requests are
+// collapsed into one upstream request, so that N pipelines cost about the
+// upstream requests of one.
+//
+// Upstream requests are authenticated with the feed's token, those of the
+// clients are ignored: the rate limit is shared. The proxy doesn't
+// authenticate its clients, it doesn't serve the users endpoints scoped by
+// the token (see isEventsPath). The clients revalidating a
+// response (If-None-Match) get 304 Not Modified when it didn't change.
+type CacheProxy struct {
+	feed   *EventFeed
+	client *http.Client
+
+	mu       sync.Mutex
+	inflight map[string]*proxyCall
+
+	requests  uint64
+	collapsed uint64
+}
+
+type proxyCall struct {
+	done chan struct{}
+	resp *proxiedResponse
+	err  error
+}
+
+type proxiedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// CacheProxyStats are the totals of a CacheProxy.
+type CacheProxyStats struct {
+	Requests uint64
+	// Requests served by the upstream request of a concurrent one.
+	Collapsed uint64
+}
+
+func NewCacheProxy(feed *EventFeed) *CacheProxy {
+	return &CacheProxy{feed: feed, client: feed.httpClient, inflight: make(map[string]*proxyCall)}
+}
+
+func (p *CacheProxy) Stats() CacheProxyStats {
+	return CacheProxyStats{
+		Requests:  atomic.LoadUint64(&p.requests),
+		Collapsed: atomic.LoadUint64(&p.collapsed),
+	}
+}
+
+// isEventsPath tells whether the API path lists events, e.g.
+// /repos/o/r/events or /users/u/received_events/public. Listed with the
+// feed's token, the events of its user include the private ones: only the
+// public variants of the users endpoints are proxied.
+func isEventsPath(path string) bool {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	last := segments[len(segments)-1]
+	if last == "public" && len(segments) > 1 {
+		last = segments[len(segments)-2]
+	} else if segments[0] == "users" {
+		return false
+	}
+	return last == "events" || last == "received_events"
+}
+
+func (p *CacheProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isEventsPath(r.URL.Path) {
+		http.Error(w, "only the public events endpoints are proxied", http.StatusNotFound)
+		return
+	}
+	atomic.AddUint64(&p.requests, 1)
+
+	target := p.feed.client.BaseURL.ResolveReference(&url.URL{Path: strings.TrimPrefix(r.URL.Path, "/"), RawQuery: r.URL.RawQuery})
+	accept := r.Header.Get("Accept")
+	resp, err := p.do(r.Context(), target.String(), accept)
+	if err != nil {
+		http.Error(w, "upstream request failed", http.StatusBadGateway)
+		return
+	}
+
+	for k, v := range resp.header {
+		w.Header()[k] = v
+	}
+
+	etag := resp.header.Get("ETag")
+	if resp.status == http.StatusOK && etag != "" && r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(resp.status)
+	if r.Method == http.MethodGet {
+		w.Write(resp.body)
+	}
+}
+
+// do returns the upstream response, shared with the concurrent requests of
+// the same URL and media type.
+func (p *CacheProxy) do(ctx context.Context, target, accept string) (*proxiedResponse, error) {
+	key := target + "\n" + accept
+
+	p.mu.Lock()
+	if call, ok := p.inflight[key]; ok {
+		p.mu.Unlock()
+		atomic.AddUint64(&p.collapsed, 1)
+
+		select {
+		case <-call.done:
+			return call.resp, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call := &proxyCall{done: make(chan struct{})}
+	p.inflight[key] = call
+	p.mu.Unlock()
+
+	// Not bound to the first client, the others wait for it too.
+	call.resp, call.err = p.fetch(target, accept)
+
+	p.mu.Lock()
+	delete(p.inflight, key)
+	p.mu.Unlock()
+	close(call.done)
+
+	return call.resp, call.err
+}
+
+func (p *CacheProxy) fetch(target, accept string) (*proxiedResponse, error) {
+	req, err := http.NewRequestWithContext(WithSubsystem(p.feed.ctx, SubsystemProxy), http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.feed.ReportError(OpPoll, "", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		p.feed.ReportError(OpPoll, "", err)
+		return nil, err
+	}
+
+	header := resp.Header.Clone()
+	// The clients tell their own cache hits apart with it, see
+	// isCachedResponse.
+	header.Del(httpcache.XFromCache)
+	header.Del("Content-Length")
+
+	return &proxiedResponse{status: resp.StatusCode, header: header, body: body}, nil
+}
@@ -0,0 +1,117 @@
+package lib_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestCacheProxy(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		<-release
+		w.Header().Set("Cache-Control", "private, max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":"1","type":"PushEvent"}]`))
+	}))
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feed, _, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := lib.NewCacheProxy(feed)
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	get := func(path, etag string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Concurrent polls are collapsed into one upstream request.
+	const siblings = 5
+	var wg sync.WaitGroup
+	bodies := make([]string, siblings)
+	for i := 0; i < siblings; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp := get("/repos/o/r/events?page=1", "")
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			bodies[i] = string(body)
+		}(i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for proxy.Stats().Collapsed != siblings-1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats %+v, expected %d collapsed requests", proxy.Stats(), siblings-1)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	for _, body := range bodies {
+		if !strings.Contains(body, `"id":"1"`) {
+			t.Errorf("proxied body %q", body)
+		}
+	}
+
+	// Then served from the cache, without the cache marker which the
+	// clients reserve to their own hits.
+	resp := get("/repos/o/r/events?page=1", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-From-Cache") != "" {
+		t.Errorf("cached response %d with headers %v", resp.StatusCode, resp.Header)
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Errorf("%d upstream requests, expected 1", n)
+	}
+
+	resp = get("/repos/o/r/events?page=1", `"v1"`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidation answered %d", resp.StatusCode)
+	}
+
+	for _, path := range []string{"/repos/o/r", "/users/u/events", "/users/u/received_events", "/users/u/events/orgs/o"} {
+		resp = get(path, "")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("proxied %s: %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, err = http.Post(server.URL+"/events", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST answered %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const defaultCatchUpInterval = 5 * time.Second
+
+// catchUp is how the sources resuming from an old checkpoint catch up, see
+// Config.CatchUpAfter.
+type catchUp struct {
+	interval time.Duration
+	// Template of the replays of the gaps, nil unless
+	// Config.CatchUpFromArchive.
+	archive *ghArchiveReplay
+}
+
+func newCatchUp(conf *Config) catchUp {
+	c := catchUp{interval: conf.CatchUpInterval}
+	if c.interval <= 0 {
+		c.interval = defaultCatchUpInterval
+	}
+	if conf.CatchUpFromArchive {
+		baseURL := conf.GHArchiveURL
+		if baseURL == "" {
+			baseURL = GHArchiveURL
+		}
+		c.archive = &ghArchiveReplay{
+			baseURL: strings.TrimSuffix(baseURL, "/"),
+			client: &http.Client{
+				Transport: NewMeteredTransport("gharchive", nil, conf.MaxBandwidth),
+				Timeout:   ghArchiveTimeout,
+			},
+			retry: newRetryPolicy(conf),
+		}
+	}
+	return c
+}
+
+func (f *EventFeed) startCatchUp(p *sourcePoller) {
+	atomic.AddInt32(&f.metrics.catchingUp, 1)
+	log.Printf("Catching up with %s since %s", p.source, p.checkpoint.Time.Format(time.RFC3339))
+}
+
+// endCatchUp settles a source back to the normal cadence.
+func (f *EventFeed) endCatchUp(p *sourcePoller, reason string) {
+	p.catchingUp = false
+	atomic.AddInt32(&f.metrics.catchingUp, -1)
+	log.Printf("Stopped catching up with %s: %s", p.source, reason)
+}
+
+// catchUpInterval returns the interval until the next poll of a source
+// catching up, the one GitHub hinted once it caught up or the rate limit
+// runs low. The first poll, resumed from the given checkpoint, replays the
+// gap it didn't reach from GH Archive if enabled.
+func (f *EventFeed) catchUpInterval(ctx context.Context, p *sourcePoller, resumed Checkpoint, events []*github.Event, interval time.Duration, gap *sync.WaitGroup) time.Duration {
+	if !p.gapChecked {
+		p.gapChecked = true
+		if !p.caughtUp && f.catchUp.archive != nil {
+			f.replayGap(ctx, p, resumed, events, gap)
+		}
+	}
+
+	if p.caughtUp {
+		f.endCatchUp(p, "reached the events published")
+		return interval
+	}
+	if limit := atomic.LoadInt64(&f.metrics.rateLimit); limit > 0 && atomic.LoadInt64(&f.metrics.rateRemaining) < limit/10 {
+		f.endCatchUp(p, "rate limit running low")
+		return interval
+	}
+	if interval > f.catchUp.interval {
+		return f.catchUp.interval
+	}
+	return interval
+}
+
+// replayGap replays from GH Archive the events of the source created since
+// the checkpoint and before the oldest of the first poll, in the background.
+func (f *EventFeed) replayGap(ctx context.Context, p *sourcePoller, resumed Checkpoint, events []*github.Event, gap *sync.WaitGroup) {
+	var oldest time.Time
+	for _, ev := range events {
+		if created := ev.GetCreatedAt(); !created.IsZero() && (oldest.IsZero() || created.Before(oldest)) {
+			oldest = created
+		}
+	}
+	// The events published before the checkpoint was saved are dropped by
+	// their ID, those created just before may have been listed later.
+	from := resumed.Time.Truncate(time.Hour)
+	if oldest.IsZero() || !from.Before(oldest) {
+		return
+	}
+
+	r := *f.catchUp.archive
+	r.from, r.to = from.UTC(), oldest.UTC()
+	source, after := p.source, resumed.EventID
+	r.keep = func(ev *github.Event) bool {
+		return source.match(ev) && (after == "" || eventIDAfter(ev.GetID(), after))
+	}
+
+	gap.Add(1)
+	go func() {
+		defer gap.Done()
+		log.Printf("Replaying the gap of %s from GH Archive [%s, %s)", source, r.from.Format(time.RFC3339), r.to.Format(time.RFC3339))
+		if err := f.replayArchive(ctx, &r); err != nil && !errors.Is(err, ctx.Err()) {
+			f.ReportError(OpPoll, "", fmt.Errorf("replaying the gap of %s: %w", source, err))
+		}
+	}()
+}
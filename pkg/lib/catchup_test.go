@@ -0,0 +1,137 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestFeedCatchesUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catchup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := lib.NewFileCheckpointStore(filepath.Join(dir, "checkpoint"))
+	if err := store.Save(lib.Checkpoint{EventID: "10", Time: now.Add(-150 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	dumps := map[string][]byte{
+		"/2020-06-01-9.json.gz": ghArchiveDump(t,
+			ghArchiveEvent("9", "2020-06-01T09:10:00Z"),
+			ghArchiveEvent("11", "2020-06-01T09:40:00Z"),
+		),
+		"/2020-06-01-10.json.gz": ghArchiveDump(t, ghArchiveEvent("12", "2020-06-01T10:20:00Z")),
+		"/2020-06-01-11.json.gz": ghArchiveDump(t,
+			ghArchiveEvent("13", "2020-06-01T11:00:00Z"),
+			ghArchiveEvent("22", "2020-06-01T11:59:50Z"),
+		),
+	}
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dump, ok := dumps[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(dump)
+	}))
+	defer archive.Close()
+
+	var polls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Poll-Interval", "60")
+		// More pages than polled, the first poll doesn't reach the
+		// checkpoint.
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s/events?page=2>; rel="next"`, r.Host))
+		if atomic.AddInt32(&polls, 1) == 1 {
+			fmt.Fprint(w, "["+ghArchiveEvent("22", "2020-06-01T11:59:50Z")+","+ghArchiveEvent("21", "2020-06-01T11:59:40Z")+"]")
+			return
+		}
+		fmt.Fprint(w, "["+ghArchiveEvent("23", "2020-06-01T12:00:04Z")+","+ghArchiveEvent("22", "2020-06-01T11:59:50Z")+"]")
+	}))
+	defer api.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(now)
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+		BaseURL:            api.URL,
+		Clock:              clock,
+		MaxPages:           1,
+		Checkpoints:        store,
+		CatchUpAfter:       time.Hour,
+		CatchUpInterval:    5 * time.Second,
+		CatchUpFromArchive: true,
+		GHArchiveURL:       archive.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+
+	seen := make(map[string]int)
+	for _, id := range strings.Split(nextBatchIDs(t, events), ",") {
+		seen[id]++
+	}
+
+	// Polled again at the catch-up interval rather than GitHub's.
+	waitForTimer(t, clock)
+	clock.Advance(5 * time.Second)
+	for len(seen) < 6 {
+		for _, id := range strings.Split(nextBatchIDs(t, events), ",") {
+			seen[id]++
+		}
+	}
+	for _, id := range []string{"11", "12", "13", "21", "22", "23"} {
+		if seen[id] != 1 {
+			t.Errorf("event %s published %d times, want once (seen %v)", id, seen[id], seen)
+		}
+	}
+
+	// Caught up, the feed settles back to GitHub's interval.
+	waitForTimer(t, clock)
+	var metrics bytes.Buffer
+	feed.WritePrometheus(&metrics)
+	if !strings.Contains(metrics.String(), "github_feed_sources_catching_up 0\n") {
+		t.Errorf("expected no source catching up:\n%s", metrics.String())
+	}
+	clock.Advance(5 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&polls); n != 2 {
+		t.Errorf("got %d polls, want 2", n)
+	}
+}
+
+func TestCatchUpConfig(t *testing.T) {
+	err := (&lib.Config{CatchUpFromArchive: true, CatchUpInterval: -time.Second}).Validate()
+	if err == nil || !strings.Contains(err.Error(), "CatchUpFromArchive: requires CatchUpAfter") ||
+		!strings.Contains(err.Error(), "CatchUpFromArchive: requires Checkpoints") || !strings.Contains(err.Error(), "CatchUpInterval: must not be negative") {
+		t.Errorf("expected the catch-up problems reported, got %v", err)
+	}
+}
+
+func nextBatchIDs(t *testing.T, events <-chan []*github.Event) string {
+	batch := nextBatch(t, events)
+	ids := make([]string, len(batch))
+	for i, ev := range batch {
+		ids[i] = ev.GetID()
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,182 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultChaosMinPollInterval = time.Second
+	defaultChaosMaxPollInterval = 2 * time.Minute
+)
+
+// ChaosConfig perturbs the responses of the events API the way GitHub does
+// under load, to verify that the feed honors its poll interval hints (see
+// Config.Chaos). It isn't meant for production: the events of the
+// responses turned into 304 Not Modified are delayed until the next poll.
+type ChaosConfig struct {
+	// Fraction of the responses whose X-Poll-Interval is replaced by a
+	// random one between MinPollInterval (1s if zero) and MaxPollInterval
+	// (2m if zero).
+	PollIntervalRate float64
+	MinPollInterval  time.Duration
+	MaxPollInterval  time.Duration
+	// Fraction of the conditional requests answered 304 Not Modified
+	// without reaching the API.
+	NotModifiedRate float64
+	// Seed of the perturbations, the time if zero.
+	Seed int64
+}
+
+func (c ChaosConfig) enabled() bool {
+	return c.PollIntervalRate > 0 || c.NotModifiedRate > 0
+}
+
+func (c ChaosConfig) validate(errs *ConfigErrors) {
+	for _, r := range []struct {
+		field string
+		value float64
+	}{
+		{"Chaos.PollIntervalRate", c.PollIntervalRate},
+		{"Chaos.NotModifiedRate", c.NotModifiedRate},
+	} {
+		if r.value < 0 || r.value > 1 {
+			errs.Addf(r.field, "expected a fraction between 0 and 1")
+		}
+	}
+	if c.MinPollInterval < 0 || c.MaxPollInterval < 0 {
+		errs.Addf("Chaos.MinPollInterval", "must not be negative")
+	} else if c.MaxPollInterval > 0 && c.MinPollInterval > c.MaxPollInterval {
+		errs.Addf("Chaos.MinPollInterval", "exceeds Chaos.MaxPollInterval (%v)", c.MaxPollInterval)
+	}
+}
+
+// pollHint is the last poll interval advertised for the events of a source.
+type pollHint struct {
+	interval time.Duration
+	at       time.Time
+}
+
+// chaosTransport perturbs the responses listing the events of the feed's
+// sources, and reports the polls earlier than the last interval advertised
+// for their source. The failed responses reset the source's hint, its polls
+// are then paced by the retries.
+type chaosTransport struct {
+	base     http.RoundTripper
+	feed     *EventFeed
+	conf     ChaosConfig
+	min, max time.Duration
+
+	mu    sync.Mutex
+	rand  *rand.Rand
+	hints map[string]pollHint
+}
+
+func newChaosTransport(feed *EventFeed, base http.RoundTripper, conf ChaosConfig) *chaosTransport {
+	seed := conf.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t := &chaosTransport{
+		base:  base,
+		feed:  feed,
+		conf:  conf,
+		min:   conf.MinPollInterval,
+		max:   conf.MaxPollInterval,
+		rand:  rand.New(rand.NewSource(seed)),
+		hints: make(map[string]pollHint),
+	}
+	if t.min <= 0 {
+		t.min = defaultChaosMinPollInterval
+	}
+	if t.max <= 0 {
+		t.max = defaultChaosMaxPollInterval
+	}
+	if t.max < t.min {
+		t.max = t.min
+	}
+	return t
+}
+
+// source returns the events path of the feed's source the request lists,
+// empty for the other requests (e.g. enrichers').
+func (t *chaosTransport) source(req *http.Request) string {
+	if req.Method != http.MethodGet {
+		return ""
+	}
+	for _, p := range t.feed.pollers() {
+		if strings.HasSuffix(req.URL.Path, "/"+p.path) {
+			return p.path
+		}
+	}
+	return ""
+}
+
+func (t *chaosTransport) chance(rate float64) bool {
+	return rate > 0 && t.rand.Float64() < rate
+}
+
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	source := t.source(req)
+	if source == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	now := t.feed.clock.Now()
+	t.mu.Lock()
+	if page := req.URL.Query().Get("page"); page == "" || page == "1" {
+		if hint, ok := t.hints[source]; ok && now.Sub(hint.at) < hint.interval {
+			t.mu.Unlock()
+			t.feed.ReportError(OpPoll, "", fmt.Errorf("chaos: polled %s %v after the last response, %v advertised",
+				source, now.Sub(hint.at), hint.interval))
+			t.mu.Lock()
+		}
+	}
+	notModified := req.Header.Get("If-None-Match") != "" && t.chance(t.conf.NotModifiedRate)
+	t.mu.Unlock()
+
+	var rep *http.Response
+	if notModified {
+		rep = &http.Response{
+			Status:     "304 Not Modified",
+			StatusCode: http.StatusNotModified,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Etag": {req.Header.Get("If-None-Match")}},
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}
+	} else {
+		var err error
+		if rep, err = t.base.RoundTrip(req); err != nil {
+			t.forget(source)
+			return nil, err
+		}
+	}
+	if rep.StatusCode != http.StatusOK && rep.StatusCode != http.StatusNotModified {
+		t.forget(source)
+		return rep, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if notModified || t.chance(t.conf.PollIntervalRate) {
+		seconds := int64(t.min/time.Second) + t.rand.Int63n(int64((t.max-t.min)/time.Second)+1)
+		rep.Header.Set(xPollIntervalHeader, strconv.FormatInt(seconds, 10))
+	}
+	t.hints[source] = pollHint{interval: pollIntervalFromResponse(rep), at: now}
+	return rep, nil
+}
+
+func (t *chaosTransport) forget(source string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.hints, source)
+}
@@ -0,0 +1,134 @@
+package lib_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// The interval advertised by each response is honored until the next poll,
+// and invalid ones fall back to the default minute.
+func TestFeedHonorsPollIntervalChanges(t *testing.T) {
+	hints := []string{"60", "5", "30", "-3", "1"}
+	waits := []time.Duration{time.Minute, 5 * time.Second, 30 * time.Second, time.Minute, time.Second}
+
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&polls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Poll-Interval", hints[int(n-1)%len(hints)])
+		fmt.Fprintf(w, `[{"id":"%d","type":"PushEvent"}]`, n)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Date(2020, 8, 20, 0, 0, 0, 0, time.UTC))
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: srv.URL, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+
+	for i, wait := range waits {
+		nextBatch(t, events)
+		waitForTimer(t, clock)
+		clock.Advance(wait - time.Millisecond)
+		if clock.Waiters() == 0 {
+			t.Fatalf("poll %d: polled again before the %v advertised", i+1, wait)
+		}
+		clock.Advance(time.Millisecond)
+	}
+	nextBatch(t, events)
+	if n := atomic.LoadInt32(&polls); n != int32(len(waits)+1) {
+		t.Errorf("got %d polls, want %d", n, len(waits)+1)
+	}
+}
+
+// With intervals varying at random and revalidations answered 304, no poll
+// comes earlier than advertised, even when the second page is served by the
+// cache with the interval of an older poll.
+func TestChaosPollIntervals(t *testing.T) {
+	var polls, conditional int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		if page == "1" {
+			atomic.AddInt32(&polls, 1)
+		}
+		if r.Header.Get("If-None-Match") != "" {
+			atomic.AddInt32(&conditional, 1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Poll-Interval", "3")
+		if page == "1" {
+			w.Header().Set("Cache-Control", "private, max-age=0")
+		} else {
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-%d"`, page, time.Now().UnixNano()))
+		if page == "1" {
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/events?page=2>; rel="next"`, r.Host))
+		}
+		fmt.Fprintf(w, `[{"id":"%d","type":"PushEvent"}]`, time.Now().UnixNano())
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Date(2020, 8, 20, 0, 0, 0, 0, time.UTC))
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+		BaseURL:  srv.URL,
+		Clock:    clock,
+		MaxPages: 2,
+		Chaos: lib.ChaosConfig{
+			PollIntervalRate: 0.5,
+			MinPollInterval:  time.Second,
+			MaxPollInterval:  10 * time.Second,
+			NotModifiedRate:  0.5,
+			Seed:             1,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+	go func() {
+		for range events {
+		}
+	}()
+
+	// A second at a time, so that an early poll isn't masked.
+	for i := 0; i < 600; i++ {
+		waitForTimer(t, clock)
+		clock.Advance(time.Second)
+	}
+	waitForTimer(t, clock)
+
+	for len(feed.Errors()) > 0 {
+		if err := <-feed.Errors(); strings.Contains(err.Error(), "chaos") {
+			t.Error(err)
+		}
+	}
+	if n := atomic.LoadInt32(&polls); n < 30 {
+		t.Errorf("got %d polls in 10 minutes, expected at least 30", n)
+	}
+	if atomic.LoadInt32(&conditional) == 0 {
+		t.Error("expected revalidations of the cached pages")
+	}
+}
+
+func TestChaosConfig(t *testing.T) {
+	err := (&lib.Config{Chaos: lib.ChaosConfig{PollIntervalRate: 2, MinPollInterval: time.Minute, MaxPollInterval: time.Second}}).Validate()
+	if err == nil || !strings.Contains(err.Error(), "Chaos.PollIntervalRate") || !strings.Contains(err.Error(), "exceeds Chaos.MaxPollInterval") {
+		t.Errorf("expected the chaos problems reported, got %v", err)
+	}
+}
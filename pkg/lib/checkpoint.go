@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+const checkpointStateVersion = 1
+
+// CheckpointFile is the name of the checkpoint in Config.StateDir.
+const CheckpointFile = "checkpoint"
+
+// Checkpoint is the position of a polling feed in the events API: the newest
+// event published, and the ETag of the first page it was polled from. The
+// checkpoint of a feed polling several sources holds theirs by source, e.g.
+// org:kubernetes.
+type Checkpoint struct {
+	EventID string                `json:"event_id,omitempty"`
+	ETag    string                `json:"etag,omitempty"`
+	Time    time.Time             `json:"time"`
+	Sources map[string]Checkpoint `json:"sources,omitempty"`
+}
+
+// CheckpointStore persists the checkpoint of a feed, see Config.Checkpoints.
+// The file store is the default, others (e.g. a database shared by
+// replicas) implement the interface.
+type CheckpointStore interface {
+	// Load returns the last saved checkpoint, the zero Checkpoint if none
+	// was saved.
+	Load() (Checkpoint, error)
+	Save(Checkpoint) error
+}
+
+// FileCheckpointStore saves the checkpoint in a state file.
+type FileCheckpointStore struct {
+	path string
+}
+
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+func (s *FileCheckpointStore) Load() (Checkpoint, error) {
+	var cp Checkpoint
+
+	payload, err := ReadMigratedStateFile(s.path, checkpointStateVersion, nil)
+	if os.IsNotExist(err) {
+		return cp, nil
+	} else if err != nil {
+		return cp, err
+	}
+
+	err = json.Unmarshal(payload, &cp)
+	return cp, err
+}
+
+func (s *FileCheckpointStore) Save(cp Checkpoint) error {
+	payload, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	return WriteStateFile(s.path, checkpointStateVersion, payload)
+}
+
+// eventIDAfter tells whether the event ID is newer than another, the IDs of
+// the events API are increasing integers.
+func eventIDAfter(id, than string) bool {
+	if len(id) != len(than) {
+		return len(id) > len(than)
+	}
+	return id > than
+}
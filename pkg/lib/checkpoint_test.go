@@ -0,0 +1,123 @@
+package lib_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// etagGitHub serves the mock with the number of events published as ETag,
+// answering conditional requests.
+type etagGitHub struct {
+	*mockGitHub
+	notModified int32
+}
+
+func (m *etagGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := httptest.NewRecorder()
+	m.mockGitHub.ServeHTTP(rec, r)
+
+	etag := fmt.Sprintf(`"%d"`, len(m.published()))
+	if r.Header.Get("If-None-Match") == etag {
+		atomic.AddInt32(&m.notModified, 1)
+		w.Header().Set("X-Poll-Interval", "0")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
+
+func nextBatch(t *testing.T, events <-chan []*github.Event) []*github.Event {
+	select {
+	case batch := <-events:
+		return batch
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for events")
+		return nil
+	}
+}
+
+func TestFeedResumesFromCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mock := &etagGitHub{mockGitHub: &mockGitHub{}}
+	mock.publish()
+	mock.publish()
+	mock.freeze()
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	store := lib.NewFileCheckpointStore(filepath.Join(dir, lib.CheckpointFile))
+	start := func(dedup *lib.Deduplicator, checkpoints lib.CheckpointStore) (<-chan []*github.Event, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		conf := &lib.Config{BaseURL: server.URL, QueueSize: 1, Deduplicator: dedup, Checkpoints: checkpoints, StateDir: dir}
+		feed, events, err := lib.NewEventFeed(ctx, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go feed.Serve()
+
+		return events, func() {
+			cancel()
+			for range events {
+			}
+		}
+	}
+
+	dedup, _ := lib.NewDeduplicator("", 0, nil)
+	events, stop := start(dedup, store)
+	if batch := nextBatch(t, events); len(batch) != 2*mockEventsPerPoll {
+		t.Fatalf("first poll published %d events, expected %d", len(batch), 2*mockEventsPerPoll)
+	}
+	// The following polls are cached or deduplicated.
+	if batch := nextBatch(t, events); len(batch) != 0 {
+		t.Errorf("published %d events again", len(batch))
+	}
+	stop()
+
+	cp, err := store.Load()
+	if err != nil || cp.EventID != "40" || cp.ETag != `"40"` {
+		t.Fatalf("checkpoint = %+v, %v", cp, err)
+	}
+
+	// Restarted, the first poll is conditional on the checkpoint, found in
+	// the state directory by default.
+	atomic.StoreInt32(&mock.notModified, 0)
+	events, stop = start(nil, nil)
+	defer stop()
+	batch := nextBatch(t, events)
+	if n := atomic.LoadInt32(&mock.notModified); len(batch) != 0 || n == 0 {
+		t.Fatalf("resumed poll published %d events, %d not modified responses", len(batch), n)
+	}
+
+	mock.mu.Lock()
+	mock.frozen = false
+	mock.mu.Unlock()
+
+	for len(batch) == 0 {
+		batch = nextBatch(t, events)
+	}
+	if len(batch) != mockEventsPerPoll || batch[0].GetID() != "60" || batch[len(batch)-1].GetID() != "41" {
+		t.Errorf("published %d events from %s to %s, expected 60 to 41", len(batch), batch[0].GetID(), batch[len(batch)-1].GetID())
+	}
+}
@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	CIOutcomeSuccess = "success"
+	CIOutcomeFailure = "failure"
+
+	// Pushes are correlated with the outcomes reported for this long.
+	ciPushMemory = 24 * time.Hour
+)
+
+// Sources of CIOutcomeRecord.
+const (
+	CISourceStatus        = "status"
+	CISourceCheckRun      = "check_run"
+	CISourceCommitComment = "commit_comment"
+)
+
+var (
+	ciFailureComment = regexp.MustCompile(`(?i)\b(fail(s|ed|ure|ing)?|error(s|ed)?|broken)\b`)
+	ciSuccessComment = regexp.MustCompile(`(?i)\b(pass(es|ed|ing)?|succe(ss|ssful|eded))\b`)
+)
+
+// CIOutcomeRecord is the CI outcome of a commit, correlated with the push of
+// the commit when it was seen.
+type CIOutcomeRecord struct {
+	SchemaVersion int    `json:"schema_version"`
+	EventID       string `json:"event_id"`
+	Repo          string `json:"repo"`
+	SHA           string `json:"sha"`
+	// One of status, check_run and commit_comment.
+	Source string `json:"source"`
+	// The status context, check name or commenting bot.
+	Context string `json:"context,omitempty"`
+	// success, failure, or the conclusion of a check run (e.g. cancelled).
+	Outcome    string    `json:"outcome"`
+	ReportedAt time.Time `json:"reported_at"`
+
+	// Set when the push was seen.
+	Ref         string     `json:"ref,omitempty"`
+	PushEventID string     `json:"push_event_id,omitempty"`
+	PushedAt    *time.Time `json:"pushed_at,omitempty"`
+	// Seconds from the push to the outcome.
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+type ciPush struct {
+	ref     string
+	eventID string
+	at      time.Time
+}
+
+type ciPayload struct {
+	// PushEvent
+	Ref  *string `json:"ref"`
+	Head *string `json:"head"`
+	// StatusEvent
+	SHA     *string `json:"sha"`
+	State   *string `json:"state"`
+	Context *string `json:"context"`
+	// CheckRunEvent
+	Action   *string `json:"action"`
+	CheckRun *struct {
+		HeadSHA     *string    `json:"head_sha"`
+		Name        *string    `json:"name"`
+		Conclusion  *string    `json:"conclusion"`
+		CompletedAt *time.Time `json:"completed_at"`
+	} `json:"check_run"`
+	// CommitCommentEvent
+	Comment *struct {
+		CommitID *string      `json:"commit_id"`
+		Body     *string      `json:"body"`
+		User     *github.User `json:"user"`
+	} `json:"comment"`
+}
+
+// CITracker correlates the CI outcomes of commits with their pushes, for a
+// set of repositories (all if empty). Outcomes come from StatusEvents and
+// CheckRunEvents, which only webhooks deliver (see NewWebhookFeed), and from
+// the CommitCommentEvents of bots in the events API, whose outcome is
+// guessed from the comment (passed, failed...). Comments telling neither are
+// skipped, as are pending statuses.
+type CITracker struct {
+	Bots BotDetector
+
+	repos  map[string]bool
+	pushes map[string]ciPush
+}
+
+// NewCITracker tracks the given repositories, LoginBotDetector classifies
+// the commenters unless Bots is set.
+func NewCITracker(repos []string) *CITracker {
+	t := &CITracker{Bots: LoginBotDetector{}, repos: make(map[string]bool, len(repos)), pushes: make(map[string]ciPush)}
+	for _, repo := range repos {
+		t.repos[strings.ToLower(repo)] = true
+	}
+
+	return t
+}
+
+func (t *CITracker) tracks(repo string) bool {
+	return len(t.repos) == 0 || t.repos[strings.ToLower(repo)]
+}
+
+func ciCommitKey(repo, sha string) string {
+	return strings.ToLower(repo) + "@" + sha
+}
+
+// Track returns the CI outcomes reported in the batch. Events with malformed
+// payloads are skipped.
+func (t *CITracker) Track(events []*github.Event) []*CIOutcomeRecord {
+	var records []*CIOutcomeRecord
+	var latest time.Time
+
+	for _, ev := range events {
+		repo := ev.GetRepo().GetName()
+		if repo == "" || !t.tracks(repo) || ev.RawPayload == nil {
+			continue
+		}
+
+		var payload ciPayload
+		if err := json.Unmarshal(*ev.RawPayload, &payload); err != nil {
+			continue
+		}
+
+		created := ev.GetCreatedAt()
+		if created.After(latest) {
+			latest = created
+		}
+
+		record := &CIOutcomeRecord{SchemaVersion: SchemaVersion, EventID: ev.GetID(), Repo: repo, ReportedAt: created.UTC()}
+		switch ev.GetType() {
+		case "PushEvent":
+			if payload.Head != nil {
+				t.pushes[ciCommitKey(repo, *payload.Head)] = ciPush{ref: stringOrEmpty(payload.Ref), eventID: ev.GetID(), at: created}
+			}
+			continue
+		case "StatusEvent":
+			switch stringOrEmpty(payload.State) {
+			case "success":
+				record.Outcome = CIOutcomeSuccess
+			case "failure", "error":
+				record.Outcome = CIOutcomeFailure
+			default:
+				continue
+			}
+			record.Source, record.SHA, record.Context = CISourceStatus, stringOrEmpty(payload.SHA), stringOrEmpty(payload.Context)
+		case "CheckRunEvent":
+			run := payload.CheckRun
+			if stringOrEmpty(payload.Action) != "completed" || run == nil || run.Conclusion == nil {
+				continue
+			}
+			if record.Outcome = *run.Conclusion; record.Outcome == "timed_out" {
+				record.Outcome = CIOutcomeFailure
+			}
+			if run.CompletedAt != nil {
+				record.ReportedAt = run.CompletedAt.UTC()
+			}
+			record.Source, record.SHA, record.Context = CISourceCheckRun, stringOrEmpty(run.HeadSHA), stringOrEmpty(run.Name)
+		case "CommitCommentEvent":
+			comment := payload.Comment
+			if comment == nil || !t.Bots.IsBot(&github.Event{Actor: comment.User}) {
+				continue
+			}
+			body := stringOrEmpty(comment.Body)
+			switch {
+			case ciFailureComment.MatchString(body):
+				record.Outcome = CIOutcomeFailure
+			case ciSuccessComment.MatchString(body):
+				record.Outcome = CIOutcomeSuccess
+			default:
+				continue
+			}
+			record.Source, record.SHA, record.Context = CISourceCommitComment, stringOrEmpty(comment.CommitID), comment.User.GetLogin()
+		default:
+			continue
+		}
+
+		if record.SHA == "" {
+			continue
+		}
+		if push, ok := t.pushes[ciCommitKey(repo, record.SHA)]; ok {
+			pushedAt := push.at.UTC()
+			record.Ref, record.PushEventID, record.PushedAt = push.ref, push.eventID, &pushedAt
+			record.DurationSeconds = record.ReportedAt.Sub(pushedAt).Seconds()
+		}
+		records = append(records, record)
+	}
+
+	t.forget(latest.Add(-ciPushMemory))
+	return records
+}
+
+// forget drops the pushes made before the given time.
+func (t *CITracker) forget(before time.Time) {
+	for key, push := range t.pushes {
+		if push.at.Before(before) {
+			delete(t.pushes, key)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestCITrackerCorrelatesPushes(t *testing.T) {
+	pushed := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	tracker := lib.NewCITracker(nil)
+
+	records := tracker.Track([]*github.Event{
+		testEvent("", "PushEvent", "octocat", "octocat/hello-world", pushed, `{"ref":"refs/heads/main","head":"abc123"}`),
+		testEvent("", "CommitCommentEvent", "octocat", "octocat/hello-world", pushed.Add(time.Minute), `{"comment":{"commit_id":"abc123","body":"Build failed?","user":{"login":"octocat"}}}`),
+		testEvent("", "CommitCommentEvent", "ci[bot]", "octocat/hello-world", pushed.Add(5*time.Minute), `{"comment":{"commit_id":"abc123","body":"Build failed: 2 tests","user":{"login":"ci[bot]"}}}`),
+		testEvent("", "StatusEvent", "octocat", "octocat/hello-world", pushed.Add(6*time.Minute), `{"sha":"abc123","state":"pending","context":"ci/build"}`),
+		testEvent("", "StatusEvent", "octocat", "octocat/hello-world", pushed.Add(7*time.Minute), `{"sha":"def456","state":"success","context":"ci/build"}`),
+	})
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2: %+v", len(records), records)
+	}
+
+	if r := records[0]; r.Source != lib.CISourceCommitComment || r.Outcome != lib.CIOutcomeFailure || r.Ref != "refs/heads/main" || r.DurationSeconds != 300 {
+		t.Errorf("unexpected correlated record %+v", r)
+	}
+	if r := records[1]; r.Source != lib.CISourceStatus || r.Outcome != lib.CIOutcomeSuccess || r.PushedAt != nil {
+		t.Errorf("unexpected uncorrelated record %+v", r)
+	}
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of the poller, rate limiters, statistics
+// windows and archive replays, so that tests and replays can run them in
+// virtual time (see SimulatedClock).
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SystemClock is the wall clock, used when no clock is configured.
+var SystemClock Clock = systemClock{}
+
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// SimulatedClock is a virtual clock which only moves when advanced, firing
+// the timers which expired on the way, e.g. to fast-forward hours of polling
+// in a test:
+//
+//	clock := lib.NewSimulatedClock(start)
+//	conf.Clock = clock
+//	...
+//	clock.Advance(time.Hour)
+type SimulatedClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []simulatedWaiter
+}
+
+type simulatedWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func NewSimulatedClock(start time.Time) *SimulatedClock {
+	return &SimulatedClock{now: start}
+}
+
+func (c *SimulatedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *SimulatedClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+
+	c.waiters = append(c.waiters, simulatedWaiter{deadline: c.now.Add(d), c: ch})
+	sort.SliceStable(c.waiters, func(i, j int) bool { return c.waiters[i].deadline.Before(c.waiters[j].deadline) })
+
+	return ch
+}
+
+// Advance moves the clock forward by d, firing the expired timers in order.
+// Each timer receives its own deadline as the current time.
+func (c *SimulatedClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for len(c.waiters) > 0 && !c.waiters[0].deadline.After(end) {
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+		c.now = w.deadline
+		w.c <- w.deadline
+	}
+	c.now = end
+}
+
+// Waiters returns the number of pending timers, e.g. for a test to wait
+// until the code under test blocked on the clock before advancing it.
+func (c *SimulatedClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
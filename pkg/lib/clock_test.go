@@ -0,0 +1,114 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestSimulatedClockFiresInOrder(t *testing.T) {
+	start := time.Date(2020, 8, 20, 14, 0, 0, 0, time.UTC)
+	clock := lib.NewSimulatedClock(start)
+
+	late, early := clock.After(2*time.Hour), clock.After(time.Hour)
+	never := clock.After(3 * time.Hour)
+
+	clock.Advance(150 * time.Minute)
+
+	if got := <-early; !got.Equal(start.Add(time.Hour)) {
+		t.Errorf("early timer fired at %v", got)
+	}
+	if got := <-late; !got.Equal(start.Add(2 * time.Hour)) {
+		t.Errorf("late timer fired at %v", got)
+	}
+	select {
+	case got := <-never:
+		t.Errorf("timer past the clock fired at %v", got)
+	default:
+	}
+
+	if got := clock.Now(); !got.Equal(start.Add(150 * time.Minute)) {
+		t.Errorf("clock at %v after advancing", got)
+	}
+	if n := clock.Waiters(); n != 1 {
+		t.Errorf("%d pending timers, expected 1", n)
+	}
+}
+
+// waitForTimer waits until the code under test blocks on the clock.
+func waitForTimer(t *testing.T, clock *lib.SimulatedClock) {
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Waiters() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the clock to be waited on")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// A day of polling, honoring GitHub's poll interval, runs in virtual time.
+func TestEventFeedPollsInVirtualTime(t *testing.T) {
+	var polls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&polls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Poll-Interval", "60")
+		w.Write([]byte(`[{"id":"1","type":"PushEvent"}]`))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Date(2020, 8, 20, 0, 0, 0, 0, time.UTC))
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: srv.URL, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+	go func() {
+		for range events {
+		}
+	}()
+
+	for i := 0; i < 24*60; i++ {
+		waitForTimer(t, clock)
+		clock.Advance(time.Minute)
+	}
+	waitForTimer(t, clock)
+
+	if n := atomic.LoadInt32(&polls); n != 24*60+1 {
+		t.Errorf("%d polls in a day, expected %d", n, 24*60+1)
+	}
+}
+
+func TestEventStatsWindowInVirtualTime(t *testing.T) {
+	clock := lib.NewSimulatedClock(time.Date(2020, 8, 20, 0, 0, 0, 0, time.UTC))
+	stats := lib.NewEventStats(nil)
+	stats.Clock = clock
+
+	stats.Observe([]*github.Event{{ID: github.String("1"), Type: github.String("PushEvent")}})
+
+	metrics := func() string {
+		var buf bytes.Buffer
+		stats.WritePrometheus(&buf)
+		return buf.String()
+	}
+
+	if m := metrics(); !strings.Contains(m, `events_per_second{type="PushEvent"}`) {
+		t.Errorf("observed event missing from the window:\n%s", m)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if m := metrics(); strings.Contains(m, `events_per_second{type="PushEvent"}`) {
+		t.Errorf("event still in the window after it elapsed:\n%s", m)
+	}
+}
@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// CloudEvents modes of the HTTP sink, see HTTPSink.CloudEvents.
+const (
+	// A request per event, its attributes in ce- headers and the record
+	// encoded by the sink's serializer as the body.
+	CloudEventsBinary = "binary"
+	// A request per event, a CloudEvent JSON envelope as the body.
+	CloudEventsStructured = "structured"
+	// A request per batch, a JSON array of envelopes.
+	CloudEventsBatch = "batch"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsContentType = "application/cloudevents+json"
+	cloudEventsBatchType   = "application/cloudevents-batch+json"
+	// Prefix of the types of the CloudEvents, followed by the GitHub event
+	// type, e.g. com.github.PushEvent.
+	cloudEventsTypePrefix = "com.github."
+)
+
+func validCloudEventsMode(mode string) error {
+	switch mode {
+	case "", CloudEventsBinary, CloudEventsStructured, CloudEventsBatch:
+		return nil
+	}
+	return fmt.Errorf("unknown cloudevents mode '%s', expected %s, %s or %s", mode,
+		CloudEventsBinary, CloudEventsStructured, CloudEventsBatch)
+}
+
+// CloudEvent is the CloudEvents 1.0 envelope of an event, in the JSON
+// format: the event's ID, its repository as source (https://github.com/
+// owner/name) and its type prefixed by com.github. as type. The data is the
+// record of the event, base64 encoded for the binary serializers.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// NewCloudEvent returns the envelope of an event, its record encoded by s.
+func NewCloudEvent(ev *github.Event, s Serializer) (*CloudEvent, error) {
+	return recordCloudEvent(NewRecord(ev), s)
+}
+
+func recordCloudEvent(r *Record, s Serializer) (*CloudEvent, error) {
+	s = serializerOrDefault(s)
+	data, err := s.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	ev := r.Event
+
+	ce := &CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              ev.GetID(),
+		Source:          cloudEventSource(ev),
+		Type:            cloudEventsTypePrefix + ev.GetType(),
+		DataContentType: s.ContentType(),
+	}
+	if created := ev.GetCreatedAt(); !created.IsZero() {
+		created = created.UTC()
+		ce.Time = &created
+	}
+	if s.Binary() {
+		ce.DataBase64 = data
+	} else {
+		ce.Data = data
+	}
+	return ce, nil
+}
+
+func cloudEventSource(ev *github.Event) string {
+	if repo := ev.GetRepo().GetName(); repo != "" {
+		return "https://github.com/" + repo
+	}
+	return "https://github.com"
+}
+
+// setCloudEventHeaders sets the attributes of an event's envelope as the ce-
+// headers of a binary mode request.
+func setCloudEventHeaders(h http.Header, ce *CloudEvent) {
+	h.Set("Ce-Specversion", ce.SpecVersion)
+	h.Set("Ce-Id", ce.ID)
+	h.Set("Ce-Source", ce.Source)
+	h.Set("Ce-Type", ce.Type)
+	if ce.Time != nil {
+		h.Set("Ce-Time", ce.Time.Format(time.RFC3339))
+	}
+	h.Set("Content-Type", ce.DataContentType)
+}
+
+func init() {
+	RegisterSerializer("cloudevents", cloudEventsSerializer{})
+}
+
+// cloudEventsSerializer encodes records as NDJSON CloudEvents envelopes, the
+// record as JSON data, for the consumers expecting CloudEvents from any
+// sink.
+type cloudEventsSerializer struct{}
+
+func (cloudEventsSerializer) ContentType() string { return cloudEventsContentType }
+func (cloudEventsSerializer) Extension() string   { return "ndjson" }
+func (cloudEventsSerializer) Binary() bool        { return false }
+
+func (cloudEventsSerializer) Marshal(r *Record) ([]byte, error) {
+	ce, err := recordCloudEvent(r, nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ce)
+}
+
+func (s cloudEventsSerializer) Encode(w io.Writer, r *Record) error {
+	b, err := s.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// encodeCloudEvents returns the envelopes of a batch as a JSON array, the
+// body of a batch mode request.
+func encodeCloudEvents(events []*github.Event, s Serializer) ([]byte, error) {
+	envelopes := make([]*CloudEvent, len(events))
+	for i, ev := range events {
+		ce, err := NewCloudEvent(ev, s)
+		if err != nil {
+			return nil, err
+		}
+		envelopes[i] = ce
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(envelopes); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Co-authored-by trailers, as GitHub credits them: a line of the commit
+// message, e.g. "Co-authored-by: Mona <mona@example.com>".
+var coAuthorTrailer = regexp.MustCompile(`(?im)^[ \t]*co-authored-by:[ \t]*([^<\r\n]*?)[ \t]*<([^>\r\n]+)>[ \t]*\r?$`)
+
+// CommitAuthor is an author of a commit named by a trailer of its message.
+type CommitAuthor struct {
+	Name  string
+	Email string
+}
+
+// CoAuthors returns the co-authors of a commit from the Co-authored-by
+// trailers of its message, in order, without duplicate emails.
+func CoAuthors(message string) []CommitAuthor {
+	var authors []CommitAuthor
+	seen := make(map[string]bool)
+	for _, m := range coAuthorTrailer.FindAllStringSubmatch(message, -1) {
+		email := strings.TrimSpace(m[2])
+		if email == "" || seen[strings.ToLower(email)] {
+			continue
+		}
+		seen[strings.ToLower(email)] = true
+		authors = append(authors, CommitAuthor{Name: m[1], Email: email})
+	}
+	return authors
+}
+
+// rewriteCoAuthors returns a commit message with the name and email of its
+// Co-authored-by trailers replaced by fn.
+func rewriteCoAuthors(message string, fn func(s string) string) string {
+	return coAuthorTrailer.ReplaceAllStringFunc(message, func(line string) string {
+		m := coAuthorTrailer.FindStringSubmatchIndex(line)
+		name, email := line[m[2]:m[3]], line[m[4]:m[5]]
+		if name != "" {
+			name = fn(name)
+		}
+		return line[:m[2]] + name + line[m[3]:m[4]] + fn(email) + line[m[5]:]
+	})
+}
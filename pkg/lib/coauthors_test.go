@@ -0,0 +1,48 @@
+package lib_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestCoAuthors(t *testing.T) {
+	message := "Pair on the parser\n\nSigned-off-by: Alice <alice@example.com>\n" +
+		"Co-authored-by: Bob Smith <bob@example.com>\r\n" +
+		"co-authored-by:<carol@example.com>\n" +
+		"Co-Authored-By: Bob <BOB@example.com>\n" +
+		"  Co-authored-by: no email\n"
+
+	want := []lib.CommitAuthor{{Name: "Bob Smith", Email: "bob@example.com"}, {Email: "carol@example.com"}}
+	if got := lib.CoAuthors(message); !reflect.DeepEqual(got, want) {
+		t.Errorf("got co-authors %+v, want %+v", got, want)
+	}
+	if got := lib.CoAuthors("Fix typo"); len(got) != 0 {
+		t.Errorf("got co-authors %+v without trailers", got)
+	}
+}
+
+func TestAnonymizeCoAuthors(t *testing.T) {
+	chain, err := lib.ParseTransformChain([]byte(`
+transforms:
+  - {type: anonymize, salt: not-so-secret-salt}
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := chain.Apply([]*github.Event{testEvent("1", "PushEvent", "alice", "o/r", time.Time{}, `{"commits":[`+
+		`{"message":"Fix\n\nCo-authored-by: Bob Smith <bob@example.com>","author":{"email":"alice@example.com","name":"Alice"}}]}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := string(*out[0].RawPayload)
+	if strings.Contains(payload, "bob") || strings.Contains(payload, "Bob") || !strings.Contains(payload, `Co-authored-by: anon-`) {
+		t.Errorf("got payload %s", payload)
+	}
+}
@@ -0,0 +1,275 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+func init() {
+	RegisterSerializer("msgpack", msgpackSerializer{})
+	RegisterSerializer("cbor", cborSerializer{})
+}
+
+// genericRecord decodes the JSON of a record into generic values, so that the
+// compact encodings carry the same fields as the JSON one. Numbers are kept
+// as json.Number to encode integers as such.
+func genericRecord(r *Record) (interface{}, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// msgpackSerializer encodes records as MessagePack maps, see
+// https://github.com/msgpack/msgpack/blob/master/spec.md. Records are
+// self-delimiting, a stream is their concatenation.
+type msgpackSerializer struct{}
+
+func (msgpackSerializer) ContentType() string { return "application/vnd.msgpack" }
+func (msgpackSerializer) Extension() string   { return "msgpack" }
+func (msgpackSerializer) Binary() bool        { return true }
+
+func (msgpackSerializer) Marshal(r *Record) ([]byte, error) {
+	v, err := genericRecord(r)
+	if err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, v)
+}
+
+func (s msgpackSerializer) Encode(w io.Writer, r *Record) error {
+	b, err := s.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// appendMsgpackHeader appends the header of a string, array or map of n
+// elements, fix is the tag of the fixed size form, holding up to max elements.
+func appendMsgpackHeader(b []byte, n int, fix byte, max int, tag8, tag16, tag32 byte) []byte {
+	switch {
+	case n <= max:
+		return append(b, fix|byte(n))
+	case tag8 != 0 && n <= math.MaxUint8:
+		return append(b, tag8, byte(n))
+	case n <= math.MaxUint16:
+		return append(append(b, tag16), byte(n>>8), byte(n))
+	default:
+		b = append(b, tag32)
+		return append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+}
+
+func appendMsgpack(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			b = append(b, 0xcf)
+			return appendUint64(b, u), nil
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		return appendUint64(b, math.Float64bits(f)), nil
+	case string:
+		b = appendMsgpackHeader(b, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		return append(b, v...), nil
+	case []interface{}:
+		b = appendMsgpackHeader(b, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			var err error
+			if b, err = appendMsgpack(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendMsgpackHeader(b, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range sortedKeys(v) {
+			var err error
+			if b, err = appendMsgpack(b, k); err != nil {
+				return nil, err
+			}
+			if b, err = appendMsgpack(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("msgpack: unsupported type %T", v)
+	}
+}
+
+// appendMsgpackInt appends i in its most compact form.
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 127:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(b, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return append(b, 0xcd, byte(i>>8), byte(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return append(b, 0xce, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+	case i >= 0:
+		return appendUint64(append(b, 0xcf), uint64(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return append(b, 0xd1, byte(i>>8), byte(i))
+	case i >= math.MinInt32:
+		return append(b, 0xd2, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+	default:
+		return appendUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+func appendUint64(b []byte, u uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], u)
+	return append(b, buf[:]...)
+}
+
+// cborSerializer encodes records as CBOR maps (RFC 8949), with sorted keys
+// and integers in their shortest form. Records are self-delimiting, a stream
+// is a CBOR sequence (RFC 8742).
+type cborSerializer struct{}
+
+func (cborSerializer) ContentType() string { return "application/cbor" }
+func (cborSerializer) Extension() string   { return "cbor" }
+func (cborSerializer) Binary() bool        { return true }
+
+func (cborSerializer) Marshal(r *Record) ([]byte, error) {
+	v, err := genericRecord(r)
+	if err != nil {
+		return nil, err
+	}
+	return appendCBOR(nil, v)
+}
+
+func (s cborSerializer) Encode(w io.Writer, r *Record) error {
+	b, err := s.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+const (
+	cborUint   = 0 << 5
+	cborNegint = 1 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+)
+
+// appendCBORHead appends the head of a data item of the major type, with its
+// argument in the shortest form.
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(b, major|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(b, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	default:
+		return appendUint64(append(b, major|27), n)
+	}
+}
+
+func appendCBOR(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if i < 0 {
+				return appendCBORHead(b, cborNegint, uint64(-1-i)), nil
+			}
+			return appendCBORHead(b, cborUint, uint64(i)), nil
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return appendCBORHead(b, cborUint, u), nil
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		return appendUint64(append(b, 0xfb), math.Float64bits(f)), nil
+	case string:
+		b = appendCBORHead(b, cborText, uint64(len(v)))
+		return append(b, v...), nil
+	case []interface{}:
+		b = appendCBORHead(b, cborArray, uint64(len(v)))
+		for _, e := range v {
+			var err error
+			if b, err = appendCBOR(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendCBORHead(b, cborMap, uint64(len(v)))
+		for _, k := range sortedKeys(v) {
+			var err error
+			if b, err = appendCBOR(b, k); err != nil {
+				return nil, err
+			}
+			if b, err = appendCBOR(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("cbor: unsupported type %T", v)
+	}
+}
@@ -0,0 +1,235 @@
+package lib_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// compactTestRecord exercises every encoded type and the size classes of
+// strings, arrays and integers.
+func compactTestRecord() *lib.Record {
+	items := make([]interface{}, 20)
+	for i := range items {
+		items[i] = i * 1000
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"small":    -5,
+		"negative": -40000,
+		"large":    int64(1) << 40,
+		"max":      uint64(math.MaxUint64),
+		"float":    1.5,
+		"short":    "bug",
+		"long":     strings.Repeat("x", 300),
+		"longer":   strings.Repeat("y", 70000),
+		"items":    items,
+		"none":     nil,
+		"flags":    []interface{}{true, false},
+	})
+
+	raw := json.RawMessage(payload)
+	return lib.NewRecord(&github.Event{
+		ID:         github.String("13224873745"),
+		Type:       github.String("IssuesEvent"),
+		Repo:       &github.Repository{ID: github.Int64(1296269), Name: github.String("octocat/Hello-World")},
+		RawPayload: &raw,
+	})
+}
+
+// normalize decodes the JSON of v the way the decoders below represent
+// values, numbers as strings in their JSON form.
+func normalize(t *testing.T, v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		t.Fatal(err)
+	}
+	return numbersAsStrings(generic)
+}
+
+func numbersAsStrings(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if f, err := v.Float64(); err == nil && strings.Contains(string(v), ".") {
+			return fmt.Sprint(f)
+		}
+		return string(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = numbersAsStrings(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = numbersAsStrings(v[k])
+		}
+	}
+	return v
+}
+
+type decoder struct {
+	b []byte
+}
+
+func (d *decoder) next(n int) []byte {
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *decoder) uint(n int) uint64 {
+	var buf [8]byte
+	copy(buf[8-n:], d.next(n))
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+func (d *decoder) msgpack() interface{} {
+	tag := d.next(1)[0]
+	switch {
+	case tag <= 0x7f:
+		return fmt.Sprint(tag)
+	case tag >= 0xe0:
+		return fmt.Sprint(int8(tag))
+	case tag&0xe0 == 0xa0:
+		return string(d.next(int(tag & 0x1f)))
+	case tag&0xf0 == 0x90:
+		return d.msgpackArray(int(tag & 0x0f))
+	case tag&0xf0 == 0x80:
+		return d.msgpackMap(int(tag & 0x0f))
+	}
+
+	switch tag {
+	case 0xc0:
+		return nil
+	case 0xc2, 0xc3:
+		return tag == 0xc3
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return fmt.Sprint(d.uint(1 << (tag - 0xcc)))
+	case 0xd0:
+		return fmt.Sprint(int8(d.uint(1)))
+	case 0xd1:
+		return fmt.Sprint(int16(d.uint(2)))
+	case 0xd2:
+		return fmt.Sprint(int32(d.uint(4)))
+	case 0xd3:
+		return fmt.Sprint(int64(d.uint(8)))
+	case 0xcb:
+		return fmt.Sprint(math.Float64frombits(d.uint(8)))
+	case 0xd9, 0xda, 0xdb:
+		return string(d.next(int(d.uint(1 << (tag - 0xd9)))))
+	case 0xdc, 0xdd:
+		return d.msgpackArray(int(d.uint(2 << (tag - 0xdc))))
+	case 0xde, 0xdf:
+		return d.msgpackMap(int(d.uint(2 << (tag - 0xde))))
+	}
+	panic(fmt.Sprintf("unexpected msgpack tag %#x", tag))
+}
+
+func (d *decoder) msgpackArray(n int) []interface{} {
+	a := make([]interface{}, n)
+	for i := range a {
+		a[i] = d.msgpack()
+	}
+	return a
+}
+
+func (d *decoder) msgpackMap(n int) map[string]interface{} {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k := d.msgpack().(string)
+		m[k] = d.msgpack()
+	}
+	return m
+}
+
+func (d *decoder) cbor() interface{} {
+	head := d.next(1)[0]
+	major, info := head>>5, head&0x1f
+
+	if major == 7 {
+		switch head {
+		case 0xf4, 0xf5:
+			return head == 0xf5
+		case 0xf6:
+			return nil
+		case 0xfb:
+			return fmt.Sprint(math.Float64frombits(d.uint(8)))
+		}
+		panic(fmt.Sprintf("unexpected cbor simple value %#x", head))
+	}
+
+	n := uint64(info)
+	if info >= 24 {
+		n = d.uint(1 << (info - 24))
+	}
+
+	switch major {
+	case 0:
+		return fmt.Sprint(n)
+	case 1:
+		return fmt.Sprint(-1 - int64(n))
+	case 3:
+		return string(d.next(int(n)))
+	case 4:
+		a := make([]interface{}, n)
+		for i := range a {
+			a[i] = d.cbor()
+		}
+		return a
+	case 5:
+		m := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			k := d.cbor().(string)
+			m[k] = d.cbor()
+		}
+		return m
+	}
+	panic(fmt.Sprintf("unexpected cbor major type %d", major))
+}
+
+func TestCompactSerializersRoundTrip(t *testing.T) {
+	record := compactTestRecord()
+	want := normalize(t, record)
+
+	for name, decode := range map[string]func(*decoder) interface{}{
+		"msgpack": (*decoder).msgpack,
+		"cbor":    (*decoder).cbor,
+	} {
+		s, err := lib.LookupSerializer(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stream bytes.Buffer
+		for i := 0; i < 2; i++ {
+			if err := s.Encode(&stream, record); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		// Records are self-delimiting, the stream holds both.
+		d := &decoder{b: stream.Bytes()}
+		for i := 0; i < 2; i++ {
+			if got := decode(d); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: record %d decoded as %v, want %v", name, i, got, want)
+			}
+		}
+		if len(d.b) != 0 {
+			t.Errorf("%s: %d trailing bytes", name, len(d.b))
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Package compat preserves the original API of package lib, so that existing
+// integrations keep compiling as the library evolves: its identifiers keep
+// their original signatures and forward to package lib.
+//
+// Deprecated: use package lib, whose event channels convert to lib.Stream for
+// composing pipelines. The package will be removed in a future major
+// version.
+package compat
+
+import (
+	"context"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// EventFeed polls the events API.
+//
+// Deprecated: use lib.EventFeed.
+type EventFeed = lib.EventFeed
+
+// Config configures an EventFeed.
+//
+// Deprecated: use lib.Config.
+type Config = lib.Config
+
+// NewEventFeed returns a feed and the channel on which it publishes batches
+// of events once served.
+//
+// Deprecated: use lib.NewEventFeed, and lib.Stream to compose the channel.
+func NewEventFeed(ctx context.Context, conf *Config) (*EventFeed, <-chan []*github.Event, error) {
+	return lib.NewEventFeed(ctx, conf)
+}
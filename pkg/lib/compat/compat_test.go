@@ -0,0 +1,22 @@
+package compat_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib/compat"
+	"github.com/google/go-github/v32/github"
+)
+
+// The signature integrations were written against, which must not change.
+var _ func(context.Context, *compat.Config) (*compat.EventFeed, <-chan []*github.Event, error) = compat.NewEventFeed
+
+func TestNewEventFeed(t *testing.T) {
+	feed, events, err := compat.NewEventFeed(context.Background(), &compat.Config{AuthToken: "token", QueueSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Client() == nil || cap(events) != 3 {
+		t.Errorf("unexpected feed %v with a queue of %d", feed, cap(events))
+	}
+}
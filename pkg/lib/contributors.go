@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	contributorStateVersion    = 1
+	defaultContributorCapacity = 1000000
+	defaultContributorWarmup   = 24 * time.Hour
+)
+
+// Kinds of NewContributorRecord.
+const (
+	ContributionPullRequest = "pull_request"
+	ContributionPush        = "push"
+)
+
+// NewContributorRecord reports the first contribution of an actor to a
+// repository seen by the ContributorTracker.
+type NewContributorRecord struct {
+	SchemaVersion int    `json:"schema_version"`
+	EventID       string `json:"event_id"`
+	Repo          string `json:"repo"`
+	Actor         string `json:"actor"`
+	// pull_request or push.
+	Kind      string    `json:"kind"`
+	URL       string    `json:"url,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type contributorState struct {
+	Since time.Time `json:"since"`
+	// Last contribution by repository and actor, see contributorKey.
+	Contributors map[string]time.Time `json:"contributors"`
+}
+
+// ContributorTracker detects the first contributions (an opened pull request
+// or a push) of actors to a set of repositories (all if empty), bots
+// excluded. The contributors are remembered in a state file, up to a
+// capacity beyond which the least recently active are forgotten.
+//
+// Every contributor is new to a tracker starting without state: records are
+// only emitted once it observed Warmup (a day if zero) of events, so that
+// the regular contributors are learned first.
+type ContributorTracker struct {
+	Warmup time.Duration
+	Bots   BotDetector
+
+	path  string
+	repos map[string]bool
+	cache *Cache
+
+	mu    sync.Mutex
+	since time.Time
+	dirty bool
+}
+
+// NewContributorTracker loads the contributors persisted at path, if not
+// empty, and remembers at most capacity of them (a million if zero).
+func NewContributorTracker(repos []string, path string, capacity int) (*ContributorTracker, error) {
+	t := &ContributorTracker{
+		Bots:  LoginBotDetector{},
+		path:  path,
+		repos: make(map[string]bool, len(repos)),
+		cache: NewCache("contributors", CacheConfig{MaxEntries: orDefault(capacity, defaultContributorCapacity)}),
+	}
+	for _, repo := range repos {
+		t.repos[strings.ToLower(repo)] = true
+	}
+
+	if path == "" {
+		return t, nil
+	}
+
+	payload, err := ReadMigratedStateFile(path, contributorStateVersion, nil)
+	if os.IsNotExist(err) {
+		return t, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state contributorState
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return nil, err
+	}
+	t.since = state.Since
+
+	// Loaded least recently active first, to be evicted first.
+	keys := make([]string, 0, len(state.Contributors))
+	for key := range state.Contributors {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return state.Contributors[keys[i]].Before(state.Contributors[keys[j]]) })
+	for _, key := range keys {
+		t.cache.SetAt(key, state.Contributors[key], len(key), state.Contributors[key])
+	}
+
+	return t, nil
+}
+
+func (t *ContributorTracker) tracks(repo string) bool {
+	return len(t.repos) == 0 || t.repos[strings.ToLower(repo)]
+}
+
+func contributorKey(repo, actor string) string {
+	return strings.ToLower(repo) + "\x00" + strings.ToLower(actor)
+}
+
+// Track returns the records of the first contributions in the batch.
+func (t *ContributorTracker) Track(events []*github.Event) []*NewContributorRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	warmup := t.Warmup
+	if warmup <= 0 {
+		warmup = defaultContributorWarmup
+	}
+
+	var records []*NewContributorRecord
+	for _, ev := range events {
+		repo, actor := ev.GetRepo().GetName(), ev.GetActor().GetLogin()
+		if repo == "" || actor == "" || !t.tracks(repo) || t.Bots.IsBot(ev) {
+			continue
+		}
+
+		record := &NewContributorRecord{SchemaVersion: SchemaVersion, EventID: ev.GetID(), Repo: repo, Actor: actor, CreatedAt: ev.GetCreatedAt()}
+		switch ev.GetType() {
+		case "PushEvent":
+			record.Kind = ContributionPush
+		case "PullRequestEvent":
+			var payload struct {
+				Action      *string `json:"action"`
+				PullRequest *struct {
+					HTMLURL *string `json:"html_url"`
+				} `json:"pull_request"`
+			}
+			if ev.RawPayload == nil || json.Unmarshal(*ev.RawPayload, &payload) != nil || stringOrEmpty(payload.Action) != "opened" {
+				continue
+			}
+			record.Kind = ContributionPullRequest
+			if payload.PullRequest != nil {
+				record.URL = stringOrEmpty(payload.PullRequest.HTMLURL)
+			}
+		default:
+			continue
+		}
+
+		if t.since.IsZero() {
+			t.since = record.CreatedAt
+		}
+
+		key := contributorKey(repo, actor)
+		_, known := t.cache.Get(key)
+		t.cache.Set(key, record.CreatedAt, len(key))
+		t.dirty = true
+
+		if !known && record.CreatedAt.Sub(t.since) >= warmup {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// Save persists the contributors if they changed since the last save.
+func (t *ContributorTracker) Save() error {
+	if t.path == "" {
+		return nil
+	}
+
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	t.dirty = false
+	state := contributorState{Since: t.since, Contributors: make(map[string]time.Time)}
+	t.mu.Unlock()
+
+	t.cache.Range(func(key string, v interface{}) bool {
+		state.Contributors[key] = v.(time.Time)
+		return true
+	})
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return WriteStateFile(t.path, contributorStateVersion, payload)
+}
@@ -0,0 +1,49 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestContributorTrackerAfterWarmup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "contributors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "contributors")
+
+	start := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	tracker, err := lib.NewContributorTracker(nil, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Warmup = time.Hour
+
+	// Learned during the warmup.
+	if records := tracker.Track([]*github.Event{testEvent("", "PushEvent", "regular", "octocat/hello-world", start, ""), testEvent("", "PushEvent", "ci[bot]", "octocat/hello-world", start, "")}); len(records) != 0 {
+		t.Fatalf("records emitted during the warmup: %+v", records)
+	}
+	if err := tracker.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted tracker remembers the contributors and its warmup.
+	tracker, err = lib.NewContributorTracker(nil, path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.Warmup = time.Hour
+
+	later := start.Add(2 * time.Hour)
+	records := tracker.Track([]*github.Event{testEvent("", "PushEvent", "regular", "octocat/hello-world", later, ""), testEvent("", "PushEvent", "newcomer", "octocat/hello-world", later, ""), testEvent("", "PushEvent", "newcomer", "octocat/hello-world", later, ""), testEvent("", "PushEvent", "dependabot[bot]", "octocat/hello-world", later, "")})
+	if len(records) != 1 || records[0].Actor != "newcomer" || records[0].Kind != lib.ContributionPush {
+		t.Errorf("unexpected records %+v", records)
+	}
+}
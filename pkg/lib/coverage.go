@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GHArchiveURL serves the hourly dumps of the public events, see
+// https://www.gharchive.org.
+const GHArchiveURL = "https://data.gharchive.org"
+
+// Events created at the end of an hour are archived a little later, the
+// archive is read this much past the hour.
+const coverageArchiveLag = 15 * time.Minute
+
+// CoverageReport is the capture rate of the feed over an hour: the fraction
+// of the events of the GH Archive dump which were archived by the feed.
+type CoverageReport struct {
+	Hour time.Time `json:"hour"`
+	// Events of the hour in GH Archive.
+	Expected int `json:"expected"`
+	// Among the expected events, those which were archived.
+	Captured int `json:"captured"`
+}
+
+func (r CoverageReport) Ratio() float64 {
+	if r.Expected == 0 {
+		return 1
+	}
+	return float64(r.Captured) / float64(r.Expected)
+}
+
+// CoverageEstimator compares the events archived by the feed (see
+// ArchiveSink) with the GH Archive dump of the same hour, to measure how many
+// events polling the events API misses.
+type CoverageEstimator struct {
+	archive ReadableStore
+	baseURL string
+	client  *http.Client
+
+	mu   sync.Mutex
+	last *CoverageReport
+}
+
+// NewCoverageEstimator compares archive with the dumps served at baseURL,
+// GHArchiveURL if empty.
+func NewCoverageEstimator(archive ReadableStore, baseURL string) *CoverageEstimator {
+	if baseURL == "" {
+		baseURL = GHArchiveURL
+	}
+
+	return &CoverageEstimator{
+		archive: archive,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// ghArchiveName returns the name of the dump of an hour, whose hour isn't
+// zero padded, e.g. 2015-01-01-5.json.gz.
+func ghArchiveName(hour time.Time) string {
+	return fmt.Sprintf("%s-%d.json.gz", hour.Format("2006-01-02"), hour.Hour())
+}
+
+// Estimate computes the coverage of the hour starting at the given time,
+// which must be complete in both GH Archive and the feed's archive, and
+// publishes it as the latest report.
+func (c *CoverageEstimator) Estimate(ctx context.Context, hour time.Time) (CoverageReport, error) {
+	hour = hour.UTC().Truncate(time.Hour)
+	report := CoverageReport{Hour: hour}
+
+	expected, err := c.ghArchiveIDs(ctx, hour)
+	if err != nil {
+		return report, err
+	}
+
+	feed, events, err := NewArchiveRangeFeed(c.archive, hour, hour.Add(time.Hour+coverageArchiveLag))
+	if err != nil {
+		return report, err
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- feed.Serve(ctx) }()
+
+	for batch := range events {
+		for _, ev := range batch {
+			if _, ok := expected[ev.GetID()]; ok {
+				// Don't count the events archived twice.
+				delete(expected, ev.GetID())
+				report.Captured++
+			}
+		}
+	}
+	if err := <-errc; err != nil {
+		return report, err
+	}
+	report.Expected = report.Captured + len(expected)
+
+	c.mu.Lock()
+	c.last = &report
+	c.mu.Unlock()
+
+	return report, nil
+}
+
+// ghArchiveIDs returns the IDs of the events of the dump of the hour.
+func (c *CoverageEstimator) ghArchiveIDs(ctx context.Context, hour time.Time) (map[string]struct{}, error) {
+	u := c.baseURL + "/" + ghArchiveName(hour)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	Identify(req)
+
+	rep, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 1024))
+		return nil, fmt.Errorf("GET %s: %s: %s", u, rep.Status, msg)
+	}
+
+	gz, err := gzip.NewReader(rep.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	ids := make(map[string]struct{})
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var ev struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || ev.ID == "" {
+			continue
+		}
+		ids[ev.ID] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("GET %s: %v", u, err)
+	}
+
+	return ids, nil
+}
+
+// Last returns the latest report, nil before the first estimate.
+func (c *CoverageEstimator) Last() *CoverageReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// WritePrometheus writes the latest report in the Prometheus text format:
+//
+//	github_feed_coverage_ratio            fraction of GH Archive's events captured
+//	github_feed_coverage_missed_events    events of GH Archive not captured
+//	github_feed_coverage_hour_seconds     start of the hour of the report
+func (c *CoverageEstimator) WritePrometheus(w io.Writer) {
+	last := c.Last()
+	if last == nil {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP %s_coverage_ratio Fraction of the events of the GH Archive hour captured by the feed.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_coverage_ratio gauge\n", statsNamespace)
+	fmt.Fprintf(w, "%s_coverage_ratio %g\n", statsNamespace, last.Ratio())
+
+	fmt.Fprintf(w, "# HELP %s_coverage_missed_events Events of the GH Archive hour missed by the feed.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_coverage_missed_events gauge\n", statsNamespace)
+	fmt.Fprintf(w, "%s_coverage_missed_events %d\n", statsNamespace, last.Expected-last.Captured)
+
+	fmt.Fprintf(w, "# HELP %s_coverage_hour_seconds Start of the hour of the coverage estimate.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_coverage_hour_seconds gauge\n", statsNamespace)
+	fmt.Fprintf(w, "%s_coverage_hour_seconds %d\n", statsNamespace, last.Hour.Unix())
+}
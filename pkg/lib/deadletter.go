@@ -0,0 +1,473 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	defaultSinkRetries = 3
+	defaultSinkBackoff = time.Second
+	// Version 0 letters are unstamped, version 1 stamps them and their
+	// records are migrated to the latest schema version.
+	deadLetterVersion = 1
+)
+
+// DeadLetter is an event a sink failed to deliver, along with the reason.
+type DeadLetter struct {
+	Version int       `json:"version"`
+	Sink    string    `json:"sink"`
+	Reason  string    `json:"reason"`
+	Time    time.Time `json:"time"`
+	Record  *Record   `json:"record"`
+	// Key of the batch the event failed in (see batchKey), whose events
+	// are redriven together.
+	Batch string `json:"batch,omitempty"`
+}
+
+// ErrDeadLetterQueueLocked is returned when opening a dead letter queue
+// already open, e.g. by a running feed.
+var ErrDeadLetterQueueLocked = errors.New("dead letter queue is open in another process")
+
+var errFileLocked = errors.New("file is locked")
+
+// DeadLetterQueue stores the events sinks permanently failed to deliver, one
+// JSON DeadLetter per line of a local file.
+//
+// Redrive and Expire replace the file, which the other processes appending
+// to it would miss: an open queue holds the lock of path.lock, and opening
+// it elsewhere fails with ErrDeadLetterQueueLocked until it is closed.
+type DeadLetterQueue struct {
+	path string
+	lock *os.File
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenDeadLetterQueue opens the queue at path, created if missing. Letters
+// queued by previous releases are migrated to the current version, the
+// queue is refused if it holds letters or records of a newer release.
+func OpenDeadLetterQueue(path string) (*DeadLetterQueue, error) {
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		if err == errFileLocked {
+			return nil, fmt.Errorf("%s: %w", path, ErrDeadLetterQueueLocked)
+		}
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	q := &DeadLetterQueue{path: path, lock: lock, f: f}
+	if err := q.migrate(); err != nil {
+		f.Close()
+		lock.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// migrate upgrades the letters of previous versions in place.
+func (q *DeadLetterQueue) migrate() error {
+	f, err := os.Open(q.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var lines []json.RawMessage
+	migrated := 0
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := append(json.RawMessage(nil), scanner.Bytes()...)
+		if len(line) == 0 {
+			continue
+		}
+
+		var letter map[string]json.RawMessage
+		if json.Unmarshal(line, &letter) != nil {
+			// Kept for inspection, see Redrive.
+			lines = append(lines, line)
+			continue
+		}
+
+		version := 0
+		if raw, ok := letter["version"]; ok {
+			if err := json.Unmarshal(raw, &version); err != nil {
+				return fmt.Errorf("%s: invalid dead letter version %s", q.path, raw)
+			}
+		}
+		if version > deadLetterVersion {
+			return fmt.Errorf("%s: %w: dead letter version %d, latest known is %d", q.path, ErrStateFileVersion, version, deadLetterVersion)
+		}
+
+		var record map[string]json.RawMessage
+		if json.Unmarshal(letter["record"], &record) != nil || record == nil {
+			lines = append(lines, line)
+			continue
+		}
+		schema, err := recordVersion(record)
+		if err != nil {
+			lines = append(lines, line)
+			continue
+		}
+		if schema > SchemaVersion {
+			return fmt.Errorf("%s: %w: record schema version %d, latest known is %d", q.path, ErrStateFileVersion, schema, SchemaVersion)
+		}
+		if version == deadLetterVersion && schema == SchemaVersion {
+			lines = append(lines, line)
+			continue
+		}
+
+		if letter["record"], err = MigrateRecord(letter["record"], SchemaVersion); err != nil {
+			return fmt.Errorf("%s: %v", q.path, err)
+		}
+		letter["version"] = json.RawMessage(fmt.Sprint(deadLetterVersion))
+		if line, err = json.Marshal(letter); err != nil {
+			return err
+		}
+		lines = append(lines, line)
+		migrated++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if migrated == 0 {
+		return nil
+	}
+
+	log.Printf("Migrated %d dead letters of %s to version %d", migrated, q.path, deadLetterVersion)
+	return q.rewrite(lines)
+}
+
+// Put appends the events to the queue, durably.
+func (q *DeadLetterQueue) Put(sink string, events []*github.Event, reason error) error {
+	var buf []byte
+	now := time.Now().UTC()
+	// Without a key the letters are redriven in batches of any size.
+	key, _ := batchKey(events)
+	for _, ev := range events {
+		b, err := json.Marshal(&DeadLetter{Version: deadLetterVersion, Sink: sink, Reason: Redact(reason.Error()), Time: now, Record: NewRecord(ev), Batch: key})
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.f.Write(buf); err != nil {
+		return err
+	}
+
+	return q.f.Sync()
+}
+
+// Close releases the queue to the other processes.
+func (q *DeadLetterQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := q.f.Close()
+	q.lock.Close()
+	return err
+}
+
+// Redrive writes the dead letters of the named sink back to it, in the batches
+// they failed in, so that e.g. the HTTP sink's requests keep their key. The
+// letters queued without their batch are written in batches of batchSize.
+// Delivered letters are removed from the queue, the others (and
+// those of other sinks) are kept. It returns the number of delivered events,
+// also on errors reading the queue, which keeps the letters past them, and
+// on failed writes, the last of which is returned.
+func (q *DeadLetterQueue) Redrive(ctx context.Context, name string, sink Sink, batchSize int) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f, err := os.Open(q.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var kept []json.RawMessage
+	var batch []*github.Event
+	var pending []json.RawMessage
+	var batchOf string
+	var writeErr error
+	delivered := 0
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := sink.Write(ctx, batch); err != nil {
+			kept = append(kept, pending...)
+			writeErr = err
+		} else {
+			delivered += len(batch)
+		}
+		// The sink may hold on to the batch, e.g. a BatchingSink.
+		batch, pending = nil, nil
+	}
+
+	// Offset of the lines read, the rest is kept as is if unreadable.
+	var offset int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		offset += int64(advance)
+		return advance, token, err
+	})
+	for scanner.Scan() {
+		line := append(json.RawMessage(nil), scanner.Bytes()...)
+		if len(line) == 0 {
+			continue
+		}
+
+		var letter DeadLetter
+		if err := json.Unmarshal(line, &letter); err != nil || letter.Record == nil || letter.Record.Event == nil {
+			// Keep what can't be understood for inspection.
+			kept = append(kept, line)
+			continue
+		}
+
+		if letter.Sink != name {
+			kept = append(kept, line)
+			continue
+		}
+
+		if letter.Batch != batchOf || (letter.Batch == "" && len(batch) >= batchSize) {
+			flush()
+		}
+		batch = append(batch, letter.Record.Event)
+		pending = append(pending, line)
+		batchOf = letter.Batch
+	}
+	if err := scanner.Err(); err != nil {
+		// The letters delivered are removed all the same.
+		flush()
+		if _, serr := f.Seek(offset, io.SeekStart); serr != nil {
+			return delivered, serr
+		}
+		rest, rerr := ioutil.ReadAll(f)
+		if rerr != nil {
+			return delivered, rerr
+		}
+		if len(rest) > 0 {
+			kept = append(kept, bytes.TrimSuffix(rest, []byte("\n")))
+		}
+		if werr := q.rewrite(kept); werr != nil {
+			return delivered, werr
+		}
+		return delivered, err
+	}
+	flush()
+
+	if err := q.rewrite(kept); err != nil {
+		return delivered, err
+	}
+	return delivered, writeErr
+}
+
+// Expire removes the letters queued before now minus the maximum age, then
+// the oldest ones until the queue fits in the maximum size, e.g. when a sink
+// is gone for good. Lines which can't be decoded are kept for inspection.
+func (q *DeadLetterQueue) Expire(policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	var result RetentionResult
+	if policy.Unbounded() {
+		return result, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f, err := os.Open(q.path)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+
+	type line struct {
+		data []byte
+		time time.Time
+	}
+	var lines []line
+	var total int64
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		l := line{data: append([]byte(nil), scanner.Bytes()...)}
+
+		var letter struct {
+			Time time.Time `json:"time"`
+		}
+		if json.Unmarshal(l.data, &letter) == nil {
+			l.time = letter.Time
+		}
+		lines = append(lines, l)
+		total += int64(len(l.data)) + 1
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	// Letters are appended in time order.
+	cutoff := now.Add(-policy.MaxAge)
+	var kept []json.RawMessage
+	for _, l := range lines {
+		dated := !l.time.IsZero()
+		expired := policy.MaxAge > 0 && dated && l.time.Before(cutoff)
+		oversize := policy.MaxBytes > 0 && dated && total > policy.MaxBytes
+		if expired || oversize {
+			result.Removed++
+			result.Bytes += int64(len(l.data)) + 1
+			total -= int64(len(l.data)) + 1
+			continue
+		}
+		kept = append(kept, l.data)
+	}
+
+	if result.Removed == 0 {
+		return result, nil
+	}
+
+	if err := q.rewrite(kept); err != nil {
+		return RetentionResult{}, err
+	}
+	recordRetention("dead_letters", result)
+	return result, nil
+}
+
+// rewrite atomically replaces the queue with the kept letters.
+func (q *DeadLetterQueue) rewrite(kept []json.RawMessage) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(q.path), filepath.Base(q.path)+stateTempPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, line := range kept {
+		w.Write(line)
+		w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := q.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return err
+	}
+
+	q.f, err = os.OpenFile(q.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	return err
+}
+
+// DeadLetterSink retries failed writes to a sink with exponential backoff,
+// and stores the batch in a dead letter queue once the retries are
+// exhausted, so that no event is silently dropped. Without a queue, the
+// write fails once the retries are exhausted. The events of a
+// PayloadTooLargeError are queued at once, alone.
+type DeadLetterSink struct {
+	Sink
+	Name    string
+	Queue   *DeadLetterQueue
+	Retries int
+	Backoff time.Duration
+}
+
+func NewDeadLetterSink(name string, sink Sink, queue *DeadLetterQueue) *DeadLetterSink {
+	return &DeadLetterSink{
+		Sink:    sink,
+		Name:    name,
+		Queue:   queue,
+		Retries: defaultSinkRetries,
+		Backoff: defaultSinkBackoff,
+	}
+}
+
+// Write only fails if the batch could neither be delivered nor queued.
+// Retries are logged.
+func (s *DeadLetterSink) Write(ctx context.Context, events []*github.Event) error {
+	backoff := s.Backoff
+
+	err := s.Sink.Write(ctx, events)
+	for i := 0; err != nil && i < s.Retries && payloadTooLarge(err) == nil; i++ {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		warnf("Retrying sink %s after %v: %v", s.Name, backoff, err)
+		backoff *= 2
+
+		err = s.Sink.Write(ctx, events)
+	}
+
+	if err == nil {
+		return nil
+	}
+	if tooLarge := payloadTooLarge(err); tooLarge != nil {
+		events = tooLarge
+	}
+
+	if s.Queue == nil {
+		return fmt.Errorf("sink %s failed to deliver %d events: %w", s.Name, len(events), err)
+	}
+
+	if qerr := s.Queue.Put(s.Name, events, err); qerr != nil {
+		return fmt.Errorf("%v, and queuing dead letters failed: %v", err, qerr)
+	}
+
+	warnf("Sink %s failed to deliver %d events, queued as dead letters: %v", s.Name, len(events), err)
+	return nil
+}
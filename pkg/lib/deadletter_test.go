@@ -0,0 +1,193 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// retainingSink keeps the batches written to it, failing those holding the
+// event fail.
+type retainingSink struct {
+	fail    string
+	batches [][]*github.Event
+}
+
+func (s *retainingSink) Write(ctx context.Context, events []*github.Event) error {
+	for _, ev := range events {
+		if ev.GetID() == s.fail {
+			return errors.New("unavailable")
+		}
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *retainingSink) Close() error { return nil }
+
+// ids lists the IDs of the batches, e.g. 1,2 5.
+func (s *retainingSink) ids() string {
+	batches := make([]string, len(s.batches))
+	for i, batch := range s.batches {
+		batches[i] = strings.Join(batchIDs(batch), ",")
+	}
+	return strings.Join(batches, " ")
+}
+
+func openDeadLetters(t *testing.T, letters map[string][]int) (*lib.DeadLetterQueue, string) {
+	dir, err := ioutil.TempDir("", "deadletters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "dlq.ndjson")
+	q, err := lib.OpenDeadLetterQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sink := range []string{"http", "kafka"} {
+		for _, id := range letters[sink] {
+			if err := q.Put(sink, []*github.Event{testEvent(fmt.Sprint(id), "PushEvent", "", "o/r", time.Time{}, "")}, errors.New("unavailable")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return q, path
+}
+
+func TestDeadLetterQueueRedrive(t *testing.T) {
+	q, path := openDeadLetters(t, map[string][]int{
+		"http":  {1, 2, 3, 4, 5},
+		"kafka": {6},
+	})
+	defer os.RemoveAll(filepath.Dir(path))
+	defer q.Close()
+
+	sink := &retainingSink{fail: "3"}
+	delivered, err := q.Redrive(context.Background(), "http", sink, 2)
+	if err == nil || err.Error() != "unavailable" {
+		t.Errorf("got error %v, want the failed write's", err)
+	}
+	if delivered != 4 {
+		t.Errorf("delivered %d letters, want 4", delivered)
+	}
+	// Each letter is redriven in its own batch, in which it failed. The
+	// batches held by the sink aren't overwritten by the next ones.
+	if got := sink.ids(); got != "1 2 4 5" {
+		t.Errorf("got batches %s, want 1 2 4 5", got)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"3", "6"} {
+		if !strings.Contains(string(data), `"id":"`+id+`"`) {
+			t.Errorf("letter %s was removed from %s", id, data)
+		}
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("got %d letters, want 2 in %s", n, data)
+	}
+}
+
+func TestDeadLetterQueueRedriveUnreadable(t *testing.T) {
+	q, path := openDeadLetters(t, map[string][]int{"http": {1, 2}})
+	defer os.RemoveAll(filepath.Dir(path))
+	defer q.Close()
+
+	// A line longer than read, followed by another letter.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(strings.Repeat("x", 65*1024*1024) + "\n")
+	f.Close()
+	if err := q.Put("http", []*github.Event{testEvent("7", "PushEvent", "", "o/r", time.Time{}, "")}, errors.New("unavailable")); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &retainingSink{}
+	delivered, err := q.Redrive(context.Background(), "http", sink, 1)
+	if err == nil {
+		t.Fatal("redrove an unreadable queue")
+	}
+	if delivered != 2 || sink.ids() != "1 2" {
+		t.Errorf("delivered %d letters in batches %s, want 1 2", delivered, sink.ids())
+	}
+
+	// The delivered letters are gone, the rest is kept.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 || !strings.Contains(string(data), `"id":"7"`) {
+		t.Errorf("got %d lines, want the unreadable one and letter 7", n)
+	}
+	if strings.Contains(string(data), `"id":"1"`) || strings.Contains(string(data), `"id":"2"`) {
+		t.Error("the delivered letters were kept")
+	}
+}
+
+func TestDeadLetterQueueLocked(t *testing.T) {
+	q, path := openDeadLetters(t, nil)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if _, err := lib.OpenDeadLetterQueue(path); !errors.Is(err, lib.ErrDeadLetterQueueLocked) {
+		t.Fatalf("got %v opening an open queue, want %v", err, lib.ErrDeadLetterQueueLocked)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	q, err := lib.OpenDeadLetterQueue(path)
+	if err != nil {
+		t.Fatalf("reopening a closed queue: %v", err)
+	}
+	q.Close()
+}
+
+func TestDeadLetterQueueRedriveBatches(t *testing.T) {
+	q, path := openDeadLetters(t, map[string][]int{"http": {1}})
+	defer os.RemoveAll(filepath.Dir(path))
+	defer q.Close()
+
+	batch := []*github.Event{testEvent("2", "PushEvent", "", "o/r", time.Time{}, ""), testEvent("3", "PushEvent", "", "o/r", time.Time{}, ""), testEvent("4", "PushEvent", "", "o/r", time.Time{}, "")}
+	if err := q.Put("http", batch, errors.New("unavailable")); err != nil {
+		t.Fatal(err)
+	}
+	// Queued without their batch by a previous release.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"5", "6", "7"} {
+		f.WriteString(`{"version":1,"sink":"http","record":{"schema_version":3,"type":"PushEvent","id":"` + id + `"}}` + "\n")
+	}
+	f.Close()
+
+	// A failed batch is kept whole.
+	sink := &retainingSink{fail: "3"}
+	if _, err := q.Redrive(context.Background(), "http", sink, 2); err == nil {
+		t.Fatal("redrove a failing batch")
+	}
+	if got := sink.ids(); got != "1 5,6 7" {
+		t.Errorf("got batches %s, want 1 5,6 7", got)
+	}
+
+	sink = &retainingSink{}
+	if _, err := q.Redrive(context.Background(), "http", sink, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.ids(); got != "2,3,4" {
+		t.Errorf("got batches %s, want 2,3,4", got)
+	}
+}
@@ -0,0 +1,180 @@
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	// Version 1 only held the IDs, version 2 adds when they were seen.
+	dedupStateVersion    = 2
+	defaultDedupCapacity = 10000
+)
+
+// Deduplicator drops the events already seen. Successive polls of the
+// events API overlap, and a restarted process polls events it already
+// delivered, the IDs of the last capacity events are remembered (and
+// persisted in a state file) to drop them.
+type Deduplicator struct {
+	path     string
+	capacity int
+	clock    Clock
+
+	mu    sync.Mutex
+	seen  map[string]struct{}
+	order []dedupEntry
+	// Number of changes to the IDs, and of those persisted.
+	changes uint64
+	saved   uint64
+}
+
+type dedupEntry struct {
+	ID   string    `json:"id"`
+	Seen time.Time `json:"seen"`
+}
+
+// NewDeduplicator loads the IDs persisted at path, if not empty. The clock,
+// the system clock if nil, dates when the IDs were seen.
+func NewDeduplicator(path string, capacity int, clock Clock) (*Deduplicator, error) {
+	if capacity <= 0 {
+		capacity = defaultDedupCapacity
+	}
+
+	d := &Deduplicator{path: path, capacity: capacity, clock: clockOrSystem(clock), seen: make(map[string]struct{})}
+	if path == "" {
+		return d, nil
+	}
+
+	payload, err := ReadMigratedStateFile(path, dedupStateVersion, dedupStateMigrations(d.clock.Now()))
+	if os.IsNotExist(err) {
+		return d, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entries []dedupEntry
+	if err := json.Unmarshal(payload, &entries); err != nil {
+		return nil, err
+	}
+	d.add(entries...)
+
+	return d, nil
+}
+
+// dedupStateMigrations upgrade the state files read at now.
+func dedupStateMigrations(now time.Time) []StateMigration {
+	return []StateMigration{
+		// 1 -> 2: the IDs are considered seen at the migration.
+		func(payload []byte) ([]byte, error) {
+			var ids []string
+			if err := json.Unmarshal(payload, &ids); err != nil {
+				return nil, err
+			}
+
+			entries := make([]dedupEntry, 0, len(ids))
+			for _, id := range ids {
+				entries = append(entries, dedupEntry{ID: id, Seen: now})
+			}
+			return json.Marshal(entries)
+		},
+	}
+}
+
+func (d *Deduplicator) add(entries ...dedupEntry) {
+	for _, e := range entries {
+		d.seen[e.ID] = struct{}{}
+		d.order = append(d.order, e)
+	}
+
+	if over := len(d.order) - d.capacity; over > 0 {
+		d.drop(over)
+	}
+}
+
+// drop forgets the n oldest IDs.
+func (d *Deduplicator) drop(n int) {
+	for _, e := range d.order[:n] {
+		delete(d.seen, e.ID)
+	}
+	d.order = append(d.order[:0:0], d.order[n:]...)
+}
+
+// Expire forgets the IDs seen before the given time, the events API only
+// returns recent events, older IDs only take space in the state file. It
+// returns the number of IDs forgotten.
+func (d *Deduplicator) Expire(before time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := 0
+	for n < len(d.order) && d.order[n].Seen.Before(before) {
+		n++
+	}
+	if n > 0 {
+		d.drop(n)
+		d.changes++
+	}
+
+	return n
+}
+
+// Filter returns the events not seen before, in order, and remembers them.
+// Events without ID are kept.
+func (d *Deduplicator) Filter(events []*github.Event) []*github.Event {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.clock.Now()
+	fresh := make([]*github.Event, 0, len(events))
+	for _, ev := range events {
+		id := ev.GetID()
+		if id != "" {
+			if _, ok := d.seen[id]; ok {
+				continue
+			}
+			d.add(dedupEntry{ID: id, Seen: now})
+			d.changes++
+		}
+
+		fresh = append(fresh, ev)
+	}
+
+	return fresh
+}
+
+// Save persists the remembered IDs if they changed since the last save. It
+// should be called once the filtered events were delivered, so that a
+// restarted process delivers them again rather than losing them.
+func (d *Deduplicator) Save() error {
+	if d.path == "" {
+		return nil
+	}
+
+	d.mu.Lock()
+	if d.changes == d.saved {
+		d.mu.Unlock()
+		return nil
+	}
+	changes := d.changes
+	payload, err := json.Marshal(d.order)
+	d.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if err := WriteStateFile(d.path, dedupStateVersion, payload); err != nil {
+		return err
+	}
+
+	// The IDs may have changed since, they're saved by the next call.
+	d.mu.Lock()
+	if changes > d.saved {
+		d.saved = changes
+	}
+	d.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,67 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestDeduplicatorSaveRetried(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state", "dedup")
+	d, err := lib.NewDeduplicator(path, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Filter([]*github.Event{{ID: github.String("1")}})
+
+	// The state directory is a file, failing the save.
+	stateDir := filepath.Dir(path)
+	if err := ioutil.WriteFile(stateDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Save(); err == nil {
+		t.Fatal("saved in a file")
+	}
+
+	os.Remove(stateDir)
+	if err := os.Mkdir(stateDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the failed save wasn't retried: %v", err)
+	}
+}
+
+func TestDeduplicatorClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := lib.NewSimulatedClock(start)
+	d, err := lib.NewDeduplicator("", 10, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Filter([]*github.Event{{ID: github.String("1")}})
+	clock.Advance(time.Hour)
+	d.Filter([]*github.Event{{ID: github.String("2")}})
+
+	if n := d.Expire(start.Add(time.Minute)); n != 1 {
+		t.Errorf("expired %d IDs, want the one seen an hour before", n)
+	}
+	if events := d.Filter([]*github.Event{{ID: github.String("1")}, {ID: github.String("2")}}); len(events) != 1 || events[0].GetID() != "1" {
+		t.Errorf("got %v, want 1 forgotten and 2 remembered", events)
+	}
+}
@@ -0,0 +1,280 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// EventSchema declares a derived event type: events of its own, e.g. the
+// analytics or alerts computed from GitHub's events, emitted by the derive
+// transform into the same pipeline (filters, sinks, archive) as the others.
+// Their payload is an object of the declared fields, whose values are JSON
+// of the declared kind: string, integer, number, boolean, object or array.
+//
+// The names of derived types can't end with Event, those of GitHub's types
+// (past and future) all do, e.g. LargePush.
+type EventSchema struct {
+	Name        string            `yaml:"name" json:"name"`
+	Description string            `yaml:"description" json:"description,omitempty"`
+	Fields      map[string]string `yaml:"fields" json:"fields"`
+	// Required fields, the others are omitted from the payloads when
+	// missing from the source event.
+	Required []string `yaml:"required" json:"required,omitempty"`
+}
+
+var derivedTypeName = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+var fieldKinds = map[string]bool{
+	"string": true, "integer": true, "number": true, "boolean": true, "object": true, "array": true,
+}
+
+func (s *EventSchema) validate() error {
+	if !derivedTypeName.MatchString(s.Name) {
+		return fmt.Errorf("invalid event type name '%s', expected a capitalized alphanumeric name", s.Name)
+	}
+	if strings.HasSuffix(s.Name, "Event") {
+		return fmt.Errorf("event type '%s': derived type names can't end with Event, reserved to GitHub's", s.Name)
+	}
+	if len(s.Fields) == 0 {
+		return fmt.Errorf("event type '%s': no fields", s.Name)
+	}
+	for field, kind := range s.Fields {
+		if !fieldKinds[kind] {
+			return fmt.Errorf("event type '%s': field '%s': unknown kind '%s'", s.Name, field, kind)
+		}
+	}
+	for _, field := range s.Required {
+		if _, ok := s.Fields[field]; !ok {
+			return fmt.Errorf("event type '%s': required field '%s' isn't declared", s.Name, field)
+		}
+	}
+	return nil
+}
+
+func (s *EventSchema) required(field string) bool {
+	for _, f := range s.Required {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+var eventTypes = struct {
+	sync.RWMutex
+	byName map[string]*EventSchema
+}{byName: make(map[string]*EventSchema)}
+
+// RegisterEventType declares a derived event type, replacing the one
+// registered under the same name, see EventSchema. The types declared in a
+// transform chain are registered when it is parsed.
+func RegisterEventType(schema EventSchema) error {
+	if err := schema.validate(); err != nil {
+		return err
+	}
+
+	eventTypes.Lock()
+	defer eventTypes.Unlock()
+	eventTypes.byName[schema.Name] = &schema
+	return nil
+}
+
+// LookupEventType returns the schema of a derived event type, nil if none
+// is registered.
+func LookupEventType(name string) *EventSchema {
+	eventTypes.RLock()
+	defer eventTypes.RUnlock()
+	return eventTypes.byName[name]
+}
+
+// EventTypes returns the names of the derived event types, sorted.
+func EventTypes() []string {
+	eventTypes.RLock()
+	defer eventTypes.RUnlock()
+
+	names := make([]string, 0, len(eventTypes.byName))
+	for name := range eventTypes.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Deriver is implemented by the transforms emitting several events for
+// one, e.g. derived events next to their source. A TransformChain passes
+// the events Derive returns, in order, to the next transform instead of
+// Apply's.
+type Deriver interface {
+	Derive(ev *github.Event) ([]*github.Event, error)
+}
+
+// deriveTransform emits an event of a derived type for each event matching
+// its filter, its payload's fields extracted from the event by key specs
+// (see KeySpec), after the event or instead of it. The derived event is
+// the source event's ID suffixed with :<type>, stable across replays, and
+// its actor, repository, organization and creation time.
+type deriveTransform struct {
+	schema  *EventSchema
+	filter  *Filter
+	fields  map[string]*KeySpec
+	replace bool
+}
+
+// Apply returns the derived event, nil if the event doesn't match.
+func (t *deriveTransform) Apply(ev *github.Event) (*github.Event, error) {
+	if t.filter != nil && !t.filter.Match(ev) {
+		return nil, nil
+	}
+
+	doc, err := eventDocument(ev)
+	if err != nil {
+		return nil, err
+	}
+	payload := make(map[string]interface{}, len(t.fields))
+	for field, spec := range t.fields {
+		v, err := spec.value(doc)
+		var missing *missingFieldError
+		if errors.As(err, &missing) && !t.schema.required(field) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if payload[field], err = coerceField(v, t.schema.Fields[field]); err != nil {
+			return nil, fmt.Errorf("%s field '%s': %v", t.schema.Name, field, err)
+		}
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(b)
+	return &github.Event{
+		ID:         github.String(ev.GetID() + ":" + t.schema.Name),
+		Type:       github.String(t.schema.Name),
+		Public:     ev.Public,
+		Actor:      ev.Actor,
+		Repo:       ev.Repo,
+		Org:        ev.Org,
+		CreatedAt:  ev.CreatedAt,
+		RawPayload: &raw,
+	}, nil
+}
+
+func (t *deriveTransform) Derive(ev *github.Event) ([]*github.Event, error) {
+	derived, err := t.Apply(ev)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case derived == nil && t.replace:
+		return nil, nil
+	case derived == nil:
+		return []*github.Event{ev}, nil
+	case t.replace:
+		return []*github.Event{derived}, nil
+	default:
+		return []*github.Event{ev, derived}, nil
+	}
+}
+
+// coerceField converts the value of a field to its declared kind, the
+// strings of templates parsed.
+func coerceField(v interface{}, kind string) (interface{}, error) {
+	s, isString := v.(string)
+	switch kind {
+	case "string":
+		switch v := v.(type) {
+		case string:
+			return v, nil
+		case json.Number:
+			return v.String(), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if isString {
+			n, ok = json.Number(strings.TrimSpace(s)), true
+		}
+		if i, err := n.Int64(); ok && err == nil {
+			return i, nil
+		}
+	case "number":
+		n, ok := v.(json.Number)
+		if isString {
+			n, ok = json.Number(strings.TrimSpace(s)), true
+		}
+		if f, err := n.Float64(); ok && err == nil {
+			return f, nil
+		}
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		if b, err := strconv.ParseBool(s); isString && err == nil {
+			return b, nil
+		}
+	case "object":
+		if m, ok := v.(map[string]interface{}); ok {
+			return m, nil
+		}
+	case "array":
+		if a, ok := v.([]interface{}); ok {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("%v isn't a valid %s", v, kind)
+}
+
+func init() {
+	RegisterTransform("derive", func(decode func(interface{}) error) (Transform, error) {
+		var opts struct {
+			Emit       string            `yaml:"emit"`
+			Expression string            `yaml:"expression"`
+			Fields     map[string]string `yaml:"fields"`
+			Replace    bool              `yaml:"replace"`
+		}
+		if err := decode(&opts); err != nil {
+			return nil, err
+		}
+
+		schema := LookupEventType(opts.Emit)
+		if schema == nil {
+			return nil, fmt.Errorf("unknown event type '%s', declare it under types", opts.Emit)
+		}
+		t := &deriveTransform{schema: schema, fields: make(map[string]*KeySpec), replace: opts.Replace}
+		if opts.Expression != "" {
+			filter, err := ParseFilter(opts.Expression)
+			if err != nil {
+				return nil, err
+			}
+			t.filter = filter
+		}
+		for field, spec := range opts.Fields {
+			if _, ok := schema.Fields[field]; !ok {
+				return nil, fmt.Errorf("field '%s' isn't declared by %s", field, schema.Name)
+			}
+			k, err := ParseKeySpec(spec)
+			if err != nil {
+				return nil, err
+			}
+			t.fields[field] = k
+		}
+		for _, field := range schema.Required {
+			if t.fields[field] == nil {
+				return nil, fmt.Errorf("required field '%s' of %s has no key spec", field, schema.Name)
+			}
+		}
+		return t, nil
+	})
+}
@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// IP families of DialerConfig.
+const (
+	FamilyIPv4       = "ipv4"
+	FamilyIPv6       = "ipv6"
+	FamilyPreferIPv6 = "prefer-ipv6"
+)
+
+const defaultDNSPort = "53"
+
+// DialerConfig configures how connections are established, for networks
+// routing GitHub through internal resolvers or reaching it over IPv6 only. A
+// zero field keeps the system's behavior.
+type DialerConfig struct {
+	// DNS servers queried in order instead of the system's, as IP address
+	// or address:port (port 53 by default).
+	Resolvers []string
+	// ipv4 or ipv6 only dial addresses of the family, prefer-ipv6 dials the
+	// IPv6 addresses first.
+	Family string
+	// Static addresses of hosts, by lower case name, bypassing DNS, e.g.
+	// api.github.com to the address of an internal proxy.
+	Hosts map[string][]net.IP
+}
+
+// ParseHostOverride parses host=address, e.g. api.github.com=2001:db8::1.
+func ParseHostOverride(s string) (string, net.IP, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return "", nil, fmt.Errorf("invalid host override '%s', expected host=address", s)
+	}
+
+	ip := net.ParseIP(strings.Trim(kv[1], "[]"))
+	if ip == nil {
+		return "", nil, fmt.Errorf("invalid address '%s' of host %s", kv[1], kv[0])
+	}
+	return strings.ToLower(kv[0]), ip, nil
+}
+
+// ConfigureDialer makes t resolve and dial the hosts as configured: the
+// addresses of a host (overridden, or resolved with the configured
+// resolvers) are filtered and ordered by family, then dialed in turn until
+// one connects. TLS is still verified against the host name.
+//
+// Configuring http.DefaultTransport covers the clients which don't set their
+// own transport. Configure it before GuardTransport, which checks the host
+// names before they are resolved.
+func ConfigureDialer(t *http.Transport, conf DialerConfig) error {
+	switch conf.Family {
+	case "", FamilyIPv4, FamilyIPv6, FamilyPreferIPv6:
+	default:
+		return fmt.Errorf("unknown IP family '%s', expected %s, %s or %s", conf.Family, FamilyIPv4, FamilyIPv6, FamilyPreferIPv6)
+	}
+
+	resolver := net.DefaultResolver
+	if len(conf.Resolvers) > 0 {
+		servers := make([]string, len(conf.Resolvers))
+		for i, server := range conf.Resolvers {
+			host, port, err := net.SplitHostPort(server)
+			if err != nil {
+				host, port = strings.Trim(server, "[]"), defaultDNSPort
+			}
+			// Resolvers can't be resolved themselves.
+			if net.ParseIP(host) == nil {
+				return fmt.Errorf("invalid DNS resolver '%s', expected an IP address", conf.Resolvers[i])
+			}
+			servers[i] = net.JoinHostPort(host, port)
+		}
+		resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			var err error
+			for _, server := range servers {
+				var conn net.Conn
+				if conn, err = d.DialContext(ctx, network, server); err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		}}
+	}
+
+	if len(conf.Resolvers) == 0 && conf.Family == "" && len(conf.Hosts) == 0 {
+		return nil
+	}
+
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dial(ctx, network, addr)
+		}
+
+		ips := conf.Hosts[strings.ToLower(host)]
+		if ip := net.ParseIP(host); ip != nil {
+			ips = []net.IP{ip}
+		} else if len(ips) == 0 {
+			addrs, err := resolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range addrs {
+				ips = append(ips, a.IP)
+			}
+		}
+
+		ips = dialOrder(ips, conf.Family)
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("no %s address for %s", conf.Family, host)
+		}
+
+		var first error
+		for _, ip := range ips {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if first == nil {
+				first = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		return nil, first
+	}
+
+	return nil
+}
+
+// dialOrder filters the addresses by family and sorts them in dialing order.
+func dialOrder(ips []net.IP, family string) []net.IP {
+	var ordered []net.IP
+	for _, ip := range ips {
+		v4 := ip.To4() != nil
+		if (family == FamilyIPv4 && !v4) || (family == FamilyIPv6 && v4) {
+			continue
+		}
+		ordered = append(ordered, ip)
+	}
+
+	if family == FamilyPreferIPv6 {
+		sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].To4() == nil && ordered[j].To4() != nil })
+	}
+	return ordered
+}
@@ -0,0 +1,63 @@
+package lib_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestConfigureDialerOverrides(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	host, ip, err := lib.ParseHostOverride("API.GitHub.test=127.0.0.1")
+	if err != nil || host != "api.github.test" {
+		t.Fatalf("ParseHostOverride = %s, %v", host, err)
+	}
+
+	transport := &http.Transport{}
+	conf := lib.DialerConfig{Hosts: map[string][]net.IP{host: {ip}}}
+	if err := lib.ConfigureDialer(transport, conf); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get("http://api.github.test:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// The override only has an IPv4 address.
+	transport = &http.Transport{}
+	conf.Family = lib.FamilyIPv6
+	if err := lib.ConfigureDialer(transport, conf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&http.Client{Transport: transport}).Get("http://api.github.test:" + port); err == nil {
+		t.Error("dialed an IPv4 address with an IPv6-only dialer")
+	}
+}
+
+func TestConfigureDialerInvalid(t *testing.T) {
+	for _, conf := range []lib.DialerConfig{
+		{Family: "ipv5"},
+		{Resolvers: []string{"10.0.0.1:53:53"}},
+	} {
+		if err := lib.ConfigureDialer(&http.Transport{}, conf); err == nil {
+			t.Errorf("ConfigureDialer(%+v) accepted an invalid configuration", conf)
+		}
+	}
+
+	for _, s := range []string{"api.github.com", "=10.0.0.1", "api.github.com=github"} {
+		if _, _, err := lib.ParseHostOverride(s); err == nil {
+			t.Errorf("ParseHostOverride(%s) accepted an invalid override", s)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	dictionaryField = "dictionary"
+	actorRefField   = "actor_ref"
+	repoRefField    = "repo_ref"
+)
+
+// RecordDictionary holds the actors and repositories shared by the records
+// following it in a dictionary-encoded stream, see DictionaryEncoded.
+type RecordDictionary struct {
+	Actors []*github.User       `json:"actors,omitempty"`
+	Repos  []*github.Repository `json:"repos,omitempty"`
+}
+
+// BatchSerializer is implemented by serializers encoding a batch of records
+// at once, e.g. to share the objects repeated across them.
+type BatchSerializer interface {
+	Serializer
+	EncodeBatch(w io.Writer, records []*Record) error
+}
+
+// DictionaryEncoded wraps a serializer so that the batches it encodes start
+// with a dictionary record holding the actors and repositories appearing
+// more than once in the batch, which the records then reference by index in
+// actor_ref and repo_ref. They repeat heavily in the firehose (bots, busy
+// repositories), the batches of the network sinks shrink accordingly.
+// Records encoded on their own are left as is.
+//
+// A dictionary applies to the records following it until the next one, so
+// that concatenated batches (e.g. compacted archives) stay readable. JSON
+// streams are read back with RecordExpander.
+func DictionaryEncoded(s Serializer) Serializer {
+	return dictionarySerializer{Serializer: s}
+}
+
+type dictionarySerializer struct {
+	Serializer
+}
+
+// dictionaryIndex assigns indices to the objects seen more than once, keyed
+// by their JSON so that only identical objects are shared.
+type dictionaryIndex struct {
+	counts  map[string]int
+	indices map[string]int
+}
+
+func newDictionaryIndex() *dictionaryIndex {
+	return &dictionaryIndex{counts: make(map[string]int), indices: make(map[string]int)}
+}
+
+func dictionaryKey(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func (s dictionarySerializer) EncodeBatch(w io.Writer, records []*Record) error {
+	actors, repos := newDictionaryIndex(), newDictionaryIndex()
+	for _, r := range records {
+		if r.Actor != nil {
+			actors.counts[dictionaryKey(r.Actor)]++
+		}
+		if r.Repo != nil {
+			repos.counts[dictionaryKey(r.Repo)]++
+		}
+	}
+
+	var dict RecordDictionary
+	encoded := make([]*Record, len(records))
+	for i, r := range records {
+		ev := *r.Event
+		ref := *r
+		ref.Event = &ev
+
+		if r.Actor != nil {
+			if key := dictionaryKey(r.Actor); actors.counts[key] > 1 {
+				idx, ok := actors.indices[key]
+				if !ok {
+					idx = len(dict.Actors)
+					actors.indices[key] = idx
+					dict.Actors = append(dict.Actors, r.Actor)
+				}
+				ref.ActorRef, ev.Actor = &idx, nil
+			}
+		}
+		if r.Repo != nil {
+			if key := dictionaryKey(r.Repo); repos.counts[key] > 1 {
+				idx, ok := repos.indices[key]
+				if !ok {
+					idx = len(dict.Repos)
+					repos.indices[key] = idx
+					dict.Repos = append(dict.Repos, r.Repo)
+				}
+				ref.RepoRef, ev.Repo = &idx, nil
+			}
+		}
+
+		encoded[i] = &ref
+	}
+
+	if len(dict.Actors) > 0 || len(dict.Repos) > 0 {
+		if err := s.Encode(w, &Record{SchemaVersion: SchemaVersion, Dictionary: &dict}); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range encoded {
+		if err := s.Encode(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordExpander resolves the references of a dictionary-encoded stream of
+// JSON records, see DictionaryEncoded, one line at a time. Streams without
+// dictionaries pass through unchanged.
+type RecordExpander struct {
+	actors []json.RawMessage
+	repos  []json.RawMessage
+}
+
+// Expand returns the record with its references resolved, or nil for a
+// dictionary record which only updates the expander.
+func (x *RecordExpander) Expand(line []byte) ([]byte, error) {
+	// Only the lines holding a dictionary or references are rewritten.
+	var fields struct {
+		Dictionary json.RawMessage `json:"dictionary"`
+		ActorRef   json.RawMessage `json:"actor_ref"`
+		RepoRef    json.RawMessage `json:"repo_ref"`
+	}
+	if err := json.Unmarshal(line, &fields); err != nil {
+		return nil, err
+	}
+	if fields.Dictionary == nil && fields.ActorRef == nil && fields.RepoRef == nil {
+		return line, nil
+	}
+
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, err
+	}
+
+	if raw, ok := record[dictionaryField]; ok {
+		var dict struct {
+			Actors []json.RawMessage `json:"actors"`
+			Repos  []json.RawMessage `json:"repos"`
+		}
+		if err := json.Unmarshal(raw, &dict); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", dictionaryField, err)
+		}
+		x.actors, x.repos = dict.Actors, dict.Repos
+		return nil, nil
+	}
+
+	resolved := false
+	for _, ref := range []struct {
+		field, target string
+		entries       []json.RawMessage
+	}{
+		{actorRefField, "actor", x.actors},
+		{repoRefField, "repo", x.repos},
+	} {
+		raw, ok := record[ref.field]
+		if !ok {
+			continue
+		}
+
+		var idx int
+		if err := json.Unmarshal(raw, &idx); err != nil || idx < 0 || idx >= len(ref.entries) {
+			return nil, fmt.Errorf("invalid %s %s, the dictionary has %d entries", ref.field, raw, len(ref.entries))
+		}
+
+		record[ref.target] = ref.entries[idx]
+		delete(record, ref.field)
+		resolved = true
+	}
+
+	if !resolved {
+		return line, nil
+	}
+	return json.Marshal(record)
+}
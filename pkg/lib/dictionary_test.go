@@ -0,0 +1,125 @@
+package lib_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// dictionaryTestBatch mimics the firehose: a few actors and repositories
+// shared by many events, and some seen once.
+func dictionaryTestBatch() []*lib.Record {
+	var records []*lib.Record
+	for i := 0; i < 30; i++ {
+		actor := i % 3
+		if i%10 == 0 {
+			actor = 100 + i
+		}
+
+		records = append(records, lib.NewRecord(&github.Event{
+			ID:   github.String(fmt.Sprint(1000 + i)),
+			Type: github.String("PushEvent"),
+			Actor: &github.User{
+				ID:        github.Int64(int64(actor)),
+				Login:     github.String(fmt.Sprintf("user-%d", actor)),
+				AvatarURL: github.String(fmt.Sprintf("https://avatars.githubusercontent.com/u/%d?", actor)),
+			},
+			Repo: &github.Repository{
+				ID:   github.Int64(int64(i % 2)),
+				Name: github.String(fmt.Sprintf("octocat/repo-%d", i%2)),
+				URL:  github.String(fmt.Sprintf("https://api.github.com/repos/octocat/repo-%d", i%2)),
+			},
+		}))
+	}
+	return records
+}
+
+func decodeRecords(t *testing.T, stream []byte) []interface{} {
+	var records []interface{}
+	var expander lib.RecordExpander
+
+	scanner := bufio.NewScanner(bytes.NewReader(stream))
+	for scanner.Scan() {
+		line, err := expander.Expand(scanner.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		} else if line == nil {
+			continue
+		}
+
+		var record interface{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestDictionaryEncodingExpands(t *testing.T) {
+	batch := dictionaryTestBatch()
+	plainJSON, err := lib.LookupSerializer("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plain bytes.Buffer
+	for _, r := range batch {
+		plainJSON.Encode(&plain, r)
+	}
+
+	var encoded bytes.Buffer
+	dict := lib.DictionaryEncoded(plainJSON).(lib.BatchSerializer)
+	// Dictionaries apply until the next one, as in compacted archives.
+	for i := 0; i < 2; i++ {
+		if err := dict.EncodeBatch(&encoded, batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if encoded.Len() >= plain.Len()*2 {
+		t.Errorf("dictionary encoding is %d bytes, plain is %d", encoded.Len(), plain.Len()*2)
+	}
+
+	want := decodeRecords(t, plain.Bytes())
+	got := decodeRecords(t, encoded.Bytes())
+	if len(got) != 2*len(want) {
+		t.Fatalf("decoded %d records, want %d", len(got), 2*len(want))
+	}
+	for i := range got {
+		if !reflect.DeepEqual(got[i], want[i%len(want)]) {
+			t.Errorf("record %d expanded to %v, want %v", i, got[i], want[i%len(want)])
+		}
+	}
+
+	// The records are unchanged by the encoding.
+	if batch[0].Actor == nil || batch[0].ActorRef != nil {
+		t.Errorf("encoding modified the batch")
+	}
+}
+
+func TestRecordExpanderRejectsDanglingReferences(t *testing.T) {
+	var expander lib.RecordExpander
+	if _, err := expander.Expand([]byte(`{"schema_version":2,"id":"1","actor_ref":0}`)); err == nil {
+		t.Errorf("expected an error for a reference without dictionary")
+	}
+}
+
+func TestRecordExpanderPassesOtherRecords(t *testing.T) {
+	var expander lib.RecordExpander
+	// Fields named like the references below the top level aren't resolved.
+	line := []byte(`{"schema_version":3,"id":"1","payload":{"ref":"main","actor_ref":"x","dictionary":{}}}`)
+	got, err := expander.Expand(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(line) {
+		t.Errorf("expanded %s to %s", line, got)
+	}
+}
@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const digestPrefix = "sha256:"
+
+var (
+	// ErrDigestMismatch is returned when a record's content doesn't match its
+	// digest, i.e. it was altered after being emitted.
+	ErrDigestMismatch = errors.New("digest mismatch")
+	// ErrNoDigest is returned when verifying records older than schema
+	// version 2.
+	ErrNoDigest = errors.New("record has no digest")
+)
+
+func digestOf(canonical []byte) string {
+	sum := sha256.Sum256(canonical)
+	return digestPrefix + hex.EncodeToString(sum[:])
+}
+
+// EventDigest returns the content address of an event, the SHA-256 of its
+// canonical JSON (see MarshalCanonical), e.g. `sha256:9f86d0...`.
+func EventDigest(ev *github.Event) (string, error) {
+	b, err := MarshalCanonical(ev)
+	if err != nil {
+		return "", err
+	}
+
+	return digestOf(b), nil
+}
+
+// recordDigest computes the digest of the event enveloped in a record, i.e.
+// without the envelope's fields.
+func recordDigest(record map[string]json.RawMessage) (string, error) {
+	event := make(map[string]json.RawMessage, len(record))
+	for k, v := range record {
+		if k != schemaVersionField && k != digestField && k != repoSnapshotField && k != pathRoutesField {
+			event[k] = v
+		}
+	}
+
+	b, err := json.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+
+	canonical, err := Canonicalize(b)
+	if err != nil {
+		return "", err
+	}
+
+	return digestOf(canonical), nil
+}
+
+// VerifyRecord checks that a JSON record matches its digest.
+func VerifyRecord(data []byte) error {
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+
+	raw, ok := record[digestField]
+	if !ok {
+		return ErrNoDigest
+	}
+
+	var expected string
+	if err := json.Unmarshal(raw, &expected); err != nil {
+		return fmt.Errorf("invalid %s: %v", digestField, err)
+	}
+
+	actual, err := recordDigest(record)
+	if err != nil {
+		return err
+	}
+
+	if actual != expected {
+		return fmt.Errorf("%w: expected %s, got %s", ErrDigestMismatch, expected, actual)
+	}
+
+	return nil
+}
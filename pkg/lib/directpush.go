@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Messages of the commits pushed by GitHub when merging a pull request: merge
+// commits ("Merge pull request #12 from ...") and squashed ones ("Fix (#12)").
+var pullRequestMerge = regexp.MustCompile(`^Merge pull request #\d+ |\(#\d+\)$`)
+
+// DirectPushRecord reports a push to the default branch of a repository
+// which didn't go through a pull request.
+type DirectPushRecord struct {
+	SchemaVersion int    `json:"schema_version"`
+	Repo          string `json:"repo"`
+	Branch        string `json:"branch"`
+	Actor         string `json:"actor"`
+	Commits       int    `json:"commits"`
+	// SHA of the branch after the push.
+	Head string `json:"head,omitempty"`
+	// Whether the push rewrote the branch, only told by webhooks.
+	Forced  bool      `json:"forced,omitempty"`
+	EventID string    `json:"event_id"`
+	Time    time.Time `json:"time"`
+}
+
+type directPushPayload struct {
+	Ref     *string `json:"ref"`
+	Head    *string `json:"head"`
+	After   *string `json:"after"`
+	Size    *int    `json:"size"`
+	Forced  *bool   `json:"forced"`
+	Commits []struct {
+		Message *string `json:"message"`
+	} `json:"commits"`
+	// Only in webhook payloads.
+	Repository *struct {
+		DefaultBranch *string `json:"default_branch"`
+	} `json:"repository"`
+}
+
+// DirectPushDetector raises a DirectPushRecord for each push to the default
+// branch of a set of organizations or repositories (all if empty) which
+// doesn't merge a pull request, as told by the messages GitHub gives merge
+// and squashed commits. Pull requests merged by rebase, whose commits keep
+// their messages, are reported.
+//
+// The default branch is the one of webhook payloads, else the one returned
+// by DefaultBranch (e.g. from RepoSnapshotter), else main or master.
+type DirectPushDetector struct {
+	// Returns the default branch of a repository, empty if unknown.
+	DefaultBranch func(repo string) string
+
+	scopes map[string]bool
+}
+
+// NewDirectPushDetector watches the given organizations (owner) or
+// repositories (owner/name).
+func NewDirectPushDetector(scopes []string) *DirectPushDetector {
+	d := &DirectPushDetector{scopes: make(map[string]bool, len(scopes))}
+	for _, scope := range scopes {
+		d.scopes[strings.ToLower(scope)] = true
+	}
+
+	return d
+}
+
+func (d *DirectPushDetector) watches(repo string) bool {
+	if len(d.scopes) == 0 {
+		return true
+	}
+	repo = strings.ToLower(repo)
+	return d.scopes[repo] || d.scopes[strings.SplitN(repo, "/", 2)[0]]
+}
+
+func (d *DirectPushDetector) isDefault(repo, branch string, payload *directPushPayload) bool {
+	if payload.Repository != nil && payload.Repository.DefaultBranch != nil {
+		return branch == *payload.Repository.DefaultBranch
+	}
+	if d.DefaultBranch != nil {
+		if name := d.DefaultBranch(repo); name != "" {
+			return branch == name
+		}
+	}
+	return branch == "main" || branch == "master"
+}
+
+// Track returns the alerts raised by the batch.
+func (d *DirectPushDetector) Track(events []*github.Event) []*DirectPushRecord {
+	var records []*DirectPushRecord
+
+	for _, ev := range events {
+		repo := ev.GetRepo().GetName()
+		if ev.GetType() != "PushEvent" || repo == "" || !d.watches(repo) || ev.RawPayload == nil {
+			continue
+		}
+
+		var payload directPushPayload
+		if err := json.Unmarshal(*ev.RawPayload, &payload); err != nil {
+			continue
+		}
+		branch := strings.TrimPrefix(stringOrEmpty(payload.Ref), "refs/heads/")
+		if branch == stringOrEmpty(payload.Ref) || !d.isDefault(repo, branch, &payload) {
+			continue
+		}
+
+		merge := false
+		for _, commit := range payload.Commits {
+			subject := strings.SplitN(stringOrEmpty(commit.Message), "\n", 2)[0]
+			if pullRequestMerge.MatchString(strings.TrimSpace(subject)) {
+				merge = true
+				break
+			}
+		}
+		if merge {
+			continue
+		}
+
+		commits := len(payload.Commits)
+		if payload.Size != nil {
+			commits = *payload.Size
+		}
+		head := stringOrEmpty(payload.Head)
+		if head == "" {
+			head = stringOrEmpty(payload.After)
+		}
+
+		records = append(records, &DirectPushRecord{
+			SchemaVersion: SchemaVersion,
+			Repo:          repo,
+			Branch:        branch,
+			Actor:         ev.GetActor().GetLogin(),
+			Commits:       commits,
+			Head:          head,
+			Forced:        payload.Forced != nil && *payload.Forced,
+			EventID:       ev.GetID(),
+			Time:          ev.GetCreatedAt().UTC(),
+		})
+	}
+
+	return records
+}
@@ -0,0 +1,62 @@
+package lib_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestDirectPushDetector(t *testing.T) {
+	at := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	push := func(id, repo, payload string) *github.Event {
+		raw := json.RawMessage(payload)
+		return &github.Event{
+			ID:         github.String(id),
+			Type:       github.String("PushEvent"),
+			Actor:      &github.User{Login: github.String("mallory")},
+			Repo:       &github.Repository{Name: github.String(repo)},
+			CreatedAt:  &at,
+			RawPayload: &raw,
+		}
+	}
+
+	d := lib.NewDirectPushDetector([]string{"octocat"})
+	d.DefaultBranch = func(repo string) string {
+		if repo == "octocat/legacy" {
+			return "develop"
+		}
+		return ""
+	}
+
+	records := d.Track([]*github.Event{
+		push("1", "octocat/hello", `{"ref":"refs/heads/main","head":"abc","size":2,"commits":[{"message":"Fix"},{"message":"Bump"}]}`),
+		// Pull request merges.
+		push("2", "octocat/hello", `{"ref":"refs/heads/main","size":1,"commits":[{"message":"Merge pull request #12 from a/b\n\nFix"}]}`),
+		push("3", "octocat/hello", `{"ref":"refs/heads/main","size":1,"commits":[{"message":"Add feature (#13)\n\n* wip"}]}`),
+		// Other branches.
+		push("4", "octocat/hello", `{"ref":"refs/heads/feature","size":1,"commits":[{"message":"Fix"}]}`),
+		push("5", "octocat/hello", `{"ref":"refs/tags/v1","size":0,"commits":[]}`),
+		push("6", "octocat/legacy", `{"ref":"refs/heads/master","size":1,"commits":[{"message":"Fix"}]}`),
+		push("7", "octocat/legacy", `{"ref":"refs/heads/develop","size":1,"commits":[{"message":"Fix"}]}`),
+		// Webhook payloads tell the default branch.
+		push("8", "octocat/trunk", `{"ref":"refs/heads/trunk","after":"def","forced":true,"commits":[{"message":"Rewrite"}],`+
+			`"repository":{"default_branch":"trunk"}}`),
+		// Unwatched.
+		push("9", "other/hello", `{"ref":"refs/heads/main","size":1,"commits":[{"message":"Fix"}]}`),
+	})
+
+	want := []*lib.DirectPushRecord{
+		{SchemaVersion: lib.SchemaVersion, Repo: "octocat/hello", Branch: "main", Actor: "mallory", Commits: 2, Head: "abc", EventID: "1", Time: at},
+		{SchemaVersion: lib.SchemaVersion, Repo: "octocat/legacy", Branch: "develop", Actor: "mallory", Commits: 1, EventID: "7", Time: at},
+		{SchemaVersion: lib.SchemaVersion, Repo: "octocat/trunk", Branch: "trunk", Actor: "mallory", Commits: 1, Head: "def", Forced: true, EventID: "8", Time: at},
+	}
+	if !reflect.DeepEqual(records, want) {
+		for _, r := range records {
+			t.Errorf("got %+v", r)
+		}
+	}
+}
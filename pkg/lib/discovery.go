@@ -0,0 +1,272 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	// DiscoveryEventType is the type of the synthetic events emitted by the
+	// SearchSeeder, they never come from the events API.
+	DiscoveryEventType = "DiscoveryEvent"
+
+	defaultDiscoveryInterval = 10 * time.Minute
+	defaultDiscoveryBudget   = 60
+	defaultDiscoveryResults  = 30
+	// Hits not returned for this long are forgotten, and emitted again if
+	// they come back.
+	discoveryMemory = 7 * 24 * time.Hour
+)
+
+// Kinds of SearchQuery.
+const (
+	SearchRepositories = "repos"
+	SearchCode         = "code"
+)
+
+// SearchQuery is a query of the Search API, e.g.
+// {SearchRepositories, "topic:kubernetes-operator"}.
+type SearchQuery struct {
+	Kind  string
+	Query string
+}
+
+// ParseSearchQuery parses kind:query, e.g. "repos:language:go stars:>100".
+func ParseSearchQuery(s string) (SearchQuery, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return SearchQuery{}, fmt.Errorf("search query '%s' lacks a kind, e.g. repos:%s", s, s)
+	}
+
+	q := SearchQuery{Kind: s[:i], Query: strings.TrimSpace(s[i+1:])}
+	if q.Kind != SearchRepositories && q.Kind != SearchCode {
+		return q, fmt.Errorf("unknown search kind '%s', expected %s or %s", q.Kind, SearchRepositories, SearchCode)
+	}
+	if q.Query == "" {
+		return q, fmt.Errorf("empty %s search query", q.Kind)
+	}
+	return q, nil
+}
+
+func (q SearchQuery) String() string {
+	return q.Kind + ":" + q.Query
+}
+
+// DiscoveryPayload is the payload of a DiscoveryEvent: the query and its hit.
+type DiscoveryPayload struct {
+	Query      string             `json:"query"`
+	Kind       string             `json:"kind"`
+	Repository *github.Repository `json:"repository"`
+	Code       *github.CodeResult `json:"code,omitempty"`
+}
+
+// SearchSeeder finds recent activity matching Search API queries (updated
+// repositories, new code search hits) and emits them as DiscoveryEvents, so
+// that activity the events API doesn't surface, or surfaced before the feed
+// started, seeds the stream. A hit is emitted once, repositories again when
+// pushed to.
+//
+// The Search API is rate limited separately from the events API, searches
+// are capped by an hourly Budget and suspended until the reset when the
+// limit is hit anyway.
+type SearchSeeder struct {
+	Queries []SearchQuery
+	// Interval between the runs of the queries, 10 minutes if zero.
+	Interval time.Duration
+	// Searches per hour, 60 if zero.
+	Budget int
+	// Called with non-fatal errors, e.g. EventFeed.ReportError.
+	ReportError func(op, eventID string, err error)
+	Clock       Clock
+
+	client *github.Client
+
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	window  time.Time
+	spent   int
+	limited time.Time
+}
+
+func NewSearchSeeder(client *github.Client, queries []SearchQuery) *SearchSeeder {
+	return &SearchSeeder{Queries: queries, client: client, seen: make(map[string]time.Time)}
+}
+
+// spend reports whether a search fits in the budget.
+func (s *SearchSeeder) spend(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Before(s.limited) {
+		return false
+	}
+	if now.Sub(s.window) >= time.Hour {
+		s.window, s.spent = now, 0
+	}
+	if s.spent >= orDefault(s.Budget, defaultDiscoveryBudget) {
+		return false
+	}
+
+	s.spent++
+	return true
+}
+
+// Serve runs the queries every interval, publishing the new hits on events,
+// until ctx is done.
+func (s *SearchSeeder) Serve(ctx context.Context, events chan<- []*github.Event) error {
+	clock := clockOrSystem(s.Clock)
+	interval := s.Interval
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+
+	for {
+		if batch := s.run(ctx, clock.Now()); len(batch) > 0 {
+			select {
+			case events <- batch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		select {
+		case <-clock.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// run searches each query and returns the events of the new hits.
+func (s *SearchSeeder) run(ctx context.Context, now time.Time) []*github.Event {
+	var batch []*github.Event
+	for _, q := range s.Queries {
+		if !s.spend(now) {
+			break
+		}
+
+		payloads, err := s.search(ctx, q)
+		if err != nil {
+			var rate *github.RateLimitError
+			if errors.As(err, &rate) {
+				s.mu.Lock()
+				s.limited = rateLimitReset(rate.Rate.Reset.Time, rate.Response, now)
+				s.mu.Unlock()
+			}
+			s.reportError(fmt.Errorf("%s: %w", q, err))
+			continue
+		}
+
+		for _, p := range payloads {
+			if ev := s.discovered(p, now); ev != nil {
+				batch = append(batch, ev)
+			}
+		}
+	}
+
+	s.forget(now.Add(-discoveryMemory))
+	return batch
+}
+
+func (s *SearchSeeder) reportError(err error) {
+	if s.ReportError != nil {
+		s.ReportError(OpDiscover, "", err)
+	}
+}
+
+func (s *SearchSeeder) search(ctx context.Context, q SearchQuery) ([]*DiscoveryPayload, error) {
+	opts := &github.SearchOptions{Order: "desc", ListOptions: github.ListOptions{PerPage: defaultDiscoveryResults}}
+	ctx = WithSubsystem(ctx, SubsystemDiscovery)
+
+	var payloads []*DiscoveryPayload
+	switch q.Kind {
+	case SearchRepositories:
+		opts.Sort = "updated"
+		result, _, err := s.client.Search.Repositories(ctx, q.Query, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range result.Repositories {
+			payloads = append(payloads, &DiscoveryPayload{Query: q.String(), Kind: q.Kind, Repository: repo})
+		}
+	case SearchCode:
+		opts.Sort = "indexed"
+		result, _, err := s.client.Search.Code(ctx, q.Query, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, code := range result.CodeResults {
+			payloads = append(payloads, &DiscoveryPayload{Query: q.String(), Kind: q.Kind, Repository: code.Repository, Code: code})
+		}
+	}
+	return payloads, nil
+}
+
+// discoveryKey identifies a hit: the repository at its last push, or the
+// file at its blob.
+func discoveryKey(p *DiscoveryPayload) string {
+	key := p.Query + "\x00" + p.Repository.GetFullName()
+	if p.Code != nil {
+		return key + "\x00" + p.Code.GetPath() + "\x00" + p.Code.GetSHA()
+	}
+	return key + "\x00" + p.Repository.GetPushedAt().String()
+}
+
+// discovered returns the event of a hit, or nil if it was already emitted.
+func (s *SearchSeeder) discovered(p *DiscoveryPayload, now time.Time) *github.Event {
+	if p.Repository == nil {
+		return nil
+	}
+
+	key := discoveryKey(p)
+	s.mu.Lock()
+	_, seen := s.seen[key]
+	s.seen[key] = now
+	s.mu.Unlock()
+	if seen {
+		return nil
+	}
+
+	payload, err := json.Marshal(p)
+	if err != nil {
+		s.reportError(err)
+		return nil
+	}
+	raw := json.RawMessage(payload)
+
+	// Deterministic, so that the deduplicator drops the hits emitted before
+	// a restart.
+	sum := sha256.Sum256([]byte(key))
+	created := now.UTC()
+	repo := p.Repository
+	return &github.Event{
+		ID:         github.String("discovery-" + hex.EncodeToString(sum[:12])),
+		Type:       github.String(DiscoveryEventType),
+		Public:     github.Bool(!repo.GetPrivate()),
+		Actor:      repo.Owner,
+		Repo:       &github.Repository{ID: repo.ID, Name: repo.FullName, URL: repo.URL},
+		CreatedAt:  &created,
+		RawPayload: &raw,
+	}
+}
+
+// forget drops the hits last returned before the given time.
+func (s *SearchSeeder) forget(before time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, t := range s.seen {
+		if t.Before(before) {
+			delete(s.seen, key)
+		}
+	}
+}
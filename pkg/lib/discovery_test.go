@@ -0,0 +1,76 @@
+package lib_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := lib.ParseSearchQuery("repos:topic:kubernetes-operator stars:>10")
+	if err != nil || q.Kind != lib.SearchRepositories || q.Query != "topic:kubernetes-operator stars:>10" {
+		t.Errorf("parsed %+v: %v", q, err)
+	}
+
+	for _, invalid := range []string{"kubernetes", "issues:bug", "code:"} {
+		if _, err := lib.ParseSearchQuery(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestSearchSeederEmitsNewHits(t *testing.T) {
+	var searches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The repository is pushed to before the third search.
+		pushed := atomic.AddInt32(&searches, 1) / 3
+		fmt.Fprintf(w, `{"total_count":1,"items":[{"id":1,"full_name":"octocat/operator","owner":{"login":"octocat"},"pushed_at":"2020-06-10T12:0%d:00Z"}]}`, pushed)
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	clock := lib.NewSimulatedClock(time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC))
+	seeder := lib.NewSearchSeeder(client, []lib.SearchQuery{{Kind: lib.SearchRepositories, Query: "topic:operator"}})
+	seeder.Clock = clock
+	seeder.Interval = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan []*github.Event)
+	go seeder.Serve(ctx, events)
+
+	waitSearch := func() {
+		for clock.Waiters() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	first := <-events
+	if len(first) != 1 || first[0].GetType() != lib.DiscoveryEventType || first[0].GetRepo().GetName() != "octocat/operator" {
+		t.Fatalf("unexpected discovery batch %v", first)
+	}
+
+	// The unchanged hit of the second search is not emitted again.
+	waitSearch()
+	clock.Advance(time.Minute)
+	waitSearch()
+	if n := atomic.LoadInt32(&searches); n != 2 {
+		t.Fatalf("%d searches, want 2", n)
+	}
+
+	clock.Advance(time.Minute)
+	second := <-events
+	if len(second) != 1 || second[0].GetID() == first[0].GetID() {
+		t.Errorf("expected the pushed repository to be emitted again, got %v", second)
+	}
+}
@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const defaultDiskCacheBytes = 256 << 20
+
+// DiskCache stores the API responses in a directory, a file per response
+// named after the hash of its key, so that the ETags survive restarts. It
+// is bounded by the size of the files: the least recently written are
+// removed once it is exceeded.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	bytes int64
+}
+
+// NewDiskCache creates dir if needed, and bounds the responses stored to
+// maxBytes, unbounded if zero.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &DiskCache{dir: dir, maxBytes: maxBytes}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		c.bytes += fi.Size()
+	}
+	return c, nil
+}
+
+func (c *DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	response, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return response, true
+}
+
+// Set writes the response atomically, readers never see a partial one.
+func (c *DiskCache) Set(key string, response []byte) {
+	path := c.path(key)
+	previous, _ := os.Stat(path)
+
+	tmp, err := ioutil.TempFile(c.dir, ".tmp-")
+	if err != nil {
+		warnf("Failed caching %s: %v", Redact(key), err)
+		return
+	}
+	_, err = tmp.Write(response)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		warnf("Failed caching %s: %v", Redact(key), err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bytes += int64(len(response))
+	if previous != nil {
+		c.bytes -= previous.Size()
+	}
+	if c.maxBytes > 0 && c.bytes > c.maxBytes {
+		c.prune()
+	}
+}
+
+func (c *DiskCache) Delete(key string) {
+	path := c.path(key)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if os.Remove(path) == nil {
+		c.mu.Lock()
+		c.bytes -= fi.Size()
+		c.mu.Unlock()
+	}
+}
+
+// prune removes the least recently written responses until the cache is
+// back to 90% of its bound, so that it isn't pruned on every write.
+func (c *DiskCache) prune() {
+	files, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		warnf("Failed pruning the HTTP cache: %v", err)
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+
+	c.bytes = 0
+	for _, fi := range files {
+		c.bytes += fi.Size()
+	}
+	for _, fi := range files {
+		if c.bytes <= c.maxBytes*9/10 {
+			break
+		}
+		if os.Remove(filepath.Join(c.dir, fi.Name())) == nil {
+			c.bytes -= fi.Size()
+		}
+	}
+}
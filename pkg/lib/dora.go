@@ -0,0 +1,270 @@
+package lib
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	defaultDORAPeriod = 24 * time.Hour
+	// Merged changes waiting for a deployment, per repository, and for how
+	// long: most repositories never deploy, as far as events tell.
+	maxDORAPendingChanges = 10000
+	doraPendingMemory     = 30 * 24 * time.Hour
+)
+
+// Sources of the deployments of a DORARecord.
+const (
+	DORADeployments = "deployment"
+	DORAReleases    = "release"
+)
+
+var doraFailureTitle = regexp.MustCompile(`(?i)^revert\b|\bhot-?fix\b`)
+
+// DORARecord holds proxies of the DORA metrics of a repository over a period:
+//
+//   - deployment frequency: successful deployments (DeploymentStatusEvent,
+//     only delivered by webhooks) or, for repositories without any, published
+//     releases;
+//   - lead time for changes: the median time from the opening of the merged
+//     pull requests to the deployment shipping them;
+//   - change failure rate: failed deployments and merged reverts or hotfixes
+//     over the deployments.
+type DORARecord struct {
+	SchemaVersion int       `json:"schema_version"`
+	Repo          string    `json:"repo"`
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+
+	Deployments int `json:"deployments"`
+	// deployment or release, empty without deployments.
+	DeploymentSource  string  `json:"deployment_source,omitempty"`
+	DeploymentsPerDay float64 `json:"deployments_per_day"`
+
+	MergedChanges   int     `json:"merged_changes"`
+	DeployedChanges int     `json:"deployed_changes"`
+	LeadTimeSeconds float64 `json:"lead_time_seconds,omitempty"`
+
+	ChangeFailures    int     `json:"change_failures"`
+	ChangeFailureRate float64 `json:"change_failure_rate"`
+}
+
+type doraActivity struct {
+	deployments int
+	releases    int
+	merged      int
+	failures    int
+	leadTimes   []time.Duration
+}
+
+type doraRepo struct {
+	name string
+	// Opening time of the changes merged since the last deployment.
+	pending  []time.Time
+	activity *doraActivity
+}
+
+type doraPayload struct {
+	Action      *string `json:"action"`
+	PullRequest *struct {
+		Title     *string    `json:"title"`
+		Merged    *bool      `json:"merged"`
+		CreatedAt *time.Time `json:"created_at"`
+	} `json:"pull_request"`
+	DeploymentStatus *struct {
+		State *string `json:"state"`
+	} `json:"deployment_status"`
+}
+
+// DORATracker computes DORARecords from the pull request, release and
+// deployment events of a set of repositories (all if empty). Periods are
+// aligned on multiples of Period (a day if zero) of the events' creation
+// time, so that replayed archives give the same records, and are emitted
+// once an event of a later period is seen. Late events are accounted in the
+// current period.
+type DORATracker struct {
+	Period time.Duration
+
+	tracked map[string]bool
+	repos   map[string]*doraRepo
+	current time.Time
+}
+
+func NewDORATracker(repos []string) *DORATracker {
+	t := &DORATracker{tracked: make(map[string]bool, len(repos)), repos: make(map[string]*doraRepo)}
+	for _, repo := range repos {
+		t.tracked[strings.ToLower(repo)] = true
+	}
+
+	return t
+}
+
+func (t *DORATracker) period() time.Duration {
+	if t.Period <= 0 {
+		return defaultDORAPeriod
+	}
+	return t.Period
+}
+
+func (t *DORATracker) tracks(repo string) bool {
+	return len(t.tracked) == 0 || t.tracked[strings.ToLower(repo)]
+}
+
+func (t *DORATracker) repo(name string) *doraRepo {
+	key := strings.ToLower(name)
+	r, ok := t.repos[key]
+	if !ok {
+		r = &doraRepo{name: name}
+		t.repos[key] = r
+	}
+	if r.activity == nil {
+		r.activity = &doraActivity{}
+	}
+	return r
+}
+
+// Track accounts the batch and returns the records of the periods it ended.
+func (t *DORATracker) Track(events []*github.Event) []*DORARecord {
+	var records []*DORARecord
+
+	for _, ev := range events {
+		name := ev.GetRepo().GetName()
+		if name == "" || !t.tracks(name) || ev.RawPayload == nil {
+			continue
+		}
+
+		switch ev.GetType() {
+		case "PullRequestEvent", "ReleaseEvent", "DeploymentStatusEvent":
+		default:
+			continue
+		}
+
+		var payload doraPayload
+		if err := json.Unmarshal(*ev.RawPayload, &payload); err != nil {
+			continue
+		}
+
+		created := ev.GetCreatedAt()
+		if start := created.Truncate(t.period()); t.current.IsZero() {
+			t.current = start
+		} else if start.After(t.current) {
+			records = append(records, t.flush()...)
+			t.current = start
+		}
+
+		switch ev.GetType() {
+		case "PullRequestEvent":
+			pr := payload.PullRequest
+			if stringOrEmpty(payload.Action) != "closed" || pr == nil || pr.Merged == nil || !*pr.Merged {
+				continue
+			}
+			r := t.repo(name)
+			r.activity.merged++
+			if doraFailureTitle.MatchString(stringOrEmpty(pr.Title)) {
+				r.activity.failures++
+			}
+			if pr.CreatedAt != nil && len(r.pending) < maxDORAPendingChanges {
+				r.pending = append(r.pending, *pr.CreatedAt)
+			}
+		case "ReleaseEvent":
+			if stringOrEmpty(payload.Action) != "published" {
+				continue
+			}
+			r := t.repo(name)
+			r.activity.releases++
+			r.deploy(created)
+		case "DeploymentStatusEvent":
+			if payload.DeploymentStatus == nil {
+				continue
+			}
+			switch stringOrEmpty(payload.DeploymentStatus.State) {
+			case "success":
+				r := t.repo(name)
+				r.activity.deployments++
+				r.deploy(created)
+			case "failure", "error":
+				t.repo(name).activity.failures++
+			}
+		}
+	}
+
+	return records
+}
+
+// deploy ships the pending changes.
+func (r *doraRepo) deploy(at time.Time) {
+	for _, opened := range r.pending {
+		r.activity.leadTimes = append(r.activity.leadTimes, at.Sub(opened))
+	}
+	r.pending = r.pending[:0]
+}
+
+// flush returns the records of the current period, sorted by repository, and
+// forgets the repositories without pending changes.
+func (t *DORATracker) flush() []*DORARecord {
+	period := t.period()
+	days := period.Hours() / 24
+	forgotten := t.current.Add(-doraPendingMemory)
+
+	keys := make([]string, 0, len(t.repos))
+	for key := range t.repos {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var records []*DORARecord
+	for _, key := range keys {
+		r := t.repos[key]
+		if a := r.activity; a != nil {
+			record := &DORARecord{
+				SchemaVersion:   SchemaVersion,
+				Repo:            r.name,
+				PeriodStart:     t.current.UTC(),
+				PeriodEnd:       t.current.Add(period).UTC(),
+				MergedChanges:   a.merged,
+				DeployedChanges: len(a.leadTimes),
+				ChangeFailures:  a.failures,
+			}
+
+			switch {
+			case a.deployments > 0:
+				record.Deployments, record.DeploymentSource = a.deployments, DORADeployments
+			case a.releases > 0:
+				record.Deployments, record.DeploymentSource = a.releases, DORAReleases
+			}
+			record.DeploymentsPerDay = float64(record.Deployments) / days
+
+			if len(a.leadTimes) > 0 {
+				sort.Slice(a.leadTimes, func(i, j int) bool { return a.leadTimes[i] < a.leadTimes[j] })
+				record.LeadTimeSeconds = a.leadTimes[len(a.leadTimes)/2].Seconds()
+			}
+			if record.Deployments > 0 {
+				record.ChangeFailureRate = float64(a.failures) / float64(record.Deployments)
+				if record.ChangeFailureRate > 1 {
+					record.ChangeFailureRate = 1
+				}
+			}
+
+			records = append(records, record)
+			r.activity = nil
+		}
+
+		kept := r.pending[:0]
+		for _, opened := range r.pending {
+			if !opened.Before(forgotten) {
+				kept = append(kept, opened)
+			}
+		}
+		r.pending = kept
+		if len(r.pending) == 0 {
+			delete(t.repos, key)
+		}
+	}
+
+	return records
+}
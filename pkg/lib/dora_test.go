@@ -0,0 +1,43 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestDORATracker(t *testing.T) {
+	day := time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)
+	tracker := lib.NewDORATracker([]string{"octocat/hello-world"})
+
+	records := tracker.Track([]*github.Event{
+		testEvent("", "PullRequestEvent", "", "octocat/hello-world", day.Add(2*time.Hour), `{"action":"closed","pull_request":{"title":"Add feature","merged":true,"created_at":"2020-06-09T22:00:00Z"}}`),
+		testEvent("", "PullRequestEvent", "", "octocat/hello-world", day.Add(3*time.Hour), `{"action":"closed","pull_request":{"title":"Revert \"Add feature\"","merged":true,"created_at":"2020-06-10T02:30:00Z"}}`),
+		testEvent("", "PullRequestEvent", "", "octocat/hello-world", day.Add(3*time.Hour), `{"action":"opened","pull_request":{"title":"Other","created_at":"2020-06-10T03:00:00Z"}}`),
+		testEvent("", "ReleaseEvent", "", "octocat/hello-world", day.Add(4*time.Hour), `{"action":"published"}`),
+		testEvent("", "ReleaseEvent", "", "octocat/hello-world", day.Add(8*time.Hour), `{"action":"published"}`),
+	})
+	if len(records) != 0 {
+		t.Fatalf("records emitted before the end of the period: %+v", records)
+	}
+
+	// The first event of the next day ends the period.
+	records = tracker.Track([]*github.Event{testEvent("", "ReleaseEvent", "", "octocat/hello-world", day.Add(25*time.Hour), `{"action":"published"}`)})
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1", len(records))
+	}
+
+	r := records[0]
+	if r.Deployments != 2 || r.DeploymentSource != lib.DORAReleases || r.DeploymentsPerDay != 2 {
+		t.Errorf("got %d deployments from %s, %v per day", r.Deployments, r.DeploymentSource, r.DeploymentsPerDay)
+	}
+	// Lead times of 6h and 1h30.
+	if r.MergedChanges != 2 || r.DeployedChanges != 2 || r.LeadTimeSeconds != (6*time.Hour).Seconds() {
+		t.Errorf("got %d merged, %d deployed changes, lead time %vs", r.MergedChanges, r.DeployedChanges, r.LeadTimeSeconds)
+	}
+	if r.ChangeFailures != 1 || r.ChangeFailureRate != 0.5 {
+		t.Errorf("got %d failures, rate %v", r.ChangeFailures, r.ChangeFailureRate)
+	}
+}
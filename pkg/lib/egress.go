@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var ErrEgressDenied = errors.New("egress denied")
+
+// EgressEndpoint is implemented by the sinks, stores and caches connecting
+// to remote hosts, so that they can be allowed, see EgressAllowlist.
+type EgressEndpoint interface {
+	// Hosts returns the hosts it dials.
+	Hosts() []string
+}
+
+// EgressHosts returns the hosts dialed by v, none unless it's an
+// EgressEndpoint.
+func EgressHosts(v interface{}) []string {
+	if e, ok := v.(EgressEndpoint); ok {
+		return e.Hosts()
+	}
+	return nil
+}
+
+// urlHost returns the host name of a URL, none if it doesn't parse.
+func urlHost(rawurl string) []string {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Hostname() == "" {
+		return nil
+	}
+	return []string{u.Hostname()}
+}
+
+// addrHost returns the host of a host:port address.
+func addrHost(addr string) []string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return []string{host}
+}
+
+// EgressAllowlist lists the hosts the process may connect to, either exact
+// names (or IPs) or suffixes given as *.example.com. Hosts can be allowed
+// while it guards a transport, e.g. as the sinks are opened.
+type EgressAllowlist struct {
+	mu       sync.RWMutex
+	hosts    map[string]bool
+	suffixes []string
+}
+
+func NewEgressAllowlist(hosts ...string) *EgressAllowlist {
+	a := &EgressAllowlist{hosts: make(map[string]bool)}
+	a.Allow(hosts...)
+	return a
+}
+
+// Allow adds hosts to the list.
+func (a *EgressAllowlist) Allow(hosts ...string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			continue
+		}
+		if strings.HasPrefix(host, "*.") {
+			a.suffixes = append(a.suffixes, host[1:])
+		} else {
+			a.hosts[host] = true
+		}
+	}
+}
+
+func (a *EgressAllowlist) Allowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.hosts[host] {
+		return true
+	}
+	for _, suffix := range a.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GuardTransport makes t refuse the requests to hosts which aren't allowed,
+// as a defense in depth against code (e.g. filter plugins) reaching out to
+// arbitrary hosts. Requests are checked on their target, whether they go
+// through an HTTP or SOCKS proxy or not, and direct connections on their
+// address; connections to the proxies of allowed requests are permitted.
+//
+// Guarding http.DefaultTransport covers the clients which don't set their
+// own transport.
+func GuardTransport(t *http.Transport, allow *EgressAllowlist) {
+	var proxies sync.Map
+
+	proxy := t.Proxy
+	t.Proxy = func(req *http.Request) (*url.URL, error) {
+		if !allow.Allowed(req.URL.Hostname()) {
+			return nil, fmt.Errorf("%w: %s", ErrEgressDenied, req.URL.Host)
+		}
+		if proxy == nil {
+			return nil, nil
+		}
+
+		u, err := proxy(req)
+		if u != nil {
+			proxies.Store(strings.ToLower(u.Hostname()), true)
+		}
+		return u, err
+	}
+
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		if _, proxied := proxies.Load(strings.ToLower(host)); !proxied && !allow.Allowed(host) {
+			return nil, fmt.Errorf("%w: %s", ErrEgressDenied, addr)
+		}
+		return dial(ctx, network, addr)
+	}
+}
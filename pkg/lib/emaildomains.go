@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	defaultEmailDomainPeriod     = 24 * time.Hour
+	defaultEmailDomainMinAuthors = 3
+	// Owners of the repositories listed in an EmailDomainRecord, by commits.
+	maxEmailDomainOwners = 10
+)
+
+// Domains of the personal and placeholder addresses, which tell nothing
+// about the employer of the authors.
+var personalEmailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "outlook.com": true, "hotmail.com": true, "live.com": true,
+	"yahoo.com": true, "icloud.com": true, "me.com": true, "mac.com": true, "protonmail.com": true,
+	"proton.me": true, "qq.com": true, "163.com": true, "126.com": true, "foxmail.com": true,
+	"gmx.de": true, "gmx.net": true, "web.de": true, "yandex.ru": true, "mail.ru": true,
+	"users.noreply.github.com": true, "noreply.github.com": true, "localhost": true,
+}
+
+// EmailDomainRecord reports the commits pushed over a period by the authors
+// of an email domain, e.g. a company's. The addresses themselves aren't
+// reported, only how many distinct ones committed.
+type EmailDomainRecord struct {
+	SchemaVersion int       `json:"schema_version"`
+	Domain        string    `json:"domain"`
+	PeriodStart   time.Time `json:"period_start"`
+	PeriodEnd     time.Time `json:"period_end"`
+
+	Commits int `json:"commits"`
+	// Distinct addresses of the commits.
+	Authors int `json:"authors"`
+	Repos   int `json:"repos"`
+	// Owners of the repositories with the most commits, the ecosystems the
+	// domain is active in.
+	Owners []EmailDomainOwner `json:"owners"`
+}
+
+// EmailDomainOwner counts the commits of a domain to the repositories of an
+// account.
+type EmailDomainOwner struct {
+	Owner   string `json:"owner"`
+	Commits int    `json:"commits"`
+}
+
+type emailDomainActivity struct {
+	commits int
+	// Hashes of the addresses, never emitted.
+	authors map[[sha256.Size]byte]bool
+	repos   map[string]bool
+	owners  map[string]*EmailDomainOwner
+}
+
+type emailDomainPayload struct {
+	Commits []struct {
+		Author *struct {
+			Email *string `json:"email"`
+		} `json:"author"`
+		Message  *string `json:"message"`
+		Distinct *bool   `json:"distinct"`
+	} `json:"commits"`
+}
+
+// EmailDomainTracker aggregates the commit email domains of the pushes to a
+// set of repositories (all if empty) in EmailDomainRecords. Periods are
+// aligned on multiples of Period (a day if zero) of the events' creation
+// time and emitted once an event of a later period is seen, like
+// DORATracker's.
+//
+// Personal and placeholder addresses (gmail.com, noreply.github.com...) are
+// ignored, and the domains with fewer than MinAuthors (3 if zero) distinct
+// authors in a period aren't reported, so that a record can't single out a
+// person. Commits pushed again (distinct false) are counted once, the
+// co-authors of their Co-authored-by trailers as authors.
+type EmailDomainTracker struct {
+	Period     time.Duration
+	MinAuthors int
+
+	tracked map[string]bool
+	domains map[string]*emailDomainActivity
+	current time.Time
+}
+
+func NewEmailDomainTracker(repos []string) *EmailDomainTracker {
+	t := &EmailDomainTracker{tracked: make(map[string]bool, len(repos)), domains: make(map[string]*emailDomainActivity)}
+	for _, repo := range repos {
+		t.tracked[strings.ToLower(repo)] = true
+	}
+
+	return t
+}
+
+func (t *EmailDomainTracker) period() time.Duration {
+	if t.Period <= 0 {
+		return defaultEmailDomainPeriod
+	}
+	return t.Period
+}
+
+func (t *EmailDomainTracker) minAuthors() int {
+	if t.MinAuthors <= 0 {
+		return defaultEmailDomainMinAuthors
+	}
+	return t.MinAuthors
+}
+
+func (t *EmailDomainTracker) tracks(repo string) bool {
+	return len(t.tracked) == 0 || t.tracked[strings.ToLower(repo)]
+}
+
+// emailDomain returns the domain of a corporate address, empty for the
+// others.
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	domain := strings.TrimSuffix(strings.ToLower(email[i+1:]), ".")
+	if !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".local") || personalEmailDomains[domain] {
+		return ""
+	}
+	return domain
+}
+
+// Track accounts the batch and returns the records of the periods it ended.
+func (t *EmailDomainTracker) Track(events []*github.Event) []*EmailDomainRecord {
+	var records []*EmailDomainRecord
+
+	for _, ev := range events {
+		name := ev.GetRepo().GetName()
+		if ev.GetType() != "PushEvent" || name == "" || !t.tracks(name) || ev.RawPayload == nil {
+			continue
+		}
+
+		var payload emailDomainPayload
+		if err := json.Unmarshal(*ev.RawPayload, &payload); err != nil {
+			continue
+		}
+
+		if start := ev.GetCreatedAt().Truncate(t.period()); t.current.IsZero() {
+			t.current = start
+		} else if start.After(t.current) {
+			records = append(records, t.flush()...)
+			t.current = start
+		}
+
+		repo := strings.ToLower(name)
+		owner := name
+		if i := strings.Index(name, "/"); i >= 0 {
+			owner = name[:i]
+		}
+
+		for _, commit := range payload.Commits {
+			if commit.Author == nil || (commit.Distinct != nil && !*commit.Distinct) {
+				continue
+			}
+
+			emails := []string{stringOrEmpty(commit.Author.Email)}
+			for _, coAuthor := range CoAuthors(stringOrEmpty(commit.Message)) {
+				emails = append(emails, coAuthor.Email)
+			}
+			// A commit counts once for each domain of its authors.
+			counted := make(map[string]bool, len(emails))
+			for _, email := range emails {
+				email = strings.ToLower(email)
+				domain := emailDomain(email)
+				if domain == "" {
+					continue
+				}
+
+				a, ok := t.domains[domain]
+				if !ok {
+					a = &emailDomainActivity{
+						authors: make(map[[sha256.Size]byte]bool),
+						repos:   make(map[string]bool),
+						owners:  make(map[string]*EmailDomainOwner),
+					}
+					t.domains[domain] = a
+				}
+				a.authors[sha256.Sum256([]byte(email))] = true
+				if counted[domain] {
+					continue
+				}
+				counted[domain] = true
+
+				a.commits++
+				a.repos[repo] = true
+				o, ok := a.owners[strings.ToLower(owner)]
+				if !ok {
+					o = &EmailDomainOwner{Owner: owner}
+					a.owners[strings.ToLower(owner)] = o
+				}
+				o.Commits++
+			}
+		}
+	}
+
+	return records
+}
+
+// flush returns the records of the current period, sorted by domain, and
+// forgets its activity.
+func (t *EmailDomainTracker) flush() []*EmailDomainRecord {
+	domains := make([]string, 0, len(t.domains))
+	for domain := range t.domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	var records []*EmailDomainRecord
+	for _, domain := range domains {
+		a := t.domains[domain]
+		if len(a.authors) < t.minAuthors() {
+			continue
+		}
+
+		owners := make([]EmailDomainOwner, 0, len(a.owners))
+		for _, o := range a.owners {
+			owners = append(owners, *o)
+		}
+		sort.Slice(owners, func(i, j int) bool {
+			if owners[i].Commits != owners[j].Commits {
+				return owners[i].Commits > owners[j].Commits
+			}
+			return owners[i].Owner < owners[j].Owner
+		})
+		if len(owners) > maxEmailDomainOwners {
+			owners = owners[:maxEmailDomainOwners]
+		}
+
+		records = append(records, &EmailDomainRecord{
+			SchemaVersion: SchemaVersion,
+			Domain:        domain,
+			PeriodStart:   t.current.UTC(),
+			PeriodEnd:     t.current.Add(t.period()).UTC(),
+			Commits:       a.commits,
+			Authors:       len(a.authors),
+			Repos:         len(a.repos),
+			Owners:        owners,
+		})
+	}
+
+	t.domains = make(map[string]*emailDomainActivity)
+	return records
+}
@@ -0,0 +1,82 @@
+package lib_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// authoredPayload returns the payload of a push of a distinct commit by each
+// email.
+func authoredPayload(emails ...string) string {
+	commits := make([]string, len(emails))
+	for i, email := range emails {
+		commits[i] = fmt.Sprintf(`{"author":{"email":%q},"distinct":true}`, email)
+	}
+	return `{"commits":[` + strings.Join(commits, ",") + `]}`
+}
+
+func TestEmailDomainTracker(t *testing.T) {
+	day := time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)
+	tracker := lib.NewEmailDomainTracker(nil)
+
+	records := tracker.Track([]*github.Event{
+		testEvent("", "PushEvent", "", "kubernetes/kubernetes", day.Add(time.Hour), authoredPayload("a@corp.example", "B@Corp.Example", "a@corp.example")),
+		testEvent("", "PushEvent", "", "kubernetes/website", day.Add(2*time.Hour), authoredPayload("c@corp.example", "me@gmail.com", "x@users.noreply.github.com")),
+		testEvent("", "PushEvent", "", "golang/go", day.Add(3*time.Hour), authoredPayload("a@corp.example")),
+		// Too few authors to be reported.
+		testEvent("", "PushEvent", "", "golang/go", day.Add(4*time.Hour), authoredPayload("solo@small.example", "solo@small.example", "solo@small.example")),
+	})
+	if len(records) != 0 {
+		t.Fatalf("records emitted before the end of the period: %+v", records)
+	}
+
+	records = tracker.Track([]*github.Event{testEvent("", "PushEvent", "", "golang/go", day.Add(25*time.Hour), authoredPayload("d@corp.example"))})
+	if len(records) != 1 {
+		t.Fatalf("got %d records, want 1: %+v", len(records), records)
+	}
+	r := records[0]
+	if r.Domain != "corp.example" || r.Commits != 5 || r.Authors != 3 || r.Repos != 3 {
+		t.Errorf("got %d commits of %d authors to %d repositories for %s", r.Commits, r.Authors, r.Repos, r.Domain)
+	}
+	if want := []lib.EmailDomainOwner{{Owner: "kubernetes", Commits: 4}, {Owner: "golang", Commits: 1}}; !reflect.DeepEqual(r.Owners, want) {
+		t.Errorf("got owners %+v, want %+v", r.Owners, want)
+	}
+	if !r.PeriodStart.Equal(day) || !r.PeriodEnd.Equal(day.Add(24*time.Hour)) {
+		t.Errorf("got the period %v to %v", r.PeriodStart, r.PeriodEnd)
+	}
+
+	// The addresses aren't part of the records.
+	b, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "@") {
+		t.Errorf("addresses leaked in %s", b)
+	}
+}
+
+func TestEmailDomainTrackerCoAuthors(t *testing.T) {
+	day := time.Date(2020, 6, 10, 0, 0, 0, 0, time.UTC)
+	tracker := lib.NewEmailDomainTracker(nil)
+
+	raw := json.RawMessage(`{"commits":[{"author":{"email":"a@corp.example"},"distinct":true,` +
+		`"message":"Pairing\n\nCo-authored-by: B <b@corp.example>\nCo-authored-by: C <c@corp.example>"}]}`)
+	tracker.Track([]*github.Event{{
+		Type:       github.String("PushEvent"),
+		Repo:       &github.Repository{Name: github.String("golang/go")},
+		CreatedAt:  &day,
+		RawPayload: &raw,
+	}})
+
+	records := tracker.Track([]*github.Event{testEvent("", "PushEvent", "", "golang/go", day.Add(25*time.Hour), authoredPayload("d@corp.example"))})
+	if len(records) != 1 || records[0].Commits != 1 || records[0].Authors != 3 {
+		t.Fatalf("got records %+v, want a commit of 3 authors", records)
+	}
+}
@@ -0,0 +1,307 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	defaultEnrichBudget  = 1000
+	defaultEnrichWorkers = 4
+	defaultEnrichQueue   = 256
+)
+
+// EnrichPriority orders the requests of an EnrichmentScheduler.
+type EnrichPriority int
+
+const (
+	// Background work, e.g. repository snapshots, granted while at least
+	// half of the budget is left.
+	EnrichLow EnrichPriority = iota
+	// Enrichment of the published events, e.g. actor types, granted while
+	// at least a tenth of the budget is left.
+	EnrichNormal
+	// Granted until the budget is exhausted.
+	EnrichHigh
+
+	enrichPriorities
+)
+
+// Fraction of the budget each priority may spend.
+var enrichShares = [enrichPriorities]float64{0.5, 0.9, 1}
+
+func (p EnrichPriority) String() string {
+	switch p {
+	case EnrichLow:
+		return "low"
+	case EnrichNormal:
+		return "normal"
+	case EnrichHigh:
+		return "high"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+var (
+	// ErrEnrichBudget is returned by EnrichmentScheduler.Do when the budget
+	// left doesn't cover the priority of the request, which is rejected.
+	ErrEnrichBudget = errors.New("enrichment budget exhausted")
+	// ErrEnrichQueueFull is returned by EnrichmentScheduler.Do when too many
+	// requests of the priority are pending.
+	ErrEnrichQueueFull = errors.New("enrichment queue full")
+)
+
+// EnrichmentConfig configures an EnrichmentScheduler, the zero value selects
+// the defaults.
+type EnrichmentConfig struct {
+	// API requests per hour shared by the enrichers (1000 by default).
+	Budget int
+	// Requests run concurrently (4 by default).
+	Workers int
+	// Requests pending per priority before they are rejected (256 by
+	// default).
+	QueueSize int
+	// Clock timing the budget's hourly window, the system clock if nil.
+	Clock Clock
+}
+
+// EnrichmentStats are the requests of an EnrichmentScheduler by priority.
+type EnrichmentStats struct {
+	Granted  [enrichPriorities]uint64
+	Rejected [enrichPriorities]uint64
+	// Requests left in the current hourly window.
+	Remaining int
+}
+
+type enrichRequest struct {
+	ctx  context.Context
+	fn   func(ctx context.Context) error
+	done chan error
+}
+
+// EnrichmentScheduler is the central scheduler of the enrichers calling the
+// API (actor types, repository snapshots, commit details), so that they
+// share a single hourly budget preserving the rate limit for polling. The
+// requests are run by a pool of workers, highest priority first, and the
+// lower priorities only get a share of the budget (see EnrichPriority), so
+// that background work can't starve the enrichment of the published events.
+//
+// Requests are rejected rather than delayed once the budget is exhausted:
+// enrichers degrade gracefully, publishing the events unenriched.
+type EnrichmentScheduler struct {
+	budget int
+	clock  Clock
+
+	workers int
+	queues  [enrichPriorities]chan enrichRequest
+	// One token per queued request, whatever its priority.
+	pending chan struct{}
+
+	mu       sync.Mutex
+	window   time.Time
+	spent    int
+	granted  [enrichPriorities]uint64
+	rejected [enrichPriorities]uint64
+}
+
+// NewEnrichmentScheduler returns a scheduler, whose requests run once Serve
+// is called.
+func NewEnrichmentScheduler(conf EnrichmentConfig) *EnrichmentScheduler {
+	queueSize := orDefault(conf.QueueSize, defaultEnrichQueue)
+
+	s := &EnrichmentScheduler{
+		budget:  orDefault(conf.Budget, defaultEnrichBudget),
+		clock:   clockOrSystem(conf.Clock),
+		workers: orDefault(conf.Workers, defaultEnrichWorkers),
+		pending: make(chan struct{}, int(enrichPriorities)*queueSize),
+	}
+	for i := range s.queues {
+		s.queues[i] = make(chan enrichRequest, queueSize)
+	}
+	return s
+}
+
+// spend reports whether the request fits in the share of the budget of its
+// priority, and accounts it.
+func (s *EnrichmentScheduler) spend(p EnrichPriority) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now := s.clock.Now(); now.Sub(s.window) >= time.Hour {
+		s.window, s.spent = now, 0
+	}
+	if float64(s.spent) >= enrichShares[p]*float64(s.budget) {
+		s.rejected[p]++
+		return false
+	}
+
+	s.spent++
+	s.granted[p]++
+	return true
+}
+
+// refund returns the budget of a request which didn't run.
+func (s *EnrichmentScheduler) refund(p EnrichPriority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.spent > 0 {
+		s.spent--
+	}
+	s.granted[p]--
+	s.rejected[p]++
+}
+
+// exhaust spends the budget until the rate limit resets, the API rejecting
+// the requests until then anyway.
+func (s *EnrichmentScheduler) exhaust(reset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window, s.spent = reset.Add(-time.Hour), s.budget
+}
+
+// Do runs fn, which makes a single API request, on a worker once the
+// requests of higher priority pending are, and returns its error. It fails
+// with ErrEnrichBudget or ErrEnrichQueueFull without running fn if the
+// request doesn't fit, and with the context's error if ctx is done first.
+// A rate limit error returned by fn exhausts the budget until the reset.
+func (s *EnrichmentScheduler) Do(ctx context.Context, p EnrichPriority, fn func(ctx context.Context) error) error {
+	if p < 0 || p >= enrichPriorities {
+		return fmt.Errorf("invalid enrichment %s", p)
+	}
+	if !s.spend(p) {
+		return ErrEnrichBudget
+	}
+
+	req := enrichRequest{ctx: ctx, fn: fn, done: make(chan error, 1)}
+	select {
+	case s.queues[p] <- req:
+		s.pending <- struct{}{}
+	default:
+		s.refund(p)
+		return ErrEnrichQueueFull
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Enrich fans the enrichment of a batch out to the workers, a request per
+// event, and returns the number of events enriched once the requests all ran
+// or were rejected: the events rejected are left unenriched. The errors of
+// fn are reported to report if not nil, e.g. EventFeed.ReportError.
+func (s *EnrichmentScheduler) Enrich(ctx context.Context, p EnrichPriority, events []*github.Event,
+	fn func(ctx context.Context, ev *github.Event) error, report func(op, eventID string, err error)) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	enriched := 0
+	for _, ev := range events {
+		wg.Add(1)
+		go func(ev *github.Event) {
+			defer wg.Done()
+			err := s.Do(ctx, p, func(ctx context.Context) error { return fn(ctx, ev) })
+			switch {
+			case err == nil:
+				mu.Lock()
+				enriched++
+				mu.Unlock()
+			case errors.Is(err, ErrEnrichBudget), errors.Is(err, ErrEnrichQueueFull), ctx.Err() != nil:
+			case report != nil:
+				report(OpEnrich, ev.GetID(), err)
+			}
+		}(ev)
+	}
+	wg.Wait()
+	return enriched
+}
+
+// next dequeues the pending request of highest priority. The tokens of
+// pending are sent once their request is queued, so holding one guarantees
+// that a request is.
+func (s *EnrichmentScheduler) next() (enrichRequest, EnrichPriority) {
+	for p := enrichPriorities - 1; p > EnrichLow; p-- {
+		select {
+		case req := <-s.queues[p]:
+			return req, p
+		default:
+		}
+	}
+	return <-s.queues[EnrichLow], EnrichLow
+}
+
+// Serve runs the requests until ctx is done.
+func (s *EnrichmentScheduler) Serve(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-s.pending:
+				case <-ctx.Done():
+					return
+				}
+
+				req, p := s.next()
+				if err := req.ctx.Err(); err != nil {
+					s.refund(p)
+					req.done <- err
+					continue
+				}
+
+				err := req.fn(req.ctx)
+				var rate *github.RateLimitError
+				if errors.As(err, &rate) {
+					s.exhaust(rateLimitReset(rate.Rate.Reset.Time, rate.Response, s.clock.Now()))
+				}
+				req.done <- err
+			}
+		}()
+	}
+
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Stats returns the requests granted and rejected since the creation of the
+// scheduler, and the budget left.
+func (s *EnrichmentScheduler) Stats() EnrichmentStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining := s.budget - s.spent
+	if s.clock.Now().Sub(s.window) >= time.Hour {
+		remaining = s.budget
+	}
+	return EnrichmentStats{Granted: s.granted, Rejected: s.rejected, Remaining: remaining}
+}
+
+// WritePrometheus writes the statistics of the scheduler in the Prometheus
+// text format:
+//
+//	github_feed_enrich_requests_total{priority,outcome}   granted or rejected
+//	github_feed_enrich_budget_remaining                   requests left this hour
+func (s *EnrichmentScheduler) WritePrometheus(w io.Writer) {
+	stats := s.Stats()
+
+	fmt.Fprintf(w, "# HELP %s_enrich_requests_total API requests of the enrichers by priority, granted or rejected.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_enrich_requests_total counter\n", statsNamespace)
+	for p := EnrichLow; p < enrichPriorities; p++ {
+		fmt.Fprintf(w, "%s_enrich_requests_total{priority=\"%s\",outcome=\"granted\"} %d\n", statsNamespace, p, stats.Granted[p])
+		fmt.Fprintf(w, "%s_enrich_requests_total{priority=\"%s\",outcome=\"rejected\"} %d\n", statsNamespace, p, stats.Rejected[p])
+	}
+
+	fmt.Fprintf(w, "# HELP %s_enrich_budget_remaining API requests left to the enrichers in the current hour.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_enrich_budget_remaining gauge\n", statsNamespace)
+	fmt.Fprintf(w, "%s_enrich_budget_remaining %d\n", statsNamespace, stats.Remaining)
+}
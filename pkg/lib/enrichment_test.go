@@ -0,0 +1,127 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestEnrichmentSchedulerBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	s := lib.NewEnrichmentScheduler(lib.EnrichmentConfig{Budget: 10, Clock: clock})
+	go s.Serve(ctx)
+
+	noop := func(ctx context.Context) error { return nil }
+	granted := func(p lib.EnrichPriority) int {
+		n := 0
+		for s.Do(ctx, p, noop) == nil {
+			n++
+		}
+		return n
+	}
+
+	// Half of the budget for low, up to 90% for normal, the rest for high.
+	if n := granted(lib.EnrichLow); n != 5 {
+		t.Errorf("got %d low requests, want 5", n)
+	}
+	if n := granted(lib.EnrichNormal); n != 4 {
+		t.Errorf("got %d normal requests, want 4", n)
+	}
+	if n := granted(lib.EnrichHigh); n != 1 {
+		t.Errorf("got %d high requests, want 1", n)
+	}
+	if err := s.Do(ctx, lib.EnrichHigh, noop); !errors.Is(err, lib.ErrEnrichBudget) {
+		t.Errorf("got %v once exhausted, want ErrEnrichBudget", err)
+	}
+
+	stats := s.Stats()
+	if stats.Remaining != 0 || stats.Granted[lib.EnrichLow] != 5 || stats.Rejected[lib.EnrichHigh] != 2 {
+		t.Errorf("got stats %+v", stats)
+	}
+
+	clock.Advance(time.Hour)
+	if err := s.Do(ctx, lib.EnrichLow, noop); err != nil {
+		t.Errorf("got %v once replenished, want nil", err)
+	}
+}
+
+func TestEnrichmentSchedulerPriority(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := lib.NewEnrichmentScheduler(lib.EnrichmentConfig{Workers: 1})
+	go s.Serve(ctx)
+
+	// Hold the only worker while requests of both priorities queue up.
+	running, release := make(chan struct{}), make(chan struct{})
+	go s.Do(ctx, lib.EnrichNormal, func(ctx context.Context) error {
+		close(running)
+		<-release
+		return nil
+	})
+	<-running
+
+	var mu sync.Mutex
+	var order []lib.EnrichPriority
+	var wg sync.WaitGroup
+	for _, p := range []lib.EnrichPriority{lib.EnrichLow, lib.EnrichHigh} {
+		wg.Add(1)
+		go func(p lib.EnrichPriority) {
+			defer wg.Done()
+			s.Do(ctx, p, func(ctx context.Context) error {
+				mu.Lock()
+				defer mu.Unlock()
+				order = append(order, p)
+				return nil
+			})
+		}(p)
+		// Queued in order.
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(release)
+	wg.Wait()
+	if len(order) != 2 || order[0] != lib.EnrichHigh {
+		t.Errorf("got requests run in order %v, want high first", order)
+	}
+}
+
+func TestEnrichmentSchedulerEnrich(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := lib.NewEnrichmentScheduler(lib.EnrichmentConfig{Budget: 4})
+	go s.Serve(ctx)
+
+	var events []*github.Event
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		events = append(events, &github.Event{ID: github.String(id)})
+	}
+
+	var calls int32
+	var reported []string
+	n := s.Enrich(ctx, lib.EnrichNormal, events, func(ctx context.Context, ev *github.Event) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("not found")
+		}
+		return nil
+	}, func(op, eventID string, err error) { reported = append(reported, eventID) })
+
+	// The budget covers 4 requests, an event is rejected and left
+	// unenriched, another failed.
+	if n != 3 {
+		t.Errorf("got %d events enriched, want 3", n)
+	}
+	if len(reported) != 1 {
+		t.Errorf("got errors reported for %v, want one", reported)
+	}
+}
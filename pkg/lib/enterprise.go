@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Wait after a rate limit error without X-RateLimit-Reset, which GitHub
+// Enterprise Server omits when its rate limits are configured per instance.
+const defaultRateLimitWait = time.Minute
+
+// enterpriseURL appends the API path of GitHub Enterprise Server (api/v3/,
+// api/uploads/) to a base URL lacking it, like github.NewEnterpriseClient.
+func enterpriseURL(raw, path string) string {
+	raw = strings.TrimSuffix(raw, "/") + "/"
+	if strings.HasSuffix(raw, "/"+path) {
+		return raw
+	}
+	return raw + path
+}
+
+// LoadCACerts returns a TLS configuration trusting the PEM certificates of
+// the files on top of the system's, e.g. the internal CA of a GitHub
+// Enterprise Server instance, see Config.TLSConfig.
+func LoadCACerts(paths ...string) (*tls.Config, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+
+	for _, path := range paths {
+		pem, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificate", path)
+		}
+	}
+
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+// apiTransport returns the transport of the API requests, http.DefaultTransport
+// (as configured by ConfigureDialer and GuardTransport) unless the
+// configuration sets its own TLS configuration or proxy.
+func apiTransport(conf *Config) (http.RoundTripper, error) {
+	if conf.TLSConfig == nil && conf.ProxyURL == "" {
+		return http.DefaultTransport, nil
+	}
+
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("http.DefaultTransport is a %T, can't set the TLS configuration or proxy", http.DefaultTransport)
+	}
+	t := base.Clone()
+	if conf.TLSConfig != nil {
+		t.TLSClientConfig = conf.TLSConfig.Clone()
+	}
+	if conf.ProxyURL != "" {
+		proxy, err := url.Parse(conf.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		RegisterSecret(proxyPassword(proxy))
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	return t, nil
+}
+
+func proxyPassword(u *url.URL) string {
+	password, _ := u.User.Password()
+	return password
+}
+
+// rateLimitReset returns when an exhausted rate limit resets: its
+// X-RateLimit-Reset, or after Retry-After or a minute for the GitHub
+// Enterprise Server instances which don't send it.
+func rateLimitReset(reset time.Time, rep *http.Response, now time.Time) time.Time {
+	if reset.Unix() > 0 {
+		return reset
+	}
+	if rep != nil {
+		if seconds, err := strconv.Atoi(rep.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			return now.Add(time.Duration(seconds) * time.Second)
+		}
+	}
+	return now.Add(defaultRateLimitWait)
+}
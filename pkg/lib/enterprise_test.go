@@ -0,0 +1,116 @@
+package lib_test
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestEnterpriseFeed(t *testing.T) {
+	var polls int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/events" {
+			t.Errorf("got path %s, want /api/v3/events", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&polls, 1) == 1 {
+			// Rate limited without X-RateLimit-Reset.
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"API rate limit exceeded"}`))
+			return
+		}
+		w.Write([]byte(`[{"id":"1","type":"PushEvent","actor":{"login":"a"}}]`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "enterprise")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(ca, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := lib.LoadCACerts(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+		BaseURL:   server.URL,
+		UploadURL: server.URL,
+		TLSConfig: tlsConfig,
+		Clock:     clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := feed.Client().UploadURL.String(), server.URL+"/api/uploads/"; got != want {
+		t.Errorf("got upload URL %s, want %s", got, want)
+	}
+
+	throttled := make(chan time.Duration, 1)
+	feed.OnThrottle(func(source lib.Source, wait time.Duration) { throttled <- wait })
+	go feed.Serve()
+
+	select {
+	case wait := <-throttled:
+		if wait != 30*time.Second {
+			t.Errorf("got a throttle of %v, want the Retry-After", wait)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the throttle")
+	}
+
+	waitForTimer(t, clock)
+	clock.Advance(30 * time.Second)
+	for {
+		select {
+		case batch := <-events:
+			if len(batch) == 0 {
+				// Published for the throttled poll.
+				continue
+			}
+			if len(batch) != 1 || batch[0].GetID() != "1" {
+				t.Errorf("got %v", batch)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the events")
+		}
+		break
+	}
+}
+
+func TestEnterpriseConfigValidate(t *testing.T) {
+	for conf, valid := range map[*lib.Config]bool{
+		{BaseURL: "https://ghe.example.com", UploadURL: "https://ghe.example.com"}: true,
+		{UploadURL: "https://ghe.example.com"}:                                     false,
+		{ProxyURL: "socks5://proxy:1080"}:                                          true,
+		{ProxyURL: "ftp://proxy"}:                                                  false,
+	} {
+		if err := conf.Validate(); (err == nil) != valid {
+			t.Errorf("%+v: got %v", conf, err)
+		}
+	}
+
+	if _, err := lib.LoadCACerts(os.DevNull); err == nil {
+		t.Error("expected an error loading a file without certificate")
+	}
+}
@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultErrorsCapacity = 64
+
+// Operations in which non-fatal errors are reported.
+const (
+	OpPoll      = "poll"
+	OpParse     = "parse"
+	OpEnrich    = "enrich"
+	OpSink      = "sink"
+	OpDiscover  = "discover"
+	OpTransform = "transform"
+	OpSpill     = "spill"
+)
+
+// FeedError is a non-fatal problem encountered while processing the feed,
+// published on EventFeed.Errors. The feed keeps running after reporting it.
+type FeedError struct {
+	Op      string
+	EventID string
+	Time    time.Time
+	Err     error
+}
+
+func (e *FeedError) Error() string {
+	if e.EventID != "" {
+		return Redact(fmt.Sprintf("%s: event %s: %v", e.Op, e.EventID, e.Err))
+	}
+	return Redact(fmt.Sprintf("%s: %v", e.Op, e.Err))
+}
+
+func (e *FeedError) Unwrap() error {
+	return e.Err
+}
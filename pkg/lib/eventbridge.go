@@ -0,0 +1,241 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	// Maximum entries of a PutEvents request.
+	eventBridgeMaxEntries = 10
+	// Maximum size of the entries of a PutEvents request, see
+	// eventBridgeEntry.size.
+	eventBridgeMaxPayload = 256 * 1024
+)
+
+// EventBridgeSink puts the events on an EventBridge bus with PutEvents, ten
+// entries per request. The entries map the CloudEvents attributes of the
+// events (see CloudEvent): their type (com.github.PushEvent) is the detail
+// type, their repository URL the source unless Source is set, their
+// creation the time, and their record the detail, so that the rules match
+// them as they would CloudEvents. Requests are signed with AWS Signature
+// Version 4.
+//
+// The records must be JSON, EventBridge details are JSON objects.
+type EventBridgeSink struct {
+	bus        string
+	region     string
+	endpoint   string
+	creds      AWSCredentials
+	serializer Serializer
+	client     *http.Client
+	// Source of every entry, instead of the repository URL of its event.
+	Source string
+}
+
+func NewEventBridgeSink(bus, region, endpoint string, creds AWSCredentials, s Serializer) (*EventBridgeSink, error) {
+	s = serializerOrDefault(s)
+	if bus == "" || region == "" {
+		return nil, fmt.Errorf("eventbridge sink requires a bus and a region")
+	}
+	if s.Binary() {
+		return nil, fmt.Errorf("eventbridge sink requires JSON records, not %s", s.ContentType())
+	}
+
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://events.%s.amazonaws.com", region)
+	}
+
+	return &EventBridgeSink{
+		bus:        bus,
+		region:     region,
+		endpoint:   strings.TrimSuffix(endpoint, "/") + "/",
+		creds:      creds,
+		serializer: s,
+		client:     &http.Client{Timeout: defaultOutputTimeout},
+	}, nil
+}
+
+type eventBridgeEntry struct {
+	EventBusName string   `json:"EventBusName"`
+	Source       string   `json:"Source"`
+	DetailType   string   `json:"DetailType"`
+	Detail       string   `json:"Detail"`
+	Time         *int64   `json:"Time,omitempty"`
+	Resources    []string `json:"Resources,omitempty"`
+}
+
+// size is the size EventBridge accounts for the entry, see
+// https://docs.aws.amazon.com/eventbridge/latest/userguide/eb-putevent-size.html.
+func (e *eventBridgeEntry) size() int {
+	size := 14 + len(e.Source) + len(e.DetailType) + len(e.Detail)
+	for _, r := range e.Resources {
+		size += len(r)
+	}
+	return size
+}
+
+type eventBridgeResponse struct {
+	FailedEntryCount int `json:"FailedEntryCount"`
+	Entries          []struct {
+		EventID      string `json:"EventId"`
+		ErrorCode    string `json:"ErrorCode"`
+		ErrorMessage string `json:"ErrorMessage"`
+	} `json:"Entries"`
+}
+
+func (s *EventBridgeSink) entry(ev *github.Event) (*eventBridgeEntry, error) {
+	ce, err := NewCloudEvent(ev, s.serializer)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &eventBridgeEntry{
+		EventBusName: s.bus,
+		Source:       ce.Source,
+		DetailType:   ce.Type,
+		Detail:       string(ce.Data),
+	}
+	if s.Source != "" {
+		e.Source = s.Source
+	}
+	if ce.Time != nil {
+		t := ce.Time.Unix()
+		e.Time = &t
+	}
+	if ev.GetRepo().GetName() != "" {
+		e.Resources = []string{ce.Source}
+	}
+	return e, nil
+}
+
+func (s *EventBridgeSink) entries(events []*github.Event) ([]*eventBridgeEntry, error) {
+	entries := make([]*eventBridgeEntry, len(events))
+	for i, ev := range events {
+		e, err := s.entry(ev)
+		if err != nil {
+			return nil, err
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+// PayloadSize returns the size of the largest PutEvents request of the
+// batch, as accounted by EventBridge.
+func (s *EventBridgeSink) PayloadSize(events []*github.Event) (int, error) {
+	entries, err := s.entries(events)
+	if err != nil {
+		return 0, err
+	}
+
+	largest := 0
+	for len(entries) > 0 {
+		n := len(entries)
+		if n > eventBridgeMaxEntries {
+			n = eventBridgeMaxEntries
+		}
+		size := 0
+		for _, e := range entries[:n] {
+			size += e.size()
+		}
+		if size > largest {
+			largest = size
+		}
+		entries = entries[n:]
+	}
+	return largest, nil
+}
+
+func (s *EventBridgeSink) Write(ctx context.Context, events []*github.Event) error {
+	entries, err := s.entries(events)
+	if err != nil {
+		return err
+	}
+
+	for len(entries) > 0 {
+		n := len(entries)
+		if n > eventBridgeMaxEntries {
+			n = eventBridgeMaxEntries
+		}
+		if err := s.putEvents(ctx, entries[:n]); err != nil {
+			return err
+		}
+		entries = entries[n:]
+	}
+	return nil
+}
+
+// putEvents sends a PutEvents request, failing if any of its entries was
+// rejected: the batch is then written again, the entries put already
+// included.
+func (s *EventBridgeSink) putEvents(ctx context.Context, entries []*eventBridgeEntry) error {
+	body, err := json.Marshal(struct {
+		Entries []*eventBridgeEntry `json:"Entries"`
+	}{entries})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSEvents.PutEvents")
+	signV4(req, body, s.creds, s.region, "events", time.Now())
+	Identify(req)
+
+	rep, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	payload, err := ioutil.ReadAll(rep.Body)
+	if err != nil {
+		return err
+	}
+	if rep.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: PutEvents: %s: %s", s.Name(), rep.Status, bytes.TrimSpace(payload))
+	}
+
+	var put eventBridgeResponse
+	if err := json.Unmarshal(payload, &put); err != nil {
+		return fmt.Errorf("%s: PutEvents: invalid response: %w", s.Name(), err)
+	}
+	if put.FailedEntryCount > 0 {
+		var first string
+		for _, e := range put.Entries {
+			if e.ErrorCode != "" {
+				first = e.ErrorCode + ": " + e.ErrorMessage
+				break
+			}
+		}
+		return fmt.Errorf("%s: rejected %d of %d entries: %s", s.Name(), put.FailedEntryCount, len(entries), first)
+	}
+
+	return nil
+}
+
+func (s *EventBridgeSink) Name() string {
+	return fmt.Sprintf("eventbridge://%s?region=%s", s.bus, s.region)
+}
+
+// Hosts returns the host of the endpoint, events.<region>.amazonaws.com by
+// default.
+func (s *EventBridgeSink) Hosts() []string {
+	return urlHost(s.endpoint)
+}
+
+func (s *EventBridgeSink) Close() error {
+	return nil
+}
@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// EventFilter selects the events a feed publishes, see Config.Filter. Every
+// criterion set must match, the zero EventFilter matches every event.
+//
+// Actors, repositories and organizations are matched against glob patterns,
+// case-insensitive, where * matches any sequence of characters and ? a
+// single one, the other characters literally: *[bot] matches the logins of
+// the GitHub apps, kubernetes/* the repositories of kubernetes.
+type EventFilter struct {
+	// Event types published, e.g. PushEvent, all if empty.
+	Types []string
+	// Patterns of the actor logins published, all if empty, and of those
+	// dropped.
+	Actors        []string
+	ExcludeActors []string
+	// Patterns of the repositories (owner/name) published and dropped.
+	Repos        []string
+	ExcludeRepos []string
+	// Patterns of the organizations published and dropped. Events outside
+	// of an organization have an empty one.
+	Orgs        []string
+	ExcludeOrgs []string
+	// Filter expression over the events and their payload, see ParseFilter,
+	// e.g. "action=opened label=bug".
+	Expression string
+}
+
+// IsZero tells whether the filter matches every event.
+func (f EventFilter) IsZero() bool {
+	return len(f.Types) == 0 && len(f.Actors) == 0 && len(f.ExcludeActors) == 0 &&
+		len(f.Repos) == 0 && len(f.ExcludeRepos) == 0 && len(f.Orgs) == 0 &&
+		len(f.ExcludeOrgs) == 0 && f.Expression == ""
+}
+
+// globPattern compiles a glob pattern, see EventFilter.
+func globPattern(glob string) (*regexp.Regexp, error) {
+	if glob == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// globs matches a value against a list of glob patterns.
+type globs []*regexp.Regexp
+
+func compileGlobs(name string, patterns []string) (globs, error) {
+	g := make(globs, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := globPattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern '%s': %v", name, p, err)
+		}
+		g = append(g, re)
+	}
+	return g, nil
+}
+
+func (g globs) match(s string) bool {
+	for _, re := range g {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compile returns the predicate of the filter, or an error if a pattern or
+// the expression is invalid.
+func (f EventFilter) Compile() (func(*github.Event) bool, error) {
+	types := make(map[string]bool, len(f.Types))
+	for _, t := range f.Types {
+		types[strings.ToLower(t)] = true
+	}
+
+	var actors, excludeActors, repos, excludeRepos, orgs, excludeOrgs globs
+	for _, l := range []struct {
+		name     string
+		patterns []string
+		globs    *globs
+	}{
+		{"actor", f.Actors, &actors},
+		{"actor", f.ExcludeActors, &excludeActors},
+		{"repository", f.Repos, &repos},
+		{"repository", f.ExcludeRepos, &excludeRepos},
+		{"organization", f.Orgs, &orgs},
+		{"organization", f.ExcludeOrgs, &excludeOrgs},
+	} {
+		var err error
+		if *l.globs, err = compileGlobs(l.name, l.patterns); err != nil {
+			return nil, err
+		}
+	}
+
+	expr, err := ParseFilter(f.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	// included tells whether the value is selected by the allow and deny
+	// lists.
+	included := func(value string, allow, deny globs) bool {
+		return (len(allow) == 0 || allow.match(value)) && !deny.match(value)
+	}
+
+	return func(ev *github.Event) bool {
+		return (len(types) == 0 || types[strings.ToLower(ev.GetType())]) &&
+			included(ev.GetActor().GetLogin(), actors, excludeActors) &&
+			included(ev.GetRepo().GetName(), repos, excludeRepos) &&
+			included(ev.GetOrg().GetLogin(), orgs, excludeOrgs) &&
+			expr.Match(ev)
+	}, nil
+}
+
+// feedFilters are the filters of a feed, changed while it runs.
+type feedFilters struct {
+	mu sync.RWMutex
+	// Predicates of AddFilter, all must match.
+	predicates []func(*github.Event) bool
+	// Compiled filters of SetFilters, one must match if any.
+	selection []func(*github.Event) bool
+}
+
+// AddFilter adds a predicate the published events must satisfy, on top of
+// the filters of SetFilters, e.g. the Match method of a Filter. The events
+// it rejects are dropped before reaching the channel or the hooks, they
+// still advance the checkpoint. It is safe to call while the feed runs.
+func (f *EventFeed) AddFilter(match func(ev *github.Event) bool) {
+	f.filters.mu.Lock()
+	defer f.filters.mu.Unlock()
+	f.filters.predicates = append(f.filters.predicates, match)
+}
+
+// SetFilters replaces the filters selecting the published events, initially
+// Config.Filter: the events matching any of them are published, every event
+// without filters (or with a zero one). It applies from the next batch
+// published, e.g. when the subscriptions of the users of a web application
+// change, without restarting the poller, and is safe to call while the feed
+// runs. The filters are left unchanged if one is invalid.
+func (f *EventFeed) SetFilters(filters ...EventFilter) error {
+	var selection []func(*github.Event) bool
+	for _, filter := range filters {
+		if filter.IsZero() {
+			selection = nil
+			break
+		}
+
+		match, err := filter.Compile()
+		if err != nil {
+			return err
+		}
+		selection = append(selection, match)
+	}
+
+	f.filters.mu.Lock()
+	defer f.filters.mu.Unlock()
+	f.filters.selection = selection
+	return nil
+}
+
+// filterEvents returns the events of the batch satisfying the filters, the
+// batch itself if they all do.
+func (f *EventFeed) filterEvents(events []*github.Event) []*github.Event {
+	f.filters.mu.RLock()
+	predicates, selection := f.filters.predicates, f.filters.selection
+	f.filters.mu.RUnlock()
+
+	if len(predicates) == 0 && len(selection) == 0 {
+		return events
+	}
+
+	kept := make([]*github.Event, 0, len(events))
+	for _, ev := range events {
+		if matchAll(predicates, ev) && (len(selection) == 0 || matchAny(selection, ev)) {
+			kept = append(kept, ev)
+		}
+	}
+	if len(kept) == len(events) {
+		return events
+	}
+	return kept
+}
+
+func matchAll(predicates []func(*github.Event) bool, ev *github.Event) bool {
+	for _, match := range predicates {
+		if !match(ev) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAny(predicates []func(*github.Event) bool, ev *github.Event) bool {
+	for _, match := range predicates {
+		if match(ev) {
+			return true
+		}
+	}
+	return false
+}
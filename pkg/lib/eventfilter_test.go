@@ -0,0 +1,200 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// orgEvent sets the organization of an event, none if empty.
+func orgEvent(org string, ev *github.Event) *github.Event {
+	if org != "" {
+		ev.Org = &github.Organization{Login: github.String(org)}
+	}
+	return ev
+}
+
+func TestEventFilter(t *testing.T) {
+	match, err := lib.EventFilter{
+		Types:         []string{"PushEvent", "pullrequestevent"},
+		ExcludeActors: []string{"*[bot]"},
+		Repos:         []string{"golang/*", "kubernetes/kubernetes"},
+		ExcludeOrgs:   []string{"test-?"},
+		Expression:    "action!=closed",
+	}.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		ev   *github.Event
+		want bool
+	}{
+		{orgEvent("golang", testEvent("", "PushEvent", "gopher", "golang/go", time.Time{}, "")), true},
+		{orgEvent("", testEvent("", "PullRequestEvent", "k8s-ci", "Kubernetes/Kubernetes", time.Time{}, `{"action":"opened"}`)), true},
+		{orgEvent("", testEvent("", "PullRequestEvent", "k8s-ci", "kubernetes/kubernetes", time.Time{}, `{"action":"closed"}`)), false},
+		{orgEvent("golang", testEvent("", "IssuesEvent", "gopher", "golang/go", time.Time{}, "")), false},
+		// Brackets are literal, the login must end with [bot].
+		{orgEvent("golang", testEvent("", "PushEvent", "dependabot[bot]", "golang/go", time.Time{}, "")), false},
+		{orgEvent("golang", testEvent("", "PushEvent", "robot", "golang/go", time.Time{}, "")), true},
+		{orgEvent("test-1", testEvent("", "PushEvent", "gopher", "golang/go", time.Time{}, "")), false},
+		{orgEvent("kubernetes", testEvent("", "PushEvent", "gopher", "kubernetes/website", time.Time{}, "")), false},
+	} {
+		if got := match(tt.ev); got != tt.want {
+			t.Errorf("%s by %s on %s: got %v, want %v", tt.ev.GetType(), tt.ev.GetActor().GetLogin(), tt.ev.GetRepo().GetName(), got, tt.want)
+		}
+	}
+
+	if _, err := (lib.EventFilter{Expression: "colour=red"}).Compile(); err == nil {
+		t.Error("an unknown field compiled")
+	}
+	if _, err := (lib.EventFilter{Actors: []string{""}}).Compile(); err == nil {
+		t.Error("an empty pattern compiled")
+	}
+}
+
+func TestFeedFilters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"id":"4","type":"PushEvent","actor":{"login":"dependabot[bot]"},"repo":{"name":"o/a"}},
+			{"id":"3","type":"WatchEvent","actor":{"login":"a"},"repo":{"name":"o/a"}},
+			{"id":"2","type":"PushEvent","actor":{"login":"a"},"repo":{"name":"o/b"}},
+			{"id":"1","type":"PushEvent","actor":{"login":"a"},"repo":{"name":"o/a"}}
+		]`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+		BaseURL: server.URL,
+		Filter:  lib.EventFilter{Types: []string{"PushEvent"}, ExcludeActors: []string{"*[bot]"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.AddFilter(func(ev *github.Event) bool { return ev.GetRepo().GetName() == "o/a" })
+	go feed.Serve()
+
+	batch := nextBatch(t, events)
+	if len(batch) != 1 || batch[0].GetID() != "1" {
+		ids := make([]string, len(batch))
+		for i, ev := range batch {
+			ids[i] = ev.GetID()
+		}
+		t.Errorf("got events %v, want 1", ids)
+	}
+
+	if _, _, err := lib.NewEventFeed(ctx, &lib.Config{Filter: lib.EventFilter{Expression: "type~=("}}); err == nil {
+		t.Error("the feed accepted an invalid filter")
+	}
+}
+
+func TestFeedSetFilters(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"id":"3","type":"IssuesEvent","actor":{"login":"c"},"repo":{"name":"o/c"}},
+			{"id":"2","type":"WatchEvent","actor":{"login":"b"},"repo":{"name":"o/b"}},
+			{"id":"1","type":"PushEvent","actor":{"login":"a"},"repo":{"name":"o/a"}}
+		]`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+		BaseURL: server.URL,
+		Clock:   clock,
+		Filter:  lib.EventFilter{Types: []string{"PushEvent"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+
+	ids := func(batch []*github.Event) string {
+		s := make([]string, len(batch))
+		for i, ev := range batch {
+			s[i] = ev.GetID()
+		}
+		return strings.Join(s, ",")
+	}
+
+	if got := ids(nextBatch(t, events)); got != "1" {
+		t.Errorf("got events %s, want 1", got)
+	}
+
+	// The subscriptions changed, the events of either are published.
+	waitForTimer(t, clock)
+	if err := feed.SetFilters(lib.EventFilter{Repos: []string{"o/b"}}, lib.EventFilter{Actors: []string{"c"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := feed.SetFilters(lib.EventFilter{Repos: []string{""}}); err == nil {
+		t.Error("an invalid filter was set")
+	}
+	clock.Advance(time.Minute)
+	if got := ids(nextBatch(t, events)); got != "3,2" {
+		t.Errorf("got events %s, want 3,2", got)
+	}
+
+	// Without filters, every event is published.
+	waitForTimer(t, clock)
+	feed.SetFilters()
+	clock.Advance(time.Minute)
+	if got := ids(nextBatch(t, events)); got != "3,2,1" {
+		t.Errorf("got events %s, want 3,2,1", got)
+	}
+}
+
+func TestFeedMaxEventAge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{"id":"4","type":"PushEvent","created_at":"2020-06-01T11:59:00Z"},
+			{"id":"3","type":"PushEvent","created_at":"2020-06-01T11:00:00Z"},
+			{"id":"2","type":"PushEvent"},
+			{"id":"1","type":"PushEvent","created_at":"2020-06-01T10:00:00Z"}
+		]`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+		BaseURL:     server.URL,
+		Clock:       lib.NewSimulatedClock(time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)),
+		MaxEventAge: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+
+	batch := nextBatch(t, events)
+	ids := make([]string, len(batch))
+	for i, ev := range batch {
+		ids[i] = ev.GetID()
+	}
+	if got := strings.Join(ids, ","); got != "4,3,2" {
+		t.Errorf("got events %s, want 4,3,2", got)
+	}
+
+	var metrics bytes.Buffer
+	feed.WritePrometheus(&metrics)
+	if !strings.Contains(metrics.String(), "github_feed_events_stale_total 1\n") {
+		t.Errorf("stale events not counted:\n%s", metrics.String())
+	}
+}
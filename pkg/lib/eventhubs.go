@@ -0,0 +1,305 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	// Standard tier namespaces limit batches to 1MB.
+	eventHubsMaxPayload = 1 << 20
+	// Resource of the Azure AD tokens of Event Hubs.
+	eventHubsResource = "https://eventhubs.azure.net"
+	// Instance Metadata Service endpoint of the managed identity tokens
+	// on Azure VMs and AKS, when IDENTITY_ENDPOINT isn't set.
+	imdsTokenEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	// Tokens are renewed this long before they expire.
+	eventHubsTokenMargin = 5 * time.Minute
+)
+
+// EventHubsAuth authorizes the requests of an EventHubsSink.
+type EventHubsAuth interface {
+	// Authorization returns the Authorization header of a request to the
+	// resource, the https:// URL of the event hub.
+	Authorization(ctx context.Context, resource string) (string, error)
+}
+
+// EventHubsSink sends the events to an Azure event hub, a batch per write,
+// through its HTTPS send API (AMQP would need a client library, the
+// messages are the same). Each message holds the record of an event, JSON,
+// with the CloudEvents attributes of the event (see CloudEvent) as
+// cloudEvents:-prefixed properties, per the CloudEvents AMQP binding.
+//
+// The messages are partitioned by the key of PartitionKey, if set, so that
+// the events of a key land in the same partition, in order, otherwise
+// spread by Event Hubs. Requests are authorized with a shared access key
+// or the managed identity of the host, see EventHubsAuth.
+type EventHubsSink struct {
+	url        string
+	resource   string
+	auth       EventHubsAuth
+	serializer Serializer
+	client     *http.Client
+	// PartitionKey of the messages, e.g. repo.name, none if nil.
+	PartitionKey *KeySpec
+}
+
+// NewEventHubsSink returns the sink of the hub of a namespace, its host name
+// (e.g. myns.servicebus.windows.net), sending to endpoint instead if set.
+func NewEventHubsSink(namespace, hub, endpoint string, auth EventHubsAuth, s Serializer) (*EventHubsSink, error) {
+	s = serializerOrDefault(s)
+	if namespace == "" || hub == "" {
+		return nil, fmt.Errorf("eventhubs sink requires a namespace and an event hub")
+	}
+	if s.Binary() {
+		return nil, fmt.Errorf("eventhubs sink requires JSON records, not %s", s.ContentType())
+	}
+
+	resource := "https://" + namespace + "/" + url.PathEscape(hub)
+	if endpoint == "" {
+		endpoint = resource
+	} else {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(hub)
+	}
+
+	return &EventHubsSink{
+		url:        endpoint + "/messages?timeout=60&api-version=2014-01",
+		resource:   resource,
+		auth:       auth,
+		serializer: s,
+		client:     &http.Client{Timeout: defaultOutputTimeout},
+	}, nil
+}
+
+type eventHubsMessage struct {
+	Body             string                 `json:"Body"`
+	BrokerProperties *eventHubsBroker       `json:"BrokerProperties,omitempty"`
+	UserProperties   map[string]interface{} `json:"UserProperties"`
+}
+
+type eventHubsBroker struct {
+	PartitionKey string `json:"PartitionKey"`
+}
+
+// encode returns the body of the batch's send request.
+func (s *EventHubsSink) encode(events []*github.Event) ([]byte, error) {
+	messages := make([]eventHubsMessage, len(events))
+	for i, ev := range events {
+		ce, err := NewCloudEvent(ev, s.serializer)
+		if err != nil {
+			return nil, err
+		}
+
+		m := eventHubsMessage{
+			Body: string(ce.Data),
+			UserProperties: map[string]interface{}{
+				"cloudEvents:specversion": ce.SpecVersion,
+				"cloudEvents:id":          ce.ID,
+				"cloudEvents:source":      ce.Source,
+				"cloudEvents:type":        ce.Type,
+				"content-type":            ce.DataContentType,
+			},
+		}
+		if ce.Time != nil {
+			m.UserProperties["cloudEvents:time"] = ce.Time.Format(time.RFC3339)
+		}
+		if s.PartitionKey != nil {
+			key, err := s.PartitionKey.Key(ev)
+			if err != nil {
+				return nil, err
+			}
+			m.BrokerProperties = &eventHubsBroker{PartitionKey: key}
+		}
+		messages[i] = m
+	}
+
+	return json.Marshal(messages)
+}
+
+// PayloadSize returns the size of the batch's send request body.
+func (s *EventHubsSink) PayloadSize(events []*github.Event) (int, error) {
+	body, err := s.encode(events)
+	return len(body), err
+}
+
+func (s *EventHubsSink) Write(ctx context.Context, events []*github.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	body, err := s.encode(events)
+	if err != nil {
+		return err
+	}
+	authorization, err := s.auth.Authorization(ctx, s.resource)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.Name(), err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.microsoft.servicebus.json")
+	req.Header.Set("Authorization", authorization)
+	Identify(req)
+
+	rep, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode/100 != 2 {
+		payload, _ := ioutil.ReadAll(rep.Body)
+		return fmt.Errorf("%s: send: %s: %s", s.Name(), rep.Status, bytes.TrimSpace(payload))
+	}
+
+	return nil
+}
+
+func (s *EventHubsSink) Name() string {
+	return "eventhubs://" + strings.TrimPrefix(s.resource, "https://")
+}
+
+// Hosts returns the host of the endpoint, and those of the auth.
+func (s *EventHubsSink) Hosts() []string {
+	return append(urlHost(s.url), EgressHosts(s.auth)...)
+}
+
+func (s *EventHubsSink) Close() error {
+	return nil
+}
+
+// SharedAccessKeyAuth authorizes requests with shared access signatures of a
+// namespace or event hub policy, valid for an hour.
+type SharedAccessKeyAuth struct {
+	KeyName string
+	Key     string
+}
+
+func (a *SharedAccessKeyAuth) Authorization(ctx context.Context, resource string) (string, error) {
+	return sharedAccessSignature(resource, a.KeyName, a.Key, time.Now().Add(time.Hour)), nil
+}
+
+// sharedAccessSignature returns the SAS token of the resource, see
+// https://docs.microsoft.com/azure/event-hubs/authenticate-shared-access-signature.
+func sharedAccessSignature(resource, keyName, key string, expiry time.Time) string {
+	uri := url.QueryEscape(resource)
+	se := strconv.FormatInt(expiry.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(uri + "\n" + se))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s&skn=%s", uri, url.QueryEscape(sig), se, url.QueryEscape(keyName))
+}
+
+// ManagedIdentityAuth authorizes requests with Azure AD tokens of the
+// managed identity of the host: from IDENTITY_ENDPOINT on App Service and
+// Functions, the Instance Metadata Service otherwise (VMs, AKS). Tokens are
+// renewed 5 minutes before they expire.
+type ManagedIdentityAuth struct {
+	// ClientID of a user assigned identity, the system assigned identity if
+	// empty.
+	ClientID string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type managedIdentityToken struct {
+	AccessToken string      `json:"access_token"`
+	ExpiresOn   json.Number `json:"expires_on"`
+}
+
+func (a *ManagedIdentityAuth) Authorization(ctx context.Context, resource string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token == "" || time.Now().Add(eventHubsTokenMargin).After(a.expires) {
+		if err := a.refresh(ctx); err != nil {
+			return "", err
+		}
+	}
+	return "Bearer " + a.token, nil
+}
+
+// managedIdentityEndpoint returns the endpoint of the tokens of the host,
+// IDENTITY_ENDPOINT if set, the Instance Metadata Service otherwise.
+func managedIdentityEndpoint() string {
+	if endpoint := os.Getenv("IDENTITY_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	return imdsTokenEndpoint
+}
+
+// Hosts returns the host of the token endpoint.
+func (a *ManagedIdentityAuth) Hosts() []string {
+	return urlHost(managedIdentityEndpoint())
+}
+
+func (a *ManagedIdentityAuth) refresh(ctx context.Context) error {
+	query := url.Values{"resource": {eventHubsResource}}
+	if a.ClientID != "" {
+		query.Set("client_id", a.ClientID)
+	}
+
+	endpoint := managedIdentityEndpoint()
+	header, secret := "X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER")
+	if endpoint != imdsTokenEndpoint {
+		query.Set("api-version", "2019-08-01")
+	} else {
+		header, secret = "Metadata", "true"
+		query.Set("api-version", "2018-02-01")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(header, secret)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	rep, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("managed identity token: %w", err)
+	}
+	defer rep.Body.Close()
+
+	payload, err := ioutil.ReadAll(rep.Body)
+	if err != nil {
+		return err
+	}
+	if rep.StatusCode != http.StatusOK {
+		return fmt.Errorf("managed identity token: %s: %s", rep.Status, bytes.TrimSpace(payload))
+	}
+
+	var token managedIdentityToken
+	if err := json.Unmarshal(payload, &token); err != nil {
+		return fmt.Errorf("managed identity token: %w", err)
+	}
+	expires, err := token.ExpiresOn.Int64()
+	if err != nil || token.AccessToken == "" {
+		return fmt.Errorf("managed identity token: invalid response")
+	}
+
+	RegisterSecret(token.AccessToken)
+	a.token = token.AccessToken
+	a.expires = time.Unix(expires, 0)
+	return nil
+}
@@ -0,0 +1,34 @@
+package lib_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// testEvent returns an event of typ by actor in repo, created at at with the
+// JSON payload, the empty arguments left unset. Its ID is id, or derived
+// from the other arguments if empty.
+func testEvent(id, typ, actor, repo string, at time.Time, payload string) *github.Event {
+	if id == "" {
+		id = fmt.Sprintf("%s-%s-%s-%d", typ, actor, repo, at.UnixNano())
+	}
+
+	ev := &github.Event{ID: github.String(id), Type: github.String(typ)}
+	if actor != "" {
+		ev.Actor = &github.User{Login: github.String(actor)}
+	}
+	if repo != "" {
+		ev.Repo = &github.Repository{Name: github.String(repo)}
+	}
+	if !at.IsZero() {
+		ev.CreatedAt = &at
+	}
+	if payload != "" {
+		raw := json.RawMessage(payload)
+		ev.RawPayload = &raw
+	}
+	return ev
+}
@@ -0,0 +1,7 @@
+package lib
+
+// Internals exercised by the tests of package lib_test.
+var (
+	SignV4           = signV4
+	RunRepoDiscovery = (*RepoDiscovery).run
+)
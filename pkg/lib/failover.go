@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultAPIURL               = "https://api.github.com/"
+	defaultFailoverProbeSeconds = 30
+	// Endpoint probed by the health checks, it doesn't count against the
+	// rate limit.
+	failoverProbePath = "rate_limit"
+)
+
+// failoverTransport sends the API requests to the first healthy endpoint of
+// a prioritized list, e.g. a caching proxy before api.github.com. Requests
+// are addressed to the first endpoint and rewritten to the endpoint serving
+// them, so that the responses are cached alike whichever served them.
+//
+// An endpoint failing a request (connection error or 5xx) is marked down and
+// the request is retried on the next one. Endpoints marked down are probed
+// periodically, and preferred again once they answer.
+type failoverTransport struct {
+	base      http.RoundTripper
+	endpoints []*apiEndpoint
+	report    func(op, eventID string, err error)
+}
+
+type apiEndpoint struct {
+	url  string
+	down int32
+}
+
+func (e *apiEndpoint) isDown() bool {
+	return atomic.LoadInt32(&e.down) != 0
+}
+
+func normalizeAPIURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(raw, "/") + "/")
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid API URL '%s', expected an absolute URL", raw)
+	}
+	return u.String(), nil
+}
+
+// newFailoverTransport returns base if there are no fallbacks. The health
+// checks run until the feed's context is done.
+func newFailoverTransport(feed *EventFeed, base http.RoundTripper, conf *Config) (http.RoundTripper, error) {
+	if len(conf.FallbackURLs) == 0 {
+		return base, nil
+	}
+
+	t := &failoverTransport{base: base, report: feed.ReportError}
+	for _, raw := range append([]string{orDefaultURL(conf.BaseURL)}, conf.FallbackURLs...) {
+		u, err := normalizeAPIURL(raw)
+		if err != nil {
+			return nil, err
+		}
+		t.endpoints = append(t.endpoints, &apiEndpoint{url: u})
+	}
+
+	go t.healthChecks(feed.ctx, feed.clock)
+
+	return t, nil
+}
+
+func orDefaultURL(u string) string {
+	if u == "" {
+		return defaultAPIURL
+	}
+	return u
+}
+
+// order returns the endpoints up, in priority order, then those down: when
+// all are down, they are still tried.
+func (t *failoverTransport) order() []*apiEndpoint {
+	order := make([]*apiEndpoint, 0, len(t.endpoints))
+	for _, e := range t.endpoints {
+		if !e.isDown() {
+			order = append(order, e)
+		}
+	}
+	for _, e := range t.endpoints {
+		if e.isDown() {
+			order = append(order, e)
+		}
+	}
+	return order
+}
+
+func (t *failoverTransport) markDown(e *apiEndpoint, err error) {
+	if atomic.CompareAndSwapInt32(&e.down, 0, 1) {
+		warnf("API endpoint %s is down, failing over: %v", e.url, err)
+		t.report(OpPoll, "", fmt.Errorf("API endpoint %s is down: %w", e.url, err))
+	}
+}
+
+func (t *failoverTransport) markUp(e *apiEndpoint) {
+	if atomic.CompareAndSwapInt32(&e.down, 1, 0) {
+		log.Printf("API endpoint %s is back up", e.url)
+	}
+}
+
+// failure returns why the endpoint failed the request, nil if it didn't.
+func failure(resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	primary := t.endpoints[0].url
+	target := req.URL.String()
+	if !strings.HasPrefix(target, primary) {
+		return t.base.RoundTrip(req)
+	}
+	path := target[len(primary):]
+
+	// Requests with a body are only retried if it can be read again.
+	retryable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	var resp *http.Response
+	var err error
+	order := t.order()
+	for i, e := range order {
+		// RoundTrippers must not modify the caller's request.
+		r := req.Clone(req.Context())
+		if r.URL, err = url.Parse(e.url + path); err != nil {
+			return nil, err
+		}
+		r.Host = ""
+		if i > 0 && req.GetBody != nil {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err = t.base.RoundTrip(r)
+		ferr := failure(resp, err)
+		if ferr == nil {
+			t.markUp(e)
+			return resp, nil
+		}
+
+		// Canceled requests say nothing of the endpoint.
+		if req.Context().Err() != nil {
+			break
+		}
+		t.markDown(e, ferr)
+
+		if i == len(order)-1 || !retryable {
+			break
+		}
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+
+	return resp, err
+}
+
+// healthChecks probes the endpoints marked down until ctx is done.
+func (t *failoverTransport) healthChecks(ctx context.Context, clock Clock) {
+	for {
+		select {
+		case <-clock.After(defaultFailoverProbeSeconds * time.Second):
+		case <-ctx.Done():
+			return
+		}
+
+		for _, e := range t.endpoints {
+			if e.isDown() && t.probe(ctx, e) {
+				t.markUp(e)
+			}
+		}
+	}
+}
+
+func (t *failoverTransport) probe(ctx context.Context, e *apiEndpoint) bool {
+	ctx, cancel := context.WithTimeout(ctx, defaultRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url+failoverProbePath, nil)
+	if err != nil {
+		return false
+	}
+	Identify(req)
+
+	resp, err := t.base.RoundTrip(req)
+	if failure(resp, err) != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return false
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return true
+}
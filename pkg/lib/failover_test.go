@@ -0,0 +1,94 @@
+package lib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// apiEndpoint answers the events API with a single event of the given ID,
+// or 503 when unhealthy.
+func apiEndpoint(id string, healthy *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(healthy) == 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":"` + id + `","type":"PushEvent"}]`))
+	}))
+}
+
+func TestFeedFailsOver(t *testing.T) {
+	primaryHealthy, fallbackHealthy := int32(0), int32(1)
+	primary, fallback := apiEndpoint("1", &primaryHealthy), apiEndpoint("2", &fallbackHealthy)
+	defer primary.Close()
+	defer fallback.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	feed, _, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: primary.URL, FallbackURLs: []string{fallback.URL}, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	servedBy := func() string {
+		events, _, err := feed.Client().Activity.ListEvents(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("listed %d events", len(events))
+		}
+		return events[0].GetID()
+	}
+
+	if id := servedBy(); id != "2" {
+		t.Fatalf("served by %s with the primary down", id)
+	}
+	select {
+	case err := <-feed.Errors():
+		if err.(*lib.FeedError).Op != lib.OpPoll {
+			t.Errorf("failover reported as %v", err)
+		}
+	default:
+		t.Error("failover not reported")
+	}
+
+	// Still down until probed healthy.
+	atomic.StoreInt32(&primaryHealthy, 1)
+	if id := servedBy(); id != "2" {
+		t.Errorf("served by %s before the primary was probed", id)
+	}
+
+	waitForTimer(t, clock)
+	clock.Advance(30 * time.Second)
+	deadline := time.Now().Add(5 * time.Second)
+	for servedBy() != "1" {
+		if time.Now().After(deadline) {
+			t.Fatal("primary not preferred again once healthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Both down, the request fails with the last endpoint's response.
+	atomic.StoreInt32(&primaryHealthy, 0)
+	atomic.StoreInt32(&fallbackHealthy, 0)
+	if _, _, err := feed.Client().Activity.ListEvents(ctx, nil); err == nil {
+		t.Error("listed events with all endpoints down")
+	}
+}
+
+func TestFeedRejectsInvalidFallback(t *testing.T) {
+	if _, _, err := lib.NewEventFeed(context.Background(), &lib.Config{FallbackURLs: []string{"proxy:8080"}}); err == nil {
+		t.Error("accepted a relative fallback URL")
+	}
+}
@@ -2,9 +2,17 @@ package lib
 
 import (
 	"context"
-	"log"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/go-github/v32/github"
@@ -14,9 +22,11 @@ import (
 
 const (
 	// Poll interval header returned in github event responses.
-	xPollIntervalHeader = "X-Poll-Interval"
-	defaultPollSeconds  = 60
-	defaultFeedCapacity = 16
+	xPollIntervalHeader   = "X-Poll-Interval"
+	defaultPollSeconds    = 60
+	defaultFeedCapacity   = 16
+	defaultRequestTimeout = 10 * time.Second
+	defaultHTTPCacheBytes = 32 << 20
 	// The following numbers are taken from github API documentation.
 	// https://developer.github.com/v3/activity/events/#list-public-events
 	maximumEventsPages   = 10
@@ -27,57 +37,605 @@ const (
 type EventFeed struct {
 	client *github.Client
 	ctx    context.Context
-	events chan<- []*github.Event
+	events chan []*github.Event
+	errors chan error
+	clock  Clock
+	// See Config.Backpressure and MaxBatchEvents.
+	backpressure string
+	maxBatch     int
+	spill        *spillQueue
+	// See Config.MaxEventAge.
+	maxEventAge time.Duration
+	// See Config.LagThreshold.
+	lagPolicy lagPolicy
+	// See Config.CatchUpAfter.
+	catchUp catchUp
+	// See Config.MinPollInterval and MaxPages.
+	minPollInterval time.Duration
+	maxPages        int
+	// Number of errors dropped because the errors channel was full.
+	droppedErrors uint64
+	// Set by NewWebhookFeed, which receives deliveries instead of polling.
+	webhook *webhookHandler
+	// Set by NewArchiveFeed, which replays GH Archive instead of polling.
+	gharchive *ghArchiveReplay
+
+	// The sources, replaced (never modified in place) by AddSource and
+	// RemoveSource under checkpointMu, and their retry policy.
+	sources      []*sourcePoller
+	sourceRetry  retryPolicy
+	dedup        *Deduplicator
+	checkpoints  CheckpointStore
+	checkpointMu sync.Mutex
+	// Starts the pollers of the added sources while Serve polls.
+	startPoller func(p *sourcePoller)
+
+	// Underlying client of the GitHub client, see CacheProxy.
+	httpClient *http.Client
+	// Set with Config.AuthTokens.
+	tokens *tokenPool
+	// API requests sent per token and subsystem, see
+	// Config.RequestReportInterval.
+	accounting     *requestAccounting
+	requestReports time.Duration
+	// Last API responses, see RecentResponses.
+	responses *responseRecorder
+	// Cache of the API responses.
+	cache *meteredHTTPCache
+
+	hooks   feedHooks
+	filters feedFilters
+	pause   pauseState
+	// Failed polls by class, see Failures.
+	failures [failureClasses]uint64
+	metrics  feedMetrics
 }
 
 type Config struct {
 	AuthToken string
+	// Tokens of several identities pooled to raise the rate limit, on top
+	// of AuthToken. Each request is authenticated with the token with the
+	// most requests left, and the tokens which exhausted their rate limit
+	// are benched until it resets, their requests retried with the others.
+	// See EventFeed.TokenStats.
+	AuthTokens []string
+	// Base URL of the API, e.g. of a GitHub Enterprise instance, defaults
+	// to https://api.github.com/.
+	BaseURL string
+	// Upload URL of a GitHub Enterprise Server instance, e.g.
+	// https://ghe.example.com/. Setting it builds the client with
+	// github.NewEnterpriseClient: api/v3/ is appended to BaseURL and
+	// api/uploads/ to UploadURL unless they end with them. The instances
+	// which don't report when their rate limit resets are polled again
+	// after Retry-After, or a minute.
+	UploadURL string
+	// TLS configuration of the API requests, e.g. trusting the internal CA
+	// of a GitHub Enterprise Server instance (see LoadCACerts), and URL of
+	// their proxy (http, https or socks5), those of http.DefaultTransport
+	// (HTTPS_PROXY...) if unset.
+	TLSConfig *tls.Config
+	ProxyURL  string
+	// Base URLs the requests fail over to, in order, when BaseURL fails
+	// (connection errors and 5xx), e.g. BaseURL a local caching proxy and
+	// https://api.github.com/ the fallback. Failed endpoints are probed
+	// every 30 seconds and preferred again once healthy.
+	FallbackURLs []string
+	// Value of the X-GitHub-Api-Version header, e.g. "2022-11-28". Unset
+	// leaves the choice to GitHub.
+	APIVersion string
+	// Preview media types to opt into, either by name (e.g. "mercy") or as
+	// a full media type.
+	Previews []string
+	// User-Agent and X-Feed-Tag of the requests to GitHub, those set by
+	// SetRequestIdentity if empty.
+	UserAgent  string
+	RequestTag string
+
+	// Tunables, the zero value selects the default.
+	//
+	// Number of batches buffered for the consumer before polling blocks.
+	QueueSize int
+	// What publishing does once QueueSize batches wait for the consumer:
+	// BackpressureBlock (the default) holds the polls back until the
+	// consumer catches up, BackpressureDropOldest and
+	// BackpressureDropNewest keep the feed fresh and the memory bounded at
+	// the cost of the events dropped (see EventFeed.OnDrop), and
+	// BackpressureSpill queues the batches in a file of SpillDir until the
+	// consumer catches up. Dropped events were checkpointed, they are not
+	// published again. The callbacks of OnBatch and OnEvent are always
+	// blocking.
+	Backpressure string
+	// Directory of the batches spilled, BackpressureSpill. Those left when
+	// the feed stops are published first by the next feed spilling to the
+	// directory.
+	SpillDir string
+	// Batches of more events are published as several batches of at most
+	// MaxBatchEvents events, so that a queue of QueueSize batches holds a
+	// bounded number of events. Unbounded if zero.
+	MaxBatchEvents int
+	// Events created longer than MaxEventAge ago are dropped instead of
+	// published, e.g. the backlog polled after a long downtime or replayed
+	// from GH Archive, for consumers which would rather skip stale events.
+	// They still advance the checkpoint. Webhook deliveries are created when
+	// received, and events without creation time are always published.
+	// Unlimited if zero.
+	MaxEventAge time.Duration
+	// Once more than LagThreshold batches wait for the consumer, the
+	// spilled ones included, the polls slow down rather than queuing ever
+	// more events: the interval is doubled for every further LagThreshold
+	// batches waiting, up to MaxLagPollInterval (5 minutes if zero). The
+	// events matching LagShedFilter, e.g. the types the consumer can do
+	// without, are meanwhile shed instead of published, and still advance
+	// the checkpoint. Disabled if zero, see also Backpressure.
+	LagThreshold       int
+	MaxLagPollInterval time.Duration
+	LagShedFilter      EventFilter
+	// Number of errors buffered before they are dropped.
+	ErrorsQueueSize int
+	// Timeout of the GitHub API requests.
+	RequestTimeout time.Duration
+	// Interval at which the API requests sent per token and subsystem
+	// (see WithSubsystem) are logged, to attribute the rate limit usage,
+	// disabled if zero. They are counted in the metrics either way, see
+	// EventFeed.RequestCounts.
+	RequestReportInterval time.Duration
+	// Floor of the interval between the polls of a source, raising the
+	// X-Poll-Interval of the API, e.g. to spare the rate limit of a token
+	// shared with other applications.
+	MinPollInterval time.Duration
+	// Pages of events requested per poll, at most 10 (the default), the
+	// number of pages the events API serves.
+	MaxPages int
+	// A feed resuming from a checkpoint saved longer than CatchUpAfter ago
+	// catches up: its sources are polled every CatchUpInterval (5s if zero)
+	// rather than at the interval GitHub hints, while more than a tenth of
+	// the rate limit is left, until a poll reaches the events published
+	// before it. It then settles back to the normal cadence. Disabled if
+	// zero.
+	CatchUpAfter    time.Duration
+	CatchUpInterval time.Duration
+	// The events created since the checkpoint and already out of reach of
+	// the first poll catching up, the API serving the last 300 events of a
+	// source, are replayed from the GH Archive dumps of GHArchiveURL
+	// concurrently. The hours not dumped yet are reported and skipped, and
+	// the gap isn't replayed again if the feed stops before the end.
+	CatchUpFromArchive bool
+	// Perturbs the responses of the events API, e.g. to test that the
+	// feed honors X-Poll-Interval as it changes. Disabled if zero.
+	Chaos ChaosConfig
+	// Size of the cache of API responses, reported as http in the cache
+	// metrics.
+	HTTPCacheBytes int
+	// Backend of the cache of API responses, e.g. a DiskCache or a
+	// RedisCache so that the ETags survive restarts and the first polls
+	// don't download the pages again, see OpenHTTPCache. Memory bounded by
+	// HTTPCacheBytes if nil.
+	Cache HTTPCacheBackend
+	// Bandwidth cap of the GitHub API traffic in bytes per second,
+	// unlimited if zero. The traffic is reported as github in the bandwidth
+	// metrics either way, see WriteBandwidthMetrics.
+	MaxBandwidth int64
+
+	// Clock timing the polls, the system clock if nil.
+	Clock Clock
+
+	// Verbosity (debug, info, warn or error) and format (text or json) of
+	// the logs of the process, validated with the others but left to the
+	// caller to set with ConfigureLogging: they are global.
+	LogLevel  string
+	LogFormat string
+
+	// Failed polls (connection errors, 5xx) are retried with exponential
+	// backoff and jitter, from RetryBackoff (1s by default) up to
+	// MaxRetryBackoff (5 minutes by default). Secondary rate limits are
+	// retried after their Retry-After. Serve returns once a source failed
+	// MaxConsecutiveFailures polls in a row (5 by default, 1 stops at the
+	// first failure, negative retries forever), or on other 4xx errors
+	// (e.g. a revoked token). See EventFeed.Failures.
+	MaxConsecutiveFailures int
+	RetryBackoff           time.Duration
+	MaxRetryBackoff        time.Duration
+
+	// Selects the events published, every event if zero. Events are
+	// filtered before being published, after deduplication and
+	// checkpointing, see also EventFeed.SetFilters and AddFilter.
+	Filter EventFilter
+
+	// Events endpoints polled concurrently, each at its own pace (poll
+	// interval, rate limit resets), their events published on the same
+	// channel. The public events of the network if empty.
+	Sources []Source
+
+	// Drops the events already published by earlier polls, which overlap,
+	// and stops paginating at the first page reaching them. Its state is
+	// saved after every published batch.
+	Deduplicator *Deduplicator
+	// Saves the newest event published after every batch, so that a
+	// restarted feed resumes where it left off: the first poll is
+	// conditional on the checkpoint's ETag, and the events up to the
+	// checkpoint are dropped. Batches are checkpointed once queued for the
+	// consumer, those in flight at a crash are not published again;
+	// consumers needing them deduplicate after delivery instead. A
+	// FileCheckpointStore of StateDir if nil.
+	Checkpoints CheckpointStore
+	// Directory of the state of the feed, e.g. /var/lib/github-feed,
+	// holding the CheckpointFile unless Checkpoints is set. The feed isn't
+	// checkpointed if both are unset.
+	StateDir string
+
+	// Address on which the deliveries of webhooks are received, e.g.
+	// ":8080", and the secret of their signatures, see NewWebhookFeed.
+	WebhookAddr   string
+	WebhookSecret string
+
+	// Range of the GH Archive hourly dumps replayed instead of polling the
+	// events API, see NewArchiveFeed: the events created in [GHArchiveFrom,
+	// GHArchiveTo), up to now if GHArchiveTo is zero. They are downloaded
+	// from GHArchiveURL (GHArchiveURL if empty), and published at
+	// GHArchiveSpeed times the pace they were created at, as fast as
+	// possible if zero.
+	GHArchiveFrom  time.Time
+	GHArchiveTo    time.Time
+	GHArchiveURL   string
+	GHArchiveSpeed float64
+
+	// Address on which Serve exposes the metrics of the feed (/metrics) and
+	// its probes (/healthz, /readyz), e.g. ":9090", disabled if empty. See
+	// EventFeed.MetricsHandler.
+	MetricsAddr string
+}
+
+func orDefault(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
 }
 
 func NewEventFeed(ctx context.Context, conf *Config) (*EventFeed, <-chan []*github.Event, error) {
-	var feed *EventFeed = &EventFeed{ctx: ctx}
+	if err := conf.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	if conf.UploadURL != "" {
+		enterprise := *conf
+		enterprise.BaseURL = enterpriseURL(conf.BaseURL, "api/v3/")
+		enterprise.UploadURL = enterpriseURL(conf.UploadURL, "api/uploads/")
+		conf = &enterprise
+	}
+
+	var feed *EventFeed = &EventFeed{ctx: ctx, clock: clockOrSystem(conf.Clock)}
+	feed.minPollInterval = conf.MinPollInterval
+	feed.maxPages = orDefault(conf.MaxPages, maximumEventsPages)
+	feed.accounting, feed.requestReports = newRequestAccounting(), conf.RequestReportInterval
+	feed.metrics.addr = conf.MetricsAddr
+
+	RegisterSecret(conf.AuthToken)
 
-	events := make(chan []*github.Event, defaultFeedCapacity)
+	events := make(chan []*github.Event, orDefault(conf.QueueSize, defaultFeedCapacity))
+
+	base, err := apiTransport(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tc *http.Client
+	if len(conf.AuthTokens) > 0 {
+		var tokens []string
+		if conf.AuthToken != "" {
+			tokens = append(tokens, conf.AuthToken)
+		}
+		for _, token := range conf.AuthTokens {
+			RegisterSecret(token)
+			tokens = append(tokens, token)
+		}
+		feed.tokens = newTokenPool(base, feed.clock, tokens)
+		feed.tokens.accounting = feed.accounting
+		tc = &http.Client{Transport: feed.tokens}
+	} else {
+		ts := oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: conf.AuthToken},
+		)
+		accounted := &accountedTransport{base: base, accounting: feed.accounting}
+		tc = oauth2.NewClient(context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: accounted}), ts)
+	}
+	tc.Timeout = conf.RequestTimeout
+	if tc.Timeout <= 0 {
+		tc.Timeout = defaultRequestTimeout
+	}
 
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: conf.AuthToken},
-	)
+	// Failing over beneath the cache, responses are cached alike whichever
+	// endpoint served them.
+	feed.responses = newResponseRecorder(NewMeteredTransport("github", tc.Transport, conf.MaxBandwidth))
+	failover, err := newFailoverTransport(feed, feed.responses, conf)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	tc := oauth2.NewClient(ctx, ts)
-	tc.Timeout = 10 * time.Second
+	var beneathCache http.RoundTripper = failover
+	if conf.Chaos.enabled() {
+		beneathCache = newChaosTransport(feed, failover, conf.Chaos)
+	}
 
+	backend := conf.Cache
+	if backend == nil {
+		backend = httpCache{NewCache("http", CacheConfig{MaxBytes: orDefault(conf.HTTPCacheBytes, defaultHTTPCacheBytes)})}
+	}
+	feed.cache = &meteredHTTPCache{HTTPCacheBackend: backend}
 	tc.Transport = &httpcache.Transport{
-		Transport:           tc.Transport,
-		Cache:               httpcache.NewMemoryCache(),
+		// Metered beneath the cache, cached responses aren't transferred.
+		Transport:           beneathCache,
+		Cache:               feed.cache,
 		MarkCachedResponses: true,
 	}
 
-	feed.client = github.NewClient(tc)
+	// Set before the cache, so that it honors Vary on these headers.
+	tc.Transport = newHeaderTransport(tc.Transport, conf)
+
+	feed.httpClient = tc
+	if conf.UploadURL != "" {
+		if feed.client, err = github.NewEnterpriseClient(conf.BaseURL, conf.UploadURL, tc); err != nil {
+			return nil, nil, err
+		}
+	} else if feed.client = github.NewClient(tc); conf.BaseURL != "" {
+		u, err := url.Parse(strings.TrimSuffix(conf.BaseURL, "/") + "/")
+		if err != nil {
+			return nil, nil, err
+		}
+		feed.client.BaseURL = u
+	}
 	feed.events = events
+	feed.errors = make(chan error, orDefault(conf.ErrorsQueueSize, defaultErrorsCapacity))
+
+	feed.backpressure, feed.maxBatch = conf.Backpressure, conf.MaxBatchEvents
+	feed.maxEventAge = conf.MaxEventAge
+	if feed.lagPolicy, err = newLagPolicy(conf); err != nil {
+		return nil, nil, err
+	}
+	if conf.Backpressure == BackpressureSpill {
+		if feed.spill, err = openSpillQueue(conf.SpillDir, events); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	sources := conf.Sources
+	if len(sources) == 0 {
+		sources = []Source{{Type: SourcePublic}}
+	}
+	feed.sourceRetry = newRetryPolicy(conf)
+	for _, source := range sources {
+		path, err := source.path()
+		if err != nil {
+			return nil, nil, err
+		}
+		feed.sources = append(feed.sources, &sourcePoller{source: source, path: path, retry: feed.sourceRetry})
+	}
+
+	if err := feed.SetFilters(conf.Filter); err != nil {
+		return nil, nil, err
+	}
+
+	feed.dedup = conf.Deduplicator
+	checkpoints := conf.Checkpoints
+	if checkpoints == nil && conf.StateDir != "" {
+		if err := os.MkdirAll(conf.StateDir, 0755); err != nil {
+			return nil, nil, err
+		}
+		checkpoints = NewFileCheckpointStore(filepath.Join(conf.StateDir, CheckpointFile))
+	}
+	if checkpoints != nil {
+		cp, err := checkpoints.Load()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		feed.checkpoints = checkpoints
+		for _, p := range feed.sources {
+			p.checkpoint = cp
+			if len(feed.sources) > 1 {
+				p.checkpoint = cp.Sources[p.source.String()]
+			}
+			p.resumeETag = p.checkpoint.ETag
+			p.catchingUp = conf.CatchUpAfter > 0 && !p.checkpoint.Time.IsZero() &&
+				feed.clock.Now().Sub(p.checkpoint.Time) > conf.CatchUpAfter
+		}
+	}
+	feed.catchUp = newCatchUp(conf)
 
 	return feed, events, nil
 }
 
+// Client returns the GitHub client used by the feed, for components (e.g.
+// enrichers) sharing its authentication and cache.
+func (f *EventFeed) Client() *github.Client {
+	return f.client
+}
+
+// Errors returns the channel on which non-fatal errors (*FeedError) are
+// published. Consuming it is optional, errors are dropped when it is full.
+func (f *EventFeed) Errors() <-chan error {
+	return f.errors
+}
+
+// ReportError publishes a non-fatal error on the errors channel, or to the
+// OnError callback. It is exported so that components processing the feed's
+// events (sinks, enrichers) surface their failures alongside the feed's own.
+func (f *EventFeed) ReportError(op string, eventID string, err error) {
+	ferr := &FeedError{Op: op, EventID: eventID, Time: f.clock.Now(), Err: err}
+	f.metrics.observeError(op)
+
+	if f.hooks.onError != nil {
+		f.hooks.onError(ferr)
+		return
+	}
+
+	select {
+	case f.errors <- ferr:
+	default:
+		atomic.AddUint64(&f.droppedErrors, 1)
+	}
+}
+
+// DroppedErrors returns the number of errors which could not be published
+// because the errors channel was full.
+func (f *EventFeed) DroppedErrors() uint64 {
+	return atomic.LoadUint64(&f.droppedErrors)
+}
+
+// Events are published even if malformed, consumers decide how strict they
+// want to be, but the problem is reported.
+func (f *EventFeed) checkEvents(events []*github.Event) {
+	for _, ev := range events {
+		switch {
+		case ev.Type == nil:
+			f.ReportError(OpParse, ev.GetID(), errors.New("missing type"))
+		case ev.Actor == nil || ev.Actor.Login == nil:
+			f.ReportError(OpParse, ev.GetID(), errors.New("missing actor"))
+		case ev.RawPayload != nil && !json.Valid(*ev.RawPayload):
+			f.ReportError(OpParse, ev.GetID(), errors.New("invalid payload"))
+		}
+	}
+}
+
+// Serve polls the events API, its sources concurrently (see Config.Sources),
+// receives webhook deliveries (see NewWebhookFeed) or replays GH Archive (see
+// NewArchiveFeed), and publishes the events until the feed's context is done,
+// the end of the replayed range or a non-recoverable error of a source. The
+// events channel is closed on return.
 func (f *EventFeed) Serve() error {
-	defer close(f.events)
+	defer atomic.StoreInt32(&f.metrics.stopped, 1)
+
+	if f.spill != nil {
+		f.spill.start(f.ctx, func(err error) { f.ReportError(OpSpill, "", err) })
+	}
+
+	if f.requestReports > 0 {
+		ctx, cancel := context.WithCancel(f.ctx)
+		defer cancel()
+		go f.reportRequests(ctx, f.requestReports)
+	}
+
+	if f.metrics.addr != "" {
+		stop, err := f.startMetricsServer()
+		if err != nil {
+			f.closeEvents()
+			return err
+		}
+		defer stop()
+	}
+
+	if f.webhook != nil {
+		return f.serveWebhooks()
+	}
+	if f.gharchive != nil {
+		return f.serveGHArchive()
+	}
+
+	defer f.closeEvents()
+
+	// The first source failing stops the others.
+	ctx, cancel := context.WithCancel(f.ctx)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	var pollers sync.WaitGroup
+	f.checkpointMu.Lock()
+	f.startPoller = func(p *sourcePoller) {
+		pollCtx, stop := context.WithCancel(ctx)
+		p.stop = stop
+		pollers.Add(1)
+		go func() {
+			defer pollers.Done()
+			err := f.servePoller(pollCtx, p)
+			if ctx.Err() == nil && pollCtx.Err() != nil {
+				// Removed by RemoveSource.
+				if p.retry.failures > 0 {
+					atomic.AddInt32(&f.metrics.failingSources, -1)
+				}
+				return
+			}
+			select {
+			case errs <- err:
+			default:
+			}
+		}()
+	}
+	for _, p := range f.sources {
+		f.startPoller(p)
+	}
+	f.checkpointMu.Unlock()
+
+	err := <-errs
+	cancel()
+	f.checkpointMu.Lock()
+	f.startPoller = nil
+	f.checkpointMu.Unlock()
+	pollers.Wait()
+	return err
+}
+
+// servePoller polls a source until ctx is done or a non-recoverable error.
+func (f *EventFeed) servePoller(ctx context.Context, p *sourcePoller) error {
+	// Replays the gap of the source catching up, stopped with the poller.
+	var gap sync.WaitGroup
+	ctx, cancel := context.WithCancel(ctx)
+	defer gap.Wait()
+	defer cancel()
+	if p.catchingUp {
+		f.startCatchUp(p)
+	}
 
 	for {
-		events, poll_interval, err := f.poll()
+		if err := f.waitResumed(ctx); err != nil {
+			return err
+		}
+
+		start := time.Now()
+		events, poll_interval, err := f.poll(ctx, p)
+		f.metrics.observePoll(time.Since(start))
 
 		// A real error was encountered
 		if err != nil {
-			return err
+			retry, err := f.pollFailed(ctx, p, events, err)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-f.clock.After(retry):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if p.retry.failures > 0 {
+			atomic.AddInt32(&f.metrics.failingSources, -1)
 		}
+		p.retry.succeeded()
+		atomic.StoreInt64(&f.metrics.lastPoll, f.clock.Now().Unix())
 
-		// Publish events in the channel
-		f.events <- events
+		f.checkEvents(events)
 
+		// Publish events in the channel, or to the callbacks
+		if err := f.publish(ctx, f.filterEvents(events)); err != nil {
+			return err
+		}
+		resumed := p.checkpoint
+		f.saveCheckpoint(p, events)
+
+		if p.catchingUp {
+			poll_interval = f.catchUpInterval(ctx, p, resumed, events, poll_interval, &gap)
+		}
+		if poll_interval < f.minPollInterval {
+			poll_interval = f.minPollInterval
+		}
+		poll_interval = f.lagInterval(poll_interval)
 		select {
-		case <-time.After(poll_interval):
-			log.Printf("Resuming after %d seconds.", poll_interval/time.Second)
+		case <-f.clock.After(poll_interval):
+			debugf("Resuming after %d seconds.", poll_interval/time.Second)
 			continue
-		case <-f.ctx.Done():
-			return f.ctx.Err()
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 }
@@ -95,7 +653,7 @@ func pollIntervalFromResponse(r *http.Response) time.Duration {
 	}
 
 	poll_seconds, err := strconv.Atoi(poll_header)
-	if err != nil {
+	if err != nil || poll_seconds < 0 {
 		return default_duration
 	}
 
@@ -108,8 +666,9 @@ func (f *EventFeed) pollIntervalOrPropagateError(r *github.Response, err error)
 		case *github.RateLimitError:
 			// RateLimiteError aren't treated as a real error. Instead, we respect
 			// the rate limit reset interval for the next poll time.
-			time_left := time.Until(r.Rate.Reset.Time)
-			log.Printf("Rate limit exceeded, resets in %d seconds.", time_left/time.Second)
+			now := f.clock.Now()
+			time_left := rateLimitReset(r.Rate.Reset.Time, r.Response, now).Sub(now)
+			warnf("Rate limit exceeded, resets in %d seconds.", time_left/time.Second)
 			return time_left, true, nil
 		default:
 			// Otherwise, propagate the error.
@@ -127,40 +686,151 @@ func isCachedResponse(r *http.Response) bool {
 	return ok
 }
 
-func (f *EventFeed) poll() (events []*github.Event, poll_interval time.Duration, err error) {
+func (f *EventFeed) poll(ctx context.Context, p *sourcePoller) (events []*github.Event, poll_interval time.Duration, err error) {
 	err = nil
 	poll_interval = time.Duration(-1)
+	p.caughtUp = false
 
 	// Consume paginated events, the loop is bounded by a known page limits.
 	opts := github.ListOptions{Page: 1}
-	for i := 0; i < maximumEventsPages; i++ {
-		log.Printf("Polling for page %d", opts.Page)
+	for i := 0; i < f.maxPages; i++ {
+		debugf("Polling %s for page %d", p.source, opts.Page)
+
+		var etag string
+		if i == 0 {
+			etag, p.resumeETag = p.resumeETag, ""
+		}
 
 		var response *github.Response
 		var batch []*github.Event
-		batch, response, err = f.client.Activity.ListEvents(f.ctx, &opts)
+		hinted := poll_interval
+		batch, response, err = f.listEvents(ctx, p.path, &opts, etag)
+
+		if etag != "" && response != nil && response.StatusCode == http.StatusNotModified {
+			debugf("No new events since the checkpoint")
+			poll_interval, err = pollIntervalFromResponse(response.Response), nil
+			p.caughtUp = true
+			break
+		}
 
 		var throttled bool
 		poll_interval, throttled, err = f.pollIntervalOrPropagateError(response, err)
+		if err == nil && poll_interval < hinted {
+			// The pages of a poll may hint differently, e.g. a cached one
+			// an older interval: the longest of them is honored.
+			poll_interval = hinted
+		}
 
+		if throttled {
+			atomic.AddUint64(&f.failures[failureRateLimit], 1)
+			if f.hooks.onThrottle != nil {
+				f.hooks.onThrottle(p.source, poll_interval)
+			}
+			if p.catchingUp {
+				f.endCatchUp(p, "rate limited")
+			}
+		}
 		if err != nil || throttled {
 			// An actual error was encountered or github asked for a throttling.
 			break
 		}
 
 		if isCachedResponse(response.Response) {
-			log.Print("Response is cached")
+			debugf("Response is cached")
+			p.caughtUp = true
+			break
+		}
+
+		if i == 0 {
+			p.pollETag = response.Header.Get("ETag")
+		}
+
+		fresh, seen := p.fresh(batch, f.dedup, len(f.pollers()) > 1)
+		events = append(events, fresh...)
+		if seen {
+			// The next pages are older, already seen as well.
+			debugf("Reached events already seen")
+			p.caughtUp = true
 			break
 		}
 
-		events = append(events, batch...)
 		opts.Page = response.NextPage
 
 		if response.NextPage == 0 {
 			// All pages were consumed.
+			p.caughtUp = true
 			break
 		}
 	}
 
 	return
 }
+
+// listEvents lists a page of the events at path, unless they didn't change
+// since the ETag if set, in which case the response is 304 Not Modified.
+func (f *EventFeed) listEvents(ctx context.Context, path string, opts *github.ListOptions, etag string) ([]*github.Event, *github.Response, error) {
+	req, err := f.client.NewRequest("GET", path+"?page="+strconv.Itoa(opts.Page), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+		// The response to a conditional request made without the cached
+		// response must not be cached.
+		req.Header.Set("Cache-Control", "no-store")
+	}
+
+	var events []*github.Event
+	response, err := f.client.Do(WithSubsystem(ctx, SubsystemPoller), req, &events)
+	if response != nil {
+		f.metrics.observeResponse(response)
+	}
+	return events, response, err
+}
+
+// saveCheckpoint records the events published by a source in the checkpoint
+// store and the deduplicator's state. Failures are reported, polling goes
+// on.
+func (f *EventFeed) saveCheckpoint(p *sourcePoller, events []*github.Event) {
+	f.checkpointMu.Lock()
+	defer f.checkpointMu.Unlock()
+
+	if f.dedup != nil {
+		if err := f.dedup.Save(); err != nil {
+			f.ReportError(OpPoll, "", err)
+		}
+	}
+
+	if f.checkpoints == nil || p.removed {
+		return
+	}
+
+	cp := p.checkpoint
+	for _, ev := range events {
+		if id := ev.GetID(); eventIDAfter(id, cp.EventID) {
+			cp.EventID = id
+		}
+	}
+	if p.pollETag != "" {
+		cp.ETag = p.pollETag
+	}
+	if cp.EventID == p.checkpoint.EventID && cp.ETag == p.checkpoint.ETag {
+		return
+	}
+	cp.Time = f.clock.Now()
+
+	saved := cp
+	if len(f.sources) > 1 {
+		saved = Checkpoint{Time: cp.Time, Sources: make(map[string]Checkpoint, len(f.sources))}
+		for _, other := range f.sources {
+			saved.Sources[other.source.String()] = other.checkpoint
+		}
+		saved.Sources[p.source.String()] = cp
+	}
+
+	if err := f.checkpoints.Save(saved); err != nil {
+		f.ReportError(OpPoll, cp.EventID, err)
+		return
+	}
+	p.checkpoint = cp
+}
@@ -0,0 +1,337 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Upper bounds of the buckets of the poll duration histogram, in seconds.
+var pollDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// feedMetrics are the counters of a feed, see EventFeed.WritePrometheus.
+type feedMetrics struct {
+	// Address of the metrics server started by Serve, see Config.MetricsAddr.
+	addr string
+
+	polls uint64
+	// Poll durations, cumulative per bucket of pollDurationBuckets plus +Inf.
+	pollBuckets [9]uint64
+	pollNanos   uint64
+	pages       uint64
+	batches     uint64
+	events      uint64
+	// Events dropped by the backpressure policy.
+	dropped uint64
+	// Events dropped for being older than Config.MaxEventAge.
+	stale uint64
+	// Events shed while the consumer lagged, see Config.LagShedFilter.
+	shed uint64
+
+	// Rate limit of the last response served by the API.
+	rateLimit     int64
+	rateRemaining int64
+	rateReset     int64
+
+	// Unix time of the last successful poll, zero before the first.
+	lastPoll int64
+	// Sources whose last poll failed, retried.
+	failingSources int32
+	// Sources catching up, see Config.CatchUpAfter.
+	catchingUp int32
+	// Set while the polls slow down for the consumer, see
+	// Config.LagThreshold.
+	lagging int32
+	// Set once a webhook feed listens, and once Serve returned.
+	listening int32
+	stopped   int32
+
+	mu     sync.Mutex
+	errors map[string]uint64
+}
+
+func (m *feedMetrics) observePoll(d time.Duration) {
+	atomic.AddUint64(&m.polls, 1)
+	atomic.AddUint64(&m.pollNanos, uint64(d))
+	for i, le := range pollDurationBuckets {
+		if d.Seconds() <= le {
+			atomic.AddUint64(&m.pollBuckets[i], 1)
+		}
+	}
+	atomic.AddUint64(&m.pollBuckets[len(pollDurationBuckets)], 1)
+}
+
+// observeResponse records a page fetched and the rate limit it reported.
+// Cached responses carry the rate limit of their time.
+func (m *feedMetrics) observeResponse(r *github.Response) {
+	atomic.AddUint64(&m.pages, 1)
+	if r.Rate.Limit == 0 || isCachedResponse(r.Response) {
+		return
+	}
+	atomic.StoreInt64(&m.rateLimit, int64(r.Rate.Limit))
+	atomic.StoreInt64(&m.rateRemaining, int64(r.Rate.Remaining))
+	atomic.StoreInt64(&m.rateReset, r.Rate.Reset.Unix())
+}
+
+func (m *feedMetrics) observeBatch(events []*github.Event) {
+	atomic.AddUint64(&m.batches, 1)
+	atomic.AddUint64(&m.events, uint64(len(events)))
+}
+
+func (m *feedMetrics) observeError(op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.errors == nil {
+		m.errors = make(map[string]uint64)
+	}
+	m.errors[op]++
+}
+
+// WritePrometheus writes the metrics of the feed in the Prometheus text
+// format:
+//
+//	github_feed_poll_duration_seconds            histogram of the polls
+//	github_feed_pages_fetched_total              pages requested, cached ones included
+//	github_feed_batches_published_total          batches published
+//	github_feed_events_published_total           events published, once filtered
+//	github_feed_errors_total{op}                 errors reported, see ReportError
+//	github_feed_rate_limit{,_remaining}          rate limit of the last API response
+//	github_feed_rate_limit_reset_timestamp_seconds
+//	github_feed_last_poll_timestamp_seconds      last successful poll
+//	github_feed_sources_catching_up              sources catching up, see Config.CatchUpAfter
+//	github_feed_queue_depth, _capacity           batches waiting for the consumer
+//	github_feed_queue_spilled                    and spilled, see Config.Backpressure
+//	github_feed_events_dropped_total             events dropped by the backpressure policy
+//	github_feed_events_stale_total               and for being older than Config.MaxEventAge
+//	github_feed_events_shed_total                and shed while the consumer lags
+//	github_feed_lagging                          polls slowed down, see Config.LagThreshold
+//	github_feed_http_cache_hit_ratio             API responses served by the cache
+//	github_feed_api_requests_total{token,subsystem}
+//	                                             API requests sent, see WithSubsystem
+//	github_feed_api_requests_not_modified_total{token,subsystem}
+//	                                             and answered 304 Not Modified
+//	github_feed_token_requests_total{token}      requests per token of Config.AuthTokens
+//	github_feed_token_rate_limited_total{token}  and rejected by its rate limit
+//	github_feed_token_rate_limit_remaining{token}
+//	github_feed_token_benched{token}             benched until its rate limit resets
+//
+// The process-wide metrics (caches, sinks, bandwidth) and the failed polls
+// are written by MetricsHandler, or by WriteCacheMetrics and friends.
+func (f *EventFeed) WritePrometheus(w io.Writer) {
+	m := &f.metrics
+
+	fmt.Fprintf(w, "# HELP %s_poll_duration_seconds Duration of the polls of the events API, every page included.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_poll_duration_seconds histogram\n", statsNamespace)
+	for i, le := range pollDurationBuckets {
+		fmt.Fprintf(w, "%s_poll_duration_seconds_bucket{le=\"%v\"} %d\n", statsNamespace, le, atomic.LoadUint64(&m.pollBuckets[i]))
+	}
+	fmt.Fprintf(w, "%s_poll_duration_seconds_bucket{le=\"+Inf\"} %d\n", statsNamespace, atomic.LoadUint64(&m.pollBuckets[len(pollDurationBuckets)]))
+	fmt.Fprintf(w, "%s_poll_duration_seconds_sum %v\n", statsNamespace, time.Duration(atomic.LoadUint64(&m.pollNanos)).Seconds())
+	fmt.Fprintf(w, "%s_poll_duration_seconds_count %d\n", statsNamespace, atomic.LoadUint64(&m.polls))
+
+	for _, s := range []struct {
+		name, kind, help string
+		value            interface{}
+	}{
+		{"pages_fetched_total", "counter", "Pages of events requested from the API, cached ones included.", atomic.LoadUint64(&m.pages)},
+		{"batches_published_total", "counter", "Batches of events published.", atomic.LoadUint64(&m.batches)},
+		{"events_published_total", "counter", "Events published, once filtered.", atomic.LoadUint64(&m.events)},
+		{"rate_limit", "gauge", "Requests allowed per hour by the API.", atomic.LoadInt64(&m.rateLimit)},
+		{"rate_limit_remaining", "gauge", "Requests left until the rate limit resets.", atomic.LoadInt64(&m.rateRemaining)},
+		{"rate_limit_reset_timestamp_seconds", "gauge", "Unix time at which the rate limit resets.", atomic.LoadInt64(&m.rateReset)},
+		{"last_poll_timestamp_seconds", "gauge", "Unix time of the last successful poll.", atomic.LoadInt64(&m.lastPoll)},
+		{"sources_catching_up", "gauge", "Sources polled faster to catch up with the events created while the feed was down.", atomic.LoadInt32(&m.catchingUp)},
+		{"queue_depth", "gauge", "Batches published and waiting for the consumer.", len(f.events)},
+		{"queue_capacity", "gauge", "Batches buffered for the consumer before the backpressure policy applies.", cap(f.events)},
+		{"queue_spilled", "gauge", "Batches spilled to disk and waiting for room in the queue.", f.spillDepth()},
+		{"events_dropped_total", "counter", "Events dropped by the backpressure policy of a full queue.", atomic.LoadUint64(&m.dropped)},
+		{"events_stale_total", "counter", "Events dropped for being older than the maximum event age.", atomic.LoadUint64(&m.stale)},
+		{"events_shed_total", "counter", "Events shed while the consumer lagged behind the polls.", atomic.LoadUint64(&m.shed)},
+		{"lagging", "gauge", "Whether the polls slow down for the consumer lagging behind.", atomic.LoadInt32(&m.lagging)},
+		{"http_cache_hit_ratio", "gauge", "Fraction of the API requests served by the cache.", f.cache.hitRatio()},
+	} {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n", statsNamespace, s.name, s.help)
+		fmt.Fprintf(w, "# TYPE %s_%s %s\n", statsNamespace, s.name, s.kind)
+		fmt.Fprintf(w, "%s_%s %v\n", statsNamespace, s.name, s.value)
+	}
+
+	m.mu.Lock()
+	ops := make([]string, 0, len(m.errors))
+	for op := range m.errors {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	fmt.Fprintf(w, "# HELP %s_errors_total Non-fatal errors reported by operation, sinks included.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_errors_total counter\n", statsNamespace)
+	for _, op := range ops {
+		fmt.Fprintf(w, "%s_errors_total{op=\"%s\"} %d\n", statsNamespace, labelEscaper.Replace(op), m.errors[op])
+	}
+	m.mu.Unlock()
+
+	requests := f.RequestCounts()
+	fmt.Fprintf(w, "# HELP %s_api_requests_total API requests sent by token and subsystem.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_api_requests_total counter\n", statsNamespace)
+	for _, c := range requests {
+		fmt.Fprintf(w, "%s_api_requests_total{token=\"%d\",subsystem=\"%s\"} %d\n", statsNamespace, c.Token, labelEscaper.Replace(c.Subsystem), c.Requests)
+	}
+	fmt.Fprintf(w, "# HELP %s_api_requests_not_modified_total API requests answered 304 Not Modified, free of the rate limit, by token and subsystem.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_api_requests_not_modified_total counter\n", statsNamespace)
+	for _, c := range requests {
+		fmt.Fprintf(w, "%s_api_requests_not_modified_total{token=\"%d\",subsystem=\"%s\"} %d\n", statsNamespace, c.Token, labelEscaper.Replace(c.Subsystem), c.NotModified)
+	}
+
+	tokens := f.TokenStats()
+	if len(tokens) == 0 {
+		return
+	}
+	for _, s := range []struct {
+		name, kind, help string
+		value            func(TokenStats) interface{}
+	}{
+		{"token_requests_total", "counter", "Requests authenticated with a token of the pool.", func(s TokenStats) interface{} { return s.Requests }},
+		{"token_rate_limited_total", "counter", "Requests rejected by the rate limit of a token of the pool.", func(s TokenStats) interface{} { return s.RateLimited }},
+		{"token_rate_limit_remaining", "gauge", "Requests left to a token of the pool, -1 before its first.", func(s TokenStats) interface{} { return s.Remaining }},
+		{"token_benched", "gauge", "Whether a token of the pool is benched until its rate limit resets.", func(s TokenStats) interface{} {
+			if s.Benched {
+				return 1
+			}
+			return 0
+		}},
+	} {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n", statsNamespace, s.name, s.help)
+		fmt.Fprintf(w, "# TYPE %s_%s %s\n", statsNamespace, s.name, s.kind)
+		for _, t := range tokens {
+			fmt.Fprintf(w, "%s_%s{token=\"%d\"} %v\n", statsNamespace, s.name, t.Token, s.value(t))
+		}
+	}
+}
+
+// FeedReport are the totals of a feed, e.g. for the report of a run written
+// on exit, see EventFeed.Report.
+type FeedReport struct {
+	Polls   uint64 `json:"polls"`
+	Pages   uint64 `json:"pages"`
+	Batches uint64 `json:"batches"`
+	Events  uint64 `json:"events"`
+	// Events not published, by reason: backpressure, stale (see
+	// Config.MaxEventAge) and shed (see Config.LagShedFilter).
+	Dropped map[string]uint64 `json:"dropped"`
+	// Errors reported by operation, see ReportError.
+	Errors map[string]uint64 `json:"errors,omitempty"`
+	// Last checkpoint saved or resumed from, nil without Config.Checkpoints.
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+	// API requests sent per token and subsystem, see RequestCounts.
+	Requests []RequestCount `json:"requests,omitempty"`
+}
+
+// Report returns the totals of the feed, those of WritePrometheus.
+func (f *EventFeed) Report() FeedReport {
+	m := &f.metrics
+	r := FeedReport{
+		Polls:   atomic.LoadUint64(&m.polls),
+		Pages:   atomic.LoadUint64(&m.pages),
+		Batches: atomic.LoadUint64(&m.batches),
+		Events:  atomic.LoadUint64(&m.events),
+		Dropped: map[string]uint64{
+			"backpressure": atomic.LoadUint64(&m.dropped),
+			"stale":        atomic.LoadUint64(&m.stale),
+			"shed":         atomic.LoadUint64(&m.shed),
+		},
+	}
+
+	m.mu.Lock()
+	if len(m.errors) > 0 {
+		r.Errors = make(map[string]uint64, len(m.errors))
+		for op, n := range m.errors {
+			r.Errors[op] = n
+		}
+	}
+	m.mu.Unlock()
+
+	if f.checkpoints != nil {
+		f.checkpointMu.Lock()
+		cp := f.sources[0].checkpoint
+		if len(f.sources) > 1 {
+			cp = Checkpoint{Sources: make(map[string]Checkpoint, len(f.sources))}
+			for _, p := range f.sources {
+				cp.Sources[p.source.String()] = p.checkpoint
+				if p.checkpoint.Time.After(cp.Time) {
+					cp.Time = p.checkpoint.Time
+				}
+			}
+		}
+		f.checkpointMu.Unlock()
+		r.Checkpoint = &cp
+	}
+	r.Requests = f.RequestCounts()
+	return r
+}
+
+// Healthy tells whether the feed is live: Serve didn't return.
+func (f *EventFeed) Healthy() bool {
+	return atomic.LoadInt32(&f.metrics.stopped) == 0 && f.ctx.Err() == nil
+}
+
+// Ready tells whether the feed publishes events: a poll succeeded and the
+// last poll of every source did, or the webhook feed listens. Paused and
+// throttled feeds are ready.
+func (f *EventFeed) Ready() bool {
+	if !f.Healthy() {
+		return false
+	}
+	if f.webhook != nil {
+		return atomic.LoadInt32(&f.metrics.listening) != 0
+	}
+	return atomic.LoadInt64(&f.metrics.lastPoll) != 0 && atomic.LoadInt32(&f.metrics.failingSources) == 0
+}
+
+// MetricsHandler serves the metrics of the feed and of the process on
+// /metrics, see WritePrometheus, and its probes for Kubernetes on /healthz
+// (see Healthy) and /readyz (see Ready), 503 Service Unavailable when they
+// fail. It may be mounted on another server instead of setting
+// Config.MetricsAddr.
+func (f *EventFeed) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		f.WritePrometheus(w)
+		f.Failures().WritePrometheus(w)
+		WriteCacheMetrics(w)
+		WriteBandwidthMetrics(w)
+		WriteSinkMetrics(w)
+	})
+	mux.Handle("/healthz", probeHandler(f.Healthy))
+	mux.Handle("/readyz", probeHandler(f.Ready))
+	return mux
+}
+
+func probeHandler(ok func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ok() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// startMetricsServer serves MetricsHandler on Config.MetricsAddr until the
+// returned function is called.
+func (f *EventFeed) startMetricsServer() (func(), error) {
+	l, err := net.Listen("tcp", f.metrics.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: f.MetricsHandler()}
+	go server.Serve(l)
+
+	return func() { server.Close() }, nil
+}
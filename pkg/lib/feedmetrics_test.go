@@ -0,0 +1,85 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestFeedMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Poll-Interval", "60")
+		w.Header().Set("X-RateLimit-Limit", "5000")
+		w.Header().Set("X-RateLimit-Remaining", "4999")
+		w.Header().Set("X-RateLimit-Reset", "1600000000")
+		w.Write([]byte(`[{"id":"2","type":"PushEvent","actor":{"login":"a"}},{"id":"1","type":"PushEvent","actor":{"login":"b"}}]`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	feed, _, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: server.URL, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := feed.MetricsHandler()
+
+	get := func(path string) (int, string) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Errorf("got /healthz %d before Serve, want 200", code)
+	}
+	if code, _ := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("got /readyz %d before the first poll, want 503", code)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- feed.Serve() }()
+	waitForTimer(t, clock)
+
+	if code, _ := get("/readyz"); code != http.StatusOK {
+		t.Errorf("got /readyz %d after a poll, want 200", code)
+	}
+
+	feed.ReportError(lib.OpSink, "1", errors.New("unavailable"))
+
+	_, metrics := get("/metrics")
+	for _, want := range []string{
+		"github_feed_poll_duration_seconds_count 1\n",
+		"github_feed_pages_fetched_total 1\n",
+		"github_feed_events_published_total 2\n",
+		"github_feed_rate_limit_remaining 4999\n",
+		"github_feed_rate_limit_reset_timestamp_seconds 1600000000\n",
+		"github_feed_queue_depth 1\n",
+		`github_feed_errors_total{op="sink"} 1` + "\n",
+		"github_feed_http_cache_hit_ratio ",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("missing %q in metrics:\n%s", want, metrics)
+		}
+	}
+
+	report := feed.Report()
+	if report.Polls != 1 || report.Events != 2 || report.Errors[lib.OpSink] != 1 || report.Dropped["backpressure"] != 0 || report.Checkpoint != nil {
+		t.Errorf("got the report %+v", report)
+	}
+
+	cancel()
+	<-served
+	if code, _ := get("/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("got /healthz %d once stopped, want 503", code)
+	}
+}
@@ -0,0 +1,218 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Filter is a compiled filter expression. An expression is a whitespace
+// separated list of terms which must all match, each term being one of
+//
+//	field=value[,value...]   the field equals (case-insensitive) any value
+//	field!=value[,value...]  the field equals none of the values
+//	field~=regex             the field matches the regular expression
+//
+// Fields yielding multiple values (e.g. labels) match if any value does. The
+// supported fields are listed in filterFields.
+//
+//	type=PullRequestEvent label=bug,security
+//	type=IssuesEvent title~=(?i)^\[rfc\]
+type Filter struct {
+	expr  string
+	terms []filterTerm
+}
+
+type filterTerm struct {
+	field  string
+	op     string
+	values []string
+	re     *regexp.Regexp
+}
+
+// eventView lazily parses the payload of an event so that a filter with many
+// payload terms only decodes it once.
+type eventView struct {
+	ev      *github.Event
+	parsed  bool
+	payload interface{}
+}
+
+func (v *eventView) Payload() interface{} {
+	if !v.parsed {
+		v.parsed = true
+		// ParsePayload dereferences the raw payload.
+		if v.ev.RawPayload != nil {
+			v.payload, _ = v.ev.ParsePayload()
+		}
+	}
+	return v.payload
+}
+
+var filterFields = map[string]func(v *eventView) []string{
+	"type":   func(v *eventView) []string { return []string{v.ev.GetType()} },
+	"actor":  func(v *eventView) []string { return []string{v.ev.GetActor().GetLogin()} },
+	"repo":   func(v *eventView) []string { return []string{v.ev.GetRepo().GetName()} },
+	"org":    func(v *eventView) []string { return []string{v.ev.GetOrg().GetLogin()} },
+	"action": payloadAction,
+	"label":  payloadLabels,
+	"title":  payloadTitle,
+}
+
+func payloadAction(v *eventView) []string {
+	switch p := v.Payload().(type) {
+	case *github.PullRequestEvent:
+		return []string{p.GetAction()}
+	case *github.IssuesEvent:
+		return []string{p.GetAction()}
+	case *github.IssueCommentEvent:
+		return []string{p.GetAction()}
+	case *github.PullRequestReviewEvent:
+		return []string{p.GetAction()}
+	case *github.PullRequestReviewCommentEvent:
+		return []string{p.GetAction()}
+	case *github.ReleaseEvent:
+		return []string{p.GetAction()}
+	case *github.MemberEvent:
+		return []string{p.GetAction()}
+	case *github.WatchEvent:
+		return []string{p.GetAction()}
+	}
+	return nil
+}
+
+// Pull requests and issues are the only payloads carrying labels and titles.
+func payloadIssueOrPR(v *eventView) (*github.Issue, *github.PullRequest) {
+	switch p := v.Payload().(type) {
+	case *github.PullRequestEvent:
+		return nil, p.PullRequest
+	case *github.PullRequestReviewEvent:
+		return nil, p.PullRequest
+	case *github.PullRequestReviewCommentEvent:
+		return nil, p.PullRequest
+	case *github.IssuesEvent:
+		return p.Issue, nil
+	case *github.IssueCommentEvent:
+		return p.Issue, nil
+	}
+	return nil, nil
+}
+
+func payloadLabels(v *eventView) []string {
+	var labels []*github.Label
+
+	issue, pr := payloadIssueOrPR(v)
+	if issue != nil {
+		labels = issue.Labels
+	} else if pr != nil {
+		labels = pr.Labels
+	}
+
+	names := make([]string, 0, len(labels))
+	for _, l := range labels {
+		names = append(names, l.GetName())
+	}
+	return names
+}
+
+func payloadTitle(v *eventView) []string {
+	issue, pr := payloadIssueOrPR(v)
+	if issue != nil {
+		return []string{issue.GetTitle()}
+	} else if pr != nil {
+		return []string{pr.GetTitle()}
+	}
+	return nil
+}
+
+// ParseFilter compiles a filter expression, an empty expression matches every
+// event.
+func ParseFilter(expr string) (*Filter, error) {
+	f := &Filter{expr: expr}
+
+	for _, token := range strings.Fields(expr) {
+		term, err := parseFilterTerm(token)
+		if err != nil {
+			return nil, err
+		}
+		f.terms = append(f.terms, term)
+	}
+
+	return f, nil
+}
+
+func parseFilterTerm(token string) (filterTerm, error) {
+	var term filterTerm
+
+	i := strings.Index(token, "=")
+	if i <= 0 {
+		return term, fmt.Errorf("filter term '%s': expected field=value, field!=value or field~=regex", token)
+	}
+
+	term.field, term.op = token[:i], "="
+	if last := token[i-1]; last == '!' || last == '~' {
+		term.field, term.op = token[:i-1], token[i-1:i+1]
+	}
+
+	if _, ok := filterFields[term.field]; !ok {
+		return term, fmt.Errorf("filter term '%s': unknown field '%s'", token, term.field)
+	}
+
+	value := token[i+1:]
+	if term.op == "~=" {
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return term, fmt.Errorf("filter term '%s': %v", token, err)
+		}
+		term.re = re
+	} else {
+		term.values = strings.Split(value, ",")
+	}
+
+	return term, nil
+}
+
+func (t *filterTerm) matchValue(value string) bool {
+	if t.re != nil {
+		return t.re.MatchString(value)
+	}
+
+	for _, expected := range t.values {
+		if strings.EqualFold(expected, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *filterTerm) match(v *eventView) bool {
+	found := false
+	for _, value := range filterFields[t.field](v) {
+		if t.matchValue(value) {
+			found = true
+			break
+		}
+	}
+
+	if t.op == "!=" {
+		return !found
+	}
+	return found
+}
+
+// Match reports whether the event satisfies every term of the filter.
+func (f *Filter) Match(ev *github.Event) bool {
+	v := &eventView{ev: ev}
+	for i := range f.terms {
+		if !f.terms[i].match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Filter) String() string {
+	return f.expr
+}
@@ -0,0 +1,121 @@
+//go:build go1.18
+// +build go1.18
+
+package lib_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// Payloads seen on the public stream, including truncated and mistyped
+// ones.
+var payloadSeeds = []string{
+	`{"action":"opened","pull_request":{"title":"Fix","labels":[{"name":"bug"}]}}`,
+	`{"action":"created","issue":{"title":"Crash","labels":[{"name":null}]},"comment":{}}`,
+	`{"action":"created","sponsorship":{"sponsorable":{"login":"a"},"sponsor":{"login":"b"},"tier":{"name":"$5","monthly_price_in_cents":500}},"changes":{"tier":{"from":{"name":"$1"}}}}`,
+	`{"action":"opened","pull_request":{"title":`,
+	`{"action":1,"issue":"x","pull_request":[]}`,
+	`{"sponsorship":null,"changes":{"tier":null}}`,
+	`{"commits":[{"sha":"abc","author":{"email":"a@b.c"}}],"size":1e400}`,
+	`null`,
+	`[]`,
+	`"payload"`,
+	``,
+}
+
+var eventTypeSeeds = []string{"PullRequestEvent", "IssuesEvent", "IssueCommentEvent", "SponsorshipEvent", "PushEvent", ""}
+
+func FuzzEventPayload(f *testing.F) {
+	for i, payload := range payloadSeeds {
+		f.Add(eventTypeSeeds[i%len(eventTypeSeeds)], []byte(payload))
+	}
+
+	filters := []string{"action=opened", "label=bug", "title~=(?i)crash", "type=PushEvent action!=closed"}
+	keys := []string{"payload.action", "payload.pull_request.labels.0.name", "{{.type}}/{{.payload.action}}"}
+
+	f.Fuzz(func(t *testing.T, typ string, payload []byte) {
+		raw := json.RawMessage(payload)
+		id, login := "1", "user[bot]"
+		ev := &github.Event{ID: &id, Type: &typ, Actor: &github.User{Login: &login}, RawPayload: &raw}
+
+		for _, expr := range filters {
+			filter, err := lib.ParseFilter(expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter.Match(ev)
+		}
+
+		lib.NewSponsorshipTracker(nil).Track([]*github.Event{ev})
+		lib.NewEventStats(nil).Observe([]*github.Event{ev})
+
+		// Invalid payloads can't be encoded, and are reported by the feed.
+		if !json.Valid(payload) {
+			return
+		}
+
+		for _, spec := range keys {
+			lib.EventKey(ev, spec)
+		}
+
+		b, err := json.Marshal(lib.NewRecord(ev))
+		if err != nil {
+			return
+		}
+		if err := lib.VerifyRecord(b); err != nil {
+			t.Fatalf("record %s: %v", b, err)
+		}
+	})
+}
+
+func FuzzRecord(f *testing.F) {
+	f.Add([]byte(`{"id":"1","type":"PushEvent","payload":{"size":1.50}}`))
+	f.Add([]byte(`{"schema_version":1,"id":"1","actor":{"login":"a<b>"}}`))
+	f.Add([]byte(`{"schema_version":2,"digest":"sha256:00","id":"1"}`))
+	f.Add([]byte(`{"schema_version":"2"}`))
+	f.Add([]byte(`{"schema_version":99}`))
+	f.Add([]byte(`{"id":"1","payload":{"a":[1,2,{"b":`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Records without digest must verify once migrated.
+		if lib.VerifyRecord(data) == lib.ErrNoDigest {
+			if migrated, err := lib.MigrateRecord(data, lib.SchemaVersion); err == nil {
+				if err := lib.VerifyRecord(migrated); err != nil && err != lib.ErrNoDigest {
+					t.Fatalf("migrated record %s: %v", migrated, err)
+				}
+			}
+		}
+
+		canonical, err := lib.Canonicalize(data)
+		if err != nil {
+			return
+		}
+		again, err := lib.Canonicalize(canonical)
+		if err != nil {
+			t.Fatalf("canonical %s: %v", canonical, err)
+		}
+		if !bytes.Equal(canonical, again) {
+			t.Fatalf("canonicalization is not idempotent: %s != %s", canonical, again)
+		}
+	})
+}
+
+func FuzzFilter(f *testing.F) {
+	for _, expr := range []string{"type=PushEvent", "label!=bug,wontfix title~=^fix", "nope=1", "title~=(", "=", "action"} {
+		f.Add(expr)
+	}
+
+	f.Fuzz(func(t *testing.T, expr string) {
+		filter, err := lib.ParseFilter(expr)
+		if err != nil {
+			return
+		}
+		filter.Match(&github.Event{})
+		_ = filter.String()
+	})
+}
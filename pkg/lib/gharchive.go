@@ -0,0 +1,304 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Dumps are large (up to a few hundred MB uncompressed), downloading one
+// may take a while.
+const ghArchiveTimeout = 30 * time.Minute
+
+// errGHArchiveMissing is returned for the hours without dump, either not
+// published yet or lost by GH Archive, which are skipped.
+var errGHArchiveMissing = errors.New("dump not found")
+
+// ghArchiveReplay streams the dumps of a range of hours, see NewArchiveFeed.
+type ghArchiveReplay struct {
+	baseURL  string
+	client   *http.Client
+	from, to time.Time
+	speed    float64
+	retry    retryPolicy
+
+	// Keeps the events replayed, all if nil, e.g. those of the gap of a
+	// source catching up.
+	keep func(ev *github.Event) bool
+
+	// Creation time of the first event published, and when it was, which
+	// pace the following ones.
+	first, start time.Time
+}
+
+// NewArchiveFeed returns a feed replaying the events of GH Archive
+// (https://www.gharchive.org) created between conf.GHArchiveFrom and
+// conf.GHArchiveTo instead of polling the events API, e.g. to load test the
+// consumers with a realistic day of activity, or to backfill them. The
+// hourly dumps are downloaded from conf.GHArchiveURL one at a time, and
+// their events published in batches of those created within the same
+// second, at conf.GHArchiveSpeed times their original pace. The feed is
+// otherwise the same as NewEventFeed's, its filters and errors included.
+//
+// Serve returns once the range is replayed. Failed downloads are retried
+// like failed polls (see Config.MaxConsecutiveFailures), resuming after the
+// events already published. The hours without dump are reported and
+// skipped.
+func NewArchiveFeed(ctx context.Context, conf *Config) (*EventFeed, <-chan []*github.Event, error) {
+	if conf.GHArchiveFrom.IsZero() {
+		return nil, nil, errors.New("archive feeds require GHArchiveFrom")
+	}
+
+	feed, events, err := NewEventFeed(ctx, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	baseURL := conf.GHArchiveURL
+	if baseURL == "" {
+		baseURL = GHArchiveURL
+	}
+	to := conf.GHArchiveTo
+	if to.IsZero() {
+		to = feed.clock.Now()
+	}
+	if !conf.GHArchiveFrom.Before(to) {
+		return nil, nil, fmt.Errorf("empty GH Archive range [%s, %s)",
+			conf.GHArchiveFrom.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	feed.gharchive = &ghArchiveReplay{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{
+			Transport: NewMeteredTransport("gharchive", nil, conf.MaxBandwidth),
+			Timeout:   ghArchiveTimeout,
+		},
+		from:  conf.GHArchiveFrom.UTC(),
+		to:    to.UTC(),
+		speed: conf.GHArchiveSpeed,
+		retry: newRetryPolicy(conf),
+	}
+
+	return feed, events, nil
+}
+
+// serveGHArchive replays the dumps of the range, hour by hour.
+func (f *EventFeed) serveGHArchive() error {
+	defer f.closeEvents()
+	return f.replayArchive(f.ctx, f.gharchive)
+}
+
+// replayArchive publishes the events of the range of r until ctx is done.
+func (f *EventFeed) replayArchive(ctx context.Context, r *ghArchiveReplay) error {
+	hour := r.from.Truncate(time.Hour)
+	// Lines of the hour's dump already replayed, skipped by the retries.
+	done := 0
+	for hour.Before(r.to) {
+		if err := f.waitResumed(ctx); err != nil {
+			return err
+		}
+
+		n, err := f.replayHour(ctx, r, hour, done)
+		done += n
+		switch {
+		case err == nil:
+		case errors.Is(err, errGHArchiveMissing):
+			f.ReportError(OpPoll, "", fmt.Errorf("GH Archive %s: %w, skipped", ghArchiveName(hour), err))
+		case ctx.Err() != nil:
+			return ctx.Err()
+		default:
+			wait, err := f.ghArchiveFailed(r, hour, err)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case <-f.clock.After(wait):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if r.retry.failures > 0 {
+			atomic.AddInt32(&f.metrics.failingSources, -1)
+		}
+		r.retry.succeeded()
+		hour, done = hour.Add(time.Hour), 0
+	}
+
+	log.Printf("GH Archive replay of [%s, %s) complete", r.from.Format(time.RFC3339), r.to.Format(time.RFC3339))
+	return nil
+}
+
+// ghArchiveFailed accounts a failed download and returns the wait before
+// retrying it, or the error stopping the feed.
+func (f *EventFeed) ghArchiveFailed(r *ghArchiveReplay, hour time.Time, err error) (time.Duration, error) {
+	class, retryAfter := classifyPollError(err)
+	atomic.AddUint64(&f.failures[class], 1)
+	if class == failureClient {
+		return 0, fmt.Errorf("GH Archive %s: %w", ghArchiveName(hour), err)
+	}
+
+	wait, ok := r.retry.failed(retryAfter)
+	if r.retry.failures == 1 {
+		atomic.AddInt32(&f.metrics.failingSources, 1)
+	}
+	if !ok {
+		return 0, fmt.Errorf("GH Archive %s: %d consecutive failed downloads: %w", ghArchiveName(hour), r.retry.failures, err)
+	}
+	f.ReportError(OpPoll, "", fmt.Errorf("GH Archive %s: retrying in %v: %w", ghArchiveName(hour), wait.Round(time.Millisecond), err))
+	return wait, nil
+}
+
+// replayHour publishes the events of the dump of an hour, after its first
+// skip lines, and returns the number of lines replayed, until the failure if
+// any.
+func (f *EventFeed) replayHour(ctx context.Context, r *ghArchiveReplay, hour time.Time, skip int) (int, error) {
+	u := r.baseURL + "/" + ghArchiveName(hour)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	Identify(req)
+
+	rep, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rep.Body.Close()
+
+	switch {
+	case rep.StatusCode == http.StatusNotFound:
+		return 0, errGHArchiveMissing
+	case rep.StatusCode != http.StatusOK:
+		msg, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 1024))
+		// Classified like the API's errors, 5xx are retried.
+		return 0, &github.ErrorResponse{Response: rep, Message: strings.TrimSpace(string(msg))}
+	}
+
+	gz, err := gzip.NewReader(rep.Body)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	var batch []*github.Event
+	// Lines read, up to the last event batched, and replayed.
+	line, last, replayed := 0, 0, 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := r.pace(ctx, f, batch[0].GetCreatedAt()); err != nil {
+			return err
+		}
+		f.checkEvents(batch)
+		if err := f.publish(ctx, f.filterEvents(batch)); err != nil {
+			return err
+		}
+		atomic.StoreInt64(&f.metrics.lastPoll, f.clock.Now().Unix())
+		batch, replayed = nil, last
+		return nil
+	}
+
+	body := &failureRecorder{r: gz}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		// The last line of a failed download is truncated, and replayed by
+		// the retry.
+		if atEOF && body.err != io.EOF && bytes.IndexByte(data, '\n') < 0 {
+			return 0, nil, body.err
+		}
+		return bufio.ScanLines(data, atEOF)
+	})
+	for scanner.Scan() {
+		if line++; line <= skip {
+			replayed = line
+			continue
+		}
+
+		var ev github.Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			f.ReportError(OpParse, "", fmt.Errorf("GH Archive %s line %d: %v", ghArchiveName(hour), line, err))
+			continue
+		}
+		created := ev.GetCreatedAt()
+		if created.Before(r.from) || !created.Before(r.to) || (r.keep != nil && !r.keep(&ev)) {
+			continue
+		}
+
+		// Batches are the events of a second, which GH Archive's times
+		// don't tell apart.
+		if len(batch) > 0 && (!created.Equal(batch[0].GetCreatedAt()) || len(batch) == maximumEventsPerPoll) {
+			if err := flush(); err != nil {
+				return replayed - skip, err
+			}
+		}
+		batch, last = append(batch, &ev), line
+	}
+
+	// The events decoded before a failure are complete, and published.
+	if err := flush(); err != nil {
+		return replayed - skip, err
+	}
+	if line < skip {
+		// Failed before the lines already replayed.
+		line = skip
+	}
+	if err := scanner.Err(); err != nil {
+		return line - skip, fmt.Errorf("GET %s: %v", u, err)
+	}
+	return line - skip, nil
+}
+
+// pace waits until the events created at the given time are due.
+func (r *ghArchiveReplay) pace(ctx context.Context, f *EventFeed, created time.Time) error {
+	if r.speed <= 0 {
+		return nil
+	}
+	if r.first.IsZero() {
+		r.first, r.start = created, f.clock.Now()
+	}
+
+	due := r.start.Add(time.Duration(float64(created.Sub(r.first)) / r.speed))
+	wait := due.Sub(f.clock.Now())
+	if wait <= 0 {
+		return nil
+	}
+
+	select {
+	case <-f.clock.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// failureRecorder records the error ending a read stream.
+type failureRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (r *failureRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
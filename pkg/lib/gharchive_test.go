@@ -0,0 +1,162 @@
+package lib_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func ghArchiveDump(t *testing.T, events ...string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, ev := range events {
+		fmt.Fprintln(gz, ev)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func ghArchiveEvent(id, created string) string {
+	return fmt.Sprintf(`{"id":"%s","type":"PushEvent","actor":{"id":1,"login":"octocat"},`+
+		`"repo":{"id":1,"name":"octocat/Hello-World"},"payload":{},"public":true,"created_at":"%s"}`, id, created)
+}
+
+func TestArchiveFeed(t *testing.T) {
+	dumps := map[string][]byte{
+		"/2015-01-01-9.json.gz": ghArchiveDump(t,
+			ghArchiveEvent("1", "2015-01-01T09:59:59Z"),
+			strings.Replace(ghArchiveEvent("2", "2015-01-01T09:59:59Z"), "octocat", "dependabot[bot]", 1),
+			ghArchiveEvent("3", "2015-01-01T09:59:59Z"),
+		),
+		"/2015-01-01-10.json.gz": ghArchiveDump(t,
+			ghArchiveEvent("4", "2015-01-01T10:00:00Z"),
+			ghArchiveEvent("5", "2015-01-01T10:00:00Z"),
+			`{"id":`,
+			ghArchiveEvent("6", "2015-01-01T10:00:01Z"),
+			ghArchiveEvent("7", "2015-01-01T10:30:00Z"),
+		),
+		// 11 is missing.
+		"/2015-01-01-12.json.gz": ghArchiveDump(t,
+			ghArchiveEvent("8", "2015-01-01T12:00:00Z"),
+			ghArchiveEvent("9", "2015-01-01T12:10:00Z"),
+		),
+	}
+
+	var requests, truncated int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		dump, ok := dumps[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path == "/2015-01-01-10.json.gz" && atomic.CompareAndSwapInt32(&truncated, 0, 1) {
+			// Fails midway, the retry resumes after the events published.
+			w.Write(dump[:len(dump)*2/3])
+			return
+		}
+		w.Write(dump)
+	}))
+	defer server.Close()
+
+	from, _ := time.Parse(time.RFC3339, "2015-01-01T09:59:59Z")
+	to, _ := time.Parse(time.RFC3339, "2015-01-01T12:05:00Z")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feed, events, err := lib.NewFeed(ctx, &lib.Config{
+		GHArchiveFrom: from,
+		GHArchiveTo:   to,
+		GHArchiveURL:  server.URL,
+		RetryBackoff:  time.Millisecond,
+		Filter:        lib.EventFilter{ExcludeActors: []string{"*[bot]"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- feed.Serve() }()
+
+	var batches []string
+	seen := make(map[string]int)
+	for batch := range events {
+		var ids []string
+		for _, ev := range batch {
+			ids = append(ids, ev.GetID())
+			seen[ev.GetID()]++
+		}
+		batches = append(batches, strings.Join(ids, ","))
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	for _, id := range []string{"1", "3", "4", "5", "6", "7", "8"} {
+		if seen[id] != 1 {
+			t.Errorf("event %s published %d times, want once (batches %v)", id, seen[id], batches)
+		}
+	}
+	if seen["2"] != 0 || seen["9"] != 0 {
+		t.Errorf("expected the filtered events and those past the range dropped, got batches %v", batches)
+	}
+	if batches[0] != "1,3" {
+		t.Errorf("expected the events of a second batched together, got batches %v", batches)
+	}
+
+	var reported []string
+	for len(feed.Errors()) > 0 {
+		reported = append(reported, (<-feed.Errors()).Error())
+	}
+	all := strings.Join(reported, "\n")
+	if !strings.Contains(all, "line 3") || !strings.Contains(all, "retrying") || !strings.Contains(all, "2015-01-01-11.json.gz: dump not found") {
+		t.Errorf("expected the malformed line, the retry and the missing hour reported, got:\n%s", all)
+	}
+	if n := atomic.LoadInt32(&requests); n != 5 {
+		t.Errorf("got %d requests, want 5", n)
+	}
+}
+
+func TestArchiveFeedSpeed(t *testing.T) {
+	dump := ghArchiveDump(t,
+		ghArchiveEvent("1", "2015-01-01T10:00:00Z"),
+		ghArchiveEvent("2", "2015-01-01T10:00:10Z"),
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(dump)
+	}))
+	defer server.Close()
+
+	from, _ := time.Parse(time.RFC3339, "2015-01-01T10:00:00Z")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feed, events, err := lib.NewArchiveFeed(ctx, &lib.Config{
+		GHArchiveFrom:  from,
+		GHArchiveTo:    from.Add(time.Hour),
+		GHArchiveURL:   server.URL,
+		GHArchiveSpeed: 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+
+	<-events
+	start := time.Now()
+	<-events
+	// 10 seconds apart, at 100 times their pace.
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("second event published after %v, want 100ms", elapsed)
+	}
+}
@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// feedHooks are the callbacks registered on a feed, see EventFeed.OnBatch.
+type feedHooks struct {
+	// Serializes the deliveries, as a single consumer of the channel would.
+	mu         sync.Mutex
+	onBatch    func([]*github.Event)
+	onEvent    func(*github.Event)
+	onError    func(*FeedError)
+	onThrottle func(Source, time.Duration)
+	onDrop     func([]*github.Event)
+}
+
+// OnBatch registers a callback receiving the published batches instead of
+// the events channel, which is then left empty, for applications embedding
+// the feed which prefer callbacks: the batches are delivered from the
+// polling goroutine, without a channel hop. Deliveries are serialized, even
+// with several sources, and hold the polls back until they return, as a
+// full channel would. Batches may be empty, see OnEvent.
+//
+// The hooks must be registered before Serve.
+func (f *EventFeed) OnBatch(fn func(events []*github.Event)) {
+	f.hooks.onBatch = fn
+}
+
+// OnEvent registers a callback receiving the published events one at a
+// time, after the OnBatch callback of their batch if any. Like OnBatch, it
+// replaces the events channel.
+func (f *EventFeed) OnEvent(fn func(ev *github.Event)) {
+	f.hooks.onEvent = fn
+}
+
+// OnError registers a callback receiving the non-fatal errors instead of the
+// errors channel, none are dropped. It is called by the goroutine reporting
+// the error (see ReportError), possibly concurrently.
+func (f *EventFeed) OnError(fn func(err *FeedError)) {
+	f.hooks.onError = fn
+}
+
+// OnThrottle registers a callback notified when a source exceeded the rate
+// limit, with the time until it resets, during which the source isn't
+// polled.
+func (f *EventFeed) OnThrottle(fn func(source Source, wait time.Duration)) {
+	f.hooks.onThrottle = fn
+}
+
+// OnDrop registers a callback receiving the batches dropped by the
+// backpressure policy, see Config.Backpressure. It is called by the
+// goroutine publishing, possibly concurrently, and should not block.
+func (f *EventFeed) OnDrop(fn func(events []*github.Event)) {
+	f.hooks.onDrop = fn
+}
+
+// dropStale returns the events of the batch created at most
+// Config.MaxEventAge ago, the batch itself if they all were.
+func (f *EventFeed) dropStale(events []*github.Event) []*github.Event {
+	if f.maxEventAge <= 0 {
+		return events
+	}
+
+	cutoff := f.clock.Now().Add(-f.maxEventAge)
+	kept := make([]*github.Event, 0, len(events))
+	for _, ev := range events {
+		if created := ev.GetCreatedAt(); created.IsZero() || !created.Before(cutoff) {
+			kept = append(kept, ev)
+		}
+	}
+	if len(kept) == len(events) {
+		return events
+	}
+
+	stale := len(events) - len(kept)
+	atomic.AddUint64(&f.metrics.stale, uint64(stale))
+	debugf("Dropped %d events older than %s", stale, f.maxEventAge)
+	return kept
+}
+
+// publish delivers a batch to the callbacks, or queues it on the events
+// channel without them, split as configured by Config.MaxBatchEvents. It
+// fails if ctx or the feed's context is done first.
+func (f *EventFeed) publish(ctx context.Context, events []*github.Event) error {
+	fresh := f.shedLagging(f.dropStale(events))
+	if len(fresh) == 0 && len(events) > 0 {
+		return nil
+	}
+	events = fresh
+
+	h := &f.hooks
+	if h.onBatch == nil && h.onEvent == nil {
+		for _, batch := range splitBatch(events, f.maxBatch) {
+			if err := f.enqueue(ctx, batch); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, batch := range splitBatch(events, f.maxBatch) {
+		if h.onBatch != nil {
+			h.onBatch(batch)
+		}
+		if h.onEvent != nil {
+			for _, ev := range batch {
+				h.onEvent(ev)
+			}
+		}
+		f.metrics.observeBatch(batch)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestFeedHooks(t *testing.T) {
+	start := time.Now()
+	reset := start.Add(time.Hour).Truncate(time.Second)
+
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if atomic.AddInt32(&polls, 1) > 1 {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"API rate limit exceeded"}`))
+			return
+		}
+		w.Header().Set("X-Poll-Interval", "1")
+		w.Write([]byte(`[{"id":"2","type":"PushEvent","actor":{"login":"a"}},{"id":"1","type":"PushEvent","actor":{"login":"b"}}]`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(start)
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: server.URL, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var batches int
+	var ids []string
+	var feedErrors []*lib.FeedError
+	throttled := make(chan time.Duration, 1)
+	feed.OnBatch(func(events []*github.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		batches++
+	})
+	feed.OnEvent(func(ev *github.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, ev.GetID())
+	})
+	feed.OnError(func(err *lib.FeedError) {
+		mu.Lock()
+		defer mu.Unlock()
+		feedErrors = append(feedErrors, err)
+	})
+	feed.OnThrottle(func(source lib.Source, wait time.Duration) {
+		if source.Type != lib.SourcePublic {
+			t.Errorf("got source %s, want public", source)
+		}
+		throttled <- wait
+	})
+	go feed.Serve()
+
+	// The batch is delivered before the feed waits for the next poll.
+	waitForTimer(t, clock)
+	mu.Lock()
+	if batches != 1 || len(ids) != 2 || ids[0] != "2" || ids[1] != "1" {
+		t.Errorf("got %d batches of events %v, want 1 of 2,1", batches, ids)
+	}
+	mu.Unlock()
+
+	clock.Advance(time.Second)
+	select {
+	case wait := <-throttled:
+		if want := reset.Sub(start.Add(time.Second)); wait != want {
+			t.Errorf("got a throttle of %v, want %v", wait, want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the throttle")
+	}
+
+	feed.ReportError(lib.OpSink, "1", errors.New("unavailable"))
+	mu.Lock()
+	if len(feedErrors) != 1 || feedErrors[0].Op != lib.OpSink {
+		t.Errorf("got errors %v, want the sink's", feedErrors)
+	}
+	mu.Unlock()
+
+	// The channels are bypassed.
+	select {
+	case batch := <-events:
+		t.Errorf("got batch %v on the channel", batch)
+	case err := <-feed.Errors():
+		t.Errorf("got error %v on the channel", err)
+	default:
+	}
+}
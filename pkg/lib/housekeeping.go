@@ -0,0 +1,239 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a housekeeping job runs: every Every since the start
+// of the process, or at the multiples of Every (in UTC) when Aligned, e.g.
+// at the top of each hour for @hourly.
+type Schedule struct {
+	Every   time.Duration
+	Aligned bool
+}
+
+var scheduleAliases = map[string]Schedule{
+	"@hourly": {Every: time.Hour, Aligned: true},
+	"@daily":  {Every: 24 * time.Hour, Aligned: true},
+	"@weekly": {Every: 7 * 24 * time.Hour, Aligned: true},
+}
+
+// ParseSchedule parses either a duration, e.g. 15m, or one of @hourly, @daily
+// and @weekly.
+func ParseSchedule(s string) (Schedule, error) {
+	if sched, ok := scheduleAliases[s]; ok {
+		return sched, nil
+	}
+
+	every, err := time.ParseDuration(s)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule '%s', expected a duration or @hourly, @daily, @weekly", s)
+	}
+	if every <= 0 {
+		return Schedule{}, fmt.Errorf("invalid schedule '%s', must be positive", s)
+	}
+
+	return Schedule{Every: every}, nil
+}
+
+// Next returns the time of the run following t.
+func (s Schedule) Next(t time.Time) time.Time {
+	if s.Aligned {
+		// Truncate rounds since the zero time, which starts a UTC day and a
+		// week (on Monday).
+		return t.Truncate(s.Every).Add(s.Every)
+	}
+	return t.Add(s.Every)
+}
+
+// Housekeeper runs maintenance jobs (pruning caches, compacting archives,
+// expiring state) on their schedule within the daemon, instead of external
+// cron jobs racing with it on its files.
+type Housekeeper struct {
+	jobs []*housekeepingJob
+}
+
+type housekeepingJob struct {
+	name     string
+	schedule Schedule
+	run      func(ctx context.Context) error
+}
+
+func NewHousekeeper() *Housekeeper {
+	return &Housekeeper{}
+}
+
+// Add schedules a job, its errors are logged.
+func (h *Housekeeper) Add(name string, schedule Schedule, run func(ctx context.Context) error) {
+	h.jobs = append(h.jobs, &housekeepingJob{name: name, schedule: schedule, run: run})
+}
+
+// Serve runs the jobs on their schedules until the context is done. Jobs run
+// one at a time, a job overrunning its schedule skips the missed runs.
+func (h *Housekeeper) Serve(ctx context.Context) error {
+	if len(h.jobs) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	now := time.Now()
+	next := make([]time.Time, len(h.jobs))
+	for i, job := range h.jobs {
+		next[i] = job.schedule.Next(now)
+	}
+
+	for {
+		first := 0
+		for i := range next {
+			if next[i].Before(next[first]) {
+				first = i
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next[first]))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		job := h.jobs[first]
+		start := time.Now()
+		if err := job.run(ctx); err != nil {
+			warnf("Housekeeping job %s failed: %v", job.name, err)
+		}
+		log.Printf("Housekeeping job %s ran in %v", job.name, time.Since(start).Round(time.Millisecond))
+
+		for now := time.Now(); !next[first].After(now); {
+			next[first] = job.schedule.Next(next[first])
+		}
+	}
+}
+
+// PruneFiles removes the regular files matching the glob patterns which
+// weren't modified since maxAge. It returns the number of files removed.
+func PruneFiles(patterns []string, maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge)
+
+	removed := 0
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, path := range paths {
+			info, err := os.Lstat(path)
+			if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// CompactArchive merges the objects archived in a file:// store (see
+// ArchiveSink) during each hour ended before the given time into a single
+// object. The objects are concatenated as members of one gzip stream, which
+// readers decompress as a whole, in the order they were archived. The
+// compacted object takes the name of the first one so that objects still
+// sort in archive order. It returns the number of objects merged.
+//
+// The merged objects are removed after the compacted object is written, a
+// crash in between leaves their records twice in the hour.
+func CompactArchive(dir string, before time.Time) (int, error) {
+	hours, err := filepath.Glob(filepath.Join(dir, "[0-9]*", "[0-9]*", "[0-9]*", "[0-9]*"))
+	if err != nil {
+		return 0, err
+	}
+
+	merged := 0
+	for _, hour := range hours {
+		rel, err := filepath.Rel(dir, hour)
+		if err != nil {
+			return merged, err
+		}
+		start, err := time.Parse(archiveKeyLayout, filepath.ToSlash(rel))
+		if err != nil || start.Add(time.Hour).After(before) {
+			continue
+		}
+
+		n, err := compactArchiveHour(hour)
+		merged += n
+		if err != nil {
+			return merged, err
+		}
+	}
+
+	return merged, nil
+}
+
+func compactArchiveHour(dir string) (int, error) {
+	objects, err := filepath.Glob(filepath.Join(dir, "*.ndjson.gz"))
+	if err != nil || len(objects) < 2 {
+		return 0, err
+	}
+	sort.Strings(objects)
+
+	var buf bytes.Buffer
+	for _, path := range objects {
+		body, err := ioutil.ReadFile(path)
+		if err != nil {
+			return 0, err
+		}
+		buf.Write(body)
+	}
+
+	store := &DirStore{dir: dir}
+	if err := store.Put(context.Background(), filepath.Base(objects[0]), buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	for _, path := range objects[1:] {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+
+	return len(objects), nil
+}
+
+// ParseSchedules parses a comma separated list of job=schedule, e.g.
+// 'prune-cache=@daily,expire-dedup=30m'.
+func ParseSchedules(spec string) (map[string]Schedule, error) {
+	schedules := make(map[string]Schedule)
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+
+		parts := strings.SplitN(term, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid job schedule '%s', expected job=schedule", term)
+		}
+
+		schedule, err := ParseSchedule(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		schedules[parts[0]] = schedule
+	}
+
+	return schedules, nil
+}
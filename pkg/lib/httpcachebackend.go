@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPCacheBackend stores the API responses cached by a feed, with their
+// ETags, see Config.Cache. The keys are the URLs of the requests and the
+// values the responses as written on the wire. Failures of the backend are
+// handled as misses, and must not fail the polls.
+type HTTPCacheBackend interface {
+	Get(key string) (response []byte, ok bool)
+	Set(key string, response []byte)
+	Delete(key string)
+}
+
+// OpenHTTPCache opens the cache of API responses of a URL:
+//
+//	memory                                    (nil, the default in-memory cache)
+//	file:///var/cache/github-feed?max-size=1G DiskCache, 256M by default
+//	redis://:password@host:6379/0?ttl=24h     RedisCache, see NewRedisCache
+//
+// A path without scheme is a DiskCache.
+func OpenHTTPCache(rawurl string) (HTTPCacheBackend, error) {
+	if rawurl == "" || rawurl == "memory" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+
+	switch u.Scheme {
+	case "", "file":
+		maxSize := int64(defaultDiskCacheBytes)
+		if v := query.Get("max-size"); v != "" {
+			if maxSize, err = parseByteSize(v); err != nil {
+				return nil, err
+			}
+		}
+		return NewDiskCache(u.Path, maxSize)
+	case "redis":
+		var ttl time.Duration
+		if v := query.Get("ttl"); v != "" {
+			if ttl, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("invalid ttl '%s': %w", v, err)
+			}
+		}
+		return NewRedisCache(u.Host, u.User, u.Path, ttl)
+	default:
+		return nil, fmt.Errorf("unsupported HTTP cache '%s'", Redact(rawurl))
+	}
+}
+
+// meteredHTTPCache counts the hits and misses of the cache of a feed, see
+// EventFeed.WritePrometheus.
+type meteredHTTPCache struct {
+	HTTPCacheBackend
+	hits   uint64
+	misses uint64
+}
+
+func (c *meteredHTTPCache) Get(key string) ([]byte, bool) {
+	response, ok := c.HTTPCacheBackend.Get(key)
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+	}
+	return response, ok
+}
+
+// hitRatio returns the fraction of the lookups served, 0 before the first.
+func (c *meteredHTTPCache) hitRatio() float64 {
+	hits, misses := atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
@@ -0,0 +1,215 @@
+package lib_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestDiskCacheSurvivesRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var conditional int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[{"id":"1","type":"PushEvent"}]`))
+	}))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		backend, err := lib.OpenHTTPCache("file://" + dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		feed, _, err := lib.NewEventFeed(context.Background(), &lib.Config{BaseURL: server.URL, Cache: backend})
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, _, err := feed.Client().Activity.ListEvents(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != 1 {
+			t.Errorf("got %d events, want 1", len(events))
+		}
+	}
+
+	// The restarted feed revalidated the response cached by the first.
+	if conditional != 1 {
+		t.Errorf("got %d conditional requests, want 1", conditional)
+	}
+}
+
+func TestDiskCachePrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "http-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := lib.NewDiskCache(dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		c.Set(strconv.Itoa(i), make([]byte, 100))
+		// Ordered by modification time.
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) > 2 {
+		t.Errorf("got %d responses stored, want at most 2", len(files))
+	}
+	if _, ok := c.Get("4"); !ok {
+		t.Error("the last response was pruned")
+	}
+
+	c.Delete("4")
+	if _, ok := c.Get("4"); ok {
+		t.Error("got the deleted response")
+	}
+}
+
+// fakeRedis serves GET, SET, DEL and AUTH from a map. The values starting
+// with + are got as simple strings.
+func fakeRedis(t *testing.T, password string) (string, map[string]string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				authenticated := password == ""
+				for {
+					var n int
+					if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+						return
+					}
+					args := make([]string, n)
+					for i := range args {
+						var size int
+						if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+							return
+						}
+						b := make([]byte, size+2)
+						if _, err := io.ReadFull(r, b); err != nil {
+							return
+						}
+						args[i] = string(b[:size])
+					}
+
+					mu.Lock()
+					switch cmd := strings.ToUpper(args[0]); {
+					case cmd == "AUTH":
+						authenticated = args[len(args)-1] == password
+						if authenticated {
+							io.WriteString(conn, "+OK\r\n")
+						} else {
+							io.WriteString(conn, "-WRONGPASS invalid password\r\n")
+						}
+					case !authenticated:
+						io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+					case cmd == "GET":
+						if v, ok := data[args[1]]; ok && strings.HasPrefix(v, "+") {
+							io.WriteString(conn, v+"\r\n")
+						} else if ok {
+							fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
+						} else {
+							io.WriteString(conn, "$-1\r\n")
+						}
+					case cmd == "SET":
+						data[args[1]] = args[2]
+						io.WriteString(conn, "+OK\r\n")
+					case cmd == "DEL":
+						delete(data, args[1])
+						io.WriteString(conn, ":1\r\n")
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String(), data, func() { l.Close() }
+}
+
+func TestRedisCache(t *testing.T) {
+	addr, data, stop := fakeRedis(t, "secret")
+	defer stop()
+
+	backend, err := lib.OpenHTTPCache("redis://:secret@" + addr + "/0?ttl=1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := backend.(*lib.RedisCache)
+	defer c.Close()
+
+	if _, ok := c.Get("https://api.github.com/events"); ok {
+		t.Error("got a response from the empty cache")
+	}
+	c.Set("https://api.github.com/events", []byte("HTTP/1.1 200 OK\r\n\r\n[]"))
+	if response, ok := c.Get("https://api.github.com/events"); !ok || string(response) != "HTTP/1.1 200 OK\r\n\r\n[]" {
+		t.Errorf("got %q, %v, want the response", response, ok)
+	}
+	if _, ok := data["github-feed:http:https://api.github.com/events"]; !ok {
+		t.Errorf("got keys %v, want the prefixed URL", data)
+	}
+
+	c.Delete("https://api.github.com/events")
+	if _, ok := c.Get("https://api.github.com/events"); ok {
+		t.Error("got the deleted response")
+	}
+
+	// Replies other than bulk strings are misses.
+	c.Set("https://api.github.com/status", []byte("+OK"))
+	if response, ok := c.Get("https://api.github.com/status"); ok {
+		t.Errorf("got %q from a simple string reply", response)
+	}
+
+	// Failures are misses.
+	wrong, err := lib.NewRedisCache(addr, nil, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wrong.Close()
+	if _, ok := wrong.Get("https://api.github.com/events"); ok {
+		t.Error("got a response without authenticating")
+	}
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"net/http"
+	"sync"
+)
+
+const (
+	defaultUserAgent = "github-feed"
+	// Header of the request tag, see SetRequestIdentity.
+	xFeedTagHeader = "X-Feed-Tag"
+)
+
+var identity = struct {
+	sync.RWMutex
+	userAgent string
+	tag       string
+}{userAgent: defaultUserAgent}
+
+// SetRequestIdentity sets the User-Agent of the outbound requests (GitHub
+// API, object stores, GH Archive), as GitHub's API guidelines ask, and the
+// tag sent in X-Feed-Tag, e.g. the name of the deployment for egress
+// attribution. An empty user agent restores the default, github-feed, an
+// empty tag sends none. Config overrides them for a feed's requests.
+func SetRequestIdentity(userAgent, tag string) {
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+
+	identity.Lock()
+	identity.userAgent, identity.tag = userAgent, tag
+	identity.Unlock()
+}
+
+// Identify sets the User-Agent and tag headers of req, unless already set.
+// Requests signed with their headers (see signV4) are identified after the
+// signature.
+func Identify(req *http.Request) {
+	userAgent, tag := requestIdentity()
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	if tag != "" && req.Header.Get(xFeedTagHeader) == "" {
+		req.Header.Set(xFeedTagHeader, tag)
+	}
+}
+
+func requestIdentity() (userAgent, tag string) {
+	identity.RLock()
+	defer identity.RUnlock()
+	return identity.userAgent, identity.tag
+}
@@ -0,0 +1,62 @@
+package lib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestRequestIdentity(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	lib.SetRequestIdentity("feed-test/1.0", "deployment-a")
+	defer lib.SetRequestIdentity("", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, c := range []struct {
+		conf           lib.Config
+		userAgent, tag string
+	}{
+		{lib.Config{}, "feed-test/1.0", "deployment-a"},
+		{lib.Config{UserAgent: "custom", RequestTag: "deployment-b"}, "custom", "deployment-b"},
+	} {
+		c.conf.BaseURL = server.URL
+		feed, _, err := lib.NewEventFeed(ctx, &c.conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := feed.Client().Activity.ListEvents(ctx, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		h := <-headers
+		if h.Get("User-Agent") != c.userAgent || h.Get("X-Feed-Tag") != c.tag {
+			t.Errorf("GitHub request identified as %q, %q, expected %q, %q", h.Get("User-Agent"), h.Get("X-Feed-Tag"), c.userAgent, c.tag)
+		}
+	}
+
+	// Other requests keep the user agent set by the caller.
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("User-Agent", "caller")
+	lib.Identify(req)
+	if req.Header.Get("User-Agent") != "caller" || req.Header.Get("X-Feed-Tag") != "deployment-a" {
+		t.Errorf("identified as %v", req.Header)
+	}
+
+	lib.SetRequestIdentity("", "")
+	req, _ = http.NewRequest(http.MethodGet, server.URL, nil)
+	lib.Identify(req)
+	if req.Header.Get("User-Agent") != "github-feed" || req.Header.Get("X-Feed-Tag") != "" {
+		t.Errorf("default identity %v", req.Header)
+	}
+}
@@ -0,0 +1,276 @@
+package lib_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	mockPageSize       = 30
+	mockPages          = 10
+	mockEventsPerPoll  = 20
+	mockWatchEventStep = 5
+)
+
+// mockGitHub mimics the public events API: each poll (a request for the
+// first page) publishes new events, and the newest events are served newest
+// first over paginated responses, so successive polls overlap.
+type mockGitHub struct {
+	mu     sync.Mutex
+	events []json.RawMessage
+	frozen bool
+}
+
+func (m *mockGitHub) publish() {
+	for i := 0; i < mockEventsPerPoll; i++ {
+		id := len(m.events) + 1
+
+		typ := "PushEvent"
+		if id%mockWatchEventStep == 0 {
+			typ = "WatchEvent"
+		}
+
+		ev := fmt.Sprintf(`{"id":"%d","type":"%s","actor":{"login":"user%d"},"repo":{"name":"org/repo%d"},"payload":{}}`,
+			id, typ, id%7, id%3)
+		m.events = append(m.events, json.RawMessage(ev))
+	}
+}
+
+// freeze stops publishing events.
+func (m *mockGitHub) freeze() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frozen = true
+}
+
+func (m *mockGitHub) published() []json.RawMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]json.RawMessage(nil), m.events...)
+}
+
+func (m *mockGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if page == 1 && !m.frozen {
+		m.publish()
+	}
+
+	// Newest first, at most mockPages pages.
+	var newest []json.RawMessage
+	for i := len(m.events) - 1; i >= 0 && len(newest) < mockPageSize*mockPages; i-- {
+		newest = append(newest, m.events[i])
+	}
+
+	start, end := (page-1)*mockPageSize, page*mockPageSize
+	if start > len(newest) {
+		start = len(newest)
+	}
+	if end >= len(newest) {
+		end = len(newest)
+	} else {
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s/events?page=%d>; rel="next"`, r.Host, page+1))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Poll-Interval", "0")
+	json.NewEncoder(w).Encode(newest[start:end])
+}
+
+// pipeline polls the mock, filters out WatchEvents, deduplicates and
+// archives the events in a directory.
+type pipeline struct {
+	t      *testing.T
+	url    string
+	dir    string
+	state  string
+	filter *lib.Filter
+}
+
+// run processes batches until done returns true for the last processed
+// (deduplicated) batch. When crash is set, the following batch is received
+// but dropped, as if the process was killed while handling it.
+func (p *pipeline) run(done func(batches int, fresh []*github.Event) bool, crash bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: p.url, QueueSize: 1})
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	go feed.Serve()
+
+	dedup, err := lib.NewDeduplicator(p.state, 0, nil)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	store, err := lib.NewDirStore(p.dir)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	sink := lib.NewArchiveSink(store)
+
+	for batches := 1; ; batches++ {
+		var batch []*github.Event
+		select {
+		case batch = <-events:
+		case <-time.After(10 * time.Second):
+			p.t.Fatal("timed out waiting for events")
+		}
+
+		var matched []*github.Event
+		for _, ev := range batch {
+			if p.filter.Match(ev) {
+				matched = append(matched, ev)
+			}
+		}
+
+		fresh := dedup.Filter(matched)
+		if err := sink.Write(ctx, fresh); err != nil {
+			p.t.Fatal(err)
+		}
+		if err := dedup.Save(); err != nil {
+			p.t.Fatal(err)
+		}
+
+		if done(batches, fresh) {
+			break
+		}
+	}
+
+	if crash {
+		<-events
+	}
+}
+
+func (p *pipeline) archived() map[string]int {
+	ids := make(map[string]int)
+
+	err := filepath.Walk(p.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			var record lib.Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return err
+			}
+			if err := lib.VerifyRecord(scanner.Bytes()); err != nil {
+				return err
+			}
+			ids[record.GetID()]++
+		}
+
+		return scanner.Err()
+	})
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	return ids
+}
+
+// TestPipelineRestart kills the pipeline mid-run and restarts it from its
+// state, every published event must be archived exactly once.
+func TestPipelineRestart(t *testing.T) {
+	mock := &mockGitHub{}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "github-feed-integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filter, err := lib.ParseFilter("type!=WatchEvent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &pipeline{
+		t:      t,
+		url:    server.URL,
+		dir:    filepath.Join(dir, "archive"),
+		state:  filepath.Join(dir, "dedup"),
+		filter: filter,
+	}
+
+	p.run(func(batches int, _ []*github.Event) bool { return batches == 5 }, true)
+
+	// Restart, then stop publishing and catch up until a batch has nothing
+	// new.
+	p.run(func(batches int, fresh []*github.Event) bool {
+		switch {
+		case batches == 5:
+			mock.freeze()
+		case batches > 5:
+			return len(fresh) == 0
+		}
+		return false
+	}, false)
+
+	archived := p.archived()
+
+	expected := 0
+	for _, raw := range mock.published() {
+		var ev github.Event
+		if err := json.Unmarshal(raw, &ev); err != nil {
+			t.Fatal(err)
+		}
+		if !filter.Match(&ev) {
+			if archived[ev.GetID()] != 0 {
+				t.Errorf("filtered event %s archived", ev.GetID())
+			}
+			continue
+		}
+
+		expected++
+		switch n := archived[ev.GetID()]; n {
+		case 1:
+		case 0:
+			t.Errorf("event %s lost", ev.GetID())
+		default:
+			t.Errorf("event %s archived %d times", ev.GetID(), n)
+		}
+	}
+
+	if len(archived) != expected {
+		t.Errorf("archived %d events, expected %d", len(archived), expected)
+	}
+}
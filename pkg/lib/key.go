@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// KeySpec extracts a key from events, shared by every subsystem deriving keys
+// (partitioning, deduplication, routing) so that they agree on the same
+// semantics. A spec is either
+//
+//	a dot path in the event's JSON, e.g. `repo.name` or `payload.commits.0.sha`
+//	a Go template over the event's JSON, e.g. `{{.repo.name}}/{{.type}}`
+//
+// Both resolve fields with the names of the emitted JSON, a missing field is
+// an error.
+type KeySpec struct {
+	spec string
+	path []string
+	tmpl *template.Template
+}
+
+var keyFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+func ParseKeySpec(spec string) (*KeySpec, error) {
+	if spec == "" {
+		return nil, fmt.Errorf("empty key spec")
+	}
+
+	k := &KeySpec{spec: spec}
+
+	if strings.Contains(spec, "{{") {
+		t, err := template.New("key").Funcs(keyFuncs).Option("missingkey=error").Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("key spec '%s': %v", spec, err)
+		}
+		k.tmpl = t
+		return k, nil
+	}
+
+	k.path = strings.Split(spec, ".")
+	for _, p := range k.path {
+		if p == "" {
+			return nil, fmt.Errorf("key spec '%s': empty path element", spec)
+		}
+	}
+
+	return k, nil
+}
+
+func (k *KeySpec) String() string {
+	return k.spec
+}
+
+// Key returns the key of the event.
+func (k *KeySpec) Key(ev *github.Event) (string, error) {
+	doc, err := eventDocument(ev)
+	if err != nil {
+		return "", err
+	}
+	v, err := k.value(doc)
+	if err != nil {
+		return "", err
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		// Objects and arrays are keyed by their JSON.
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+}
+
+// eventDocument returns the emitted JSON of an event, decoded.
+func eventDocument(ev *github.Event) (interface{}, error) {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	// Keep numbers (e.g. ids) as written instead of float64.
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// missingFieldError is returned for the key specs whose path lacks a field.
+type missingFieldError struct {
+	spec, field string
+}
+
+func (e *missingFieldError) Error() string {
+	return fmt.Sprintf("key spec '%s': missing field '%s'", e.spec, e.field)
+}
+
+// value returns the value of the spec in an event's document, see
+// eventDocument, as decoded (a string for templates).
+func (k *KeySpec) value(doc interface{}) (interface{}, error) {
+	if k.tmpl != nil {
+		var buf bytes.Buffer
+		if err := k.tmpl.Execute(&buf, doc); err != nil {
+			return nil, fmt.Errorf("key spec '%s': %v", k.spec, err)
+		}
+		return buf.String(), nil
+	}
+
+	v := doc
+	for _, p := range k.path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[p]
+		case []interface{}:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(node) {
+				v = nil
+			} else {
+				v = node[i]
+			}
+		default:
+			v = nil
+		}
+
+		if v == nil {
+			return nil, &missingFieldError{spec: k.spec, field: p}
+		}
+	}
+	return v, nil
+}
+
+// EventKey extracts the key of the event with a spec, see KeySpec. Callers
+// extracting many keys should parse the spec once with ParseKeySpec.
+func EventKey(ev *github.Event, spec string) (string, error) {
+	k, err := ParseKeySpec(spec)
+	if err != nil {
+		return "", err
+	}
+
+	return k.Key(ev)
+}
@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const defaultMaxLagPollInterval = 5 * time.Minute
+
+// lagPolicy is how the polls slow down while the consumer lags, see
+// Config.LagThreshold.
+type lagPolicy struct {
+	threshold   int
+	maxInterval time.Duration
+	// Events shed while lagging, nil if none.
+	shed func(*github.Event) bool
+}
+
+func newLagPolicy(conf *Config) (lagPolicy, error) {
+	l := lagPolicy{threshold: conf.LagThreshold, maxInterval: conf.MaxLagPollInterval}
+	if l.maxInterval <= 0 {
+		l.maxInterval = defaultMaxLagPollInterval
+	}
+	if !conf.LagShedFilter.IsZero() {
+		shed, err := conf.LagShedFilter.Compile()
+		if err != nil {
+			return l, err
+		}
+		l.shed = shed
+	}
+	return l, nil
+}
+
+// lag returns the number of batches waiting for the consumer, spilled ones
+// included.
+func (f *EventFeed) lag() int {
+	return len(f.events) + f.spillDepth()
+}
+
+// lagging tells whether the consumer lags behind the polls.
+func (f *EventFeed) lagging() bool {
+	return f.lagPolicy.threshold > 0 && f.lag() > f.lagPolicy.threshold
+}
+
+// lagInterval returns the interval until the next poll, doubled for every
+// Config.LagThreshold batches waiting for the consumer beyond the first
+// LagThreshold, up to Config.MaxLagPollInterval. Longer intervals are left
+// as is.
+func (f *EventFeed) lagInterval(interval time.Duration) time.Duration {
+	l := &f.lagPolicy
+	if l.threshold <= 0 {
+		return interval
+	}
+
+	lag := f.lag()
+	if lag <= l.threshold {
+		if atomic.CompareAndSwapInt32(&f.metrics.lagging, 1, 0) {
+			log.Printf("The consumer caught up, polling at the normal cadence")
+		}
+		return interval
+	}
+	if atomic.CompareAndSwapInt32(&f.metrics.lagging, 0, 1) {
+		warnf("The consumer lags %d batches behind, slowing down the polls", lag)
+	}
+
+	slowed := interval
+	for n := (lag - 1) / l.threshold; n > 0 && slowed < l.maxInterval; n-- {
+		slowed *= 2
+	}
+	if slowed > l.maxInterval {
+		slowed = l.maxInterval
+	}
+	if slowed < interval {
+		return interval
+	}
+	return slowed
+}
+
+// shedLagging returns the events of the batch not shed while the consumer
+// lags, see Config.LagShedFilter, the batch itself if none was.
+func (f *EventFeed) shedLagging(events []*github.Event) []*github.Event {
+	shed := f.lagPolicy.shed
+	if shed == nil || len(events) == 0 || !f.lagging() {
+		return events
+	}
+
+	kept := make([]*github.Event, 0, len(events))
+	for _, ev := range events {
+		if !shed(ev) {
+			kept = append(kept, ev)
+		}
+	}
+	if len(kept) == len(events) {
+		return events
+	}
+
+	n := len(events) - len(kept)
+	atomic.AddUint64(&f.metrics.shed, uint64(n))
+	debugf("Shed %d events while the consumer lags", n)
+	return kept
+}
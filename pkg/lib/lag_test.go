@@ -0,0 +1,80 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestFeedSlowsDownWhileLagging(t *testing.T) {
+	var polls int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&polls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Poll-Interval", "60")
+		fmt.Fprintf(w, `[{"id":"%d","type":"WatchEvent","actor":{"login":"a"}},{"id":"%d","type":"PushEvent","actor":{"login":"a"}}]`, 2*n, 2*n-1)
+	}))
+	defer api.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+		BaseURL:       api.URL,
+		Clock:         clock,
+		LagThreshold:  1,
+		LagShedFilter: lib.EventFilter{Types: []string{"WatchEvent"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+
+	// Nobody consumes: a batch waiting is within the threshold.
+	waitForTimer(t, clock)
+	clock.Advance(time.Minute)
+	waitForTimer(t, clock)
+
+	// Two batches waiting, the interval is doubled.
+	clock.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&polls); n != 2 {
+		t.Fatalf("got %d polls, want 2", n)
+	}
+	clock.Advance(time.Minute)
+	waitForTimer(t, clock)
+	if n := atomic.LoadInt32(&polls); n != 3 {
+		t.Fatalf("got %d polls, want 3", n)
+	}
+
+	var metrics bytes.Buffer
+	feed.WritePrometheus(&metrics)
+	for _, want := range []string{"github_feed_events_shed_total 1\n", "github_feed_lagging 1\n"} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("expected %q in the metrics:\n%s", want, metrics.String())
+		}
+	}
+
+	for _, want := range []string{"2,1", "4,3", "5"} {
+		if got := nextBatchIDs(t, events); got != want {
+			t.Errorf("got the batch %s, want %s", got, want)
+		}
+	}
+}
+
+func TestLagConfig(t *testing.T) {
+	err := (&lib.Config{LagShedFilter: lib.EventFilter{Types: []string{"WatchEvent"}}, MaxLagPollInterval: -time.Second}).Validate()
+	if err == nil || !strings.Contains(err.Error(), "LagShedFilter: requires LagThreshold") ||
+		!strings.Contains(err.Error(), "MaxLagPollInterval: must not be negative") {
+		t.Errorf("expected the lag problems reported, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Levels of the logs, see ConfigureLogging.
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// Formats of the logs, see ConfigureLogging.
+const (
+	// Lines of text prefixed by the time, as written by the log package.
+	LogText = "text"
+	// A JSON object per line, with the time, level and message.
+	LogJSON = "json"
+)
+
+var logLevels = []string{LogDebug, LogInfo, LogWarn, LogError}
+
+// logTags are the prefixes of the messages giving their level, stripped from
+// the JSON messages unless they are part of the message.
+var logTags = []struct {
+	prefix, level string
+	strip         bool
+}{
+	{LogDebug + ": ", LogDebug, true},
+	{LogWarn + ": ", LogWarn, true},
+	{LogError + ": ", LogError, true},
+	{"Fatal: ", LogError, false},
+}
+
+// Level of the logs below which the package's are discarded, info by
+// default.
+var logThreshold int32 = 1
+
+func logLevel(level string) (int32, error) {
+	for i, l := range logLevels {
+		if l == level {
+			return int32(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level '%s', expected %s", level, strings.Join(logLevels, ", "))
+}
+
+func validLogFormat(format string) error {
+	if format != LogText && format != LogJSON {
+		return fmt.Errorf("unknown log format '%s', expected %s or %s", format, LogText, LogJSON)
+	}
+	return nil
+}
+
+// logf logs a message of a level other than info, tagged with it, e.g.
+// "debug: Polling public for page 1". Untagged messages are info.
+func logf(level string, format string, args ...interface{}) {
+	l, _ := logLevel(level)
+	if l < atomic.LoadInt32(&logThreshold) {
+		return
+	}
+	log.Output(3, level+": "+fmt.Sprintf(format, args...))
+}
+
+func debugf(format string, args ...interface{}) { logf(LogDebug, format, args...) }
+func warnf(format string, args ...interface{})  { logf(LogWarn, format, args...) }
+
+// ConfigureLogging sets the verbosity and format of the logs of the
+// process, written through the log package: the messages below level are
+// discarded, the others written in format to the current output of the log
+// package. The level of a message is its tag (debug:, warn:, error: or
+// Fatal:), info without. Empty arguments keep the current setting, the log
+// output is left alone if both are.
+func ConfigureLogging(level, format string) error {
+	if level == "" && format == "" {
+		return nil
+	}
+	if level != "" {
+		l, err := logLevel(level)
+		if err != nil {
+			return err
+		}
+		atomic.StoreInt32(&logThreshold, l)
+	}
+	if format != "" {
+		if err := validLogFormat(format); err != nil {
+			return err
+		}
+	}
+
+	logOutput.Lock()
+	defer logOutput.Unlock()
+
+	w, ok := log.Writer().(*logWriter)
+	if !ok {
+		w = &logWriter{w: log.Writer(), format: LogText}
+		log.SetFlags(0)
+		log.SetOutput(w)
+	}
+	if format != "" {
+		w.setFormat(format)
+	}
+	return nil
+}
+
+var logOutput sync.Mutex
+
+// logWriter filters and formats the lines of the log package, written
+// without its prefix.
+type logWriter struct {
+	w io.Writer
+
+	mu     sync.Mutex
+	format string
+}
+
+func (w *logWriter) setFormat(format string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.format = format
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	msg := string(bytes.TrimSuffix(p, []byte("\n")))
+	level, text := LogInfo, msg
+	for _, tag := range logTags {
+		if strings.HasPrefix(msg, tag.prefix) {
+			level = tag.level
+			if tag.strip {
+				text = msg[len(tag.prefix):]
+			}
+			break
+		}
+	}
+	if l, _ := logLevel(level); l < atomic.LoadInt32(&logThreshold) {
+		return len(p), nil
+	}
+
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var line []byte
+	if w.format == LogJSON {
+		line, _ = json.Marshal(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{now.UTC().Format(time.RFC3339Nano), level, text})
+	} else {
+		line = []byte(now.Format("2006/01/02 15:04:05 ") + msg)
+	}
+
+	if _, err := w.w.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
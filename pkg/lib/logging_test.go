@@ -0,0 +1,124 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	out, flags := log.Writer(), log.Flags()
+	defer func() {
+		lib.ConfigureLogging(lib.LogInfo, lib.LogText)
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	}()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	if err := lib.ConfigureLogging(lib.LogWarn, lib.LogJSON); err != nil {
+		t.Fatal(err)
+	}
+	log.Print("info: dropped")
+	log.Print("warn: kept")
+	log.Print("Fatal: kept too")
+
+	var levels, msgs []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry struct{ Time, Level, Msg string }
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		levels, msgs = append(levels, entry.Level), append(msgs, entry.Msg)
+	}
+	if fmt.Sprint(levels) != "[warn error]" || fmt.Sprint(msgs) != "[kept Fatal: kept too]" {
+		t.Errorf("got levels %v and messages %v", levels, msgs)
+	}
+
+	// Reconfiguring doesn't wrap the output again.
+	buf.Reset()
+	if err := lib.ConfigureLogging(lib.LogInfo, lib.LogText); err != nil {
+		t.Fatal(err)
+	}
+	log.Print("hello")
+	if line := buf.String(); strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, " hello\n") {
+		t.Errorf("got %q", line)
+	}
+
+	if err := lib.ConfigureLogging("verbose", ""); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestPollSettings(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Poll-Interval", "1")
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s/events?page=%d>; rel="next"`, r.Host, n+1))
+		fmt.Fprintf(w, `[{"id":"%d","type":"PushEvent","actor":{"login":"a"}}]`, 1000-n)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+		BaseURL:         server.URL,
+		Clock:           clock,
+		MaxPages:        2,
+		MinPollInterval: 30 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+
+	if batch := <-events; len(batch) != 2 {
+		t.Errorf("got %d events, want the 2 pages", len(batch))
+	}
+	waitForTimer(t, clock)
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+
+	// X-Poll-Interval is raised to the floor.
+	clock.Advance(29 * time.Second)
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("polled again after %d requests before the minimum interval", n)
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-events:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the second poll")
+	}
+}
+
+func TestPollSettingsValidate(t *testing.T) {
+	for conf, valid := range map[*lib.Config]bool{
+		{MaxPages: 10, MinPollInterval: time.Minute}: true,
+		{MaxPages: 11}:                                   false,
+		{MinPollInterval: -time.Second}:                  false,
+		{LogLevel: lib.LogDebug, LogFormat: lib.LogJSON}: true,
+		{LogLevel: "trace"}:                              false,
+		{LogFormat: "logfmt"}:                            false,
+	} {
+		if err := conf.Validate(); (err == nil) != valid {
+			t.Errorf("%+v: got %v", conf, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MirrorStore replicates every object to multiple stores, e.g. buckets in
+// distinct regions. The copies destined to the failed stores are spooled on
+// local disk and uploaded later by Reconcile. A Put succeeds as long as one
+// store accepted the object or one copy was spooled.
+type MirrorStore struct {
+	stores []ObjectStore
+	spool  string
+}
+
+func NewMirrorStore(spool string, stores ...ObjectStore) (*MirrorStore, error) {
+	if len(stores) == 0 {
+		return nil, fmt.Errorf("mirror store requires at least one store")
+	}
+
+	for _, store := range stores {
+		if err := os.MkdirAll(spoolDir(spool, store), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &MirrorStore{stores: stores, spool: spool}, nil
+}
+
+var spoolNameSanitizer = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func spoolDir(spool string, store ObjectStore) string {
+	return filepath.Join(spool, spoolNameSanitizer.ReplaceAllString(store.Name(), "_"))
+}
+
+func (m *MirrorStore) Name() string {
+	names := make([]string, 0, len(m.stores))
+	for _, store := range m.stores {
+		names = append(names, store.Name())
+	}
+	return "mirror(" + strings.Join(names, ",") + ")"
+}
+
+func (m *MirrorStore) Put(ctx context.Context, key string, body []byte) error {
+	errs := make([]error, len(m.stores))
+
+	var wg sync.WaitGroup
+	for i, store := range m.stores {
+		wg.Add(1)
+		go func(i int, store ObjectStore) {
+			defer wg.Done()
+			errs[i] = store.Put(ctx, key, body)
+		}(i, store)
+	}
+	wg.Wait()
+
+	var failed []string
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		spooled := &DirStore{dir: spoolDir(m.spool, m.stores[i])}
+		if serr := spooled.Put(ctx, key, body); serr != nil {
+			failed = append(failed, fmt.Sprintf("%v, and spooling failed: %v", err, serr))
+			warnf("Mirror upload of %s to %s failed, and spooling failed too: %v; %v", key, m.stores[i].Name(), err, serr)
+			continue
+		}
+		warnf("Mirror upload of %s to %s failed, spooled: %v", key, m.stores[i].Name(), err)
+	}
+
+	// If spooling fails for some stores the object is only missing from
+	// their regions, which is better than failing the write altogether. If
+	// none has the object, let the caller decide how to retry.
+	if len(failed) > 0 && len(failed) == len(m.stores) {
+		return fmt.Errorf("mirror upload of %s failed: %s", key, strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// Reconcile uploads the spooled objects to the stores which missed them and
+// returns the number of objects still pending.
+func (m *MirrorStore) Reconcile(ctx context.Context) (pending int, err error) {
+	for _, store := range m.stores {
+		dir := spoolDir(m.spool, store)
+
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// Skip uploads in progress.
+			if tmp, _ := filepath.Match("*"+stateTempPattern, info.Name()); tmp {
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			body, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			if err := store.Put(ctx, filepath.ToSlash(rel), body); err != nil {
+				pending++
+				return nil
+			}
+
+			return os.Remove(path)
+		})
+
+		if err != nil {
+			return pending, err
+		}
+	}
+
+	return pending, nil
+}
+
+// ServeReconcile periodically reconciles the spooled objects until the context
+// is cancelled.
+func (m *MirrorStore) ServeReconcile(ctx context.Context, interval time.Duration) error {
+	for {
+		select {
+		case <-time.After(interval):
+			pending, err := m.Reconcile(ctx)
+			if err != nil {
+				warnf("Mirror reconciliation failed: %v", err)
+			} else if pending > 0 {
+				log.Printf("Mirror reconciliation has %d objects pending", pending)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
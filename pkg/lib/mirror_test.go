@@ -0,0 +1,102 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// flakyStore keeps the objects put to it, unless it is down.
+type flakyStore struct {
+	name string
+	down bool
+
+	mu      sync.Mutex
+	objects map[string]string
+}
+
+func (s *flakyStore) Put(ctx context.Context, key string, body []byte) error {
+	if s.down {
+		return errors.New("unavailable")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = string(body)
+	return nil
+}
+
+func (s *flakyStore) Name() string { return s.name }
+
+func newMirror(t *testing.T, down ...bool) (*lib.MirrorStore, []*flakyStore, string) {
+	spool, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stores []*flakyStore
+	var objectStores []lib.ObjectStore
+	for i, d := range down {
+		store := &flakyStore{name: string(rune('a' + i)), down: d, objects: make(map[string]string)}
+		stores = append(stores, store)
+		objectStores = append(objectStores, store)
+	}
+	mirror, err := lib.NewMirrorStore(spool, objectStores...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mirror, stores, spool
+}
+
+func TestMirrorStoreSomeFail(t *testing.T) {
+	mirror, stores, spool := newMirror(t, false, true)
+	defer os.RemoveAll(spool)
+
+	if err := mirror.Put(context.Background(), "2020/01/01.json", []byte("events")); err != nil {
+		t.Fatal(err)
+	}
+	if stores[0].objects["2020/01/01.json"] != "events" {
+		t.Error("the available store missed the object")
+	}
+	if _, err := os.Stat(filepath.Join(spool, "b", "2020", "01", "01.json")); err != nil {
+		t.Errorf("the copy of the failed store wasn't spooled: %v", err)
+	}
+
+	stores[1].down = false
+	if pending, err := mirror.Reconcile(context.Background()); err != nil || pending != 0 {
+		t.Fatalf("reconciled with %d pending: %v", pending, err)
+	}
+	if stores[1].objects["2020/01/01.json"] != "events" {
+		t.Error("the reconciled store missed the object")
+	}
+}
+
+func TestMirrorStoreAllFail(t *testing.T) {
+	mirror, stores, spool := newMirror(t, true, true)
+	defer os.RemoveAll(spool)
+
+	if err := mirror.Put(context.Background(), "k", []byte("events")); err != nil {
+		t.Fatalf("failed with the copies spooled: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := os.Stat(filepath.Join(spool, name, "k")); err != nil {
+			t.Errorf("the copy of %s wasn't spooled: %v", name, err)
+		}
+	}
+
+	// Without a spool either, nothing holds the object.
+	for _, store := range stores {
+		dir := filepath.Join(spool, store.Name())
+		os.RemoveAll(dir)
+		if err := ioutil.WriteFile(dir, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mirror.Put(context.Background(), "k2", []byte("events")); err == nil {
+		t.Error("stored an object neither uploaded nor spooled")
+	}
+}
@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	defaultNATSPort = "4222"
+	// Servers limit messages to 1MB by default.
+	defaultNATSMaxPayload = 1 << 20
+)
+
+// NATSSink publishes a message per event, the record as payload, on a NATS
+// subject. It speaks the core client protocol, plain TCP without TLS, and
+// authenticates with a user and password or, with a user only, a token.
+//
+// Every batch ends with a PING, so that a write returns once the server
+// processed the messages. The events whose record exceeds the server's
+// maximum payload are skipped and returned in a PayloadTooLargeError. The
+// connection is re-established by the write following a failure.
+// Connections go through the dialer of http.DefaultTransport, see
+// ConfigureDialer and GuardTransport.
+type NATSSink struct {
+	addr       string
+	subject    string
+	user       *url.Userinfo
+	serializer Serializer
+
+	mu         sync.Mutex
+	conn       net.Conn
+	r          *bufio.Reader
+	maxPayload int
+}
+
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Lang     string `json:"lang"`
+	Name     string `json:"name"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+	Token    string `json:"auth_token,omitempty"`
+}
+
+func NewNATSSink(addr, subject string, user *url.Userinfo, s Serializer) (*NATSSink, error) {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n/") {
+		return nil, fmt.Errorf("invalid NATS subject '%s'", subject)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultNATSPort)
+	}
+
+	return &NATSSink{addr: addr, subject: subject, user: user, serializer: serializerOrDefault(s)}, nil
+}
+
+func (s *NATSSink) Write(ctx context.Context, events []*github.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	var tooLarge []*github.Event
+	for _, ev := range events {
+		payload, err := s.serializer.Marshal(NewRecord(ev))
+		if err != nil {
+			return err
+		}
+		if len(payload) > s.maxPayload {
+			tooLarge = append(tooLarge, ev)
+			continue
+		}
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", s.subject, len(payload))
+		buf.Write(payload)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("PING\r\n")
+
+	if err := s.roundTrip(ctx, buf.Bytes()); err != nil {
+		s.disconnect()
+		return err
+	}
+	if len(tooLarge) > 0 {
+		return &PayloadTooLargeError{Events: tooLarge, Limit: s.maxPayload}
+	}
+	return nil
+}
+
+func (s *NATSSink) connect(ctx context.Context) error {
+	dial := (&net.Dialer{}).DialContext
+	if t, ok := http.DefaultTransport.(*http.Transport); ok && t.DialContext != nil {
+		dial = t.DialContext
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultOutputTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	s.conn, s.r = conn, bufio.NewReader(conn)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		s.disconnect()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		s.disconnect()
+		return fmt.Errorf("unexpected NATS greeting: %s", strings.TrimSpace(line))
+	}
+
+	var info natsInfo
+	if err := json.Unmarshal([]byte(line[len("INFO "):]), &info); err != nil {
+		s.disconnect()
+		return fmt.Errorf("invalid NATS INFO: %w", err)
+	}
+	if info.TLSRequired {
+		s.disconnect()
+		return fmt.Errorf("NATS server %s requires TLS, which isn't supported", s.addr)
+	}
+	s.maxPayload = info.MaxPayload
+	if s.maxPayload <= 0 {
+		s.maxPayload = defaultNATSMaxPayload
+	}
+
+	connect := natsConnect{Lang: "go", Name: defaultUserAgent}
+	if s.user != nil {
+		if pass, ok := s.user.Password(); ok {
+			connect.User, connect.Pass = s.user.Username(), pass
+		} else {
+			connect.Token = s.user.Username()
+		}
+	}
+	payload, err := json.Marshal(connect)
+	if err != nil {
+		s.disconnect()
+		return err
+	}
+
+	if err := s.roundTrip(ctx, []byte("CONNECT "+string(payload)+"\r\nPING\r\n")); err != nil {
+		s.disconnect()
+		return err
+	}
+	return nil
+}
+
+// roundTrip writes the commands, terminated by a PING, and waits for the
+// server's PONG.
+func (s *NATSSink) roundTrip(ctx context.Context, commands []byte) error {
+	deadline := time.Now().Add(defaultOutputTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	s.conn.SetDeadline(deadline)
+
+	if _, err := s.conn.Write(commands); err != nil {
+		return err
+	}
+
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("NATS: " + strings.Trim(strings.TrimSpace(line[len("-ERR"):]), "'"))
+		}
+		// +OK and INFO updates are ignored.
+	}
+}
+
+func (s *NATSSink) disconnect() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.r = nil, nil
+	}
+}
+
+func (s *NATSSink) Hosts() []string {
+	return addrHost(s.addr)
+}
+
+func (s *NATSSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn, s.r = nil, nil
+	return err
+}
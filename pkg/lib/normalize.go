@@ -0,0 +1,202 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// actionAliases maps, by event type, the actions of the webhooks and of the
+// former versions of the events API to those of the current events API.
+var actionAliases = map[string]map[string]string{
+	// Webhooks name the pull request review events submitted.
+	"PullRequestReviewEvent": {"submitted": "created"},
+	// Webhooks publish a release once created and released, the events API
+	// only once published.
+	"ReleaseEvent": {"created": "published", "released": "published"},
+	"WatchEvent":   {"star": "started"},
+}
+
+// Normalize maps the event to the taxonomy of the current events API, so
+// that filters and pipelines keep working across the sources and versions
+// of the events (webhooks, GH Archive's older hours, other go-github
+// versions):
+//
+//   - the webhook event names (pull_request) become the events API types
+//     (PullRequestEvent);
+//   - the repository is named owner/name, derived from its URL when
+//     missing;
+//   - the actions renamed across versions get their current name, see
+//     actionAliases;
+//   - the legacy payload fields get their current name: object and
+//     object_name of creations and deletions, the shas of pushes, and the
+//     webhook push fields (after, commit id).
+//
+// It returns the event as is when it is already normalized, a modified
+// copy otherwise.
+func Normalize(ev *github.Event) (*github.Event, error) {
+	cp := *ev
+	changed := false
+
+	if typ := ev.GetType(); typ != "" && !strings.HasSuffix(typ, "Event") && strings.ToLower(typ) == typ {
+		cp.Type = github.String(webhookEventType(typ))
+		changed = true
+	}
+
+	if ev.Repo != nil {
+		if name := normalizeRepoName(ev.Repo.GetName(), ev.Repo.GetURL()); name != ev.Repo.GetName() {
+			repo := *ev.Repo
+			repo.Name = github.String(name)
+			cp.Repo = &repo
+			changed = true
+		}
+	}
+
+	if ev.RawPayload != nil {
+		payload, ok, err := normalizePayload(cp.GetType(), *ev.RawPayload)
+		if err != nil {
+			return nil, fmt.Errorf("event %s: %v", ev.GetID(), err)
+		}
+		if ok {
+			cp.RawPayload = payload
+			changed = true
+		}
+	}
+
+	if !changed {
+		return ev, nil
+	}
+	return &cp, nil
+}
+
+// normalizeRepoName returns the owner/name of a repository, from its name
+// or its URL: an API URL (.../repos/owner/name) or a web one.
+func normalizeRepoName(name, rawURL string) string {
+	if name == "" || strings.Contains(name, "://") {
+		if name != "" {
+			rawURL = name
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil || u.Path == "" {
+			return name
+		}
+		path := u.Path
+		if i := strings.Index(path, "/repos/"); i >= 0 {
+			path = path[i+len("/repos/"):]
+		}
+		name = path
+	}
+
+	name = strings.TrimSuffix(strings.Trim(name, "/"), ".git")
+	if parts := strings.Split(name, "/"); len(parts) > 2 {
+		name = parts[0] + "/" + parts[1]
+	}
+	return name
+}
+
+// normalizePayload renames the legacy fields of a payload, returning
+// whether it changed.
+func normalizePayload(typ string, raw json.RawMessage) (*json.RawMessage, bool, error) {
+	var payload map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(string(raw)))
+	// Keeps the IDs exceeding float64's precision.
+	d.UseNumber()
+	if err := d.Decode(&payload); err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	rename := func(from, to string) {
+		v, ok := payload[from]
+		if !ok {
+			return
+		}
+		if _, ok := payload[to]; !ok {
+			payload[to] = v
+		}
+		delete(payload, from)
+		changed = true
+	}
+
+	if action, ok := payload["action"].(string); ok {
+		if alias, ok := actionAliases[typ][action]; ok {
+			payload["action"] = alias
+			changed = true
+		}
+	}
+
+	switch typ {
+	case "CreateEvent", "DeleteEvent":
+		rename("object", "ref_type")
+		rename("object_name", "ref")
+
+	case "PushEvent":
+		if shas, ok := payload["shas"].([]interface{}); ok {
+			// [sha, email, message, name, distinct]
+			commits := make([]interface{}, 0, len(shas))
+			for _, s := range shas {
+				fields, _ := s.([]interface{})
+				field := func(i int) interface{} {
+					if i < len(fields) {
+						return fields[i]
+					}
+					return nil
+				}
+				commits = append(commits, map[string]interface{}{
+					"sha":      field(0),
+					"author":   map[string]interface{}{"email": field(1), "name": field(3)},
+					"message":  field(2),
+					"distinct": field(4),
+				})
+			}
+			payload["shas"] = commits
+			rename("shas", "commits")
+			if _, ok := payload["size"]; !ok {
+				payload["size"] = len(commits)
+			}
+		}
+		rename("after", "head")
+
+		commits, _ := payload["commits"].([]interface{})
+		for _, c := range commits {
+			commit, _ := c.(map[string]interface{})
+			if id, ok := commit["id"]; ok {
+				if _, ok := commit["sha"]; !ok {
+					commit["sha"] = id
+				}
+				delete(commit, "id")
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		return nil, false, nil
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, false, err
+	}
+	normalized := json.RawMessage(b)
+	return &normalized, true, nil
+}
+
+// normalizeTransform normalizes the events, see Normalize.
+type normalizeTransform struct{}
+
+func (normalizeTransform) Apply(ev *github.Event) (*github.Event, error) {
+	return Normalize(ev)
+}
+
+func init() {
+	RegisterTransform("normalize", func(decode func(interface{}) error) (Transform, error) {
+		var opts struct{}
+		if err := decode(&opts); err != nil {
+			return nil, err
+		}
+		return normalizeTransform{}, nil
+	})
+}
@@ -0,0 +1,297 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ObjectStore is a minimal blob store where archived objects are uploaded.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body []byte) error
+	// Name identifies the store in logs and reconciliation spools.
+	Name() string
+}
+
+// ReadableStore is an ObjectStore whose objects can be listed and read back,
+// e.g. to replay an archive (see ArchiveRangeFeed).
+type ReadableStore interface {
+	ObjectStore
+	// List returns the sorted keys starting with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// OpenObjectStore opens a store from its URL, one of
+//
+//	file:///var/lib/github-feed/archive
+//	s3://bucket/prefix?region=us-east-1[&endpoint=https://minio.local]
+func OpenObjectStore(rawurl string) (ObjectStore, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewDirStore(u.Path)
+	case "s3":
+		creds, err := AWSCredentialsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Store(u.Host, strings.TrimPrefix(u.Path, "/"), u.Query().Get("region"), u.Query().Get("endpoint"), creds)
+	default:
+		return nil, fmt.Errorf("unsupported object store '%s'", rawurl)
+	}
+}
+
+// ValidateObjectStoreURL checks the URL of a store, see OpenObjectStore, and
+// that its credentials are set, without opening it.
+func ValidateObjectStoreURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" {
+			return fmt.Errorf("file store '%s' lacks a directory", rawurl)
+		}
+		return nil
+	case "s3":
+		if u.Host == "" || u.Query().Get("region") == "" {
+			return fmt.Errorf("s3 store requires a bucket and a region")
+		}
+		_, err := AWSCredentialsFromEnv()
+		return err
+	default:
+		return fmt.Errorf("unsupported object store '%s'", rawurl)
+	}
+}
+
+// DirStore stores objects as files under a local directory.
+type DirStore struct {
+	dir string
+}
+
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+func (s *DirStore) Name() string {
+	return "file://" + s.dir
+}
+
+func (s *DirStore) Put(ctx context.Context, key string, body []byte) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+stateTempPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *DirStore) List(ctx context.Context, prefix string) ([]string, error) {
+	// Only walk the deepest directory covered by the prefix.
+	root := s.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		root = filepath.Join(s.dir, filepath.FromSlash(prefix[:i]))
+	}
+
+	var keys []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		// Skip uploads in progress.
+		if tmp, _ := filepath.Match("*"+stateTempPattern, info.Name()); tmp {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+
+	// Walk visits the files in lexical order of their path.
+	return keys, err
+}
+
+func (s *DirStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+// S3Store uploads objects to an S3 (or S3 compatible) bucket.
+type S3Store struct {
+	bucket   string
+	prefix   string
+	region   string
+	endpoint string
+	creds    AWSCredentials
+	client   *http.Client
+}
+
+func NewS3Store(bucket, prefix, region, endpoint string, creds AWSCredentials) (*S3Store, error) {
+	if bucket == "" || region == "" {
+		return nil, fmt.Errorf("s3 store requires a bucket and a region")
+	}
+
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+
+	return &S3Store{
+		bucket:   bucket,
+		prefix:   prefix,
+		region:   region,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		creds:    creds,
+		client:   &http.Client{Timeout: 60 * time.Second},
+	}, nil
+}
+
+func (s *S3Store) Hosts() []string {
+	return urlHost(s.endpoint)
+}
+
+func (s *S3Store) Name() string {
+	return fmt.Sprintf("s3://%s/%s?region=%s", s.bucket, s.prefix, s.region)
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, body []byte) error {
+	key = s.key(key)
+
+	// Path style addressing works for both AWS and S3 compatible stores.
+	u := s.endpoint + "/" + s.bucket + "/" + (&url.URL{Path: key}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	signV4(req, body, s.creds, s.region, "s3", time.Now())
+	Identify(req)
+
+	rep, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(rep.Body)
+		return fmt.Errorf("%s: put %s: %s: %s", s.Name(), key, rep.Status, msg)
+	}
+
+	return nil
+}
+
+func (s *S3Store) key(key string) string {
+	if s.prefix != "" {
+		return strings.TrimSuffix(s.prefix, "/") + "/" + key
+	}
+	return key
+}
+
+// do sends a signed request, failing on statuses other than 200.
+func (s *S3Store) do(ctx context.Context, method, u string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	signV4(req, body, s.creds, s.region, "s3", time.Now())
+	Identify(req)
+
+	rep, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+
+	payload, err := ioutil.ReadAll(rep.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rep.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s %s: %s: %s", s.Name(), method, req.URL.Path, rep.Status, payload)
+	}
+
+	return payload, nil
+}
+
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+
+	query := url.Values{"list-type": {"2"}, "prefix": {s.key(prefix)}}
+	for {
+		payload, err := s.do(ctx, http.MethodGet, s.endpoint+"/"+s.bucket+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(payload, &result); err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(object.Key, s.key("")))
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	u := s.endpoint + "/" + s.bucket + "/" + (&url.URL{Path: s.key(key)}).EscapedPath()
+	return s.do(ctx, http.MethodGet, u, nil)
+}
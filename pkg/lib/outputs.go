@@ -0,0 +1,679 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const defaultOutputTimeout = 30 * time.Second
+
+// OpenSink opens an output sink from its URL, one of
+//
+//	stdout
+//	file:///var/lib/github-feed/events?rotate=1h&max-size=100M&gzip=true
+//	https://collector.local/events[?gzip=true&max-payload=256K&cloudevents=binary&secret-env=NAME]
+//	webhook+https://receiver.local/hooks[?secret-env=NAME]
+//	nats://[user:password@]localhost:4222/github.events
+//	kafka://[user:password@]rest-proxy:8082/github-events[?tls=true&max-payload=1M]
+//	eventbridge://bus-name?region=us-east-1[&source=github-feed&endpoint=https://events.local]
+//	eventhubs://[key-name:key@]namespace/hub[?partition-key=repo.name&client-id=id]
+//
+// The records are encoded by s, JSON if nil. Files are rotated every rotate
+// interval and once max-size bytes (K, M and G suffixes) were written, see
+// RotatingFileSink. HTTP endpoints receive a POST per batch, or CloudEvents
+// in the binary, structured or batch mode, signed with the secret of the
+// secret-env environment variable if set, see HTTPSink. Webhook receivers
+// get each event as a GitHub webhook delivery, see WebhookSink. Kafka topics
+// are written through a Kafka REST Proxy (v2 API), with the basic
+// authentication of the URL's credentials if set. EventBridge buses
+// receive PutEvents requests signed with the credentials of the AWS
+// environment variables, see EventBridgeSink, split at 256K by default.
+// Azure event hubs receive a batch per write, authorized by the shared
+// access key of the URL or the host's managed identity, see EventHubsSink,
+// split at 1M by default. The requests of more than max-payload bytes are
+// split, see PayloadLimitSink.
+func OpenSink(rawurl string, s Serializer) (Sink, error) {
+	open, err := parseSinkURL(rawurl, s)
+	if err != nil {
+		return nil, err
+	}
+	return open()
+}
+
+// ValidateSinkURL checks the URL of a sink, see OpenSink, without opening
+// it.
+func ValidateSinkURL(rawurl string) error {
+	_, err := parseSinkURL(rawurl, nil)
+	return err
+}
+
+// parseSinkURL returns the function opening the sink of the URL.
+func parseSinkURL(rawurl string, s Serializer) (func() (Sink, error), error) {
+	sink := func(sink Sink, err error) (func() (Sink, error), error) {
+		if err != nil {
+			return nil, err
+		}
+		return func() (Sink, error) { return sink, nil }, nil
+	}
+
+	if rawurl == "stdout" || rawurl == "-" {
+		return sink(NewWriterSink(os.Stdout, s), nil)
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	compress, err := queryBool(query, "gzip")
+	if err != nil {
+		return nil, err
+	}
+	var maxPayload int64
+	if v := query.Get("max-payload"); v != "" {
+		if maxPayload, err = parseByteSize(v); err != nil {
+			return nil, err
+		}
+	}
+	limit := func(sink Sink) Sink {
+		if maxPayload > 0 {
+			return NewPayloadLimitSink(sink, int(maxPayload))
+		}
+		return sink
+	}
+
+	switch u.Scheme {
+	case "stdout":
+		return sink(NewWriterSink(os.Stdout, s), nil)
+	case "file":
+		var rotate time.Duration
+		if v := query.Get("rotate"); v != "" {
+			if rotate, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("invalid rotate interval '%s': %w", v, err)
+			}
+		}
+		var maxSize int64
+		if v := query.Get("max-size"); v != "" {
+			if maxSize, err = parseByteSize(v); err != nil {
+				return nil, err
+			}
+		}
+		return func() (Sink, error) { return NewRotatingFileSink(u.Path, s, rotate, maxSize, compress) }, nil
+	case "http", "https":
+		mode := query.Get("cloudevents")
+		if err := validCloudEventsMode(mode); err != nil {
+			return nil, err
+		}
+		secret, err := querySecret(query, rawurl)
+		if err != nil {
+			return nil, err
+		}
+		u.RawQuery = withoutSinkOptions(u.RawQuery)
+		http := NewHTTPSink(u.String(), s, compress)
+		http.CloudEvents = mode
+		http.Secret = secret
+		return sink(limit(http), nil)
+	case "webhook+http", "webhook+https":
+		secret, err := querySecret(query, rawurl)
+		if err != nil {
+			return nil, err
+		}
+		u.Scheme = strings.TrimPrefix(u.Scheme, "webhook+")
+		u.RawQuery = withoutSinkOptions(u.RawQuery)
+		webhook := NewWebhookSink(u.String())
+		webhook.Secret = secret
+		return sink(webhook, nil)
+	case "nats":
+		return sink(NewNATSSink(u.Host, strings.TrimPrefix(u.Path, "/"), u.User, s))
+	case "kafka":
+		tls, err := queryBool(query, "tls")
+		if err != nil {
+			return nil, err
+		}
+		scheme := "http"
+		if tls {
+			scheme = "https"
+		}
+		topic := path.Base(u.Path)
+		if topic == "/" || topic == "." {
+			return nil, fmt.Errorf("kafka sink '%s' lacks a topic", Redact(rawurl))
+		}
+		// The proxy's basic authentication, if any.
+		if u.User != nil {
+			password, _ := u.User.Password()
+			RegisterSecret(password)
+		}
+		proxy := &url.URL{Scheme: scheme, User: u.User, Host: u.Host, Path: path.Dir(u.Path)}
+		return sink(limit(NewKafkaRESTSink(proxy.String(), topic, s)), nil)
+	case "eventbridge":
+		region := query.Get("region")
+		if region == "" {
+			region = os.Getenv("AWS_REGION")
+		}
+		if u.Host == "" || region == "" {
+			return nil, fmt.Errorf("eventbridge sink '%s' requires a bus and a region", Redact(rawurl))
+		}
+		if serializerOrDefault(s).Binary() {
+			return nil, fmt.Errorf("eventbridge sink '%s' requires JSON records", Redact(rawurl))
+		}
+		if maxPayload == 0 {
+			maxPayload = eventBridgeMaxPayload
+		}
+		return func() (Sink, error) {
+			creds, err := AWSCredentialsFromEnv()
+			if err != nil {
+				return nil, err
+			}
+			eb, err := NewEventBridgeSink(u.Host, region, query.Get("endpoint"), creds, s)
+			if err != nil {
+				return nil, err
+			}
+			eb.Source = query.Get("source")
+			return limit(eb), nil
+		}, nil
+	case "eventhubs":
+		namespace, hub := u.Hostname(), strings.Trim(u.Path, "/")
+		if namespace == "" || hub == "" {
+			return nil, fmt.Errorf("eventhubs sink '%s' requires a namespace and an event hub", Redact(rawurl))
+		}
+		if !strings.Contains(namespace, ".") {
+			namespace += ".servicebus.windows.net"
+		}
+		if serializerOrDefault(s).Binary() {
+			return nil, fmt.Errorf("eventhubs sink '%s' requires JSON records", Redact(rawurl))
+		}
+		var key *KeySpec
+		if v := query.Get("partition-key"); v != "" {
+			if key, err = ParseKeySpec(v); err != nil {
+				return nil, err
+			}
+		}
+		var auth EventHubsAuth = &ManagedIdentityAuth{ClientID: query.Get("client-id")}
+		if u.User != nil {
+			secret, _ := u.User.Password()
+			RegisterSecret(secret)
+			auth = &SharedAccessKeyAuth{KeyName: u.User.Username(), Key: secret}
+		}
+		if maxPayload == 0 {
+			maxPayload = eventHubsMaxPayload
+		}
+		eh, err := NewEventHubsSink(namespace, hub, query.Get("endpoint"), auth, s)
+		if err != nil {
+			return nil, err
+		}
+		eh.PartitionKey = key
+		return sink(limit(eh), nil)
+	default:
+		return nil, fmt.Errorf("unsupported sink '%s'", Redact(rawurl))
+	}
+}
+
+// querySecret returns the secret in the environment variable named by the
+// secret-env query parameter, none without.
+func querySecret(query url.Values, rawurl string) ([]byte, error) {
+	name := query.Get("secret-env")
+	if name == "" {
+		return nil, nil
+	}
+	secret := os.Getenv(name)
+	if secret == "" {
+		return nil, fmt.Errorf("sink '%s': the secret-env variable %s is not set", Redact(rawurl), name)
+	}
+	RegisterSecret(secret)
+	return []byte(secret), nil
+}
+
+// sinkOptions are the query parameters of the sink URLs read by OpenSink,
+// the others are the endpoints'.
+var sinkOptions = map[string]bool{"gzip": true, "max-payload": true, "cloudevents": true, "secret-env": true}
+
+// withoutSinkOptions removes the sinkOptions from a query, keeping the other
+// parameters as they were written.
+func withoutSinkOptions(rawQuery string) string {
+	var kept []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		key := strings.SplitN(param, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if param != "" && !sinkOptions[key] {
+			kept = append(kept, param)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+func queryBool(query url.Values, key string) (bool, error) {
+	v := query.Get(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s '%s', expected a boolean", key, v)
+	}
+	return b, nil
+}
+
+// parseByteSize parses a size in bytes, with an optional K, M or G suffix
+// (powers of 1024), e.g. 100M.
+func parseByteSize(s string) (int64, error) {
+	multiplier := int64(1)
+	digits := strings.TrimSuffix(strings.ToUpper(s), "B")
+	switch {
+	case strings.HasSuffix(digits, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(digits, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(digits, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		digits = digits[:len(digits)-1]
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s', expected bytes with an optional K, M or G suffix", s)
+	}
+	return n * multiplier, nil
+}
+
+// WriterSink writes the records of each batch to a writer, e.g. stdout. The
+// writer isn't closed by Close.
+type WriterSink struct {
+	serializer Serializer
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer, s Serializer) *WriterSink {
+	return &WriterSink{serializer: serializerOrDefault(s), w: w}
+}
+
+// Write encodes the batch before writing it at once, so that concurrent
+// writers of the same file don't interleave partial records.
+func (s *WriterSink) Write(ctx context.Context, events []*github.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := encodeRecords(&buf, events, s.serializer); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(buf.Bytes())
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// HTTPSinkKeyHeader is the header of the HTTP sink's requests identifying
+// their batch, see HTTPSink.
+const HTTPSinkKeyHeader = "Idempotency-Key"
+
+// HTTPSink POSTs each batch to an endpoint as a stream of records, NDJSON by
+// default (application/x-ndjson), optionally gzipped. Responses other than
+// 2xx fail the write.
+//
+// With CloudEvents set, the events are posted as CloudEvents (see
+// CloudEvent) over HTTP: one per request in the binary and structured
+// modes, or the batch as a JSON array in the batch mode.
+//
+// Deliveries are at least once: a batch may be posted again, e.g. retried
+// by a DeadLetterSink after a timeout or redriven from the dead letters, or
+// its events redelivered by a process restarted before its Deduplicator was
+// saved. Each request carries the batch's content hash in
+// HTTPSinkKeyHeader, the same for every delivery of the same events (see
+// batchKey, the dead letters are redriven in their batch), and each record
+// its event's digest. The key only holds while
+// the batch does: the events redelivered after a restart are batched anew,
+// receivers drop those by the digests of the records. A 409 Conflict
+// answer, of a receiver rejecting a known key, is a success.
+//
+// With Secret set, each request carries the HMAC-SHA256 of its body, as
+// sent, in the X-Hub-Signature-256 header (sha256=<hex>), so that receivers
+// authenticate the requests as they do GitHub's webhook deliveries, e.g.
+// with github.ValidateSignature.
+type HTTPSink struct {
+	url        string
+	serializer Serializer
+	compress   bool
+	client     *http.Client
+	// CloudEvents mode of the requests, CloudEventsBinary,
+	// CloudEventsStructured or CloudEventsBatch, none if empty.
+	CloudEvents string
+	// Secret signing the requests, unsigned if empty.
+	Secret []byte
+}
+
+// HTTPSinkSignatureHeader is the header of the signature of the requests of
+// an HTTPSink with a secret.
+const HTTPSinkSignatureHeader = "X-Hub-Signature-256"
+
+func NewHTTPSink(url string, s Serializer, compress bool) *HTTPSink {
+	return &HTTPSink{
+		url:        url,
+		serializer: serializerOrDefault(s),
+		compress:   compress,
+		client:     &http.Client{Timeout: defaultOutputTimeout},
+	}
+}
+
+func (s *HTTPSink) contentType() string {
+	if s.serializer.Extension() == "ndjson" {
+		return "application/x-ndjson"
+	}
+	return s.serializer.ContentType()
+}
+
+// perEvent tells whether the events are posted one per request.
+func (s *HTTPSink) perEvent() bool {
+	return s.CloudEvents == CloudEventsBinary || s.CloudEvents == CloudEventsStructured
+}
+
+// encode returns the body of the request of the events, a single one in the
+// per event modes, and sets its headers.
+func (s *HTTPSink) encode(events []*github.Event, header http.Header) ([]byte, error) {
+	var body []byte
+	switch s.CloudEvents {
+	case CloudEventsBinary:
+		ce, err := NewCloudEvent(events[0], s.serializer)
+		if err != nil {
+			return nil, err
+		}
+		setCloudEventHeaders(header, ce)
+		body = ce.Data
+		if s.serializer.Binary() {
+			body = ce.DataBase64
+		}
+	case CloudEventsStructured:
+		ce, err := NewCloudEvent(events[0], s.serializer)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = json.Marshal(ce); err != nil {
+			return nil, err
+		}
+		header.Set("Content-Type", cloudEventsContentType)
+	case CloudEventsBatch:
+		var err error
+		if body, err = encodeCloudEvents(events, s.serializer); err != nil {
+			return nil, err
+		}
+		header.Set("Content-Type", cloudEventsBatchType)
+	default:
+		var buf bytes.Buffer
+		if err := encodeRecords(&buf, events, s.serializer); err != nil {
+			return nil, err
+		}
+		body = buf.Bytes()
+		header.Set("Content-Type", s.contentType())
+	}
+
+	if !s.compress {
+		return body, nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(body)
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	header.Set("Content-Encoding", "gzip")
+	return buf.Bytes(), nil
+}
+
+// PayloadSize returns the size of the batch's request body, compressed if
+// enabled, or of its largest request in the per event modes.
+func (s *HTTPSink) PayloadSize(events []*github.Event) (int, error) {
+	if !s.perEvent() {
+		body, err := s.encode(events, make(http.Header))
+		return len(body), err
+	}
+
+	size := 0
+	for _, ev := range events {
+		body, err := s.encode([]*github.Event{ev}, make(http.Header))
+		if err != nil {
+			return 0, err
+		}
+		if len(body) > size {
+			size = len(body)
+		}
+	}
+	return size, nil
+}
+
+func (s *HTTPSink) Write(ctx context.Context, events []*github.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	if !s.perEvent() {
+		return s.post(ctx, events)
+	}
+	for _, ev := range events {
+		if err := s.post(ctx, []*github.Event{ev}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post sends the request of the events.
+func (s *HTTPSink) post(ctx context.Context, events []*github.Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, nil)
+	if err != nil {
+		return err
+	}
+	body, err := s.encode(events, req.Header)
+	if err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) { return ioutil.NopCloser(bytes.NewReader(body)), nil }
+
+	key, err := batchKey(events)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HTTPSinkKeyHeader, key)
+	if len(s.Secret) > 0 {
+		req.Header.Set(HTTPSinkSignatureHeader, "sha256="+hmacHex(sha256.New, s.Secret, body))
+	}
+	Identify(req)
+
+	rep, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode/100 != 2 && rep.StatusCode != http.StatusConflict {
+		body, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 512))
+		return fmt.Errorf("POST %s: %s: %s", Redact(s.url), rep.Status, bytes.TrimSpace(body))
+	}
+
+	io.Copy(ioutil.Discard, rep.Body)
+	return nil
+}
+
+func (s *HTTPSink) Hosts() []string {
+	return urlHost(s.url)
+}
+
+func (s *HTTPSink) Close() error {
+	return nil
+}
+
+// batchKey returns the content hash of a batch, the SHA-256 of its events'
+// digests (see EventDigest), which doesn't depend on the serializer nor on
+// the compression of the request. Another batch of the same events, in the
+// same order, has the same key; a batch of some of them doesn't.
+func batchKey(events []*github.Event) (string, error) {
+	h := sha256.New()
+	for _, ev := range events {
+		digest, err := EventDigest(ev)
+		if err != nil {
+			return "", err
+		}
+		io.WriteString(h, digest)
+		h.Write([]byte{'\n'})
+	}
+	return digestPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// KafkaRESTSink produces the records of each batch to a Kafka topic through
+// a Kafka REST Proxy (v2 API). Records are keyed by repository name, so
+// that the events of a repository land in the same partition, in order.
+// JSON records are produced as JSON values, the binary formats (msgpack,
+// cbor) as binary values.
+type KafkaRESTSink struct {
+	url        string
+	serializer Serializer
+	client     *http.Client
+}
+
+func NewKafkaRESTSink(proxy, topic string, s Serializer) *KafkaRESTSink {
+	return &KafkaRESTSink{
+		url:        strings.TrimSuffix(proxy, "/") + "/topics/" + url.PathEscape(topic),
+		serializer: serializerOrDefault(s),
+		client:     &http.Client{Timeout: defaultOutputTimeout},
+	}
+}
+
+type kafkaRecord struct {
+	Key   interface{} `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type kafkaProduceResponse struct {
+	Offsets []struct {
+		Partition int    `json:"partition"`
+		Offset    int64  `json:"offset"`
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// encode returns the body of the batch's produce request.
+func (s *KafkaRESTSink) encode(events []*github.Event) ([]byte, error) {
+	binary := s.serializer.Binary()
+	records := make([]kafkaRecord, len(events))
+	for i, ev := range events {
+		value, err := s.serializer.Marshal(NewRecord(ev))
+		if err != nil {
+			return nil, err
+		}
+
+		key := ev.GetRepo().GetName()
+		if binary {
+			records[i] = kafkaRecord{Key: base64.StdEncoding.EncodeToString([]byte(key)), Value: base64.StdEncoding.EncodeToString(value)}
+		} else {
+			records[i] = kafkaRecord{Key: key, Value: json.RawMessage(value)}
+		}
+	}
+
+	return json.Marshal(struct {
+		Records []kafkaRecord `json:"records"`
+	}{records})
+}
+
+// PayloadSize returns the size of the batch's produce request body, the
+// binary records base64 encoded.
+func (s *KafkaRESTSink) PayloadSize(events []*github.Event) (int, error) {
+	body, err := s.encode(events)
+	return len(body), err
+}
+
+func (s *KafkaRESTSink) Write(ctx context.Context, events []*github.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	body, err := s.encode(events)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if s.serializer.Binary() {
+		req.Header.Set("Content-Type", "application/vnd.kafka.binary.v2+json")
+	} else {
+		req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	}
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	Identify(req)
+
+	rep, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	payload, err := ioutil.ReadAll(rep.Body)
+	if err != nil {
+		return err
+	}
+	if rep.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: %s: %s", Redact(s.url), rep.Status, bytes.TrimSpace(payload))
+	}
+
+	// The proxy answers 200 even if some records failed.
+	var produced kafkaProduceResponse
+	if err := json.Unmarshal(payload, &produced); err != nil {
+		return fmt.Errorf("POST %s: invalid response: %w", Redact(s.url), err)
+	}
+	failed := 0
+	var first string
+	for _, o := range produced.Offsets {
+		if o.ErrorCode != nil || o.Error != "" {
+			if failed == 0 {
+				first = o.Error
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("kafka rejected %d of %d records: %s", failed, len(events), first)
+	}
+
+	return nil
+}
+
+func (s *KafkaRESTSink) Hosts() []string {
+	return urlHost(s.url)
+}
+
+func (s *KafkaRESTSink) Close() error {
+	return nil
+}
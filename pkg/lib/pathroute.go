@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	defaultPathRouteBudget = 1000
+	defaultPathRouteTime   = 5 * time.Second
+	// Routes of the events remembered for their records.
+	pathRouteCacheSize = 100000
+	pathRoutesField    = "routes"
+	// SHA of the parent of a branch's first commit.
+	zeroSHA = "0000000000000000000000000000000000000000"
+)
+
+// PathRoute routes the pushes of a repository changing a path matching
+// Pattern to Route, e.g. a team's topic.
+type PathRoute struct {
+	Repo string
+	// A path prefix ending with /** (services/payments/**) or a glob
+	// matching the changed files (*.proto).
+	Pattern string
+	Route   string
+}
+
+// ParsePathRoute parses a route written repo:pattern=route, e.g.
+// acme/monorepo:services/payments/**=payments.
+func ParsePathRoute(s string) (PathRoute, error) {
+	i, j := strings.Index(s, ":"), strings.LastIndex(s, "=")
+	if i < 0 || j < i {
+		return PathRoute{}, fmt.Errorf("invalid path route '%s', expected repo:pattern=route", s)
+	}
+
+	r := PathRoute{Repo: s[:i], Pattern: s[i+1 : j], Route: s[j+1:]}
+	if strings.Count(r.Repo, "/") != 1 || r.Pattern == "" || r.Route == "" {
+		return PathRoute{}, fmt.Errorf("invalid path route '%s', expected owner/name:pattern=route", s)
+	}
+	if _, err := path.Match(r.Pattern, ""); err != nil {
+		return PathRoute{}, fmt.Errorf("invalid path route '%s': %v", s, err)
+	}
+	return r, nil
+}
+
+// Match reports whether a changed file matches the route's pattern.
+func (r PathRoute) Match(file string) bool {
+	if strings.HasSuffix(r.Pattern, "/**") {
+		return strings.HasPrefix(file, strings.TrimSuffix(r.Pattern, "**"))
+	}
+	ok, _ := path.Match(r.Pattern, file)
+	return ok
+}
+
+// PathRouter routes the pushes to monorepos by the paths they change, so
+// that teams can follow the activity of their part of the repository: the
+// changed files are fetched with the Compare API, a request per push, and
+// the routes matching them are returned by Routes, and set in the `routes`
+// field of the records once attached (see AttachToRecords).
+//
+// Pushes are resolved before being emitted, so that they are routed as
+// published. Requests are capped by an hourly budget, or the shared one of
+// Scheduler, at normal priority: the pushes rejected, or creating branches
+// (nothing to compare with), are left unrouted.
+type PathRouter struct {
+	// Called with non-fatal errors, e.g. EventFeed.ReportError.
+	ReportError func(op, eventID string, err error)
+	// Shared budget of the requests instead of the router's own, if not nil.
+	Scheduler *EnrichmentScheduler
+
+	client *github.Client
+	routes map[string][]PathRoute
+	own    *EnrichmentScheduler
+	cache  *Cache
+}
+
+// NewPathRouter returns a router of the pushes to the routes' repositories,
+// allowing at most budget requests per hour (1000 if zero) unless
+// Scheduler is set.
+func NewPathRouter(client *github.Client, routes []PathRoute, budget int) *PathRouter {
+	r := &PathRouter{
+		client: client,
+		routes: make(map[string][]PathRoute),
+		own:    NewEnrichmentScheduler(EnrichmentConfig{Budget: orDefault(budget, defaultPathRouteBudget)}),
+		cache:  NewCache("path_routes", CacheConfig{MaxEntries: pathRouteCacheSize}),
+	}
+	for _, route := range routes {
+		key := strings.ToLower(route.Repo)
+		r.routes[key] = append(r.routes[key], route)
+	}
+	return r
+}
+
+// Serve runs the router's own budget until ctx is done, unless Scheduler is
+// set.
+func (r *PathRouter) Serve(ctx context.Context) error {
+	if r.Scheduler != nil {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return r.own.Serve(ctx)
+}
+
+type pathRoutePayload struct {
+	Before *string `json:"before"`
+	Head   *string `json:"head"`
+	After  *string `json:"after"`
+}
+
+// Resolve routes the pushes of the batch to the routed repositories,
+// returning once their changed files were fetched or the requests
+// rejected.
+func (r *PathRouter) Resolve(ctx context.Context, events []*github.Event) {
+	var pushes []*github.Event
+	for _, ev := range events {
+		if ev.GetType() != "PushEvent" || ev.RawPayload == nil || len(r.routes[strings.ToLower(ev.GetRepo().GetName())]) == 0 {
+			continue
+		}
+		if _, ok := r.cache.Get(ev.GetID()); !ok {
+			pushes = append(pushes, ev)
+		}
+	}
+	if len(pushes) == 0 {
+		return
+	}
+
+	scheduler, priority := r.Scheduler, EnrichNormal
+	if scheduler == nil {
+		scheduler, priority = r.own, EnrichHigh
+	}
+	scheduler.Enrich(ctx, priority, pushes, r.resolve, r.ReportError)
+}
+
+func (r *PathRouter) resolve(ctx context.Context, ev *github.Event) error {
+	var payload pathRoutePayload
+	if err := json.Unmarshal(*ev.RawPayload, &payload); err != nil {
+		return err
+	}
+	base, head := stringOrEmpty(payload.Before), stringOrEmpty(payload.Head)
+	if head == "" {
+		head = stringOrEmpty(payload.After)
+	}
+	if base == "" || base == zeroSHA || head == "" || head == zeroSHA {
+		return nil
+	}
+
+	repo := ev.GetRepo().GetName()
+	parts := strings.SplitN(repo, "/", 2)
+	ctx, cancel := context.WithTimeout(ctx, defaultPathRouteTime)
+	defer cancel()
+	comparison, _, err := r.client.Repositories.CompareCommits(WithSubsystem(ctx, SubsystemPathRoute), parts[0], parts[1], base, head)
+	if err != nil {
+		return fmt.Errorf("comparing %s %s...%s: %w", repo, base, head, err)
+	}
+
+	matched := make(map[string]bool)
+	for _, route := range r.routes[strings.ToLower(repo)] {
+		for _, file := range comparison.Files {
+			if route.Match(file.GetFilename()) {
+				matched[route.Route] = true
+				break
+			}
+		}
+	}
+	routes := make([]string, 0, len(matched))
+	for route := range matched {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	r.cache.Set(ev.GetID(), routes, len(ev.GetID())+16*len(routes)+64)
+	return nil
+}
+
+// Routes returns the routes of a push resolved by Resolve, sorted.
+func (r *PathRouter) Routes(ev *github.Event) []string {
+	v, ok := r.cache.Get(ev.GetID())
+	if !ok {
+		return nil
+	}
+	return v.([]string)
+}
+
+// recordRoutes sets the routes of the records built by NewRecord, see
+// AttachToRecords.
+var recordRoutes *PathRouter
+
+// AttachToRecords sets the routes of the pushes in the `routes` field of the
+// records built from then on, which is not part of their digest. It must be
+// called before records are built concurrently.
+func (r *PathRouter) AttachToRecords() {
+	recordRoutes = r
+}
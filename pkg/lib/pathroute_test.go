@@ -0,0 +1,106 @@
+package lib_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestParsePathRoute(t *testing.T) {
+	r, err := lib.ParsePathRoute("acme/mono:services/payments/**=payments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != (lib.PathRoute{Repo: "acme/mono", Pattern: "services/payments/**", Route: "payments"}) {
+		t.Errorf("got %+v", r)
+	}
+	for file, match := range map[string]bool{
+		"services/payments/api/main.go": true,
+		"services/payments.md":          false,
+		"services/billing/main.go":      false,
+	} {
+		if r.Match(file) != match {
+			t.Errorf("%s: got match %v", file, !match)
+		}
+	}
+
+	for _, s := range []string{"acme/mono", "acme:x=y", "acme/mono:=y", "acme/mono:[=y"} {
+		if _, err := lib.ParsePathRoute(s); err == nil {
+			t.Errorf("%s: parsed", s)
+		}
+	}
+}
+
+func TestPathRouter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acme/mono/compare/a...b" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"files": []map[string]string{
+			{"filename": "services/payments/api.go"},
+			{"filename": "proto/payments.proto"},
+		}})
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	var routes []lib.PathRoute
+	for _, s := range []string{"acme/mono:services/payments/**=payments", "acme/mono:proto/*.proto=api", "acme/mono:web/**=frontend"} {
+		r, err := lib.ParsePathRoute(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routes = append(routes, r)
+	}
+	router := lib.NewPathRouter(client, routes, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Serve(ctx)
+
+	push := func(id, repo, payload string) *github.Event {
+		raw := json.RawMessage(payload)
+		return &github.Event{ID: github.String(id), Type: github.String("PushEvent"), Repo: &github.Repository{Name: github.String(repo)}, RawPayload: &raw}
+	}
+	events := []*github.Event{
+		push("1", "acme/mono", `{"before":"a","head":"b"}`),
+		// A new branch, without base.
+		push("2", "acme/mono", `{"before":"0000000000000000000000000000000000000000","head":"b"}`),
+		push("3", "acme/other", `{"before":"a","head":"b"}`),
+	}
+	router.Resolve(ctx, events)
+
+	if got, want := router.Routes(events[0]), []string{"api", "payments"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got routes %v, want %v", got, want)
+	}
+	for _, ev := range events[1:] {
+		if got := router.Routes(ev); len(got) != 0 {
+			t.Errorf("%s: got routes %v", ev.GetID(), got)
+		}
+	}
+
+	// The routes are set in the records, outside of their digest.
+	digest := lib.NewRecord(events[0]).Digest
+	router.AttachToRecords()
+	defer lib.NewPathRouter(nil, nil, 0).AttachToRecords()
+	record := lib.NewRecord(events[0])
+	if !reflect.DeepEqual(record.Routes, []string{"api", "payments"}) || record.Digest != digest {
+		t.Errorf("got the record routes %v and digest %s, want %s", record.Routes, record.Digest, digest)
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.VerifyRecord(b); err != nil {
+		t.Error(err)
+	}
+}
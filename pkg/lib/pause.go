@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// pauseState is the pause switch of a feed, see EventFeed.Pause.
+type pauseState struct {
+	mu     sync.Mutex
+	paused bool
+	// Closed on Resume.
+	resumed chan struct{}
+}
+
+// Pause stops polling until Resume, without tearing the feed down: the poll
+// in flight completes and its batch is published, then the sources wait.
+// The cache of API responses, the deduplicator and the checkpoint are kept
+// warm, so that polling resumes where it left off. Webhook feeds answer the
+// deliveries received while paused with 503 Service Unavailable.
+//
+// Pausing a paused feed is a no-op, as is resuming a running one.
+func (f *EventFeed) Pause() {
+	f.pause.mu.Lock()
+	defer f.pause.mu.Unlock()
+
+	if !f.pause.paused {
+		f.pause.paused = true
+		f.pause.resumed = make(chan struct{})
+		log.Print("Feed paused")
+	}
+}
+
+// Resume restarts polling after Pause. The sources whose poll interval
+// elapsed while paused poll at once.
+func (f *EventFeed) Resume() {
+	f.pause.mu.Lock()
+	defer f.pause.mu.Unlock()
+
+	if f.pause.paused {
+		f.pause.paused = false
+		close(f.pause.resumed)
+		log.Print("Feed resumed")
+	}
+}
+
+// Paused tells whether the feed is paused.
+func (f *EventFeed) Paused() bool {
+	f.pause.mu.Lock()
+	defer f.pause.mu.Unlock()
+	return f.pause.paused
+}
+
+// waitResumed returns once the feed isn't paused, or ctx is done.
+func (f *EventFeed) waitResumed(ctx context.Context) error {
+	f.pause.mu.Lock()
+	paused, resumed := f.pause.paused, f.pause.resumed
+	f.pause.mu.Unlock()
+
+	if !paused {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
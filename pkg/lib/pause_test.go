@@ -0,0 +1,76 @@
+package lib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestFeedPauseResume(t *testing.T) {
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&polls, 1)
+		w.Header().Set("X-Poll-Interval", "60")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":"` + strconv.Itoa(int(n)) + `","type":"PushEvent","actor":{"login":"a"}}]`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: server.URL, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+
+	nextBatch(t, events)
+	waitForTimer(t, clock)
+
+	feed.Pause()
+	feed.Pause()
+	if !feed.Paused() {
+		t.Fatal("the feed isn't paused")
+	}
+
+	// The poll interval elapses while paused, without polling.
+	clock.Advance(2 * time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&polls); n != 1 {
+		t.Errorf("polled %d times while paused, want 1", n)
+	}
+	select {
+	case batch := <-events:
+		t.Errorf("got batch %v while paused", batch)
+	default:
+	}
+
+	// Overdue, the feed polls at once.
+	feed.Resume()
+	if batch := nextBatch(t, events); len(batch) != 1 || batch[0].GetID() != "2" {
+		t.Errorf("got batch %v after resuming, want event 2", batch)
+	}
+	if feed.Paused() {
+		t.Error("the feed is still paused")
+	}
+
+	// Pausing doesn't stop the feed from shutting down.
+	feed.Pause()
+	cancel()
+	select {
+	case _, ok := <-events:
+		for ok {
+			_, ok = <-events
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the paused feed to stop")
+	}
+}
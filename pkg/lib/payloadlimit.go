@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// PayloadSizer is implemented by the sinks writing each batch as a single
+// payload, e.g. the body of a request, whose size their destination bounds.
+type PayloadSizer interface {
+	// PayloadSize returns the bytes written for the batch, its envelope,
+	// encoding and compression included.
+	PayloadSize(events []*github.Event) (int, error)
+}
+
+// PayloadTooLargeError reports the events a sink didn't write because their
+// record alone exceeds the payload limit of its destination, the others of
+// the batch were written. Writing them again fails the same, a
+// DeadLetterSink queues them without retrying.
+type PayloadTooLargeError struct {
+	Events []*github.Event
+	// Payload limit in bytes.
+	Limit int
+}
+
+func (e *PayloadTooLargeError) Error() string {
+	return fmt.Sprintf("%d events exceed the maximum payload of %d bytes, e.g. %s", len(e.Events), e.Limit, e.Events[0].GetID())
+}
+
+// PayloadLimitSink splits the batches written to a PayloadSizer sink in
+// halves until their payload fits in MaxBytes, so that a destination
+// bounding its payloads (e.g. an SQS queue behind an HTTP endpoint, 256K)
+// receives the batch in several writes rather than rejecting it whole. The
+// events too large on their own are returned in a PayloadTooLargeError.
+// The batches are written in order, and as is to the other sinks.
+type PayloadLimitSink struct {
+	Sink
+	MaxBytes int
+}
+
+func NewPayloadLimitSink(sink Sink, maxBytes int) *PayloadLimitSink {
+	return &PayloadLimitSink{Sink: sink, MaxBytes: maxBytes}
+}
+
+// Hosts returns those of the sink.
+func (s *PayloadLimitSink) Hosts() []string {
+	return EgressHosts(s.Sink)
+}
+
+func (s *PayloadLimitSink) Write(ctx context.Context, events []*github.Event) error {
+	sizer, ok := s.Sink.(PayloadSizer)
+	if !ok || s.MaxBytes <= 0 || len(events) == 0 {
+		return s.Sink.Write(ctx, events)
+	}
+
+	var tooLarge []*github.Event
+	if err := s.write(ctx, sizer, events, &tooLarge); err != nil {
+		return err
+	}
+	if len(tooLarge) > 0 {
+		return &PayloadTooLargeError{Events: tooLarge, Limit: s.MaxBytes}
+	}
+	return nil
+}
+
+func (s *PayloadLimitSink) write(ctx context.Context, sizer PayloadSizer, events []*github.Event, tooLarge *[]*github.Event) error {
+	size, err := sizer.PayloadSize(events)
+	if err != nil {
+		return err
+	}
+	if size <= s.MaxBytes {
+		return s.Sink.Write(ctx, events)
+	}
+	if len(events) == 1 {
+		*tooLarge = append(*tooLarge, events[0])
+		return nil
+	}
+
+	half := len(events) / 2
+	if err := s.write(ctx, sizer, events[:half], tooLarge); err != nil {
+		return err
+	}
+	return s.write(ctx, sizer, events[half:], tooLarge)
+}
+
+// payloadTooLarge returns the events of a PayloadTooLargeError, nil for the
+// other errors.
+func payloadTooLarge(err error) []*github.Event {
+	var tooLarge *PayloadTooLargeError
+	if errors.As(err, &tooLarge) {
+		return tooLarge.Events
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSnapshotInterval   = 15 * time.Second
+	defaultSnapshotsKept      = 240
+	defaultCPUProfileDuration = 30 * time.Second
+	defaultCPUProfilesKept    = 16
+	defaultCPULoadThreshold   = 0.8
+)
+
+// RuntimeSnapshot is a periodic sample of the process' resource usage.
+type RuntimeSnapshot struct {
+	Time        time.Time `json:"time"`
+	Goroutines  int       `json:"goroutines"`
+	HeapAlloc   uint64    `json:"heap_alloc"`
+	HeapObjects uint64    `json:"heap_objects"`
+	Sys         uint64    `json:"sys"`
+	NumGC       uint32    `json:"num_gc"`
+	// Resident set size, 0 when unknown.
+	RSS uint64 `json:"rss,omitempty"`
+	// Fraction of the available CPUs (GOMAXPROCS) used since the previous
+	// snapshot, -1 when unknown.
+	CPULoad float64 `json:"cpu_load"`
+}
+
+// Profiler samples runtime statistics periodically and, when ProfileDir is
+// set, records a CPU profile whenever the CPU load crosses the threshold.
+type Profiler struct {
+	ProfileDir string
+	// Fraction of GOMAXPROCS above which the process is considered loaded.
+	LoadThreshold float64
+	Interval      time.Duration
+
+	mu        sync.Mutex
+	snapshots []RuntimeSnapshot
+	profiling bool
+
+	lastCPU  time.Duration
+	lastTime time.Time
+}
+
+func NewProfiler(dir string, threshold float64) *Profiler {
+	if threshold <= 0 {
+		threshold = defaultCPULoadThreshold
+	}
+
+	return &Profiler{ProfileDir: dir, LoadThreshold: threshold, Interval: defaultSnapshotInterval}
+}
+
+// TakeSnapshot samples the runtime, without the CPU load which requires a
+// previous sample.
+func TakeSnapshot() RuntimeSnapshot {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	rss, _ := processRSS()
+
+	return RuntimeSnapshot{
+		Time:        time.Now(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   mem.HeapAlloc,
+		HeapObjects: mem.HeapObjects,
+		Sys:         mem.Sys,
+		NumGC:       mem.NumGC,
+		RSS:         rss,
+		CPULoad:     -1,
+	}
+}
+
+func (p *Profiler) sample(now time.Time) RuntimeSnapshot {
+	snap := TakeSnapshot()
+	snap.Time = now
+
+	if cpu, ok := processCPUTime(); ok {
+		if !p.lastTime.IsZero() {
+			elapsed := now.Sub(p.lastTime) * time.Duration(runtime.GOMAXPROCS(0))
+			if elapsed > 0 {
+				snap.CPULoad = float64(cpu-p.lastCPU) / float64(elapsed)
+			}
+		}
+		p.lastCPU, p.lastTime = cpu, now
+	}
+
+	return snap
+}
+
+// Snapshots returns the retained snapshots, oldest first.
+func (p *Profiler) Snapshots() []RuntimeSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]RuntimeSnapshot(nil), p.snapshots...)
+}
+
+// Serve samples until the context is cancelled.
+func (p *Profiler) Serve(ctx context.Context) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			snap := p.sample(now)
+
+			p.mu.Lock()
+			p.snapshots = append(p.snapshots, snap)
+			if len(p.snapshots) > defaultSnapshotsKept {
+				p.snapshots = append(p.snapshots[:0:0], p.snapshots[1:]...)
+			}
+			start := p.ProfileDir != "" && !p.profiling && snap.CPULoad >= p.LoadThreshold
+			p.profiling = p.profiling || start
+			p.mu.Unlock()
+
+			if start {
+				go p.profileCPU(ctx, snap.CPULoad)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *Profiler) profileCPU(ctx context.Context, load float64) {
+	defer func() {
+		p.mu.Lock()
+		p.profiling = false
+		p.mu.Unlock()
+	}()
+
+	path := filepath.Join(p.ProfileDir, fmt.Sprintf("cpu-%s.pprof", time.Now().UTC().Format("20060102T150405Z")))
+	log.Printf("CPU load at %.0f%%, writing CPU profile to %s", load*100, path)
+
+	if err := os.MkdirAll(p.ProfileDir, 0755); err != nil {
+		warnf("Failed creating profile directory: %v", err)
+		return
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		warnf("Failed creating CPU profile: %v", err)
+		return
+	}
+	defer f.Close()
+
+	// Fails if a profile is already being taken, e.g. through the pprof
+	// endpoint.
+	if err := runtimepprof.StartCPUProfile(f); err != nil {
+		warnf("Failed starting CPU profile: %v", err)
+		os.Remove(path)
+		return
+	}
+
+	select {
+	case <-time.After(defaultCPUProfileDuration):
+	case <-ctx.Done():
+	}
+	runtimepprof.StopCPUProfile()
+
+	p.pruneProfiles()
+}
+
+func (p *Profiler) pruneProfiles() {
+	profiles, _ := filepath.Glob(filepath.Join(p.ProfileDir, "cpu-*.pprof"))
+	sort.Strings(profiles)
+	for len(profiles) > defaultCPUProfilesKept {
+		os.Remove(profiles[0])
+		profiles = profiles[1:]
+	}
+}
+
+// RegisterHandlers exposes the pprof handlers under /debug/pprof/ and the
+// snapshots under /debug/snapshots on the mux.
+func (p *Profiler) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.Snapshots())
+	})
+}
@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a classic token bucket, refilled continuously at rate tokens
+// per second up to burst tokens.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// allow consumes a token if one is available.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// reserve consumes a token and returns how long the caller must wait before
+// using it.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	return b.reserveN(now, 1)
+}
+
+// reserveN consumes n tokens, possibly more than the burst, and returns how
+// long the caller must wait before using them.
+func (b *tokenBucket) reserveN(now time.Time, n int) time.Duration {
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// KeyedLimiter maintains a token bucket per key, e.g. per client or per
+// actor. Buckets which are full again are evicted to bound memory.
+type KeyedLimiter struct {
+	// Clock refilling the buckets, the system clock if nil.
+	Clock Clock
+
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// NewKeyedLimiter allows rate events per second and per key, with bursts of
+// up to burst events.
+func NewKeyedLimiter(rate float64, burst int) *KeyedLimiter {
+	return &KeyedLimiter{rate: rate, burst: burst, buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *KeyedLimiter) bucket(key string, now time.Time) *tokenBucket {
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newTokenBucket(l.rate, l.burst, now)
+		l.buckets[key] = b
+	}
+	return b
+}
+
+func (l *KeyedLimiter) sweep(now time.Time) {
+	idle := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	if now.Sub(l.swept) < idle {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// Allow consumes a token of the key's bucket if one is available.
+func (l *KeyedLimiter) Allow(key string) bool {
+	now := clockOrSystem(l.Clock).Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	return l.bucket(key, now).allow(now)
+}
+
+// Wait blocks until the key's bucket grants a token or the context is done.
+func (l *KeyedLimiter) Wait(ctx context.Context, key string) error {
+	clock := clockOrSystem(l.Clock)
+	now := clock.Now()
+
+	l.mu.Lock()
+	l.sweep(now)
+	delay := l.bucket(key, now).reserve(now)
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	select {
+	case <-clock.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Limiter is a token bucket shared by its callers, e.g. the workers of a load
+// generator sending at most a given rate of requests.
+type Limiter struct {
+	// Clock refilling the bucket, the system clock if nil.
+	Clock Clock
+
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	bucket *tokenBucket
+}
+
+// NewLimiter allows rate events per second, with bursts of up to burst
+// events.
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{rate: rate, burst: burst}
+}
+
+// Wait blocks until the bucket grants a token or the context is done. The
+// callers are served in order.
+func (l *Limiter) Wait(ctx context.Context) error {
+	clock := clockOrSystem(l.Clock)
+	now := clock.Now()
+
+	l.mu.Lock()
+	if l.bucket == nil {
+		l.bucket = newTokenBucket(l.rate, l.burst, now)
+	}
+	delay := l.bucket.reserve(now)
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+
+	select {
+	case <-clock.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
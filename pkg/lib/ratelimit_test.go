@@ -0,0 +1,43 @@
+package lib_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestLimiter(t *testing.T) {
+	clock := lib.NewSimulatedClock(time.Date(2020, 8, 20, 14, 0, 0, 0, time.UTC))
+	l := lib.NewLimiter(10, 2)
+	l.Clock = clock
+
+	ctx := context.Background()
+	// The burst is granted at once.
+	for i := 0; i < 2; i++ {
+		if err := l.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- l.Wait(ctx) }()
+	waitForTimer(t, clock)
+	select {
+	case <-done:
+		t.Fatal("token granted past the burst")
+	default:
+	}
+
+	clock.Advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.Wait(cancelled); err != context.Canceled {
+		t.Errorf("expected the wait cancelled, got %v", err)
+	}
+}
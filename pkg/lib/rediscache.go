@@ -0,0 +1,241 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisPort    = "6379"
+	defaultRedisTimeout = 5 * time.Second
+	// Prefix of the keys of the cached responses.
+	redisCacheKeyPrefix = "github-feed:http:"
+)
+
+// RedisCache stores the API responses in Redis, so that the ETags survive
+// restarts and are shared by the replicas of a feed. Replicas sharing a cache
+// must poll with tokens seeing the same events, the responses are keyed by
+// URL only.
+//
+// It speaks the RESP protocol over plain TCP without TLS. The connection is
+// re-established by the command following a failure, the failed commands are
+// handled as misses. Connections go through the dialer of
+// http.DefaultTransport, see ConfigureDialer and GuardTransport.
+type RedisCache struct {
+	addr string
+	user *url.Userinfo
+	db   int
+	ttl  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	// Set once a failure was logged, until the next success.
+	failing bool
+}
+
+// NewRedisCache returns a cache on the Redis server at addr, authenticated
+// with user's password (and name, with ACLs) if not nil, on the database
+// numbered by path (e.g. "/1", 0 if empty). The responses expire after ttl,
+// never if zero.
+func NewRedisCache(addr string, user *url.Userinfo, path string, ttl time.Duration) (*RedisCache, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, defaultRedisPort)
+	}
+
+	db := 0
+	if path = strings.Trim(path, "/"); path != "" {
+		var err error
+		if db, err = strconv.Atoi(path); err != nil || db < 0 {
+			return nil, fmt.Errorf("invalid Redis database '%s'", path)
+		}
+	}
+
+	return &RedisCache{addr: addr, user: user, db: db, ttl: ttl}, nil
+}
+
+func (c *RedisCache) Get(key string) ([]byte, bool) {
+	reply, err := c.do("GET", redisCacheKeyPrefix+key)
+	// Other replies, e.g. of a server mixing up the types, are misses too.
+	response, ok := reply.([]byte)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return response, true
+}
+
+func (c *RedisCache) Set(key string, response []byte) {
+	args := []interface{}{"SET", redisCacheKeyPrefix + key, response}
+	if c.ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(int64(c.ttl/time.Millisecond), 10))
+	}
+	c.do(args...)
+}
+
+func (c *RedisCache) Delete(key string) {
+	c.do("DEL", redisCacheKeyPrefix+key)
+}
+
+// do runs a command, connecting first if needed, and returns its reply: nil,
+// a []byte, a string or an int64.
+func (c *RedisCache) do(args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	reply, err := c.roundTrip(args)
+	if err != nil {
+		c.disconnect()
+		if !c.failing {
+			c.failing = true
+			warnf("Redis cache %s failing, serving misses: %v", c.addr, err)
+		}
+		return nil, err
+	}
+	if c.failing {
+		c.failing = false
+		log.Printf("Redis cache %s recovered", c.addr)
+	}
+	return reply, nil
+}
+
+func (c *RedisCache) roundTrip(args []interface{}) (interface{}, error) {
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return c.command(args...)
+}
+
+func (c *RedisCache) connect() error {
+	dial := (&net.Dialer{}).DialContext
+	if t, ok := http.DefaultTransport.(*http.Transport); ok && t.DialContext != nil {
+		dial = t.DialContext
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRedisTimeout)
+	defer cancel()
+
+	conn, err := dial(ctx, "tcp", c.addr)
+	if err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+
+	if c.user != nil {
+		args := []interface{}{"AUTH"}
+		if pass, ok := c.user.Password(); ok {
+			if name := c.user.Username(); name != "" {
+				args = append(args, name)
+			}
+			args = append(args, pass)
+		} else {
+			args = append(args, c.user.Username())
+		}
+		if _, err := c.command(args...); err != nil {
+			return err
+		}
+	}
+	if c.db != 0 {
+		if _, err := c.command("SELECT", strconv.Itoa(c.db)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// command writes a command as an array of bulk strings and reads its reply.
+func (c *RedisCache) command(args ...interface{}) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(defaultRedisTimeout))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch arg := arg.(type) {
+		case string:
+			b = []byte(arg)
+		case []byte:
+			b = arg
+		}
+		fmt.Fprintf(&buf, "$%d\r\n", len(b))
+		buf.Write(b)
+		buf.WriteString("\r\n")
+	}
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return c.reply()
+}
+
+func (c *RedisCache) reply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty Redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("Redis: " + line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis reply: %s", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	default:
+		return nil, fmt.Errorf("unexpected Redis reply: %s", line)
+	}
+}
+
+func (c *RedisCache) disconnect() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.r = nil, nil
+	}
+}
+
+// Close closes the connection to the server.
+func (c *RedisCache) Hosts() []string {
+	return addrHost(c.addr)
+}
+
+func (c *RedisCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn, c.r = nil, nil
+	return err
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// RepoDiscovery keeps a feed polling the repositories of an organization,
+// or tagged with a topic, listing them every Interval: the repositories
+// created (or tagged) meanwhile are polled within an interval, those
+// deleted, archived or no longer matching are removed. The sources are
+// changed through a SourceManager, recorded as added by the discovery so
+// that the sources added otherwise are left alone.
+//
+// Each repository is a source polled at its own pace, the organization's
+// public events (an org source) cost a single source instead.
+type RepoDiscovery struct {
+	// Interval between the listings, 10 minutes if zero.
+	Interval time.Duration
+	// Called with non-fatal errors, e.g. EventFeed.ReportError.
+	ReportError func(op, eventID string, err error)
+	Clock       Clock
+
+	client  *github.Client
+	manager *SourceManager
+	// What is discovered, e.g. org:kubernetes, and how it is listed.
+	scope   string
+	list    func(ctx context.Context) ([]*github.Repository, error)
+	include globs
+	exclude globs
+}
+
+// NewRepoDiscovery discovers the repositories of org, include and exclude
+// being glob patterns (* and ?, case-insensitive) of their names without
+// the owner, e.g. "kube*". Every repository is included if include is
+// empty.
+func NewRepoDiscovery(client *github.Client, manager *SourceManager, org string, include, exclude []string) (*RepoDiscovery, error) {
+	if org == "" || strings.Contains(org, "/") {
+		return nil, fmt.Errorf("invalid organization '%s'", org)
+	}
+
+	d := &RepoDiscovery{client: client, manager: manager, scope: SourceOrg + ":" + strings.ToLower(org)}
+	d.list = func(ctx context.Context) ([]*github.Repository, error) { return d.listOrg(ctx, org) }
+	var err error
+	if d.include, err = compileGlobs("include", include); err != nil {
+		return nil, err
+	}
+	if d.exclude, err = compileGlobs("exclude", exclude); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// NewTopicDiscovery discovers the repositories tagged with a topic, e.g.
+// kubernetes-operator, with the Search API: the 1000 most recently updated
+// ones at most, a search per 100.
+func NewTopicDiscovery(client *github.Client, manager *SourceManager, topic string) (*RepoDiscovery, error) {
+	if topic == "" || strings.ContainsAny(topic, ": ") {
+		return nil, fmt.Errorf("invalid topic '%s'", topic)
+	}
+
+	d := &RepoDiscovery{client: client, manager: manager, scope: "topic:" + strings.ToLower(topic)}
+	d.list = func(ctx context.Context) ([]*github.Repository, error) { return d.search(ctx, "topic:"+topic) }
+	return d, nil
+}
+
+// owner is the SourceManager's AddedBy of the discovered repositories.
+func (d *RepoDiscovery) owner() string {
+	return "discovery:" + d.scope
+}
+
+func (d *RepoDiscovery) match(repo *github.Repository) bool {
+	name := repo.GetName()
+	return !repo.GetArchived() && (len(d.include) == 0 || d.include.match(name)) && !d.exclude.match(name)
+}
+
+// Serve lists the repositories every interval until ctx is done.
+func (d *RepoDiscovery) Serve(ctx context.Context) error {
+	clock := clockOrSystem(d.Clock)
+	interval := d.Interval
+	if interval <= 0 {
+		interval = defaultDiscoveryInterval
+	}
+
+	for {
+		if err := d.run(ctx); err != nil {
+			d.reportError(err)
+		}
+
+		select {
+		case <-clock.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (d *RepoDiscovery) reportError(err error) {
+	if d.ReportError != nil {
+		d.ReportError(OpDiscover, "", err)
+	}
+}
+
+// run lists the repositories and updates the sources, which are left as
+// they are if the listing fails.
+func (d *RepoDiscovery) run(ctx context.Context) error {
+	repos, err := d.list(ctx)
+	if err != nil {
+		return fmt.Errorf("listing the repositories of %s: %w", d.scope, err)
+	}
+
+	owner := d.owner()
+	wanted := make(map[string]bool, len(repos))
+	for _, repo := range repos {
+		if !d.match(repo) {
+			continue
+		}
+
+		source := Source{Type: SourceRepo, Name: repo.GetFullName()}
+		wanted[strings.ToLower(source.String())] = true
+		if _, err := d.manager.Add(source, owner); err != nil {
+			d.reportError(fmt.Errorf("adding %s: %w", source, err))
+		}
+	}
+
+	for _, s := range d.manager.List() {
+		if s.AddedBy != owner || wanted[strings.ToLower(s.Source.String())] {
+			continue
+		}
+		if _, err := d.manager.Remove(s.Source); err != nil {
+			d.reportError(fmt.Errorf("removing %s: %w", s.Source, err))
+		}
+	}
+	return nil
+}
+
+// listOrg returns every repository of the organization.
+func (d *RepoDiscovery) listOrg(ctx context.Context, org string) ([]*github.Repository, error) {
+	ctx = WithSubsystem(ctx, SubsystemDiscovery)
+	opts := &github.RepositoryListByOrgOptions{ListOptions: github.ListOptions{PerPage: 100}}
+
+	var repos []*github.Repository
+	for {
+		page, resp, err := d.client.Repositories.ListByOrg(ctx, org, opts)
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, page...)
+
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// search returns the repositories matching a Search API query, which lists
+// 1000 at most. An incomplete search (timed out on GitHub's side) fails, so
+// that the repositories it missed aren't removed.
+func (d *RepoDiscovery) search(ctx context.Context, query string) ([]*github.Repository, error) {
+	ctx = WithSubsystem(ctx, SubsystemDiscovery)
+	opts := &github.SearchOptions{Sort: "updated", Order: "desc", ListOptions: github.ListOptions{PerPage: 100}}
+
+	var repos []*github.Repository
+	for {
+		result, resp, err := d.client.Search.Repositories(ctx, query, opts)
+		if err != nil {
+			return nil, err
+		}
+		if result.GetIncompleteResults() {
+			return nil, fmt.Errorf("incomplete search results for '%s'", query)
+		}
+		repos = append(repos, result.Repositories...)
+
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,174 @@
+package lib_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// orgRepos serves the repositories of an organization, failing while
+// repos is nil.
+type orgRepos struct {
+	mu    sync.Mutex
+	repos []string
+}
+
+func (o *orgRepos) set(repos ...string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.repos = repos
+}
+
+func (o *orgRepos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.repos == nil {
+		http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+		return
+	}
+	var repos []string
+	for _, name := range o.repos {
+		archived := strings.HasSuffix(name, "!")
+		name = strings.TrimSuffix(name, "!")
+		repos = append(repos, fmt.Sprintf(`{"name":%q,"full_name":"k/%s","archived":%v}`, name, name, archived))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, "[%s]", strings.Join(repos, ","))
+}
+
+func sourceNames(manager *lib.SourceManager) string {
+	var names []string
+	for _, s := range manager.List() {
+		names = append(names, s.Source.String())
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestRepoDiscovery(t *testing.T) {
+	repos := &orgRepos{}
+	mux := http.NewServeMux()
+	mux.Handle("/orgs/k/repos", repos)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	feed, _, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: server.URL, Sources: []lib.Source{{Type: lib.SourceOrg, Name: "k"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := lib.NewSourceManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Manage(feed)
+	// Added by hand, left alone by the discovery.
+	if _, err := manager.Add(lib.Source{Type: lib.SourceRepo, Name: "k/manual"}, "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	discovery, err := lib.NewRepoDiscovery(feed.Client(), manager, "k", []string{"api*", "web"}, []string{"*-old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		repos []string
+		want  string
+	}{
+		{[]string{"api", "api-old", "web", "docs", "api-frozen!", "manual"}, "org:k repo:k/api repo:k/manual repo:k/web"},
+		// api was deleted, api2 created.
+		{[]string{"web", "api2", "docs"}, "org:k repo:k/api2 repo:k/manual repo:k/web"},
+		// The sources are kept while the listing fails.
+		{nil, "org:k repo:k/api2 repo:k/manual repo:k/web"},
+		{[]string{"docs"}, "org:k repo:k/manual"},
+	} {
+		repos.set(c.repos...)
+		err := lib.RunRepoDiscovery(discovery, ctx)
+		if (err != nil) != (c.repos == nil) {
+			t.Errorf("listing %v: got error %v", c.repos, err)
+		}
+		if got := sourceNames(manager); got != c.want {
+			t.Errorf("listing %v: got sources %s, want %s", c.repos, got, c.want)
+		}
+	}
+
+	for _, s := range manager.List() {
+		if s.Source.String() == "repo:k/manual" && s.AddedBy != "admin" {
+			t.Errorf("repo:k/manual was taken over by %s", s.AddedBy)
+		}
+	}
+
+	if _, err := lib.NewRepoDiscovery(feed.Client(), manager, "k", []string{""}, nil); err == nil {
+		t.Error("accepted an empty pattern")
+	}
+}
+
+func TestTopicDiscovery(t *testing.T) {
+	var mu sync.Mutex
+	var items string
+	incomplete := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search/repositories", func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query().Get("q"); q != "topic:kubernetes-operator" {
+			t.Errorf("searched %s", q)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"incomplete_results":%v,"items":[%s]}`, incomplete, items)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx := context.Background()
+	feed, _, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := lib.NewSourceManager("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Manage(feed)
+
+	discovery, err := lib.NewTopicDiscovery(feed.Client(), manager, "kubernetes-operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		items      string
+		incomplete bool
+		want       string
+	}{
+		{`{"full_name":"a/x"},{"full_name":"b/y"}`, false, "public repo:a/x repo:b/y"},
+		{`{"full_name":"b/y"},{"full_name":"c/z","archived":true}`, false, "public repo:b/y"},
+		// The repositories missing from an incomplete search are kept.
+		{`{"full_name":"d/w"}`, true, "public repo:b/y"},
+	} {
+		mu.Lock()
+		items, incomplete = c.items, c.incomplete
+		mu.Unlock()
+
+		err := lib.RunRepoDiscovery(discovery, ctx)
+		if (err != nil) != c.incomplete {
+			t.Errorf("searching %s: got error %v", c.items, err)
+		}
+		if got := sourceNames(manager); got != c.want {
+			t.Errorf("searching %s: got sources %s, want %s", c.items, got, c.want)
+		}
+	}
+
+	if _, err := lib.NewTopicDiscovery(feed.Client(), manager, "stars:>10"); err == nil {
+		t.Error("accepted a query as topic")
+	}
+}
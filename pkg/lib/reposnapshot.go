@@ -0,0 +1,396 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	repoSnapshotVersion        = 1
+	defaultRepoSnapshotTTL     = 30 * 24 * time.Hour
+	defaultRepoSnapshotSize    = 100000
+	defaultRepoSnapshotLimit   = 1000
+	defaultRepoSnapshotTime    = 5 * time.Second
+	repoSnapshotQueue          = 1000
+	repoSnapshotField          = "repo_snapshot"
+	defaultRepoSnapshotResults = 100
+)
+
+// RepoSnapshot is the metadata of a repository when it was first seen in the
+// stream. Records of its events reference it by Ref in `repo_snapshot`.
+type RepoSnapshot struct {
+	// Content address of the snapshot, e.g. 3f1c0a9d2b7e4f60.
+	Ref    string `json:"ref"`
+	RepoID int64  `json:"repo_id"`
+	Repo   string `json:"repo"`
+	// SPDX identifier of the license, e.g. MIT, empty without license.
+	License       string    `json:"license,omitempty"`
+	DefaultBranch string    `json:"default_branch,omitempty"`
+	Topics        []string  `json:"topics,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Captured      time.Time `json:"captured"`
+}
+
+func repoSnapshotRef(s RepoSnapshot) string {
+	b, _ := json.Marshal(struct {
+		RepoID        int64     `json:"repo_id"`
+		Repo          string    `json:"repo"`
+		License       string    `json:"license"`
+		DefaultBranch string    `json:"default_branch"`
+		Topics        []string  `json:"topics"`
+		CreatedAt     time.Time `json:"created_at"`
+	}{s.RepoID, s.Repo, s.License, s.DefaultBranch, s.Topics, s.CreatedAt})
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+func repoSnapshotSize(key string, s RepoSnapshot) int {
+	size := 2*len(key) + len(s.Repo) + len(s.License) + len(s.DefaultBranch) + 128
+	for _, topic := range s.Topics {
+		size += len(topic)
+	}
+	return size
+}
+
+// RepoSnapshotter captures a RepoSnapshot of the repositories first seen in
+// the stream with the Repositories API, so that the events can be joined
+// with their license, default branch, topics and creation time without
+// crawling them separately. Snapshots are taken in the background, the
+// events following the capture reference it, and are persisted in a state
+// file. They expire after the cache TTL, to be captured again when the
+// repository is seen next.
+//
+// Captures are capped by an hourly budget to preserve the rate limit for
+// polling, repositories seen while the budget is exhausted or the queue is
+// full are captured when seen again. The cache is reported as repo_snapshot
+// in the cache metrics (see WriteCacheMetrics).
+type RepoSnapshotter struct {
+	// Called with non-fatal errors, e.g. EventFeed.ReportError.
+	ReportError func(op, eventID string, err error)
+	// Shared budget of the captures, at low priority, instead of the
+	// snapshotter's own if not nil. Repositories rejected by the scheduler
+	// are captured when seen again.
+	Scheduler *EnrichmentScheduler
+
+	client *github.Client
+	clock  Clock
+	path   string
+	budget int
+	cache  *Cache
+	queue  chan string
+
+	mu      sync.Mutex
+	queued  map[string]bool
+	window  time.Time
+	lookups int
+	dirty   bool
+}
+
+// NewRepoSnapshotter loads the snapshots persisted at path (if not empty) and
+// allows at most budget captures per hour (1000 if zero). The cache holds at
+// most 100000 snapshots for 30 days unless bounded otherwise, its clock
+// times the captures and the budget.
+func NewRepoSnapshotter(client *github.Client, path string, budget int, cache CacheConfig) (*RepoSnapshotter, error) {
+	if cache.MaxEntries <= 0 && cache.MaxBytes <= 0 {
+		cache.MaxEntries = defaultRepoSnapshotSize
+	}
+	if cache.TTL <= 0 {
+		cache.TTL = defaultRepoSnapshotTTL
+	}
+
+	s := &RepoSnapshotter{
+		client: client,
+		clock:  clockOrSystem(cache.Clock),
+		path:   path,
+		budget: orDefault(budget, defaultRepoSnapshotLimit),
+		cache:  NewCache("repo_snapshot", cache),
+		queue:  make(chan string, repoSnapshotQueue),
+		queued: make(map[string]bool),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	payload, err := ReadMigratedStateFile(path, repoSnapshotVersion, nil)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var snapshots []RepoSnapshot
+	if err := json.Unmarshal(payload, &snapshots); err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		key := strings.ToLower(snapshot.Repo)
+		s.cache.SetAt(key, snapshot, repoSnapshotSize(key, snapshot), snapshot.Captured)
+	}
+
+	return s, nil
+}
+
+// Snapshot returns the snapshot of the repository, if captured.
+func (s *RepoSnapshotter) Snapshot(repo string) (RepoSnapshot, bool) {
+	v, ok := s.cache.Get(strings.ToLower(repo))
+	if !ok {
+		return RepoSnapshot{}, false
+	}
+	return v.(RepoSnapshot), true
+}
+
+// Ref returns the reference of the snapshot of the event's repository, empty
+// if not captured.
+func (s *RepoSnapshotter) Ref(ev *github.Event) string {
+	snapshot, _ := s.Snapshot(ev.GetRepo().GetName())
+	return snapshot.Ref
+}
+
+// Observe queues the capture of the repositories of the batch without
+// snapshot. It never blocks, repositories not fitting in the queue are
+// skipped.
+func (s *RepoSnapshotter) Observe(events []*github.Event) {
+	for _, ev := range events {
+		repo := ev.GetRepo().GetName()
+		if repo == "" || ev.GetType() == DiscoveryEventType {
+			continue
+		}
+
+		key := strings.ToLower(repo)
+		if _, ok := s.cache.Get(key); ok {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.queued[key] {
+			s.mu.Unlock()
+			continue
+		}
+		select {
+		case s.queue <- repo:
+			s.queued[key] = true
+		default:
+		}
+		s.mu.Unlock()
+	}
+}
+
+// spend reports whether a capture fits in the budget, and otherwise when the
+// budget is replenished.
+func (s *RepoSnapshotter) spend(now time.Time) (bool, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.window) >= time.Hour {
+		s.window, s.lookups = now, 0
+	}
+	if s.lookups >= s.budget {
+		return false, s.window.Add(time.Hour)
+	}
+
+	s.lookups++
+	return true, time.Time{}
+}
+
+// Serve captures the queued repositories until ctx is done.
+func (s *RepoSnapshotter) Serve(ctx context.Context) error {
+	for {
+		var repo string
+		select {
+		case repo = <-s.queue:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		var err error
+		if s.Scheduler != nil {
+			err = s.Scheduler.Do(ctx, EnrichLow, func(ctx context.Context) error { return s.capture(ctx, repo) })
+			if errors.Is(err, ErrEnrichBudget) || errors.Is(err, ErrEnrichQueueFull) {
+				err = nil
+			} else if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		} else {
+			ok, replenished := s.spend(s.clock.Now())
+			for !ok {
+				select {
+				case <-s.clock.After(replenished.Sub(s.clock.Now())):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				ok, replenished = s.spend(s.clock.Now())
+			}
+			err = s.capture(ctx, repo)
+		}
+
+		if err != nil {
+			var rate *github.RateLimitError
+			if errors.As(err, &rate) {
+				s.mu.Lock()
+				s.window, s.lookups = rateLimitReset(rate.Rate.Reset.Time, rate.Response, s.clock.Now()).Add(-time.Hour), s.budget
+				s.mu.Unlock()
+			}
+			if s.ReportError != nil {
+				s.ReportError(OpEnrich, "", fmt.Errorf("snapshotting %s: %w", repo, err))
+			}
+		}
+
+		s.mu.Lock()
+		delete(s.queued, strings.ToLower(repo))
+		s.mu.Unlock()
+	}
+}
+
+func (s *RepoSnapshotter) capture(ctx context.Context, repo string) error {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid repository name")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultRepoSnapshotTime)
+	defer cancel()
+
+	r, _, err := s.client.Repositories.Get(WithSubsystem(ctx, SubsystemRepoSnapshot), parts[0], parts[1])
+	if err != nil {
+		return err
+	}
+
+	now := s.clock.Now().UTC()
+	snapshot := RepoSnapshot{
+		RepoID:        r.GetID(),
+		Repo:          repo,
+		License:       r.GetLicense().GetSPDXID(),
+		DefaultBranch: r.GetDefaultBranch(),
+		Topics:        r.Topics,
+		CreatedAt:     r.GetCreatedAt().UTC(),
+		Captured:      now,
+	}
+	snapshot.Ref = repoSnapshotRef(snapshot)
+
+	key := strings.ToLower(repo)
+	s.cache.SetAt(key, snapshot, repoSnapshotSize(key, snapshot), now)
+	s.mu.Lock()
+	s.dirty = true
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Save persists the snapshots if they changed since the last save.
+func (s *RepoSnapshotter) Save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	// Only the live snapshots are persisted, expired ones are dropped.
+	snapshots := []RepoSnapshot{}
+	s.cache.Range(func(key string, v interface{}) bool {
+		snapshots = append(snapshots, v.(RepoSnapshot))
+		return true
+	})
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Captured.Before(snapshots[j].Captured) })
+
+	payload, err := json.Marshal(snapshots)
+	if err != nil {
+		return err
+	}
+
+	return WriteStateFile(s.path, repoSnapshotVersion, payload)
+}
+
+// ServePersist saves the snapshots periodically until the context is
+// cancelled.
+func (s *RepoSnapshotter) ServePersist(ctx context.Context, interval time.Duration) error {
+	for {
+		select {
+		case <-time.After(interval):
+			if err := s.Save(); err != nil {
+				warnf("Failed saving repository snapshots: %v", err)
+			}
+		case <-ctx.Done():
+			s.Save()
+			return ctx.Err()
+		}
+	}
+}
+
+// ServeHTTP exposes the snapshots, e.g. on the admin endpoint:
+//
+//	GET /repo-snapshots?repo=owner/name
+//	GET /repo-snapshots?ref=REF
+//	GET /repo-snapshots?limit=n
+//
+// The latter lists the most recent captures, 100 by default.
+func (s *RepoSnapshotter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultRepoSnapshotResults
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	snapshots := []RepoSnapshot{}
+	if repo := query.Get("repo"); repo != "" {
+		if snapshot, ok := s.Snapshot(repo); ok {
+			snapshots = append(snapshots, snapshot)
+		}
+	} else {
+		ref := query.Get("ref")
+		s.cache.Range(func(key string, v interface{}) bool {
+			if snapshot := v.(RepoSnapshot); ref == "" || snapshot.Ref == ref {
+				snapshots = append(snapshots, snapshot)
+			}
+			return true
+		})
+		sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Captured.After(snapshots[j].Captured) })
+		if len(snapshots) > limit {
+			snapshots = snapshots[:limit]
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshots)
+}
+
+// recordSnapshots references the snapshots in the records built by
+// NewRecord, see AttachToRecords.
+var recordSnapshots *RepoSnapshotter
+
+// AttachToRecords references the snapshots in the `repo_snapshot` field of
+// the records built from then on, which is not part of their digest. It
+// must be called before records are built concurrently.
+func (s *RepoSnapshotter) AttachToRecords() {
+	recordSnapshots = s
+}
@@ -0,0 +1,109 @@
+package lib_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestRepoSnapshotter(t *testing.T) {
+	var lookups int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&lookups, 1)
+		name := strings.TrimPrefix(r.URL.Path, "/repos/")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":             len(name),
+			"full_name":      name,
+			"default_branch": "main",
+			"topics":         []string{"go", "feed"},
+			"license":        map[string]string{"spdx_id": "MIT"},
+			"created_at":     "2015-01-02T03:04:05Z",
+		})
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	dir, err := ioutil.TempDir("", "snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshots")
+	clock := lib.NewSimulatedClock(time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC))
+	snapshots, err := lib.NewRepoSnapshotter(client, path, 1, lib.CacheConfig{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go snapshots.Serve(ctx)
+
+	events := []*github.Event{
+		{Repo: &github.Repository{Name: github.String("octocat/hello")}},
+		{Repo: &github.Repository{Name: github.String("octocat/Hello")}},
+		{Repo: &github.Repository{Name: github.String("octocat/world")}},
+	}
+	snapshots.Observe(events)
+
+	// The second repository waits for the budget.
+	for clock.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	snapshot, ok := snapshots.Snapshot("octocat/hello")
+	if !ok || snapshot.License != "MIT" || snapshot.DefaultBranch != "main" || len(snapshot.Topics) != 2 || snapshot.CreatedAt.Year() != 2015 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	if ref := snapshots.Ref(events[1]); ref == "" || ref != snapshot.Ref {
+		t.Errorf("expected %s to reference %s, got %q", events[1].GetRepo().GetName(), snapshot.Ref, ref)
+	}
+	if ref := snapshots.Ref(events[2]); ref != "" {
+		t.Errorf("expected no snapshot for octocat/world yet, got %s", ref)
+	}
+
+	clock.Advance(time.Hour)
+	for snapshots.Ref(events[2]) == "" {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Errorf("%d lookups, want 2", n)
+	}
+
+	if err := snapshots.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := lib.NewRepoSnapshotter(client, path, 1, lib.CacheConfig{Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, ok := reloaded.Snapshot("OCTOCAT/HELLO"); !ok || again.Ref != snapshot.Ref {
+		t.Errorf("expected the reloaded snapshot %s, got %+v", snapshot.Ref, again)
+	}
+}
+
+func TestRepoSnapshotNotDigested(t *testing.T) {
+	record := lib.NewRecord(&github.Event{ID: github.String("1"), Type: github.String("WatchEvent")})
+	record.RepoSnapshot = "3f1c0a9d2b7e4f60"
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.VerifyRecord(b); err != nil {
+		t.Errorf("expected the record to verify with its snapshot reference: %v", err)
+	}
+}
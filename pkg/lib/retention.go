@@ -0,0 +1,148 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetentionPolicy bounds stored data by age and by total size, a zero field
+// is unbounded. The oldest data goes first.
+type RetentionPolicy struct {
+	MaxAge   time.Duration
+	MaxBytes int64
+}
+
+func (p RetentionPolicy) Unbounded() bool {
+	return p.MaxAge <= 0 && p.MaxBytes <= 0
+}
+
+// RetentionResult is the outcome of enforcing a policy.
+type RetentionResult struct {
+	// Objects, files or records removed.
+	Removed int
+	Bytes   int64
+}
+
+var retention = struct {
+	sync.Mutex
+	byTarget map[string]RetentionResult
+}{byTarget: make(map[string]RetentionResult)}
+
+// recordRetention accumulates the space reclaimed from a target for the
+// metrics.
+func recordRetention(target string, r RetentionResult) {
+	retention.Lock()
+	defer retention.Unlock()
+
+	total := retention.byTarget[target]
+	total.Removed += r.Removed
+	total.Bytes += r.Bytes
+	retention.byTarget[target] = total
+}
+
+// WriteRetentionMetrics writes the space reclaimed by the retention policies
+// in the Prometheus text format, labeled by target (archive, dead_letters):
+//
+//	github_feed_retention_removed_total{target}          objects or records removed
+//	github_feed_retention_reclaimed_bytes_total{target}  bytes reclaimed
+func WriteRetentionMetrics(w io.Writer) {
+	retention.Lock()
+	targets := make([]string, 0, len(retention.byTarget))
+	totals := make(map[string]RetentionResult, len(retention.byTarget))
+	for target, r := range retention.byTarget {
+		targets = append(targets, target)
+		totals[target] = r
+	}
+	retention.Unlock()
+
+	if len(targets) == 0 {
+		return
+	}
+	sort.Strings(targets)
+
+	fmt.Fprintf(w, "# HELP %s_retention_removed_total Objects or records removed by the retention policies.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_retention_removed_total counter\n", statsNamespace)
+	for _, target := range targets {
+		fmt.Fprintf(w, "%s_retention_removed_total{target=\"%s\"} %d\n", statsNamespace, labelEscaper.Replace(target), totals[target].Removed)
+	}
+
+	fmt.Fprintf(w, "# HELP %s_retention_reclaimed_bytes_total Bytes reclaimed by the retention policies.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_retention_reclaimed_bytes_total counter\n", statsNamespace)
+	for _, target := range targets {
+		fmt.Fprintf(w, "%s_retention_reclaimed_bytes_total{target=\"%s\"} %d\n", statsNamespace, labelEscaper.Replace(target), totals[target].Bytes)
+	}
+}
+
+type archivedObject struct {
+	path string
+	time time.Time
+	size int64
+}
+
+// EnforceArchiveRetention removes the objects of a file:// archive (see
+// ArchiveSink) archived before now minus the maximum age, then the oldest
+// ones until the archive fits in the maximum size. The hour directories left
+// empty are removed.
+func EnforceArchiveRetention(dir string, policy RetentionPolicy, now time.Time) (RetentionResult, error) {
+	var result RetentionResult
+	if policy.Unbounded() {
+		return result, nil
+	}
+
+	hours, err := filepath.Glob(filepath.Join(dir, "[0-9]*", "[0-9]*", "[0-9]*", "[0-9]*"))
+	if err != nil {
+		return result, err
+	}
+
+	var objects []archivedObject
+	var total int64
+	for _, hour := range hours {
+		paths, err := filepath.Glob(filepath.Join(hour, "*.gz"))
+		if err != nil {
+			return result, err
+		}
+		for _, path := range paths {
+			t, ok := archiveObjectTime(filepath.ToSlash(path))
+			info, err := os.Stat(path)
+			if !ok || err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			objects = append(objects, archivedObject{path: path, time: t, size: info.Size()})
+			total += info.Size()
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].time.Before(objects[j].time) })
+
+	cutoff := now.Add(-policy.MaxAge)
+	for _, obj := range objects {
+		expired := policy.MaxAge > 0 && obj.time.Before(cutoff)
+		oversize := policy.MaxBytes > 0 && total > policy.MaxBytes
+		if !expired && !oversize {
+			break
+		}
+
+		if err := os.Remove(obj.path); err != nil && !os.IsNotExist(err) {
+			recordRetention("archive", result)
+			return result, err
+		}
+		result.Removed++
+		result.Bytes += obj.size
+		total -= obj.size
+
+		// Removes the hour, day, month and year directories once empty,
+		// os.Remove fails on the others.
+		for d := filepath.Dir(obj.path); d != dir && len(d) > len(dir); d = filepath.Dir(d) {
+			if os.Remove(d) != nil {
+				break
+			}
+		}
+	}
+
+	recordRetention("archive", result)
+	return result, nil
+}
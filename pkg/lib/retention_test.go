@@ -0,0 +1,99 @@
+package lib_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestEnforceArchiveRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 6, 10, 12, 30, 0, 0, time.UTC)
+	var paths []string
+	for _, age := range []time.Duration{50 * time.Hour, 26 * time.Hour, 3 * time.Hour, time.Hour} {
+		at := now.Add(-age)
+		path := filepath.Join(dir, at.Format("2006/01/02/15"), at.Format("20060102T150405.000000000Z")+"-000001.ndjson.gz")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	r, err := lib.EnforceArchiveRetention(dir, lib.RetentionPolicy{MaxAge: 24 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 2 || r.Bytes != 200 {
+		t.Errorf("removed %d objects (%d bytes), want 2 (200 bytes)", r.Removed, r.Bytes)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2020", "06", "08")); !os.IsNotExist(err) {
+		t.Errorf("expected the empty day directory to be removed, got %v", err)
+	}
+
+	r, err = lib.EnforceArchiveRetention(dir, lib.RetentionPolicy{MaxBytes: 150}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 {
+		t.Errorf("removed %d objects, want 1", r.Removed)
+	}
+	if _, err := os.Stat(paths[3]); err != nil {
+		t.Errorf("expected the newest object to be kept, got %v", err)
+	}
+
+	var metrics bytes.Buffer
+	lib.WriteRetentionMetrics(&metrics)
+	if !strings.Contains(metrics.String(), `github_feed_retention_removed_total{target="archive"}`) {
+		t.Errorf("missing the archive retention metrics in:\n%s", metrics.String())
+	}
+}
+
+func TestDeadLetterQueueExpire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q, err := lib.OpenDeadLetterQueue(filepath.Join(dir, "dlq.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	for _, id := range []string{"1", "2"} {
+		ev := &github.Event{ID: github.String(id), Type: github.String("PushEvent")}
+		if err := q.Put("archive", []*github.Event{ev}, errors.New("unavailable")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := q.Expire(lib.RetentionPolicy{MaxAge: time.Hour}, time.Now())
+	if err != nil || r.Removed != 0 {
+		t.Fatalf("expired %d fresh letters: %v", r.Removed, err)
+	}
+
+	r, err = q.Expire(lib.RetentionPolicy{MaxAge: time.Hour}, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 2 || r.Bytes == 0 {
+		t.Errorf("expired %d letters (%d bytes), want 2", r.Removed, r.Bytes)
+	}
+}
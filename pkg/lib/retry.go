@@ -0,0 +1,206 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	defaultMaxPollFailures = 5
+	defaultRetryBackoff    = time.Second
+	defaultMaxRetryBackoff = 5 * time.Minute
+	// Wait after an abuse rate limit without Retry-After.
+	defaultAbuseRetryAfter = time.Minute
+)
+
+// Classes of poll failures, see PollFailures.
+const (
+	failureNetwork = iota
+	failureServer
+	failureAbuse
+	failureRateLimit
+	failureClient
+	failureClasses
+)
+
+// PollFailures are the failed polls of a feed by class, see
+// EventFeed.Failures.
+type PollFailures struct {
+	// Connection errors and timeouts, retried.
+	Network uint64
+	// 5xx responses, retried.
+	Server uint64
+	// Secondary (abuse) rate limits, retried after Retry-After.
+	Abuse uint64
+	// Primary rate limits, polled again once reset.
+	RateLimit uint64
+	// Other 4xx responses (e.g. a revoked token), which stop the feed.
+	Client uint64
+}
+
+// Failures returns the failed polls of the feed since its creation.
+func (f *EventFeed) Failures() PollFailures {
+	return PollFailures{
+		Network:   atomic.LoadUint64(&f.failures[failureNetwork]),
+		Server:    atomic.LoadUint64(&f.failures[failureServer]),
+		Abuse:     atomic.LoadUint64(&f.failures[failureAbuse]),
+		RateLimit: atomic.LoadUint64(&f.failures[failureRateLimit]),
+		Client:    atomic.LoadUint64(&f.failures[failureClient]),
+	}
+}
+
+// WritePrometheus writes the failed polls of the feed in the Prometheus text
+// format, labeled by class (network, server, abuse, rate_limit, client):
+//
+//	github_feed_poll_failures_total{class}
+func (p PollFailures) WritePrometheus(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s_poll_failures_total Failed polls of the events API by class.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_poll_failures_total counter\n", statsNamespace)
+	for _, c := range []struct {
+		class string
+		n     uint64
+	}{
+		{"network", p.Network},
+		{"server", p.Server},
+		{"abuse", p.Abuse},
+		{"rate_limit", p.RateLimit},
+		{"client", p.Client},
+	} {
+		fmt.Fprintf(w, "%s_poll_failures_total{class=\"%s\"} %d\n", statsNamespace, c.class, c.n)
+	}
+}
+
+// classifyPollError returns the class of a failed poll, and the wait the
+// server asked for before retrying, if any.
+func classifyPollError(err error) (int, time.Duration) {
+	var abuse *github.AbuseRateLimitError
+	if errors.As(err, &abuse) {
+		if abuse.RetryAfter != nil && *abuse.RetryAfter > 0 {
+			return failureAbuse, *abuse.RetryAfter
+		}
+		return failureAbuse, defaultAbuseRetryAfter
+	}
+
+	var rep *github.ErrorResponse
+	if errors.As(err, &rep) && rep.Response != nil {
+		if code := rep.Response.StatusCode; code >= 500 || code == http.StatusTooManyRequests {
+			seconds, _ := strconv.Atoi(rep.Response.Header.Get("Retry-After"))
+			return failureServer, time.Duration(seconds) * time.Second
+		}
+		return failureClient, 0
+	}
+
+	return failureNetwork, 0
+}
+
+// retryPolicy paces the retries of a source's failed polls.
+type retryPolicy struct {
+	maxFailures int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+
+	// Consecutive failures of the source.
+	failures int
+	next     time.Duration
+}
+
+func newRetryPolicy(conf *Config) retryPolicy {
+	p := retryPolicy{
+		maxFailures: conf.MaxConsecutiveFailures,
+		backoff:     conf.RetryBackoff,
+		maxBackoff:  conf.MaxRetryBackoff,
+	}
+	if p.maxFailures == 0 {
+		p.maxFailures = defaultMaxPollFailures
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultRetryBackoff
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultMaxRetryBackoff
+	}
+	return p
+}
+
+// failed returns the wait before retrying the failed poll, or false if the
+// failures are exhausted. The backoff doubles on every consecutive failure,
+// jittered by up to half, and never undercuts the server's Retry-After.
+func (p *retryPolicy) failed(retryAfter time.Duration) (time.Duration, bool) {
+	p.failures++
+	if p.maxFailures > 0 && p.failures >= p.maxFailures {
+		return 0, false
+	}
+
+	if p.next == 0 {
+		p.next = p.backoff
+	}
+	wait := p.next/2 + time.Duration(rand.Int63n(int64(p.next/2)+1))
+	if p.next *= 2; p.next > p.maxBackoff {
+		p.next = p.maxBackoff
+	}
+
+	if wait < retryAfter {
+		wait = retryAfter
+	}
+	return wait, true
+}
+
+// succeeded resets the backoff.
+func (p *retryPolicy) succeeded() {
+	p.failures, p.next = 0, 0
+}
+
+// pollFailed accounts a failed poll of the source and returns the wait
+// before retrying it, or the error stopping the feed. The events of the
+// pages polled before the failure are published, the deduplicator already
+// saw them.
+func (f *EventFeed) pollFailed(ctx context.Context, p *sourcePoller, events []*github.Event, err error) (time.Duration, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	class, retryAfter := classifyPollError(err)
+	atomic.AddUint64(&f.failures[class], 1)
+
+	var wait time.Duration
+	switch class {
+	case failureClient:
+		return 0, err
+	case failureAbuse:
+		// A throttle rather than a failure of the source.
+		wait = retryAfter
+		warnf("Secondary rate limit exceeded on %s, retrying in %d seconds.", p.source, wait/time.Second)
+		if f.hooks.onThrottle != nil {
+			f.hooks.onThrottle(p.source, wait)
+		}
+	default:
+		var ok bool
+		wait, ok = p.retry.failed(retryAfter)
+		if p.retry.failures == 1 {
+			atomic.AddInt32(&f.metrics.failingSources, 1)
+		}
+		if !ok {
+			return 0, fmt.Errorf("%s: %d consecutive failed polls: %w", p.source, p.retry.failures, err)
+		}
+		f.ReportError(OpPoll, "", fmt.Errorf("%s: retrying in %v: %w", p.source, wait.Round(time.Millisecond), err))
+	}
+
+	if len(events) > 0 {
+		f.checkEvents(events)
+		if err := f.publish(ctx, f.filterEvents(events)); err != nil {
+			return 0, err
+		}
+		f.saveCheckpoint(p, events)
+	}
+
+	return wait, nil
+}
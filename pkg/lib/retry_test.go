@@ -0,0 +1,124 @@
+package lib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// flakyEndpoint answers the polls with the statuses in order, then with an
+// event.
+func flakyEndpoint(polls *int32, statuses ...int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(polls, 1))
+		w.Header().Set("Content-Type", "application/json")
+		if n <= len(statuses) {
+			switch statuses[n-1] {
+			case http.StatusForbidden:
+				w.Header().Set("Retry-After", "90")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"message":"You have exceeded a secondary rate limit.","documentation_url":"https://developer.github.com/v3/#abuse-rate-limits"}`))
+			default:
+				w.WriteHeader(statuses[n-1])
+				w.Write([]byte(`{"message":"failed"}`))
+			}
+			return
+		}
+		w.Write([]byte(`[{"id":"1","type":"PushEvent","actor":{"login":"a"}}]`))
+	}
+}
+
+func TestFeedRetriesFailedPolls(t *testing.T) {
+	var polls int32
+	server := httptest.NewServer(flakyEndpoint(&polls, http.StatusBadGateway, http.StatusForbidden, http.StatusServiceUnavailable))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: server.URL, Clock: clock, MaxConsecutiveFailures: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var throttles []time.Duration
+	feed.OnThrottle(func(source lib.Source, wait time.Duration) { throttles = append(throttles, wait) })
+
+	done := make(chan error, 1)
+	go func() { done <- feed.Serve() }()
+
+	// The 502 is retried after a jittered second.
+	waitForTimer(t, clock)
+	clock.Advance(time.Second)
+	// The secondary rate limit waits for its Retry-After.
+	waitForTimer(t, clock)
+	clock.Advance(time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&polls); n != 2 {
+		t.Fatalf("polled %d times before the Retry-After, want 2", n)
+	}
+	clock.Advance(30 * time.Second)
+	// The 503 is the second consecutive failure, the rate limit isn't one.
+	waitForTimer(t, clock)
+	clock.Advance(2 * time.Second)
+
+	if batch := nextBatch(t, events); len(batch) != 1 {
+		t.Errorf("got %d events, want 1", len(batch))
+	}
+	if len(throttles) != 1 || throttles[0] != 90*time.Second {
+		t.Errorf("got throttles %v, want 90s", throttles)
+	}
+	if got := feed.Failures(); got != (lib.PollFailures{Server: 2, Abuse: 1}) {
+		t.Errorf("got failures %+v", got)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestFeedGivesUp(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		statuses []int
+		want     lib.PollFailures
+	}{
+		{"consecutive failures", []int{http.StatusBadGateway, http.StatusBadGateway}, lib.PollFailures{Server: 2}},
+		{"client error", []int{http.StatusUnauthorized}, lib.PollFailures{Client: 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var polls int32
+			server := httptest.NewServer(flakyEndpoint(&polls, tt.statuses...))
+			defer server.Close()
+
+			clock := lib.NewSimulatedClock(time.Now())
+			feed, _, err := lib.NewEventFeed(context.Background(), &lib.Config{BaseURL: server.URL, Clock: clock, MaxConsecutiveFailures: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan error, 1)
+			go func() { done <- feed.Serve() }()
+			if len(tt.statuses) > 1 {
+				waitForTimer(t, clock)
+				clock.Advance(time.Second)
+			}
+
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Error("Serve returned without error")
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the feed to give up")
+			}
+			if got := feed.Failures(); got != tt.want {
+				t.Errorf("got failures %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// RotatingFileSink appends the records of each batch to local files named by
+// the time they were opened, e.g. events-20200820T143000.000000000Z.ndjson
+// for the prefix events. A new file is opened once the current one is older
+// than the rotation interval or holds max bytes of records (before
+// compression), whichever comes first, zero disables either bound.
+//
+// Compressed files are gzipped, each batch is flushed so that a crash loses
+// at most the batch being written.
+type RotatingFileSink struct {
+	prefix     string
+	serializer Serializer
+	interval   time.Duration
+	maxBytes   int64
+	compress   bool
+
+	mu      sync.Mutex
+	f       *os.File
+	gz      *gzip.Writer
+	opened  time.Time
+	written int64
+}
+
+func NewRotatingFileSink(prefix string, s Serializer, interval time.Duration, maxBytes int64, compress bool) (*RotatingFileSink, error) {
+	if prefix == "" || prefix[len(prefix)-1] == filepath.Separator {
+		return nil, fmt.Errorf("invalid file sink prefix '%s', expected a path such as /var/lib/github-feed/events", prefix)
+	}
+	if err := os.MkdirAll(filepath.Dir(prefix), 0755); err != nil {
+		return nil, err
+	}
+
+	return &RotatingFileSink{
+		prefix:     prefix,
+		serializer: serializerOrDefault(s),
+		interval:   interval,
+		maxBytes:   maxBytes,
+		compress:   compress,
+	}, nil
+}
+
+func (s *RotatingFileSink) Write(ctx context.Context, events []*github.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := encodeRecords(&buf, events, s.serializer); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.f != nil && s.due(now) {
+		if err := s.closeFile(); err != nil {
+			return err
+		}
+	}
+	if s.f == nil {
+		if err := s.openFile(now); err != nil {
+			return err
+		}
+	}
+
+	w := io.Writer(s.f)
+	if s.gz != nil {
+		w = s.gz
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	s.written += int64(buf.Len())
+
+	if s.gz != nil {
+		return s.gz.Flush()
+	}
+	return nil
+}
+
+// due tells whether the current file must be rotated.
+func (s *RotatingFileSink) due(now time.Time) bool {
+	return (s.interval > 0 && now.Sub(s.opened) >= s.interval) ||
+		(s.maxBytes > 0 && s.written >= s.maxBytes)
+}
+
+func (s *RotatingFileSink) openFile(now time.Time) error {
+	name := fmt.Sprintf("%s-%s.%s", s.prefix, now.UTC().Format(archiveObjectTimeLayout), s.serializer.Extension())
+	if s.compress {
+		name += ".gz"
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.f, s.opened, s.written = f, now, 0
+	if s.compress {
+		s.gz = gzip.NewWriter(f)
+	}
+	return nil
+}
+
+func (s *RotatingFileSink) closeFile() error {
+	var err error
+	if s.gz != nil {
+		err = s.gz.Close()
+	}
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f, s.gz = nil, nil
+	return err
+}
+
+func (s *RotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+	return s.closeFile()
+}
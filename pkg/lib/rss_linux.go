@@ -0,0 +1,22 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// processRSS returns the resident set size of the process.
+func processRSS() (uint64, bool) {
+	b, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+
+	var size, resident uint64
+	if _, err := fmt.Sscan(string(b), &size, &resident); err != nil {
+		return 0, false
+	}
+
+	return resident * uint64(os.Getpagesize()), true
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package lib
+
+// processRSS is only implemented on linux.
+func processRSS() (uint64, bool) {
+	return 0, false
+}
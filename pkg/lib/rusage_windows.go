@@ -0,0 +1,9 @@
+package lib
+
+import "time"
+
+// processCPUTime is not implemented on windows, load triggered profiling is
+// disabled there.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// SchemaVersion is the version of the emitted records' schema, stamped in
+// their `schema_version` field. Bump it and register a migration in
+// schemaMigrations whenever the shape of emitted records changes.
+//
+// Version history:
+//
+//	0: raw events, as returned by the GitHub API (unstamped).
+//	1: events stamped with schema_version.
+//	2: events carry the digest of their canonical JSON in `digest`.
+//	3: records may carry `repo_snapshot` and `routes`, and be
+//	   dictionary-encoded: `dictionary` records, `actor_ref` and `repo_ref`.
+const SchemaVersion = 3
+
+const (
+	schemaVersionField = "schema_version"
+	digestField        = "digest"
+)
+
+// Record is the envelope in which events are emitted: the GitHub event
+// stamped with the schema version and its digest (see EventDigest), and
+// the reference of its repository's snapshot if any.
+type Record struct {
+	SchemaVersion int    `json:"schema_version"`
+	Digest        string `json:"digest,omitempty"`
+	// Set in dictionary-encoded streams only, see DictionaryEncoded.
+	Dictionary *RecordDictionary `json:"dictionary,omitempty"`
+	ActorRef   *int              `json:"actor_ref,omitempty"`
+	RepoRef    *int              `json:"repo_ref,omitempty"`
+	// Set when the repository was snapshotted, see RepoSnapshotter.
+	RepoSnapshot string `json:"repo_snapshot,omitempty"`
+	// Set for the pushes routed by a PathRouter.
+	Routes []string `json:"routes,omitempty"`
+	*github.Event
+}
+
+func NewRecord(ev *github.Event) *Record {
+	r := &Record{SchemaVersion: SchemaVersion, Event: ev}
+	// Only fails on unencodable payloads, which can't be emitted anyway.
+	r.Digest, _ = EventDigest(ev)
+	if recordSnapshots != nil {
+		r.RepoSnapshot = recordSnapshots.Ref(ev)
+	}
+	if recordRoutes != nil {
+		r.Routes = recordRoutes.Routes(ev)
+	}
+	return r
+}
+
+// A migration upgrades a decoded record from version i to i+1 in place.
+type schemaMigration func(record map[string]json.RawMessage) error
+
+var schemaMigrations = []schemaMigration{
+	// 0 -> 1: stamping only, the version is set by MigrateRecord.
+	func(record map[string]json.RawMessage) error { return nil },
+	// 1 -> 2: digest.
+	func(record map[string]json.RawMessage) error {
+		digest, err := recordDigest(record)
+		if err != nil {
+			return err
+		}
+
+		record[digestField], _ = json.Marshal(digest)
+		return nil
+	},
+	// 2 -> 3: optional fields only.
+	func(record map[string]json.RawMessage) error { return nil },
+}
+
+func recordVersion(record map[string]json.RawMessage) (int, error) {
+	raw, ok := record[schemaVersionField]
+	if !ok {
+		return 0, nil
+	}
+
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return 0, fmt.Errorf("invalid %s: %v", schemaVersionField, err)
+	}
+
+	return version, nil
+}
+
+// MigrateRecord upgrades a JSON record to the target schema version.
+// Downgrades are not supported.
+func MigrateRecord(data []byte, to int) ([]byte, error) {
+	if to > SchemaVersion {
+		return nil, fmt.Errorf("unknown schema version %d, latest is %d", to, SchemaVersion)
+	}
+
+	var record map[string]json.RawMessage
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+
+	from, err := recordVersion(record)
+	if err != nil {
+		return nil, err
+	}
+
+	if from > to {
+		return nil, fmt.Errorf("cannot downgrade record from schema version %d to %d", from, to)
+	} else if from == to {
+		return data, nil
+	}
+
+	for v := from; v < to; v++ {
+		if err := schemaMigrations[v](record); err != nil {
+			return nil, fmt.Errorf("migrating from schema version %d: %v", v, err)
+		}
+	}
+
+	record[schemaVersionField] = json.RawMessage(fmt.Sprint(to))
+
+	return json.Marshal(record)
+}
+
+// MigrateNDJSON upgrades every record of an NDJSON stream to the target
+// schema version and returns the number of records written.
+func MigrateNDJSON(r io.Reader, w io.Writer, to int) (int, error) {
+	scanner := bufio.NewScanner(r)
+	// Some events (e.g. large pushes) are well above the default line limit.
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+
+	bw := bufio.NewWriter(w)
+
+	n := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		migrated, err := MigrateRecord(line, to)
+		if err != nil {
+			return n, fmt.Errorf("record %d: %v", n+1, err)
+		}
+
+		bw.Write(migrated)
+		bw.WriteByte('\n')
+		n++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+
+	return n, bw.Flush()
+}
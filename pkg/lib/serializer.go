@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Serializer encodes records for the sinks (the archive, stdout) and the
+// served streams, so that a format registered once is available to all of
+// them. Formats such as protobuf or Avro plug in with RegisterSerializer.
+type Serializer interface {
+	// ContentType of an encoded record, e.g. application/json.
+	ContentType() string
+	// Extension of the files holding a stream of records, e.g. ndjson.
+	Extension() string
+	// Binary records are base64 encoded in text streams, e.g. server-sent
+	// events.
+	Binary() bool
+	// Marshal encodes a record on its own, e.g. as the data of an event.
+	Marshal(r *Record) ([]byte, error)
+	// Encode appends a record to a stream of records, delimited so that it
+	// can be decoded back (e.g. newline terminated JSON).
+	Encode(w io.Writer, r *Record) error
+}
+
+const DefaultSerializer = "json"
+
+var serializers = struct {
+	sync.RWMutex
+	byName map[string]Serializer
+}{byName: make(map[string]Serializer)}
+
+// RegisterSerializer makes a serializer available under name, e.g. to the
+// -format flag, it panics if the name is taken.
+func RegisterSerializer(name string, s Serializer) {
+	serializers.Lock()
+	defer serializers.Unlock()
+
+	if _, dup := serializers.byName[name]; dup {
+		panic("duplicate serializer " + name)
+	}
+	serializers.byName[name] = s
+}
+
+// SerializerNames returns the names of the registered serializers, sorted.
+func SerializerNames() []string {
+	serializers.RLock()
+	defer serializers.RUnlock()
+
+	names := make([]string, 0, len(serializers.byName))
+	for name := range serializers.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupSerializer returns the serializer registered under name, the
+// default if name is empty.
+func LookupSerializer(name string) (Serializer, error) {
+	if name == "" {
+		name = DefaultSerializer
+	}
+
+	serializers.RLock()
+	s, ok := serializers.byName[name]
+	serializers.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown format '%s', available: %s", name, strings.Join(SerializerNames(), ", "))
+	}
+	return s, nil
+}
+
+func serializerOrDefault(s Serializer) Serializer {
+	if s == nil {
+		return jsonSerializer{}
+	}
+	return s
+}
+
+func init() {
+	RegisterSerializer("json", jsonSerializer{})
+	RegisterSerializer("canonical-json", canonicalJSONSerializer{})
+}
+
+// jsonSerializer encodes records as NDJSON, the events as returned by the
+// GitHub API.
+type jsonSerializer struct{}
+
+func (jsonSerializer) ContentType() string { return "application/json" }
+func (jsonSerializer) Extension() string   { return "ndjson" }
+func (jsonSerializer) Binary() bool        { return false }
+
+func (jsonSerializer) Marshal(r *Record) ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (jsonSerializer) Encode(w io.Writer, r *Record) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// canonicalJSONSerializer encodes records as canonical JSON, see
+// MarshalCanonical.
+type canonicalJSONSerializer struct{}
+
+func (canonicalJSONSerializer) ContentType() string { return "application/json" }
+func (canonicalJSONSerializer) Extension() string   { return "ndjson" }
+func (canonicalJSONSerializer) Binary() bool        { return false }
+
+func (canonicalJSONSerializer) Marshal(r *Record) ([]byte, error) {
+	return MarshalCanonical(r)
+}
+
+func (s canonicalJSONSerializer) Encode(w io.Writer, r *Record) error {
+	b, err := MarshalCanonical(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
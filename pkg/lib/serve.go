@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ServeConfig controls how the HTTP endpoints of the serve modes (event
+// streams, admin) are exposed. The zero value serves plain HTTP without
+// authentication, which is only suitable for localhost.
+type ServeConfig struct {
+	Addr string
+
+	// Enables TLS when both are set.
+	TLSCertFile string
+	TLSKeyFile  string
+	// Requires clients to present a certificate signed by this CA (mTLS).
+	ClientCAFile string
+
+	// Accepted `Authorization: Bearer <token>` values, if any is set requests
+	// without a valid token (or client certificate) are rejected.
+	BearerTokens []string
+	// Paths served without authentication, e.g. a static page whose
+	// requests carry the credentials.
+	PublicPaths []string
+
+	// Requests per second allowed per client, 0 disables rate limiting.
+	ClientRate  float64
+	ClientBurst int
+}
+
+const serveShutdownTimeout = 5 * time.Second
+
+func (c *ServeConfig) tlsEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// TLSConfig returns the server TLS configuration, or nil if TLS is disabled.
+func (c *ServeConfig) TLSConfig() (*tls.Config, error) {
+	if !c.tlsEnabled() {
+		if c.ClientCAFile != "" {
+			return nil, errors.New("client certificate authentication requires TLS")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificate found", c.ClientCAFile)
+		}
+
+		conf.ClientCAs = pool
+		// Bearer tokens may still authenticate clients without certificates.
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		if len(c.BearerTokens) > 0 {
+			conf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return conf, nil
+}
+
+func (c *ServeConfig) validBearer(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for i, expected := range c.BearerTokens {
+		if subtle.ConstantTimeCompare(token, []byte(expected)) == 1 {
+			// Never use the secret itself as an identity in logs or limiters.
+			return fmt.Sprintf("token#%d", i), true
+		}
+	}
+
+	return "", false
+}
+
+func (c *ServeConfig) authRequired(r *http.Request) bool {
+	for _, path := range c.PublicPaths {
+		if r.URL.Path == path {
+			return false
+		}
+	}
+
+	return len(c.BearerTokens) > 0 || c.ClientCAFile != ""
+}
+
+// clientIdentity authenticates the request, returning the identity used for
+// per-client rate limiting.
+func (c *ServeConfig) clientIdentity(r *http.Request) (string, bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return "cert:" + r.TLS.PeerCertificates[0].Subject.CommonName, true
+	}
+
+	if id, ok := c.validBearer(r); ok {
+		return id, true
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host, !c.authRequired(r)
+}
+
+type clientIdentityKey struct{}
+
+// ClientIdentity returns the identity of the client authenticated by
+// ServeConfig.Handler, e.g. cert:CN or token#0.
+func ClientIdentity(r *http.Request) string {
+	id, _ := r.Context().Value(clientIdentityKey{}).(string)
+	return id
+}
+
+// Handler wraps h with the authentication and rate limiting policies.
+func (c *ServeConfig) Handler(h http.Handler) http.Handler {
+	for _, token := range c.BearerTokens {
+		RegisterSecret(token)
+	}
+
+	var limiter *KeyedLimiter
+	if c.ClientRate > 0 {
+		limiter = NewKeyedLimiter(c.ClientRate, c.ClientBurst)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := c.clientIdentity(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="github-feed"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if limiter != nil && !limiter.Allow(id) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id)))
+	})
+}
+
+// ListenAndServe serves h with the configured policies until the context is
+// cancelled.
+func (c *ServeConfig) ListenAndServe(ctx context.Context, h http.Handler) error {
+	tlsConf, err := c.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:      c.Addr,
+		Handler:   c.Handler(h),
+		TLSConfig: tlsConf,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if tlsConf != nil {
+			// Certificates are already loaded in TLSConfig.
+			errs <- server.ListenAndServeTLS("", "")
+		} else {
+			errs <- server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+		return ctx.Err()
+	}
+}
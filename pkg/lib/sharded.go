@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const defaultShardQueue = 64
+
+// ShardedSink writes to a sink from multiple workers while preserving the
+// order of the events sharing a key (e.g. the events of a repository): each
+// key is hashed onto a single worker, which writes its batches in order.
+// Writes are asynchronous, their errors are passed to OnError, and Close
+// waits for the queued batches.
+//
+// The events matching Priority, if set, are queued on a separate lane which
+// the workers drain first, so that they overtake the bulk traffic of the
+// other keys during a backlog. Those of a key with events queued on the bulk
+// lane are queued behind them.
+type ShardedSink struct {
+	sink Sink
+	key  *KeySpec
+	// Called with the errors of the workers' writes.
+	OnError  func(error)
+	Priority func(*github.Event) bool
+
+	queues []chan []*github.Event
+	urgent []chan []*github.Event
+	wg     sync.WaitGroup
+
+	// Number of events of each key queued on the bulk lanes, tracked with
+	// Priority.
+	pendingMu sync.Mutex
+	pending   map[string]int
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewShardedSink starts the workers, queue is the number of batches each
+// worker buffers before Write blocks.
+func NewShardedSink(sink Sink, key *KeySpec, workers, queue int) *ShardedSink {
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 1 {
+		queue = defaultShardQueue
+	}
+
+	s := &ShardedSink{sink: sink, key: key, OnError: func(error) {}, pending: make(map[string]int)}
+	for i := 0; i < workers; i++ {
+		q, u := make(chan []*github.Event, queue), make(chan []*github.Event, queue)
+		s.queues = append(s.queues, q)
+		s.urgent = append(s.urgent, u)
+
+		s.wg.Add(1)
+		go s.work(u, q)
+	}
+
+	return s
+}
+
+func (s *ShardedSink) work(urgent, bulk <-chan []*github.Event) {
+	defer s.wg.Done()
+
+	write := func(batch []*github.Event) {
+		if err := s.sink.Write(context.Background(), batch); err != nil {
+			s.OnError(err)
+		}
+	}
+
+	for urgent != nil || bulk != nil {
+		// Drain the priority lane before taking a bulk batch.
+		select {
+		case batch, ok := <-urgent:
+			if !ok {
+				urgent = nil
+			} else {
+				write(batch)
+			}
+			continue
+		default:
+		}
+
+		select {
+		case batch, ok := <-urgent:
+			if !ok {
+				urgent = nil
+			} else {
+				write(batch)
+			}
+		case batch, ok := <-bulk:
+			if !ok {
+				bulk = nil
+			} else {
+				write(batch)
+				s.settle(batch)
+			}
+		}
+	}
+}
+
+func (s *ShardedSink) shard(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(s.queues)))
+}
+
+// settle forgets the events of a bulk batch once written, or not queued.
+func (s *ShardedSink) settle(batch []*github.Event) {
+	if s.Priority == nil {
+		return
+	}
+
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	for _, ev := range batch {
+		key, _ := s.key.Key(ev)
+		if s.pending[key]--; s.pending[key] <= 0 {
+			delete(s.pending, key)
+		}
+	}
+}
+
+// Write splits the batch per worker and lane, and queues the parts.
+func (s *ShardedSink) Write(ctx context.Context, events []*github.Event) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return ErrSinkClosed
+	}
+
+	n := len(s.queues)
+	// The priority parts first, then the bulk ones.
+	parts := make([][]*github.Event, 2*n)
+	s.pendingMu.Lock()
+	for _, ev := range events {
+		// Events without the key all land on the same worker, and stay
+		// ordered.
+		key, _ := s.key.Key(ev)
+		i := s.shard(key)
+		if s.Priority == nil {
+			i += n
+		} else if !s.Priority(ev) || s.pending[key] > 0 {
+			i += n
+			s.pending[key]++
+		}
+		parts[i] = append(parts[i], ev)
+	}
+	s.pendingMu.Unlock()
+
+	for i, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+
+		q := s.queues[i%n]
+		if i < n {
+			q = s.urgent[i]
+		}
+
+		select {
+		case q <- part:
+		case <-ctx.Done():
+			// The bulk parts left won't be written.
+			if i < n {
+				i = n
+			}
+			for _, part := range parts[i:] {
+				s.settle(part)
+			}
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Close waits for the queued batches to be written and closes the sink.
+func (s *ShardedSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	for i := range s.queues {
+		close(s.queues[i])
+		close(s.urgent[i])
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return s.sink.Close()
+}
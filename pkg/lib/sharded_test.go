@@ -0,0 +1,86 @@
+package lib_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// orderSink records the IDs written per repository, taking its time.
+type orderSink struct {
+	mu  sync.Mutex
+	ids map[string][]int
+}
+
+func (s *orderSink) Write(ctx context.Context, events []*github.Event) error {
+	time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ev := range events {
+		id, _ := strconv.Atoi(ev.GetID())
+		s.ids[ev.GetRepo().GetName()] = append(s.ids[ev.GetRepo().GetName()], id)
+	}
+	return nil
+}
+
+func (s *orderSink) Close() error { return nil }
+
+// Run with -race, the workers write concurrently.
+func TestShardedSinkKeyOrder(t *testing.T) {
+	testShardedSinkKeyOrder(t, nil)
+}
+
+// The priority events don't overtake the bulk events of their key.
+func TestShardedSinkPriorityKeyOrder(t *testing.T) {
+	testShardedSinkKeyOrder(t, func(ev *github.Event) bool {
+		id, _ := strconv.Atoi(ev.GetID())
+		return id%3 == 0
+	})
+}
+
+func testShardedSinkKeyOrder(t *testing.T, priority func(*github.Event) bool) {
+	key, err := lib.ParseKeySpec("repo.name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := &orderSink{ids: make(map[string][]int)}
+	sink := lib.NewShardedSink(recorded, key, 4, 2)
+	sink.Priority = priority
+
+	const repos, batches = 16, 50
+	id := 0
+	for b := 0; b < batches; b++ {
+		var batch []*github.Event
+		for r := 0; r < repos; r++ {
+			id++
+			batch = append(batch, testEvent(fmt.Sprint(id), "PushEvent", "", fmt.Sprintf("o/r%d", (r*7+b)%repos), time.Time{}, ""))
+		}
+		if err := sink.Write(context.Background(), batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for repo, ids := range recorded.ids {
+		total += len(ids)
+		for i := 1; i < len(ids); i++ {
+			if ids[i] <= ids[i-1] {
+				t.Errorf("%s: wrote %d after %d", repo, ids[i], ids[i-1])
+			}
+		}
+	}
+	if len(recorded.ids) != repos || total != repos*batches {
+		t.Errorf("wrote %d events of %d repositories, want %d of %d", total, len(recorded.ids), repos*batches, repos)
+	}
+}
@@ -0,0 +1,267 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	defaultSignatureBudget = 1000
+	defaultSignatureTime   = 5 * time.Second
+	signatureQueue         = 1000
+)
+
+// Signature states of the commits checked by a SignatureChecker.
+const (
+	// Signed with a key GitHub verified, e.g. a GPG key of the author.
+	SignatureVerified = "verified"
+	// Signed, the signature not verified (unknown key, expired...).
+	SignatureUnverified = "unverified"
+	SignatureUnsigned   = "unsigned"
+)
+
+var signatureStates = []string{SignatureVerified, SignatureUnverified, SignatureUnsigned}
+
+// SignatureStats are the commits of a repository checked by a
+// SignatureChecker, by signature state.
+type SignatureStats map[string]uint64
+
+// Coverage returns the fraction of the commits checked which are verified.
+func (s SignatureStats) Coverage() float64 {
+	var total uint64
+	for _, n := range s {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(s[SignatureVerified]) / float64(total)
+}
+
+type signatureCheck struct {
+	repo, sha string
+}
+
+// SignatureChecker checks whether the commits pushed to a set of
+// repositories (all if empty) are signed, fetching them with the Git
+// Database API, for supply chain security reporting: the commits checked
+// are counted by repository and signature state (see SignatureStats).
+//
+// Checks are capped by an hourly budget, like RepoSnapshotter's captures,
+// commits pushed while the budget is exhausted or the queue is full are
+// left unchecked. Commits pushed again (distinct false) are checked once.
+type SignatureChecker struct {
+	// Called with non-fatal errors, e.g. EventFeed.ReportError.
+	ReportError func(op, eventID string, err error)
+	// Shared budget of the checks, at low priority, instead of the
+	// checker's own if not nil.
+	Scheduler *EnrichmentScheduler
+
+	client  *github.Client
+	clock   Clock
+	tracked map[string]bool
+	budget  int
+	queue   chan signatureCheck
+
+	mu      sync.Mutex
+	window  time.Time
+	lookups int
+	// Keyed by lower case repository name.
+	repos map[string]*signatureRepo
+}
+
+type signatureRepo struct {
+	name  string
+	stats SignatureStats
+}
+
+type signaturePayload struct {
+	Commits []struct {
+		SHA      *string `json:"sha"`
+		Distinct *bool   `json:"distinct"`
+	} `json:"commits"`
+}
+
+// NewSignatureChecker returns a checker of the commits pushed to repos,
+// allowing at most budget checks per hour (1000 if zero), timed by clock
+// (the system clock if nil).
+func NewSignatureChecker(client *github.Client, repos []string, budget int, clock Clock) *SignatureChecker {
+	c := &SignatureChecker{
+		client:  client,
+		clock:   clockOrSystem(clock),
+		tracked: make(map[string]bool, len(repos)),
+		budget:  orDefault(budget, defaultSignatureBudget),
+		queue:   make(chan signatureCheck, signatureQueue),
+		repos:   make(map[string]*signatureRepo),
+	}
+	for _, repo := range repos {
+		c.tracked[strings.ToLower(repo)] = true
+	}
+	return c
+}
+
+func (c *SignatureChecker) tracks(repo string) bool {
+	return len(c.tracked) == 0 || c.tracked[strings.ToLower(repo)]
+}
+
+// Observe queues the check of the commits pushed in the batch. It never
+// blocks, commits not fitting in the queue are skipped.
+func (c *SignatureChecker) Observe(events []*github.Event) {
+	for _, ev := range events {
+		repo := ev.GetRepo().GetName()
+		if ev.GetType() != "PushEvent" || repo == "" || !c.tracks(repo) || ev.RawPayload == nil {
+			continue
+		}
+
+		var payload signaturePayload
+		if err := json.Unmarshal(*ev.RawPayload, &payload); err != nil {
+			continue
+		}
+		for _, commit := range payload.Commits {
+			if commit.SHA == nil || (commit.Distinct != nil && !*commit.Distinct) {
+				continue
+			}
+			select {
+			case c.queue <- signatureCheck{repo, *commit.SHA}:
+			default:
+			}
+		}
+	}
+}
+
+// spend reports whether a check fits in the budget.
+func (c *SignatureChecker) spend(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.window) >= time.Hour {
+		c.window, c.lookups = now, 0
+	}
+	if c.lookups >= c.budget {
+		return false
+	}
+	c.lookups++
+	return true
+}
+
+// Serve checks the queued commits until ctx is done.
+func (c *SignatureChecker) Serve(ctx context.Context) error {
+	for {
+		var check signatureCheck
+		select {
+		case check = <-c.queue:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		var err error
+		if c.Scheduler != nil {
+			err = c.Scheduler.Do(ctx, EnrichLow, func(ctx context.Context) error { return c.check(ctx, check) })
+			if errors.Is(err, ErrEnrichBudget) || errors.Is(err, ErrEnrichQueueFull) {
+				err = nil
+			} else if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		} else if c.spend(c.clock.Now()) {
+			err = c.check(ctx, check)
+		}
+
+		if err != nil {
+			var rate *github.RateLimitError
+			if errors.As(err, &rate) {
+				c.mu.Lock()
+				c.window, c.lookups = rateLimitReset(rate.Rate.Reset.Time, rate.Response, c.clock.Now()).Add(-time.Hour), c.budget
+				c.mu.Unlock()
+			}
+			if c.ReportError != nil {
+				c.ReportError(OpEnrich, "", fmt.Errorf("checking the signature of %s@%s: %w", check.repo, check.sha, err))
+			}
+		}
+	}
+}
+
+func (c *SignatureChecker) check(ctx context.Context, check signatureCheck) error {
+	parts := strings.SplitN(check.repo, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid repository name")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, defaultSignatureTime)
+	defer cancel()
+
+	commit, _, err := c.client.Git.GetCommit(WithSubsystem(ctx, SubsystemSignatures), parts[0], parts[1], check.sha)
+	if err != nil {
+		return err
+	}
+
+	state := SignatureUnsigned
+	if v := commit.GetVerification(); v.GetVerified() {
+		state = SignatureVerified
+	} else if v.GetSignature() != "" {
+		state = SignatureUnverified
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := strings.ToLower(check.repo)
+	r, ok := c.repos[key]
+	if !ok {
+		r = &signatureRepo{name: check.repo, stats: make(SignatureStats)}
+		c.repos[key] = r
+	}
+	r.stats[state]++
+	return nil
+}
+
+// Stats returns the commits checked, by repository.
+func (c *SignatureChecker) Stats() map[string]SignatureStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]SignatureStats, len(c.repos))
+	for _, r := range c.repos {
+		s := make(SignatureStats, len(r.stats))
+		for state, n := range r.stats {
+			s[state] = n
+		}
+		stats[r.name] = s
+	}
+	return stats
+}
+
+// WritePrometheus writes the commits checked in the Prometheus text format:
+//
+//	github_feed_commit_signatures_total{repo,state}   commits checked
+//	github_feed_commit_signature_coverage{repo}       fraction verified
+func (c *SignatureChecker) WritePrometheus(w io.Writer) {
+	stats := c.Stats()
+	repos := make([]string, 0, len(stats))
+	for repo := range stats {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	fmt.Fprintf(w, "# HELP %s_commit_signatures_total Pushed commits checked by signature state.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_commit_signatures_total counter\n", statsNamespace)
+	for _, repo := range repos {
+		for _, state := range signatureStates {
+			fmt.Fprintf(w, "%s_commit_signatures_total{repo=\"%s\",state=\"%s\"} %d\n",
+				statsNamespace, labelEscaper.Replace(repo), state, stats[repo][state])
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP %s_commit_signature_coverage Fraction of the pushed commits checked with a verified signature.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_commit_signature_coverage gauge\n", statsNamespace)
+	for _, repo := range repos {
+		fmt.Fprintf(w, "%s_commit_signature_coverage{repo=\"%s\"} %g\n", statsNamespace, labelEscaper.Replace(repo), stats[repo].Coverage())
+	}
+}
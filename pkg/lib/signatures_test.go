@@ -0,0 +1,80 @@
+package lib_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestSignatureChecker(t *testing.T) {
+	verifications := map[string]interface{}{
+		"a": map[string]interface{}{"verified": true, "signature": "-----BEGIN PGP SIGNATURE-----"},
+		"b": map[string]interface{}{"verified": false, "reason": "unknown_key", "signature": "-----BEGIN PGP SIGNATURE-----"},
+		"c": map[string]interface{}{"verified": false, "reason": "unsigned"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(strings.ToLower(r.URL.Path), "/repos/octocat/hello/git/commits/") {
+			http.NotFound(w, r)
+			return
+		}
+		sha := path.Base(r.URL.Path)
+		json.NewEncoder(w).Encode(map[string]interface{}{"sha": sha, "verification": verifications[sha]})
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+
+	checker := lib.NewSignatureChecker(client, []string{"octocat/hello"}, 0, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go checker.Serve(ctx)
+
+	push := func(repo, payload string) *github.Event {
+		raw := json.RawMessage(payload)
+		return &github.Event{Type: github.String("PushEvent"), Repo: &github.Repository{Name: github.String(repo)}, RawPayload: &raw}
+	}
+	checker.Observe([]*github.Event{
+		push("octocat/hello", `{"commits":[{"sha":"a","distinct":true},{"sha":"b","distinct":true},{"sha":"a","distinct":false}]}`),
+		push("octocat/other", `{"commits":[{"sha":"d","distinct":true}]}`),
+		push("octocat/Hello", `{"commits":[{"sha":"c","distinct":true}]}`),
+	})
+
+	var stats lib.SignatureStats
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(time.Millisecond) {
+		stats = checker.Stats()["octocat/hello"]
+		if stats[lib.SignatureVerified]+stats[lib.SignatureUnverified]+stats[lib.SignatureUnsigned] == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out, got %v", checker.Stats())
+		}
+	}
+	if stats[lib.SignatureVerified] != 1 || stats[lib.SignatureUnverified] != 1 || stats[lib.SignatureUnsigned] != 1 {
+		t.Errorf("got %v, want a commit of each state", stats)
+	}
+	if len(checker.Stats()) != 1 {
+		t.Errorf("checked the commits of other repositories: %v", checker.Stats())
+	}
+
+	var metrics bytes.Buffer
+	checker.WritePrometheus(&metrics)
+	for _, line := range []string{
+		`github_feed_commit_signatures_total{repo="octocat/hello",state="verified"} 1`,
+		`github_feed_commit_signature_coverage{repo="octocat/hello"} 0.3333333333333333`,
+	} {
+		if !strings.Contains(metrics.String(), line+"\n") {
+			t.Errorf("missing %s in\n%s", line, metrics.String())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Sink receives the batches of events published by a feed. Write must not
+// retain the batch after returning.
+type Sink interface {
+	Write(ctx context.Context, events []*github.Event) error
+	Close() error
+}
+
+// SinkStats are the delivery totals of an instrumented sink.
+type SinkStats struct {
+	Events   uint64 `json:"events"`
+	Batches  uint64 `json:"batches"`
+	Failures uint64 `json:"failures"`
+}
+
+var sinkStats = struct {
+	sync.Mutex
+	byName map[string]*InstrumentedSink
+}{byName: make(map[string]*InstrumentedSink)}
+
+// InstrumentedSink counts the events a sink delivered and its failed writes,
+// see WriteSinkMetrics. Wrapped by a DeadLetterSink, every failed attempt is
+// counted.
+type InstrumentedSink struct {
+	Sink
+
+	events   uint64
+	batches  uint64
+	failures uint64
+}
+
+// NewInstrumentedSink registers the sink under name, replacing the sink
+// previously registered under it.
+func NewInstrumentedSink(name string, sink Sink) *InstrumentedSink {
+	s := &InstrumentedSink{Sink: sink}
+
+	sinkStats.Lock()
+	sinkStats.byName[name] = s
+	sinkStats.Unlock()
+
+	return s
+}
+
+func (s *InstrumentedSink) Write(ctx context.Context, events []*github.Event) error {
+	if err := s.Sink.Write(ctx, events); err != nil {
+		atomic.AddUint64(&s.failures, 1)
+		return err
+	}
+
+	atomic.AddUint64(&s.batches, 1)
+	atomic.AddUint64(&s.events, uint64(len(events)))
+	return nil
+}
+
+func (s *InstrumentedSink) Stats() SinkStats {
+	return SinkStats{
+		Events:   atomic.LoadUint64(&s.events),
+		Batches:  atomic.LoadUint64(&s.batches),
+		Failures: atomic.LoadUint64(&s.failures),
+	}
+}
+
+// AllSinkStats returns the delivery totals of the instrumented sinks, by
+// name.
+func AllSinkStats() map[string]SinkStats {
+	sinkStats.Lock()
+	defer sinkStats.Unlock()
+
+	stats := make(map[string]SinkStats, len(sinkStats.byName))
+	for name, s := range sinkStats.byName {
+		stats[name] = s.Stats()
+	}
+	return stats
+}
+
+// WriteSinkMetrics writes the delivery totals of the instrumented sinks in
+// the Prometheus text format, labeled by sink name:
+//
+//	github_feed_sink_events_total{sink}    events delivered
+//	github_feed_sink_batches_total{sink}   batches delivered
+//	github_feed_sink_failures_total{sink}  failed writes, retries included
+func WriteSinkMetrics(w io.Writer) {
+	stats := AllSinkStats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	series := []struct {
+		name, help string
+		value      func(SinkStats) uint64
+	}{
+		{"sink_events_total", "Events delivered by the sink.", func(s SinkStats) uint64 { return s.Events }},
+		{"sink_batches_total", "Batches delivered by the sink.", func(s SinkStats) uint64 { return s.Batches }},
+		{"sink_failures_total", "Failed writes of the sink, retries included.", func(s SinkStats) uint64 { return s.Failures }},
+	}
+
+	for _, m := range series {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n", statsNamespace, m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s_%s counter\n", statsNamespace, m.name)
+		for _, name := range names {
+			fmt.Fprintf(w, "%s_%s{sink=\"%s\"} %d\n", statsNamespace, m.name, labelEscaper.Replace(name), m.value(stats[name]))
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// Types of Source.
+const (
+	// The public events of the network, the default.
+	SourcePublic = "public"
+	// The events of a repository, named owner/name.
+	SourceRepo = "repo"
+	// The public events of an organization.
+	SourceOrg = "org"
+	// The events performed by a user, private ones included when the token
+	// is the user's.
+	SourceUser = "user"
+)
+
+// Source is an events endpoint polled by a feed, see Config.Sources.
+type Source struct {
+	Type string
+	Name string
+}
+
+// ParseSource parses a source as type:name, e.g. org:kubernetes or
+// repo:golang/go, or public.
+func ParseSource(s string) (Source, error) {
+	kv := strings.SplitN(s, ":", 2)
+	source := Source{Type: kv[0]}
+	if len(kv) == 2 {
+		source.Name = kv[1]
+	}
+
+	if _, err := source.path(); err != nil {
+		return Source{}, err
+	}
+	return source, nil
+}
+
+func (s Source) String() string {
+	if s.Name == "" {
+		return s.Type
+	}
+	return s.Type + ":" + s.Name
+}
+
+// MarshalText encodes the source as type:name, e.g. in JSON.
+func (s Source) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses a source like ParseSource.
+func (s *Source) UnmarshalText(text []byte) error {
+	source, err := ParseSource(string(text))
+	if err != nil {
+		return err
+	}
+	*s = source
+	return nil
+}
+
+// path returns the API path listing the events of the source.
+func (s Source) path() (string, error) {
+	switch s.Type {
+	case SourcePublic:
+		if s.Name == "" {
+			return "events", nil
+		}
+	case SourceRepo:
+		kv := strings.SplitN(s.Name, "/", 2)
+		if len(kv) == 2 && kv[0] != "" && kv[1] != "" && !strings.Contains(kv[1], "/") {
+			return fmt.Sprintf("repos/%s/%s/events", url.PathEscape(kv[0]), url.PathEscape(kv[1])), nil
+		}
+	case SourceOrg, SourceUser:
+		if s.Name != "" && !strings.Contains(s.Name, "/") {
+			return fmt.Sprintf("%ss/%s/events", s.Type, url.PathEscape(s.Name)), nil
+		}
+	default:
+		return "", fmt.Errorf("unknown event source '%s', expected %s, %s, %s or %s", s.Type, SourcePublic, SourceRepo, SourceOrg, SourceUser)
+	}
+
+	return "", fmt.Errorf("invalid event source '%s'", s)
+}
+
+// match reports whether the source lists an event, e.g. one of GH Archive.
+func (s Source) match(ev *github.Event) bool {
+	switch s.Type {
+	case SourceRepo:
+		return strings.EqualFold(ev.GetRepo().GetName(), s.Name)
+	case SourceOrg:
+		return strings.EqualFold(ev.GetOrg().GetLogin(), s.Name)
+	case SourceUser:
+		return strings.EqualFold(ev.GetActor().GetLogin(), s.Name)
+	}
+	return true
+}
+
+// sourcePoller is the polling state of a source of the feed.
+type sourcePoller struct {
+	source Source
+	path   string
+	// Position of the source, updated under the feed's checkpointMu.
+	checkpoint Checkpoint
+	// ETag sent with the first poll, from the loaded checkpoint.
+	resumeETag string
+	// ETag of the first page of the last poll.
+	pollETag string
+	retry    retryPolicy
+	// Whether the source catches up, see Config.CatchUpAfter, and the last
+	// poll reached the events published before it.
+	catchingUp bool
+	caughtUp   bool
+	// Set once the first poll catching up looked for a gap.
+	gapChecked bool
+	// Stops the poller, set by Serve, and set by RemoveSource under the
+	// feed's checkpointMu.
+	stop    context.CancelFunc
+	removed bool
+}
+
+var (
+	// ErrNotPolling is returned when changing the sources of a feed which
+	// receives webhooks or replays GH Archive.
+	ErrNotPolling = errors.New("the feed doesn't poll the events API")
+	ErrLastSource = errors.New("the last source of the feed can't be removed")
+)
+
+func (f *EventFeed) pollers() []*sourcePoller {
+	f.checkpointMu.Lock()
+	defer f.checkpointMu.Unlock()
+	return f.sources
+}
+
+// Sources returns the sources polled by the feed.
+func (f *EventFeed) Sources() []Source {
+	pollers := f.pollers()
+	sources := make([]Source, len(pollers))
+	for i, p := range pollers {
+		sources[i] = p.source
+	}
+	return sources
+}
+
+func (f *EventFeed) findSource(source Source) int {
+	for i, p := range f.sources {
+		if strings.EqualFold(p.source.String(), source.String()) {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddSource starts polling a source, e.g. a repository created after the
+// feed started, and reports whether it was added, false if the feed already
+// polls it. The first poll of an added source publishes the events the API
+// still lists, those already published are dropped by the Deduplicator.
+func (f *EventFeed) AddSource(source Source) (bool, error) {
+	if f.webhook != nil || f.gharchive != nil {
+		return false, ErrNotPolling
+	}
+	path, err := source.path()
+	if err != nil {
+		return false, err
+	}
+
+	f.checkpointMu.Lock()
+	defer f.checkpointMu.Unlock()
+
+	if f.findSource(source) >= 0 {
+		return false, nil
+	}
+
+	p := &sourcePoller{source: source, path: path, retry: f.sourceRetry}
+	f.sources = append(f.sources[:len(f.sources):len(f.sources)], p)
+	if f.startPoller != nil {
+		f.startPoller(p)
+	}
+	return true, nil
+}
+
+// RemoveSource stops polling a source and reports whether it was removed,
+// false if the feed doesn't poll it. Its checkpoint is dropped, the last
+// source of the feed can't be removed.
+func (f *EventFeed) RemoveSource(source Source) (bool, error) {
+	if f.webhook != nil || f.gharchive != nil {
+		return false, ErrNotPolling
+	}
+
+	f.checkpointMu.Lock()
+	defer f.checkpointMu.Unlock()
+
+	i := f.findSource(source)
+	if i < 0 {
+		return false, nil
+	} else if len(f.sources) == 1 {
+		return false, ErrLastSource
+	}
+
+	p := f.sources[i]
+	sources := make([]*sourcePoller, 0, len(f.sources)-1)
+	f.sources = append(append(sources, f.sources[:i]...), f.sources[i+1:]...)
+	p.removed = true
+	if p.stop != nil {
+		p.stop()
+	}
+	return true, nil
+}
+
+// fresh returns the events of the batch newer than the source's checkpoint
+// and not already seen by the deduplicator, and whether some were seen, in
+// which case the older pages were seen too. Events without ID are kept.
+func (p *sourcePoller) fresh(batch []*github.Event, dedup *Deduplicator, shared bool) ([]*github.Event, bool) {
+	fresh := batch
+	if p.checkpoint.EventID != "" {
+		fresh = make([]*github.Event, 0, len(batch))
+		for _, ev := range batch {
+			if id := ev.GetID(); id == "" || eventIDAfter(id, p.checkpoint.EventID) {
+				fresh = append(fresh, ev)
+			}
+		}
+	}
+	seen := len(fresh) < len(batch)
+
+	if dedup != nil {
+		deduplicated := dedup.Filter(fresh)
+		// A deduplicator shared by several sources drops the events the
+		// others published, this source's older pages may be unseen.
+		seen = seen || (!shared && len(deduplicated) < len(fresh))
+		fresh = deduplicated
+	}
+
+	return fresh, seen
+}
@@ -0,0 +1,129 @@
+package lib_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// eventsEndpoint serves a new event on every poll, with IDs from first, and
+// the poll interval.
+func eventsEndpoint(first int, interval string, hits *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(hits, 1)
+		w.Header().Set("X-Poll-Interval", interval)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":"` + strconv.Itoa(first+int(n)) + `","type":"PushEvent","actor":{"login":"a"}}]`))
+	}
+}
+
+func TestFeedPollsSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var repoHits, orgHits int32
+	mux := http.NewServeMux()
+	mux.Handle("/repos/o/r/events", eventsEndpoint(2000, "60", &repoHits))
+	mux.Handle("/orgs/k/events", eventsEndpoint(1000, "3600", &orgHits))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	sources := make([]lib.Source, 0, 2)
+	for _, s := range []string{"repo:o/r", "org:k"} {
+		source, err := lib.ParseSource(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, source)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := lib.NewSimulatedClock(time.Now())
+	store := lib.NewFileCheckpointStore(filepath.Join(dir, "checkpoint"))
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{BaseURL: server.URL, Sources: sources, Clock: clock, Checkpoints: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go feed.Serve()
+
+	received := func(n int) map[string]bool {
+		ids := make(map[string]bool)
+		for i := 0; i < n; i++ {
+			for _, ev := range nextBatch(t, events) {
+				ids[ev.GetID()] = true
+			}
+		}
+		return ids
+	}
+	waitForTimers := func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for clock.Waiters() != len(sources) {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the sources to wait for their next poll")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if ids := received(2); !ids["2001"] || !ids["1001"] {
+		t.Fatalf("first polls published %v", ids)
+	}
+
+	// Each source polls at its own interval.
+	waitForTimers()
+	clock.Advance(time.Minute)
+	if ids := received(1); !ids["2002"] {
+		t.Fatalf("second repository poll published %v", ids)
+	}
+	waitForTimers()
+	if n := atomic.LoadInt32(&orgHits); n != 1 {
+		t.Errorf("organization polled %d times within its poll interval", n)
+	}
+
+	cp, err := store.Load()
+	if err != nil || cp.Sources["repo:o/r"].EventID != "2002" || cp.Sources["org:k"].EventID != "1001" {
+		t.Errorf("checkpoint = %+v, %v", cp, err)
+	}
+
+	clock.Advance(time.Hour - time.Minute)
+	if ids := received(2); !ids["2003"] || !ids["1002"] {
+		t.Errorf("polls after an hour published %v", ids)
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	for s, expected := range map[string]lib.Source{
+		"public":         {Type: lib.SourcePublic},
+		"repo:golang/go": {Type: lib.SourceRepo, Name: "golang/go"},
+		"org:kubernetes": {Type: lib.SourceOrg, Name: "kubernetes"},
+		"user:octocat":   {Type: lib.SourceUser, Name: "octocat"},
+	} {
+		if source, err := lib.ParseSource(s); err != nil || source != expected || source.String() != s {
+			t.Errorf("ParseSource(%s) = %+v, %v", s, source, err)
+		}
+	}
+
+	for _, s := range []string{"team:x", "repo:golang", "repo:a/b/c", "org:", "public:x"} {
+		if _, err := lib.ParseSource(s); err == nil {
+			t.Errorf("ParseSource(%s) accepted an invalid source", s)
+		}
+	}
+
+	if _, _, err := lib.NewEventFeed(context.Background(), &lib.Config{Sources: []lib.Source{{Type: "team"}}}); err == nil {
+		t.Error("NewEventFeed accepted an invalid source")
+	}
+}
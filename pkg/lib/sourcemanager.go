@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sourceStateVersion = 1
+	maxSourceBody      = 4 * 1024
+)
+
+// ManagedSource is a source of the feed persisted by a SourceManager.
+type ManagedSource struct {
+	Source Source `json:"source"`
+	// Who added the source at runtime, e.g. the admin client, empty for the
+	// configured sources, and when, or when a configured source was first
+	// managed.
+	AddedBy string    `json:"added_by,omitempty"`
+	Added   time.Time `json:"added"`
+}
+
+// SourceManager adds and removes the sources of a running feed, e.g. to
+// start watching a newly created repository without a restart. The sources
+// are persisted in a state file, from which a restarted feed polls them
+// instead of its configured ones.
+type SourceManager struct {
+	path string
+
+	mu      sync.Mutex
+	feed    *EventFeed
+	sources []ManagedSource
+	loaded  bool
+}
+
+// NewSourceManager loads the sources persisted at path, if not empty.
+func NewSourceManager(path string) (*SourceManager, error) {
+	m := &SourceManager{path: path}
+	if path == "" {
+		return m, nil
+	}
+
+	payload, err := ReadMigratedStateFile(path, sourceStateVersion, nil)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(payload, &m.sources); err != nil {
+		return nil, err
+	}
+	m.loaded = true
+	return m, nil
+}
+
+// Sources returns the persisted sources, to be polled by the feed (see
+// Config.Sources), nil if none were.
+func (m *SourceManager) Sources() []Source {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.loaded {
+		return nil
+	}
+	sources := make([]Source, len(m.sources))
+	for i, s := range m.sources {
+		sources[i] = s.Source
+	}
+	return sources
+}
+
+// Manage attaches the manager to the feed, whose sources it changes from
+// then on. The feed's sources missing from the state are recorded as
+// configured ones.
+func (m *SourceManager) Manage(feed *EventFeed) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.feed = feed
+	m.sync()
+}
+
+// sync records the sources of the feed, keeping what is known of them.
+func (m *SourceManager) sync() {
+	known := make(map[string]ManagedSource, len(m.sources))
+	for _, s := range m.sources {
+		known[strings.ToLower(s.Source.String())] = s
+	}
+
+	sources := m.feed.Sources()
+	m.sources = make([]ManagedSource, len(sources))
+	for i, source := range sources {
+		if s, ok := known[strings.ToLower(source.String())]; ok {
+			m.sources[i] = s
+		} else {
+			m.sources[i] = ManagedSource{Source: source, Added: m.feed.clock.Now().UTC()}
+		}
+	}
+}
+
+func (m *SourceManager) save() error {
+	if m.path == "" {
+		return nil
+	}
+
+	payload, err := json.Marshal(m.sources)
+	if err != nil {
+		return err
+	}
+	if err := WriteStateFile(m.path, sourceStateVersion, payload); err != nil {
+		return err
+	}
+	m.loaded = true
+	return nil
+}
+
+// List returns the sources of the feed, in the order they were added.
+func (m *SourceManager) List() []ManagedSource {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ManagedSource(nil), m.sources...)
+}
+
+// Add starts polling a source and persists it, reporting whether it was
+// added, false if the feed already polls it.
+func (m *SourceManager) Add(source Source, addedBy string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.feed == nil {
+		return false, ErrNotPolling
+	}
+	added, err := m.feed.AddSource(source)
+	if err != nil || !added {
+		return false, err
+	}
+
+	m.sources = append(m.sources, ManagedSource{Source: source, AddedBy: addedBy, Added: m.feed.clock.Now().UTC()})
+	return true, m.save()
+}
+
+// Remove stops polling a source and persists its removal, reporting whether
+// it was removed, false if the feed doesn't poll it.
+func (m *SourceManager) Remove(source Source) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.feed == nil {
+		return false, ErrNotPolling
+	}
+	removed, err := m.feed.RemoveSource(source)
+	if err != nil || !removed {
+		return false, err
+	}
+
+	m.sync()
+	return true, m.save()
+}
+
+// ServeHTTP exposes the manager, e.g. on the admin endpoint:
+//
+//	GET    /sources
+//	POST   /sources {"source": "repo:owner/name"}
+//	DELETE /sources?source=repo:owner/name
+//
+// The sources posted are recorded as added by the authenticated client, see
+// ClientIdentity.
+func (m *SourceManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.List())
+	case http.MethodPost:
+		var body struct {
+			Source Source `json:"source"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSourceBody)).Decode(&body); err != nil {
+			http.Error(w, "invalid source: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if body.Source.Type == "" {
+			http.Error(w, "missing source", http.StatusBadRequest)
+			return
+		}
+
+		added, err := m.Add(body.Source, ClientIdentity(r))
+		if err != nil {
+			sourceError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if added {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(body)
+	case http.MethodDelete:
+		source, err := ParseSource(r.URL.Query().Get("source"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		removed, err := m.Remove(source)
+		if err != nil {
+			sourceError(w, err)
+		} else if !removed {
+			http.Error(w, "not found", http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// sourceError reports a change the feed refused as a conflict, the others
+// (e.g. saving the state) as the server's errors.
+func sourceError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, ErrNotPolling) || errors.Is(err, ErrLastSource) {
+		status = http.StatusConflict
+	}
+	http.Error(w, err.Error(), status)
+}
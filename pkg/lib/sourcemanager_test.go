@@ -0,0 +1,122 @@
+package lib_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestSourceManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var repoHits, newHits int32
+	mux := http.NewServeMux()
+	mux.Handle("/repos/o/r/events", eventsEndpoint(2000, "60", &repoHits))
+	mux.Handle("/repos/o/new/events", eventsEndpoint(3000, "60", &newHits))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(dir, "sources")
+	manager, err := lib.NewSourceManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sources := manager.Sources(); sources != nil {
+		t.Fatalf("got sources %v without state", sources)
+	}
+
+	clock := lib.NewSimulatedClock(time.Now())
+	store := lib.NewFileCheckpointStore(filepath.Join(dir, "checkpoint"))
+	feed, events, err := lib.NewEventFeed(ctx, &lib.Config{
+		BaseURL: server.URL, Sources: []lib.Source{{Type: lib.SourceRepo, Name: "o/r"}}, Clock: clock, Checkpoints: store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Manage(feed)
+	go feed.Serve()
+
+	if id := nextBatchIDs(t, events); id != "2001" {
+		t.Fatalf("first poll published %s", id)
+	}
+
+	request := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		manager.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	// The added repository is polled right away.
+	if rec := request(http.MethodPost, "/sources", `{"source": "repo:o/new"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("adding a source: %d %s", rec.Code, rec.Body)
+	}
+	if id := nextBatchIDs(t, events); id != "3001" {
+		t.Fatalf("the added source published %s", id)
+	}
+
+	for _, c := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodPost, "/sources", `{"source": "repo:O/New"}`, http.StatusOK},
+		{http.MethodPost, "/sources", `{"source": "team:x"}`, http.StatusBadRequest},
+		{http.MethodPost, "/sources", `{}`, http.StatusBadRequest},
+		{http.MethodDelete, "/sources?source=repo:o/other", "", http.StatusNotFound},
+		{http.MethodDelete, "/sources?source=repo:o/r", "", http.StatusNoContent},
+		{http.MethodDelete, "/sources?source=repo:o/new", "", http.StatusConflict},
+		{http.MethodPut, "/sources", "", http.StatusMethodNotAllowed},
+	} {
+		if rec := request(c.method, c.target, c.body); rec.Code != c.code {
+			t.Errorf("%s %s %s: got %d %s, want %d", c.method, c.target, c.body, rec.Code, rec.Body, c.code)
+		}
+	}
+
+	var listed []lib.ManagedSource
+	if err := json.Unmarshal(request(http.MethodGet, "/sources", "").Body.Bytes(), &listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Source.String() != "repo:o/new" || listed[0].Added.IsZero() {
+		t.Errorf("listed %+v, want repo:o/new", listed)
+	}
+
+	// The removed source is dropped from the checkpoint.
+	clock.Advance(time.Minute)
+	if id := nextBatchIDs(t, events); id != "3002" {
+		t.Fatalf("the remaining source published %s", id)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		cp, err := store.Load()
+		if err == nil && cp.EventID == "3002" && cp.Sources == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("checkpoint = %+v, %v", cp, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A restarted feed polls the persisted sources.
+	reloaded, err := lib.NewSourceManager(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sources := reloaded.Sources(); len(sources) != 1 || sources[0].String() != "repo:o/new" {
+		t.Errorf("reloaded sources %v, want repo:o/new", sources)
+	}
+}
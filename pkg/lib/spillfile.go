@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// spillFile holds batches of events in a file, one JSON array per line, read
+// back in order, for the queues spilling to disk (see spillQueue and
+// SpillingSink). The batches left in it by a previous process are read
+// first. Its users serialize the calls.
+type spillFile struct {
+	path string
+	w    *os.File
+	rf   *os.File
+	r    *bufio.Reader
+	// Batches in the file not yet done, the one read included.
+	pending int
+	// Line of the batch read, kept by close until done.
+	current []byte
+}
+
+func openSpillFile(path string) (*spillFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	w, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := os.Open(path)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	f := &spillFile{path: path, w: w, rf: rf, r: bufio.NewReader(rf)}
+
+	// Batches left by a previous process.
+	scanner := bufio.NewScanner(rf)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		f.pending++
+	}
+	if err := scanner.Err(); err != nil {
+		f.closeFiles()
+		return nil, err
+	}
+	if _, err := rf.Seek(0, 0); err != nil {
+		f.closeFiles()
+		return nil, err
+	}
+	return f, nil
+}
+
+// append spills a batch after the others.
+func (f *spillFile) append(events []*github.Event) error {
+	b, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	if _, err := f.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("spilling a batch of %d events: %v", len(events), err)
+	}
+	f.pending++
+	return nil
+}
+
+// read returns the next batch, pending until done. On errors the rest of
+// the file can't be trusted, the caller drops it.
+func (f *spillFile) read() ([]*github.Event, error) {
+	line, err := f.r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var events []*github.Event
+	if err := json.Unmarshal(line, &events); err != nil {
+		return nil, err
+	}
+	f.current = line
+	return events, nil
+}
+
+// done accounts the batch read as handled, and empties the file once they
+// all were.
+func (f *spillFile) done() {
+	f.current = nil
+	if f.pending--; f.pending == 0 {
+		f.reset()
+	}
+}
+
+// drop empties the file, e.g. once it can't be read.
+func (f *spillFile) drop() {
+	f.current = nil
+	f.pending = 0
+	f.reset()
+}
+
+func (f *spillFile) reset() {
+	if err := f.w.Truncate(0); err != nil {
+		warnf("Failed truncating the spilled batches of %s: %v", f.path, err)
+	}
+	f.rf.Seek(0, 0)
+	f.r.Reset(f.rf)
+}
+
+// close closes the file, rewritten with the batches pending, the next
+// process reads them.
+func (f *spillFile) close() error {
+	var err error
+	if f.pending > 0 {
+		err = f.compact()
+	}
+	f.closeFiles()
+	return err
+}
+
+func (f *spillFile) compact() error {
+	rest, err := ioutil.ReadAll(f.r)
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(f.current, rest...), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f *spillFile) closeFiles() {
+	f.w.Close()
+	f.rf.Close()
+}
@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// SpillingSink decouples the writes to a sink from their callers: Write
+// queues the batch and returns, and a writer writes the queued batches to
+// the sink in order, passing its errors to OnError. The queued batches are
+// held in memory up to a threshold of bytes (estimated like
+// BatchPolicy.MaxBytes), then appended to a file like the one of the spill
+// backpressure policy, read back once the writer caught up with the memory,
+// so that a slow sink degrades to disk I/O rather than growing the memory or
+// holding back the feed and the other sinks.
+//
+// Close waits for the queued batches, spilled ones included, to be
+// written. The batches left in the file by a process killed before are
+// written first by the next sink spilling to it.
+type SpillingSink struct {
+	sink      Sink
+	name      string
+	maxMemory int
+	// Called with the errors of the writer's writes.
+	OnError func(error)
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// Batches in memory, and their estimated size.
+	memory     [][]*github.Event
+	memorySize int
+	file       *spillFile
+	closed     bool
+	done       chan struct{}
+}
+
+// NewSpillingSink starts the writer of the sink, named name in the logs,
+// spilling past maxMemory bytes to the file path.
+func NewSpillingSink(name string, sink Sink, path string, maxMemory int) (*SpillingSink, error) {
+	file, err := openSpillFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if file.pending > 0 {
+		log.Printf("Writing %d batches of %s spilled to %s", file.pending, name, path)
+	}
+
+	s := &SpillingSink{
+		sink:      sink,
+		name:      name,
+		maxMemory: maxMemory,
+		OnError:   func(error) {},
+		file:      file,
+		done:      make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	go s.run()
+	return s, nil
+}
+
+// Write queues the batch, in memory unless the threshold is reached or
+// batches are spilled ahead of it.
+func (s *SpillingSink) Write(ctx context.Context, events []*github.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	size := 0
+	for _, ev := range events {
+		size += eventSize(ev)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrSinkClosed
+	}
+
+	spilled := s.file.pending
+	if spilled == 0 && s.memorySize+size <= s.maxMemory {
+		s.memory = append(s.memory, events)
+		s.memorySize += size
+		s.cond.Signal()
+		return nil
+	}
+
+	if err := s.file.append(events); err != nil {
+		return fmt.Errorf("%s: %v", s.name, err)
+	}
+	if spilled == 0 {
+		log.Printf("The writes of %s exceed %d bytes in memory, spilling to %s", s.name, s.maxMemory, s.file.path)
+	}
+	s.cond.Signal()
+	return nil
+}
+
+// next returns the next batch to write, the batches in memory first, which
+// were all queued before those spilled, false once closed and drained.
+func (s *SpillingSink) next() ([]*github.Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		for len(s.memory) == 0 && s.file.pending == 0 && !s.closed {
+			s.cond.Wait()
+		}
+
+		if len(s.memory) > 0 {
+			batch := s.memory[0]
+			s.memory[0] = nil
+			s.memory = s.memory[1:]
+			for _, ev := range batch {
+				s.memorySize -= eventSize(ev)
+			}
+			return batch, true
+		}
+		if s.file.pending == 0 {
+			return nil, false
+		}
+
+		batch, err := s.file.read()
+		if err != nil {
+			// The rest of the file can't be trusted.
+			s.OnError(fmt.Errorf("%s: reading the spilled batches, %d dropped: %v", s.name, s.file.pending, err))
+			s.file.drop()
+		} else {
+			s.file.done()
+		}
+		if s.file.pending == 0 {
+			log.Printf("%s caught up with its spilled batches", s.name)
+		}
+		if batch != nil {
+			return batch, true
+		}
+	}
+}
+
+func (s *SpillingSink) run() {
+	defer close(s.done)
+	for {
+		batch, ok := s.next()
+		if !ok {
+			return
+		}
+		if err := s.sink.Write(context.Background(), batch); err != nil {
+			s.OnError(err)
+		}
+	}
+}
+
+// Queued returns the number of batches queued in memory and spilled.
+func (s *SpillingSink) Queued() (memory, spilled int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.memory), s.file.pending
+}
+
+// Close waits for the queued batches to be written and closes the sink.
+func (s *SpillingSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	<-s.done
+	s.file.close()
+	return s.sink.Close()
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"github.com/google/go-github/v32/github"
+)
+
+const defaultSplitQueue = 64
+
+// Split routes the batches of a feed to two channels, the events matching
+// pred and the others, so that each side can be handled by its own sinks
+// without filtering the feed twice, e.g. bots to the archive only and humans
+// to a stream:
+//
+//	bots, humans := lib.Split(events, lib.LoginBotDetector{}.IsBot, 0)
+//
+// The order of the events is preserved on each side, batches left empty by
+// the split are not published. Each side buffers up to queue batches (a
+// default if not positive), a slow consumer of one side only holds back the
+// other once its queue is full. Both channels are closed once in is.
+func Split(in <-chan []*github.Event, pred func(*github.Event) bool, queue int) (match, rest <-chan []*github.Event) {
+	queue = orDefault(queue, defaultSplitQueue)
+	matchc := make(chan []*github.Event, queue)
+	restc := make(chan []*github.Event, queue)
+
+	go func() {
+		defer close(matchc)
+		defer close(restc)
+
+		for batch := range in {
+			var matched, others []*github.Event
+			for _, ev := range batch {
+				if pred(ev) {
+					matched = append(matched, ev)
+				} else {
+					others = append(others, ev)
+				}
+			}
+
+			if len(matched) > 0 {
+				matchc <- matched
+			}
+			if len(others) > 0 {
+				restc <- others
+			}
+		}
+	}()
+
+	return matchc, restc
+}
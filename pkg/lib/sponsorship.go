@@ -0,0 +1,145 @@
+package lib
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const sponsorshipEventType = "SponsorshipEvent"
+
+// SponsorshipRecord is the structured form of a SponsorshipEvent, flattened
+// for consumption by sinks and analytics.
+type SponsorshipRecord struct {
+	SchemaVersion       int       `json:"schema_version"`
+	EventID             string    `json:"event_id"`
+	Action              string    `json:"action"`
+	Sponsorable         string    `json:"sponsorable"`
+	Sponsor             string    `json:"sponsor,omitempty"`
+	PrivacyLevel        string    `json:"privacy_level,omitempty"`
+	Tier                string    `json:"tier,omitempty"`
+	MonthlyPriceInCents int       `json:"monthly_price_in_cents,omitempty"`
+	PreviousTier        string    `json:"previous_tier,omitempty"`
+	EffectiveDate       string    `json:"effective_date,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// go-github v32 does not know about sponsorship payloads, only the fields we
+// care about are decoded.
+type sponsorshipTier struct {
+	Name                *string `json:"name"`
+	MonthlyPriceInCents *int    `json:"monthly_price_in_cents"`
+}
+
+type sponsorshipPayload struct {
+	Action        *string `json:"action"`
+	EffectiveDate *string `json:"effective_date"`
+	Sponsorship   *struct {
+		Sponsorable  *github.User     `json:"sponsorable"`
+		Sponsor      *github.User     `json:"sponsor"`
+		PrivacyLevel *string          `json:"privacy_level"`
+		Tier         *sponsorshipTier `json:"tier"`
+	} `json:"sponsorship"`
+	Changes *struct {
+		Tier *struct {
+			From *sponsorshipTier `json:"from"`
+		} `json:"tier"`
+	} `json:"changes"`
+}
+
+// SponsorshipTracker extracts SponsorshipRecords for a set of sponsorable
+// accounts. An empty set of accounts tracks every sponsorship seen.
+type SponsorshipTracker struct {
+	accounts map[string]bool
+}
+
+func NewSponsorshipTracker(accounts []string) *SponsorshipTracker {
+	t := &SponsorshipTracker{accounts: make(map[string]bool, len(accounts))}
+	for _, account := range accounts {
+		t.accounts[strings.ToLower(account)] = true
+	}
+
+	return t
+}
+
+func (t *SponsorshipTracker) tracks(account string) bool {
+	return len(t.accounts) == 0 || t.accounts[strings.ToLower(account)]
+}
+
+// Track returns the records of the sponsorship events found in the batch.
+// Events with malformed payloads are skipped.
+func (t *SponsorshipTracker) Track(events []*github.Event) []*SponsorshipRecord {
+	var records []*SponsorshipRecord
+
+	for _, ev := range events {
+		if ev.GetType() != sponsorshipEventType || ev.RawPayload == nil {
+			continue
+		}
+
+		var payload sponsorshipPayload
+		if err := json.Unmarshal(*ev.RawPayload, &payload); err != nil {
+			continue
+		}
+
+		record := newSponsorshipRecord(ev, &payload)
+		if !t.tracks(record.Sponsorable) {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func newSponsorshipRecord(ev *github.Event, payload *sponsorshipPayload) *SponsorshipRecord {
+	record := &SponsorshipRecord{
+		SchemaVersion: SchemaVersion,
+		EventID:       ev.GetID(),
+		Action:        strings.ToLower(stringOrEmpty(payload.Action)),
+		CreatedAt:     ev.GetCreatedAt(),
+	}
+
+	// The events API attributes the event to the sponsorable account, use it
+	// when the payload omits the sponsorship details.
+	record.Sponsorable = ev.GetActor().GetLogin()
+	if ev.Org != nil {
+		record.Sponsorable = ev.GetOrg().GetLogin()
+	}
+
+	if s := payload.Sponsorship; s != nil {
+		if s.Sponsorable != nil {
+			record.Sponsorable = s.Sponsorable.GetLogin()
+		}
+		record.Sponsor = s.Sponsor.GetLogin()
+		record.PrivacyLevel = stringOrEmpty(s.PrivacyLevel)
+		if s.Tier != nil {
+			record.Tier = stringOrEmpty(s.Tier.Name)
+			record.MonthlyPriceInCents = intOrZero(s.Tier.MonthlyPriceInCents)
+		}
+	}
+
+	if c := payload.Changes; c != nil && c.Tier != nil && c.Tier.From != nil {
+		record.PreviousTier = stringOrEmpty(c.Tier.From.Name)
+	}
+
+	record.EffectiveDate = stringOrEmpty(payload.EffectiveDate)
+
+	return record
+}
+
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func intOrZero(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
@@ -0,0 +1,283 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// State files are written in a small binary envelope so that truncated or
+// corrupted files (NFS has been known to do this) are detected instead of
+// being silently decoded:
+//
+//	magic "GHFS" | format version (1 byte) | payload version (2 bytes)
+//	| payload length (4 bytes) | payload | CRC-32C of all previous bytes
+//
+// Files are replaced atomically (temp file, fsync, rename) and the previous
+// good copy is kept with the stateBackupSuffix, which readers fall back to.
+const (
+	stateFileMagic      = "GHFS"
+	stateFileFormat     = 1
+	stateFileHeaderSize = len(stateFileMagic) + 1 + 2 + 4
+	stateFileCRCSize    = 4
+	stateBackupSuffix   = ".bak"
+	stateCorruptSuffix  = ".corrupt"
+	stateTempPattern    = ".tmp-*"
+)
+
+var (
+	ErrStateFileCorrupted = errors.New("state file is corrupted")
+	ErrStateFileFormat    = errors.New("state file has an unsupported format")
+	// ErrStateFileVersion is returned for state written by a newer release,
+	// which this one can't read without losing what it doesn't know of.
+	ErrStateFileVersion = errors.New("state file was written by a newer version")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func encodeStateFile(version uint16, payload []byte) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, stateFileHeaderSize+len(payload)+stateFileCRCSize))
+	buf.WriteString(stateFileMagic)
+	buf.WriteByte(stateFileFormat)
+	binary.Write(buf, binary.BigEndian, version)
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+	binary.Write(buf, binary.BigEndian, crc32.Checksum(buf.Bytes(), crcTable))
+	return buf.Bytes()
+}
+
+func decodeStateFile(b []byte) (uint16, []byte, error) {
+	if len(b) < stateFileHeaderSize+stateFileCRCSize {
+		return 0, nil, ErrStateFileCorrupted
+	}
+
+	if string(b[:len(stateFileMagic)]) != stateFileMagic {
+		return 0, nil, ErrStateFileCorrupted
+	}
+
+	if b[len(stateFileMagic)] != stateFileFormat {
+		return 0, nil, ErrStateFileFormat
+	}
+
+	version := binary.BigEndian.Uint16(b[len(stateFileMagic)+1:])
+	length := binary.BigEndian.Uint32(b[len(stateFileMagic)+3:])
+	if uint64(len(b)) != uint64(stateFileHeaderSize)+uint64(length)+stateFileCRCSize {
+		return 0, nil, ErrStateFileCorrupted
+	}
+
+	body := b[:len(b)-stateFileCRCSize]
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(b[len(body):]) {
+		return 0, nil, ErrStateFileCorrupted
+	}
+
+	return version, body[stateFileHeaderSize:], nil
+}
+
+// WriteStateFile atomically replaces the state file at path with payload,
+// tagged with the payload's schema version.
+func WriteStateFile(path string, version uint16, payload []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+stateTempPattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(encodeStateFile(version, payload)); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// Keep the previous copy around, it is the fallback if the new file is
+	// ever found corrupted. A corrupted copy would replace the good backup.
+	if _, _, err := readStateFile(path); err == nil {
+		if err := os.Rename(path, path+stateBackupSuffix); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	// Some filesystems don't support syncing directories, the rename is
+	// still atomic.
+	d.Sync()
+	return nil
+}
+
+func readStateFile(path string) (uint16, []byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	version, payload, err := decodeStateFile(b)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return version, payload, nil
+}
+
+// ReadStateFile returns the version and payload of the state file at path,
+// falling back to the backup copy if the file is missing or corrupted.
+func ReadStateFile(path string) (uint16, []byte, error) {
+	version, payload, err := readStateFile(path)
+	if err == nil {
+		return version, payload, nil
+	}
+
+	bversion, bpayload, berr := readStateFile(path + stateBackupSuffix)
+	if berr != nil {
+		// Report the error of the primary file, it is the most relevant.
+		return 0, nil, err
+	}
+
+	return bversion, bpayload, nil
+}
+
+// A StateMigration upgrades the payload of a state file by one version.
+type StateMigration func(payload []byte) ([]byte, error)
+
+// ReadMigratedStateFile returns the payload of the state file at path (see
+// ReadStateFile) upgraded to the latest version, where migrations[v-1]
+// upgrades version v to v+1, so that upgrading the binary never requires
+// wiping its state. A migrated file is rewritten at once, the previous
+// version is kept as the backup copy. Versions newer than latest are refused
+// with ErrStateFileVersion.
+func ReadMigratedStateFile(path string, latest uint16, migrations []StateMigration) ([]byte, error) {
+	if len(migrations) != int(latest)-1 {
+		panic(fmt.Sprintf("%d state migrations for version %d", len(migrations), latest))
+	}
+
+	version, payload, err := ReadStateFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > latest {
+		return nil, fmt.Errorf("%s: %w: version %d, latest known is %d", path, ErrStateFileVersion, version, latest)
+	} else if version == 0 {
+		return nil, fmt.Errorf("%s: %w: version 0", path, ErrStateFileFormat)
+	} else if version == latest {
+		return payload, nil
+	}
+
+	for v := version; v < latest; v++ {
+		if payload, err = migrations[v-1](payload); err != nil {
+			return nil, fmt.Errorf("%s: migrating from version %d: %v", path, v, err)
+		}
+	}
+
+	if err := WriteStateFile(path, latest, payload); err != nil {
+		return nil, err
+	}
+	log.Printf("Migrated %s from version %d to %d", path, version, latest)
+
+	return payload, nil
+}
+
+// StateFileReport describes the health of a state file and its backup.
+type StateFileReport struct {
+	Path        string
+	Version     uint16
+	Size        int
+	Err         error
+	BackupErr   error
+	StaleTemps  []string
+	Repaired    bool
+	RepairNotes []string
+	// Set when the file is corrupted and its backup too: the state is lost,
+	// repairing only moves the file aside.
+	Unrecoverable bool
+}
+
+func (r *StateFileReport) Healthy() bool {
+	return r.Err == nil && len(r.StaleTemps) == 0
+}
+
+// CheckStateFile validates the state file at path and its backup. If repair
+// is true, a corrupted file is restored from a valid backup (or moved aside
+// when no valid copy exists, which isn't a repair) and stale temporary files
+// are removed.
+func CheckStateFile(path string, repair bool) *StateFileReport {
+	report := &StateFileReport{Path: path}
+
+	var payload []byte
+	report.Version, payload, report.Err = readStateFile(path)
+	report.Size = len(payload)
+	_, _, report.BackupErr = readStateFile(path + stateBackupSuffix)
+	report.StaleTemps, _ = filepath.Glob(path + stateTempPattern)
+	report.Unrecoverable = report.Err != nil && !os.IsNotExist(report.Err) && report.BackupErr != nil
+
+	if !repair {
+		return report
+	}
+
+	for _, tmp := range report.StaleTemps {
+		if err := os.Remove(tmp); err == nil {
+			report.RepairNotes = append(report.RepairNotes, "removed stale "+tmp)
+		}
+	}
+
+	if report.Err == nil {
+		report.Repaired = len(report.RepairNotes) > 0
+		return report
+	}
+
+	if !os.IsNotExist(report.Err) {
+		// Keep the corrupted file for forensics, out of the reader's way.
+		if err := os.Rename(path, path+stateCorruptSuffix); err != nil {
+			report.RepairNotes = append(report.RepairNotes, "failed moving corrupted file: "+err.Error())
+			return report
+		}
+		report.RepairNotes = append(report.RepairNotes, "moved corrupted file to "+path+stateCorruptSuffix)
+	}
+	if report.Unrecoverable {
+		report.RepairNotes = append(report.RepairNotes, "no valid backup to restore, the state is lost")
+		return report
+	}
+
+	if report.BackupErr == nil {
+		b, err := ioutil.ReadFile(path + stateBackupSuffix)
+		if err == nil {
+			version, payload, _ := decodeStateFile(b)
+			err = WriteStateFile(path, version, payload)
+		}
+		if err != nil {
+			report.RepairNotes = append(report.RepairNotes, "failed restoring backup: "+err.Error())
+			return report
+		}
+		report.RepairNotes = append(report.RepairNotes, "restored from backup")
+	}
+
+	report.Repaired = len(report.RepairNotes) > 0
+	return report
+}
@@ -0,0 +1,153 @@
+package lib_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestDeduplicatorMigratesVersion1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dedup")
+	payload, _ := json.Marshal([]string{"1", "2"})
+	if err := lib.WriteStateFile(path, 1, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := lib.NewDeduplicator(path, 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := d.Filter([]*github.Event{{ID: github.String("1")}, {ID: github.String("3")}})
+	if len(events) != 1 || events[0].GetID() != "3" {
+		t.Errorf("expected the migrated IDs to be deduplicated, got %v", events)
+	}
+
+	if report := lib.CheckStateFile(path, false); report.Err != nil || report.Version != 2 {
+		t.Errorf("expected the state file to be rewritten in version 2, got %d: %v", report.Version, report.Err)
+	}
+}
+
+func TestStateFileBackups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "checkpoint")
+	for _, payload := range []string{"first", "second"} {
+		if err := lib.WriteStateFile(path, 1, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A corrupted file isn't rotated over the good backup.
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.WriteStateFile(path, 1, []byte("third")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, payload, err := lib.ReadStateFile(path); err != nil || string(payload) != "first" {
+		t.Errorf("got %q (%v), want the backup", payload, err)
+	}
+
+	report := lib.CheckStateFile(path, true)
+	if !report.Repaired || report.Unrecoverable {
+		t.Errorf("got %+v, want the backup restored", report)
+	}
+	if report := lib.CheckStateFile(path, false); report.Err != nil || report.Size != len("first") {
+		t.Errorf("got %+v after the repair", report)
+	}
+
+	// Without a valid backup the file is moved aside, it isn't repaired.
+	for _, p := range []string{path, path + ".bak"} {
+		if err := ioutil.WriteFile(p, []byte("garbage"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report = lib.CheckStateFile(path, true)
+	if report.Repaired || !report.Unrecoverable {
+		t.Errorf("got %+v, want the state unrecoverable", report)
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("the corrupted file wasn't kept: %v", err)
+	}
+}
+
+func TestStateFileRefusesNewerVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dedup")
+	if err := lib.WriteStateFile(path, 99, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.NewDeduplicator(path, 10, nil); !errors.Is(err, lib.ErrStateFileVersion) {
+		t.Errorf("expected ErrStateFileVersion, got %v", err)
+	}
+
+	dlq := filepath.Join(dir, "dlq.ndjson")
+	if err := ioutil.WriteFile(dlq, []byte(`{"version":99,"sink":"archive","record":{}}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lib.OpenDeadLetterQueue(dlq); !errors.Is(err, lib.ErrStateFileVersion) {
+		t.Errorf("expected ErrStateFileVersion, got %v", err)
+	}
+}
+
+func TestDeadLetterQueueMigratesLetters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dlq.ndjson")
+	legacy := `{"sink":"archive","reason":"unavailable","time":"2020-06-10T12:00:00Z","record":{"id":"1","type":"PushEvent"}}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(legacy+"not json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := lib.OpenDeadLetterQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Close()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || lines[1] != "not json" {
+		t.Fatalf("unexpected queue after migration:\n%s", b)
+	}
+
+	var letter lib.DeadLetter
+	if err := json.Unmarshal([]byte(lines[0]), &letter); err != nil {
+		t.Fatal(err)
+	}
+	if letter.Version != 1 || letter.Record.SchemaVersion != lib.SchemaVersion || letter.Record.Digest == "" {
+		t.Errorf("letter not migrated: %s", lines[0])
+	}
+}
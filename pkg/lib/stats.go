@@ -0,0 +1,174 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	statsWindow    = time.Minute
+	statsBuckets   = 12
+	statsTopRepos  = 10
+	statsNamespace = "github_feed"
+)
+
+type statsBucket struct {
+	start  time.Time
+	types  map[string]int
+	repos  map[string]int
+	bots   int
+	events int
+}
+
+// EventStats exposes precomputed series over a sliding window of a minute in
+// the Prometheus text format, so that a dashboard plots them as is:
+//
+//	github_feed_events_per_second{type}    rate by event type
+//	github_feed_top_repo_events{rank,repo} the 10 busiest repositories
+//	github_feed_bot_ratio                  fraction of events by bots
+//	github_feed_events_total               events seen since the start
+type EventStats struct {
+	// Clock of the sliding window, the system clock if nil.
+	Clock Clock
+
+	bots BotDetector
+
+	mu      sync.Mutex
+	buckets [statsBuckets]statsBucket
+	total   uint64
+}
+
+// NewEventStats uses bots to classify actors, LoginBotDetector if nil.
+func NewEventStats(bots BotDetector) *EventStats {
+	if bots == nil {
+		bots = LoginBotDetector{}
+	}
+
+	return &EventStats{bots: bots}
+}
+
+func (s *EventStats) bucket(now time.Time) *statsBucket {
+	width := statsWindow / statsBuckets
+	start := now.Truncate(width)
+
+	b := &s.buckets[int(start.UnixNano()/int64(width))%statsBuckets]
+	if !b.start.Equal(start) {
+		*b = statsBucket{start: start, types: make(map[string]int), repos: make(map[string]int)}
+	}
+
+	return b
+}
+
+// Observe accounts a batch of events.
+func (s *EventStats) Observe(events []*github.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.bucket(clockOrSystem(s.Clock).Now())
+	for _, ev := range events {
+		b.events++
+		b.types[ev.GetType()]++
+		if repo := ev.GetRepo().GetName(); repo != "" {
+			b.repos[repo]++
+		}
+		if s.bots.IsBot(ev) {
+			b.bots++
+		}
+	}
+
+	s.total += uint64(len(events))
+}
+
+type repoCount struct {
+	repo  string
+	count int
+}
+
+// aggregate sums the buckets of the window.
+func (s *EventStats) aggregate(now time.Time) (types map[string]int, top []repoCount, bots, events int, total uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	types = make(map[string]int)
+	repos := make(map[string]int)
+	for i := range s.buckets {
+		b := &s.buckets[i]
+		if b.start.IsZero() || now.Sub(b.start) >= statsWindow {
+			continue
+		}
+
+		for t, n := range b.types {
+			types[t] += n
+		}
+		for r, n := range b.repos {
+			repos[r] += n
+		}
+		bots += b.bots
+		events += b.events
+	}
+
+	for r, n := range repos {
+		top = append(top, repoCount{r, n})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].count != top[j].count {
+			return top[i].count > top[j].count
+		}
+		return top[i].repo < top[j].repo
+	})
+	if len(top) > statsTopRepos {
+		top = top[:statsTopRepos]
+	}
+
+	return types, top, bots, events, s.total
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes the series in the Prometheus text format.
+func (s *EventStats) WritePrometheus(w io.Writer) {
+	types, top, bots, events, total := s.aggregate(clockOrSystem(s.Clock).Now())
+	seconds := statsWindow.Seconds()
+
+	fmt.Fprintf(w, "# HELP %s_events_per_second Events per second by type over the last %v.\n", statsNamespace, statsWindow)
+	fmt.Fprintf(w, "# TYPE %s_events_per_second gauge\n", statsNamespace)
+	names := make([]string, 0, len(types))
+	for t := range types {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	for _, t := range names {
+		fmt.Fprintf(w, "%s_events_per_second{type=\"%s\"} %g\n", statsNamespace, labelEscaper.Replace(t), float64(types[t])/seconds)
+	}
+
+	fmt.Fprintf(w, "# HELP %s_top_repo_events Events of the %d busiest repositories over the last %v.\n",
+		statsNamespace, statsTopRepos, statsWindow)
+	fmt.Fprintf(w, "# TYPE %s_top_repo_events gauge\n", statsNamespace)
+	for i, r := range top {
+		fmt.Fprintf(w, "%s_top_repo_events{rank=\"%d\",repo=\"%s\"} %d\n", statsNamespace, i+1, labelEscaper.Replace(r.repo), r.count)
+	}
+
+	ratio := 0.0
+	if events > 0 {
+		ratio = float64(bots) / float64(events)
+	}
+	fmt.Fprintf(w, "# HELP %s_bot_ratio Fraction of the events by bots over the last %v.\n", statsNamespace, statsWindow)
+	fmt.Fprintf(w, "# TYPE %s_bot_ratio gauge\n", statsNamespace)
+	fmt.Fprintf(w, "%s_bot_ratio %g\n", statsNamespace, ratio)
+
+	fmt.Fprintf(w, "# HELP %s_events_total Events seen since the start.\n", statsNamespace)
+	fmt.Fprintf(w, "# TYPE %s_events_total counter\n", statsNamespace)
+	fmt.Fprintf(w, "%s_events_total %d\n", statsNamespace, total)
+}
+
+func (s *EventStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.WritePrometheus(w)
+}
@@ -0,0 +1,199 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const defaultStreamQueue = 64
+
+// Stream is a stream of event batches, e.g. the channel returned by
+// NewEventFeed, with combinators returning new streams so that pipelines are
+// composed programmatically with the primitives of the command line (filter
+// expressions, batch policies, splits):
+//
+//	filter, _ := lib.ParseFilter("type=PullRequestEvent")
+//	prs := lib.Stream(events).Filter(filter.Match).Buffer(lib.BatchPolicy{MaxRecords: 100, MaxLatency: time.Second})
+//
+// A combinator consumes its stream in a goroutine until the stream is
+// closed, then closes the streams it returned. Each returned stream buffers
+// a few batches, after which a slow consumer holds back the pipeline.
+// Batches left empty by a combinator are not published.
+type Stream <-chan []*github.Event
+
+// Filter returns the events matching pred, e.g. Filter.Match.
+func (s Stream) Filter(pred func(*github.Event) bool) Stream {
+	return s.Map(func(ev *github.Event) *github.Event {
+		if !pred(ev) {
+			return nil
+		}
+		return ev
+	})
+}
+
+// Map returns the events transformed by fn, those it maps to nil are
+// dropped.
+func (s Stream) Map(fn func(*github.Event) *github.Event) Stream {
+	out := make(chan []*github.Event, defaultStreamQueue)
+
+	go func() {
+		defer close(out)
+
+		for batch := range s {
+			var mapped []*github.Event
+			for _, ev := range batch {
+				if ev = fn(ev); ev != nil {
+					mapped = append(mapped, ev)
+				}
+			}
+
+			if len(mapped) > 0 {
+				out <- mapped
+			}
+		}
+	}()
+
+	return out
+}
+
+// Buffer regroups the events in batches bounded by the policy, as a
+// BatchingSink does: a batch is published once full, or MaxLatency after
+// its first event. The events buffered when the stream is closed are
+// published as a last batch.
+func (s Stream) Buffer(policy BatchPolicy) Stream {
+	out := make(chan []*github.Event, defaultStreamQueue)
+
+	go func() {
+		defer close(out)
+
+		var buf []*github.Event
+		var size int
+		var deadline <-chan time.Time
+		flush := func() {
+			if len(buf) > 0 {
+				out <- buf
+			}
+			buf, size, deadline = nil, 0, nil
+		}
+
+		for {
+			select {
+			case batch, ok := <-s:
+				if !ok {
+					flush()
+					return
+				}
+
+				for _, ev := range batch {
+					if len(buf) == 0 && policy.MaxLatency > 0 {
+						deadline = time.After(policy.MaxLatency)
+					}
+					buf = append(buf, ev)
+					size += eventSize(ev)
+					if policy.full(len(buf), size) {
+						flush()
+					}
+				}
+			case <-deadline:
+				flush()
+			}
+		}
+	}()
+
+	return out
+}
+
+// Throttle publishes at most rate events per second on average, in bursts of
+// up to burst events. Batches are delayed, never split nor dropped: a batch
+// larger than the burst waits for the tokens of all its events.
+func (s Stream) Throttle(rate float64, burst int) Stream {
+	out := make(chan []*github.Event, defaultStreamQueue)
+
+	go func() {
+		defer close(out)
+
+		bucket := newTokenBucket(rate, burst, time.Now())
+		for batch := range s {
+			if wait := bucket.reserveN(time.Now(), len(batch)); wait > 0 {
+				time.Sleep(wait)
+			}
+
+			if len(batch) > 0 {
+				out <- batch
+			}
+		}
+	}()
+
+	return out
+}
+
+// Tee publishes every batch on n streams, e.g. one per sink. A slow consumer
+// of one stream holds back the others once its queue is full.
+func (s Stream) Tee(n int) []Stream {
+	outs := make([]chan []*github.Event, n)
+	streams := make([]Stream, n)
+	for i := range outs {
+		outs[i] = make(chan []*github.Event, defaultStreamQueue)
+		streams[i] = outs[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, out := range outs {
+				close(out)
+			}
+		}()
+
+		for batch := range s {
+			if len(batch) == 0 {
+				continue
+			}
+			for _, out := range outs {
+				out <- batch
+			}
+		}
+	}()
+
+	return streams
+}
+
+// Split routes the events matching pred and the others to two streams, see
+// Split.
+func (s Stream) Split(pred func(*github.Event) bool) (match, rest Stream) {
+	return Split(s, pred, defaultStreamQueue)
+}
+
+// Window regroups the events in tumbling windows of their creation time,
+// aligned on multiples of d, so that replayed archives give the same
+// windows. A window is published once an event of a later window is seen,
+// late events are published in the current window, and the last window when
+// the stream is closed.
+func (s Stream) Window(d time.Duration) Stream {
+	out := make(chan []*github.Event, defaultStreamQueue)
+
+	go func() {
+		defer close(out)
+
+		var window []*github.Event
+		var current time.Time
+		for batch := range s {
+			for _, ev := range batch {
+				if start := ev.GetCreatedAt().Truncate(d); start.After(current) {
+					if len(window) > 0 {
+						out <- window
+						window = nil
+					}
+					current = start
+				}
+				window = append(window, ev)
+			}
+		}
+
+		if len(window) > 0 {
+			out <- window
+		}
+	}()
+
+	return out
+}
@@ -0,0 +1,96 @@
+package lib_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func streamOf(batches ...[]*github.Event) lib.Stream {
+	in := make(chan []*github.Event, len(batches))
+	for _, batch := range batches {
+		in <- batch
+	}
+	close(in)
+	return in
+}
+
+func collect(s lib.Stream) [][]string {
+	var batches [][]string
+	for batch := range s {
+		var ids []string
+		for _, ev := range batch {
+			ids = append(ids, ev.GetID())
+		}
+		batches = append(batches, ids)
+	}
+	return batches
+}
+
+func TestStreamCombinators(t *testing.T) {
+	start := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	var events []*github.Event
+	for i := 0; i < 6; i++ {
+		typ := "PushEvent"
+		if i%2 == 0 {
+			typ = "WatchEvent"
+		}
+		events = append(events, testEvent(fmt.Sprint(i), typ, "", "", start.Add(time.Duration(i)*20*time.Minute), ""))
+	}
+
+	filter, err := lib.ParseFilter("type=PushEvent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushes := streamOf(events[:3], events[3:]).Filter(filter.Match).Buffer(lib.BatchPolicy{MaxRecords: 2})
+	if got := fmt.Sprint(collect(pushes)); got != "[[1 3] [5]]" {
+		t.Errorf("filtered and buffered %s", got)
+	}
+
+	// One event per 20 minutes, late event 1 lands in the current window.
+	late := []*github.Event{events[0], events[2], events[1], events[3], events[4]}
+	if got := fmt.Sprint(collect(streamOf(late).Window(time.Hour))); got != "[[0 2 1] [3 4]]" {
+		t.Errorf("windowed %s", got)
+	}
+
+	renamed := streamOf(events).Map(func(ev *github.Event) *github.Event {
+		if ev.GetType() != "WatchEvent" {
+			return nil
+		}
+		star := *ev
+		star.Type = github.String("StarEvent")
+		return &star
+	})
+	tees := renamed.Tee(2)
+	for i, tee := range tees {
+		batches := 0
+		for batch := range tee {
+			batches++
+			if len(batch) != 3 || batch[0].GetType() != "StarEvent" {
+				t.Errorf("tee %d got %v", i, batch)
+			}
+		}
+		if batches != 1 {
+			t.Errorf("tee %d got %d batches, want 1", i, batches)
+		}
+	}
+}
+
+func TestStreamThrottle(t *testing.T) {
+	var batches [][]*github.Event
+	for i := 0; i < 4; i++ {
+		batches = append(batches, []*github.Event{testEvent(fmt.Sprint(2*i), "PushEvent", "", "", time.Time{}, ""), testEvent(fmt.Sprint(2*i+1), "PushEvent", "", "", time.Time{}, "")})
+	}
+
+	// 8 events at 40 events per second with a burst of 2: 150ms at least.
+	began := time.Now()
+	if got := collect(streamOf(batches...).Throttle(40, 2)); len(got) != 4 {
+		t.Fatalf("got %d batches, want 4", len(got))
+	}
+	if elapsed := time.Since(began); elapsed < 140*time.Millisecond {
+		t.Errorf("throttled stream took %v, want at least 150ms", elapsed)
+	}
+}
@@ -0,0 +1,356 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v32/github"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultSummaryLocale is the locale every other falls back on.
+const DefaultSummaryLocale = "en"
+
+// SummaryCatalog holds the messages of a locale, by key. The keys are the
+// event types, followed by the action for the events having one (e.g.
+// PullRequestEvent.opened, with merged for the closed pull requests which
+// were), falling back on the type alone then on "default". Keys suffixed
+// with #one are preferred when {count} is 1.
+//
+// The messages substitute the placeholders {actor}, {repo}, {type},
+// {action}, {number} (of the issue or pull request), {title}, {ref},
+// {branch} (the ref without refs/heads/), {tag}, {count} (of commits or
+// pages), {member} and {fork}.
+type SummaryCatalog map[string]string
+
+var summaryCatalogs = struct {
+	sync.RWMutex
+	byLocale map[string]SummaryCatalog
+}{byLocale: make(map[string]SummaryCatalog)}
+
+// RegisterSummaryCatalog adds the messages of a catalog to the locale's,
+// replacing those with the same keys, e.g. to translate a new locale or to
+// rephrase some messages of a built-in one.
+func RegisterSummaryCatalog(locale string, catalog SummaryCatalog) {
+	locale = normalizeLocale(locale)
+
+	summaryCatalogs.Lock()
+	defer summaryCatalogs.Unlock()
+
+	merged := make(SummaryCatalog, len(catalog))
+	for k, v := range summaryCatalogs.byLocale[locale] {
+		merged[k] = v
+	}
+	for k, v := range catalog {
+		merged[k] = v
+	}
+	summaryCatalogs.byLocale[locale] = merged
+}
+
+// SummaryLocales returns the locales with a catalog, sorted.
+func SummaryLocales() []string {
+	summaryCatalogs.RLock()
+	defer summaryCatalogs.RUnlock()
+
+	locales := make([]string, 0, len(summaryCatalogs.byLocale))
+	for locale := range summaryCatalogs.byLocale {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// LoadSummaryCatalog registers the catalogs of a YAML file mapping locales
+// to their messages, e.g.
+//
+//	pt-br:
+//	  PushEvent: "{actor} enviou {count} commits para {branch} em {repo}"
+//	  PushEvent#one: "{actor} enviou um commit para {branch} em {repo}"
+func LoadSummaryCatalog(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var catalogs map[string]SummaryCatalog
+	if err := yaml.UnmarshalStrict(data, &catalogs); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for locale, catalog := range catalogs {
+		RegisterSummaryCatalog(locale, catalog)
+	}
+	return nil
+}
+
+// normalizeLocale lowercases a locale and drops its encoding, e.g. fr_CA.UTF-8
+// is fr-ca.
+func normalizeLocale(locale string) string {
+	if i := strings.IndexAny(locale, ".@"); i >= 0 {
+		locale = locale[:i]
+	}
+	return strings.ToLower(strings.Replace(locale, "_", "-", -1))
+}
+
+// Summarizer renders events as one-line human-readable descriptions, e.g.
+// "alice opened PR #42 in foo/bar", for the sinks notifying people (chat,
+// email, feeds) so that they share the phrasing and its translations.
+type Summarizer struct {
+	// Catalogs looked up in order, the locale's then its language's, then
+	// the default locale's.
+	catalogs []SummaryCatalog
+}
+
+// NewSummarizer returns a summarizer in the given locale (e.g. fr-CA, or
+// fr_CA.UTF-8 as in LANG), whose missing messages fall back on the locale's
+// language then on DefaultSummaryLocale. It fails if neither the locale nor
+// its language have a catalog.
+func NewSummarizer(locale string) (*Summarizer, error) {
+	locale = normalizeLocale(locale)
+	chain := []string{locale}
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		chain = append(chain, locale[:i])
+	}
+
+	summaryCatalogs.RLock()
+	defer summaryCatalogs.RUnlock()
+
+	s := &Summarizer{}
+	for _, l := range chain {
+		if catalog, ok := summaryCatalogs.byLocale[l]; ok {
+			s.catalogs = append(s.catalogs, catalog)
+		}
+	}
+	if len(s.catalogs) == 0 && locale != "" {
+		return nil, fmt.Errorf("unknown locale '%s', available: %s", locale, strings.Join(SummaryLocales(), ", "))
+	}
+	s.catalogs = append(s.catalogs, summaryCatalogs.byLocale[DefaultSummaryLocale])
+	return s, nil
+}
+
+func (s *Summarizer) lookup(keys ...string) string {
+	for _, catalog := range s.catalogs {
+		for _, key := range keys {
+			if msg, ok := catalog[key]; ok {
+				return msg
+			}
+		}
+	}
+	return ""
+}
+
+// Summarize returns the description of an event.
+func (s *Summarizer) Summarize(ev *github.Event) string {
+	v := &eventView{ev: ev}
+	args := summaryArgs(v)
+
+	typ, action := ev.GetType(), args["{action}"]
+	var keys []string
+	if action != "" {
+		keys = append(keys, typ+"."+action)
+	}
+	keys = append(keys, typ, "default")
+	if args["{count}"] == "1" {
+		one := make([]string, 0, 2*len(keys))
+		for _, key := range keys {
+			one = append(one, key+"#one", key)
+		}
+		keys = one
+	}
+
+	pairs := make([]string, 0, 2*len(args))
+	for k, v := range args {
+		pairs = append(pairs, k, v)
+	}
+	return strings.NewReplacer(pairs...).Replace(s.lookup(keys...))
+}
+
+// summaryArgs returns the values of the placeholders of an event's
+// messages.
+func summaryArgs(v *eventView) map[string]string {
+	ev := v.ev
+	args := map[string]string{
+		"{actor}": ev.GetActor().GetLogin(),
+		"{repo}":  ev.GetRepo().GetName(),
+		"{type}":  ev.GetType(),
+	}
+	if actions := payloadAction(v); len(actions) > 0 {
+		args["{action}"] = actions[0]
+	}
+	if titles := payloadTitle(v); len(titles) > 0 {
+		args["{title}"] = titles[0]
+	}
+
+	switch p := v.Payload().(type) {
+	case *github.PushEvent:
+		args["{ref}"] = p.GetRef()
+		args["{branch}"] = strings.TrimPrefix(p.GetRef(), "refs/heads/")
+		count := p.GetSize()
+		if count == 0 {
+			count = len(p.Commits)
+		}
+		args["{count}"] = strconv.Itoa(count)
+	case *github.PullRequestEvent:
+		args["{number}"] = strconv.Itoa(p.GetNumber())
+		if p.GetAction() == "closed" && p.GetPullRequest().GetMerged() {
+			args["{action}"] = "merged"
+		}
+	case *github.IssuesEvent:
+		args["{number}"] = strconv.Itoa(p.GetIssue().GetNumber())
+	case *github.IssueCommentEvent:
+		args["{number}"] = strconv.Itoa(p.GetIssue().GetNumber())
+	case *github.PullRequestReviewEvent:
+		args["{number}"] = strconv.Itoa(p.GetPullRequest().GetNumber())
+	case *github.PullRequestReviewCommentEvent:
+		args["{number}"] = strconv.Itoa(p.GetPullRequest().GetNumber())
+	case *github.CreateEvent:
+		args["{action}"] = p.GetRefType()
+		args["{ref}"], args["{branch}"], args["{tag}"] = p.GetRef(), p.GetRef(), p.GetRef()
+	case *github.DeleteEvent:
+		args["{action}"] = p.GetRefType()
+		args["{ref}"], args["{branch}"], args["{tag}"] = p.GetRef(), p.GetRef(), p.GetRef()
+	case *github.ReleaseEvent:
+		args["{tag}"] = p.GetRelease().GetTagName()
+	case *github.MemberEvent:
+		args["{member}"] = p.GetMember().GetLogin()
+	case *github.ForkEvent:
+		args["{fork}"] = p.GetForkee().GetFullName()
+	case *github.GollumEvent:
+		args["{count}"] = strconv.Itoa(len(p.Pages))
+	}
+	return args
+}
+
+func init() {
+	RegisterSummaryCatalog("en", SummaryCatalog{
+		"default":                       "{actor}: {type} in {repo}",
+		"PushEvent":                     "{actor} pushed {count} commits to {branch} in {repo}",
+		"PushEvent#one":                 "{actor} pushed a commit to {branch} in {repo}",
+		"PullRequestEvent":              "{actor} updated PR #{number} in {repo}",
+		"PullRequestEvent.opened":       "{actor} opened PR #{number} in {repo}",
+		"PullRequestEvent.closed":       "{actor} closed PR #{number} in {repo}",
+		"PullRequestEvent.merged":       "{actor} merged PR #{number} in {repo}",
+		"PullRequestEvent.reopened":     "{actor} reopened PR #{number} in {repo}",
+		"PullRequestReviewEvent":        "{actor} reviewed PR #{number} in {repo}",
+		"PullRequestReviewCommentEvent": "{actor} commented on PR #{number} in {repo}",
+		"IssuesEvent":                   "{actor} updated issue #{number} in {repo}",
+		"IssuesEvent.opened":            "{actor} opened issue #{number} in {repo}",
+		"IssuesEvent.closed":            "{actor} closed issue #{number} in {repo}",
+		"IssuesEvent.reopened":          "{actor} reopened issue #{number} in {repo}",
+		"IssueCommentEvent":             "{actor} commented on #{number} in {repo}",
+		"CommitCommentEvent":            "{actor} commented on a commit in {repo}",
+		"CreateEvent":                   "{actor} created {ref} in {repo}",
+		"CreateEvent.repository":        "{actor} created the repository {repo}",
+		"CreateEvent.branch":            "{actor} created the branch {branch} in {repo}",
+		"CreateEvent.tag":               "{actor} created the tag {tag} in {repo}",
+		"DeleteEvent":                   "{actor} deleted {ref} in {repo}",
+		"DeleteEvent.branch":            "{actor} deleted the branch {branch} in {repo}",
+		"DeleteEvent.tag":               "{actor} deleted the tag {tag} in {repo}",
+		"ReleaseEvent":                  "{actor} published {tag} of {repo}",
+		"WatchEvent":                    "{actor} starred {repo}",
+		"ForkEvent":                     "{actor} forked {repo} to {fork}",
+		"MemberEvent":                   "{actor} added {member} to {repo}",
+		"PublicEvent":                   "{actor} made {repo} public",
+		"GollumEvent":                   "{actor} edited {count} wiki pages of {repo}",
+		"GollumEvent#one":               "{actor} edited a wiki page of {repo}",
+	})
+	RegisterSummaryCatalog("fr", SummaryCatalog{
+		"default":                       "{actor} : {type} dans {repo}",
+		"PushEvent":                     "{actor} a poussé {count} commits sur {branch} dans {repo}",
+		"PushEvent#one":                 "{actor} a poussé un commit sur {branch} dans {repo}",
+		"PullRequestEvent":              "{actor} a mis à jour la PR #{number} dans {repo}",
+		"PullRequestEvent.opened":       "{actor} a ouvert la PR #{number} dans {repo}",
+		"PullRequestEvent.closed":       "{actor} a fermé la PR #{number} dans {repo}",
+		"PullRequestEvent.merged":       "{actor} a fusionné la PR #{number} dans {repo}",
+		"PullRequestEvent.reopened":     "{actor} a rouvert la PR #{number} dans {repo}",
+		"PullRequestReviewEvent":        "{actor} a relu la PR #{number} dans {repo}",
+		"PullRequestReviewCommentEvent": "{actor} a commenté la PR #{number} dans {repo}",
+		"IssuesEvent":                   "{actor} a mis à jour l'issue #{number} dans {repo}",
+		"IssuesEvent.opened":            "{actor} a ouvert l'issue #{number} dans {repo}",
+		"IssuesEvent.closed":            "{actor} a fermé l'issue #{number} dans {repo}",
+		"IssuesEvent.reopened":          "{actor} a rouvert l'issue #{number} dans {repo}",
+		"IssueCommentEvent":             "{actor} a commenté #{number} dans {repo}",
+		"CommitCommentEvent":            "{actor} a commenté un commit dans {repo}",
+		"CreateEvent":                   "{actor} a créé {ref} dans {repo}",
+		"CreateEvent.repository":        "{actor} a créé le dépôt {repo}",
+		"CreateEvent.branch":            "{actor} a créé la branche {branch} dans {repo}",
+		"CreateEvent.tag":               "{actor} a créé le tag {tag} dans {repo}",
+		"DeleteEvent":                   "{actor} a supprimé {ref} dans {repo}",
+		"DeleteEvent.branch":            "{actor} a supprimé la branche {branch} dans {repo}",
+		"DeleteEvent.tag":               "{actor} a supprimé le tag {tag} dans {repo}",
+		"ReleaseEvent":                  "{actor} a publié {tag} de {repo}",
+		"WatchEvent":                    "{actor} a ajouté une étoile à {repo}",
+		"ForkEvent":                     "{actor} a forké {repo} vers {fork}",
+		"MemberEvent":                   "{actor} a ajouté {member} à {repo}",
+		"PublicEvent":                   "{actor} a rendu {repo} public",
+		"GollumEvent":                   "{actor} a modifié {count} pages du wiki de {repo}",
+		"GollumEvent#one":               "{actor} a modifié une page du wiki de {repo}",
+	})
+	RegisterSummaryCatalog("de", SummaryCatalog{
+		"default":                       "{actor}: {type} in {repo}",
+		"PushEvent":                     "{actor} hat {count} Commits nach {branch} in {repo} gepusht",
+		"PushEvent#one":                 "{actor} hat einen Commit nach {branch} in {repo} gepusht",
+		"PullRequestEvent":              "{actor} hat PR #{number} in {repo} aktualisiert",
+		"PullRequestEvent.opened":       "{actor} hat PR #{number} in {repo} eröffnet",
+		"PullRequestEvent.closed":       "{actor} hat PR #{number} in {repo} geschlossen",
+		"PullRequestEvent.merged":       "{actor} hat PR #{number} in {repo} gemergt",
+		"PullRequestEvent.reopened":     "{actor} hat PR #{number} in {repo} wieder eröffnet",
+		"PullRequestReviewEvent":        "{actor} hat PR #{number} in {repo} geprüft",
+		"PullRequestReviewCommentEvent": "{actor} hat PR #{number} in {repo} kommentiert",
+		"IssuesEvent":                   "{actor} hat Issue #{number} in {repo} aktualisiert",
+		"IssuesEvent.opened":            "{actor} hat Issue #{number} in {repo} eröffnet",
+		"IssuesEvent.closed":            "{actor} hat Issue #{number} in {repo} geschlossen",
+		"IssuesEvent.reopened":          "{actor} hat Issue #{number} in {repo} wieder eröffnet",
+		"IssueCommentEvent":             "{actor} hat #{number} in {repo} kommentiert",
+		"CommitCommentEvent":            "{actor} hat einen Commit in {repo} kommentiert",
+		"CreateEvent":                   "{actor} hat {ref} in {repo} erstellt",
+		"CreateEvent.repository":        "{actor} hat das Repository {repo} erstellt",
+		"CreateEvent.branch":            "{actor} hat den Branch {branch} in {repo} erstellt",
+		"CreateEvent.tag":               "{actor} hat den Tag {tag} in {repo} erstellt",
+		"DeleteEvent":                   "{actor} hat {ref} in {repo} gelöscht",
+		"DeleteEvent.branch":            "{actor} hat den Branch {branch} in {repo} gelöscht",
+		"DeleteEvent.tag":               "{actor} hat den Tag {tag} in {repo} gelöscht",
+		"ReleaseEvent":                  "{actor} hat {tag} von {repo} veröffentlicht",
+		"WatchEvent":                    "{actor} hat {repo} einen Stern gegeben",
+		"ForkEvent":                     "{actor} hat {repo} nach {fork} geforkt",
+		"MemberEvent":                   "{actor} hat {member} zu {repo} hinzugefügt",
+		"PublicEvent":                   "{actor} hat {repo} veröffentlicht",
+		"GollumEvent":                   "{actor} hat {count} Wiki-Seiten von {repo} bearbeitet",
+		"GollumEvent#one":               "{actor} hat eine Wiki-Seite von {repo} bearbeitet",
+	})
+	RegisterSummaryCatalog("es", SummaryCatalog{
+		"default":                       "{actor}: {type} en {repo}",
+		"PushEvent":                     "{actor} subió {count} commits a {branch} en {repo}",
+		"PushEvent#one":                 "{actor} subió un commit a {branch} en {repo}",
+		"PullRequestEvent":              "{actor} actualizó el PR #{number} en {repo}",
+		"PullRequestEvent.opened":       "{actor} abrió el PR #{number} en {repo}",
+		"PullRequestEvent.closed":       "{actor} cerró el PR #{number} en {repo}",
+		"PullRequestEvent.merged":       "{actor} fusionó el PR #{number} en {repo}",
+		"PullRequestEvent.reopened":     "{actor} reabrió el PR #{number} en {repo}",
+		"PullRequestReviewEvent":        "{actor} revisó el PR #{number} en {repo}",
+		"PullRequestReviewCommentEvent": "{actor} comentó el PR #{number} en {repo}",
+		"IssuesEvent":                   "{actor} actualizó el issue #{number} en {repo}",
+		"IssuesEvent.opened":            "{actor} abrió el issue #{number} en {repo}",
+		"IssuesEvent.closed":            "{actor} cerró el issue #{number} en {repo}",
+		"IssuesEvent.reopened":          "{actor} reabrió el issue #{number} en {repo}",
+		"IssueCommentEvent":             "{actor} comentó #{number} en {repo}",
+		"CommitCommentEvent":            "{actor} comentó un commit en {repo}",
+		"CreateEvent":                   "{actor} creó {ref} en {repo}",
+		"CreateEvent.repository":        "{actor} creó el repositorio {repo}",
+		"CreateEvent.branch":            "{actor} creó la rama {branch} en {repo}",
+		"CreateEvent.tag":               "{actor} creó el tag {tag} en {repo}",
+		"DeleteEvent":                   "{actor} eliminó {ref} en {repo}",
+		"DeleteEvent.branch":            "{actor} eliminó la rama {branch} en {repo}",
+		"DeleteEvent.tag":               "{actor} eliminó el tag {tag} en {repo}",
+		"ReleaseEvent":                  "{actor} publicó {tag} de {repo}",
+		"WatchEvent":                    "{actor} marcó {repo} con una estrella",
+		"ForkEvent":                     "{actor} hizo un fork de {repo} en {fork}",
+		"MemberEvent":                   "{actor} añadió a {member} a {repo}",
+		"PublicEvent":                   "{actor} hizo público {repo}",
+		"GollumEvent":                   "{actor} editó {count} páginas de la wiki de {repo}",
+		"GollumEvent#one":               "{actor} editó una página de la wiki de {repo}",
+	})
+}
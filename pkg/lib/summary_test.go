@@ -0,0 +1,97 @@
+package lib_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestSummarizer(t *testing.T) {
+	en, err := lib.NewSummarizer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr, err := lib.NewSummarizer("fr_CA.UTF-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		ev     *github.Event
+		en, fr string
+	}{
+		{
+			testEvent("", "PullRequestEvent", "alice", "foo/bar", time.Time{}, `{"action":"opened","number":42,"pull_request":{"number":42}}`),
+			"alice opened PR #42 in foo/bar", "alice a ouvert la PR #42 dans foo/bar",
+		},
+		{
+			testEvent("", "PullRequestEvent", "alice", "foo/bar", time.Time{}, `{"action":"closed","number":42,"pull_request":{"merged":true}}`),
+			"alice merged PR #42 in foo/bar", "alice a fusionné la PR #42 dans foo/bar",
+		},
+		{
+			testEvent("", "PullRequestEvent", "alice", "foo/bar", time.Time{}, `{"action":"labeled","number":42}`),
+			"alice updated PR #42 in foo/bar", "alice a mis à jour la PR #42 dans foo/bar",
+		},
+		{
+			testEvent("", "PushEvent", "alice", "foo/bar", time.Time{}, `{"ref":"refs/heads/main","size":3}`),
+			"alice pushed 3 commits to main in foo/bar", "alice a poussé 3 commits sur main dans foo/bar",
+		},
+		{
+			testEvent("", "PushEvent", "alice", "foo/bar", time.Time{}, `{"ref":"refs/heads/main","size":1}`),
+			"alice pushed a commit to main in foo/bar", "alice a poussé un commit sur main dans foo/bar",
+		},
+		{
+			testEvent("", "CreateEvent", "alice", "foo/bar", time.Time{}, `{"ref":"v1.0","ref_type":"tag"}`),
+			"alice created the tag v1.0 in foo/bar", "alice a créé le tag v1.0 dans foo/bar",
+		},
+		{
+			testEvent("", "SponsorshipEvent", "alice", "foo/bar", time.Time{}, `{}`),
+			"alice: SponsorshipEvent in foo/bar", "alice : SponsorshipEvent dans foo/bar",
+		},
+	} {
+		if got := en.Summarize(c.ev); got != c.en {
+			t.Errorf("got %q, want %q", got, c.en)
+		}
+		if got := fr.Summarize(c.ev); got != c.fr {
+			t.Errorf("got %q, want %q", got, c.fr)
+		}
+	}
+
+	if _, err := lib.NewSummarizer("tlh"); err == nil {
+		t.Error("expected an error for a locale without catalog")
+	}
+}
+
+func TestLoadSummaryCatalog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "catalog.yaml")
+	catalog := "pt-br:\n  WatchEvent: \"{actor} favoritou {repo}\"\n"
+	if err := ioutil.WriteFile(path, []byte(catalog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.LoadSummaryCatalog(path); err != nil {
+		t.Fatal(err)
+	}
+
+	pt, err := lib.NewSummarizer("pt-BR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pt.Summarize(testEvent("", "WatchEvent", "alice", "foo/bar", time.Time{}, `{"action":"started"}`)); got != "alice favoritou foo/bar" {
+		t.Errorf("got %q", got)
+	}
+	// Missing messages fall back on English.
+	if got := pt.Summarize(testEvent("", "PublicEvent", "alice", "foo/bar", time.Time{}, `{}`)); got != "alice made foo/bar public" {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// API responses kept by a feed, see EventFeed.RecentResponses.
+	recentResponses = 20
+	// Bytes of their bodies kept, the events pages weigh ~100KB.
+	recentResponseBody = 16 << 10
+)
+
+// RecentLogs keeps the last lines written to it, e.g. the logs of the
+// process for the support bundles, next to their usual output:
+//
+//	log.SetOutput(io.MultiWriter(os.Stderr, recent))
+//
+// It serves them as text over HTTP.
+type RecentLogs struct {
+	mu    sync.Mutex
+	lines []string
+	// Position of the oldest line once full.
+	next int
+	max  int
+}
+
+// NewRecentLogs keeps the last n lines.
+func NewRecentLogs(n int) *RecentLogs {
+	return &RecentLogs{max: n}
+}
+
+func (l *RecentLogs) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		if len(l.lines) < l.max {
+			l.lines = append(l.lines, line)
+			continue
+		}
+		l.lines[l.next] = line
+		l.next = (l.next + 1) % l.max
+	}
+	return len(p), nil
+}
+
+// Lines returns the lines kept, oldest first.
+func (l *RecentLogs) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lines := make([]string, 0, len(l.lines))
+	lines = append(lines, l.lines[l.next:]...)
+	return append(lines, l.lines[:l.next]...)
+}
+
+func (l *RecentLogs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range l.Lines() {
+		io.WriteString(w, line+"\n")
+	}
+}
+
+// RecordedResponse is an API response received by a feed, with the start of
+// its body, redacted.
+type RecordedResponse struct {
+	Time      time.Time   `json:"time"`
+	Method    string      `json:"method"`
+	URL       string      `json:"url"`
+	Subsystem string      `json:"subsystem"`
+	Status    int         `json:"status"`
+	Header    http.Header `json:"header"`
+	Body      string      `json:"body"`
+	// Set when the body was longer than kept.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type recordedResponse struct {
+	RecordedResponse
+	body bytes.Buffer
+}
+
+// responseRecorder keeps the last API responses sent through it, see
+// EventFeed.RecentResponses.
+type responseRecorder struct {
+	base http.RoundTripper
+
+	mu        sync.Mutex
+	responses []*recordedResponse
+	next      int
+}
+
+func newResponseRecorder(base http.RoundTripper) *responseRecorder {
+	return &responseRecorder{base: base}
+}
+
+func (t *responseRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	r := &recordedResponse{RecordedResponse: RecordedResponse{
+		Time:      time.Now(),
+		Method:    req.Method,
+		URL:       req.URL.String(),
+		Subsystem: requestSubsystem(req.Context()),
+		Status:    resp.StatusCode,
+		Header:    resp.Header.Clone(),
+	}}
+	r.Header.Del("Set-Cookie")
+
+	t.mu.Lock()
+	if len(t.responses) < recentResponses {
+		t.responses = append(t.responses, r)
+	} else {
+		t.responses[t.next] = r
+		t.next = (t.next + 1) % recentResponses
+	}
+	t.mu.Unlock()
+
+	resp.Body = &recordingBody{ReadCloser: resp.Body, recorder: t, r: r}
+	return resp, nil
+}
+
+// recordingBody copies the start of a body as it is read.
+type recordingBody struct {
+	io.ReadCloser
+	recorder *responseRecorder
+	r        *recordedResponse
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.recorder.mu.Lock()
+		if left := recentResponseBody - b.r.body.Len(); left < n {
+			b.r.body.Write(p[:left])
+			b.r.Truncated = true
+		} else {
+			b.r.body.Write(p[:n])
+		}
+		b.recorder.mu.Unlock()
+	}
+	return n, err
+}
+
+// recent returns the responses kept, oldest first.
+func (t *responseRecorder) recent() []RecordedResponse {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	responses := make([]RecordedResponse, 0, len(t.responses))
+	for i := range t.responses {
+		r := t.responses[(t.next+i)%len(t.responses)]
+		recorded := r.RecordedResponse
+		recorded.URL = Redact(recorded.URL)
+		recorded.Body = Redact(r.body.String())
+		responses = append(responses, recorded)
+	}
+	return responses
+}
+
+// RecentResponses returns the last 20 responses of the GitHub API received
+// by the feed (those served by the cache aren't), oldest first, with the
+// first 16KB of their bodies, e.g. to see what GitHub answered while the
+// feed misbehaved.
+func (f *EventFeed) RecentResponses() []RecordedResponse {
+	return f.responses.recent()
+}
+
+// RecentResponsesHandler serves the RecentResponses of the feed as JSON.
+func (f *EventFeed) RecentResponsesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(f.RecentResponses())
+	})
+}
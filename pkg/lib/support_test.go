@@ -0,0 +1,60 @@
+package lib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestRecentLogs(t *testing.T) {
+	logs := lib.NewRecentLogs(3)
+	logs.Write([]byte("one\n"))
+	logs.Write([]byte("two\nthree\n"))
+	logs.Write([]byte("four\n"))
+	if got := strings.Join(logs.Lines(), ","); got != "two,three,four" {
+		t.Errorf("got lines %s, want the last 3", got)
+	}
+
+	rec := httptest.NewRecorder()
+	logs.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/logs", nil))
+	if rec.Body.String() != "two\nthree\nfour\n" {
+		t.Errorf("served %q", rec.Body.String())
+	}
+}
+
+func TestRecentResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Write([]byte(`[{"id":"1","type":"PushEvent","payload":{"token":"ghp_aaaaaaaaaaaaaaaaaaaaaaaa"}}]`))
+	}))
+	defer server.Close()
+
+	feed, _, err := lib.NewEventFeed(context.Background(), &lib.Config{BaseURL: server.URL, AuthToken: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := lib.WithSubsystem(context.Background(), lib.SubsystemPoller)
+	if _, _, err := feed.Client().Activity.ListEvents(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	responses := feed.RecentResponses()
+	if len(responses) != 1 {
+		t.Fatalf("got %d responses, want 1", len(responses))
+	}
+	r := responses[0]
+	if r.Status != http.StatusOK || r.Subsystem != lib.SubsystemPoller || !strings.HasSuffix(r.URL, "/events") {
+		t.Errorf("got response %+v", r)
+	}
+	if !strings.Contains(r.Body, `"type":"PushEvent"`) || strings.Contains(r.Body, "ghp_") {
+		t.Errorf("got body %s, want it redacted", r.Body)
+	}
+	if r.Header.Get("Set-Cookie") != "" {
+		t.Errorf("got Set-Cookie %s, want it dropped", r.Header.Get("Set-Cookie"))
+	}
+}
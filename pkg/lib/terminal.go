@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// ANSI escape sequences of the terminal renderer.
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiRed     = "\x1b[31m"
+	ansiGreen   = "\x1b[32m"
+	ansiYellow  = "\x1b[33m"
+	ansiBlue    = "\x1b[34m"
+	ansiMagenta = "\x1b[35m"
+	ansiCyan    = "\x1b[36m"
+	ansiGray    = "\x1b[90m"
+)
+
+// terminalStyle is how an event type is rendered: an icon (an emoji two
+// columns wide), a short label and the color of the label.
+type terminalStyle struct {
+	icon, label, color string
+}
+
+// Width of the label column, that of the longest label.
+const terminalLabelWidth = 14
+
+var terminalStyles = map[string]terminalStyle{
+	"PushEvent":                     {"🚀", "push", ansiGreen},
+	"PullRequestEvent":              {"🔀", "pull request", ansiMagenta},
+	"PullRequestReviewEvent":        {"👀", "review", ansiMagenta},
+	"PullRequestReviewCommentEvent": {"💬", "review comment", ansiCyan},
+	"IssuesEvent":                   {"🐛", "issue", ansiYellow},
+	"IssueCommentEvent":             {"💬", "comment", ansiCyan},
+	"CommitCommentEvent":            {"💬", "commit comment", ansiCyan},
+	"CreateEvent":                   {"🌱", "create", ansiBlue},
+	"DeleteEvent":                   {"🔥", "delete", ansiRed},
+	"ReleaseEvent":                  {"📦", "release", ansiGreen + ansiBold},
+	"WatchEvent":                    {"⭐", "star", ansiYellow + ansiBold},
+	"ForkEvent":                     {"🍴", "fork", ansiBlue},
+	"MemberEvent":                   {"👥", "member", ansiBlue},
+	"PublicEvent":                   {"📢", "public", ansiGreen},
+	"GollumEvent":                   {"📝", "wiki", ansiBlue},
+}
+
+var defaultTerminalStyle = terminalStyle{"📌", "", ""}
+
+// TerminalRenderer writes events as aligned lines for people watching the
+// feed in a terminal, e.g. in demos, rather than for programs: the time, an
+// icon and a label per event type, and the event's summary.
+//
+//	15:04:05 🔀 pull request   alice opened PR #42 in foo/bar
+type TerminalRenderer struct {
+	w          io.Writer
+	summarizer *Summarizer
+	// Colors the labels with ANSI escape sequences.
+	color bool
+}
+
+// NewTerminalRenderer renders on w the summaries of summarizer, colored if
+// color is set (e.g. unless w isn't a terminal or NO_COLOR is set).
+func NewTerminalRenderer(w io.Writer, summarizer *Summarizer, color bool) *TerminalRenderer {
+	return &TerminalRenderer{w: w, summarizer: summarizer, color: color}
+}
+
+// Render writes the line of an event.
+func (r *TerminalRenderer) Render(ev *github.Event) error {
+	style, ok := terminalStyles[ev.GetType()]
+	if !ok {
+		style = defaultTerminalStyle
+		style.label = strings.TrimSuffix(ev.GetType(), "Event")
+	}
+
+	// Padded before being colored, the escape sequences have no width.
+	label := fmt.Sprintf("%-*s", terminalLabelWidth, style.label)
+	clock := ev.GetCreatedAt().Local().Format("15:04:05")
+	if r.color {
+		clock = ansiGray + clock + ansiReset
+		if style.color != "" {
+			label = style.color + label + ansiReset
+		}
+	}
+
+	_, err := fmt.Fprintf(r.w, "%s %s %s %s\n", clock, style.icon, label, r.summarizer.Summarize(ev))
+	return err
+}
@@ -0,0 +1,40 @@
+package lib_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestTerminalRenderer(t *testing.T) {
+	summarizer, err := lib.NewSummarizer("en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2020, 8, 20, 15, 4, 5, 0, time.Local)
+	pr := testEvent("", "PullRequestEvent", "alice", "foo/bar", time.Time{}, `{"action":"opened","number":42}`)
+	pr.CreatedAt = &created
+	other := testEvent("", "SponsorshipEvent", "alice", "foo/bar", time.Time{}, `{}`)
+	other.CreatedAt = &created
+
+	var buf bytes.Buffer
+	r := lib.NewTerminalRenderer(&buf, summarizer, false)
+	r.Render(pr)
+	r.Render(other)
+
+	expected := "15:04:05 🔀 pull request   alice opened PR #42 in foo/bar\n" +
+		"15:04:05 📌 Sponsorship    alice: SponsorshipEvent in foo/bar\n"
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+
+	buf.Reset()
+	lib.NewTerminalRenderer(&buf, summarizer, true).Render(pr)
+	if !strings.Contains(buf.String(), "\x1b[35mpull request  \x1b[0m") {
+		t.Errorf("expected the padded label colored, got %q", buf.String())
+	}
+}
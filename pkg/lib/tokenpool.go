@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Rate limit headers of the API responses.
+const (
+	xRateLimitRemainingHeader = "X-RateLimit-Remaining"
+	xRateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// TokenStats are the usage of a token of the pool of a feed, see
+// Config.AuthTokens.
+type TokenStats struct {
+	// Position of the token in the pool, from 1, which identifies it in
+	// the logs and the metrics.
+	Token       int
+	Requests    uint64
+	RateLimited uint64
+	// Rate limit of the token's last response, -1 before the first.
+	Remaining int
+	Reset     time.Time
+	// Set while the token's rate limit is exhausted, until Reset.
+	Benched bool
+}
+
+type pooledToken struct {
+	token string
+	stats TokenStats
+}
+
+// tokenPool authenticates the requests with the token of the pool with the
+// most requests left, those never used first, and benches the tokens which
+// exhausted their rate limit until it resets.
+type tokenPool struct {
+	base       http.RoundTripper
+	clock      Clock
+	accounting *requestAccounting
+
+	mu     sync.Mutex
+	tokens []*pooledToken
+	// Round-robin among the tokens with as many requests left.
+	next int
+}
+
+func newTokenPool(base http.RoundTripper, clock Clock, tokens []string) *tokenPool {
+	p := &tokenPool{base: base, clock: clock}
+	for i, token := range tokens {
+		p.tokens = append(p.tokens, &pooledToken{token: token, stats: TokenStats{Token: i + 1, Remaining: -1}})
+	}
+	return p
+}
+
+// pick returns the token to use, excluding those already tried. Once they
+// are all benched, the one resetting first is returned, and false.
+func (p *tokenPool) pick(tried map[*pooledToken]bool) (*pooledToken, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	var best, earliest *pooledToken
+	for i := range p.tokens {
+		t := p.tokens[(p.next+i)%len(p.tokens)]
+		if t.stats.Benched && !now.Before(t.stats.Reset) {
+			// Replenished, as if never used.
+			t.stats.Benched, t.stats.Remaining = false, -1
+			log.Printf("Token %d rate limit reset, back in the pool", t.stats.Token)
+		}
+		if tried[t] {
+			continue
+		}
+		if t.stats.Benched {
+			if earliest == nil || t.stats.Reset.Before(earliest.stats.Reset) {
+				earliest = t
+			}
+			continue
+		}
+		if best == nil || remaining(t) > remaining(best) {
+			best = t
+		}
+	}
+
+	available := best != nil
+	if !available {
+		best = earliest
+	}
+	best.stats.Requests++
+	p.next = (p.next + 1) % len(p.tokens)
+	return best, available
+}
+
+// remaining ranks the tokens, those whose rate limit is unknown first.
+func remaining(t *pooledToken) int {
+	if t.stats.Remaining < 0 {
+		return int(^uint(0) >> 1)
+	}
+	return t.stats.Remaining
+}
+
+// observe records the rate limit reported by the response, and tells
+// whether the token exhausted it.
+func (p *tokenPool) observe(t *pooledToken, r *http.Response) bool {
+	remaining, err := strconv.Atoi(r.Header.Get(xRateLimitRemainingHeader))
+	if err != nil {
+		return false
+	}
+	reset, _ := strconv.ParseInt(r.Header.Get(xRateLimitResetHeader), 10, 64)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t.stats.Remaining = remaining
+	t.stats.Reset = rateLimitReset(time.Unix(reset, 0), r, p.clock.Now())
+
+	exhausted := remaining == 0 && (r.StatusCode == http.StatusForbidden || r.StatusCode == http.StatusTooManyRequests)
+	if exhausted {
+		t.stats.RateLimited++
+		if !t.stats.Benched {
+			t.stats.Benched = true
+			log.Printf("Token %d exhausted its rate limit, benched until %v", t.stats.Token, t.stats.Reset.Format(time.RFC3339))
+		}
+	}
+	return exhausted
+}
+
+// RoundTrip retries the requests rejected by the rate limit of their token
+// with the others, as long as their body can be replayed. Once the tokens
+// are all benched, the rate limit error reaches the caller, which waits for
+// the reset.
+func (p *tokenPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := make(map[*pooledToken]bool, len(p.tokens))
+	for {
+		t, available := p.pick(tried)
+		tried[t] = true
+
+		// RoundTrippers must not modify the caller's request.
+		r := req.Clone(req.Context())
+		if req.Body != nil && len(tried) > 1 {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+		r.Header.Set("Authorization", "Bearer "+t.token)
+
+		resp, err := p.base.RoundTrip(r)
+		if p.accounting != nil {
+			p.accounting.record(t.stats.Token, r, resp)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		retry := available && len(tried) < len(p.tokens) && (req.Body == nil || req.GetBody != nil)
+		if !p.observe(t, resp) || !retry {
+			return resp, nil
+		}
+		resp.Body.Close()
+	}
+}
+
+// stats returns the usage of the tokens, in the order of the pool.
+func (p *tokenPool) stats() []TokenStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]TokenStats, len(p.tokens))
+	for i, t := range p.tokens {
+		stats[i] = t.stats
+	}
+	return stats
+}
+
+// TokenStats returns the usage of the tokens of Config.AuthTokens, nil
+// without a pool.
+func (f *EventFeed) TokenStats() []TokenStats {
+	if f.tokens == nil {
+		return nil
+	}
+	return f.tokens.stats()
+}
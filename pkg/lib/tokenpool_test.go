@@ -0,0 +1,144 @@
+package lib_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestTokenPool(t *testing.T) {
+	start := time.Now()
+	reset := start.Add(time.Hour).Truncate(time.Second)
+
+	var exhausted int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		switch r.Header.Get("Authorization") {
+		case "Bearer a":
+			if atomic.LoadInt32(&exhausted) == 1 {
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"message":"API rate limit exceeded"}`))
+				return
+			}
+			w.Header().Set("X-RateLimit-Remaining", "4999")
+		case "Bearer b":
+			w.Header().Set("X-RateLimit-Remaining", "10")
+		default:
+			t.Errorf("got Authorization %q", r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	clock := lib.NewSimulatedClock(start)
+	feed, _, err := lib.NewEventFeed(context.Background(), &lib.Config{
+		BaseURL:    server.URL,
+		AuthToken:  "a",
+		AuthTokens: []string{"b"},
+		Clock:      clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := func() {
+		t.Helper()
+		// Distinct pages, bypassing the cache.
+		for page := 1; page <= 2; page++ {
+			if _, _, err := feed.Client().Activity.ListEvents(context.Background(), &github.ListOptions{Page: page}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The first token is exhausted, the request is retried with the second,
+	// which serves the next one while the first is benched.
+	list()
+	stats := feed.TokenStats()
+	if len(stats) != 2 || !stats[0].Benched || stats[0].Requests != 1 || stats[0].RateLimited != 1 {
+		t.Errorf("got stats %+v of the first token, want benched after a request", stats[0])
+	}
+	if stats[1].Requests != 2 || stats[1].Remaining != 10 {
+		t.Errorf("got stats %+v of the second token, want 2 requests", stats[1])
+	}
+
+	// Back in the pool once reset, with the most requests left.
+	atomic.StoreInt32(&exhausted, 0)
+	clock.Advance(time.Hour)
+	list()
+	stats = feed.TokenStats()
+	if stats[0].Benched || stats[0].Requests != 3 || stats[0].Remaining != 4999 {
+		t.Errorf("got stats %+v of the first token, want 2 more requests", stats[0])
+	}
+	if stats[1].Requests != 2 {
+		t.Errorf("got stats %+v of the second token, want no more requests", stats[1])
+	}
+
+	// The retried requests are accounted to both tokens.
+	if got := lib.FormatRequestCounts(feed.RequestCounts()); got != "token 1: other 3; token 2: other 2" {
+		t.Errorf("got requests %s, want those of each token", got)
+	}
+}
+
+func TestRequestAccounting(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/events" {
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte(`[]`))
+			return
+		}
+		w.Write([]byte(`{"login":"octocat"}`))
+	}))
+	defer server.Close()
+
+	feed, _, err := lib.NewEventFeed(context.Background(), &lib.Config{BaseURL: server.URL, AuthToken: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Revalidated by the cache the second time.
+	poller := lib.WithSubsystem(context.Background(), lib.SubsystemPoller)
+	for i := 0; i < 2; i++ {
+		if _, _, err := feed.Client().Activity.ListEvents(poller, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := feed.Client().Users.Get(lib.WithSubsystem(context.Background(), lib.SubsystemActorType), "octocat"); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := feed.RequestCounts()
+	if got := lib.FormatRequestCounts(counts); got != "token 1: actor-type 1, poller 2 (1 not modified)" {
+		t.Errorf("got requests %s", got)
+	}
+	if report := feed.Report(); len(report.Requests) != 2 {
+		t.Errorf("got requests %+v in the report, want %+v", report.Requests, counts)
+	}
+
+	var metrics strings.Builder
+	feed.WritePrometheus(&metrics)
+	for _, want := range []string{
+		`github_feed_api_requests_total{token="1",subsystem="poller"} 2`,
+		`github_feed_api_requests_not_modified_total{token="1",subsystem="poller"} 1`,
+		`github_feed_api_requests_total{token="1",subsystem="actor-type"} 1`,
+	} {
+		if !strings.Contains(metrics.String(), want) {
+			t.Errorf("metrics lack %s:\n%s", want, metrics.String())
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package lib
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v32/github"
+	"gopkg.in/yaml.v2"
+)
+
+// Transform rewrites the emitted events one at a time. Apply returns the
+// event to pass to the next transform, nil to drop it. Events are shared
+// with the other consumers of the feed (archive, stream), a transform
+// changing an event returns a modified copy.
+type Transform interface {
+	Apply(ev *github.Event) (*github.Event, error)
+}
+
+// TransformFactory builds a transform from its options, which decode
+// decodes strictly (unknown options are errors) into a struct with yaml
+// tags.
+type TransformFactory func(decode func(options interface{}) error) (Transform, error)
+
+var transforms = struct {
+	sync.RWMutex
+	byType map[string]TransformFactory
+}{byType: make(map[string]TransformFactory)}
+
+// RegisterTransform makes a transform type available to the transform
+// chains, see LoadTransformChain, replacing the one registered under the
+// same type.
+func RegisterTransform(typ string, factory TransformFactory) {
+	transforms.Lock()
+	defer transforms.Unlock()
+	transforms.byType[typ] = factory
+}
+
+// TransformTypes returns the registered transform types, sorted.
+func TransformTypes() []string {
+	transforms.RLock()
+	defer transforms.RUnlock()
+
+	types := make([]string, 0, len(transforms.byType))
+	for typ := range transforms.byType {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// transformStats are the counters of a transform of a chain.
+type transformStats struct {
+	in, out, errors uint64
+	nanos           int64
+}
+
+// What a chain does with an event a transform failed on, see the on_error
+// option of TransformChain.
+const (
+	// The event is dropped, the default.
+	TransformErrorDrop = "drop"
+	// The event is passed on to the next transform unmodified.
+	TransformErrorPass = "pass"
+	// The event is queued in the dead letters of the chain and dropped.
+	TransformErrorDeadLetter = "dead-letter"
+	// Apply fails, stopping the pipeline.
+	TransformErrorFail = "fail"
+)
+
+// TransformError is returned by TransformChain.Apply when a transform whose
+// on_error policy is fail failed on an event, or its dead letter couldn't
+// be queued.
+type TransformError struct {
+	Transform string
+	EventID   string
+	Err       error
+}
+
+func (e *TransformError) Error() string {
+	return fmt.Sprintf("transform '%s': event %s: %v", e.Transform, e.EventID, e.Err)
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+type namedTransform struct {
+	name    string
+	onError string
+	Transform
+	stats transformStats
+}
+
+// TransformChain applies named transforms to the events in the order they
+// are declared, e.g. in a YAML file (see LoadTransformChain):
+//
+//	transforms:
+//	  - name: pull-requests
+//	    type: filter
+//	    expression: type=PullRequestEvent
+//	  - name: pseudonymize
+//	    type: anonymize
+//	    salt: 3f1d...
+//	  - name: short
+//	    type: truncate
+//	    max_commits: 5
+//	    max_text: 1024
+//	    on_error: pass
+//
+// Each event goes through every transform in order, and the events keep
+// their order, so that the output of a chain only depends on its
+// configuration and its input.
+//
+// The on_error option of a transform decides what happens to the events it
+// fails on: drop (the default), pass to pass them on unmodified,
+// dead-letter to queue them in the dead letters of the chain, or fail to
+// stop the pipeline.
+//
+// The derive transforms emit events of the derived types declared under
+// types (see EventSchema), next to their source events or instead of them:
+//
+//	types:
+//	  - name: LargePush
+//	    fields: {repo: string, commits: integer, ref: string}
+//	    required: [repo, commits]
+//	transforms:
+//	  - name: large-pushes
+//	    type: derive
+//	    emit: LargePush
+//	    expression: type=PushEvent
+//	    fields: {repo: repo.name, commits: payload.size, ref: payload.ref}
+type TransformChain struct {
+	// OnError receives the events a transform failed on, whatever its
+	// on_error policy.
+	OnError func(transform string, ev *github.Event, err error)
+	// Queues the events of the dead-letter transforms, as the dead letters
+	// of the sink transform/<name>.
+	deadLetters *DeadLetterQueue
+
+	transforms []*namedTransform
+}
+
+type transformSpec struct {
+	Name    string                 `yaml:"name"`
+	Type    string                 `yaml:"type"`
+	OnError string                 `yaml:"on_error"`
+	Options map[string]interface{} `yaml:",inline"`
+}
+
+// ParseTransformChain parses a transform chain, see TransformChain, whose
+// dead-letter transforms queue the events they fail on in deadLetters. It
+// is required by them only.
+func ParseTransformChain(data []byte, deadLetters *DeadLetterQueue) (*TransformChain, error) {
+	var spec struct {
+		Types      []EventSchema   `yaml:"types"`
+		Transforms []transformSpec `yaml:"transforms"`
+	}
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return nil, err
+	}
+	for _, schema := range spec.Types {
+		if err := RegisterEventType(schema); err != nil {
+			return nil, err
+		}
+	}
+
+	chain := &TransformChain{deadLetters: deadLetters}
+	names := make(map[string]bool, len(spec.Transforms))
+	for i, t := range spec.Transforms {
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("%s-%d", t.Type, i+1)
+		}
+		if names[t.Name] {
+			return nil, fmt.Errorf("transform %d: duplicate name '%s'", i+1, t.Name)
+		}
+		names[t.Name] = true
+
+		switch t.OnError {
+		case "":
+			t.OnError = TransformErrorDrop
+		case TransformErrorDrop, TransformErrorPass, TransformErrorFail:
+		case TransformErrorDeadLetter:
+			if deadLetters == nil {
+				return nil, fmt.Errorf("transform '%s': on_error %s without a dead letter queue", t.Name, t.OnError)
+			}
+		default:
+			return nil, fmt.Errorf("transform '%s': unknown on_error '%s', expected %s, %s, %s or %s", t.Name, t.OnError,
+				TransformErrorDrop, TransformErrorPass, TransformErrorDeadLetter, TransformErrorFail)
+		}
+
+		transforms.RLock()
+		factory, ok := transforms.byType[t.Type]
+		transforms.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("transform '%s': unknown type '%s', expected one of %s",
+				t.Name, t.Type, strings.Join(TransformTypes(), ", "))
+		}
+
+		options := t.Options
+		decode := func(v interface{}) error {
+			b, err := yaml.Marshal(options)
+			if err != nil {
+				return err
+			}
+			return yaml.UnmarshalStrict(b, v)
+		}
+		transform, err := factory(decode)
+		if err != nil {
+			return nil, fmt.Errorf("transform '%s': %v", t.Name, err)
+		}
+		chain.transforms = append(chain.transforms, &namedTransform{name: t.Name, onError: t.OnError, Transform: transform})
+	}
+
+	return chain, nil
+}
+
+// LoadTransformChain reads a transform chain from a YAML file, see
+// ParseTransformChain.
+func LoadTransformChain(path string, deadLetters *DeadLetterQueue) (*TransformChain, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := ParseTransformChain(data, deadLetters)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return chain, nil
+}
+
+// Names returns the names of the transforms, in order.
+func (c *TransformChain) Names() []string {
+	names := make([]string, len(c.transforms))
+	for i, t := range c.transforms {
+		names[i] = t.name
+	}
+	return names
+}
+
+// OnErrorPolicies returns the on_error policy of each transform by name.
+func (c *TransformChain) OnErrorPolicies() map[string]string {
+	policies := make(map[string]string, len(c.transforms))
+	for _, t := range c.transforms {
+		policies[t.name] = t.onError
+	}
+	return policies
+}
+
+// Apply runs the events through the chain and returns those left, in order.
+// It fails with a *TransformError, and no events, once a transform whose
+// on_error policy is fail failed, or a dead letter couldn't be queued.
+func (c *TransformChain) Apply(events []*github.Event) ([]*github.Event, error) {
+	for _, t := range c.transforms {
+		if len(events) == 0 {
+			break
+		}
+
+		start := time.Now()
+		out := make([]*github.Event, 0, len(events))
+		deriver, derives := t.Transform.(Deriver)
+		for _, ev := range events {
+			var derived []*github.Event
+			var transformed *github.Event
+			var err error
+			if derives {
+				derived, err = deriver.Derive(ev)
+			} else {
+				transformed, err = t.Apply(ev)
+			}
+			if err != nil {
+				atomic.AddUint64(&t.stats.errors, 1)
+				if c.OnError != nil {
+					c.OnError(t.name, ev, err)
+				}
+				if err := c.failed(t, ev, err); err != nil {
+					return nil, err
+				}
+				if t.onError == TransformErrorPass {
+					out = append(out, ev)
+				}
+				continue
+			}
+			if transformed != nil {
+				out = append(out, transformed)
+			}
+			out = append(out, derived...)
+		}
+
+		atomic.AddUint64(&t.stats.in, uint64(len(events)))
+		atomic.AddUint64(&t.stats.out, uint64(len(out)))
+		atomic.AddInt64(&t.stats.nanos, int64(time.Since(start)))
+		events = out
+	}
+
+	return events, nil
+}
+
+// failed applies the on_error policy of a transform failing on an event
+// other than pass, and returns the error failing the chain if any.
+func (c *TransformChain) failed(t *namedTransform, ev *github.Event, err error) error {
+	switch t.onError {
+	case TransformErrorFail:
+		return &TransformError{Transform: t.name, EventID: ev.GetID(), Err: err}
+	case TransformErrorDeadLetter:
+		if err := c.deadLetters.Put("transform/"+t.name, []*github.Event{ev}, err); err != nil {
+			return &TransformError{Transform: t.name, EventID: ev.GetID(), Err: fmt.Errorf("queuing the dead letter: %v", err)}
+		}
+	}
+	return nil
+}
+
+// WritePrometheus writes the counters of the transforms in the Prometheus
+// text format, labeled by transform name:
+//
+//	github_feed_transform_events_in_total{transform}   events received
+//	github_feed_transform_events_out_total{transform}  events passed on
+//	github_feed_transform_errors_total{transform}      events failed, see on_error
+//	github_feed_transform_seconds_total{transform}     time spent
+func (c *TransformChain) WritePrometheus(w io.Writer) {
+	if len(c.transforms) == 0 {
+		return
+	}
+
+	series := []struct {
+		name, help string
+		value      func(s *transformStats) string
+	}{
+		{"transform_events_in_total", "Events received by the transform.", func(s *transformStats) string {
+			return fmt.Sprint(atomic.LoadUint64(&s.in))
+		}},
+		{"transform_events_out_total", "Events passed on by the transform.", func(s *transformStats) string {
+			return fmt.Sprint(atomic.LoadUint64(&s.out))
+		}},
+		{"transform_errors_total", "Events the transform failed on, handled by its on_error policy.", func(s *transformStats) string {
+			return fmt.Sprint(atomic.LoadUint64(&s.errors))
+		}},
+		{"transform_seconds_total", "Time spent in the transform.", func(s *transformStats) string {
+			return fmt.Sprint(time.Duration(atomic.LoadInt64(&s.nanos)).Seconds())
+		}},
+	}
+
+	for _, m := range series {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n", statsNamespace, m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s_%s counter\n", statsNamespace, m.name)
+		for _, t := range c.transforms {
+			fmt.Fprintf(w, "%s_%s{transform=\"%s\"} %s\n", statsNamespace, m.name, labelEscaper.Replace(t.name), m.value(&t.stats))
+		}
+	}
+}
+
+// filterTransform keeps the events matching a filter expression, or drops
+// them with exclude.
+type filterTransform struct {
+	filter  *Filter
+	exclude bool
+}
+
+func (t *filterTransform) Apply(ev *github.Event) (*github.Event, error) {
+	if t.filter.Match(ev) == t.exclude {
+		return nil, nil
+	}
+	return ev, nil
+}
+
+// sampleTransform keeps a fraction of the events, chosen by their ID so
+// that replays keep the same events.
+type sampleTransform struct {
+	rate float64
+}
+
+func (t *sampleTransform) Apply(ev *github.Event) (*github.Event, error) {
+	// Uniform even for sequential IDs, unlike FNV.
+	sum := sha256.Sum256([]byte(ev.GetID()))
+	if float64(binary.BigEndian.Uint64(sum[:8]))/(1<<64) >= t.rate {
+		return nil, nil
+	}
+	return ev, nil
+}
+
+// anonymizeTransform replaces the actor and the commit authors, co-authors
+// included, with pseudonyms, stable for a given salt so that the activity of
+// an actor can still be followed.
+type anonymizeTransform struct {
+	salt []byte
+}
+
+func (t *anonymizeTransform) pseudonym(s string) string {
+	mac := hmac.New(sha256.New, t.salt)
+	io.WriteString(mac, strings.ToLower(s))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+func (t *anonymizeTransform) Apply(ev *github.Event) (*github.Event, error) {
+	cp := *ev
+	if ev.Actor != nil {
+		cp.Actor = &github.User{Login: github.String(t.pseudonym(ev.Actor.GetLogin()))}
+	}
+
+	if ev.GetType() == "PushEvent" && ev.RawPayload != nil {
+		payload, err := rewritePayload(ev, func(p map[string]interface{}) {
+			commits, _ := p["commits"].([]interface{})
+			for _, c := range commits {
+				commit, _ := c.(map[string]interface{})
+				author, _ := commit["author"].(map[string]interface{})
+				for _, field := range []string{"email", "name"} {
+					if v, ok := author[field].(string); ok && v != "" {
+						author[field] = t.pseudonym(v)
+					}
+				}
+				if message, ok := commit["message"].(string); ok {
+					commit["message"] = rewriteCoAuthors(message, t.pseudonym)
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		cp.RawPayload = payload
+	}
+
+	return &cp, nil
+}
+
+// truncateTransform bounds the size of the payloads: the commits of pushes
+// and the strings (titles, bodies, commit messages) of every payload.
+type truncateTransform struct {
+	MaxCommits int `yaml:"max_commits"`
+	MaxText    int `yaml:"max_text"`
+}
+
+func truncateText(v interface{}, max int) interface{} {
+	switch v := v.(type) {
+	case string:
+		if len(v) <= max {
+			return v
+		}
+		// Cut on a rune boundary.
+		i := max
+		for i > 0 && !utf8.RuneStart(v[i]) {
+			i--
+		}
+		return v[:i] + "…"
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = truncateText(e, max)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = truncateText(e, max)
+		}
+	}
+	return v
+}
+
+func (t *truncateTransform) Apply(ev *github.Event) (*github.Event, error) {
+	if ev.RawPayload == nil {
+		return ev, nil
+	}
+
+	payload, err := rewritePayload(ev, func(p map[string]interface{}) {
+		if commits, ok := p["commits"].([]interface{}); ok && t.MaxCommits > 0 && len(commits) > t.MaxCommits {
+			p["commits"] = commits[:t.MaxCommits]
+		}
+		if t.MaxText > 0 {
+			truncateText(p, t.MaxText)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cp := *ev
+	cp.RawPayload = payload
+	return &cp, nil
+}
+
+// rewritePayload returns the raw payload of an event rewritten by fn.
+func rewritePayload(ev *github.Event, fn func(payload map[string]interface{})) (*json.RawMessage, error) {
+	var payload map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(string(*ev.RawPayload)))
+	// Keeps the IDs exceeding float64's precision.
+	d.UseNumber()
+	if err := d.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("event %s: %v", ev.GetID(), err)
+	}
+
+	fn(payload)
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	raw := json.RawMessage(b)
+	return &raw, nil
+}
+
+func init() {
+	RegisterTransform("filter", func(decode func(interface{}) error) (Transform, error) {
+		var opts struct {
+			Expression string `yaml:"expression"`
+			Exclude    bool   `yaml:"exclude"`
+		}
+		if err := decode(&opts); err != nil {
+			return nil, err
+		}
+		filter, err := ParseFilter(opts.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return &filterTransform{filter: filter, exclude: opts.Exclude}, nil
+	})
+
+	RegisterTransform("sample", func(decode func(interface{}) error) (Transform, error) {
+		var opts struct {
+			Rate float64 `yaml:"rate"`
+		}
+		if err := decode(&opts); err != nil {
+			return nil, err
+		}
+		if opts.Rate <= 0 || opts.Rate > 1 {
+			return nil, fmt.Errorf("invalid rate %g, expected a fraction in (0, 1]", opts.Rate)
+		}
+		return &sampleTransform{rate: opts.Rate}, nil
+	})
+
+	RegisterTransform("anonymize", func(decode func(interface{}) error) (Transform, error) {
+		var opts struct {
+			Salt string `yaml:"salt"`
+		}
+		if err := decode(&opts); err != nil {
+			return nil, err
+		}
+		if opts.Salt == "" {
+			// Unsalted, the pseudonyms of known logins and emails are found
+			// by hashing them.
+			return nil, errors.New("missing salt")
+		}
+		RegisterSecret(opts.Salt)
+		return &anonymizeTransform{salt: []byte(opts.Salt)}, nil
+	})
+
+	RegisterTransform("truncate", func(decode func(interface{}) error) (Transform, error) {
+		var t truncateTransform
+		if err := decode(&t); err != nil {
+			return nil, err
+		}
+		if t.MaxCommits < 0 || t.MaxText < 0 {
+			return nil, errors.New("max_commits and max_text must be positive")
+		}
+		return &t, nil
+	})
+}
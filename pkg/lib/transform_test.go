@@ -0,0 +1,349 @@
+package lib_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestTransformChain(t *testing.T) {
+	chain, err := lib.ParseTransformChain([]byte(`
+transforms:
+  - name: no-stars
+    type: filter
+    expression: type=WatchEvent
+    exclude: true
+  - name: pseudonymize
+    type: anonymize
+    salt: not-so-secret-salt
+  - type: truncate
+    max_commits: 1
+    max_text: 5
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"no-stars", "pseudonymize", "truncate-3"}; !reflect.DeepEqual(chain.Names(), want) {
+		t.Errorf("got transforms %v, want %v", chain.Names(), want)
+	}
+
+	push := testEvent("1", "PushEvent", "Alice", "o/r", time.Time{}, `{"push_id":10556331495516155,"commits":[`+
+		`{"message":"héllo world","author":{"email":"alice@example.com","name":"Alice"}},`+
+		`{"message":"second","author":{"email":"bob@example.com","name":"Bob"}}]}`)
+	push.Actor.ID = github.Int64(42)
+	events := []*github.Event{
+		push,
+		testEvent("2", "WatchEvent", "bob", "o/r", time.Time{}, `{"action":"started"}`),
+		testEvent("3", "IssuesEvent", "alice", "o/r", time.Time{}, `{"action":"opened"}`),
+		testEvent("4", "PushEvent", "carol", "o/r", time.Time{}, `{"commits":`),
+	}
+	original := string(*push.RawPayload)
+
+	var failed []string
+	chain.OnError = func(name string, ev *github.Event, err error) {
+		failed = append(failed, name+":"+ev.GetID())
+	}
+	out, err := chain.Apply(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out) != 2 || out[0].GetID() != "1" || out[1].GetID() != "3" {
+		t.Fatalf("got %d events, want 1 and 3", len(out))
+	}
+	if want := []string{"pseudonymize:4"}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("got errors %v, want %v", failed, want)
+	}
+
+	login := out[0].GetActor().GetLogin()
+	if !strings.HasPrefix(login, "anon-") || out[1].GetActor().GetLogin() != login || out[0].GetActor().GetID() != 0 {
+		t.Errorf("got actors %v and %v, want the same pseudonym", out[0].GetActor(), out[1].GetActor())
+	}
+	payload := string(*out[0].RawPayload)
+	if strings.Contains(payload, "alice") || strings.Contains(payload, "bob") || !strings.Contains(payload, "10556331495516155") {
+		t.Errorf("got payload %s", payload)
+	}
+	if !strings.Contains(payload, `"message":"héll…"`) || strings.Count(payload, "message") != 1 {
+		t.Errorf("got payload %s, want a single truncated commit", payload)
+	}
+	if string(*push.RawPayload) != original || push.GetActor().GetLogin() != "Alice" {
+		t.Error("the original event was modified")
+	}
+
+	var metrics bytes.Buffer
+	chain.WritePrometheus(&metrics)
+	for _, line := range []string{
+		`github_feed_transform_events_in_total{transform="no-stars"} 4`,
+		`github_feed_transform_events_out_total{transform="no-stars"} 3`,
+		`github_feed_transform_errors_total{transform="pseudonymize"} 1`,
+		`github_feed_transform_events_out_total{transform="truncate-3"} 2`,
+	} {
+		if !strings.Contains(metrics.String(), line+"\n") {
+			t.Errorf("missing %s in\n%s", line, metrics.String())
+		}
+	}
+}
+
+func TestTransformSample(t *testing.T) {
+	chain, err := lib.ParseTransformChain([]byte("transforms:\n  - type: sample\n    rate: 0.25\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []*github.Event
+	for i := 0; i < 1000; i++ {
+		events = append(events, testEvent(fmt.Sprint(i), "PushEvent", "a", "o/r", time.Time{}, `{}`))
+	}
+	first, err := chain.Apply(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(first); n < 200 || n > 300 {
+		t.Errorf("kept %d events of 1000, want about 250", n)
+	}
+	if again, _ := chain.Apply(events); !reflect.DeepEqual(first, again) {
+		t.Error("sampled different events")
+	}
+}
+
+func TestParseTransformChainErrors(t *testing.T) {
+	for spec, want := range map[string]string{
+		"transforms:\n  - type: nope\n":                                                             "unknown type 'nope'",
+		"transforms:\n  - type: filter\n    expresion: type=X\n":                                    "expresion",
+		"transforms:\n  - type: anonymize\n":                                                        "missing salt",
+		"transforms:\n  - {type: sample, rate: 1, on_error: retry}\n":                               "unknown on_error 'retry'",
+		"transforms:\n  - type: sample\n    rate: 2\n":                                              "invalid rate",
+		"transforms:\n  - {name: a, type: sample, rate: 1}\n  - {name: a, type: sample, rate: 1}\n": "duplicate name 'a'",
+		"transforms:\n  - {name: a, type: sample, rate: 1, on_error: dead-letter}\n":                "without a dead letter queue",
+	} {
+		if _, err := lib.ParseTransformChain([]byte(spec), nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %s", spec, err, want)
+		}
+	}
+}
+
+func TestTransformOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := lib.OpenDeadLetterQueue(filepath.Join(dir, "dlq.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Close()
+
+	// Fails on the events of an actor, e.g. a flaky lookup.
+	lib.RegisterTransform("test-flaky", func(decode func(interface{}) error) (lib.Transform, error) {
+		var options struct {
+			Actor string `yaml:"actor"`
+		}
+		if err := decode(&options); err != nil {
+			return nil, err
+		}
+		return flakyTransform(options.Actor), nil
+	})
+
+	events := []*github.Event{
+		testEvent("1", "PushEvent", "alice", "o/r", time.Time{}, `{}`),
+		testEvent("2", "PushEvent", "bob", "o/r", time.Time{}, `{}`),
+	}
+
+	for policy, want := range map[string][]string{
+		lib.TransformErrorDrop:       {"1"},
+		lib.TransformErrorPass:       {"1", "2"},
+		lib.TransformErrorDeadLetter: {"1"},
+		lib.TransformErrorFail:       nil,
+	} {
+		chain, err := lib.ParseTransformChain([]byte("transforms:\n  - {name: flaky, type: test-flaky, actor: bob, on_error: "+policy+"}\n"), queue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := chain.OnErrorPolicies()["flaky"]; got != policy {
+			t.Errorf("got policy %s, want %s", got, policy)
+		}
+
+		var failed int
+		chain.OnError = func(name string, ev *github.Event, err error) { failed++ }
+		out, err := chain.Apply(events)
+
+		var ids []string
+		for _, ev := range out {
+			ids = append(ids, ev.GetID())
+		}
+		if !reflect.DeepEqual(ids, want) || failed != 1 {
+			t.Errorf("%s: got events %v and %d errors, want %v and 1", policy, ids, failed, want)
+		}
+		if len(out) == 2 && out[1] != events[1] {
+			t.Errorf("%s: the event failed on wasn't passed unmodified", policy)
+		}
+
+		var terr *lib.TransformError
+		if isErr := errors.As(err, &terr); isErr != (policy == lib.TransformErrorFail) {
+			t.Errorf("%s: got error %v", policy, err)
+		} else if isErr && (terr.Transform != "flaky" || terr.EventID != "2") {
+			t.Errorf("%s: got error %+v", policy, terr)
+		}
+	}
+
+	letters, err := ioutil.ReadFile(filepath.Join(dir, "dlq.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(letters), "\n"); n != 1 || !strings.Contains(string(letters), `"sink":"transform/flaky"`) {
+		t.Errorf("got dead letters %s, want the event 2 of transform/flaky", letters)
+	}
+}
+
+type flakyTransform string
+
+func (t flakyTransform) Apply(ev *github.Event) (*github.Event, error) {
+	if ev.GetActor().GetLogin() == string(t) {
+		return nil, errors.New("unavailable")
+	}
+	cp := *ev
+	cp.Public = github.Bool(true)
+	return &cp, nil
+}
+
+func TestNormalize(t *testing.T) {
+	for _, tc := range []struct {
+		ev        *github.Event
+		typ, repo string
+		payload   string
+		unchanged bool
+	}{
+		{
+			ev:        testEvent("1", "IssuesEvent", "a", "o/r", time.Time{}, `{"action":"opened"}`),
+			typ:       "IssuesEvent",
+			repo:      "o/r",
+			payload:   `{"action":"opened"}`,
+			unchanged: true,
+		},
+		{
+			ev:      testEvent("2", "pull_request_review", "a", "o/r", time.Time{}, `{"action":"submitted"}`),
+			typ:     "PullRequestReviewEvent",
+			repo:    "o/r",
+			payload: `{"action":"created"}`,
+		},
+		{
+			ev:      testEvent("3", "CreateEvent", "a", "o/r", time.Time{}, `{"object":"branch","object_name":"main"}`),
+			typ:     "CreateEvent",
+			repo:    "o/r",
+			payload: `{"ref":"main","ref_type":"branch"}`,
+		},
+		{
+			ev: testEvent("4", "PushEvent", "a", "o/r", time.Time{},
+				`{"push_id":10556331495516155,"shas":[["abc","a@example.com","fix","A",true]]}`),
+			typ:  "PushEvent",
+			repo: "o/r",
+			payload: `{"commits":[{"author":{"email":"a@example.com","name":"A"},"distinct":true,"message":"fix","sha":"abc"}],` +
+				`"push_id":10556331495516155,"size":1}`,
+		},
+		{
+			ev:      testEvent("5", "push", "a", "o/r", time.Time{}, `{"after":"def","commits":[{"id":"def"}]}`),
+			typ:     "PushEvent",
+			repo:    "o/r",
+			payload: `{"commits":[{"sha":"def"}],"head":"def"}`,
+		},
+	} {
+		got, err := lib.Normalize(tc.ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.unchanged != (got == tc.ev) {
+			t.Errorf("%s: got a copy %v, want %v", tc.ev.GetID(), got != tc.ev, !tc.unchanged)
+		}
+		if got.GetType() != tc.typ || got.GetRepo().GetName() != tc.repo {
+			t.Errorf("%s: got %s of %s, want %s of %s", tc.ev.GetID(), got.GetType(), got.GetRepo().GetName(), tc.typ, tc.repo)
+		}
+		if payload := string(*got.RawPayload); payload != tc.payload {
+			t.Errorf("%s: got the payload %s, want %s", tc.ev.GetID(), payload, tc.payload)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"":                             "o/legacy",
+		"https://github.com/o/web.git": "o/web",
+		"/o/r/":                        "o/r",
+	} {
+		ev := testEvent("6", "WatchEvent", "a", "o/r", time.Time{}, `{"action":"started"}`)
+		ev.Repo = &github.Repository{Name: github.String(name), URL: github.String("https://api.github.com/repos/o/legacy")}
+		got, err := lib.Normalize(ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.GetRepo().GetName() != want {
+			t.Errorf("%q: got the repository %s, want %s", name, got.GetRepo().GetName(), want)
+		}
+	}
+}
+
+func TestTransformDerive(t *testing.T) {
+	chain, err := lib.ParseTransformChain([]byte(`
+types:
+  - name: LargePush
+    description: Pushes of more than one commit.
+    fields: {repo: string, commits: integer, ref: string, forced: boolean}
+    required: [repo, commits]
+transforms:
+  - name: large-pushes
+    type: derive
+    emit: LargePush
+    expression: type=PushEvent
+    fields: {repo: repo.name, commits: payload.size, ref: payload.ref, forced: payload.forced}
+  - name: only-large
+    type: filter
+    expression: type=LargePush
+`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema := lib.LookupEventType("LargePush"); schema == nil || schema.Description != "Pushes of more than one commit." {
+		t.Fatalf("got LargePush schema %+v", schema)
+	}
+
+	push := testEvent("1", "PushEvent", "alice", "o/r", time.Time{}, `{"size":3,"ref":"refs/heads/main"}`)
+	push.Repo = &github.Repository{Name: github.String("o/r")}
+	bad := testEvent("2", "PushEvent", "alice", "o/r", time.Time{}, `{"size":"many"}`)
+	bad.Repo = push.Repo
+	out, err := chain.Apply([]*github.Event{push, testEvent("3", "WatchEvent", "bob", "o/r", time.Time{}, `{}`), bad})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 {
+		t.Fatalf("got %d events, want the derived event of the valid push", len(out))
+	}
+	ev := out[0]
+	if ev.GetID() != "1:LargePush" || ev.GetType() != "LargePush" || ev.GetRepo().GetName() != "o/r" || ev.GetActor().GetLogin() != "alice" {
+		t.Errorf("got derived event %+v", ev)
+	}
+	if payload := string(*ev.RawPayload); payload != `{"commits":3,"ref":"refs/heads/main","repo":"o/r"}` {
+		t.Errorf("got payload %s", payload)
+	}
+
+	for _, spec := range []string{
+		"types: [{name: LargePushEvent, fields: {repo: string}}]",
+		"types: [{name: LargePush, fields: {repo: text}}]",
+		"types: [{name: LargePush, fields: {repo: string}, required: [commits]}]",
+		"transforms: [{type: derive, emit: Unknown}]",
+		"types: [{name: LargePush, fields: {repo: string}}]\ntransforms: [{type: derive, emit: LargePush, fields: {size: payload.size}}]",
+		"types: [{name: LargePush, fields: {repo: string}, required: [repo]}]\ntransforms: [{type: derive, emit: LargePush}]",
+	} {
+		if _, err := lib.ParseTransformChain([]byte(spec), nil); err == nil {
+			t.Errorf("parsed %q", spec)
+		}
+	}
+}
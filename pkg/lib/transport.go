@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"net/http"
+	"strings"
+)
+
+const xGitHubAPIVersionHeader = "X-GitHub-Api-Version"
+
+// headerTransport sets the API version, preview media types and identity
+// (see SetRequestIdentity) on every request to GitHub.
+type headerTransport struct {
+	base       http.RoundTripper
+	apiVersion string
+	accept     []string
+	// The request identity if empty.
+	userAgent string
+	tag       string
+}
+
+func newHeaderTransport(base http.RoundTripper, conf *Config) http.RoundTripper {
+	t := &headerTransport{base: base, apiVersion: conf.APIVersion, userAgent: conf.UserAgent, tag: conf.RequestTag}
+	for _, preview := range conf.Previews {
+		// Accept both the preview name and its full media type.
+		if !strings.HasPrefix(preview, "application/") {
+			preview = "application/vnd.github." + preview + "-preview+json"
+		}
+		t.accept = append(t.accept, preview)
+	}
+
+	return t
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the caller's request.
+	req = req.Clone(req.Context())
+
+	if t.apiVersion != "" {
+		req.Header.Set(xGitHubAPIVersionHeader, t.apiVersion)
+	}
+
+	// Replacing go-github's.
+	userAgent, tag := requestIdentity()
+	if t.userAgent != "" {
+		userAgent = t.userAgent
+	}
+	if t.tag != "" {
+		tag = t.tag
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if tag != "" {
+		req.Header.Set(xFeedTagHeader, tag)
+	}
+
+	if len(t.accept) > 0 {
+		accept := t.accept
+		if current := req.Header.Get("Accept"); current != "" {
+			accept = append(append([]string(nil), accept...), current)
+		}
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,308 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const defaultHandlerQueue = 64
+
+// TypedEvent is an event with its parsed payload.
+type TypedEvent struct {
+	*github.Event
+	// Payload is the payload parsed by ParsePayload, e.g. a
+	// *github.PushEvent for a PushEvent, or a map for the types go-github
+	// doesn't know.
+	Payload interface{}
+}
+
+// ParseEvent parses the payload of an event.
+func ParseEvent(ev *github.Event) (*TypedEvent, error) {
+	payload, err := ev.ParsePayload()
+	if err != nil {
+		return nil, err
+	}
+	return &TypedEvent{Event: ev, Payload: payload}, nil
+}
+
+// EventHandler handles the events of a Dispatcher's subscription.
+type EventHandler interface {
+	HandleEvent(ev *TypedEvent)
+}
+
+// EventHandlerFunc adapts a function to EventHandler.
+type EventHandlerFunc func(ev *TypedEvent)
+
+func (fn EventHandlerFunc) HandleEvent(ev *TypedEvent) {
+	fn(ev)
+}
+
+// TypedHandler is an EventHandler calling the function of the event's
+// payload type, with the event (actor, repository, creation time) and its
+// payload, instead of a type switch. The events of the types without
+// function are passed to Default, if set.
+type TypedHandler struct {
+	CommitComment            func(ev *github.Event, p *github.CommitCommentEvent)
+	Create                   func(ev *github.Event, p *github.CreateEvent)
+	Delete                   func(ev *github.Event, p *github.DeleteEvent)
+	Fork                     func(ev *github.Event, p *github.ForkEvent)
+	Gollum                   func(ev *github.Event, p *github.GollumEvent)
+	IssueComment             func(ev *github.Event, p *github.IssueCommentEvent)
+	Issues                   func(ev *github.Event, p *github.IssuesEvent)
+	Member                   func(ev *github.Event, p *github.MemberEvent)
+	Public                   func(ev *github.Event, p *github.PublicEvent)
+	PullRequest              func(ev *github.Event, p *github.PullRequestEvent)
+	PullRequestReview        func(ev *github.Event, p *github.PullRequestReviewEvent)
+	PullRequestReviewComment func(ev *github.Event, p *github.PullRequestReviewCommentEvent)
+	Push                     func(ev *github.Event, p *github.PushEvent)
+	Release                  func(ev *github.Event, p *github.ReleaseEvent)
+	Watch                    func(ev *github.Event, p *github.WatchEvent)
+
+	Default func(ev *TypedEvent)
+}
+
+func (h *TypedHandler) HandleEvent(ev *TypedEvent) {
+	handled := true
+	switch p := ev.Payload.(type) {
+	case *github.CommitCommentEvent:
+		handled = h.CommitComment != nil
+		if handled {
+			h.CommitComment(ev.Event, p)
+		}
+	case *github.CreateEvent:
+		handled = h.Create != nil
+		if handled {
+			h.Create(ev.Event, p)
+		}
+	case *github.DeleteEvent:
+		handled = h.Delete != nil
+		if handled {
+			h.Delete(ev.Event, p)
+		}
+	case *github.ForkEvent:
+		handled = h.Fork != nil
+		if handled {
+			h.Fork(ev.Event, p)
+		}
+	case *github.GollumEvent:
+		handled = h.Gollum != nil
+		if handled {
+			h.Gollum(ev.Event, p)
+		}
+	case *github.IssueCommentEvent:
+		handled = h.IssueComment != nil
+		if handled {
+			h.IssueComment(ev.Event, p)
+		}
+	case *github.IssuesEvent:
+		handled = h.Issues != nil
+		if handled {
+			h.Issues(ev.Event, p)
+		}
+	case *github.MemberEvent:
+		handled = h.Member != nil
+		if handled {
+			h.Member(ev.Event, p)
+		}
+	case *github.PublicEvent:
+		handled = h.Public != nil
+		if handled {
+			h.Public(ev.Event, p)
+		}
+	case *github.PullRequestEvent:
+		handled = h.PullRequest != nil
+		if handled {
+			h.PullRequest(ev.Event, p)
+		}
+	case *github.PullRequestReviewEvent:
+		handled = h.PullRequestReview != nil
+		if handled {
+			h.PullRequestReview(ev.Event, p)
+		}
+	case *github.PullRequestReviewCommentEvent:
+		handled = h.PullRequestReviewComment != nil
+		if handled {
+			h.PullRequestReviewComment(ev.Event, p)
+		}
+	case *github.PushEvent:
+		handled = h.Push != nil
+		if handled {
+			h.Push(ev.Event, p)
+		}
+	case *github.ReleaseEvent:
+		handled = h.Release != nil
+		if handled {
+			h.Release(ev.Event, p)
+		}
+	case *github.WatchEvent:
+		handled = h.Watch != nil
+		if handled {
+			h.Watch(ev.Event, p)
+		}
+	default:
+		handled = false
+	}
+
+	if !handled && h.Default != nil {
+		h.Default(ev)
+	}
+}
+
+// SubscribeOptions configure a subscription to a Dispatcher.
+type SubscribeOptions struct {
+	// Types of the events delivered, e.g. PushEvent, all if empty.
+	Types []string
+	// Number of events buffered for the subscriber, after which a slow
+	// subscriber holds back the dispatcher. 64 by default.
+	Queue int
+	// Number of goroutines calling the handler. Events are handled in order
+	// by a single worker (the default), concurrently by several.
+	Workers int
+}
+
+// Subscriber is a handler subscribed to a Dispatcher.
+type Subscriber struct {
+	handler EventHandler
+	types   map[string]bool
+	queue   chan *TypedEvent
+	done    sync.WaitGroup
+	handled uint64
+}
+
+// Handled returns the number of events the subscriber handled.
+func (s *Subscriber) Handled() uint64 {
+	return atomic.LoadUint64(&s.handled)
+}
+
+func (s *Subscriber) serve() {
+	defer s.done.Done()
+	for ev := range s.queue {
+		s.handler.HandleEvent(ev)
+		atomic.AddUint64(&s.handled, 1)
+	}
+}
+
+// Dispatcher parses the payloads of events once and delivers them to
+// independent subscribers, each with its own queue and workers, so that
+// consumers handle typed payloads rather than raw events:
+//
+//	d := lib.NewDispatcher()
+//	d.Subscribe(&lib.TypedHandler{
+//		Push: func(ev *github.Event, p *github.PushEvent) { ... },
+//	}, lib.SubscribeOptions{Types: []string{"PushEvent"}})
+//	d.Run(events)
+//
+// The events whose payload fails to parse are passed to OnError rather than
+// to the subscribers.
+type Dispatcher struct {
+	// OnError receives the events whose payload failed to parse, they are
+	// logged if nil. E.g. EventFeed.ReportError with OpParse.
+	OnError func(ev *github.Event, err error)
+
+	mu          sync.Mutex
+	subs        []*Subscriber
+	closed      bool
+	parseErrors uint64
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{}
+}
+
+// Subscribe registers a handler receiving the events published from now on.
+func (d *Dispatcher) Subscribe(handler EventHandler, opts SubscribeOptions) *Subscriber {
+	s := &Subscriber{
+		handler: handler,
+		queue:   make(chan *TypedEvent, orDefault(opts.Queue, defaultHandlerQueue)),
+	}
+	if len(opts.Types) > 0 {
+		s.types = make(map[string]bool, len(opts.Types))
+		for _, t := range opts.Types {
+			s.types[t] = true
+		}
+	}
+
+	workers := orDefault(opts.Workers, 1)
+	s.done.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.serve()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		close(s.queue)
+	} else {
+		d.subs = append(d.subs, s)
+	}
+	return s
+}
+
+// Publish parses the events and queues them for their subscribers, in
+// order. It fails if ctx is done before they are all queued.
+func (d *Dispatcher) Publish(ctx context.Context, events []*github.Event) error {
+	d.mu.Lock()
+	subs := d.subs
+	d.mu.Unlock()
+
+	for _, ev := range events {
+		typed, err := ParseEvent(ev)
+		if err != nil {
+			atomic.AddUint64(&d.parseErrors, 1)
+			if d.OnError != nil {
+				d.OnError(ev, err)
+			} else {
+				warnf("Error parsing the payload of event %s: %v", ev.GetID(), err)
+			}
+			continue
+		}
+
+		for _, s := range subs {
+			if s.types != nil && !s.types[ev.GetType()] {
+				continue
+			}
+			select {
+			case s.queue <- typed:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+// ParseErrors returns the number of events whose payload failed to parse.
+func (d *Dispatcher) ParseErrors() uint64 {
+	return atomic.LoadUint64(&d.parseErrors)
+}
+
+// Close stops the subscriptions once they handled their queued events. The
+// dispatcher must not be published to afterwards.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	subs := d.subs
+	if !d.closed {
+		d.closed = true
+		for _, s := range subs {
+			close(s.queue)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, s := range subs {
+		s.done.Wait()
+	}
+}
+
+// Run publishes the events of a stream, e.g. the channel returned by
+// NewEventFeed, until it is closed, then closes the dispatcher.
+func (d *Dispatcher) Run(s Stream) {
+	for batch := range s {
+		d.Publish(context.Background(), batch)
+	}
+	d.Close()
+}
@@ -0,0 +1,76 @@
+package lib_test
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestDispatcher(t *testing.T) {
+	events := []*github.Event{
+		testEvent("1", "PushEvent", "", "", time.Time{}, `{"ref":"refs/heads/main","commits":[{"sha":"a"},{"sha":"b"}]}`),
+		testEvent("2", "WatchEvent", "", "", time.Time{}, `{"action":"started"}`),
+		testEvent("3", "PushEvent", "", "", time.Time{}, `{"ref":`),
+		testEvent("4", "IssuesEvent", "", "", time.Time{}, `{"action":"opened","issue":{"number":7}}`),
+		testEvent("5", "PushEvent", "", "", time.Time{}, `{"ref":"refs/heads/dev"}`),
+	}
+
+	d := lib.NewDispatcher()
+	var failed []string
+	d.OnError = func(ev *github.Event, err error) { failed = append(failed, ev.GetID()) }
+
+	var mu sync.Mutex
+	var pushes, others []string
+	typed := d.Subscribe(&lib.TypedHandler{
+		Push: func(ev *github.Event, p *github.PushEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			pushes = append(pushes, ev.GetID()+":"+p.GetRef())
+		},
+		Default: func(ev *lib.TypedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			others = append(others, ev.GetID())
+		},
+	}, lib.SubscribeOptions{})
+
+	var issues []int
+	d.Subscribe(lib.EventHandlerFunc(func(ev *lib.TypedEvent) {
+		issues = append(issues, ev.Payload.(*github.IssuesEvent).GetIssue().GetNumber())
+	}), lib.SubscribeOptions{Types: []string{"IssuesEvent"}, Queue: 1})
+
+	var concurrent []string
+	var cmu sync.Mutex
+	d.Subscribe(lib.EventHandlerFunc(func(ev *lib.TypedEvent) {
+		cmu.Lock()
+		defer cmu.Unlock()
+		concurrent = append(concurrent, ev.GetID())
+	}), lib.SubscribeOptions{Workers: 4})
+
+	d.Run(streamOf(events[:2], events[2:]))
+
+	if want := []string{"1:refs/heads/main", "5:refs/heads/dev"}; !reflect.DeepEqual(pushes, want) {
+		t.Errorf("got pushes %v, want %v", pushes, want)
+	}
+	if want := []string{"2", "4"}; !reflect.DeepEqual(others, want) {
+		t.Errorf("got others %v, want %v", others, want)
+	}
+	if want := []int{7}; !reflect.DeepEqual(issues, want) {
+		t.Errorf("got issues %v, want %v", issues, want)
+	}
+	sort.Strings(concurrent)
+	if want := []string{"1", "2", "4", "5"}; !reflect.DeepEqual(concurrent, want) {
+		t.Errorf("got %v handled by the workers, want %v", concurrent, want)
+	}
+	if want := []string{"3"}; !reflect.DeepEqual(failed, want) || d.ParseErrors() != 1 {
+		t.Errorf("got parse errors %v (%d), want %v", failed, d.ParseErrors(), want)
+	}
+	if typed.Handled() != 4 {
+		t.Errorf("got %d events handled, want 4", typed.Handled())
+	}
+}
@@ -0,0 +1,247 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ConfigError is a problem of a field of a configuration.
+type ConfigError struct {
+	// Path of the field, e.g. Filter.Repos[1], or the flag setting it.
+	Field string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors are the problems of a configuration, reported all at once
+// rather than at the first use of each field.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	if len(e) == 1 {
+		return "invalid configuration: " + e[0].Error()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration, %d problems:", len(e))
+	for _, err := range e {
+		b.WriteString("\n  ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Add records the problem of a field, nothing if err is nil.
+func (e *ConfigErrors) Add(field string, err error) {
+	if err != nil {
+		*e = append(*e, &ConfigError{Field: field, Err: err})
+	}
+}
+
+// Addf records a problem of a field described by a format.
+func (e *ConfigErrors) Addf(field, format string, args ...interface{}) {
+	e.Add(field, fmt.Errorf(format, args...))
+}
+
+// Err returns the errors, nil without any.
+func (e ConfigErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the whole configuration, e.g. before NewFeed, and returns
+// the problems found as ConfigErrors: malformed URLs, patterns and
+// expressions, negative sizes and durations, and conflicting modes.
+func (c *Config) Validate() error {
+	var errs ConfigErrors
+
+	for i, u := range append([]string{c.BaseURL}, c.FallbackURLs...) {
+		field := "BaseURL"
+		if i > 0 {
+			field = fmt.Sprintf("FallbackURLs[%d]", i-1)
+		}
+		if u == "" && i == 0 {
+			continue
+		}
+		errs.Add(field, validateHTTPURL(u))
+	}
+
+	if c.UploadURL != "" {
+		if c.BaseURL == "" {
+			errs.Addf("UploadURL", "requires the base URL of the GitHub Enterprise Server instance")
+		}
+		errs.Add("UploadURL", validateHTTPURL(c.UploadURL))
+	}
+	if c.ProxyURL != "" {
+		if u, err := url.Parse(c.ProxyURL); err != nil {
+			errs.Add("ProxyURL", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+			errs.Addf("ProxyURL", "expected an http(s) or socks5 URL")
+		} else if u.Host == "" {
+			errs.Addf("ProxyURL", "missing host")
+		}
+	}
+
+	for i, token := range c.AuthTokens {
+		if strings.TrimSpace(token) == "" {
+			errs.Addf(fmt.Sprintf("AuthTokens[%d]", i), "empty token")
+		}
+	}
+	for i, preview := range c.Previews {
+		if strings.TrimSpace(preview) == "" {
+			errs.Addf(fmt.Sprintf("Previews[%d]", i), "empty preview")
+		}
+	}
+
+	for _, n := range []struct {
+		field string
+		value int64
+	}{
+		{"QueueSize", int64(c.QueueSize)},
+		{"MaxBatchEvents", int64(c.MaxBatchEvents)},
+		{"MaxEventAge", int64(c.MaxEventAge)},
+		{"LagThreshold", int64(c.LagThreshold)},
+		{"MaxLagPollInterval", int64(c.MaxLagPollInterval)},
+		{"CatchUpAfter", int64(c.CatchUpAfter)},
+		{"CatchUpInterval", int64(c.CatchUpInterval)},
+		{"ErrorsQueueSize", int64(c.ErrorsQueueSize)},
+		{"RequestTimeout", int64(c.RequestTimeout)},
+		{"RequestReportInterval", int64(c.RequestReportInterval)},
+		{"MinPollInterval", int64(c.MinPollInterval)},
+		{"MaxPages", int64(c.MaxPages)},
+		{"HTTPCacheBytes", int64(c.HTTPCacheBytes)},
+		{"MaxBandwidth", int64(c.MaxBandwidth)},
+		{"RetryBackoff", int64(c.RetryBackoff)},
+		{"MaxRetryBackoff", int64(c.MaxRetryBackoff)},
+	} {
+		if n.value < 0 {
+			errs.Addf(n.field, "must not be negative")
+		}
+	}
+	if c.RetryBackoff > 0 && c.MaxRetryBackoff > 0 && c.RetryBackoff > c.MaxRetryBackoff {
+		errs.Addf("RetryBackoff", "%v exceeds the maximum backoff (%v)", c.RetryBackoff, c.MaxRetryBackoff)
+	}
+
+	if c.MaxPages > maximumEventsPages {
+		errs.Addf("MaxPages", "exceeds the %d pages served by the events API", maximumEventsPages)
+	}
+	if c.LogLevel != "" {
+		_, err := logLevel(c.LogLevel)
+		errs.Add("LogLevel", err)
+	}
+	if c.LogFormat != "" {
+		errs.Add("LogFormat", validLogFormat(c.LogFormat))
+	}
+
+	errs.Add("Backpressure", validBackpressure(c.Backpressure))
+	if c.Backpressure == BackpressureSpill && c.SpillDir == "" {
+		errs.Addf("SpillDir", "required to spill batches")
+	}
+
+	for i, source := range c.Sources {
+		_, err := source.path()
+		errs.Add(fmt.Sprintf("Sources[%d]", i), err)
+	}
+
+	for _, l := range []struct {
+		field, name string
+		patterns    []string
+	}{
+		{"Filter.Actors", "actor", c.Filter.Actors},
+		{"Filter.ExcludeActors", "actor", c.Filter.ExcludeActors},
+		{"Filter.Repos", "repository", c.Filter.Repos},
+		{"Filter.ExcludeRepos", "repository", c.Filter.ExcludeRepos},
+		{"Filter.Orgs", "organization", c.Filter.Orgs},
+		{"Filter.ExcludeOrgs", "organization", c.Filter.ExcludeOrgs},
+	} {
+		for i, p := range l.patterns {
+			_, err := compileGlobs(l.name, []string{p})
+			errs.Add(fmt.Sprintf("%s[%d]", l.field, i), err)
+		}
+	}
+	_, err := ParseFilter(c.Filter.Expression)
+	errs.Add("Filter.Expression", err)
+	if !c.LagShedFilter.IsZero() {
+		if c.LagThreshold == 0 {
+			errs.Addf("LagShedFilter", "requires LagThreshold")
+		}
+		_, err := c.LagShedFilter.Compile()
+		errs.Add("LagShedFilter", err)
+	}
+
+	if c.WebhookAddr != "" {
+		if c.WebhookSecret == "" {
+			errs.Add("WebhookSecret", ErrWebhookSecret)
+		}
+		if len(c.Sources) > 0 {
+			errs.Addf("Sources", "webhook feeds (WebhookAddr) don't poll sources")
+		}
+		if c.MetricsAddr == c.WebhookAddr {
+			errs.Addf("MetricsAddr", "conflicts with WebhookAddr %s", c.WebhookAddr)
+		}
+	}
+
+	if c.CatchUpFromArchive {
+		if c.CatchUpAfter == 0 {
+			errs.Addf("CatchUpFromArchive", "requires CatchUpAfter")
+		}
+		if c.Checkpoints == nil && c.StateDir == "" {
+			errs.Addf("CatchUpFromArchive", "requires Checkpoints or StateDir to resume from")
+		}
+	}
+
+	c.Chaos.validate(&errs)
+	if c.Chaos.enabled() && c.CatchUpAfter > 0 {
+		errs.Addf("Chaos", "conflicts with CatchUpAfter, whose polls don't honor X-Poll-Interval")
+	}
+
+	if c.GHArchiveURL != "" {
+		errs.Add("GHArchiveURL", validateHTTPURL(c.GHArchiveURL))
+	}
+	if c.GHArchiveSpeed < 0 {
+		errs.Addf("GHArchiveSpeed", "must not be negative")
+	}
+	if c.GHArchiveFrom.IsZero() {
+		if !c.GHArchiveTo.IsZero() {
+			errs.Addf("GHArchiveTo", "requires GHArchiveFrom")
+		}
+	} else {
+		if !c.GHArchiveTo.IsZero() && !c.GHArchiveFrom.Before(c.GHArchiveTo) {
+			errs.Addf("GHArchiveTo", "not after GHArchiveFrom (%s)", c.GHArchiveFrom.Format(time.RFC3339))
+		}
+		if c.WebhookAddr != "" {
+			errs.Addf("GHArchiveFrom", "conflicts with WebhookAddr %s", c.WebhookAddr)
+		}
+		if len(c.Sources) > 0 {
+			errs.Addf("Sources", "archive feeds (GHArchiveFrom) don't poll sources")
+		}
+	}
+
+	return errs.Err()
+}
+
+func validateHTTPURL(rawurl string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("expected an http(s) URL")
+	}
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package lib_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+func TestConfigValidate(t *testing.T) {
+	if err := (&lib.Config{}).Validate(); err != nil {
+		t.Errorf("expected the zero configuration to be valid, got %v", err)
+	}
+
+	conf := &lib.Config{
+		BaseURL:         "ftp://example.com",
+		FallbackURLs:    []string{"https://example.com", "http://"},
+		AuthTokens:      []string{"a", " "},
+		QueueSize:       -1,
+		RetryBackoff:    time.Hour,
+		MaxRetryBackoff: time.Minute,
+		Sources:         []lib.Source{{Type: "team"}},
+		Filter:          lib.EventFilter{Repos: []string{"a/b", ""}, Expression: "a=("},
+		WebhookAddr:     ":8080",
+		MetricsAddr:     ":8080",
+	}
+	err := conf.Validate()
+
+	var errs lib.ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ConfigErrors, got %v", err)
+	}
+	var fields []string
+	for _, e := range errs {
+		fields = append(fields, e.Field)
+	}
+	expected := []string{
+		"BaseURL", "FallbackURLs[1]", "AuthTokens[1]", "QueueSize", "RetryBackoff",
+		"Sources[0]", "Filter.Repos[1]", "Filter.Expression",
+		"WebhookSecret", "Sources", "MetricsAddr",
+	}
+	if strings.Join(fields, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected problems of %v, got %v", expected, fields)
+	}
+	if !errors.Is(errs[8], lib.ErrWebhookSecret) {
+		t.Errorf("expected ErrWebhookSecret, got %v", errs[8])
+	}
+	if !strings.HasPrefix(err.Error(), "invalid configuration, 11 problems:\n  BaseURL: ") {
+		t.Errorf("unexpected message %q", err)
+	}
+
+	if _, _, err := lib.NewEventFeed(context.Background(), conf); !errors.As(err, &errs) {
+		t.Errorf("expected NewEventFeed to validate the configuration, got %v", err)
+	}
+}
+
+func TestValidateSinkURL(t *testing.T) {
+	for rawurl, valid := range map[string]bool{
+		"-":                   true,
+		"file:///tmp/events":  true,
+		"kafka://h:9092/t":    true,
+		"kafka://h:9092/":     false,
+		"ftp://example.com/x": false,
+	} {
+		if err := lib.ValidateSinkURL(rawurl); (err == nil) != valid {
+			t.Errorf("ValidateSinkURL(%s): got %v", rawurl, err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	watchEventType = "WatchEvent"
+	// Velocities are counted over an hour, acceleration compares it with the
+	// hour before.
+	velocityWindow = time.Hour
+)
+
+// StarVelocityRecord reports the stars of a repository over the last hour of
+// events, emitted whenever it is starred.
+type StarVelocityRecord struct {
+	SchemaVersion int    `json:"schema_version"`
+	Repo          string `json:"repo"`
+	// Stars received in the batch which triggered the record.
+	Stars int `json:"stars"`
+	// Stars received in the hour up to Time.
+	StarsPerHour int `json:"stars_per_hour"`
+	// StarsPerHour minus the stars of the hour before, positive when the
+	// repository is gaining popularity.
+	Acceleration int       `json:"acceleration"`
+	Time         time.Time `json:"time"`
+}
+
+// StarVelocityTracker maintains rolling star counts from the WatchEvents
+// (starring a repository is a WatchEvent, for historical reasons) of a set
+// of repositories. An empty set tracks every repository. Windows are
+// measured with the events' creation time, so that replayed archives give
+// the same records. Repositories unstarred for two windows are forgotten.
+type StarVelocityTracker struct {
+	repos map[string]bool
+	stars map[string][]time.Time
+}
+
+func NewStarVelocityTracker(repos []string) *StarVelocityTracker {
+	t := &StarVelocityTracker{repos: make(map[string]bool, len(repos)), stars: make(map[string][]time.Time)}
+	for _, repo := range repos {
+		t.repos[strings.ToLower(repo)] = true
+	}
+
+	return t
+}
+
+func (t *StarVelocityTracker) tracks(repo string) bool {
+	return len(t.repos) == 0 || t.repos[strings.ToLower(repo)]
+}
+
+// Track returns a record for each repository starred in the batch, sorted by
+// repository.
+func (t *StarVelocityTracker) Track(events []*github.Event) []*StarVelocityRecord {
+	// Keyed by lower case name, as GitHub names are case insensitive.
+	starred := make(map[string]int)
+	names := make(map[string]string)
+	var latest time.Time
+
+	for _, ev := range events {
+		repo := ev.GetRepo().GetName()
+		if ev.GetType() != watchEventType || repo == "" || !t.tracks(repo) {
+			continue
+		}
+
+		key := strings.ToLower(repo)
+		created := ev.GetCreatedAt()
+		t.stars[key] = append(t.stars[key], created)
+		starred[key]++
+		names[key] = repo
+		if created.After(latest) {
+			latest = created
+		}
+	}
+
+	if len(starred) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(starred))
+	for key := range starred {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	records := make([]*StarVelocityRecord, 0, len(keys))
+	for _, key := range keys {
+		record := &StarVelocityRecord{SchemaVersion: SchemaVersion, Repo: names[key], Stars: starred[key], Time: latest.UTC()}
+
+		previous := 0
+		for _, star := range t.stars[key] {
+			switch age := latest.Sub(star); {
+			case age < velocityWindow:
+				record.StarsPerHour++
+			case age < 2*velocityWindow:
+				previous++
+			}
+		}
+		record.Acceleration = record.StarsPerHour - previous
+
+		records = append(records, record)
+	}
+
+	t.forget(latest.Add(-2 * velocityWindow))
+	return records
+}
+
+// forget drops the stars received before the given time.
+func (t *StarVelocityTracker) forget(before time.Time) {
+	for repo, stars := range t.stars {
+		kept := stars[:0]
+		for _, star := range stars {
+			if !star.Before(before) {
+				kept = append(kept, star)
+			}
+		}
+
+		if len(kept) == 0 {
+			delete(t.stars, repo)
+		} else {
+			t.stars[repo] = kept
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package lib_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func TestStarVelocityTracker(t *testing.T) {
+	start := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	tracker := lib.NewStarVelocityTracker([]string{"octocat/hello-world"})
+
+	var batch []*github.Event
+	for i := 0; i < 2; i++ {
+		batch = append(batch, testEvent("", "WatchEvent", "", "octocat/hello-world", start.Add(time.Duration(i)*time.Minute), ""))
+	}
+	batch = append(batch, testEvent("", "WatchEvent", "", "other/repo", start, ""), &github.Event{Type: github.String("PushEvent")})
+	if records := tracker.Track(batch); len(records) != 1 || records[0].StarsPerHour != 2 || records[0].Acceleration != 2 {
+		t.Fatalf("unexpected records %+v", records)
+	}
+
+	// An hour later, 5 stars against 2 the hour before.
+	batch = nil
+	for i := 0; i < 5; i++ {
+		batch = append(batch, testEvent("", "WatchEvent", "", "Octocat/Hello-World", start.Add(time.Hour+time.Duration(i)*time.Minute), ""))
+	}
+	records := tracker.Track(batch)
+	if len(records) != 1 {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if r := records[0]; r.Stars != 5 || r.StarsPerHour != 5 || r.Acceleration != 3 {
+		t.Errorf("got %d stars, %d per hour, acceleration %d; want 5, 5, 3", r.Stars, r.StarsPerHour, r.Acceleration)
+	}
+}
@@ -0,0 +1,236 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/go-github/v32/github"
+)
+
+const (
+	// GitHub caps the payloads of webhook deliveries to 25MB.
+	maxWebhookPayload = 25 << 20
+	webhookPing       = "ping"
+	// Sent next to X-Hub-Signature (SHA-1), which go-github checks.
+	xHubSignature256Header = "X-Hub-Signature-256"
+)
+
+// ErrWebhookSecret is returned by NewWebhookFeed without a secret, unsigned
+// deliveries can't be told apart from forged ones.
+var ErrWebhookSecret = errors.New("webhook feeds require a secret")
+
+// NewWebhookFeed returns a feed publishing the deliveries of GitHub webhooks
+// (of organizations, repositories or apps) instead of polling the events
+// API: Serve listens on conf.WebhookAddr, and every delivery whose signature
+// matches conf.WebhookSecret is published as a batch of one event, without
+// polling latency. The feed is otherwise the same as NewEventFeed's, its
+// client and errors included, so consumers handle either source alike.
+//
+// Deliveries are converted to events as the events API would return them:
+// the type is derived from X-GitHub-Event (pull_request gives
+// PullRequestEvent), the ID is the delivery's GUID (redeliveries keep it),
+// the actor, repository and organization are the delivery's sender,
+// repository and organization, and the creation time is the reception time.
+// The payload is the webhook's, richer than the events API's.
+func NewWebhookFeed(ctx context.Context, conf *Config) (*EventFeed, <-chan []*github.Event, error) {
+	if conf.WebhookSecret == "" {
+		return nil, nil, ErrWebhookSecret
+	}
+
+	feed, events, err := NewEventFeed(ctx, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	RegisterSecret(conf.WebhookSecret)
+	feed.webhook = &webhookHandler{feed: feed, addr: conf.WebhookAddr, secret: []byte(conf.WebhookSecret)}
+
+	return feed, events, nil
+}
+
+// NewFeed returns the feed of NewWebhookFeed if conf.WebhookAddr is set, of
+// NewArchiveFeed if conf.GHArchiveFrom is, of NewEventFeed otherwise.
+func NewFeed(ctx context.Context, conf *Config) (*EventFeed, <-chan []*github.Event, error) {
+	if conf.WebhookAddr != "" {
+		return NewWebhookFeed(ctx, conf)
+	}
+	if !conf.GHArchiveFrom.IsZero() {
+		return NewArchiveFeed(ctx, conf)
+	}
+	return NewEventFeed(ctx, conf)
+}
+
+// WebhookHandler returns the handler receiving the deliveries of a feed
+// created by NewWebhookFeed, nil for polling feeds. It may be mounted on
+// another server instead of calling Serve, which closes the events channel
+// when done.
+func (f *EventFeed) WebhookHandler() http.Handler {
+	if f.webhook == nil {
+		return nil
+	}
+	return f.webhook
+}
+
+type webhookHandler struct {
+	feed   *EventFeed
+	addr   string
+	secret []byte
+}
+
+// serveWebhooks receives deliveries until the feed's context is done.
+func (f *EventFeed) serveWebhooks() error {
+	defer f.closeEvents()
+
+	server := &http.Server{Addr: f.webhook.addr, Handler: f.webhook}
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-f.ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	l, err := net.Listen("tcp", f.webhook.addr)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&f.metrics.listening, 1)
+
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+
+	// The handlers in flight complete before the events channel is closed.
+	<-shutdown
+	return f.ctx.Err()
+}
+
+func (h *webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.feed.Paused() {
+		http.Error(w, "paused", http.StatusServiceUnavailable)
+		return
+	}
+
+	delivery := github.DeliveryID(r)
+	if sig := r.Header.Get(xHubSignature256Header); sig != "" {
+		r.Header.Set("X-Hub-Signature", sig)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookPayload)
+	payload, err := github.ValidatePayload(r, h.secret)
+	if err != nil {
+		h.feed.ReportError(OpParse, delivery, fmt.Errorf("rejected webhook delivery: %w", err))
+		http.Error(w, "invalid delivery", http.StatusBadRequest)
+		return
+	}
+
+	hook := github.WebHookType(r)
+	if hook == webhookPing {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	ev, err := webhookEvent(hook, delivery, payload)
+	if err != nil {
+		h.feed.ReportError(OpParse, delivery, err)
+		http.Error(w, "invalid delivery", http.StatusBadRequest)
+		return
+	}
+	created := h.feed.clock.Now().UTC()
+	ev.CreatedAt = &created
+
+	batch := []*github.Event{ev}
+	h.feed.checkEvents(batch)
+	if batch = h.feed.filterEvents(batch); len(batch) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	// A full queue holds the delivery back, GitHub retries those timing out.
+	if err := h.feed.publish(r.Context(), batch); err == nil {
+		w.WriteHeader(http.StatusAccepted)
+	} else if h.feed.ctx.Err() != nil {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	}
+}
+
+// webhookEventType returns the events API type of a webhook event, e.g.
+// PullRequestReviewEvent for pull_request_review.
+func webhookEventType(hook string) string {
+	var b strings.Builder
+	for _, word := range strings.Split(hook, "_") {
+		if word != "" {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String() + "Event"
+}
+
+// webhookHook returns the webhook event of an events API type, the reverse
+// of webhookEventType, e.g. pull_request_review for PullRequestReviewEvent.
+func webhookHook(eventType string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSuffix(eventType, "Event") {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type webhookEnvelope struct {
+	Repository *struct {
+		ID       *int64  `json:"id"`
+		FullName *string `json:"full_name"`
+		URL      *string `json:"url"`
+		Private  *bool   `json:"private"`
+	} `json:"repository"`
+	Sender       *github.User         `json:"sender"`
+	Organization *github.Organization `json:"organization"`
+}
+
+// webhookEvent converts a delivery into an event, without creation time.
+func webhookEvent(hook, delivery string, payload []byte) (*github.Event, error) {
+	if hook == "" || delivery == "" {
+		return nil, errors.New("webhook delivery lacks its event type or ID")
+	}
+
+	var envelope webhookEnvelope
+	if err := json.Unmarshal(payload, &envelope); err != nil {
+		return nil, fmt.Errorf("invalid %s payload: %w", hook, err)
+	}
+
+	raw := json.RawMessage(payload)
+	ev := &github.Event{
+		ID:         github.String(delivery),
+		Type:       github.String(webhookEventType(hook)),
+		RawPayload: &raw,
+	}
+
+	// Trimmed to the fields of the events API.
+	if s := envelope.Sender; s != nil {
+		ev.Actor = &github.User{ID: s.ID, Login: s.Login, URL: s.URL, AvatarURL: s.AvatarURL}
+	}
+	if o := envelope.Organization; o != nil {
+		ev.Org = &github.Organization{ID: o.ID, Login: o.Login, URL: o.URL, AvatarURL: o.AvatarURL}
+	}
+	if repo := envelope.Repository; repo != nil {
+		ev.Repo = &github.Repository{ID: repo.ID, Name: repo.FullName, URL: repo.URL}
+		ev.Public = github.Bool(repo.Private == nil || !*repo.Private)
+	}
+
+	return ev, nil
+}
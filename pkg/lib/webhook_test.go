@@ -0,0 +1,138 @@
+package lib_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+func webhookDelivery(hook, payload, secret string) *http.Request {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-GitHub-Event", hook)
+	r.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+	r.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestWebhookFeed(t *testing.T) {
+	if _, _, err := lib.NewWebhookFeed(context.Background(), &lib.Config{WebhookAddr: ":0"}); !errors.Is(err, lib.ErrWebhookSecret) {
+		t.Errorf("expected ErrWebhookSecret without secret, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	feed, events, err := lib.NewWebhookFeed(ctx, &lib.Config{WebhookAddr: "127.0.0.1:0", WebhookSecret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := feed.WebhookHandler()
+
+	payload := `{"action":"opened","pull_request":{"number":1},` +
+		`"repository":{"id":1296269,"full_name":"octocat/Hello-World","url":"https://api.github.com/repos/octocat/Hello-World","private":false},` +
+		`"sender":{"login":"octocat","id":1,"type":"User","site_admin":false},"organization":{"login":"github","id":9919}}`
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, webhookDelivery("pull_request", payload, "s3cret"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("delivery answered %d: %s", w.Code, w.Body)
+	}
+
+	batch := <-events
+	if len(batch) != 1 {
+		t.Fatalf("got a batch of %d events, want 1", len(batch))
+	}
+	ev := batch[0]
+	if ev.GetType() != "PullRequestEvent" || ev.GetID() != "72d3162e-cc78-11e3-81ab-4c9367dc0958" ||
+		ev.GetActor().GetLogin() != "octocat" || ev.GetRepo().GetName() != "octocat/Hello-World" ||
+		ev.GetOrg().GetLogin() != "github" || !ev.GetPublic() || ev.CreatedAt == nil {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if ev.GetActor().Type != nil {
+		t.Errorf("expected the actor trimmed to the events API fields, got %+v", ev.GetActor())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, webhookDelivery("pull_request", payload, "forged"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("forged delivery answered %d", w.Code)
+	}
+	if err := <-feed.Errors(); !strings.Contains(err.Error(), "signature") {
+		t.Errorf("expected the forged delivery to be reported, got %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, webhookDelivery("ping", `{"zen":"Keep it logically awesome."}`, "s3cret"))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("ping answered %d", w.Code)
+	}
+
+	served := make(chan error)
+	go func() { served <- feed.Serve() }()
+	cancel()
+	if err := <-served; !errors.Is(err, context.Canceled) {
+		t.Errorf("Serve returned %v, want context.Canceled", err)
+	}
+	if _, ok := <-events; ok {
+		t.Error("expected the events channel to be closed")
+	}
+}
+
+func TestWebhookProxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	upstream, received, err := lib.NewWebhookFeed(ctx, &lib.Config{WebhookAddr: "127.0.0.1:0", WebhookSecret: "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The downstream receiver is another feed, with its own secret.
+	downstream, proxied, err := lib.NewWebhookFeed(ctx, &lib.Config{WebhookAddr: "127.0.0.1:0", WebhookSecret: "downstream"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(downstream.WebhookHandler())
+	defer server.Close()
+
+	payload := `{"action":"submitted","review":{"id":80},` +
+		`"repository":{"id":1296269,"full_name":"octocat/Hello-World","private":false},"sender":{"login":"octocat","id":1}}`
+	w := httptest.NewRecorder()
+	upstream.WebhookHandler().ServeHTTP(w, webhookDelivery("pull_request_review", payload, "s3cret"))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("delivery answered %d: %s", w.Code, w.Body)
+	}
+
+	os.Setenv("GITHUB_FEED_PROXY_SECRET", "downstream")
+	defer os.Unsetenv("GITHUB_FEED_PROXY_SECRET")
+	sink, err := lib.OpenSink("webhook+"+server.URL+"?secret-env=GITHUB_FEED_PROXY_SECRET", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(ctx, <-received); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := (<-proxied)[0]
+	if ev.GetType() != "PullRequestReviewEvent" || ev.GetID() != "72d3162e-cc78-11e3-81ab-4c9367dc0958" ||
+		ev.GetRepo().GetName() != "octocat/Hello-World" || string(*ev.RawPayload) != payload {
+		t.Errorf("got proxied event %+v", ev)
+	}
+
+	// Rejected deliveries fail the write.
+	unsigned := lib.NewWebhookSink(server.URL)
+	if err := unsigned.Write(ctx, []*github.Event{ev}); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("got %v, want the unsigned delivery rejected", err)
+	}
+}
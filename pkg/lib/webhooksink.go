@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/go-github/v32/github"
+)
+
+// WebhookSink re-emits each event as a GitHub webhook delivery to a
+// receiver, so that a feed receiving webhooks (see NewWebhookFeed) proxies
+// them, filtered and transformed, to any number of receivers: the body is
+// the event's payload, X-GitHub-Event its webhook event (PullRequestEvent
+// gives pull_request), X-GitHub-Delivery its ID, the delivery GUID of the
+// received webhooks, and the payload is signed with Secret in
+// X-Hub-Signature-256 and X-Hub-Signature, as GitHub does. Receivers
+// written for GitHub's webhooks take the deliveries as is, and drop the
+// redeliveries by their GUID.
+//
+// Responses other than 2xx fail the write, retried like the other sinks' by
+// a DeadLetterSink. The deliveries of a batch are sent in order.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+	// Secret signing the deliveries, unsigned if empty.
+	Secret []byte
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: defaultOutputTimeout}}
+}
+
+func (s *WebhookSink) Write(ctx context.Context, events []*github.Event) error {
+	for _, ev := range events {
+		if err := s.deliver(ctx, ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *WebhookSink) deliver(ctx context.Context, ev *github.Event) error {
+	payload := []byte("{}")
+	if ev.RawPayload != nil {
+		payload = *ev.RawPayload
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", webhookHook(ev.GetType()))
+	req.Header.Set("X-GitHub-Delivery", ev.GetID())
+	if len(s.Secret) > 0 {
+		req.Header.Set(xHubSignature256Header, "sha256="+hmacHex(sha256.New, s.Secret, payload))
+		req.Header.Set("X-Hub-Signature", "sha1="+hmacHex(sha1.New, s.Secret, payload))
+	}
+	Identify(req)
+
+	rep, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(rep.Body, 512))
+		return fmt.Errorf("deliver %s to %s: %s: %s", ev.GetID(), Redact(s.url), rep.Status, bytes.TrimSpace(body))
+	}
+
+	io.Copy(ioutil.Discard, rep.Body)
+	return nil
+}
+
+func (s *WebhookSink) Hosts() []string {
+	return urlHost(s.url)
+}
+
+func (s *WebhookSink) Close() error {
+	return nil
+}
+
+func hmacHex(h func() hash.Hash, key, data []byte) string {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
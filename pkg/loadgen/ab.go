@@ -0,0 +1,135 @@
+package loadgen
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	abTarget = Flags.String("ab-target", "",
+		"Base URL (scheme://host[:port]) of a B target, enables the A/B mode where traffic is split between "+
+			"the mapped target (A) and B, and their steady-state results are compared.")
+	abSplit = Flags.String("ab-split", "actor",
+		"How traffic is split in A/B mode, 'actor' keeps each actor (and its session) on one target, "+
+			"'alternate' alternates requests.")
+)
+
+// An arm is one of the target configurations of a run. Without A/B mode,
+// there's a single arm sending to the mapped URLs.
+type arm struct {
+	name  string
+	base  *url.URL
+	stats *runStats
+}
+
+func (r *Runner) setupArms(warmup time.Duration) error {
+	r.arms = []*arm{{name: "A", stats: newRunStats(warmup)}}
+
+	if *abTarget == "" {
+		return nil
+	}
+
+	if *abSplit != "actor" && *abSplit != "alternate" {
+		return fmt.Errorf("invalid -ab-split '%s', expected actor or alternate", *abSplit)
+	}
+
+	base, err := url.Parse(*abTarget)
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return fmt.Errorf("invalid -ab-target '%s', expected scheme://host[:port]", *abTarget)
+	}
+
+	r.arms = append(r.arms, &arm{name: "B", base: base, stats: newRunStats(warmup)})
+	return nil
+}
+
+func (r *Runner) pickArm(user string) *arm {
+	if len(r.arms) == 1 {
+		return r.arms[0]
+	}
+
+	if *abSplit == "alternate" {
+		return r.arms[atomic.AddUint64(&r.armsCount, 1)%uint64(len(r.arms))]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(user))
+	return r.arms[h.Sum32()%uint32(len(r.arms))]
+}
+
+// rewrite points the request to the arm's target.
+func (a *arm) rewrite(req *http.Request) {
+	if a.base == nil {
+		return
+	}
+
+	req.URL.Scheme = a.base.Scheme
+	req.URL.Host = a.base.Host
+	req.Host = ""
+}
+
+func (r *Runner) drainArms() {
+	for _, a := range r.arms {
+		a.stats.drain()
+	}
+}
+
+func (r *Runner) reportArms(w io.Writer) {
+	if r.abortReason != "" {
+		fmt.Fprintf(w, "Partial report, run aborted: %s\n", r.abortReason)
+	}
+
+	for _, a := range r.arms {
+		if len(r.arms) > 1 {
+			fmt.Fprintf(w, "Target %s: ", a.name)
+		}
+		a.stats.report(w)
+	}
+
+	if len(r.arms) == 2 {
+		compareArms(w, &r.arms[0].stats.phases[phaseSteady], &r.arms[1].stats.phases[phaseSteady])
+	}
+}
+
+// z-score of the 95% confidence intervals.
+const z95 = 1.96
+
+// compareArms reports the difference (B - A) of the mean latency and error
+// rate with 95% confidence intervals, using Welch's normal approximation.
+func compareArms(w io.Writer, a, b *phaseStats) {
+	na, meanA, varA := a.moments()
+	nb, meanB, varB := b.moments()
+	if na < 2 || nb < 2 {
+		fmt.Fprintln(w, "Not enough steady-state samples to compare targets")
+		return
+	}
+
+	diff := meanB - meanA
+	se := math.Sqrt(varA/float64(na) + varB/float64(nb))
+	fmt.Fprintf(w, "Mean latency B-A: %v [%v, %v] (A %v, B %v)%s\n",
+		seconds(diff), seconds(diff-z95*se), seconds(diff+z95*se), seconds(meanA), seconds(meanB),
+		significance(diff, se))
+
+	ra, pa := a.errorRate()
+	rb, pb := b.errorRate()
+	ediff := pb - pa
+	ese := math.Sqrt(pa*(1-pa)/float64(ra) + pb*(1-pb)/float64(rb))
+	fmt.Fprintf(w, "Error rate B-A: %+.3f%% [%+.3f%%, %+.3f%%] (A %.3f%%, B %.3f%%)%s\n",
+		100*ediff, 100*(ediff-z95*ese), 100*(ediff+z95*ese), 100*pa, 100*pb, significance(ediff, ese))
+}
+
+func significance(diff, se float64) string {
+	if se > 0 && math.Abs(diff) > z95*se {
+		return " significant"
+	}
+	return ""
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond)
+}
@@ -0,0 +1,91 @@
+package loadgen
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+var (
+	gzipBodies  = Flags.Bool("gzip", false, "Compress request bodies with gzip (Content-Encoding: gzip).")
+	contentType = Flags.String("content-type", "json",
+		"Encoding of the identify request body: json, form or protobuf.")
+)
+
+// Encoders of the identify payload. The protobuf encoding corresponds to
+//
+//	message Identify { repeated string ids = 1; }
+var idsEncoders = map[string]struct {
+	mime   string
+	encode func(ids []string) ([]byte, error)
+}{
+	"json": {"application/json", func(ids []string) ([]byte, error) { return json.Marshal(ids) }},
+	"form": {"application/x-www-form-urlencoded", func(ids []string) ([]byte, error) {
+		return []byte(url.Values{"id": ids}.Encode()), nil
+	}},
+	"protobuf": {"application/x-protobuf", encodeIdsProtobuf},
+}
+
+func encodeIdsProtobuf(ids []string) ([]byte, error) {
+	var buf bytes.Buffer
+	varint := make([]byte, binary.MaxVarintLen64)
+
+	for _, id := range ids {
+		// Field 1, wire type 2 (length delimited).
+		buf.WriteByte(1<<3 | 2)
+		buf.Write(varint[:binary.PutUvarint(varint, uint64(len(id)))])
+		buf.WriteString(id)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func validateContentType() error {
+	if _, ok := idsEncoders[*contentType]; !ok {
+		return fmt.Errorf("invalid -content-type '%s', expected json, form or protobuf", *contentType)
+	}
+	return nil
+}
+
+func encodeIds(ids []string) (body []byte, mime string, err error) {
+	encoder := idsEncoders[*contentType]
+	body, err = encoder.encode(ids)
+	return body, encoder.mime, err
+}
+
+// compressBody gzips the request body in place when -gzip is set, as our
+// production clients do.
+func compressBody(req *http.Request) error {
+	if !*gzipBodies || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(body)
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	compressed := buf.Bytes()
+	req.Body = ioutil.NopCloser(bytes.NewReader(compressed))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(compressed)), nil
+	}
+	req.ContentLength = int64(len(compressed))
+	req.Header.Set("Content-Encoding", "gzip")
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	feed "github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+var (
+	healthURL = Flags.String("health-url", "",
+		"URL probed before starting the run, defaults to every -target. Set to 'none' to skip the probe.")
+	abortErrorRate = Flags.Float64("abort-error-rate", 0,
+		"Abort the run when the error rate of -abort-windows consecutive windows exceeds this fraction, 0 disables.")
+	abortP99 = Flags.Duration("abort-p99", 0,
+		"Abort the run when the p99 latency of -abort-windows consecutive windows exceeds this duration, 0 disables.")
+	abortWindows = Flags.Int("abort-windows", 3, "Number of consecutive unhealthy windows before aborting.")
+	abortWindow  = Flags.Duration("abort-window", time.Minute, "Duration of the windows evaluated for aborting.")
+)
+
+const healthProbeTimeout = 10 * time.Second
+
+// precheck probes every target before the run starts, a target answering
+// with a server error (or not answering) is considered broken.
+func (r *Runner) precheck() error {
+	var urls []string
+	switch *healthURL {
+	case "none":
+		return nil
+	case "":
+		for _, t := range r.targets {
+			urls = append(urls, t.String())
+		}
+	default:
+		urls = []string{*healthURL}
+	}
+
+	client := &http.Client{Timeout: healthProbeTimeout}
+	for _, a := range r.arms {
+		for _, u := range urls {
+			req, err := http.NewRequest(http.MethodGet, u, nil)
+			if err != nil {
+				return err
+			}
+			a.rewrite(req)
+			feed.Identify(req)
+
+			rep, err := client.Do(req)
+			if err != nil {
+				return fmt.Errorf("target %s is unreachable: %v", a.name, err)
+			}
+			rep.Body.Close()
+
+			if rep.StatusCode >= 500 {
+				return fmt.Errorf("target %s is unhealthy: %s answered %s", a.name, req.URL, rep.Status)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) abort(cancel context.CancelFunc, reason string) {
+	r.abortOnce.Do(func() {
+		r.abortReason = reason
+		log.Printf("Aborting run: %s", reason)
+		cancel()
+	})
+}
+
+// windowHealth returns why the window is unhealthy, if it is.
+func windowHealth(w *phaseStats) (string, bool) {
+	requests, rate := w.errorRate()
+	if requests == 0 {
+		return "", true
+	}
+
+	if *abortErrorRate > 0 && rate > *abortErrorRate {
+		return fmt.Sprintf("error rate %.2f%% above %.2f%%", 100*rate, 100**abortErrorRate), false
+	}
+
+	if *abortP99 > 0 {
+		w.mu.Lock()
+		sorted := append([]time.Duration(nil), w.samples...)
+		w.mu.Unlock()
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		if p99 := percentile(sorted, 0.99); p99 > *abortP99 {
+			return fmt.Sprintf("p99 latency %v above %v", p99, *abortP99), false
+		}
+	}
+
+	return "", true
+}
+
+// monitor evaluates the health of every target at the end of each window and
+// aborts the run after too many consecutive unhealthy windows.
+func (r *Runner) monitor(ctx context.Context, cancel context.CancelFunc) {
+	if *abortErrorRate <= 0 && *abortP99 <= 0 {
+		return
+	}
+
+	unhealthy := make([]int, len(r.arms))
+
+	ticker := time.NewTicker(*abortWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for i, a := range r.arms {
+				reason, ok := windowHealth(a.stats.rotateWindow())
+				if ok {
+					unhealthy[i] = 0
+					continue
+				}
+
+				unhealthy[i]++
+				log.Printf("Target %s unhealthy (%d/%d): %s", a.name, unhealthy[i], *abortWindows, reason)
+				if unhealthy[i] >= *abortWindows {
+					r.abort(cancel, fmt.Sprintf("target %s: %s for %d windows", a.name, reason, unhealthy[i]))
+					return
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
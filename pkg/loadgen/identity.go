@@ -0,0 +1,187 @@
+package loadgen
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	feed "github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// IDExtractor returns the identifiers of a kind found in an event, e.g. the
+// hashed commit emails, without namespace.
+type IDExtractor func(user string, event *feed.TypedEvent) []string
+
+type idKind struct {
+	prefix  string
+	extract IDExtractor
+}
+
+var idKinds = map[string]idKind{
+	"login":     {"c", func(user string, _ *feed.TypedEvent) []string { return []string{user} }},
+	"email":     {"e", commitEmailIDs(hashEmail)},
+	"github-id": {"g", githubIDs},
+	"domain":    {"d", commitEmailIDs(emailDomain)},
+}
+
+// RegisterIDKind makes a kind of identifier available to -id-namespaces,
+// written in its default namespace prefix unless the flag gives another.
+func RegisterIDKind(kind, prefix string, extract IDExtractor) {
+	if _, dup := idKinds[kind]; dup {
+		panic("duplicate id kind " + kind)
+	}
+	if err := validPrefix(prefix); err != nil {
+		panic(err)
+	}
+	idKinds[kind] = idKind{prefix, extract}
+}
+
+func idKindNames() string {
+	names := make([]string, 0, len(idKinds))
+	for name := range idKinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Namespaces are separated from the identifiers by a colon, which the
+// prefixes can't contain so that an identifier belongs to a single one.
+func validPrefix(prefix string) error {
+	if prefix == "" || strings.ContainsAny(prefix, ": ,=") {
+		return fmt.Errorf("invalid id namespace '%s', expected a non-empty prefix without ':', ' ', ',' or '='", prefix)
+	}
+	return nil
+}
+
+type idNamespace struct {
+	kind   string
+	prefix string
+}
+
+// idNamespaces implements flag.Value for `-id-namespaces login=c,email=e`,
+// the kinds of identifiers sent for the events, in order, and their
+// namespaces.
+type idNamespaces struct {
+	namespaces []idNamespace
+	set        bool
+}
+
+func (n *idNamespaces) String() string {
+	if n == nil {
+		return ""
+	}
+	specs := make([]string, len(n.namespaces))
+	for i, ns := range n.namespaces {
+		specs[i] = ns.kind + "=" + ns.prefix
+	}
+	return strings.Join(specs, ",")
+}
+
+func (n *idNamespaces) Set(value string) error {
+	if !n.set {
+		n.namespaces, n.set = nil, true
+	}
+
+	for _, spec := range strings.Split(value, ",") {
+		kind, prefix := spec, ""
+		if i := strings.Index(spec, "="); i >= 0 {
+			kind, prefix = spec[:i], spec[i+1:]
+		}
+
+		k, ok := idKinds[kind]
+		if !ok {
+			return fmt.Errorf("unknown id kind '%s', expected %s", kind, idKindNames())
+		}
+		if prefix == "" {
+			prefix = k.prefix
+		}
+		if err := validPrefix(prefix); err != nil {
+			return err
+		}
+
+		for _, ns := range n.namespaces {
+			if ns.kind == kind {
+				return fmt.Errorf("duplicate id kind '%s'", kind)
+			}
+			if ns.prefix == prefix {
+				return fmt.Errorf("id kinds %s and %s share the namespace '%s'", ns.kind, kind, prefix)
+			}
+		}
+		n.namespaces = append(n.namespaces, idNamespace{kind, prefix})
+	}
+
+	return nil
+}
+
+func (n *idNamespaces) List() bool { return true }
+
+var namespaces = idNamespaces{namespaces: []idNamespace{{"login", "c"}, {"email", "e"}}}
+
+func init() {
+	Flags.Var(&namespaces, "id-namespaces",
+		"Comma separated kinds of identifiers sent for each event and their namespace prefix, kind=prefix or kind "+
+			"for its default: login (c), email (hashed commit and co-author emails, e), github-id (actor ID, g) or domain "+
+			"(their domains, d), e.g. 'login=user,email,github-id'.")
+}
+
+// gatherIds returns the identifiers of an event, namespace:id, in the order
+// of -id-namespaces, without duplicates.
+func gatherIds(user string, event *feed.TypedEvent) []string {
+	ids := make([]string, 0, len(namespaces.namespaces))
+	seen := make(map[string]bool, 16)
+
+	for _, ns := range namespaces.namespaces {
+		for _, id := range idKinds[ns.kind].extract(user, event) {
+			id = ns.prefix + ":" + id
+			if id == ns.prefix+":" || seen[id] {
+				continue
+			}
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// commitEmailIDs returns an extractor of the public commit emails of a push,
+// those of the co-authors included, lowercased and formatted by format.
+func commitEmailIDs(format func(email string) string) IDExtractor {
+	return func(_ string, event *feed.TypedEvent) []string {
+		push, ok := event.Payload.(*github.PushEvent)
+		if !ok {
+			return nil
+		}
+
+		var ids []string
+		for _, commit := range push.Commits {
+			emails := []string{commit.GetAuthor().GetEmail()}
+			for _, coAuthor := range feed.CoAuthors(commit.GetMessage()) {
+				emails = append(emails, coAuthor.Email)
+			}
+			for _, email := range emails {
+				if email = strings.ToLower(email); matchEmail(email) {
+					ids = append(ids, format(email))
+				}
+			}
+		}
+		return ids
+	}
+}
+
+func emailDomain(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		return email[i+1:]
+	}
+	return ""
+}
+
+func githubIDs(_ string, event *feed.TypedEvent) []string {
+	if id := event.GetActor().GetID(); id != 0 {
+		return []string{strconv.FormatInt(id, 10)}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package loadgen
+
+import (
+	"strings"
+	"testing"
+
+	feed "github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// pushEvent is a push of octocat (ID 583231) with a commit of each email.
+func pushEvent(emails ...string) *feed.TypedEvent {
+	push := &github.PushEvent{}
+	for _, email := range emails {
+		push.Commits = append(push.Commits, &github.HeadCommit{
+			Author:  &github.CommitAuthor{Email: github.String(email)},
+			Message: github.String("Fix the build"),
+		})
+	}
+	return &feed.TypedEvent{
+		Event: &github.Event{
+			Type:  github.String("PushEvent"),
+			Actor: &github.User{Login: github.String("octocat"), ID: github.Int64(583231)},
+		},
+		Payload: push,
+	}
+}
+
+func TestIDNamespaces(t *testing.T) {
+	for _, c := range []struct {
+		flag, want, err string
+	}{
+		{"login,email", "login=c,email=e", ""},
+		{"github-id,domain", "github-id=g,domain=d", ""},
+		{"login=user,email=sha256,github-id=gh", "login=user,email=sha256,github-id=gh", ""},
+		{"login=e,email", "", "id kinds login and email share the namespace 'e'"},
+		{"domain=x,github-id=x", "", "id kinds domain and github-id share the namespace 'x'"},
+		{"login,login=user", "", "duplicate id kind 'login'"},
+		{"phone", "", "unknown id kind 'phone'"},
+		{"login=a:b", "", "invalid id namespace 'a:b'"},
+		{"login=a b", "", "invalid id namespace 'a b'"},
+	} {
+		var ns idNamespaces
+		err := ns.Set(c.flag)
+		if c.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+				t.Errorf("%s: got error %v, want %s", c.flag, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.flag, err)
+		} else if got := ns.String(); got != c.want {
+			t.Errorf("%s: got %s, want %s", c.flag, got, c.want)
+		}
+	}
+}
+
+func TestGatherIds(t *testing.T) {
+	defer func(old idNamespaces) { namespaces = old }(namespaces)
+
+	event := pushEvent("Octo@Example.com", "octo@example.com", "cat@example.com", "octocat@users.noreply.github.com", "")
+	for _, c := range []struct {
+		flag, user string
+		want       []string
+	}{
+		{"login,email", "octocat", []string{
+			"c:octocat", "e:" + hashEmail("octo@example.com"), "e:" + hashEmail("cat@example.com"),
+		}},
+		{"github-id,domain,login=user", "octocat", []string{"g:583231", "d:example.com", "user:octocat"}},
+		// The same identifier is sent once per namespace.
+		{"login,domain", "example.com", []string{"c:example.com", "d:example.com"}},
+	} {
+		namespaces = idNamespaces{}
+		if err := namespaces.Set(c.flag); err != nil {
+			t.Fatal(err)
+		}
+		if got := gatherIds(c.user, event); strings.Join(got, " ") != strings.Join(c.want, " ") {
+			t.Errorf("%s: got %v, want %v", c.flag, got, c.want)
+		}
+	}
+}
+
+func TestRegisterIDKind(t *testing.T) {
+	defer func(old idNamespaces) { namespaces = old }(namespaces)
+
+	RegisterIDKind("test-repo", "r", func(_ string, event *feed.TypedEvent) []string {
+		return []string{event.GetRepo().GetName()}
+	})
+	defer delete(idKinds, "test-repo")
+
+	event := pushEvent()
+	event.Repo = &github.Repository{Name: github.String("octo/cat")}
+
+	namespaces = idNamespaces{}
+	if err := namespaces.Set("test-repo,login=r"); err == nil {
+		t.Error("login shares the namespace r of test-repo")
+	}
+	namespaces = idNamespaces{}
+	if err := namespaces.Set("test-repo=repo,login"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(gatherIds("octocat", event), " "); got != "repo:octo/cat c:octocat" {
+		t.Errorf("got %s, want repo:octo/cat c:octocat", got)
+	}
+
+	for _, c := range []struct{ kind, prefix string }{{"login", "l"}, {"test-invalid", "a:b"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registered %s=%s", c.kind, c.prefix)
+				}
+			}()
+			RegisterIDKind(c.kind, c.prefix, githubIDs)
+		}()
+	}
+}
@@ -0,0 +1,330 @@
+package loadgen
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	feed "github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// Flags are the command line flags of the load generator, parsed by the
+// caller before NewRunner.
+var Flags = flag.NewFlagSet("loadgen", flag.ExitOnError)
+
+// commaList is a string flag holding a comma separated list, given as an
+// array by the -config files of the command.
+type commaList string
+
+func (l *commaList) String() string { return string(*l) }
+
+func (l *commaList) Set(v string) error {
+	*l = commaList(v)
+	return nil
+}
+
+func (l *commaList) List() bool { return true }
+
+func stringList(name, value, usage string) *string {
+	p := &value
+	Flags.Var((*commaList)(p), name, usage)
+	return p
+}
+
+var (
+	actorCache = Flags.String("actor-cache", "",
+		"State file caching actor types resolved with the Users API, enables bot detection by actor type.")
+	actorLookups   = Flags.Int("actor-lookups", 1000, "Maximum number of actor type lookups per hour.")
+	actorCacheSize = Flags.Int("actor-cache-size", 100000, "Maximum number of actor types cached.")
+	keyRates       perKeyRates
+	scenarioPath   = Flags.String("scenario", "",
+		"YAML scenario file describing the requests sent for each event, replacing the request mapper.")
+	mapperName = Flags.String("mapper", "identify", "Request mapper turning events into requests.")
+	warmup     = Flags.Duration("warmup", 0,
+		"Warm-up duration whose requests are excluded from the steady-state results.")
+	duration = Flags.Duration("duration", 0,
+		"Duration of the run after which in-flight requests are drained and a report printed, 0 runs until interrupted.")
+	drainTimeout = Flags.Duration("drain-timeout", 30*time.Second, "Maximum time waiting for in-flight requests.")
+)
+
+func init() {
+	Flags.Var(&keyRates, "per-key-rate",
+		"Comma separated request rates per key, e.g. 'actor=5/s,repo=30/m'. Requests exceeding a rate are delayed.")
+}
+
+// A Runner replays the feed against the targets, its state set up from the
+// Flags by NewRunner.
+type Runner struct {
+	// Accessed atomically, first for its alignment.
+	armsCount uint64
+	arms      []*arm
+
+	targets  Targets
+	limiter  *feed.Limiter
+	mapper   RequestMapper
+	scenario *Scenario
+
+	transports sourceTransports
+	sessions   sessionStats
+	bots       feed.BotDetector
+	// dispatcher parses the events and hands them to the -workers.
+	dispatcher *feed.Dispatcher
+
+	cMu     sync.RWMutex
+	cookies map[string]http.CookieJar
+
+	abortOnce   sync.Once
+	abortReason string
+}
+
+// NewRunner validates the flags and sets up the targets, the request mapper
+// or scenario, and the A/B arms of a run.
+func NewRunner() (*Runner, error) {
+	r := &Runner{
+		bots:    feed.LoginBotDetector{},
+		cookies: make(map[string]http.CookieJar),
+	}
+
+	if err := validateContentType(); err != nil {
+		return nil, err
+	}
+
+	if err := r.setupTargets(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if r.mapper, err = newMapper(*mapperName, r.targets); err != nil {
+		return nil, err
+	}
+
+	if *scenarioPath != "" {
+		if r.scenario, err = LoadScenario(*scenarioPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.setupArms(*warmup); err != nil {
+		return nil, err
+	}
+
+	if err := r.transports.setup(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Runner) clientFor(user string) *http.Client {
+	r.cMu.RLock()
+	jar, found := r.cookies[user]
+	r.cMu.RUnlock()
+
+	if !found {
+		jar, _ = cookiejar.New(nil)
+		r.cMu.Lock()
+		// In practice we should re-check, but here we don't really care.
+		r.cookies[user] = jar
+		r.cMu.Unlock()
+	}
+
+	return &http.Client{Jar: jar, Transport: r.transports.transportFor(user), Timeout: *targetTimeout}
+}
+
+var privateEmailMatcher = regexp.MustCompile(`(noreply.github.com$|\.local$)`)
+
+func matchEmail(email string) bool {
+	return email != "" && !privateEmailMatcher.MatchString(email)
+}
+
+func hashEmail(email string) string {
+	hashed := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(hashed[:])
+}
+
+func (r *Runner) send(c *http.Client, a *arm, req *http.Request) {
+	a.rewrite(req)
+
+	if err := compressBody(req); err != nil {
+		log.Printf("Error compressing request: %v", err)
+		return
+	}
+
+	feed.Identify(req)
+
+	sent := c.Jar.Cookies(req.URL)
+
+	rep, latency, err := r.do(context.Background(), c, req)
+	if err != nil {
+		a.stats.record(latency, true)
+		log.Printf("Error with request: %v", err)
+		return
+	}
+
+	defer rep.Body.Close()
+
+	a.stats.record(latency, rep.StatusCode != http.StatusOK)
+	r.sessions.check(sent, rep)
+
+	if rep.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(rep.Body)
+		log.Printf("Error with request %s %s code (%s): %s", req.Method, req.URL, rep.Status, body)
+		return
+	}
+}
+
+func (r *Runner) sendEvent(event *feed.TypedEvent) {
+	user := strings.ToLower(event.Actor.GetLogin())
+
+	if err := keyRates.wait(context.Background(), event.Event); err != nil {
+		return
+	}
+
+	c := r.clientFor(user)
+	a := r.pickArm(user)
+
+	if r.scenario != nil {
+		r.scenario.run(r, c, a, user, gatherIds(user, event), event)
+		return
+	}
+
+	reqs, err := r.mapper.Map(user, event)
+	if err != nil {
+		log.Printf("Error mapping event %s: %v", event.GetID(), err)
+		return
+	}
+
+	for _, req := range reqs {
+		r.send(c, a, req)
+	}
+}
+
+func (r *Runner) matchEvent(e *github.Event) bool {
+	return !r.bots.IsBot(e)
+}
+
+func (r *Runner) processEvent(event *feed.TypedEvent) {
+	if !r.matchEvent(event.Event) {
+		return
+	}
+
+	r.sendEvent(event)
+}
+
+func (r *Runner) startWorkers() {
+	r.dispatcher = feed.NewDispatcher()
+	r.dispatcher.Subscribe(feed.EventHandlerFunc(r.processEvent), feed.SubscribeOptions{Queue: *workers, Workers: *workers})
+}
+
+// processBatch queues the events of a batch, blocking while the workers are
+// busy, until the run ends.
+func (r *Runner) processBatch(ctx context.Context, batch []*github.Event) {
+	r.sessions.log()
+	log.Printf("Consuming %d events", len(batch))
+
+	r.dispatcher.Publish(ctx, batch)
+}
+
+// drain waits for in-flight requests, up to the drain timeout.
+func (r *Runner) drain() {
+	r.drainArms()
+
+	done := make(chan struct{})
+	go func() {
+		r.dispatcher.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(*drainTimeout):
+		log.Printf("Drain timed out after %v", *drainTimeout)
+	}
+}
+
+// Run replays the events of the feed configured by conf against the
+// targets until the run ends, then prints the report on stdout. It fails if
+// a target is unhealthy before the start, or if the feed fails.
+func (r *Runner) Run(conf *feed.Config) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	go func() {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		<-interrupt
+		cancel()
+	}()
+
+	if err := r.precheck(); err != nil {
+		return fmt.Errorf("target pre-check failed: %v", err)
+	}
+
+	go r.monitor(ctx, cancel)
+
+	// The feed outlives the run's context, which ends with the run while
+	// in-flight requests are drained.
+	eventFeed, events, err := feed.NewFeed(context.Background(), conf)
+	if err != nil {
+		return err
+	}
+
+	if *actorCache != "" {
+		enricher, err := feed.NewActorTypeEnricher(eventFeed.Client(), *actorCache, *actorLookups, feed.CacheConfig{MaxEntries: *actorCacheSize})
+		if err != nil {
+			return err
+		}
+
+		go enricher.ServePersist(ctx, time.Minute)
+		r.bots = enricher
+	}
+
+	r.startWorkers()
+
+	served := make(chan error, 1)
+	go func() {
+		served <- eventFeed.Serve()
+	}()
+
+	for {
+		select {
+		case batch, ok := <-events:
+			if !ok {
+				events = nil
+				cancel()
+				continue
+			}
+			// Batches are queued in order, the feed waiting for the workers.
+			r.processBatch(ctx, batch)
+		case err := <-served:
+			if err != nil {
+				r.drain()
+				return err
+			}
+		case <-ctx.Done():
+			r.drain()
+			r.sessions.log()
+			r.reportArms(os.Stdout)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package loadgen
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+
+	feed "github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+// RequestMapper turns an event, its payload parsed, into the requests sent
+// to the target. All the
+// requests of an event are sent in order, with the cookie jar of the event's
+// actor. Load testing a new product only requires implementing a mapper and
+// registering it in an init function, its factory given the -target URLs.
+type RequestMapper interface {
+	Map(user string, event *feed.TypedEvent) ([]*http.Request, error)
+}
+
+var mappers = make(map[string]func(targets Targets) (RequestMapper, error))
+
+// RegisterMapper makes a mapper available to the -mapper flag.
+func RegisterMapper(name string, factory func(targets Targets) (RequestMapper, error)) {
+	if _, dup := mappers[name]; dup {
+		panic("duplicate request mapper " + name)
+	}
+	mappers[name] = factory
+}
+
+func mapperNames() string {
+	names := make([]string, 0, len(mappers))
+	for name := range mappers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newMapper(name string, targets Targets) (RequestMapper, error) {
+	factory, ok := mappers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown request mapper '%s', available: %s", name, mapperNames())
+	}
+	return factory(targets)
+}
+
+// identifyMapper posts the identifiers of the actor (login and hashed commit
+// emails by default, see -id-namespaces) to the actor's -target, encoded per
+// -content-type or rendered with -payload-template.
+type identifyMapper struct {
+	targets Targets
+	payload *template.Template
+}
+
+func newIdentifyMapper(targets Targets) (RequestMapper, error) {
+	m := &identifyMapper{targets: targets}
+	if *payloadTemplate == "" {
+		return m, nil
+	}
+
+	text := *payloadTemplate
+	if strings.HasPrefix(text, "@") {
+		b, err := ioutil.ReadFile(text[1:])
+		if err != nil {
+			return nil, fmt.Errorf("-payload-template: %v", err)
+		}
+		text = string(b)
+	}
+
+	var err error
+	if m.payload, err = template.New("payload").Funcs(scenarioFuncs).Parse(text); err != nil {
+		return nil, fmt.Errorf("-payload-template: %v", err)
+	}
+	return m, nil
+}
+
+func (m *identifyMapper) Map(user string, event *feed.TypedEvent) ([]*http.Request, error) {
+	ids := gatherIds(user, event)
+	if len(ids) < 1 {
+		return nil, nil
+	}
+
+	body, mime, err := m.body(user, ids, event)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", m.targets.For(user).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", mime)
+	feed.Identify(req)
+
+	return []*http.Request{req}, nil
+}
+
+func (m *identifyMapper) body(user string, ids []string, event *feed.TypedEvent) ([]byte, string, error) {
+	if m.payload == nil {
+		return encodeIds(ids)
+	}
+
+	var buf bytes.Buffer
+	err := m.payload.Execute(&buf, &scenarioContext{Event: event.Event, Payload: event.Payload, User: user, IDs: ids})
+	return buf.Bytes(), *payloadType, err
+}
+
+func init() {
+	RegisterMapper("identify", newIdentifyMapper)
+}
@@ -0,0 +1,88 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	feed "github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+)
+
+// Keys on which requests can be paced, mirroring how identify endpoints
+// throttle by session.
+var pacingKeys = map[string]func(e *github.Event) string{
+	"actor": func(e *github.Event) string { return strings.ToLower(e.GetActor().GetLogin()) },
+	"repo":  func(e *github.Event) string { return strings.ToLower(e.GetRepo().GetName()) },
+}
+
+type keyedPacer struct {
+	key     func(e *github.Event) string
+	limiter *feed.KeyedLimiter
+}
+
+// perKeyRates implements flag.Value for `-per-key-rate actor=5/s,repo=1/m`.
+type perKeyRates []keyedPacer
+
+func (r *perKeyRates) String() string {
+	return ""
+}
+
+var rateUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}
+
+// parseRate parses rates like `5/s`, `30/m` or `2.5` (per second).
+func parseRate(s string) (float64, error) {
+	count, unit := s, "s"
+	if i := strings.Index(s, "/"); i >= 0 {
+		count, unit = s[:i], s[i+1:]
+	}
+
+	period, ok := rateUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid rate unit '%s', expected s, m or h", unit)
+	}
+
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate '%s'", s)
+	}
+
+	return n / period.Seconds(), nil
+}
+
+func (r *perKeyRates) Set(value string) error {
+	for _, spec := range strings.Split(value, ",") {
+		i := strings.Index(spec, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid per key rate '%s', expected key=rate", spec)
+		}
+
+		key, ok := pacingKeys[spec[:i]]
+		if !ok {
+			return fmt.Errorf("invalid pacing key '%s', expected actor or repo", spec[:i])
+		}
+
+		rate, err := parseRate(spec[i+1:])
+		if err != nil {
+			return err
+		}
+
+		*r = append(*r, keyedPacer{key: key, limiter: feed.NewKeyedLimiter(rate, 1)})
+	}
+
+	return nil
+}
+
+func (r *perKeyRates) List() bool { return true }
+
+// wait blocks until every keyed limiter allows the event's request.
+func (r perKeyRates) wait(ctx context.Context, e *github.Event) error {
+	for _, pacer := range r {
+		if err := pacer.limiter.Wait(ctx, pacer.key(e)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A run goes through three phases: ramp (the warm-up, excluded from the
+// results), steady state, and drain (in-flight requests completing after the
+// run was stopped).
+type phase int
+
+const (
+	phaseRamp phase = iota
+	phaseSteady
+	phaseDrain
+	numPhases
+)
+
+var phaseNames = [numPhases]string{"ramp", "steady", "drain"}
+
+// Percentiles are computed over a uniform sample of the latencies to bound
+// memory during long runs.
+const latencyReservoirSize = 100000
+
+type phaseStats struct {
+	mu       sync.Mutex
+	start    time.Time
+	end      time.Time
+	requests int
+	errors   int
+	seen     int
+	samples  []time.Duration
+}
+
+func (p *phaseStats) record(now time.Time, latency time.Duration, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.requests == 0 {
+		p.start = now
+	}
+	p.end = now
+	p.requests++
+	if failed {
+		p.errors++
+	}
+
+	// Reservoir sampling (algorithm R).
+	p.seen++
+	if len(p.samples) < latencyReservoirSize {
+		p.samples = append(p.samples, latency)
+	} else if i := rand.Intn(p.seen); i < latencyReservoirSize {
+		p.samples[i] = latency
+	}
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+func (p *phaseStats) summary(name string, w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.requests == 0 {
+		fmt.Fprintf(w, "%-7s no requests\n", name)
+		return
+	}
+
+	sorted := append([]time.Duration(nil), p.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	elapsed := p.end.Sub(p.start).Seconds()
+	rate := float64(p.requests)
+	if elapsed > 0 {
+		rate /= elapsed
+	}
+
+	fmt.Fprintf(w, "%-7s %8d requests %6.2f%% errors %8.1f req/s  p50 %v  p90 %v  p99 %v  max %v\n",
+		name, p.requests, 100*float64(p.errors)/float64(p.requests), rate,
+		percentile(sorted, 0.5), percentile(sorted, 0.9), percentile(sorted, 0.99),
+		percentile(sorted, 1))
+}
+
+// moments returns the sample size, mean and variance (in seconds) of the
+// latency samples.
+func (p *phaseStats) moments() (n int, mean, variance float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n = len(p.samples)
+	if n == 0 {
+		return 0, 0, 0
+	}
+
+	for _, s := range p.samples {
+		mean += s.Seconds()
+	}
+	mean /= float64(n)
+
+	for _, s := range p.samples {
+		d := s.Seconds() - mean
+		variance += d * d
+	}
+	if n > 1 {
+		variance /= float64(n - 1)
+	}
+
+	return n, mean, variance
+}
+
+func (p *phaseStats) errorRate() (requests int, rate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.requests == 0 {
+		return 0, 0
+	}
+	return p.requests, float64(p.errors) / float64(p.requests)
+}
+
+type runStats struct {
+	start    time.Time
+	warmup   time.Duration
+	draining int32
+	phases   [numPhases]phaseStats
+
+	// The current window of the health monitor.
+	mu     sync.Mutex
+	window *phaseStats
+}
+
+func newRunStats(warmup time.Duration) *runStats {
+	return &runStats{start: time.Now(), warmup: warmup, window: &phaseStats{}}
+}
+
+// rotateWindow starts a new window and returns the previous one.
+func (r *runStats) rotateWindow() *phaseStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := r.window
+	r.window = &phaseStats{}
+	return previous
+}
+
+func (r *runStats) phase(now time.Time) phase {
+	switch {
+	case atomic.LoadInt32(&r.draining) != 0:
+		return phaseDrain
+	case now.Sub(r.start) < r.warmup:
+		return phaseRamp
+	default:
+		return phaseSteady
+	}
+}
+
+func (r *runStats) record(latency time.Duration, failed bool) {
+	now := time.Now()
+	r.phases[r.phase(now)].record(now, latency, failed)
+
+	r.mu.Lock()
+	window := r.window
+	r.mu.Unlock()
+	window.record(now, latency, failed)
+}
+
+func (r *runStats) drain() {
+	atomic.StoreInt32(&r.draining, 1)
+}
+
+// report writes the per-phase summary, only the steady state should be used
+// to compare runs.
+func (r *runStats) report(w io.Writer) {
+	fmt.Fprintf(w, "Run of %v (warm-up %v):\n", time.Since(r.start).Round(time.Second), r.warmup)
+	for i := range r.phases {
+		r.phases[i].summary(phaseNames[i], w)
+	}
+}
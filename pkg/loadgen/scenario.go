@@ -0,0 +1,331 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	feed "github.com/fsaintjacques/github-feed/pkg/lib"
+	"github.com/google/go-github/v32/github"
+	"gopkg.in/yaml.v2"
+)
+
+// A Scenario is a sequence of requests executed for each event, expressed in
+// YAML, e.g.
+//
+//	name: identify-then-browse
+//	steps:
+//	  - name: identify
+//	    method: POST
+//	    url: https://target/identify
+//	    headers: {Content-Type: application/json}
+//	    body: '{{ json .IDs }}'
+//	    expect_status: 200
+//	    extract:
+//	      visitor: json:visitor.id
+//	  - name: browse
+//	    when: type=PushEvent,PullRequestEvent
+//	    require: [visitor]
+//	    delay: 500ms
+//	    url: 'https://target/page?visitor={{ .Vars.visitor }}'
+//
+// The url, headers and body are Go templates evaluated with a
+// scenarioContext. `when` is a filter expression on the event, `require`
+// lists variables which must have been extracted by previous steps, and
+// `extract` captures variables from the response with one of
+// `header:Name`, `cookie:Name`, `json:dot.path` or `regex:expr` (first
+// submatch of the body).
+type Scenario struct {
+	Name  string          `yaml:"name"`
+	Steps []*ScenarioStep `yaml:"steps"`
+}
+
+type ScenarioStep struct {
+	Name         string            `yaml:"name"`
+	Method       string            `yaml:"method"`
+	URL          string            `yaml:"url"`
+	Headers      map[string]string `yaml:"headers"`
+	Body         string            `yaml:"body"`
+	When         string            `yaml:"when"`
+	Require      []string          `yaml:"require"`
+	Delay        time.Duration     `yaml:"delay"`
+	ExpectStatus int               `yaml:"expect_status"`
+	Extract      map[string]string `yaml:"extract"`
+
+	when    *feed.Filter
+	url     *template.Template
+	body    *template.Template
+	headers map[string]*template.Template
+	regexps map[string]*regexp.Regexp
+}
+
+// scenarioContext is the data available to the step templates.
+type scenarioContext struct {
+	Event *github.Event
+	// Parsed payload of the event, e.g. a *github.PushEvent.
+	Payload interface{}
+	User    string
+	IDs     []string
+	Vars    map[string]string
+}
+
+var scenarioFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"lower": strings.ToLower,
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Scenario
+	if err := yaml.UnmarshalStrict(b, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if len(s.Steps) == 0 {
+		return nil, fmt.Errorf("%s: scenario has no steps", path)
+	}
+
+	for i, step := range s.Steps {
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step-%d", i+1)
+		}
+
+		if err := step.compile(); err != nil {
+			return nil, fmt.Errorf("%s: step '%s': %v", path, step.Name, err)
+		}
+	}
+
+	return &s, nil
+}
+
+func (s *ScenarioStep) compile() (err error) {
+	if s.Method == "" {
+		s.Method = http.MethodGet
+	}
+
+	if s.URL == "" {
+		return fmt.Errorf("missing url")
+	}
+
+	if s.when, err = feed.ParseFilter(s.When); err != nil {
+		return err
+	}
+
+	if s.url, err = template.New("url").Funcs(scenarioFuncs).Parse(s.URL); err != nil {
+		return err
+	}
+
+	if s.body, err = template.New("body").Funcs(scenarioFuncs).Parse(s.Body); err != nil {
+		return err
+	}
+
+	s.headers = make(map[string]*template.Template, len(s.Headers))
+	for name, value := range s.Headers {
+		if s.headers[name], err = template.New(name).Funcs(scenarioFuncs).Parse(value); err != nil {
+			return err
+		}
+	}
+
+	s.regexps = make(map[string]*regexp.Regexp)
+	for name, spec := range s.Extract {
+		i := strings.Index(spec, ":")
+		if i < 0 {
+			return fmt.Errorf("extract '%s': expected source:argument", name)
+		}
+
+		switch spec[:i] {
+		case "header", "cookie", "json":
+		case "regex":
+			if s.regexps[name], err = regexp.Compile(spec[i+1:]); err != nil {
+				return fmt.Errorf("extract '%s': %v", name, err)
+			}
+		default:
+			return fmt.Errorf("extract '%s': unknown source '%s'", name, spec[:i])
+		}
+	}
+
+	return nil
+}
+
+func render(t *template.Template, ctx *scenarioContext) (string, error) {
+	var buf bytes.Buffer
+	err := t.Execute(&buf, ctx)
+	return buf.String(), err
+}
+
+// lookupJSON follows a dot separated path (object keys or array indices) in
+// a decoded JSON document.
+func lookupJSON(body []byte, path string) (string, bool) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", false
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			var i int
+			if _, err := fmt.Sscanf(key, "%d", &i); err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			v = node[i]
+		default:
+			return "", false
+		}
+	}
+
+	if v == nil {
+		return "", false
+	} else if s, ok := v.(string); ok {
+		return s, true
+	}
+	return fmt.Sprint(v), true
+}
+
+func (s *ScenarioStep) extract(rep *http.Response, body []byte, vars map[string]string) {
+	for name, spec := range s.Extract {
+		i := strings.Index(spec, ":")
+		source, arg := spec[:i], spec[i+1:]
+
+		var value string
+		var ok bool
+		switch source {
+		case "header":
+			value = rep.Header.Get(arg)
+			ok = value != ""
+		case "cookie":
+			for _, c := range rep.Cookies() {
+				if c.Name == arg {
+					value, ok = c.Value, true
+				}
+			}
+		case "json":
+			value, ok = lookupJSON(body, arg)
+		case "regex":
+			if m := s.regexps[name].FindSubmatch(body); len(m) > 1 {
+				value, ok = string(m[1]), true
+			}
+		}
+
+		if ok {
+			vars[name] = value
+		}
+	}
+}
+
+func (s *ScenarioStep) runnable(ctx *scenarioContext) bool {
+	if !s.when.Match(ctx.Event) {
+		return false
+	}
+
+	for _, name := range s.Require {
+		if _, ok := ctx.Vars[name]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *ScenarioStep) newRequest(ctx *scenarioContext) (*http.Request, error) {
+	u, err := render(s.url, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := render(s.body, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(s.Method, u, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	feed.Identify(req)
+	for name, t := range s.headers {
+		value, err := render(t, ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, value)
+	}
+
+	return req, nil
+}
+
+// run executes the step, returning false if the scenario must stop.
+func (s *ScenarioStep) run(r *Runner, c *http.Client, a *arm, ctx *scenarioContext) bool {
+	if s.Delay > 0 {
+		time.Sleep(s.Delay)
+	}
+
+	req, err := s.newRequest(ctx)
+	if err != nil {
+		log.Printf("Scenario step '%s': %v", s.Name, err)
+		return false
+	}
+
+	a.rewrite(req)
+	if err := compressBody(req); err != nil {
+		log.Printf("Scenario step '%s': %v", s.Name, err)
+		return false
+	}
+
+	sent := c.Jar.Cookies(req.URL)
+
+	rep, latency, err := r.do(context.Background(), c, req)
+	if err != nil {
+		a.stats.record(latency, true)
+		log.Printf("Scenario step '%s': %v", s.Name, err)
+		return false
+	}
+	defer rep.Body.Close()
+
+	body, _ := ioutil.ReadAll(rep.Body)
+
+	a.stats.record(latency, rep.StatusCode >= 400 || (s.ExpectStatus != 0 && rep.StatusCode != s.ExpectStatus))
+	r.sessions.check(sent, rep)
+
+	if s.ExpectStatus != 0 && rep.StatusCode != s.ExpectStatus {
+		log.Printf("Scenario step '%s': expected status %d, got %s: %s", s.Name, s.ExpectStatus, rep.Status, body)
+		return false
+	}
+
+	s.extract(rep, body, ctx.Vars)
+	return true
+}
+
+// run plays the scenario for an event with the user's client against the
+// arm's target.
+func (s *Scenario) run(r *Runner, c *http.Client, a *arm, user string, ids []string, event *feed.TypedEvent) {
+	ctx := &scenarioContext{Event: event.Event, Payload: event.Payload, User: user, IDs: ids, Vars: make(map[string]string)}
+
+	for _, step := range s.Steps {
+		if !step.runnable(ctx) {
+			continue
+		}
+
+		if !step.run(r, c, a, ctx) {
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package loadgen
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+var expectCookies = stringList("expect-cookies", "",
+	"Comma separated cookies the identify endpoint must set, and which must be reused by subsequent "+
+		"requests of the same user. Violations are counted in the session correctness metric.")
+
+// sessionStats counts how requests relate to the per-user sessions.
+type sessionStats struct {
+	requests uint64
+	// Requests which started a session, e.g. the first for a user.
+	started uint64
+	// Requests reusing the cookies of a previous response.
+	reused uint64
+	// Requests after which an expected cookie was still missing.
+	missing uint64
+	// Requests for which the endpoint replaced an expected cookie the client
+	// already had, i.e. the session was not recognized.
+	resets uint64
+}
+
+func expectedCookies() []string {
+	if *expectCookies == "" {
+		return nil
+	}
+	return strings.Split(*expectCookies, ",")
+}
+
+func cookieValues(cookies []*http.Cookie) map[string]string {
+	values := make(map[string]string, len(cookies))
+	for _, c := range cookies {
+		values[c.Name] = c.Value
+	}
+	return values
+}
+
+// check compares the cookies sent with a request with the ones set by its
+// response.
+func (s *sessionStats) check(sent []*http.Cookie, rep *http.Response) {
+	atomic.AddUint64(&s.requests, 1)
+
+	if len(sent) == 0 {
+		atomic.AddUint64(&s.started, 1)
+	} else {
+		atomic.AddUint64(&s.reused, 1)
+	}
+
+	before, set := cookieValues(sent), cookieValues(rep.Cookies())
+	for _, name := range expectedCookies() {
+		old, had := before[name]
+		updated, got := set[name]
+
+		switch {
+		case !had && !got:
+			atomic.AddUint64(&s.missing, 1)
+			return
+		case had && got && old != updated:
+			atomic.AddUint64(&s.resets, 1)
+			return
+		}
+	}
+}
+
+// sessionCorrectness is the fraction of requests which kept their session
+// according to the expected cookies.
+func (s *sessionStats) correctness() float64 {
+	requests := atomic.LoadUint64(&s.requests)
+	if requests == 0 {
+		return 1
+	}
+
+	violations := atomic.LoadUint64(&s.missing) + atomic.LoadUint64(&s.resets)
+	return 1 - float64(violations)/float64(requests)
+}
+
+func (s *sessionStats) log() {
+	if *expectCookies == "" {
+		return
+	}
+
+	log.Printf("Sessions: %d requests, %d started, %d reused, %d missing cookies, %d resets, correctness %.2f%%",
+		atomic.LoadUint64(&s.requests), atomic.LoadUint64(&s.started),
+		atomic.LoadUint64(&s.reused), atomic.LoadUint64(&s.missing),
+		atomic.LoadUint64(&s.resets), s.correctness()*100)
+}
@@ -0,0 +1,73 @@
+package loadgen
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var sourceIPs = stringList("source-ips", "",
+	"Comma separated local addresses (IPv4 or IPv6) outgoing connections are bound to, assigned round-robin to the users.")
+
+// sourceTransports holds a transport per local address, each user sticks to
+// the address it was assigned to so that the target sees a consistent
+// client IP.
+type sourceTransports struct {
+	mu         sync.Mutex
+	transports []*http.Transport
+	users      map[string]http.RoundTripper
+	next       int
+}
+
+func newSourceTransport(ip net.IP) *http.Transport {
+	dialer := &net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: ip},
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = dialer.DialContext
+	return t
+}
+
+func (s *sourceTransports) setup() error {
+	s.transports, s.users, s.next = nil, make(map[string]http.RoundTripper), 0
+	if *sourceIPs == "" {
+		return nil
+	}
+
+	for _, addr := range strings.Split(*sourceIPs, ",") {
+		addr = strings.TrimSpace(addr)
+		ip := net.ParseIP(strings.Trim(addr, "[]"))
+		if ip == nil {
+			return fmt.Errorf("-source-ips: invalid address '%s'", addr)
+		}
+		s.transports = append(s.transports, newSourceTransport(ip))
+	}
+
+	return nil
+}
+
+// transportFor returns the transport of the user, nil (the default transport)
+// when no source addresses are configured.
+func (s *sourceTransports) transportFor(user string) http.RoundTripper {
+	if len(s.transports) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, found := s.users[user]
+	if !found {
+		t = s.transports[s.next%len(s.transports)]
+		s.next++
+		s.users[user] = t
+	}
+
+	return t
+}
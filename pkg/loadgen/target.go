@@ -0,0 +1,128 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	feed "github.com/fsaintjacques/github-feed/pkg/lib"
+)
+
+const defaultTargetURL = "https://staging1.cloud-dev.optable.co/my-super-site/identify?cookies=yes"
+
+var (
+	targetURLs = stringList("target", defaultTargetURL,
+		"Comma separated URLs of the identify endpoints, each actor (and its session) sticks to one of them.")
+	targetQPS = Flags.Float64("qps", 5,
+		"Requests per second sent to the targets, retries included, shared by every worker and batch; 0 is unlimited.")
+	qpsBurst = Flags.Int("qps-burst", 1, "Requests sent at once beyond -qps after an idle period.")
+	workers  = Flags.Int("workers", 64,
+		"Number of events processed concurrently, the feed is consumed no faster than the workers process it.")
+	targetTimeout = Flags.Duration("target-timeout", 30*time.Second, "Timeout of each request to the targets.")
+	retries       = Flags.Int("retries", 0,
+		"Retries of the requests failing with a connection error, a timeout, 429 or 5xx.")
+	retryBackoff = Flags.Duration("retry-backoff", 100*time.Millisecond,
+		"Backoff before the first retry of a request, doubled on every retry.")
+	payloadTemplate = Flags.String("payload-template", "",
+		"Go template of the identify request body replacing the -content-type encoding, e.g. '{\"ids\": {{ json .IDs }}}', "+
+			"or @file; evaluated with .Event, .Payload, .User and .IDs like the -scenario templates.")
+	payloadType = Flags.String("payload-content-type", "application/json", "Content-Type of the -payload-template bodies.")
+)
+
+// Targets are the -target URLs, each user sticking to one of them.
+type Targets []*url.URL
+
+// For returns the target of a user, the same for all its requests so that
+// its session lives on one target.
+func (t Targets) For(user string) *url.URL {
+	if len(t) == 1 {
+		return t[0]
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(user))
+	return t[h.Sum32()%uint32(len(t))]
+}
+
+// setupTargets parses the target flags.
+func (r *Runner) setupTargets() error {
+	var targets Targets
+	for _, raw := range strings.Split(*targetURLs, ",") {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid -target '%s', expected an absolute URL", raw)
+		}
+		targets = append(targets, u)
+	}
+
+	if *targetQPS < 0 {
+		return fmt.Errorf("invalid -qps %g, expected a positive rate or 0", *targetQPS)
+	}
+
+	if *workers < 1 {
+		return fmt.Errorf("invalid -workers %d, expected at least 1", *workers)
+	}
+	if *retries < 0 {
+		return fmt.Errorf("invalid -retries %d", *retries)
+	}
+
+	r.targets, r.limiter = targets, nil
+	if *targetQPS > 0 {
+		r.limiter = feed.NewLimiter(*targetQPS, *qpsBurst)
+	}
+	return nil
+}
+
+// retryable tells whether a failed request may succeed if sent again.
+func retryable(rep *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return rep.StatusCode == http.StatusTooManyRequests || rep.StatusCode >= 500
+}
+
+// do sends a request within -qps, retrying it as configured, and returns the
+// response of the last attempt and its latency. Bodies are replayed with
+// GetBody, which http.NewRequest and compressBody set.
+func (r *Runner) do(ctx context.Context, c *http.Client, req *http.Request) (*http.Response, time.Duration, error) {
+	backoff := *retryBackoff
+	for attempt := 0; ; attempt++ {
+		if r.limiter != nil {
+			if err := r.limiter.Wait(ctx); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, 0, err
+			}
+			req.Body = body
+		}
+
+		start := time.Now()
+		rep, err := c.Do(req)
+		latency := time.Since(start)
+
+		if attempt >= *retries || !retryable(rep, err) || (req.Body != nil && req.GetBody == nil) {
+			return rep, latency, err
+		}
+		if err == nil {
+			ioutil.ReadAll(rep.Body)
+			rep.Body.Close()
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
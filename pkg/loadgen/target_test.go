@@ -0,0 +1,202 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// setFlags sets the flags of a test, returning a function restoring them.
+func setFlags(t *testing.T, values map[string]string) func() {
+	old := make(map[string]string, len(values))
+	for name, value := range values {
+		old[name] = Flags.Lookup(name).Value.String()
+		if err := Flags.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for name, value := range old {
+			Flags.Set(name, value)
+		}
+	}
+}
+
+func TestSetupTargets(t *testing.T) {
+	defer setFlags(t, map[string]string{"target": "https://a/identify, https://b/identify", "qps": "0"})()
+
+	r := &Runner{}
+	for i := 0; i < 2; i++ {
+		if err := r.setupTargets(); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.targets) != 2 || r.targets[1].Host != "b" {
+			t.Fatalf("got targets %v, want https://a/identify and https://b/identify", r.targets)
+		}
+		if r.limiter != nil {
+			t.Error("limited the requests with -qps 0")
+		}
+	}
+
+	for _, c := range []struct {
+		flag, value, want string
+	}{
+		{"target", "https://a/identify,/identify", "invalid -target '/identify', expected an absolute URL"},
+		{"target", "a/identify", "invalid -target 'a/identify', expected an absolute URL"},
+		{"qps", "-1", "invalid -qps -1, expected a positive rate or 0"},
+		{"workers", "0", "invalid -workers 0, expected at least 1"},
+		{"retries", "-1", "invalid -retries -1"},
+	} {
+		restore := setFlags(t, map[string]string{c.flag: c.value})
+		err := (&Runner{}).setupTargets()
+		restore()
+		if err == nil || err.Error() != c.want {
+			t.Errorf("-%s %s: got error %v, want %s", c.flag, c.value, err, c.want)
+		}
+	}
+}
+
+func TestTargetsFor(t *testing.T) {
+	defer setFlags(t, map[string]string{"target": "https://a/,https://b/,https://c/"})()
+
+	r := &Runner{}
+	if err := r.setupTargets(); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		user := fmt.Sprintf("user%d", i)
+		target := r.targets.For(user)
+		if again := r.targets.For(user); again != target {
+			t.Errorf("%s moved from %s to %s", user, target, again)
+		}
+		hosts[target.Host] = true
+	}
+	if len(hosts) != 3 {
+		t.Errorf("users were sent to %d targets, want 3", len(hosts))
+	}
+
+	single := Targets{r.targets[0]}
+	if got := single.For("user0"); got != r.targets[0] {
+		t.Errorf("got %s with a single target, want %s", got, r.targets[0])
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, c := range []struct {
+		status int
+		err    error
+		want   bool
+	}{
+		{0, errors.New("connection refused"), true},
+		{http.StatusOK, nil, false},
+		{http.StatusBadRequest, nil, false},
+		{http.StatusNotFound, nil, false},
+		{http.StatusTooManyRequests, nil, true},
+		{http.StatusInternalServerError, nil, true},
+		{http.StatusServiceUnavailable, nil, true},
+	} {
+		var rep *http.Response
+		if c.err == nil {
+			rep = &http.Response{StatusCode: c.status}
+		}
+		if got := retryable(rep, c.err); got != c.want {
+			t.Errorf("retryable(%d, %v) = %v, want %v", c.status, c.err, got, c.want)
+		}
+	}
+}
+
+// flakyServer fails the first requests with 503, recording the bodies.
+type flakyServer struct {
+	mu     sync.Mutex
+	fail   int
+	bodies []string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies = append(s.bodies, string(body))
+	if len(s.bodies) <= s.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func TestDoRetries(t *testing.T) {
+	defer setFlags(t, map[string]string{"retries": "2", "retry-backoff": "1ms", "gzip": "true"})()
+
+	for _, c := range []struct {
+		fail     int
+		status   int
+		attempts int
+	}{
+		{0, http.StatusOK, 1},
+		{2, http.StatusOK, 3},
+		{3, http.StatusServiceUnavailable, 3},
+	} {
+		s := &flakyServer{fail: c.fail}
+		srv := httptest.NewServer(s)
+
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`["c:octocat"]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The compressed body is replayed with the GetBody of compressBody.
+		if err := compressBody(req); err != nil {
+			t.Fatal(err)
+		}
+		compressed, _ := req.GetBody()
+		want, _ := ioutil.ReadAll(compressed)
+
+		rep, _, err := (&Runner{}).do(context.Background(), srv.Client(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rep.Body.Close()
+		srv.Close()
+
+		if rep.StatusCode != c.status {
+			t.Errorf("failing %d times: got %s, want %d", c.fail, rep.Status, c.status)
+		}
+		if len(s.bodies) != c.attempts {
+			t.Errorf("failing %d times: sent %d requests, want %d", c.fail, len(s.bodies), c.attempts)
+		}
+		for i, body := range s.bodies {
+			if body != string(want) {
+				t.Errorf("failing %d times: attempt %d sent %q, want %q", c.fail, i+1, body, want)
+			}
+		}
+	}
+}
+
+func TestDoWithoutGetBody(t *testing.T) {
+	defer setFlags(t, map[string]string{"retries": "2", "retry-backoff": "1ms"})()
+
+	s := &flakyServer{fail: 1}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	// A body which can't be replayed isn't retried.
+	req, err := http.NewRequest(http.MethodPost, srv.URL, ioutil.NopCloser(strings.NewReader("ids")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep, _, err := (&Runner{}).do(context.Background(), srv.Client(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep.Body.Close()
+
+	if rep.StatusCode != http.StatusServiceUnavailable || len(s.bodies) != 1 {
+		t.Errorf("got %s after %d requests, want 503 after 1", rep.Status, len(s.bodies))
+	}
+}